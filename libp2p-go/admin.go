@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newIssueTokenCommand signs an authorization token offline
+func newIssueTokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "issue-token <subject-peer> <audience-peer>",
+		Short: "Issue a token that lets a peer use another node's private protocols",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runIssueToken,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the issuer")
+	cmd.Flags().StringArray("scope", nil, "Scope granted by the token")
+	cmd.Flags().Duration("ttl", 24*time.Hour, "How long the token is valid")
+	cmd.MarkFlagRequired("identity")
+	return cmd
+}
+
+func runIssueToken(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	var ids []peer.ID
+	for _, ref := range args {
+		id, err := contacts.ResolveID(ref)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	identityFile, _ := cmd.Flags().GetString("identity")
+	key, err := libp2plearn.LoadIdentity(identityFile)
+	if err != nil {
+		return err
+	}
+	scopes, _ := cmd.Flags().GetStringArray("scope")
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+
+	now := time.Now()
+	claims := libp2plearn.TokenClaims{
+		Subject:  ids[0].String(),
+		Audience: ids[1].String(),
+		Scopes:   scopes,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(ttl).Unix(),
+	}
+	token, err := libp2plearn.IssueToken(key, claims)
+	if err != nil {
+		return err
+	}
+	result := issuedToken{
+		Token:    token,
+		Subject:  claims.Subject,
+		Audience: claims.Audience,
+		Scopes:   append([]string{}, scopes...),
+		Expiry:   time.Unix(claims.Expiry, 0).UTC(),
+	}
+	return printOutput(cmd, result, func() { fmt.Println(result.Token) })
+}
+
+// newAuditCommand works with audit logs written by --audit-log
+func newAuditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Work with audit logs",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "verify <file>...",
+		Short: "Check the hash chain of audit log files, oldest first",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runAuditVerify,
+	})
+	return cmd
+}
+
+func runAuditVerify(cmd *cobra.Command, args []string) error {
+	count, err := libp2plearn.VerifyAuditLog(args...)
+	if err != nil {
+		return fmt.Errorf("audit log verification failed after %d entries: %w", count, err)
+	}
+	result := auditVerifyResult{Files: args, Entries: count}
+	return printOutput(cmd, result, func() { fmt.Printf("✓ %d entries verified\n", result.Entries) })
+}
+
+// newAdminCommand runs one admin command on a remote node and prints the result
+func newAdminCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin <peer> <command> [args...]",
+		Short: "Run a remote admin command (peers, connect, disconnect, stats, log_level, log_levels, latency, transports, streams, stream_reset, close_transport, maintenance, pin_ls, pin_add, pin_rm, repo_gc, nat_status, protocols, protocol_unregister, protocol_register)",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  runAdmin,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runAdmin(cmd *cobra.Command, args []string) error {
+	return printAdminCommand(cmd, args[0], args[1], args[2:]...)
+}
+
+// newPinCommand manages the pinned blobs of a remote node
+func newPinCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pin",
+		Short: "Manage the pinned blobs of a remote node",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls <peer>",
+		Short: "List pinned blobs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdPinLs)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <peer> <cid>",
+		Short: "Pin a blob, fetching it first if the node doesn't have it",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdPinAdd, args[1])
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm <peer> <cid>",
+		Short: "Unpin a blob so garbage collection may remove it",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdPinRm, args[1])
+		},
+	})
+	cmd.PersistentFlags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.PersistentFlags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+// newRepoCommand maintains the blob store of a remote node
+func newRepoCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Maintain the blob store of a remote node",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "gc <peer>",
+		Short: "Remove every unpinned blob block",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdRepoGC)
+		},
+	})
+	cmd.PersistentFlags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.PersistentFlags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+// newNATStatusCommand shows how a remote node sees its NAT situation
+func newNATStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "nat-status <peer>",
+		Short: "Show a remote node's reachability and the confidence in each address peers observe it at",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdNATStatus)
+		},
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+// newPeersCommand lists the peers a remote node is connected to
+func newPeersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peers <peer>",
+		Short: "List the peers a remote node is connected to",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runPeers,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runPeers(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	var peers []libp2plearn.AdminPeer
+	if err := runAdminQuery(cmd, args[0], &peers, libp2plearn.AdminCmdPeers); err != nil {
+		return err
+	}
+	if peers == nil {
+		peers = []libp2plearn.AdminPeer{}
+	}
+	for i := range peers {
+		if peers[i].Addrs == nil {
+			peers[i].Addrs = []string{}
+		}
+		if peers[i].Paths == nil {
+			peers[i].Paths = []libp2plearn.ConnPath{}
+		}
+	}
+	return printOutput(cmd, peers, func() {
+		for _, p := range peers {
+			fmt.Printf("%s, %d connections\n", contactLabel(contacts, p.ID), p.Connections)
+			for _, path := range p.Paths {
+				fmt.Printf("  %s (%s)\n", path.Addr, connPathLabel(contacts, path))
+			}
+		}
+		fmt.Printf("%d peers\n", len(peers))
+	})
+}
+
+// newStatsCommand shows the statistics of a remote node
+func newStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats <peer>",
+		Short: "Show a remote node's peers, connections, streams and uptime",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runStats,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	var stats libp2plearn.AdminStats
+	if err := runAdminQuery(cmd, args[0], &stats, libp2plearn.AdminCmdStats); err != nil {
+		return err
+	}
+	if stats.Addrs == nil {
+		stats.Addrs = []string{}
+	}
+	if stats.Protocols == nil {
+		stats.Protocols = []string{}
+	}
+	if stats.Reservations == nil {
+		stats.Reservations = []libp2plearn.RelayReservation{}
+	}
+	return printOutput(cmd, stats, func() {
+		fmt.Printf("Peer:        %s\n", stats.PeerID)
+		fmt.Printf("Uptime:      %s\n", stats.Uptime)
+		fmt.Printf("Peers:       %d (%d connections, %d relayed, %d streams)\n", stats.Peers, stats.Connections, stats.Relayed, stats.Streams)
+		fmt.Printf("Log level:   %s\n", stats.LogLevel)
+		fmt.Printf("DHT mode:    %s\n", stats.DHTMode)
+		fmt.Println("Addresses:")
+		for _, addr := range stats.Addrs {
+			fmt.Printf("  %s\n", addr)
+		}
+		fmt.Println("Protocols:")
+		for _, proto := range stats.Protocols {
+			fmt.Printf("  %s\n", proto)
+		}
+		if len(stats.Reservations) > 0 {
+			fmt.Println("Relay reservations:")
+		}
+		for _, res := range stats.Reservations {
+			switch {
+			case res.Error != "":
+				fmt.Printf("  %s: failed: %s\n", res.Relay, res.Error)
+			case time.Until(res.Expires) <= 0:
+				fmt.Printf("  %s: expired\n", res.Relay)
+			default:
+				fmt.Printf("  %s: expires in %s\n", res.Relay, time.Until(res.Expires).Round(time.Second))
+			}
+		}
+		if len(stats.Quotas) > 0 {
+			fmt.Println("Traffic quotas:")
+		}
+		for _, q := range stats.Quotas {
+			const mib = 1 << 20
+			limit := "no cap"
+			if q.Limit > 0 {
+				limit = fmt.Sprintf("of %.1f MiB", float64(q.Limit)/mib)
+			}
+			state := ""
+			if q.Exceeded {
+				state = ", exceeded"
+			}
+			fmt.Printf("  %s: %.1f MiB %s this %s%s, resets in %s\n", q.Name, float64(q.Used)/mib, limit, q.Period, state, time.Until(q.Resets).Round(time.Minute))
+		}
+	})
+}
+
+// connPathLabel describes whether a connection is direct or relayed, and
+// through which relay
+func connPathLabel(contacts *libp2plearn.AddressBook, path libp2plearn.ConnPath) string {
+	parts := []string{"direct"}
+	if path.Relayed {
+		parts[0] = "relayed"
+		if path.Relay != "" {
+			parts[0] = "relayed via " + contactLabel(contacts, path.Relay)
+		}
+	}
+	parts = append(parts, path.Direction)
+	if path.Limited {
+		parts = append(parts, "limited")
+	}
+	if path.ReservationExpiry != nil {
+		parts = append(parts, fmt.Sprintf("reservation expires in %s", time.Until(*path.ReservationExpiry).Round(time.Second)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newLatencyCommand shows the round-trip percentiles a remote node measured
+func newLatencyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "latency <peer> [peer]",
+		Short: "Show the round-trip percentiles and jitter a remote node measured to each peer, or to one",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  runLatency,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runLatency(cmd *cobra.Command, args []string) error {
+	var filter []string
+	if len(args) > 1 {
+		contacts, err := loadContacts(cmd)
+		if err != nil {
+			return err
+		}
+		id, err := contacts.ResolveID(args[1])
+		if err != nil {
+			return err
+		}
+		filter = append(filter, id.String())
+	}
+	var stats []libp2plearn.LatencyStats
+	if err := runAdminQuery(cmd, args[0], &stats, libp2plearn.AdminCmdLatency, filter...); err != nil {
+		return err
+	}
+	if stats == nil {
+		stats = []libp2plearn.LatencyStats{}
+	}
+	return printOutput(cmd, stats, func() {
+		ms := func(d libp2plearn.Duration) string {
+			return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+		}
+		fmt.Printf("%-14s %7s %9s %9s %9s %9s %9s\n", "PEER", "SAMPLES", "P50", "P95", "P99", "MAX", "JITTER")
+		for _, s := range stats {
+			fmt.Printf("%-14s %7d %9s %9s %9s %9s %9s\n", "…"+s.Peer[max(len(s.Peer)-12, 0):], s.Samples,
+				ms(s.P50), ms(s.P95), ms(s.P99), ms(s.Max), ms(s.Jitter))
+		}
+	})
+}
+
+// newTransportsCommand shows how each transport performed for a remote node
+func newTransportsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "transports <peer>",
+		Short: "Show the connect times and round trips a remote node measured per transport and address",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runTransports,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runTransports(cmd *cobra.Command, args []string) error {
+	var estimates []libp2plearn.TransportEstimate
+	if err := runAdminQuery(cmd, args[0], &estimates, libp2plearn.AdminCmdTransports); err != nil {
+		return err
+	}
+	if estimates == nil {
+		estimates = []libp2plearn.TransportEstimate{}
+	}
+	return printOutput(cmd, estimates, func() {
+		ms := func(d libp2plearn.Duration) string {
+			return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+		}
+		fmt.Printf("%-13s %8s %9s %5s %9s  %s\n", "TRANSPORT", "CONNECTS", "CONNECT", "RTTS", "RTT", "ADDRESS")
+		for _, e := range estimates {
+			addr := e.Addr
+			if addr == "" {
+				addr = "(all)"
+			}
+			fmt.Printf("%-13s %8d %9s %5d %9s  %s\n", e.Transport, e.Connects, ms(e.Connect), e.RTTs, ms(e.RTT), addr)
+		}
+	})
+}
+
+// newStreamsCommand lists the open streams of a remote node, and resets them
+func newStreamsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "streams <peer> [peer]",
+		Short: "Show the open streams of each connection of a remote node, or of its connections to one peer",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  runStreams,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "reset <peer> <stream-id>",
+		Short: "Reset an open stream of a remote node, e.g. a stuck or leaked one",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdStreamReset, args[1])
+		},
+	})
+	cmd.PersistentFlags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.PersistentFlags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runStreams(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	var filter []string
+	if len(args) > 1 {
+		id, err := contacts.ResolveID(args[1])
+		if err != nil {
+			return err
+		}
+		filter = append(filter, id.String())
+	}
+	var conns []libp2plearn.ConnStreams
+	if err := runAdminQuery(cmd, args[0], &conns, libp2plearn.AdminCmdStreams, filter...); err != nil {
+		return err
+	}
+	if conns == nil {
+		conns = []libp2plearn.ConnStreams{}
+	}
+	return printOutput(cmd, conns, func() {
+		streams := 0
+		for _, c := range conns {
+			fmt.Printf("%s via %s (%s)\n", contactLabel(contacts, c.Peer), c.Transport, c.ID)
+			for _, s := range c.Streams {
+				bytes := "-"
+				if s.Counted {
+					bytes = fmt.Sprintf("%d/%d", s.BytesRead, s.BytesWritten)
+				}
+				fmt.Printf("  %-24s %-8s %10s %15s  %s\n", s.ID, s.Direction, time.Duration(s.Age).Round(time.Second), bytes, s.Protocol)
+			}
+			streams += len(c.Streams)
+		}
+		fmt.Printf("%d streams on %d connections (bytes read/written)\n", streams, len(conns))
+	})
+}
+
+// newMaintenanceCommand takes a remote node out of service ahead of a
+// restart, and puts it back
+func newMaintenanceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "Drain a remote node's connections ahead of a restart, and put it back in service",
+	}
+	enter := &cobra.Command{
+		Use:   "enter <peer>",
+		Short: "Refuse new inbound connections and streams, tell peers and drain their connections",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var drain []string
+			if d, _ := cmd.Flags().GetDuration("drain"); d > 0 {
+				drain = append(drain, d.String())
+			}
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdMaintenance, append([]string{"enter"}, drain...)...)
+		},
+	}
+	enter.Flags().Duration("drain", 0, "How long to wait for connections to go idle (default maintenance_drain of the node)")
+	cmd.AddCommand(enter)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "exit <peer>",
+		Short: "Accept connections and streams again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdMaintenance, "exit")
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status <peer>",
+		Short: "Show whether the node is in maintenance and how many peers are still connected",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printAdminCommand(cmd, args[0], libp2plearn.AdminCmdMaintenance, "status")
+		},
+	})
+	cmd.PersistentFlags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.PersistentFlags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+// newLogLevelCommand shows or changes the log levels of a running node
+func newLogLevelCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "log-level <peer> [level]",
+		Short: "Show the log levels of a remote node, or change its level or that of a libp2p subsystem",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  runLogLevel,
+	}
+	cmd.Flags().StringP("subsystem", "s", "", "libp2p subsystem to change, such as swarm2 or dht, or * for all")
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and running the command")
+	return cmd
+}
+
+func runLogLevel(cmd *cobra.Command, args []string) error {
+	subsystem, _ := cmd.Flags().GetString("subsystem")
+	if len(args) > 1 {
+		cmdArgs := []string{args[1]}
+		if subsystem != "" {
+			cmdArgs = append(cmdArgs, subsystem)
+		}
+		var level string
+		if err := runAdminQuery(cmd, args[0], &level, libp2plearn.AdminCmdLogLevel, cmdArgs...); err != nil {
+			return err
+		}
+		return printOutput(cmd, level, func() {
+			if subsystem != "" {
+				fmt.Printf("%s: %s\n", subsystem, level)
+			} else {
+				fmt.Println(level)
+			}
+		})
+	}
+	if subsystem != "" {
+		return fmt.Errorf("--subsystem needs a level to set")
+	}
+
+	var levels libp2plearn.LogLevels
+	if err := runAdminQuery(cmd, args[0], &levels, libp2plearn.AdminCmdLogLevels); err != nil {
+		return err
+	}
+	if levels.Subsystems == nil {
+		levels.Subsystems = map[string]string{}
+	}
+	if levels.Available == nil {
+		levels.Available = []string{}
+	}
+	return printOutput(cmd, levels, func() {
+		fmt.Printf("Level:       %s\n", levels.Level)
+		names := make([]string, 0, len(levels.Subsystems))
+		for name := range levels.Subsystems {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("Subsystems:")
+		for _, name := range names {
+			fmt.Printf("  %-20s %s\n", name, levels.Subsystems[name])
+		}
+		fmt.Printf("  (%d others at their default)\n", len(levels.Available)-len(names))
+	})
+}
+
+// runAdminQuery runs an admin command on the node at addr and decodes the
+// result into v
+func runAdminQuery(cmd *cobra.Command, addr string, v interface{}, command string, args ...string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, target, err := connectAsOperator(ctx, cmd, addr)
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	result, err := libp2plearn.SendAdminCommand(ctx, node.Host(), target, command, args...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(result, v); err != nil {
+		return fmt.Errorf("failed to parse %s result: %w", command, err)
+	}
+	return nil
+}
+
+// printAdminCommand runs an admin command on the node at addr and prints
+// the result as indented JSON
+func printAdminCommand(cmd *cobra.Command, addr, command string, args ...string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, target, err := connectAsOperator(ctx, cmd, addr)
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	result, err := libp2plearn.SendAdminCommand(ctx, node.Host(), target, command, args...)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		return fmt.Errorf("failed to format result: %w", err)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// newPushConfigCommand pushes a signed config patch to a remote node
+func newPushConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "push-config <peer> <patch.json>",
+		Short: "Push a signed partial configuration to a remote node",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runPushConfig,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of the admin identity")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting and applying the update")
+	return cmd
+}
+
+func runPushConfig(cmd *cobra.Command, args []string) error {
+	patch, err := os.ReadFile(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to read config patch: %w", err)
+	}
+	if !json.Valid(patch) {
+		return fmt.Errorf("config patch is not valid JSON")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, target, err := connectAsOperator(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	ack, err := libp2plearn.PushConfig(ctx, node.Host(), target, patch)
+	if err != nil {
+		return err
+	}
+	result := configPushResult{ID: target.String(), Seq: ack.Seq, RestartRequired: []string{}}
+	result.RestartRequired = append(result.RestartRequired, ack.RestartRequired...)
+	return printOutput(cmd, result, func() {
+		fmt.Printf("Config update %d applied\n", result.Seq)
+		if len(result.RestartRequired) > 0 {
+			fmt.Printf("Restart required for: %v\n", result.RestartRequired)
+		}
+	})
+}
+
+// newShellCommand runs a command or an interactive shell on a remote node
+func newShellCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shell <peer> [command...]",
+		Short: "Open a remote shell, or run a command, on a node with the shell enabled",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runShell,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of an identity listed in the node's shell_peers")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	cmd.Flags().BoolP("tty", "t", false, "Allocate a pty even when running a command")
+	return cmd
+}
+
+func runShell(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, target, err := connectAsOperator(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	req := libp2plearn.ShellRequest{Command: strings.Join(args[1:], " ")}
+	forceTTY, _ := cmd.Flags().GetBool("tty")
+	stdinFd := int(os.Stdin.Fd())
+	req.PTY = forceTTY || (req.Command == "" && isTerminal(stdinFd))
+
+	sio := libp2plearn.ShellIO{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	restore := func() {}
+	if req.PTY && isTerminal(stdinFd) {
+		req.Term = os.Getenv("TERM")
+		if size, err := terminalSize(stdinFd); err == nil {
+			req.Rows, req.Cols = size.Rows, size.Cols
+		}
+		if restore, err = makeRaw(stdinFd); err != nil {
+			node.Stop(context.Background())
+			return fmt.Errorf("failed to put terminal into raw mode: %w", err)
+		}
+		sio.Resize = watchResize(stdinFd)
+	}
+
+	code, err := libp2plearn.RunRemoteShell(ctx, node.Host(), target, req, sio)
+	restore()
+	node.Stop(context.Background())
+	if err != nil {
+		return err
+	}
+	os.Exit(code)
+	return nil
+}
+
+// connectAsOperator starts a throwaway node with the admin identity that only
+// dials out, and connects it to the target node
+func connectAsOperator(ctx context.Context, cmd *cobra.Command, addr string) (*libp2plearn.Node, peer.ID, error) {
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile == "" {
+		return nil, "", fmt.Errorf("--identity is required so the remote node can recognize the admin peer")
+	}
+	return connectToPeer(ctx, cmd, addr)
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newChatCommand opens an interactive chat with one peer, or a room of several
+func newChatCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "chat [peer...]",
+		Short: "Chat with a peer, or with several as a room, in a terminal UI",
+		Long: `Chat with a peer, or with several as a room, in a terminal UI.
+
+Every message goes to each peer in the room over its own chat session. Peers
+that open a chat session with this node join the room, so with no addresses
+the command waits for others to start the chat. With --room, only members of
+that private room, as listed in rooms_file, can take part.`,
+		RunE: runChat,
+	}
+	cmd.Flags().StringP("config", "c", "", "Configuration file path")
+	cmd.Flags().StringP("identity", "k", "", "Private key file, so peers see the same peer ID every time")
+	cmd.Flags().Bool("secure-chat", false, "Encrypt the chat end to end with a double ratchet")
+	cmd.Flags().Int("queue-size", 0, "Messages queued for each peer before it counts as slow (default chat_queue_size)")
+	cmd.Flags().String("slow-peers", "", "What to do with a slow peer: drop-oldest, drop-newest or disconnect (default chat_slow_peers)")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to each peer")
+	cmd.Flags().String("room", "", "Only chat with members of this private room (see the room command)")
+	return cmd
+}
+
+func runChat(cmd *cobra.Command, args []string) error {
+	stdinFd := int(os.Stdin.Fd())
+	if !isTerminal(stdinFd) {
+		return fmt.Errorf("chat needs an interactive terminal")
+	}
+	size, err := terminalSize(stdinFd)
+	if err != nil {
+		return fmt.Errorf("failed to read terminal size: %w", err)
+	}
+
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	if secureChat, _ := cmd.Flags().GetBool("secure-chat"); secureChat {
+		config.EnableSecureChat = true
+	}
+	if queueSize, _ := cmd.Flags().GetInt("queue-size"); queueSize != 0 {
+		config.ChatQueueSize = queueSize
+	}
+	if slowPeers, _ := cmd.Flags().GetString("slow-peers"); slowPeers != "" {
+		config.ChatSlowPeers = slowPeers
+	}
+	roomName, _ := cmd.Flags().GetString("room")
+	if roomName != "" {
+		config.EnableRooms = true
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return err
+	}
+	// Logs would scribble over the chat, so keep only those going to a file
+	if config.LogFile == "" {
+		logrus.SetOutput(io.Discard)
+	}
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	rooms := node.Rooms()
+	if roomName != "" {
+		if _, ok := rooms.Membership(roomName); !ok {
+			return fmt.Errorf("no room named %s in %s", roomName, config.RoomsFile)
+		}
+	}
+
+	// Take incoming sessions from the start, so peers that open one while
+	// the node starts or connects out aren't refused
+	room := libp2plearn.NewChatBroadcaster(config.ChatQueueSize, config.ChatSlowPeers)
+	defer room.Close()
+	ui := newChatUI(os.Stdout, node.Host().ID(), size, contacts, room)
+	node.Protocols().SetChatSessionHandler(func(sess *libp2plearn.ChatSession) {
+		if roomName != "" && !rooms.IsMember(roomName, sess.Peer()) {
+			logrus.WithFields(logrus.Fields{"peer": sess.Peer(), "room": roomName}).Info("Refused chat from non-member")
+			sess.Close()
+			return
+		}
+		ui.Join(sess)
+	})
+	defer node.Protocols().SetChatSessionHandler(nil)
+
+	if err := node.Start(ctx); err != nil {
+		return err
+	}
+
+	for _, ref := range args {
+		target, err := contacts.Resolve(ref)
+		if err != nil {
+			return err
+		}
+		connectCtx, connectCancel := context.WithTimeout(ctx, timeout)
+		err = node.Host().Connect(connectCtx, target)
+		if err == nil && roomName != "" {
+			// Swap member lists first, which also gets us admitted if we
+			// were invited
+			if syncErr := node.RoomSync().Sync(connectCtx, roomName, target.ID); syncErr != nil {
+				logrus.WithError(syncErr).WithField("peer", target.ID).Debug("Failed to sync room")
+			}
+			if !rooms.IsMember(roomName, target.ID) {
+				err = fmt.Errorf("not a member of room %s", roomName)
+			}
+		}
+		var sess *libp2plearn.ChatSession
+		if err == nil {
+			sess, err = node.Protocols().OpenChatSession(connectCtx, target.ID)
+		}
+		connectCancel()
+		if err != nil {
+			return fmt.Errorf("failed to open chat with %s: %w", ref, err)
+		}
+		defer sess.Close()
+		ui.Join(sess)
+	}
+
+	restore, err := makeRaw(stdinFd)
+	if err != nil {
+		return fmt.Errorf("failed to put terminal into raw mode: %w", err)
+	}
+	defer restore()
+
+	ui.Run(os.Stdin, watchResize(stdinFd))
+	return nil
+}
+
+const (
+	// chatSidebarWidth is the width of the peer list, which is hidden on
+	// terminals narrower than chatSidebarMinCols
+	chatSidebarWidth   = 26
+	chatSidebarMinCols = 70
+
+	// chatHistoryLimit bounds the lines of history kept for scrolling back
+	chatHistoryLimit = 5000
+)
+
+// chatLine is one line of chat history. from is empty for status lines;
+// sent holds the ID of our own messages in every session they went to,
+// filled in as each member's queue gets to them.
+type chatLine struct {
+	time time.Time
+	from string
+	text string
+	sent map[*libp2plearn.ChatSession]uint64
+}
+
+// chatMember is a peer in the room. Messages go out on its first session;
+// a second one exists when both sides opened a session at once.
+type chatMember struct {
+	id          peer.ID
+	sessions    []*libp2plearn.ChatSession
+	typingUntil time.Time
+}
+
+// chatUI is a terminal chat with one or more peers: history on the left,
+// who is in the room on the right and an input line at the bottom
+type chatUI struct {
+	out      io.Writer
+	self     peer.ID
+	contacts *libp2plearn.AddressBook
+	room     *libp2plearn.ChatBroadcaster
+
+	mu      sync.Mutex
+	size    libp2plearn.WindowSize
+	members []*chatMember
+	history []chatLine
+	input   []rune
+	scroll  int
+	drawing bool
+}
+
+func newChatUI(out io.Writer, self peer.ID, size libp2plearn.WindowSize, contacts *libp2plearn.AddressBook, room *libp2plearn.ChatBroadcaster) *chatUI {
+	return &chatUI{out: out, self: self, size: size, contacts: contacts, room: room}
+}
+
+// Join adds a session to the room and shows what the peer does in it until
+// it ends
+func (ui *chatUI) Join(sess *libp2plearn.ChatSession) {
+	ui.mu.Lock()
+	m := ui.member(sess.Peer())
+	if m == nil {
+		m = &chatMember{id: sess.Peer()}
+		ui.members = append(ui.members, m)
+		ui.status("%s joined", ui.name(m.id))
+		// Peers whose key wasn't checked could be anyone in between
+		if !ui.contacts.IsVerified(m.id) {
+			if number, err := libp2plearn.SafetyNumberOf(ui.self, m.id); err == nil {
+				ui.status("%s is not verified, safety number %s", ui.name(m.id), number.EmojiString())
+			}
+		}
+	}
+	if len(m.sessions) == 0 {
+		ui.room.Add(sess)
+	}
+	m.sessions = append(m.sessions, sess)
+	ui.draw()
+	ui.mu.Unlock()
+
+	go ui.follow(m, sess)
+}
+
+// follow shows the events of one session
+func (ui *chatUI) follow(m *chatMember, sess *libp2plearn.ChatSession) {
+	for evt := range sess.Events() {
+		ui.mu.Lock()
+		switch evt.Type {
+		case libp2plearn.ChatEventMessage:
+			m.typingUntil = time.Time{}
+			ui.add(chatLine{time: evt.Time, from: ui.name(m.id), text: evt.Text})
+			sess.MarkRead(evt.ID)
+		case libp2plearn.ChatEventTyping:
+			m.typingUntil = time.Now().Add(libp2plearn.ChatTypingTimeout)
+		}
+		ui.draw()
+		ui.mu.Unlock()
+	}
+
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	for i, s := range m.sessions {
+		if s == sess {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			break
+		}
+	}
+	// Messages go out on the member's first session
+	ui.room.Remove(sess)
+	if len(m.sessions) > 0 {
+		ui.room.Add(m.sessions[0])
+	} else {
+		m.typingUntil = time.Time{}
+		ui.status("%s left", ui.name(m.id))
+	}
+	ui.draw()
+}
+
+// Run reads keys from in until the user quits or in ends. Enter sends the
+// input, Page Up and Page Down scroll the history, and Ctrl+C, Ctrl+D or
+// /quit leave.
+func (ui *chatUI) Run(in io.Reader, resize <-chan libp2plearn.WindowSize) {
+	ui.mu.Lock()
+	fmt.Fprint(ui.out, "\x1b[?1049h")
+	ui.drawing = true
+	ui.draw()
+	ui.mu.Unlock()
+	defer func() {
+		ui.mu.Lock()
+		ui.drawing = false
+		fmt.Fprint(ui.out, "\x1b[?1049l")
+		ui.mu.Unlock()
+	}()
+
+	// Redraw for resizes, and every second to expire typing indicators
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case size := <-resize:
+				ui.mu.Lock()
+				ui.size = size
+				ui.draw()
+				ui.mu.Unlock()
+			case <-ticker.C:
+				ui.mu.Lock()
+				ui.draw()
+				ui.mu.Unlock()
+			}
+		}
+	}()
+
+	keys := bufio.NewReader(in)
+	for {
+		r, _, err := keys.ReadRune()
+		if err != nil {
+			return
+		}
+		ui.mu.Lock()
+		quit := ui.key(r, keys)
+		ui.draw()
+		ui.mu.Unlock()
+		if quit {
+			return
+		}
+	}
+}
+
+// key handles one key and reports whether the user wants to quit
+func (ui *chatUI) key(r rune, keys *bufio.Reader) bool {
+	switch r {
+	case 3, 4: // Ctrl+C, Ctrl+D
+		return true
+	case '\r', '\n':
+		text := strings.TrimSpace(string(ui.input))
+		ui.input = nil
+		if text == "/quit" {
+			return true
+		}
+		if text != "" {
+			ui.send(text)
+		}
+	case 127, '\b':
+		if len(ui.input) > 0 {
+			ui.input = ui.input[:len(ui.input)-1]
+		}
+	case 0x1b:
+		ui.escape(keys)
+	default:
+		if r >= ' ' {
+			ui.input = append(ui.input, r)
+			ui.room.Typing()
+		}
+	}
+	return false
+}
+
+// escape handles an escape sequence, of which only Page Up and Page Down
+// mean anything
+func (ui *chatUI) escape(keys *bufio.Reader) {
+	if b, err := keys.ReadByte(); err != nil || b != '[' {
+		return
+	}
+	var seq []byte
+	for {
+		b, err := keys.ReadByte()
+		if err != nil {
+			return
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+	page := ui.historyHeight() - 1
+	switch string(seq) {
+	case "5~":
+		ui.scroll += page
+	case "6~":
+		ui.scroll = max(ui.scroll-page, 0)
+	}
+}
+
+// send queues a message for everyone in the room, so a slow peer doesn't
+// hold up the others
+func (ui *chatUI) send(text string) {
+	line := chatLine{time: time.Now(), from: "you", text: text, sent: make(map[*libp2plearn.ChatSession]uint64)}
+	queued := ui.room.Broadcast(text, func(sess *libp2plearn.ChatSession, id uint64, err error) {
+		ui.mu.Lock()
+		defer ui.mu.Unlock()
+		if err != nil {
+			ui.status("failed to send to %s: %v", ui.name(sess.Peer()), err)
+		} else {
+			line.sent[sess] = id
+		}
+		ui.draw()
+	})
+	if queued == 0 {
+		ui.status("nobody is here to read that")
+		return
+	}
+	ui.add(line)
+	ui.scroll = 0
+}
+
+// member returns the member for a peer, or nil; ui.mu must be held
+func (ui *chatUI) member(id peer.ID) *chatMember {
+	for _, m := range ui.members {
+		if m.id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// status adds a status line; ui.mu must be held
+func (ui *chatUI) status(format string, args ...interface{}) {
+	ui.add(chatLine{time: time.Now(), text: fmt.Sprintf(format, args...)})
+}
+
+// add adds a line of history; ui.mu must be held
+func (ui *chatUI) add(line chatLine) {
+	ui.history = append(ui.history, line)
+	if len(ui.history) > chatHistoryLimit {
+		ui.history = ui.history[len(ui.history)-chatHistoryLimit:]
+	}
+}
+
+// historyHeight is the number of rows showing history
+func (ui *chatUI) historyHeight() int {
+	return max(int(ui.size.Rows)-3, 1)
+}
+
+// draw redraws the whole screen while Run runs; ui.mu must be held
+func (ui *chatUI) draw() {
+	if !ui.drawing {
+		return
+	}
+	cols := max(int(ui.size.Cols), 20)
+	sidebar := 0
+	if cols >= chatSidebarMinCols {
+		sidebar = chatSidebarWidth
+	}
+	width := cols
+	if sidebar > 0 {
+		width = cols - sidebar - 1
+	}
+	height := ui.historyHeight()
+
+	var lines []string
+	for _, line := range ui.history {
+		lines = append(lines, wrapRunes(ui.format(line), width)...)
+	}
+	ui.scroll = min(ui.scroll, max(len(lines)-height, 0))
+	end := len(lines) - ui.scroll
+	start := max(end-height, 0)
+	people := ui.peopleLines()
+
+	var b strings.Builder
+	b.WriteString("\x1b[?25l\x1b[H")
+	title := fmt.Sprintf(" libp2p chat as %s, %d in the room", shortPeerID(ui.self), ui.present())
+	if ui.scroll > 0 {
+		title += fmt.Sprintf(" (scrolled back %d lines)", ui.scroll)
+	}
+	b.WriteString("\x1b[7m" + fitRunes(title, cols) + "\x1b[0m\r\n")
+	for row := 0; row < height; row++ {
+		text := ""
+		if start+row < end {
+			text = lines[start+row]
+		}
+		b.WriteString(fitRunes(text, width))
+		if sidebar > 0 {
+			text = ""
+			if row < len(people) {
+				text = people[row]
+			}
+			b.WriteString("│" + fitRunes(text, sidebar))
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString(strings.Repeat("─", cols) + "\r\n")
+
+	input := ui.input
+	if len(input) > cols-3 {
+		input = input[len(input)-(cols-3):]
+	}
+	b.WriteString("> " + string(input) + "\x1b[K\x1b[?25h")
+	fmt.Fprint(ui.out, b.String())
+}
+
+// format renders a line of history. Control characters from peers are
+// replaced, so they can't send escape sequences to the terminal.
+func (ui *chatUI) format(line chatLine) string {
+	stamp := line.time.Format("15:04")
+	if line.from == "" {
+		return fmt.Sprintf("%s * %s", stamp, printable(line.text))
+	}
+	text := fmt.Sprintf("%s <%s> %s", stamp, line.from, printable(line.text))
+	if len(line.sent) > 0 {
+		read := true
+		for sess, id := range line.sent {
+			if sess.ReadUpTo() < id {
+				read = false
+			}
+		}
+		if read {
+			text += " ✓✓"
+		} else {
+			text += " ✓"
+		}
+	}
+	return text
+}
+
+// peopleLines renders the peer list
+func (ui *chatUI) peopleLines() []string {
+	lines := []string{" In the room:"}
+	now := time.Now()
+	for _, m := range ui.members {
+		var queued int
+		if len(m.sessions) > 0 {
+			queued = ui.room.Queue(m.sessions[0]).Queued
+		}
+		switch {
+		case len(m.sessions) == 0:
+			lines = append(lines, fmt.Sprintf(" ○ %s (left)", ui.name(m.id)))
+		case queued > 0:
+			lines = append(lines, fmt.Sprintf(" ◌ %s (%d queued)", ui.name(m.id), queued))
+		case now.Before(m.typingUntil):
+			lines = append(lines, fmt.Sprintf(" ● %s typing…", ui.name(m.id)))
+		default:
+			lines = append(lines, fmt.Sprintf(" ● %s", ui.name(m.id)))
+		}
+	}
+	return lines
+}
+
+// present counts the members still in the room
+func (ui *chatUI) present() int {
+	var n int
+	for _, m := range ui.members {
+		if len(m.sessions) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// name returns the contact name of a peer, or the end of its peer ID
+func (ui *chatUI) name(id peer.ID) string {
+	if name := ui.contacts.Name(id); name != "" {
+		return name
+	}
+	return shortPeerID(id)
+}
+
+// shortPeerID returns the end of a peer ID, which is enough to tell peers
+// apart in a chat
+func shortPeerID(id peer.ID) string {
+	s := id.String()
+	if len(s) > 8 {
+		return s[len(s)-8:]
+	}
+	return s
+}
+
+// printable replaces control characters with spaces
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// fitRunes cuts or pads s to exactly width runes
+func fitRunes(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+// wrapRunes splits s into lines of at most width runes
+func wrapRunes(s string, width int) []string {
+	r := []rune(s)
+	var lines []string
+	for len(r) > width {
+		lines = append(lines, string(r[:width]))
+		r = r[width:]
+	}
+	return append(lines, string(r))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newInviteCommand runs a node and prints a token, and its QR code, that
+// another node joins it with
+func newInviteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Run a node and print a token, with its QR code, that another node joins it with",
+		Args:  cobra.NoArgs,
+		RunE:  runInvite,
+	}
+	cmd.Flags().StringP("config", "c", "", "Configuration file path")
+	cmd.Flags().StringP("identity", "k", "", "Private key file, so the token keeps working across restarts")
+	cmd.Flags().Bool("no-qr", false, "Print the token without its QR code")
+	return cmd
+}
+
+func runInvite(cmd *cobra.Command, args []string) error {
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return err
+	}
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		return err
+	}
+
+	invite := node.Invite()
+	if len(invite.Addrs) == 0 {
+		node.Stop(context.Background())
+		return fmt.Errorf("the node has no addresses to invite others to")
+	}
+	token := invite.Token()
+	result := inviteToken{ID: invite.ID.String(), Addrs: []string{}, Token: token}
+	for _, addr := range invite.Addrs {
+		result.Addrs = append(result.Addrs, addr.String())
+	}
+	if err := printOutput(cmd, result, func() {
+		if noQR, _ := cmd.Flags().GetBool("no-qr"); !noQR {
+			if qr, err := libp2plearn.EncodeQR(token); err == nil {
+				fmt.Print(qr.Terminal())
+			}
+		}
+		fmt.Printf("Invite token for %s:\n\n  %s\n\n", invite.ID, token)
+		fmt.Printf("On the other machine, run:\n\n  libp2p-node join %s\n\n", token)
+		fmt.Println("Press Ctrl+C to stop...")
+	}); err != nil {
+		node.Stop(context.Background())
+		return err
+	}
+	waitForSignal()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return node.Stop(shutdownCtx)
+}
+
+// newJoinCommand connects to the node of an invite token
+func newJoinCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "join <token>",
+		Short: "Connect to the node that printed an invite token, optionally saving it as a contact",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runJoin,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file to connect with (default a fresh identity)")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	cmd.Flags().String("name", "", "Save the node in the address book under this name")
+	return cmd
+}
+
+func runJoin(cmd *cobra.Command, args []string) error {
+	invite, err := libp2plearn.ParseInvite(args[0])
+	if err != nil {
+		return err
+	}
+	// Check the name before connecting, so a bad one doesn't waste the dial
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	name, _ := cmd.Flags().GetString("name")
+	if name != "" {
+		for _, addr := range invite.Addrs {
+			if _, err := contacts.Add(name, fmt.Sprintf("%s/p2p/%s", addr, invite.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx := context.Background()
+	node, err := dialPeer(ctx, cmd, invite.AddrInfo())
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", invite.ID, err)
+	}
+	defer node.Stop(context.Background())
+
+	result := joinResult{ID: invite.ID.String(), Name: name}
+	for _, conn := range node.Host().Network().ConnsToPeer(invite.ID) {
+		result.Addr = conn.RemoteMultiaddr().String()
+	}
+	if rtt, err := node.Ping(ctx, invite.ID); err == nil {
+		result.RTT = libp2plearn.Duration(rtt)
+	}
+	if name != "" {
+		if err := contacts.Save(); err != nil {
+			return err
+		}
+	}
+	return printOutput(cmd, result, func() {
+		fmt.Printf("✓ Connected to %s at %s", invite.ID, result.Addr)
+		if result.RTT > 0 {
+			fmt.Printf(", round trip %s", time.Duration(result.RTT).Round(10*time.Microsecond))
+		}
+		fmt.Println()
+		if name != "" {
+			fmt.Printf("Saved as %s, so commands can use it: libp2p-node ping %s\n", name, name)
+		}
+	})
+}
+
+// newPairCommand pairs two nodes on the same network with a short PIN
+func newPairCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pair",
+		Short: "Pair with a node on the local network by PIN, pinning each other",
+		Long: `Pair two nodes on the same network without copying multiaddrs. Run
+"pair" on one to show a PIN, then "pair --pin <PIN>" on the other. The nodes
+find each other with mDNS and prove they know the PIN with a SPAKE2 handshake,
+which ends after 3 wrong PINs. Each pins the other, saving it to the
+pinned_peers of --config.`,
+		Args: cobra.NoArgs,
+		RunE: runPair,
+	}
+	cmd.Flags().StringP("config", "c", "", "Configuration file path, whose pinned peers the paired node is saved to")
+	cmd.Flags().StringP("identity", "k", "", "Private key file, so the pairing outlives restarts")
+	cmd.Flags().String("pin", "", "PIN shown by the other node (default show one and wait)")
+	cmd.Flags().Duration("timeout", 5*time.Minute, "Timeout for pairing")
+	cmd.Flags().String("name", "", "Save the paired node in the address book under this name")
+	return cmd
+}
+
+func runPair(cmd *cobra.Command, args []string) error {
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	config.EnableMDNS = true
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return err
+	}
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	name, _ := cmd.Flags().GetString("name")
+
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+	if err := node.Start(ctx); err != nil {
+		return err
+	}
+
+	var info peer.AddrInfo
+	if pin, _ := cmd.Flags().GetString("pin"); pin != "" {
+		if !outputIsJSON(cmd) {
+			fmt.Println("Looking for the node showing the PIN on the local network...")
+		}
+		info, err = node.Pair(ctx, pin)
+	} else {
+		pin, err = libp2plearn.NewPairingPIN()
+		if err != nil {
+			return err
+		}
+		if !outputIsJSON(cmd) {
+			fmt.Printf("Pairing PIN: %s\n\nOn the other device, run:\n\n  libp2p-node pair --pin %s\n\n", pin, pin)
+		}
+		info, err = node.AcceptPairing(ctx, pin)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pair: %w", err)
+	}
+
+	addr := fmt.Sprintf("%s/p2p/%s", info.Addrs[0], info.ID)
+	result := pairResult{ID: info.ID.String(), Name: name, Addr: addr}
+	if configFile != "" {
+		// Save to the file as it was, without the flags of this run
+		saved, err := libp2plearn.LoadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(saved.PinnedPeers, addr) {
+			saved.PinnedPeers = append(saved.PinnedPeers, addr)
+		}
+		if err := saved.SaveConfig(configFile); err != nil {
+			return err
+		}
+		result.Saved = true
+	}
+	if name != "" {
+		if _, err := contacts.Add(name, addr); err != nil {
+			return err
+		}
+		if err := contacts.Save(); err != nil {
+			return err
+		}
+	}
+	return printOutput(cmd, result, func() {
+		fmt.Printf("✓ Paired with %s at %s\n", info.ID, info.Addrs[0])
+		if result.Saved {
+			fmt.Printf("Pinned in %s\n", configFile)
+		} else {
+			fmt.Printf("Pin it for good by adding it to pinned_peers:\n\n  %s\n", addr)
+		}
+		if name != "" {
+			fmt.Printf("Saved as %s, so commands can use it: libp2p-node ping %s\n", name, name)
+		}
+	})
+}
+
+// newContactsCommand manages the address book that names peers
+func newContactsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "contacts",
+		Short: "Name peers, so commands take \"alice\" wherever a peer goes",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <name> <peer>",
+		Short: "Name a peer ID or peer multiaddr, adding the address to a name already used for the peer",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runContactsAdd,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm <name>",
+		Short: "Forget a name",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runContactsRm,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls",
+		Short: "List the named peers",
+		Args:  cobra.NoArgs,
+		RunE:  runContactsLs,
+	})
+	verify := &cobra.Command{
+		Use:   "verify <name>",
+		Short: "Show the safety number shared with a contact, and mark it verified once it matches theirs",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runContactsVerify,
+	}
+	verify.Flags().StringP("identity", "k", "", "Private key file of this node")
+	verify.Flags().Bool("yes", false, "Mark the contact verified without asking")
+	verify.MarkFlagRequired("identity")
+	cmd.AddCommand(verify)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unverify <name>",
+		Short: "Mark a contact as no longer verified",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runContactsUnverify,
+	})
+	return cmd
+}
+
+func runContactsAdd(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	contact, err := contacts.Add(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if err := contacts.Save(); err != nil {
+		return err
+	}
+	return printOutput(cmd, contact, func() {
+		fmt.Printf("%s is %s\n", contact.Name, contact.ID)
+	})
+}
+
+func runContactsRm(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	if !contacts.Remove(args[0]) {
+		return fmt.Errorf("no contact named %s", args[0])
+	}
+	return contacts.Save()
+}
+
+func runContactsLs(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	list := contacts.Contacts()
+	return printOutput(cmd, list, func() {
+		for _, c := range list {
+			if c.Verified {
+				fmt.Printf("%s\t%s\tverified\n", c.Name, c.ID)
+			} else {
+				fmt.Printf("%s\t%s\n", c.Name, c.ID)
+			}
+			for _, addr := range c.Addrs {
+				fmt.Printf("  %s\n", addr)
+			}
+		}
+		fmt.Printf("%d contacts\n", len(list))
+	})
+}
+
+// contactVerification is the result of contacts verify
+type contactVerification struct {
+	Contact      libp2plearn.Contact      `json:"contact"`
+	SafetyNumber libp2plearn.SafetyNumber `json:"safety_number"`
+}
+
+func runContactsVerify(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	contact, ok := contacts.Lookup(args[0])
+	if !ok {
+		return fmt.Errorf("no contact named %s", args[0])
+	}
+	identityFile, _ := cmd.Flags().GetString("identity")
+	key, err := libp2plearn.LoadIdentity(identityFile)
+	if err != nil {
+		return err
+	}
+	self, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	number, err := libp2plearn.SafetyNumberOf(self, contact.ID)
+	if err != nil {
+		return err
+	}
+
+	// Only ask when the result is read by a person
+	confirmed, _ := cmd.Flags().GetBool("yes")
+	if !confirmed && !outputIsJSON(cmd) {
+		fmt.Printf("Safety number with %s:\n\n  %s\n\n  %s\n  %s\n\n", contact.Name, number, number.EmojiString(), strings.Join(number.EmojiNames, ", "))
+		fmt.Printf("Compare it with the one %s sees, in person or on a call.\nDo they match? [y/N] ", contact.Name)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			confirmed = true
+		default:
+			fmt.Printf("%s is not verified\n", contact.Name)
+			return nil
+		}
+	}
+	if confirmed {
+		if contact, err = contacts.SetVerified(contact.Name, true); err != nil {
+			return err
+		}
+		if err := contacts.Save(); err != nil {
+			return err
+		}
+	}
+	result := contactVerification{Contact: contact, SafetyNumber: number}
+	return printOutput(cmd, result, func() {
+		fmt.Printf("%s is verified\n", contact.Name)
+	})
+}
+
+func runContactsUnverify(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	if _, err := contacts.SetVerified(args[0], false); err != nil {
+		return err
+	}
+	return contacts.Save()
+}
+
+// loadContacts loads the address book named by --contacts
+func loadContacts(cmd *cobra.Command) (*libp2plearn.AddressBook, error) {
+	path, _ := cmd.Flags().GetString("contacts")
+	return libp2plearn.LoadAddressBook(path)
+}
+
+// contactLabel names a peer ID for people, with its contact name if it has
+// one
+func contactLabel(contacts *libp2plearn.AddressBook, id string) string {
+	p, err := peer.Decode(id)
+	if err != nil {
+		return id
+	}
+	return contacts.Label(p)
+}
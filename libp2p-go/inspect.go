@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newPingCommand measures the round-trip time to a node with the standard
+// libp2p ping, which stock libp2p nodes answer too
+func newPingCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ping <peer>",
+		Short: "Measure the round-trip time to a node with /ipfs/ping/1.0.0",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runPing,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file to connect with (default a fresh identity)")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	cmd.Flags().IntP("count", "c", 4, "Number of pings to send")
+	cmd.Flags().Duration("interval", time.Second, "Time between pings")
+	return cmd
+}
+
+func runPing(cmd *cobra.Command, args []string) error {
+	count, _ := cmd.Flags().GetInt("count")
+	interval, _ := cmd.Flags().GetDuration("interval")
+
+	ctx := context.Background()
+	node, target, err := connectToPeer(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	// Pings are printed as they come back, and with --output json all at once
+	result := pingResult{ID: target.String(), Pings: []pingReply{}, Sent: count}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		reply := pingReply{Seq: i + 1}
+		rtt, err := node.Ping(ctx, target)
+		if err != nil {
+			result.Lost++
+			reply.Error = err.Error()
+		} else {
+			reply.RTT = libp2plearn.Duration(rtt.Round(10 * time.Microsecond))
+		}
+		result.Pings = append(result.Pings, reply)
+		if !outputIsJSON(cmd) {
+			if reply.Error != "" {
+				fmt.Printf("ping %d: %s\n", reply.Seq, reply.Error)
+			} else {
+				fmt.Printf("ping %d: %s\n", reply.Seq, time.Duration(reply.RTT))
+			}
+		}
+	}
+	result.SmoothedRTT = libp2plearn.Duration(node.Host().Peerstore().LatencyEWMA(target).Round(10 * time.Microsecond))
+	if err := printOutput(cmd, result, func() {
+		fmt.Printf("%d sent, %d lost, smoothed RTT %s\n", result.Sent, result.Lost, time.Duration(result.SmoothedRTT))
+	}); err != nil {
+		return err
+	}
+	if result.Lost == count {
+		return fmt.Errorf("no replies from %s", target)
+	}
+	return nil
+}
+
+// newHealthCommand probes the health of a remote node
+func newHealthCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "health <peer>",
+		Short: "Show the health report of a node, failing unless it is ok",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runHealth,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file to connect with (default a fresh identity)")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	cmd.Flags().Bool("json", false, "Print the report as JSON (same as --output json)")
+	return cmd
+}
+
+func runHealth(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	node, target, err := connectToPeer(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	report, err := node.Health().Check(ctx, target)
+	if err != nil {
+		return err
+	}
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		cmd.Flags().Set("output", outputJSON)
+	}
+	if err := printOutput(cmd, report, func() { printHealthReport(report) }); err != nil {
+		return err
+	}
+	if report.Status != libp2plearn.HealthOK {
+		return fmt.Errorf("node is %s", report.Status)
+	}
+	return nil
+}
+
+// printHealthReport prints a health report for people
+func printHealthReport(report *libp2plearn.HealthReport) {
+	fmt.Printf("Peer:         %s\n", report.PeerID)
+	fmt.Printf("Status:       %s\n", report.Status)
+	fmt.Printf("Uptime:       %s\n", time.Duration(report.Uptime))
+	fmt.Printf("Reachability: %s\n", report.Reachability)
+	fmt.Printf("Peers:        %d (%d connections, %d streams)\n", report.Peers, report.Connections, report.Streams)
+	fmt.Printf("Resources:    %d goroutines, %d MiB heap, %d MiB libp2p memory, %d libp2p fds\n",
+		report.Resources.Goroutines, report.Resources.HeapBytes>>20, report.Resources.Memory>>20, report.Resources.FDs)
+	fmt.Println("Subsystems:")
+	for _, sub := range report.Subsystems {
+		if sub.Message != "" {
+			fmt.Printf("  %-10s %-8s %s\n", sub.Name, sub.Status, sub.Message)
+		} else {
+			fmt.Printf("  %-10s %s\n", sub.Name, sub.Status)
+		}
+	}
+}
+
+// newDescribeCommand prints the protocols a node serves and how to speak them
+func newDescribeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "describe <peer>",
+		Short: "Describe the protocols a node serves: versions, message schemas and limits",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runDescribe,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file to connect with (default a fresh identity)")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	cmd.Flags().StringP("protocol", "p", "", "Only describe protocols whose ID contains this")
+	return cmd
+}
+
+func runDescribe(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	node, target, err := connectToPeer(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	reflection, err := node.Reflect().Fetch(ctx, target)
+	if err != nil {
+		return err
+	}
+	if filter, _ := cmd.Flags().GetString("protocol"); filter != "" {
+		protocols := []libp2plearn.ProtocolDoc{}
+		for _, doc := range reflection.Protocols {
+			if strings.Contains(doc.ID, filter) {
+				protocols = append(protocols, doc)
+			}
+		}
+		reflection.Protocols = protocols
+	}
+	return printOutput(cmd, reflection, func() { printReflection(reflection) })
+}
+
+// printReflection prints a protocol description for people
+func printReflection(reflection *libp2plearn.ProtocolReflection) {
+	fmt.Printf("Peer: %s\n", reflection.PeerID)
+	for _, doc := range reflection.Protocols {
+		fmt.Printf("\n%s\n", doc.ID)
+		if doc.Summary != "" {
+			fmt.Printf("  %s\n", doc.Summary)
+		}
+		if doc.Encoding != "" {
+			fmt.Printf("  Encoding:    %s\n", doc.Encoding)
+		}
+		if doc.Schema != "" {
+			fmt.Printf("  Schema:      %s\n", doc.Schema)
+		}
+		if len(doc.Compression) > 0 {
+			fmt.Printf("  Compression: %s\n", strings.Join(doc.Compression, ", "))
+		}
+		if doc.Limits != nil {
+			if doc.Limits.MaxMessageSize > 0 {
+				fmt.Printf("  Max message: %d bytes\n", doc.Limits.MaxMessageSize)
+			}
+			if doc.Limits.Timeout > 0 {
+				fmt.Printf("  Timeout:     %s\n", time.Duration(doc.Limits.Timeout))
+			}
+		}
+		for _, msg := range doc.Messages {
+			fmt.Printf("  %s (%s)", msg.Name, msg.Direction)
+			if msg.Doc != "" {
+				fmt.Printf(": %s", msg.Doc)
+			}
+			fmt.Println()
+			printSchemaFields(msg.Fields, "      ")
+		}
+	}
+}
+
+// printSchemaFields prints message fields, nested ones indented further
+func printSchemaFields(fields []libp2plearn.FieldSchema, indent string) {
+	for _, f := range fields {
+		optional := ""
+		if f.Optional {
+			optional = " (optional)"
+		}
+		fmt.Printf("%s%s %s%s\n", indent, f.Name, f.Type, optional)
+		printSchemaFields(f.Fields, indent+"  ")
+	}
+}
+
+// newSchemasCommand writes the protobuf schemas of the node's protocols
+func newSchemasCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schemas",
+		Short: "Write the protobuf schemas of the node's protocols, for clients in other languages",
+		Args:  cobra.NoArgs,
+		RunE:  runSchemas,
+	}
+	cmd.Flags().String("out", "proto", "Directory to write the .proto files under")
+	return cmd
+}
+
+func runSchemas(cmd *cobra.Command, args []string) error {
+	dir, _ := cmd.Flags().GetString("out")
+	paths, err := libp2plearn.WriteProtoFiles(dir)
+	if err != nil {
+		return err
+	}
+	return printOutput(cmd, paths, func() {
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+	})
+}
+
+// newIDCommand shows the identity of this operator or of a remote node
+func newIDCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "id [peer]",
+		Short: "Show the peer ID of --identity, or the identity a remote node announces",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runID,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file to show, or to connect with (default a fresh identity)")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	return cmd
+}
+
+func runID(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		identityFile, _ := cmd.Flags().GetString("identity")
+		if identityFile == "" {
+			return fmt.Errorf("give a peer multiaddr or --identity")
+		}
+		if _, err := os.Stat(identityFile); err != nil {
+			return fmt.Errorf("failed to read identity: %w", err)
+		}
+		key, err := libp2plearn.LoadIdentity(identityFile)
+		if err != nil {
+			return err
+		}
+		id, err := peer.IDFromPrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to derive peer ID: %w", err)
+		}
+		result := peerIdentity{ID: id.String(), Addrs: []string{}, Protocols: []string{}}
+		return printOutput(cmd, result, func() { fmt.Println(result.ID) })
+	}
+
+	ctx := context.Background()
+	node, target, err := connectToPeer(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	// Connecting waits for identify, so the peerstore has what the node announced
+	ps := node.Host().Peerstore()
+	result := peerIdentity{ID: target.String(), Addrs: []string{}, Protocols: []string{}}
+	if agent, err := ps.Get(target, "AgentVersion"); err == nil {
+		result.AgentVersion, _ = agent.(string)
+	}
+	if version, err := ps.Get(target, "ProtocolVersion"); err == nil {
+		result.ProtocolVersion, _ = version.(string)
+	}
+	for _, addr := range ps.Addrs(target) {
+		result.Addrs = append(result.Addrs, addr.String())
+	}
+	sort.Strings(result.Addrs)
+	protos, err := ps.GetProtocols(target)
+	if err != nil {
+		return fmt.Errorf("failed to read protocols: %w", err)
+	}
+	for _, proto := range protos {
+		result.Protocols = append(result.Protocols, string(proto))
+	}
+	sort.Strings(result.Protocols)
+
+	return printOutput(cmd, result, func() {
+		fmt.Printf("Peer:     %s\n", result.ID)
+		fmt.Printf("Agent:    %s\n", result.AgentVersion)
+		fmt.Printf("Protocol: %s\n", result.ProtocolVersion)
+		fmt.Println("Addresses:")
+		for _, addr := range result.Addrs {
+			fmt.Printf("  %s\n", addr)
+		}
+		fmt.Println("Protocols:")
+		for _, proto := range result.Protocols {
+			fmt.Printf("  %s\n", proto)
+		}
+	})
+}
+
+// connectToPeer starts a throwaway node, with the --identity key if given,
+// and connects it to the peer at addr within --timeout
+func connectToPeer(ctx context.Context, cmd *cobra.Command, addr string) (*libp2plearn.Node, peer.ID, error) {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	target, err := contacts.Resolve(addr)
+	if err != nil {
+		return nil, "", err
+	}
+	node, err := dialPeer(ctx, cmd, target)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	return node, target.ID, nil
+}
+
+// dialPeer starts a throwaway node, with the --identity key if given, and
+// connects it to target within --timeout
+func dialPeer(ctx context.Context, cmd *cobra.Command, target peer.AddrInfo) (*libp2plearn.Node, error) {
+	identityFile, _ := cmd.Flags().GetString("identity")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	config := libp2plearn.DefaultConfig()
+	config.IdentityFile = identityFile
+	config.BootstrapPeers = nil
+	config.EnableWebSocket = false
+	config.LogLevel = "warn"
+	if err := config.SetupLogging(); err != nil {
+		return nil, err
+	}
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return nil, err
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := node.Host().Connect(connectCtx, target); err != nil {
+		node.Stop(context.Background())
+		return nil, err
+	}
+	return node, nil
+}
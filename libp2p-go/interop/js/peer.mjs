@@ -0,0 +1,105 @@
+// js-libp2p peer for the libp2p-learn interop harness. See
+// InteropImplementation in pkg/libp2plearn/interop.go for the protocol it
+// speaks on stdin and stdout.
+import { createInterface } from 'node:readline'
+import { noise } from '@chainsafe/libp2p-noise'
+import { yamux } from '@chainsafe/libp2p-yamux'
+import { identify } from '@libp2p/identify'
+import { ping } from '@libp2p/ping'
+import { tcp } from '@libp2p/tcp'
+import { tls } from '@libp2p/tls'
+import { webSockets } from '@libp2p/websockets'
+import { multiaddr } from '@multiformats/multiaddr'
+import { createLibp2p } from 'libp2p'
+
+const PING = '/libp2p-learn/ping/1.0.0'
+const ECHO = '/libp2p-learn/echo/1.0.0'
+const HEALTH = '/libp2p-learn/health/1.0.0'
+
+const transports = {
+  tcp: { transport: tcp, listen: '/ip4/127.0.0.1/tcp/0' },
+  websocket: { transport: webSockets, listen: '/ip4/127.0.0.1/tcp/0/ws' }
+}
+const securities = { noise, tls }
+const muxers = { yamux }
+
+const { TRANSPORT, SECURITY, MUXER } = process.env
+const transport = transports[TRANSPORT]
+const security = securities[SECURITY]
+const muxer = muxers[MUXER]
+if (transport == null || security == null || muxer == null) {
+  console.error(`unsupported case ${TRANSPORT}/${SECURITY}/${MUXER}`)
+  process.exit(2)
+}
+
+const encoder = new TextEncoder()
+const decoder = new TextDecoder()
+
+// readAll reads a stream to its end
+async function readAll (source) {
+  const chunks = []
+  for await (const chunk of source) {
+    chunks.push(chunk.subarray())
+  }
+  return Buffer.concat(chunks)
+}
+
+// readLine reads a stream up to its first newline, which isn't returned
+async function readLine (source) {
+  let buf = Buffer.alloc(0)
+  for await (const chunk of source) {
+    buf = Buffer.concat([buf, chunk.subarray()])
+    const i = buf.indexOf(10)
+    if (i >= 0) {
+      return decoder.decode(buf.subarray(0, i))
+    }
+  }
+  throw new Error('stream ended before a newline')
+}
+
+const node = await createLibp2p({
+  addresses: { listen: [transport.listen] },
+  transports: [transport.transport()],
+  connectionEncrypters: [security()],
+  streamMuxers: [muxer()],
+  services: { identify: identify(), ping: ping() }
+})
+
+await node.handle(PING, async ({ stream }) => {
+  const line = await readLine(stream.source)
+  await stream.sink([encoder.encode(`pong: ${line}\n`)])
+})
+await node.handle(ECHO, async ({ stream }) => {
+  await stream.sink(stream.source)
+})
+
+// call runs the client side of a protocol against addr
+async function call ({ addr, protocol, data = '' }) {
+  const stream = await node.dialProtocol(multiaddr(addr), protocol)
+  switch (protocol) {
+    case PING:
+      await stream.sink([encoder.encode(`${data}\n`)])
+      return await readLine(stream.source)
+    case ECHO:
+      await stream.sink([encoder.encode(data)])
+      return decoder.decode(await readAll(stream.source))
+    case HEALTH:
+      await stream.sink([])
+      return await readLine(stream.source)
+    default:
+      stream.abort(new Error('unknown protocol'))
+      throw new Error(`unknown protocol ${protocol}`)
+  }
+}
+
+const write = (v) => process.stdout.write(JSON.stringify(v) + '\n')
+write({ id: node.peerId.toString(), addrs: node.getMultiaddrs().map(String) })
+
+for await (const line of createInterface({ input: process.stdin })) {
+  try {
+    write({ response: await call(JSON.parse(line)) })
+  } catch (err) {
+    write({ response: '', error: err.message })
+  }
+}
+await node.stop()
@@ -0,0 +1,292 @@
+//! rust-libp2p peer for the libp2p-learn interop harness. See
+//! InteropImplementation in pkg/libp2plearn/interop.go for the protocol it
+//! speaks on stdin and stdout.
+
+use std::{collections::HashMap, env, time::Duration};
+
+use anyhow::{anyhow, bail, Result};
+use futures::{AsyncBufReadExt, AsyncReadExt, AsyncWriteExt, StreamExt};
+use libp2p::{
+    identify,
+    identity::Keypair,
+    multiaddr::Protocol,
+    noise, ping,
+    swarm::{dial_opts::DialOpts, NetworkBehaviour, SwarmEvent},
+    tcp, tls, yamux, Multiaddr, PeerId, Stream, StreamProtocol, Swarm, SwarmBuilder,
+};
+use serde::{Deserialize, Serialize};
+use tokio::{
+    io::AsyncBufReadExt as _,
+    sync::{mpsc, oneshot},
+};
+
+const PING: StreamProtocol = StreamProtocol::new("/libp2p-learn/ping/1.0.0");
+const ECHO: StreamProtocol = StreamProtocol::new("/libp2p-learn/echo/1.0.0");
+const HEALTH: StreamProtocol = StreamProtocol::new("/libp2p-learn/health/1.0.0");
+
+#[derive(NetworkBehaviour)]
+struct Behaviour {
+    identify: identify::Behaviour,
+    ping: ping::Behaviour,
+    stream: libp2p_stream::Behaviour,
+}
+
+/// The first line the peer prints
+#[derive(Serialize)]
+struct Hello {
+    id: String,
+    addrs: Vec<String>,
+}
+
+/// Asks the peer to run the client side of a protocol
+#[derive(Deserialize)]
+struct Request {
+    addr: String,
+    protocol: String,
+    #[serde(default)]
+    data: String,
+}
+
+/// What the peer got back
+#[derive(Serialize)]
+struct Reply {
+    response: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    error: Option<String>,
+}
+
+/// Asks the swarm task to connect to an address
+type DialRequest = (Multiaddr, oneshot::Sender<Result<PeerId, String>>);
+
+fn behaviour(key: &Keypair) -> Behaviour {
+    Behaviour {
+        identify: identify::Behaviour::new(identify::Config::new(
+            "/ipfs/id/1.0.0".into(),
+            key.public(),
+        )),
+        ping: ping::Behaviour::default(),
+        stream: libp2p_stream::Behaviour::new(),
+    }
+}
+
+/// Builds a swarm whose TCP and WebSocket transports only offer the secure
+/// channel under test
+async fn build(security: &str) -> Result<Swarm<Behaviour>> {
+    let config = |c: libp2p::swarm::Config| c.with_idle_connection_timeout(Duration::from_secs(60));
+    let swarm = match security {
+        "tls" => SwarmBuilder::with_new_identity()
+            .with_tokio()
+            .with_tcp(
+                tcp::Config::default(),
+                tls::Config::new,
+                yamux::Config::default,
+            )?
+            .with_quic()
+            .with_dns()?
+            .with_websocket(tls::Config::new, yamux::Config::default)
+            .await?
+            .with_behaviour(behaviour)?
+            .with_swarm_config(config)
+            .build(),
+        _ => SwarmBuilder::with_new_identity()
+            .with_tokio()
+            .with_tcp(
+                tcp::Config::default(),
+                noise::Config::new,
+                yamux::Config::default,
+            )?
+            .with_quic()
+            .with_dns()?
+            .with_websocket(noise::Config::new, yamux::Config::default)
+            .await?
+            .with_behaviour(behaviour)?
+            .with_swarm_config(config)
+            .build(),
+    };
+    Ok(swarm)
+}
+
+#[tokio::main]
+async fn main() -> Result<()> {
+    let transport = env::var("TRANSPORT").unwrap_or_default();
+    let security = env::var("SECURITY").unwrap_or_default();
+    let muxer = env::var("MUXER").unwrap_or_default();
+    let listen: Multiaddr = match (transport.as_str(), security.as_str(), muxer.as_str()) {
+        ("tcp", "noise" | "tls", "yamux") => "/ip4/127.0.0.1/tcp/0",
+        ("websocket", "noise" | "tls", "yamux") => "/ip4/127.0.0.1/tcp/0/ws",
+        ("quic", "", "") => "/ip4/127.0.0.1/udp/0/quic-v1",
+        _ => bail!("unsupported case {transport}/{security}/{muxer}"),
+    }
+    .parse()?;
+
+    let mut swarm = build(&security).await?;
+    swarm.listen_on(listen)?;
+    let addr = loop {
+        if let SwarmEvent::NewListenAddr { address, .. } = swarm.select_next_some().await {
+            break address;
+        }
+    };
+    let id = *swarm.local_peer_id();
+
+    let mut control = swarm.behaviour().stream.new_control();
+    let mut pings = control.accept(PING)?;
+    tokio::spawn(async move {
+        while let Some((_, stream)) = pings.next().await {
+            tokio::spawn(async move {
+                if let Err(e) = handle_ping(stream).await {
+                    eprintln!("ping: {e:#}");
+                }
+            });
+        }
+    });
+    let mut echoes = control.accept(ECHO)?;
+    tokio::spawn(async move {
+        while let Some((_, stream)) = echoes.next().await {
+            tokio::spawn(async move {
+                if let Err(e) = handle_echo(stream).await {
+                    eprintln!("echo: {e:#}");
+                }
+            });
+        }
+    });
+
+    let (dials, dial_requests) = mpsc::channel(1);
+    tokio::spawn(run_swarm(swarm, dial_requests));
+
+    let hello = Hello {
+        id: id.to_string(),
+        addrs: vec![addr.to_string()],
+    };
+    println!("{}", serde_json::to_string(&hello)?);
+
+    let mut lines = tokio::io::BufReader::new(tokio::io::stdin()).lines();
+    while let Some(line) = lines.next_line().await? {
+        let result = match serde_json::from_str::<Request>(&line) {
+            Ok(req) => call(&mut control, &dials, req).await,
+            Err(e) => Err(e.into()),
+        };
+        let reply = match result {
+            Ok(response) => Reply {
+                response,
+                error: None,
+            },
+            Err(e) => Reply {
+                response: String::new(),
+                error: Some(format!("{e:#}")),
+            },
+        };
+        println!("{}", serde_json::to_string(&reply)?);
+    }
+    Ok(())
+}
+
+/// Drives the swarm, connecting to the addresses asked for
+async fn run_swarm(mut swarm: Swarm<Behaviour>, mut dial_requests: mpsc::Receiver<DialRequest>) {
+    let mut pending: HashMap<PeerId, Vec<oneshot::Sender<Result<PeerId, String>>>> = HashMap::new();
+    loop {
+        tokio::select! {
+            Some((addr, reply)) = dial_requests.recv() => {
+                let Some(peer) = addr.iter().find_map(|p| match p {
+                    Protocol::P2p(peer) => Some(peer),
+                    _ => None,
+                }) else {
+                    let _ = reply.send(Err(format!("no peer ID in {addr}")));
+                    continue;
+                };
+                if swarm.is_connected(&peer) {
+                    let _ = reply.send(Ok(peer));
+                    continue;
+                }
+                if let Err(e) = swarm.dial(DialOpts::peer_id(peer).addresses(vec![addr]).build()) {
+                    let _ = reply.send(Err(e.to_string()));
+                    continue;
+                }
+                pending.entry(peer).or_default().push(reply);
+            }
+            event = swarm.select_next_some() => match event {
+                SwarmEvent::ConnectionEstablished { peer_id, .. } => {
+                    for reply in pending.remove(&peer_id).unwrap_or_default() {
+                        let _ = reply.send(Ok(peer_id));
+                    }
+                }
+                SwarmEvent::OutgoingConnectionError { peer_id: Some(peer_id), error, .. } => {
+                    for reply in pending.remove(&peer_id).unwrap_or_default() {
+                        let _ = reply.send(Err(error.to_string()));
+                    }
+                }
+                _ => {}
+            }
+        }
+    }
+}
+
+/// Answers a line with the same line prefixed by "pong: "
+async fn handle_ping(stream: Stream) -> Result<()> {
+    let mut reader = futures::io::BufReader::new(stream);
+    let mut line = String::new();
+    reader.read_line(&mut line).await?;
+    let mut stream = reader.into_inner();
+    stream.write_all(format!("pong: {line}").as_bytes()).await?;
+    stream.close().await?;
+    Ok(())
+}
+
+/// Sends back everything it reads
+async fn handle_echo(mut stream: Stream) -> Result<()> {
+    let mut data = Vec::new();
+    stream.read_to_end(&mut data).await?;
+    stream.write_all(&data).await?;
+    stream.close().await?;
+    Ok(())
+}
+
+/// Runs the client side of a protocol against the address of a request
+async fn call(
+    control: &mut libp2p_stream::Control,
+    dials: &mpsc::Sender<DialRequest>,
+    req: Request,
+) -> Result<String> {
+    let addr: Multiaddr = req.addr.parse()?;
+    let (reply, connected) = oneshot::channel();
+    dials
+        .send((addr, reply))
+        .await
+        .map_err(|_| anyhow!("swarm stopped"))?;
+    let peer = connected.await?.map_err(|e| anyhow!(e))?;
+
+    let protocol = StreamProtocol::try_from_owned(req.protocol.clone())?;
+    let mut stream = control
+        .open_stream(peer, protocol.clone())
+        .await
+        .map_err(|e| anyhow!("failed to open stream: {e}"))?;
+    if protocol == PING {
+        stream
+            .write_all(format!("{}\n", req.data).as_bytes())
+            .await?;
+        stream.close().await?;
+        read_line(stream).await
+    } else if protocol == ECHO {
+        stream.write_all(req.data.as_bytes()).await?;
+        stream.close().await?;
+        let mut data = String::new();
+        stream.read_to_string(&mut data).await?;
+        Ok(data)
+    } else if protocol == HEALTH {
+        stream.close().await?;
+        read_line(stream).await
+    } else {
+        bail!("unknown protocol {}", req.protocol)
+    }
+}
+
+/// Reads a stream up to its first newline, which isn't returned
+async fn read_line(stream: Stream) -> Result<String> {
+    let mut line = String::new();
+    futures::io::BufReader::new(stream)
+        .read_line(&mut line)
+        .await?;
+    match line.strip_suffix('\n') {
+        Some(line) => Ok(line.to_string()),
+        None => bail!("stream ended before a newline"),
+    }
+}
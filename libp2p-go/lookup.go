@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newFindServiceCommand looks up the providers of an application service
+func newFindServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "find-service <name>",
+		Short: "Find peers that advertise an application service",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runFindService,
+	}
+	cmd.Flags().StringP("config", "c", "", "Configuration file path")
+	cmd.Flags().Int("limit", 10, "Maximum number of providers to find")
+	cmd.Flags().Duration("timeout", time.Minute, "Timeout for the lookup")
+	return cmd
+}
+
+func runFindService(cmd *cobra.Command, args []string) error {
+	limit, _ := cmd.Flags().GetInt("limit")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	node, err := startLookupNode(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	providers, err := node.FindService(ctx, args[0], limit)
+	if err != nil {
+		return err
+	}
+	if len(providers) == 0 {
+		return fmt.Errorf("no providers of %q found", args[0])
+	}
+	return printPeers(cmd, providers)
+}
+
+// startLookupNode starts a throwaway node from --config and waits until its
+// routing table is filled, or ctx is done, so it can look things up
+func startLookupNode(ctx context.Context, cmd *cobra.Command) (*libp2plearn.Node, error) {
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	config.LogLevel = "warn"
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return nil, err
+	}
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		return nil, err
+	}
+	select {
+	case <-node.DHT().RefreshRoutingTable():
+	case <-ctx.Done():
+	}
+	return node, nil
+}
+
+// printPeers prints peers found in the DHT with their addresses
+func printPeers(cmd *cobra.Command, infos []peer.AddrInfo) error {
+	peers := make([]dhtPeer, 0, len(infos))
+	for _, info := range infos {
+		p := dhtPeer{ID: info.ID.String(), Addrs: []string{}}
+		for _, addr := range info.Addrs {
+			p.Addrs = append(p.Addrs, fmt.Sprintf("%s/p2p/%s", addr, info.ID))
+		}
+		peers = append(peers, p)
+	}
+	return printOutput(cmd, peers, func() {
+		for _, p := range peers {
+			fmt.Println(p.ID)
+			for _, addr := range p.Addrs {
+				fmt.Printf("  %s\n", addr)
+			}
+		}
+	})
+}
+
+// newDHTCommand looks things up in the DHT from a throwaway node
+func newDHTCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dht",
+		Short: "Look up peers, providers and values in the DHT",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "find-peer <peer>",
+		Short: "Find the addresses of a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runDHTFindPeer,
+	})
+	findProviders := &cobra.Command{
+		Use:   "find-providers <cid>",
+		Short: "Find the peers that provide a CID",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runDHTFindProviders,
+	}
+	findProviders.Flags().Int("limit", 20, "Maximum number of providers to find")
+	cmd.AddCommand(findProviders)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get <key>",
+		Short: "Get the best value of a key, such as /pk/<peer-id>",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runDHTGet,
+	})
+	cmd.PersistentFlags().StringP("config", "c", "", "Configuration file path")
+	cmd.PersistentFlags().Duration("timeout", time.Minute, "Timeout for the lookup")
+	return cmd
+}
+
+// withLookupNode runs fn with a throwaway node whose routing table is
+// filled, within --timeout
+func withLookupNode(cmd *cobra.Command, fn func(ctx context.Context, node *libp2plearn.Node) error) error {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	node, err := startLookupNode(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+	return fn(ctx, node)
+}
+
+func runDHTFindPeer(cmd *cobra.Command, args []string) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	id, err := contacts.ResolveID(args[0])
+	if err != nil {
+		return err
+	}
+	return withLookupNode(cmd, func(ctx context.Context, node *libp2plearn.Node) error {
+		info, err := node.DHT().FindPeer(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to find peer: %w", err)
+		}
+		return printPeers(cmd, []peer.AddrInfo{info})
+	})
+}
+
+func runDHTFindProviders(cmd *cobra.Command, args []string) error {
+	key, err := cid.Decode(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid CID: %w", err)
+	}
+	limit, _ := cmd.Flags().GetInt("limit")
+	return withLookupNode(cmd, func(ctx context.Context, node *libp2plearn.Node) error {
+		var providers []peer.AddrInfo
+		for info := range node.DHT().FindProvidersAsync(ctx, key, limit) {
+			providers = append(providers, info)
+		}
+		if len(providers) == 0 {
+			return fmt.Errorf("no providers of %s found", key)
+		}
+		return printPeers(cmd, providers)
+	})
+}
+
+func runDHTGet(cmd *cobra.Command, args []string) error {
+	return withLookupNode(cmd, func(ctx context.Context, node *libp2plearn.Node) error {
+		d := node.DHT()
+		if strings.HasPrefix(args[0], "/"+libp2plearn.AppNamespace+"/") {
+			d = node.AppDHT()
+		}
+		value, err := d.GetValue(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to get value: %w", err)
+		}
+		result := dhtValue{Key: args[0], Value: value}
+		return printOutput(cmd, result, func() { fmt.Printf("%s\n", value) })
+	})
+}
@@ -2,34 +2,172 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+	"libp2p-learn/pkg/libp2plearn/discovery"
 )
 
 func main() {
+	initService()
+
 	var rootCmd = &cobra.Command{
-		Use:   "libp2p-node",
-		Short: "A libp2p node with TCP/UDP/WebSocket support and hole punching",
-		Run:   runNode,
+		Use:               "libp2p-node",
+		Short:             "A libp2p node with TCP/UDP/WebSocket support and hole punching",
+		Run:               runNode,
+		PersistentPreRunE: checkOutput,
 	}
 
 	var port int
+	var tcpPort, quicPort, wsPort, wssPort int
 	var enableRelay bool
 	var bootstrap []string
+	var interfaces []string
 	var configFile string
 	var enableWebSocket bool
+	var enableGateway bool
+	var gatewayAddr string
+	var enableHTTPService bool
+	var proxyAddr string
+	var proxyStrict bool
+	var dialStrategy string
+	var prewarm bool
+	var reconnect bool
+	var autonatService bool
+	var offline bool
+	var dhtMode string
+	var enableMDNS bool
+	var uploadLimit, downloadLimit int
+	var maxStreams int
+	var enableQoS bool
+	var connectTimeout time.Duration
+	var identityFile string
+	var adminPeers []string
+	var logCollector string
+	var kvSpaces []string
+	var timeSyncPeers []string
+	var raftPeers []string
+	var enableShell bool
+	var shellPeers []string
+	var expose []string
+	var exposeAllow []string
+	var services []string
+	var plugins []string
+	var wasmHandlers []string
+	var scripts []string
+	var secureChat bool
+	var outbox, mailbox, rooms bool
+	var mailboxPeers []string
+	var auditLog string
+	var reputation bool
+	var datastore, datastorePath string
+	var metrics []string
+	var metricsAddr string
+	var adminHTTP string
 
+	rootCmd.PersistentFlags().StringP("output", "o", outputText, "Output format of command results: text or json")
+	rootCmd.PersistentFlags().String("contacts", libp2plearn.DefaultContactsFile, "Address book file that names peers for commands")
 	rootCmd.Flags().IntVarP(&port, "port", "p", 0, "Port to listen on (0 for random)")
+	rootCmd.Flags().IntVar(&tcpPort, "tcp-port", 0, "TCP port (overrides --port, 0 for random)")
+	rootCmd.Flags().IntVar(&quicPort, "quic-port", 0, "QUIC port (overrides --port, 0 for random)")
+	rootCmd.Flags().IntVar(&wsPort, "ws-port", 0, "WebSocket port (overrides --port, 0 for random)")
+	rootCmd.Flags().IntVar(&wssPort, "wss-port", 0, "Secure WebSocket port (overrides --port, 0 for random)")
 	rootCmd.Flags().BoolVarP(&enableRelay, "relay", "r", false, "Enable relay functionality")
 	rootCmd.Flags().StringArrayVarP(&bootstrap, "bootstrap", "b", nil, "Bootstrap peer addresses")
+	rootCmd.Flags().StringArrayVarP(&interfaces, "interface", "i", nil, "Network interfaces to listen on (default all)")
 	rootCmd.Flags().StringVarP(&configFile, "config", "c", "", "Configuration file path")
 	rootCmd.Flags().BoolVarP(&enableWebSocket, "websocket", "w", true, "Enable WebSocket transport")
+	rootCmd.Flags().BoolVar(&enableGateway, "gateway", false, "Enable HTTP gateway to peer protocols")
+	rootCmd.Flags().StringVar(&gatewayAddr, "gateway-addr", "", "HTTP gateway listen address")
+	rootCmd.Flags().BoolVar(&enableHTTPService, "http-service", false, "Serve HTTP over libp2p streams")
+	rootCmd.Flags().StringVar(&proxyAddr, "proxy", "", "SOCKS5 proxy for outbound TCP/WebSocket dials (e.g. 127.0.0.1:9050)")
+	rootCmd.Flags().BoolVar(&proxyStrict, "proxy-strict", false, "Refuse dials that cannot go through the proxy")
+	rootCmd.Flags().StringVar(&dialStrategy, "dial-strategy", "", "Dial strategy: smart, staggered, parallel or measured")
+	rootCmd.Flags().DurationVar(&connectTimeout, "connect-timeout", 0, "Overall timeout for connecting to a peer")
+	rootCmd.Flags().IntVar(&uploadLimit, "peer-upload-limit", 0, "Upload cap per peer in bytes/s (0 for unlimited)")
+	rootCmd.Flags().IntVar(&downloadLimit, "peer-download-limit", 0, "Download cap per peer in bytes/s (0 for unlimited)")
+	rootCmd.Flags().IntVar(&maxStreams, "max-streams-per-peer", 0, "Inbound streams a peer may have open per protocol (default 32)")
+	rootCmd.Flags().BoolVar(&enableQoS, "qos", false, "Slow low-priority streams while higher-priority traffic is active")
+	rootCmd.Flags().BoolVar(&reconnect, "reconnect", false, "Reconnect to last-known peers saved from previous runs")
+	rootCmd.Flags().BoolVar(&prewarm, "prewarm", false, "Open connections to pinned and DHT-closest peers at startup")
+	rootCmd.Flags().BoolVar(&autonatService, "autonat-service", false, "Dial peers back so they learn whether they are reachable")
+	rootCmd.Flags().BoolVar(&offline, "offline", false, "Run without internet access: local peers and mDNS only, DHT writes wait for peers")
+	rootCmd.Flags().StringVar(&dhtMode, "dht-mode", "", "DHT mode: auto, auto-server, client or server")
+	rootCmd.Flags().BoolVar(&enableMDNS, "mdns", false, "Discover peers on the local network with mDNS")
+	rootCmd.Flags().StringVar(&identityFile, "identity", "", "Private key file that keeps the peer ID across restarts")
+	rootCmd.Flags().StringArrayVar(&adminPeers, "admin-peer", nil, "Peer ID allowed to run remote admin commands")
+	rootCmd.Flags().StringVar(&logCollector, "log-collector", "", "Multiaddr of a peer to stream logs to")
+	rootCmd.Flags().StringArrayVar(&kvSpaces, "kv-space", nil, "Shared key/value space to join")
+	rootCmd.Flags().StringArrayVar(&timeSyncPeers, "time-sync-peer", nil, "Peer ID whose clock offset to measure")
+	rootCmd.Flags().StringArrayVar(&raftPeers, "raft-peer", nil, "Peer ID of another member of the replicated log")
+	rootCmd.Flags().BoolVar(&enableShell, "enable-shell", false, "Let --shell-peer peers run commands on this node")
+	rootCmd.Flags().StringArrayVar(&shellPeers, "shell-peer", nil, "Peer ID allowed to open remote shells")
+	rootCmd.Flags().StringArrayVar(&expose, "expose", nil, "Expose a local TCP service to peers as <protocol>=<host:port>")
+	rootCmd.Flags().StringArrayVar(&exposeAllow, "expose-allow", nil, "Peer ID allowed to use exposed services (\"*\" for any peer)")
+	rootCmd.Flags().StringArrayVar(&services, "service", nil, "Application service to advertise to peers (e.g. relay, mailbox)")
+	rootCmd.Flags().StringArrayVar(&plugins, "plugin", nil, "Manifest of an external plugin to run")
+	rootCmd.Flags().StringArrayVar(&wasmHandlers, "wasm-handler", nil, "Serve a protocol with a sandboxed WASM module as <protocol>=<module.wasm>")
+	rootCmd.Flags().StringArrayVar(&scripts, "script", nil, "Starlark script to run on ping and chat messages and new peers")
+	rootCmd.Flags().BoolVar(&secureChat, "secure-chat", false, "Encrypt chat sessions end to end with a double ratchet")
+	rootCmd.Flags().BoolVar(&outbox, "outbox", false, "Queue messages for unreachable peers and deliver them when they connect")
+	rootCmd.Flags().BoolVar(&mailbox, "mailbox", false, "Keep sealed chat messages for offline peers")
+	rootCmd.Flags().StringArrayVar(&mailboxPeers, "mailbox-peer", nil, "Peer ID of a mailbox node that holds our messages while we are offline")
+	rootCmd.Flags().BoolVar(&rooms, "rooms", false, "Sync the member lists of private chat rooms with their members")
+	rootCmd.Flags().StringVar(&auditLog, "audit-log", "", "Append-only audit log of inbound streams and admin actions")
+	rootCmd.Flags().BoolVar(&reputation, "reputation", false, "Gate and ban misbehaving peers, remembering them across restarts")
+	rootCmd.Flags().StringVar(&datastore, "datastore", "", "Datastore for the peerstore, DHT and blobs (memory, fs, badger, s3)")
+	rootCmd.Flags().StringVar(&datastorePath, "datastore-path", "", "Directory of the fs or badger datastore")
+	rootCmd.Flags().StringArrayVar(&metrics, "metrics", nil, "Metrics exporter to enable (prometheus, statsd, otlp)")
+	rootCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Listen address of the Prometheus metrics endpoint")
+	rootCmd.Flags().StringVar(&adminHTTP, "admin-http", "", "Listen address of the /healthz and /readyz endpoints")
+
+	rootCmd.AddCommand(newAdminCommand())
+	rootCmd.AddCommand(newPushConfigCommand())
+	rootCmd.AddCommand(newForwardCommand())
+	rootCmd.AddCommand(newShellCommand())
+	rootCmd.AddCommand(newSendDirCommand())
+	rootCmd.AddCommand(newRecvDirCommand())
+	rootCmd.AddCommand(newFindServiceCommand())
+	rootCmd.AddCommand(newHealthCommand())
+	rootCmd.AddCommand(newDescribeCommand())
+	rootCmd.AddCommand(newSchemasCommand())
+	rootCmd.AddCommand(newPingCommand())
+	rootCmd.AddCommand(newClusterCommand())
+	rootCmd.AddCommand(newIssueTokenCommand())
+	rootCmd.AddCommand(newAuditCommand())
+	rootCmd.AddCommand(newPinCommand())
+	rootCmd.AddCommand(newRepoCommand())
+	rootCmd.AddCommand(newNATStatusCommand())
+	rootCmd.AddCommand(newServiceCommand())
+	rootCmd.AddCommand(newBootstrapServerCommand())
+	rootCmd.AddCommand(newSoakCommand())
+	rootCmd.AddCommand(newIDCommand())
+	rootCmd.AddCommand(newPeersCommand())
+	rootCmd.AddCommand(newStatsCommand())
+	rootCmd.AddCommand(newDHTCommand())
+	rootCmd.AddCommand(newChatCommand())
+	rootCmd.AddCommand(newLatencyCommand())
+	rootCmd.AddCommand(newTransportsCommand())
+	rootCmd.AddCommand(newStreamsCommand())
+	rootCmd.AddCommand(newMaintenanceCommand())
+	rootCmd.AddCommand(newLogLevelCommand())
+	rootCmd.AddCommand(newContactsCommand())
+	rootCmd.AddCommand(newRoomCommand())
+	rootCmd.AddCommand(newInviteCommand())
+	rootCmd.AddCommand(newJoinCommand())
+	rootCmd.AddCommand(newPairCommand())
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
@@ -42,7 +180,7 @@ func runNode(cmd *cobra.Command, args []string) {
 
 	// Load configuration
 	configFile, _ := cmd.Flags().GetString("config")
-	config, err := LoadConfig(configFile)
+	config, err := libp2plearn.LoadConfig(configFile)
 	if err != nil {
 		log.Fatal("Failed to load config:", err)
 	}
@@ -51,15 +189,175 @@ func runNode(cmd *cobra.Command, args []string) {
 	if port, _ := cmd.Flags().GetInt("port"); port != 0 {
 		config.ListenPort = port
 	}
+	if cmd.Flags().Changed("tcp-port") {
+		tcpPort, _ := cmd.Flags().GetInt("tcp-port")
+		config.TCPPort = &tcpPort
+	}
+	if cmd.Flags().Changed("quic-port") {
+		quicPort, _ := cmd.Flags().GetInt("quic-port")
+		config.QUICPort = &quicPort
+	}
+	if cmd.Flags().Changed("ws-port") {
+		wsPort, _ := cmd.Flags().GetInt("ws-port")
+		config.WSPort = &wsPort
+	}
+	if cmd.Flags().Changed("wss-port") {
+		wssPort, _ := cmd.Flags().GetInt("wss-port")
+		config.WSSPort = &wssPort
+	}
 	if enableRelay, _ := cmd.Flags().GetBool("relay"); enableRelay {
 		config.EnableRelay = true
 	}
 	if bootstrap, _ := cmd.Flags().GetStringArray("bootstrap"); len(bootstrap) > 0 {
 		config.BootstrapPeers = bootstrap
 	}
+	if interfaces, _ := cmd.Flags().GetStringArray("interface"); len(interfaces) > 0 {
+		config.Interfaces = interfaces
+	}
 	if enableWebSocket, _ := cmd.Flags().GetBool("websocket"); !enableWebSocket {
 		config.EnableWebSocket = false
 	}
+	if enableGateway, _ := cmd.Flags().GetBool("gateway"); enableGateway {
+		config.EnableGateway = true
+	}
+	if gatewayAddr, _ := cmd.Flags().GetString("gateway-addr"); gatewayAddr != "" {
+		config.GatewayAddr = gatewayAddr
+	}
+	if enableHTTPService, _ := cmd.Flags().GetBool("http-service"); enableHTTPService {
+		config.EnableHTTPService = true
+	}
+	if proxyAddr, _ := cmd.Flags().GetString("proxy"); proxyAddr != "" {
+		config.ProxyAddr = proxyAddr
+	}
+	if proxyStrict, _ := cmd.Flags().GetBool("proxy-strict"); proxyStrict {
+		config.ProxyStrict = true
+	}
+	if dialStrategy, _ := cmd.Flags().GetString("dial-strategy"); dialStrategy != "" {
+		config.DialStrategy = dialStrategy
+	}
+	if connectTimeout, _ := cmd.Flags().GetDuration("connect-timeout"); connectTimeout > 0 {
+		config.ConnectTimeout = libp2plearn.Duration(connectTimeout)
+	}
+	if prewarm, _ := cmd.Flags().GetBool("prewarm"); prewarm {
+		config.EnablePrewarm = true
+	}
+	if reconnect, _ := cmd.Flags().GetBool("reconnect"); reconnect {
+		config.EnableReconnect = true
+	}
+	if autonatService, _ := cmd.Flags().GetBool("autonat-service"); autonatService {
+		config.EnableAutoNATService = true
+	}
+	if offline, _ := cmd.Flags().GetBool("offline"); offline {
+		config.Offline = true
+	}
+	if dhtMode, _ := cmd.Flags().GetString("dht-mode"); dhtMode != "" {
+		config.DHTMode = dhtMode
+	}
+	if enableMDNS, _ := cmd.Flags().GetBool("mdns"); enableMDNS {
+		config.EnableMDNS = true
+	}
+	if enableQoS, _ := cmd.Flags().GetBool("qos"); enableQoS {
+		config.EnableQoS = true
+	}
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	if adminPeers, _ := cmd.Flags().GetStringArray("admin-peer"); len(adminPeers) > 0 {
+		config.AdminPeers = adminPeers
+	}
+	if logCollector, _ := cmd.Flags().GetString("log-collector"); logCollector != "" {
+		config.LogCollector = logCollector
+	}
+	if kvSpaces, _ := cmd.Flags().GetStringArray("kv-space"); len(kvSpaces) > 0 {
+		config.KVSpaces = kvSpaces
+	}
+	if timeSyncPeers, _ := cmd.Flags().GetStringArray("time-sync-peer"); len(timeSyncPeers) > 0 {
+		config.TimeSyncPeers = timeSyncPeers
+	}
+	if raftPeers, _ := cmd.Flags().GetStringArray("raft-peer"); len(raftPeers) > 0 {
+		config.RaftPeers = raftPeers
+	}
+	if services, _ := cmd.Flags().GetStringArray("service"); len(services) > 0 {
+		config.Services = services
+	}
+	if plugins, _ := cmd.Flags().GetStringArray("plugin"); len(plugins) > 0 {
+		config.Plugins = plugins
+	}
+	if wasmHandlers, _ := cmd.Flags().GetStringArray("wasm-handler"); len(wasmHandlers) > 0 {
+		for _, w := range wasmHandlers {
+			proto, module, ok := strings.Cut(w, "=")
+			if !ok {
+				log.Fatalf("Invalid --wasm-handler %q: expected <protocol>=<module.wasm>", w)
+			}
+			config.WASMHandlers = append(config.WASMHandlers, libp2plearn.WASMHandlerConfig{Protocol: proto, Module: module})
+		}
+	}
+	if scripts, _ := cmd.Flags().GetStringArray("script"); len(scripts) > 0 {
+		for _, file := range scripts {
+			config.Scripts = append(config.Scripts, libp2plearn.ScriptConfig{File: file})
+		}
+	}
+	if secureChat, _ := cmd.Flags().GetBool("secure-chat"); secureChat {
+		config.EnableSecureChat = true
+	}
+	if outbox, _ := cmd.Flags().GetBool("outbox"); outbox {
+		config.EnableOutbox = true
+	}
+	if mailbox, _ := cmd.Flags().GetBool("mailbox"); mailbox {
+		config.EnableMailbox = true
+	}
+	if mailboxPeers, _ := cmd.Flags().GetStringArray("mailbox-peer"); len(mailboxPeers) > 0 {
+		config.MailboxPeers = mailboxPeers
+	}
+	if rooms, _ := cmd.Flags().GetBool("rooms"); rooms {
+		config.EnableRooms = true
+	}
+	if auditLog, _ := cmd.Flags().GetString("audit-log"); auditLog != "" {
+		config.AuditLog = auditLog
+	}
+	if reputation, _ := cmd.Flags().GetBool("reputation"); reputation {
+		config.EnableReputation = true
+	}
+	if datastore, _ := cmd.Flags().GetString("datastore"); datastore != "" {
+		config.Datastore = datastore
+	}
+	if datastorePath, _ := cmd.Flags().GetString("datastore-path"); datastorePath != "" {
+		config.DatastorePath = datastorePath
+	}
+	if metrics, _ := cmd.Flags().GetStringArray("metrics"); len(metrics) > 0 {
+		config.MetricsExporters = metrics
+	}
+	if metricsAddr, _ := cmd.Flags().GetString("metrics-addr"); metricsAddr != "" {
+		config.MetricsAddr = metricsAddr
+	}
+	if adminHTTP, _ := cmd.Flags().GetString("admin-http"); adminHTTP != "" {
+		config.AdminHTTPAddr = adminHTTP
+	}
+	if enableShell, _ := cmd.Flags().GetBool("enable-shell"); enableShell {
+		config.EnableShell = true
+	}
+	if shellPeers, _ := cmd.Flags().GetStringArray("shell-peer"); len(shellPeers) > 0 {
+		config.ShellPeers = shellPeers
+	}
+	if expose, _ := cmd.Flags().GetStringArray("expose"); len(expose) > 0 {
+		allow, _ := cmd.Flags().GetStringArray("expose-allow")
+		for _, e := range expose {
+			proto, target, ok := strings.Cut(e, "=")
+			if !ok {
+				log.Fatalf("Invalid --expose %q: expected <protocol>=<host:port>", e)
+			}
+			config.Expose = append(config.Expose, libp2plearn.ExposeConfig{Protocol: proto, Target: target, Allow: allow})
+		}
+	}
+	if uploadLimit, _ := cmd.Flags().GetInt("peer-upload-limit"); uploadLimit > 0 {
+		config.PeerBandwidth.Upload = uploadLimit
+	}
+	if downloadLimit, _ := cmd.Flags().GetInt("peer-download-limit"); downloadLimit > 0 {
+		config.PeerBandwidth.Download = downloadLimit
+	}
+	if cmd.Flags().Changed("max-streams-per-peer") {
+		config.MaxStreamsPerPeer, _ = cmd.Flags().GetInt("max-streams-per-peer")
+	}
 
 	// Validate configuration
 	if err := config.Validate(); err != nil {
@@ -74,47 +372,58 @@ func runNode(cmd *cobra.Command, args []string) {
 	fmt.Printf("Starting libp2p node...\n")
 	fmt.Printf("Configuration:\n")
 	fmt.Printf("  Port: %d\n", config.ListenPort)
+	ports := config.TransportPorts()
+	fmt.Printf("  Transport Ports: tcp=%d quic=%d ws=%d wss=%d\n", ports.TCP, ports.QUIC, ports.WS, ports.WSS)
+	if len(config.Interfaces) > 0 {
+		fmt.Printf("  Interfaces: %v\n", config.Interfaces)
+	}
 	fmt.Printf("  Enable Relay: %t\n", config.EnableRelay)
 	fmt.Printf("  Enable Hole Punching: %t\n", config.EnableHolePunch)
 	fmt.Printf("  Enable WebSocket: %t\n", config.EnableWebSocket)
+	fmt.Printf("  Enable Gateway: %t\n", config.EnableGateway)
+	fmt.Printf("  Enable HTTP Service: %t\n", config.EnableHTTPService)
+	if config.ProxyAddr != "" {
+		fmt.Printf("  Proxy: %s (strict: %t)\n", config.ProxyAddr, config.ProxyStrict)
+	}
+	fmt.Printf("  Dial Strategy: %s (connect timeout: %s)\n", config.DialStrategy, time.Duration(config.ConnectTimeout))
 	fmt.Printf("  Max Connections: %d\n", config.MaxConnections)
 	fmt.Printf("  Bootstrap Peers: %d\n", len(config.BootstrapPeers))
 
 	// Create the libp2p node
 	fmt.Println("Creating libp2p node...")
-	node, err := createNodeWithOptions(ctx, config.ListenPort, config.EnableRelay, config.EnableWebSocket)
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
 	if err != nil {
 		log.Fatal("Failed to create node:", err)
 	}
-	defer node.Close()
 
 	fmt.Printf("Node started successfully!\n")
-	fmt.Printf("Node ID: %s\n", node.ID())
+	fmt.Printf("Node ID: %s\n", node.Host().ID())
 	fmt.Printf("Listening addresses:\n")
-	for _, addr := range node.Addrs() {
-		fmt.Printf("  %s/p2p/%s\n", addr, node.ID())
+	for _, addr := range node.Host().Addrs() {
+		fmt.Printf("  %s/p2p/%s\n", addr, node.Host().ID())
 	}
 
-	// Set up protocols
-	protocolHandler := NewProtocolHandler(node)
-	protocolHandler.SetupProtocols()
-
-	// Bootstrap process
 	if len(config.BootstrapPeers) > 0 {
 		fmt.Printf("Bootstrapping with %d peers...\n", len(config.BootstrapPeers))
-		if err := bootstrapPeers(ctx, node, config.BootstrapPeers); err != nil {
-			log.Printf("Bootstrap error: %v", err)
-		}
+	}
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		log.Fatal("Failed to start node:", err)
 	}
 
 	fmt.Println("\nNode is running. Features enabled:")
 	fmt.Printf("  ✓ TCP Transport\n")
-	fmt.Printf("  ✓ UDP/QUIC Transport\n")
+	if !config.ProxyStrict {
+		fmt.Printf("  ✓ UDP/QUIC Transport\n")
+	}
 	if config.EnableWebSocket {
 		fmt.Printf("  ✓ WebSocket/WSS Transport\n")
 	}
 	fmt.Printf("  ✓ Connection Management (max: %d)\n", config.MaxConnections)
-	if config.EnableHolePunch {
+	if config.Datastore != libp2plearn.DatastoreMemory {
+		fmt.Printf("  ✓ Persistent Datastore (%s)\n", config.Datastore)
+	}
+	if config.EnableHolePunch && !config.ProxyStrict {
 		fmt.Printf("  ✓ Hole Punching/NAT Traversal\n")
 	}
 	if config.EnableRelay {
@@ -123,30 +432,477 @@ func runNode(cmd *cobra.Command, args []string) {
 	if config.EnableAutoNAT {
 		fmt.Printf("  ✓ AutoNAT\n")
 	}
+	if config.EnableGateway {
+		fmt.Printf("  ✓ HTTP Gateway (http://%s/p2p/<peer>/<protocol>)\n", config.GatewayAddr)
+	}
+	if config.EnableHTTPService {
+		fmt.Printf("  ✓ HTTP over libp2p\n")
+	}
+	if config.AdminHTTPAddr != "" {
+		fmt.Printf("  ✓ Liveness/Readiness (http://%s/healthz, /readyz)\n", config.AdminHTTPAddr)
+	}
+	if len(config.MetricsExporters) > 0 {
+		fmt.Printf("  ✓ Metrics (%s)\n", strings.Join(config.MetricsExporters, ", "))
+	}
+	if config.ProxyAddr != "" {
+		fmt.Printf("  ✓ SOCKS5 Proxy Dialing (%s)\n", config.ProxyAddr)
+	}
+	if config.EnablePrewarm {
+		fmt.Printf("  ✓ Connection Prewarming (%d pinned, %d closest)\n", len(config.PinnedPeers), config.PrewarmClosest)
+	}
+	if config.BandwidthLimited() {
+		fmt.Printf("  ✓ Bandwidth Throttling (per peer up: %d B/s, down: %d B/s)\n", config.PeerBandwidth.Upload, config.PeerBandwidth.Download)
+	}
+	if config.EnableQoS {
+		fmt.Printf("  ✓ Stream QoS Priorities\n")
+	}
+	if config.EnableAutoNATService {
+		fmt.Printf("  ✓ AutoNAT Service (%d dial-backs per %s, %d per peer)\n", config.AutoNATServiceRate, time.Duration(config.AutoNATServiceInterval), config.AutoNATServicePeerRate)
+	}
+	if config.EnableReconnect {
+		fmt.Printf("  ✓ Reconnect to Last-Known Peers (%s)\n", config.PeerHistoryFile)
+	}
+	if len(config.FailoverPeers) > 0 {
+		fmt.Printf("  ✓ Transport Failover (%d peers)\n", len(config.FailoverPeers))
+	}
+	if len(config.AdminPeers) > 0 {
+		fmt.Printf("  ✓ Remote Admin (%d admin peers)\n", len(config.AdminPeers))
+	}
+	if config.EnableSecureChat {
+		fmt.Printf("  ✓ End-to-End Encrypted Chat (%s)\n", config.SecureChatFile)
+	}
+	if config.EnableOutbox {
+		fmt.Printf("  ✓ Outbox (%s, %d mailbox peers)\n", config.OutboxFile, len(config.MailboxPeers))
+	}
+	if config.EnableMailbox {
+		fmt.Printf("  ✓ Mailbox (%d messages per peer)\n", config.MailboxMaxPerPeer)
+	}
+	if config.EnableRooms {
+		fmt.Printf("  ✓ Private Rooms (%s)\n", config.RoomsFile)
+	}
+	if config.EnableReputation {
+		fmt.Printf("  ✓ Peer Reputation (%s)\n", config.ReputationFile)
+	}
+	if config.AuditLog != "" {
+		fmt.Printf("  ✓ Audit Log (%s)\n", config.AuditLog)
+	}
+	if config.EnableShell {
+		fmt.Printf("  ✓ Remote Shell (%d authorized peers)\n", len(config.ShellPeers))
+	}
+	if config.LogCollector != "" {
+		fmt.Printf("  ✓ Log Streaming (%s and above)\n", config.LogForwardLevel)
+	}
+	if len(config.CollectLogsFrom) > 0 {
+		fmt.Printf("  ✓ Log Collector (%d sources)\n", len(config.CollectLogsFrom))
+	}
+	for _, e := range config.Expose {
+		fmt.Printf("  ✓ Exposing %s as %s (%d allowed)\n", e.Target, e.Protocol, len(e.Allow))
+	}
+	for _, f := range config.Forwards {
+		fmt.Printf("  ✓ Forwarding %s to %s\n", f.Listen, f.Target)
+	}
+	if len(config.TimeSyncPeers) > 0 {
+		fmt.Printf("  ✓ Clock Synchronization (%d peers every %s)\n", len(config.TimeSyncPeers), time.Duration(config.TimeSyncInterval))
+	}
+	if len(config.KVSpaces) > 0 {
+		fmt.Printf("  ✓ Shared Key/Value Spaces (%v)\n", config.KVSpaces)
+	}
+	if len(config.RaftPeers) > 0 {
+		fmt.Printf("  ✓ Replicated Log (%d members)\n", len(config.RaftPeers)+1)
+	}
+	if len(config.Services) > 0 {
+		fmt.Printf("  ✓ Advertised Services (%v)\n", config.Services)
+	}
+	if len(config.Plugins) > 0 {
+		fmt.Printf("  ✓ Plugins (%d)\n", len(config.Plugins))
+	}
+	if len(config.WASMHandlers) > 0 {
+		fmt.Printf("  ✓ WASM Handlers (%d)\n", len(config.WASMHandlers))
+	}
+	if len(config.Scripts) > 0 {
+		fmt.Printf("  ✓ Scripts (%d)\n", len(config.Scripts))
+	}
+	if len(config.MultipathPeers) > 0 {
+		fmt.Printf("  ✓ Multipath Streams (%s over %v)\n", config.MultipathPolicy, config.MultipathTransports)
+	}
 
 	// Show peer info periodically
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
-		
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				printPeerInfo(node)
+				discovery.PrintPeerInfo(node.Host())
 			}
 		}
 	}()
 
 	fmt.Println("\nPress Ctrl+C to stop...")
+	runUntilStopped(func() {
+		fmt.Println("\nShutting down...")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := node.Stop(shutdownCtx); err != nil {
+			log.Printf("Shutdown error: %v", err)
+		}
+		fmt.Println("Node stopped")
+	})
+}
 
-	// Wait for interrupt signal
+// newBootstrapServerCommand runs a seed node for others to bootstrap from
+func newBootstrapServerCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bootstrap-server",
+		Short: "Run a bootstrap server that other nodes join the network through",
+		Long: `Run a bootstrap server that other nodes join the network through.
+
+The server always serves the DHT, relays for peers behind NATs, dials peers
+back for AutoNAT and keeps up to 2000 connections, but serves none of the
+application protocols. --config settings override these defaults. The
+addresses clients can bootstrap from are printed at startup and whenever
+they change. Use --identity so they stay the same across restarts.`,
+		Args: cobra.NoArgs,
+		RunE: runBootstrapServer,
+	}
+	cmd.Flags().StringP("config", "c", "", "Configuration file path")
+	cmd.Flags().IntP("port", "p", 0, "Port to listen on (0 for random)")
+	cmd.Flags().StringP("identity", "k", "", "Private key file that keeps the peer ID across restarts")
+	cmd.Flags().StringArrayP("bootstrap", "b", nil, "Other bootstrap servers to peer with")
+	return cmd
+}
+
+func runBootstrapServer(cmd *cobra.Command, args []string) error {
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadBootstrapServerConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if port, _ := cmd.Flags().GetInt("port"); port != 0 {
+		config.ListenPort = port
+	}
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	if bootstrap, _ := cmd.Flags().GetStringArray("bootstrap"); len(bootstrap) > 0 {
+		config.BootstrapPeers = bootstrap
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return err
+	}
+	if config.IdentityFile == "" {
+		fmt.Println("Warning: without --identity the peer ID, and so every address below, changes on restart")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return err
+	}
+	// Subscribe before starting, so no address change is missed
+	events, unsubscribe := node.SubscribeEvents(libp2plearn.EventAddressesUpdated)
+	defer unsubscribe()
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		return err
+	}
+
+	fmt.Printf("Bootstrap server %s (DHT %s, %d relay reservations, %d-%d connections)\n",
+		node.Host().ID(), config.DHTMode, config.RelayMaxReservations, config.LowWater, config.HighWater)
+	var printed []string
+	printAddrs := func() {
+		addrs := bootstrapAddrs(node)
+		if slices.Equal(addrs, printed) {
+			return
+		}
+		printed = addrs
+		snippet, _ := json.MarshalIndent(map[string][]string{"bootstrap_peers": addrs}, "", "  ")
+		fmt.Printf("\nClients can bootstrap from these addresses, e.g. in their config file:\n%s\n", snippet)
+	}
+	printAddrs()
+	go func() {
+		for range events {
+			printAddrs()
+		}
+	}()
+
+	runUntilStopped(func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := node.Stop(shutdownCtx); err != nil {
+			log.Printf("Shutdown error: %v", err)
+		}
+	})
+	return nil
+}
+
+// bootstrapAddrs returns the addresses a node advertises, except loopback
+// ones, as full multiaddrs clients can dial
+func bootstrapAddrs(node *libp2plearn.Node) []string {
+	var addrs []string
+	for _, addr := range node.Host().Addrs() {
+		if manet.IsIPLoopback(addr) {
+			continue
+		}
+		addrs = append(addrs, fmt.Sprintf("%s/p2p/%s", addr, node.Host().ID()))
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// newServiceCommand installs and controls the node as a Windows service
+func newServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Install and control the node as a Windows service",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "install [-- node flags...]",
+		Short: "Install the service, which runs the node with the given flags at boot",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := installService(args); err != nil {
+				return err
+			}
+			fmt.Println("✓ Service installed")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := uninstallService(); err != nil {
+				return err
+			}
+			fmt.Println("✓ Service uninstalled")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "start",
+		Short: "Start the service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startService(); err != nil {
+				return err
+			}
+			fmt.Println("✓ Service started")
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stop",
+		Short: "Stop the service and wait until the node has shut down",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := stopService(); err != nil {
+				return err
+			}
+			fmt.Println("✓ Service stopped")
+			return nil
+		},
+	})
+	return cmd
+}
+
+// waitForSignal blocks until an interrupt or termination signal arrives
+func waitForSignal() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
+}
+
+// newForwardCommand forwards a local TCP port to a service exposed by a peer
+func newForwardCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "forward",
+		Short: "Forward a local TCP port to a service a peer exposes with --expose",
+		Args:  cobra.NoArgs,
+		RunE:  runForward,
+	}
+	cmd.Flags().StringP("listen", "l", "", "Local address to accept connections on, e.g. 127.0.0.1:8080")
+	cmd.Flags().StringP("target", "t", "", "Service to forward to, as <peer ID or multiaddr>:<protocol>")
+	cmd.Flags().StringP("identity", "k", "", "Private key file, so the exposing node can allow this peer")
+	cmd.Flags().StringP("config", "c", "", "Configuration file path")
+	cmd.MarkFlagRequired("listen")
+	cmd.MarkFlagRequired("target")
+	return cmd
+}
+
+func runForward(cmd *cobra.Command, args []string) error {
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	listen, _ := cmd.Flags().GetString("listen")
+	target, _ := cmd.Flags().GetString("target")
+	config.Forwards = append(config.Forwards, libp2plearn.ForwardConfig{Listen: listen, Target: target})
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return err
+	}
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		return err
+	}
+
+	result := forwardResult{ID: node.Host().ID().String(), Forwards: config.Forwards}
+	if err := printOutput(cmd, result, func() {
+		fmt.Printf("Peer ID: %s\n", result.ID)
+		for _, f := range result.Forwards {
+			fmt.Printf("Forwarding %s to %s\n", f.Listen, f.Target)
+		}
+		fmt.Println("Press Ctrl+C to stop...")
+	}); err != nil {
+		node.Stop(context.Background())
+		return err
+	}
+	waitForSignal()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return node.Stop(shutdownCtx)
+}
+
+// newClusterCommand runs the nodes of a cluster file in this process
+func newClusterCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cluster <cluster.json>",
+		Short: "Run several nodes in one process with shared metrics and admin endpoints",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runCluster,
+	}
+}
+
+func runCluster(cmd *cobra.Command, args []string) error {
+	cluster, err := libp2plearn.LoadClusterConfig(args[0])
+	if err != nil {
+		return err
+	}
+	if err := cluster.Nodes[0].Config.SetupLogging(); err != nil {
+		return err
+	}
+
+	supervisor, err := libp2plearn.NewSupervisor(cluster)
+	if err != nil {
+		return err
+	}
+	if err := supervisor.Start(context.Background()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Cluster started with %d nodes:\n", len(cluster.Nodes))
+	for i, node := range supervisor.Nodes() {
+		fmt.Printf("  ✓ %s (%s)\n", cluster.Nodes[i].Name, node.Host().ID())
+		for _, addr := range node.Host().Addrs() {
+			fmt.Printf("      %s/p2p/%s\n", addr, node.Host().ID())
+		}
+	}
+	if cluster.AdminHTTPAddr != "" {
+		fmt.Printf("  ✓ Admin endpoints (http://%s/readyz, /nodes)\n", cluster.AdminHTTPAddr)
+	}
+
+	fmt.Println("\nPress Ctrl+C to stop...")
+	waitForSignal()
 
 	fmt.Println("\nShutting down...")
-	time.Sleep(500 * time.Millisecond)
-	fmt.Println("Node stopped")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Duration(len(cluster.Nodes))*time.Second)
+	defer cancel()
+	if err := supervisor.Stop(shutdownCtx); err != nil {
+		return err
+	}
+	fmt.Println("Cluster stopped")
+	return nil
+}
+
+// newSoakCommand runs a network of nodes in this process with churn,
+// watching for goroutine leaks, heap growth and stuck streams
+func newSoakCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "soak",
+		Short: "Run an in-process network with churn and check for leaks and stuck streams",
+		Args:  cobra.NoArgs,
+		RunE:  runSoak,
+	}
+	defaults := libp2plearn.DefaultSoakConfig()
+	cmd.Flags().IntP("nodes", "n", defaults.Nodes, "Number of nodes in the network")
+	cmd.Flags().DurationP("duration", "d", defaults.Duration, "How long to run")
+	cmd.Flags().Duration("churn", defaults.ChurnInterval, "Time between replacing a node")
+	cmd.Flags().Duration("sample", defaults.SampleInterval, "Time between samples")
+	cmd.Flags().Float64("max-goroutine-growth", defaults.MaxGoroutineGrowth, "Allowed goroutine growth over the run, as a fraction")
+	cmd.Flags().Float64("max-heap-growth", defaults.MaxHeapGrowth, "Allowed heap growth over the run, as a fraction")
+	return cmd
+}
+
+func runSoak(cmd *cobra.Command, args []string) error {
+	cfg := libp2plearn.DefaultSoakConfig()
+	cfg.Nodes, _ = cmd.Flags().GetInt("nodes")
+	cfg.Duration, _ = cmd.Flags().GetDuration("duration")
+	cfg.ChurnInterval, _ = cmd.Flags().GetDuration("churn")
+	cfg.SampleInterval, _ = cmd.Flags().GetDuration("sample")
+	cfg.MaxGoroutineGrowth, _ = cmd.Flags().GetFloat64("max-goroutine-growth")
+	cfg.MaxHeapGrowth, _ = cmd.Flags().GetFloat64("max-heap-growth")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	printSample := func(sample libp2plearn.SoakSample) {
+		fmt.Printf("%8s  nodes %3d  goroutines %6d  heap %6.1f MiB  streams %5d  stuck %d\n",
+			time.Duration(sample.Elapsed), sample.Nodes, sample.Goroutines,
+			float64(sample.HeapBytes)/(1<<20), sample.Streams, sample.Stuck)
+	}
+	// Samples are printed as they are taken, and with --output json only
+	// in the report
+	onSample := printSample
+	if outputIsJSON(cmd) {
+		onSample = func(libp2plearn.SoakSample) {}
+	}
+	report, err := libp2plearn.RunSoak(ctx, cfg, onSample)
+	if report != nil {
+		result := soakResult{SoakReport: *report, Passed: err == nil}
+		if result.Samples == nil {
+			result.Samples = []libp2plearn.SoakSample{}
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if perr := printOutput(cmd, result, func() {
+			fmt.Print("baseline")
+			printSample(report.Baseline)
+			fmt.Print("final   ")
+			printSample(report.Final)
+			fmt.Printf("%d joins, %d leaves, %d requests, %d failed\n", report.Joins, report.Leaves, report.Requests, report.Failures)
+			if result.Passed {
+				fmt.Println("✓ Soak passed")
+			}
+		}); perr != nil {
+			return perr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("soak failed: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// Values of --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// checkOutput rejects an unknown --output before any command runs
+func checkOutput(cmd *cobra.Command, args []string) error {
+	switch output, _ := cmd.Flags().GetString("output"); output {
+	case outputText, outputJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid --output %q: must be text or json", output)
+	}
+}
+
+// outputIsJSON reports whether the command should print JSON
+func outputIsJSON(cmd *cobra.Command) bool {
+	output, _ := cmd.Flags().GetString("output")
+	return output == outputJSON
+}
+
+// printOutput prints result as indented JSON with --output json, and
+// otherwise calls text to print it for people
+func printOutput(cmd *cobra.Command, result interface{}, text func()) error {
+	if !outputIsJSON(cmd) {
+		text()
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return nil
+}
+
+// peerIdentity is the result of the id command
+type peerIdentity struct {
+	ID              string   `json:"id"`
+	AgentVersion    string   `json:"agent_version,omitempty"`
+	ProtocolVersion string   `json:"protocol_version,omitempty"`
+	Addrs           []string `json:"addrs"`
+	Protocols       []string `json:"protocols"`
+}
+
+// dhtPeer is the result of dht find-peer and one provider of dht
+// find-providers
+type dhtPeer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// dhtValue is the result of dht get. Value is base64 in JSON.
+type dhtValue struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// inviteToken is the result of invite
+type inviteToken struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+	Token string   `json:"token"`
+}
+
+// joinResult is the result of join. RTT is 0 if the node didn't answer
+// pings.
+type joinResult struct {
+	ID   string               `json:"id"`
+	Name string               `json:"name,omitempty"`
+	Addr string               `json:"addr"`
+	RTT  libp2plearn.Duration `json:"rtt"`
+}
+
+// pairResult is the result of pair. Saved is whether the peer was pinned in
+// the configuration file.
+type pairResult struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Addr  string `json:"addr"`
+	Saved bool   `json:"saved"`
+}
+
+// pingResult is the result of ping. A lost ping has an Error and no RTT.
+type pingResult struct {
+	ID          string               `json:"id"`
+	Pings       []pingReply          `json:"pings"`
+	Sent        int                  `json:"sent"`
+	Lost        int                  `json:"lost"`
+	SmoothedRTT libp2plearn.Duration `json:"smoothed_rtt"`
+}
+
+// pingReply is one ping of a pingResult
+type pingReply struct {
+	Seq   int                  `json:"seq"`
+	RTT   libp2plearn.Duration `json:"rtt,omitempty"`
+	Error string               `json:"error,omitempty"`
+}
+
+// issuedToken is the result of issue-token
+type issuedToken struct {
+	Token    string    `json:"token"`
+	Subject  string    `json:"subject"`
+	Audience string    `json:"audience"`
+	Scopes   []string  `json:"scopes"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// auditVerifyResult is the result of audit verify
+type auditVerifyResult struct {
+	Files   []string `json:"files"`
+	Entries int      `json:"entries"`
+}
+
+// soakResult is the result of soak. The report is printed even when the
+// soak failed, with the reason in Error.
+type soakResult struct {
+	libp2plearn.SoakReport
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// configPushResult is the result of push-config
+type configPushResult struct {
+	ID              string   `json:"id"`
+	Seq             uint64   `json:"seq"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// forwardResult is printed by forward once the node is up
+type forwardResult struct {
+	ID       string                      `json:"id"`
+	Forwards []libp2plearn.ForwardConfig `json:"forwards"`
+}
@@ -0,0 +1,143 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+// Kinds of useful activity that tag a peer in the connection manager
+const (
+	ActivityPing = "ping" // the peer answered our ping
+	ActivityChat = "chat" // the peer sent us a ping, chat or chat session message
+	ActivityDHT  = "dht"  // the peer was useful to our DHT queries
+)
+
+const (
+	// activityResolution is how often the connection manager decays tags
+	activityResolution = 10 * time.Second
+
+	// activityMaxBumps caps the score of each kind at this many bumps' worth,
+	// so one chatty peer cannot build up a score that never decays away
+	activityMaxBumps = 10
+
+	// activityTagPrefix starts the connection manager tag of each kind
+	activityTagPrefix = "activity-"
+)
+
+// defaultActivityWeights favor peers we talk to or route through over peers
+// that only answer pings
+func defaultActivityWeights() map[string]int {
+	return map[string]int{
+		ActivityPing: 2,
+		ActivityChat: 5,
+		ActivityDHT:  5,
+	}
+}
+
+// Activity tags peers in the connection manager for recent useful activity,
+// with a decaying score per kind of activity. Scores halve every decay
+// interval, so when connections are trimmed above the high water mark, idle
+// strangers go before the peers we actually work with.
+type Activity struct {
+	host    host.Host
+	tags    map[string]connmgr.DecayingTag
+	weights map[string]int
+}
+
+// NewActivity registers a decaying tag for each kind with a positive weight,
+// the score one instance of that activity adds
+func NewActivity(h host.Host, weights map[string]int, decay time.Duration) (*Activity, error) {
+	decayer, ok := h.ConnManager().(connmgr.Decayer)
+	if !ok {
+		return nil, fmt.Errorf("connection manager does not support decaying tags")
+	}
+
+	a := &Activity{
+		host:    h,
+		tags:    make(map[string]connmgr.DecayingTag),
+		weights: make(map[string]int),
+	}
+	for kind, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		tag, err := decayer.RegisterDecayingTag(activityTagPrefix+kind, decay,
+			connmgr.DecayLinear(0.5), connmgr.BumpSumBounded(0, activityMaxBumps*weight))
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("failed to register %s activity tag: %w", kind, err)
+		}
+		a.tags[kind] = tag
+		a.weights[kind] = weight
+	}
+	return a, nil
+}
+
+// Bump records an instance of a kind of activity for a peer. Kinds without
+// a weight, and bumps on a nil Activity, are ignored.
+func (a *Activity) Bump(p peer.ID, kind string) {
+	if a == nil {
+		return
+	}
+	tag, ok := a.tags[kind]
+	if !ok {
+		return
+	}
+	if err := tag.Bump(p, a.weights[kind]); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"peer": p, "kind": kind}).Debug("Failed to bump activity tag")
+	}
+}
+
+// Scores returns a peer's current score for each kind of activity it has
+func (a *Activity) Scores(p peer.ID) map[string]int {
+	scores := make(map[string]int)
+	info := a.host.ConnManager().GetTagInfo(p)
+	if info == nil {
+		return scores
+	}
+	for tag, value := range info.Tags {
+		if kind, ok := strings.CutPrefix(tag, activityTagPrefix); ok {
+			scores[kind] = value
+		}
+	}
+	return scores
+}
+
+// WatchDHT bumps the peers in the DHT routing table that were useful to our
+// queries since the last check, every interval until ctx is done
+func (a *Activity) WatchDHT(ctx context.Context, d *discovery.DHT, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for _, info := range d.RoutingTable().GetPeerInfos() {
+			if info.LastUsefulAt.After(since) {
+				a.Bump(info.Id, ActivityDHT)
+			}
+		}
+		since = now
+	}
+}
+
+// Close removes the activity tags
+func (a *Activity) Close() {
+	for _, tag := range a.tags {
+		tag.Close()
+	}
+}
@@ -0,0 +1,76 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h2.Close()
+
+	activity, err := NewActivity(h1, map[string]int{ActivityChat: 5, ActivityPing: 2, ActivityDHT: 0}, time.Minute)
+	require.NoError(t, err)
+	defer activity.Close()
+
+	activity.Bump(h2.ID(), ActivityChat)
+	activity.Bump(h2.ID(), ActivityChat)
+	activity.Bump(h2.ID(), ActivityPing)
+	activity.Bump(h2.ID(), ActivityDHT) // no weight
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		scores := activity.Scores(h2.ID())
+		return scores[ActivityChat] == 10 && scores[ActivityPing] == 2
+	}, 5*time.Second, 10*time.Millisecond))
+	assert.NotContains(t, activity.Scores(h2.ID()), ActivityDHT)
+
+	t.Run("scores are capped", func(t *testing.T) {
+		for i := 0; i < 3*activityMaxBumps; i++ {
+			activity.Bump(h2.ID(), ActivityPing)
+		}
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return activity.Scores(h2.ID())[ActivityPing] == activityMaxBumps*2
+		}, 5*time.Second, 10*time.Millisecond))
+	})
+
+	t.Run("tags count for the connection manager", func(t *testing.T) {
+		info := h1.ConnManager().GetTagInfo(h2.ID())
+		require.NotNil(t, info)
+		assert.Equal(t, 10+activityMaxBumps*2, info.Value)
+	})
+
+	t.Run("nil activity ignores bumps", func(t *testing.T) {
+		var none *Activity
+		none.Bump(h2.ID(), ActivityChat)
+	})
+}
+
+func TestActivityConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.True(t, cfg.ActivityTagged())
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ActivityWeights = map[string]int{"gossip": 1}
+	assert.Error(t, cfg.Validate())
+
+	cfg.ActivityWeights = map[string]int{ActivityChat: -1}
+	assert.Error(t, cfg.Validate())
+
+	cfg.ActivityWeights = map[string]int{ActivityChat: 1}
+	cfg.ActivityDecay = Duration(time.Second)
+	assert.Error(t, cfg.Validate(), "decay shorter than the connection manager resolution")
+
+	cfg.ActivityWeights = nil
+	assert.False(t, cfg.ActivityTagged())
+	assert.NoError(t, cfg.Validate())
+}
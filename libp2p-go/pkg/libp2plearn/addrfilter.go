@@ -0,0 +1,119 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+// AddressFilters selects the addresses the node dials and advertises
+type AddressFilters struct {
+	// DenyPrivate drops RFC1918, CGNAT, link-local and unique local addresses
+	DenyPrivate bool `json:"deny_private"`
+	// DenyLoopback drops 127.0.0.0/8 and ::1
+	DenyLoopback bool `json:"deny_loopback"`
+	// Deny lists further CIDRs to drop, such as bogons
+	Deny []string `json:"deny"`
+	// Allow lists CIDRs kept even when a deny rule matches
+	Allow []string `json:"allow"`
+}
+
+// Enabled reports whether any filter is configured
+func (f AddressFilters) Enabled() bool {
+	return f.DenyPrivate || f.DenyLoopback || len(f.Deny) > 0
+}
+
+// AddrFilter applies address filters. Addresses without an IP, such as
+// /dns ones, always pass.
+type AddrFilter struct {
+	filters AddressFilters
+	deny    []*net.IPNet
+	allow   []*net.IPNet
+}
+
+// NewAddrFilter compiles the filters, returning nil if none are configured
+func NewAddrFilter(filters AddressFilters) (*AddrFilter, error) {
+	if !filters.Enabled() {
+		return nil, nil
+	}
+	f := &AddrFilter{filters: filters}
+	var err error
+	if f.deny, err = parseCIDRs(filters.Deny); err != nil {
+		return nil, fmt.Errorf("invalid address filter: %w", err)
+	}
+	if f.allow, err = parseCIDRs(filters.Allow); err != nil {
+		return nil, fmt.Errorf("invalid address filter: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"deny_private":  filters.DenyPrivate,
+		"deny_loopback": filters.DenyLoopback,
+		"deny":          filters.Deny,
+		"allow":         filters.Allow,
+	}).Info("Address filters enabled")
+	return f, nil
+}
+
+// parseCIDRs parses a list of CIDRs
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Allowed reports whether the address passes the filters. A nil filter
+// allows everything.
+func (f *AddrFilter) Allowed(addr multiaddr.Multiaddr) bool {
+	if f == nil {
+		return true
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return true
+	}
+	if containsIP(f.allow, ip) {
+		return true
+	}
+	if ip.IsLoopback() {
+		if f.filters.DenyLoopback {
+			return false
+		}
+	} else if f.filters.DenyPrivate && manet.IsPrivateAddr(addr) {
+		return false
+	}
+	return !containsIP(f.deny, ip)
+}
+
+// Filter returns the addresses that pass the filters, suiting
+// libp2p.AddrsFactory
+func (f *AddrFilter) Filter(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	if f == nil {
+		return addrs
+	}
+	filtered := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if f.Allowed(addr) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// containsIP reports whether any of the networks contains ip
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
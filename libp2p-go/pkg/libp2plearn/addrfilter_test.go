@@ -0,0 +1,85 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrFilter(t *testing.T) {
+	filter, err := NewAddrFilter(AddressFilters{
+		DenyPrivate:  true,
+		DenyLoopback: true,
+		Deny:         []string{"240.0.0.0/4"},
+		Allow:        []string{"10.8.0.0/16"},
+	})
+	require.NoError(t, err)
+
+	for addr, allowed := range map[string]bool{
+		"/ip4/93.184.216.34/tcp/4001":        true,
+		"/ip4/10.1.2.3/tcp/4001":             false,
+		"/ip4/192.168.1.20/udp/4001/quic-v1": false,
+		"/ip4/100.64.0.1/tcp/4001":           false,
+		"/ip4/10.8.0.5/tcp/4001":             true,
+		"/ip4/127.0.0.1/tcp/4001":            false,
+		"/ip6/::1/tcp/4001":                  false,
+		"/ip6/fd00::1/tcp/4001":              false,
+		"/ip4/240.0.0.1/tcp/4001":            false,
+		"/dns4/example.com/tcp/4001":         true,
+	} {
+		assert.Equal(t, allowed, filter.Allowed(multiaddr.StringCast(addr)), addr)
+	}
+
+	public := multiaddr.StringCast("/ip4/93.184.216.34/tcp/4001")
+	assert.Equal(t, []multiaddr.Multiaddr{public},
+		filter.Filter([]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001"), public}))
+
+	t.Run("no filters", func(t *testing.T) {
+		filter, err := NewAddrFilter(AddressFilters{Allow: []string{"10.0.0.0/8"}})
+		require.NoError(t, err)
+		assert.Nil(t, filter)
+		assert.True(t, filter.Allowed(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")))
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.AddressFilters.Deny = []string{"10.0.0.0"}
+		assert.Error(t, cfg.Validate())
+	})
+}
+
+func TestAddressFiltersNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.AddressFilters.DenyLoopback = true
+	node, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node.Stop(ctx)
+
+	for _, addr := range node.Host().Addrs() {
+		assert.False(t, manet.IsIPLoopback(addr), addr)
+	}
+
+	other, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer other.Stop(ctx)
+
+	var loopback multiaddr.Multiaddr
+	for _, addr := range other.Host().Addrs() {
+		if manet.IsIPLoopback(addr) {
+			loopback = addr
+			break
+		}
+	}
+	require.NotNil(t, loopback)
+	err = node.Connect(ctx, fmt.Sprintf("%s/p2p/%s", loopback, other.Host().ID()))
+	assert.Error(t, err)
+}
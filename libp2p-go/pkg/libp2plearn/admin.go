@@ -0,0 +1,638 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+const (
+	// AdminProtocol carries remote administration commands from admin peers
+	AdminProtocol = "/libp2p-learn/admin/1.0.0"
+
+	// adminTimeout bounds reading a command, running it and writing the response
+	adminTimeout = 30 * time.Second
+
+	// maxAdminMessageSize bounds the size of an admin request or response line
+	maxAdminMessageSize = 1 << 20
+)
+
+// Admin commands
+const (
+	AdminCmdPeers      = "peers"      // list connected peers
+	AdminCmdConnect    = "connect"    // connect to a multiaddr: args[0]
+	AdminCmdDisconnect = "disconnect" // say goodbye to and disconnect a peer ID: args[0], without the goodbye if args[1] is "force"
+	AdminCmdStats      = "stats"      // node statistics
+	AdminCmdLogLevel   = "log_level"  // change the log level, or that of libp2p subsystem args[1], to args[0]
+	AdminCmdLogLevels  = "log_levels" // the log level and the levels of libp2p subsystems
+	AdminCmdPinLs      = "pin_ls"     // list pinned blobs
+	AdminCmdPinAdd     = "pin_add"    // fetch if needed and pin a blob CID: args[0]
+	AdminCmdPinRm      = "pin_rm"     // unpin a blob CID: args[0]
+	AdminCmdRepoGC     = "repo_gc"    // remove every unpinned blob block
+	AdminCmdNATStatus  = "nat_status" // reachability and observed address confidence
+	AdminCmdLatency    = "latency"    // round-trip percentiles of every peer, or of peer ID args[0]
+	AdminCmdTransports = "transports" // measured performance of each transport and address
+
+	AdminCmdStreams     = "streams"      // open streams of each connection, or of peer ID args[0]
+	AdminCmdStreamReset = "stream_reset" // reset an open stream by its ID: args[0]
+
+	AdminCmdCloseTransport = "close_transport" // close every connection over transport args[0], e.g. quic
+	AdminCmdMaintenance    = "maintenance"     // args[0] enter (draining over duration args[1]), exit or status
+
+	AdminCmdProtocols          = "protocols"           // list registered protocols
+	AdminCmdProtocolUnregister = "protocol_unregister" // unregister a protocol ID: args[0]
+	AdminCmdProtocolRegister   = "protocol_register"   // register an unregistered protocol ID again: args[0]
+)
+
+// AdminRequest is a remote command, sent as one JSON line
+type AdminRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// AdminResponse is the result of a remote command, sent as one JSON line
+type AdminResponse struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// AdminPeer describes a connected peer in the peers command
+type AdminPeer struct {
+	ID          string     `json:"id"`
+	Addrs       []string   `json:"addrs"`
+	Connections int        `json:"connections"`
+	Paths       []ConnPath `json:"paths"`
+}
+
+// AdminStats is the result of the stats command
+type AdminStats struct {
+	PeerID      string   `json:"peer_id"`
+	Addrs       []string `json:"addrs"`
+	Peers       int      `json:"peers"`
+	Connections int      `json:"connections"`
+	Relayed     int      `json:"relayed"` // connections through a circuit relay
+	Streams     int      `json:"streams"`
+	Uptime      string   `json:"uptime"`
+	LogLevel    string   `json:"log_level"`
+	DHTMode     string   `json:"dht_mode"` // client or server
+	Protocols   []string `json:"protocols"`
+
+	Reservations []RelayReservation `json:"reservations"` // ours, with relay_reservations
+	Quotas       []QuotaStatus      `json:"quotas,omitempty"`
+}
+
+// AdminClosedConns is the result of the close_transport command
+type AdminClosedConns struct {
+	Transport string   `json:"transport"`
+	Closed    int      `json:"closed"`
+	Peers     []string `json:"peers"`
+}
+
+// Admin serves remote administration commands to configured admin peers. Peers
+// are authenticated by the secure channel, so only the remote peer ID needs checking.
+type Admin struct {
+	host    host.Host
+	goodbye *Goodbye
+	started time.Time
+	audit   *AuditLog
+	blobs   *BlobStore
+	pinBlob func(ctx context.Context, root cid.Cid) error
+	protos  *ProtocolHandler
+	nat     *ObservedAddrs
+	latency *LatencyTracker
+	perf    *TransportPerf
+	relays  *RelayReservations
+	streams *StreamTracker
+	maint   *Maintenance
+	quotas  *Quotas
+
+	mu     sync.RWMutex
+	admins map[peer.ID]bool
+}
+
+// NewAdmin creates the admin service and registers its protocol handler.
+// Only the given peer IDs may run commands.
+func NewAdmin(h host.Host, goodbye *Goodbye, adminIDs []string) (*Admin, error) {
+	a := &Admin{
+		host:    h,
+		goodbye: goodbye,
+		started: time.Now(),
+	}
+	if err := a.SetAdmins(adminIDs); err != nil {
+		return nil, err
+	}
+
+	h.SetStreamHandler(protocol.ID(AdminProtocol), RecoveryMiddleware(protocol.ID(AdminProtocol), a.handleAdmin))
+	logrus.WithFields(logrus.Fields{
+		"protocol": AdminProtocol,
+		"admins":   len(adminIDs),
+	}).Info("Registered admin protocol")
+	return a, nil
+}
+
+// SetAdmins replaces the peer IDs allowed to run commands
+func (a *Admin) SetAdmins(adminIDs []string) error {
+	admins := make(map[peer.ID]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		p, err := peer.Decode(id)
+		if err != nil {
+			return fmt.Errorf("invalid admin peer %q: %w", id, err)
+		}
+		admins[p] = true
+	}
+
+	a.mu.Lock()
+	a.admins = admins
+	a.mu.Unlock()
+	return nil
+}
+
+// IsAdmin reports whether the peer may run admin commands
+func (a *Admin) IsAdmin(p peer.ID) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.admins[p]
+}
+
+// SetAuditLog records every command, and refused attempts, to the audit log
+func (a *Admin) SetAuditLog(audit *AuditLog) {
+	a.audit = audit
+}
+
+// SetBlobStore enables the pin and repo commands. pin fetches content that
+// isn't complete in the store yet and pins it.
+func (a *Admin) SetBlobStore(blobs *BlobStore, pin func(ctx context.Context, root cid.Cid) error) {
+	a.blobs = blobs
+	a.pinBlob = pin
+}
+
+// SetProtocols enables the protocol commands
+func (a *Admin) SetProtocols(protos *ProtocolHandler) {
+	a.protos = protos
+}
+
+// SetObservedAddrs enables the nat_status command
+func (a *Admin) SetObservedAddrs(observed *ObservedAddrs) {
+	a.nat = observed
+}
+
+// SetLatencyTracker enables the latency command
+func (a *Admin) SetLatencyTracker(latency *LatencyTracker) {
+	a.latency = latency
+}
+
+// SetTransportPerf enables the transports command
+func (a *Admin) SetTransportPerf(perf *TransportPerf) {
+	a.perf = perf
+}
+
+// SetRelayReservations adds relay reservations and their expiry to the
+// peers and stats commands
+func (a *Admin) SetRelayReservations(relays *RelayReservations) {
+	a.relays = relays
+}
+
+// SetStreamTracker enables the streams and stream_reset commands
+func (a *Admin) SetStreamTracker(streams *StreamTracker) {
+	a.streams = streams
+}
+
+// SetMaintenance enables the maintenance command
+func (a *Admin) SetMaintenance(maint *Maintenance) {
+	a.maint = maint
+}
+
+// SetQuotas adds traffic quota usage to the stats command
+func (a *Admin) SetQuotas(quotas *Quotas) {
+	a.quotas = quotas
+}
+
+// Close unregisters the admin protocol
+func (a *Admin) Close() {
+	a.host.RemoveStreamHandler(protocol.ID(AdminProtocol))
+}
+
+// handleAdmin runs one command from an admin peer
+func (a *Admin) handleAdmin(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !a.IsAdmin(remote) {
+		logrus.WithField("peer", remote).Warn("Rejected admin stream from unauthorized peer")
+		a.audit.Action(remote, AdminProtocol, "", nil, fmt.Errorf("unauthorized peer"))
+		s.Reset()
+		return
+	}
+	defer s.Close()
+
+	s.SetDeadline(time.Now().Add(adminTimeout))
+
+	var req AdminRequest
+	reader := bufio.NewReaderSize(s, maxAdminMessageSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read admin request")
+		return
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		a.audit.Action(remote, AdminProtocol, "", nil, fmt.Errorf("invalid request: %w", err))
+		writeAdminResponse(s, AdminResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":    remote,
+		"command": req.Command,
+		"args":    req.Args,
+	}).Info("Running admin command")
+
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	resp := AdminResponse{OK: true}
+	result, err := a.execute(ctx, s.Conn(), req)
+	a.audit.Action(remote, AdminProtocol, req.Command, req.Args, err)
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		resp = AdminResponse{Error: err.Error()}
+	}
+	writeAdminResponse(s, resp)
+}
+
+// execute runs a command received over a connection and returns its result
+func (a *Admin) execute(ctx context.Context, from network.Conn, req AdminRequest) (interface{}, error) {
+	switch req.Command {
+	case AdminCmdPeers:
+		return a.peers(), nil
+
+	case AdminCmdConnect:
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("connect takes a multiaddr")
+		}
+		if err := discovery.ConnectToPeer(ctx, a.host, req.Args[0]); err != nil {
+			return nil, err
+		}
+		return "connected", nil
+
+	case AdminCmdDisconnect:
+		if len(req.Args) < 1 || len(req.Args) > 2 || (len(req.Args) == 2 && req.Args[1] != "force") {
+			return nil, fmt.Errorf("disconnect takes a peer ID and optionally force")
+		}
+		p, err := peer.Decode(req.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID: %w", err)
+		}
+		if a.host.Network().Connectedness(p) != network.Connected {
+			return nil, fmt.Errorf("not connected to %s", p)
+		}
+		if len(req.Args) == 2 {
+			// A misbehaving peer may not read a goodbye, so don't wait for it to
+			if err := a.host.Network().ClosePeer(p); err != nil {
+				return nil, fmt.Errorf("failed to close connection to %s: %w", p, err)
+			}
+			logrus.WithField("peer", p).Warn("Force-closed connections to peer")
+			return "disconnected", nil
+		}
+		if err := a.goodbye.Disconnect(ctx, p, GoodbyePruned, "disconnected by admin"); err != nil {
+			return nil, err
+		}
+		return "disconnected", nil
+
+	case AdminCmdCloseTransport:
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("close_transport takes a transport")
+		}
+		return a.closeTransport(req.Args[0], from)
+
+	case AdminCmdStats:
+		return a.stats(), nil
+
+	case AdminCmdLogLevel:
+		if len(req.Args) != 1 && len(req.Args) != 2 {
+			return nil, fmt.Errorf("log_level takes a level and optionally a libp2p subsystem")
+		}
+		if len(req.Args) == 2 {
+			if err := SetLogLevel(req.Args[1], req.Args[0]); err != nil {
+				return nil, err
+			}
+			return req.Args[0], nil
+		}
+		if err := SetLogLevel("", req.Args[0]); err != nil {
+			return nil, err
+		}
+		return logrus.GetLevel().String(), nil
+
+	case AdminCmdLogLevels:
+		return CurrentLogLevels(), nil
+
+	case AdminCmdNATStatus:
+		if a.nat == nil {
+			return nil, fmt.Errorf("observed addresses are not tracked")
+		}
+		return a.nat.Status(), nil
+
+	case AdminCmdLatency:
+		return a.latencyStats(req.Args)
+
+	case AdminCmdTransports:
+		if a.perf == nil {
+			return nil, fmt.Errorf("transport performance is not measured")
+		}
+		return a.perf.Snapshot(), nil
+
+	case AdminCmdMaintenance:
+		return a.maintenance(req.Args)
+
+	case AdminCmdStreams, AdminCmdStreamReset:
+		return a.executeStreams(req)
+
+	case AdminCmdPinLs, AdminCmdPinAdd, AdminCmdPinRm, AdminCmdRepoGC:
+		return a.executeBlob(ctx, req)
+
+	case AdminCmdProtocols, AdminCmdProtocolUnregister, AdminCmdProtocolRegister:
+		return a.executeProtocol(req)
+
+	default:
+		return nil, fmt.Errorf("unknown command: %s", req.Command)
+	}
+}
+
+// closeTransport closes every connection over a transport, except the one
+// the command came over so its response can still be sent
+func (a *Admin) closeTransport(transport string, from network.Conn) (AdminClosedConns, error) {
+	result := AdminClosedConns{Transport: transport, Peers: []string{}}
+	if !slices.Contains(transportNames, transport) {
+		return result, fmt.Errorf("unknown transport %q, expected one of %s", transport, strings.Join(transportNames, ", "))
+	}
+
+	peers := make(map[peer.ID]bool)
+	for _, c := range a.host.Network().Conns() {
+		if c == from || transportName(c.RemoteMultiaddr()) != transport {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			logrus.WithError(err).WithField("peer", c.RemotePeer()).Debug("Failed to close connection")
+			continue
+		}
+		result.Closed++
+		peers[c.RemotePeer()] = true
+	}
+	for p := range peers {
+		result.Peers = append(result.Peers, p.String())
+	}
+	sort.Strings(result.Peers)
+
+	logrus.WithFields(logrus.Fields{
+		"transport":   transport,
+		"connections": result.Closed,
+		"peers":       len(result.Peers),
+	}).Warn("Closed every connection over transport")
+	return result, nil
+}
+
+// maintenance enters or exits maintenance, or reports its status
+func (a *Admin) maintenance(args []string) (MaintenanceStatus, error) {
+	if a.maint == nil {
+		return MaintenanceStatus{}, fmt.Errorf("maintenance is not available")
+	}
+	if len(args) == 0 {
+		return MaintenanceStatus{}, fmt.Errorf("maintenance takes enter, exit or status")
+	}
+	switch args[0] {
+	case "enter":
+		var drain time.Duration
+		if len(args) > 1 {
+			var err error
+			drain, err = time.ParseDuration(args[1])
+			if err != nil || drain <= 0 {
+				return MaintenanceStatus{}, fmt.Errorf("invalid drain period %q", args[1])
+			}
+		}
+		return a.maint.Enter(drain)
+	case "exit":
+		return a.maint.Exit()
+	case "status":
+		return a.maint.Status(), nil
+	default:
+		return MaintenanceStatus{}, fmt.Errorf("maintenance takes enter, exit or status, not %q", args[0])
+	}
+}
+
+// executeStreams runs a streams or stream_reset command
+func (a *Admin) executeStreams(req AdminRequest) (interface{}, error) {
+	if a.streams == nil {
+		return nil, fmt.Errorf("streams are not tracked")
+	}
+	if req.Command == AdminCmdStreamReset {
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("stream_reset takes a stream ID")
+		}
+		if err := a.streams.Reset(req.Args[0]); err != nil {
+			return nil, err
+		}
+		return "reset", nil
+	}
+
+	var p peer.ID
+	if len(req.Args) > 0 {
+		var err error
+		p, err = peer.Decode(req.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID: %w", err)
+		}
+	}
+	return a.streams.Streams(p), nil
+}
+
+// executeBlob runs a pin or repo command
+func (a *Admin) executeBlob(ctx context.Context, req AdminRequest) (interface{}, error) {
+	if a.blobs == nil {
+		return nil, fmt.Errorf("blob store is not enabled")
+	}
+	if req.Command == AdminCmdPinLs {
+		return a.blobs.Pins(), nil
+	}
+	if req.Command == AdminCmdRepoGC {
+		return a.blobs.GC(0)
+	}
+
+	if len(req.Args) != 1 {
+		return nil, fmt.Errorf("%s takes a CID", req.Command)
+	}
+	root, err := cid.Decode(req.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID: %w", err)
+	}
+	if req.Command == AdminCmdPinRm {
+		if err := a.blobs.Unpin(root); err != nil {
+			return nil, err
+		}
+		return "unpinned", nil
+	}
+	if err := a.pinBlob(ctx, root); err != nil {
+		return nil, err
+	}
+	return "pinned", nil
+}
+
+// executeProtocol runs a protocol command. Handlers can't be sent over the
+// wire, so only protocols registered before can be registered again.
+func (a *Admin) executeProtocol(req AdminRequest) (interface{}, error) {
+	if a.protos == nil {
+		return nil, fmt.Errorf("protocol handler is not available")
+	}
+	if req.Command == AdminCmdProtocols {
+		return a.protos.Registered(), nil
+	}
+
+	if len(req.Args) != 1 {
+		return nil, fmt.Errorf("%s takes a protocol ID", req.Command)
+	}
+	proto := protocol.ID(req.Args[0])
+	if req.Command == AdminCmdProtocolUnregister {
+		if err := a.protos.Unregister(proto); err != nil {
+			return nil, err
+		}
+		return "unregistered", nil
+	}
+	if err := a.protos.Reregister(proto); err != nil {
+		return nil, err
+	}
+	return "registered", nil
+}
+
+// latencyStats summarizes the round trips of every peer, or of the peer ID
+// in args
+func (a *Admin) latencyStats(args []string) ([]LatencyStats, error) {
+	if a.latency == nil {
+		return nil, fmt.Errorf("latency is not tracked")
+	}
+	if len(args) == 0 {
+		return a.latency.All(), nil
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("latency takes at most a peer ID")
+	}
+	p, err := peer.Decode(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	stats, ok := a.latency.Stats(p)
+	if !ok {
+		return nil, fmt.Errorf("no round trips to %s recorded", p)
+	}
+	return []LatencyStats{stats}, nil
+}
+
+// peers lists the connected peers
+func (a *Admin) peers() []AdminPeer {
+	var peers []AdminPeer
+	for _, p := range a.host.Network().Peers() {
+		conns := a.host.Network().ConnsToPeer(p)
+		info := AdminPeer{ID: p.String(), Connections: len(conns)}
+		for _, c := range conns {
+			info.Addrs = append(info.Addrs, c.RemoteMultiaddr().String())
+		}
+		info.Paths = ConnPaths(a.host, p, a.relays)
+		peers = append(peers, info)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// stats summarizes the node
+func (a *Admin) stats() AdminStats {
+	stats := AdminStats{
+		PeerID:   a.host.ID().String(),
+		Peers:    len(a.host.Network().Peers()),
+		Uptime:   time.Since(a.started).Round(time.Second).String(),
+		LogLevel: logrus.GetLevel().String(),
+		DHTMode:  discovery.ModeOf(a.host),
+	}
+	for _, addr := range a.host.Addrs() {
+		stats.Addrs = append(stats.Addrs, addr.String())
+	}
+	for _, c := range a.host.Network().Conns() {
+		stats.Connections++
+		stats.Streams += len(c.GetStreams())
+		if _, ok := relayOf(c.RemoteMultiaddr()); ok {
+			stats.Relayed++
+		}
+	}
+	if a.relays != nil {
+		stats.Reservations = a.relays.Reservations()
+	}
+	if a.quotas != nil {
+		stats.Quotas = a.quotas.Status()
+	}
+	for _, proto := range a.host.Mux().Protocols() {
+		stats.Protocols = append(stats.Protocols, string(proto))
+	}
+	sort.Strings(stats.Protocols)
+	return stats
+}
+
+// writeAdminResponse sends a response as one JSON line
+func writeAdminResponse(s network.Stream, resp AdminResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode admin response")
+		return
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).Debug("Failed to write admin response")
+	}
+}
+
+// SendAdminCommand runs a command on a remote node that lists this host as an admin peer
+func SendAdminCommand(ctx context.Context, h host.Host, p peer.ID, command string, args ...string) (json.RawMessage, error) {
+	s, err := h.NewStream(ctx, p, protocol.ID(AdminProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	data, err := json.Marshal(AdminRequest{Command: command, Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode admin request: %w", err)
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send admin request: %w", err)
+	}
+	s.CloseWrite()
+
+	reader := bufio.NewReaderSize(s, maxAdminMessageSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin response: %w", err)
+	}
+
+	var resp AdminResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse admin response: %w", err)
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("admin command failed: %s", resp.Error)
+	}
+	return resp.Result, nil
+}
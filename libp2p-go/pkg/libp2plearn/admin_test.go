@@ -0,0 +1,181 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	operator, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer operator.Close()
+
+	stranger, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer stranger.Close()
+
+	managed, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer managed.Close()
+
+	goodbye, err := NewGoodbye(managed)
+	require.NoError(t, err)
+	defer goodbye.Close()
+
+	admin, err := NewAdmin(managed, goodbye, []string{operator.ID().String()})
+	require.NoError(t, err)
+	defer admin.Close()
+	admin.SetStreamTracker(NewStreamTracker(managed))
+
+	require.NoError(t, connectNodes(ctx, operator, managed))
+	require.NoError(t, connectNodes(ctx, stranger, managed))
+
+	t.Run("Stats", func(t *testing.T) {
+		result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdStats)
+		require.NoError(t, err)
+
+		var stats AdminStats
+		require.NoError(t, json.Unmarshal(result, &stats))
+		assert.Equal(t, managed.ID().String(), stats.PeerID)
+		assert.Equal(t, 2, stats.Peers)
+		assert.Contains(t, stats.Protocols, AdminProtocol)
+	})
+
+	t.Run("Peers", func(t *testing.T) {
+		result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdPeers)
+		require.NoError(t, err)
+
+		var peers []AdminPeer
+		require.NoError(t, json.Unmarshal(result, &peers))
+		ids := make([]string, 0, len(peers))
+		for _, p := range peers {
+			ids = append(ids, p.ID)
+		}
+		assert.ElementsMatch(t, []string{operator.ID().String(), stranger.ID().String()}, ids)
+	})
+
+	t.Run("Streams", func(t *testing.T) {
+		result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdStreams, operator.ID().String())
+		require.NoError(t, err)
+
+		var conns []ConnStreams
+		require.NoError(t, json.Unmarshal(result, &conns))
+		require.Len(t, conns, 1)
+		assert.Equal(t, operator.ID().String(), conns[0].Peer)
+		var protocols []string
+		for _, s := range conns[0].Streams {
+			protocols = append(protocols, s.Protocol)
+		}
+		assert.Contains(t, protocols, AdminProtocol)
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdStreamReset, "missing")
+		assert.ErrorContains(t, err, "no open stream")
+	})
+
+	t.Run("LogLevel", func(t *testing.T) {
+		previous := logrus.GetLevel()
+		defer logrus.SetLevel(previous)
+
+		_, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdLogLevel, "debug")
+		require.NoError(t, err)
+		assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdLogLevel, "loud")
+		assert.ErrorContains(t, err, "invalid log level")
+	})
+
+	t.Run("SubsystemLogLevel", func(t *testing.T) {
+		defer SetLogLevel("swarm2", "error")
+
+		_, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdLogLevel, "debug", "swarm2")
+		require.NoError(t, err)
+
+		result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdLogLevels)
+		require.NoError(t, err)
+		var levels LogLevels
+		require.NoError(t, json.Unmarshal(result, &levels))
+		assert.Equal(t, "debug", levels.Subsystems["swarm2"])
+		assert.Contains(t, levels.Available, "swarm2")
+		assert.Equal(t, logrus.GetLevel().String(), levels.Level)
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdLogLevel, "debug", "no-such-subsystem")
+		assert.ErrorContains(t, err, "no-such-subsystem")
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdLogLevel, "trace", "swarm2")
+		assert.ErrorContains(t, err, "invalid log level")
+	})
+
+	t.Run("UnknownCommand", func(t *testing.T) {
+		_, err := SendAdminCommand(ctx, operator, managed.ID(), "reboot")
+		assert.ErrorContains(t, err, "unknown command")
+	})
+
+	t.Run("UnauthorizedPeer", func(t *testing.T) {
+		_, err := SendAdminCommand(ctx, stranger, managed.ID(), AdminCmdStats)
+		assert.Error(t, err)
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		_, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdDisconnect, stranger.ID().String())
+		require.NoError(t, err)
+		assert.False(t, isConnected(managed, stranger.ID()))
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdDisconnect, stranger.ID().String())
+		assert.ErrorContains(t, err, "not connected")
+	})
+
+	t.Run("ForceDisconnect", func(t *testing.T) {
+		misbehaving, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer misbehaving.Close()
+		require.NoError(t, connectNodes(ctx, misbehaving, managed))
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdDisconnect, misbehaving.ID().String(), "now")
+		assert.ErrorContains(t, err, "optionally force")
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdDisconnect, misbehaving.ID().String(), "force")
+		require.NoError(t, err)
+		assert.False(t, isConnected(managed, misbehaving.ID()))
+	})
+
+	t.Run("CloseTransport", func(t *testing.T) {
+		other, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer other.Close()
+		require.NoError(t, connectNodes(ctx, other, managed))
+
+		result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdCloseTransport, "tcp")
+		require.NoError(t, err)
+
+		var closed AdminClosedConns
+		require.NoError(t, json.Unmarshal(result, &closed))
+		assert.Equal(t, 1, closed.Closed)
+		assert.Equal(t, []string{other.ID().String()}, closed.Peers)
+		assert.False(t, isConnected(managed, other.ID()))
+		// The connection the command came over stays open
+		assert.True(t, isConnected(managed, operator.ID()))
+
+		_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdCloseTransport, "carrier-pigeon")
+		assert.ErrorContains(t, err, "unknown transport")
+	})
+}
+
+func TestLoadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.key")
+
+	key, err := LoadIdentity(path)
+	require.NoError(t, err)
+
+	again, err := LoadIdentity(path)
+	require.NoError(t, err)
+	assert.True(t, key.Equals(again), "identity should be reused across loads")
+}
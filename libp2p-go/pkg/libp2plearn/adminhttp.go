@@ -0,0 +1,118 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ProbeResult is the outcome of one readiness check; Err is nil if it passed
+type ProbeResult struct {
+	Name string
+	Err  error
+}
+
+// AdminHTTP serves operator endpoints over HTTP: /healthz answers as long as
+// the node runs, and /readyz only once it can do useful work, so an
+// orchestrator such as Kubernetes can restart it or hold traffic back
+type AdminHTTP struct {
+	server *http.Server
+	mux    *http.ServeMux
+	ready  func() []ProbeResult
+}
+
+// NewAdminHTTP creates the operator endpoints on listenAddr. ready runs the
+// readiness checks.
+func NewAdminHTTP(listenAddr string, ready func() []ProbeResult) *AdminHTTP {
+	a := &AdminHTTP{mux: http.NewServeMux(), ready: ready}
+	a.mux.HandleFunc("/healthz", a.handleHealthz)
+	a.mux.HandleFunc("/readyz", a.handleReadyz)
+	a.server = &http.Server{
+		Addr:              listenAddr,
+		Handler:           a.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return a
+}
+
+// Handle adds an endpoint; call it before Start
+func (a *AdminHTTP) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// Start begins serving HTTP requests in the background
+func (a *AdminHTTP) Start() {
+	go func() {
+		if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).WithField("addr", a.server.Addr).Error("Admin HTTP server failed")
+		}
+	}()
+	logrus.WithField("addr", a.server.Addr).Info("Admin HTTP endpoints started")
+}
+
+// Close shuts the endpoints down, waiting for in-flight requests
+func (a *AdminHTTP) Close(ctx context.Context) error {
+	return a.server.Shutdown(ctx)
+}
+
+// handleHealthz reports that the process is alive
+func (a *AdminHTTP) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz runs the readiness checks and lists them the way Kubernetes
+// components do, answering 503 if any failed
+func (a *AdminHTTP) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	var body strings.Builder
+	ready := true
+	for _, result := range a.ready() {
+		if result.Err != nil {
+			ready = false
+			fmt.Fprintf(&body, "[-]%s failed: %v\n", result.Name, result.Err)
+		} else {
+			fmt.Fprintf(&body, "[+]%s ok\n", result.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, body.String()+"readyz check failed\n")
+		return
+	}
+	fmt.Fprint(w, body.String()+"readyz check passed\n")
+}
+
+// readiness checks that the host listens, the DHT has found enough peers to
+// route with and enough peers are connected
+func (n *Node) readiness() []ProbeResult {
+	cfg := n.Config()
+	var results []ProbeResult
+
+	var err error
+	if len(n.host.Network().ListenAddresses()) == 0 {
+		err = fmt.Errorf("not listening on any address")
+	}
+	results = append(results, ProbeResult{"host", err})
+
+	err = nil
+	if n.dht == nil {
+		err = fmt.Errorf("not started")
+	} else if size := n.dht.RoutingTable().Size(); size < cfg.ReadyMinRoutingPeers {
+		err = fmt.Errorf("%d of %d peers in the routing table", size, cfg.ReadyMinRoutingPeers)
+	}
+	results = append(results, ProbeResult{"dht", err})
+
+	err = nil
+	if peers := len(n.host.Network().Peers()); peers < cfg.ReadyMinPeers {
+		err = fmt.Errorf("%d of %d peers connected", peers, cfg.ReadyMinPeers)
+	}
+	results = append(results, ProbeResult{"peers", err})
+	return results
+}
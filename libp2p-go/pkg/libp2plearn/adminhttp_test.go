@@ -0,0 +1,59 @@
+package libp2plearn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHTTP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	get := func(a *AdminHTTP, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	cfg := testNodeConfig()
+	cfg.ReadyMinPeers = 1
+	cfg.ReadyMinRoutingPeers = 1
+	node, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node.Stop(ctx)
+	a := NewAdminHTTP("127.0.0.1:0", node.readiness)
+
+	t.Run("Liveness", func(t *testing.T) {
+		rec := get(a, "/healthz")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ok\n", rec.Body.String())
+	})
+
+	t.Run("NotReady", func(t *testing.T) {
+		rec := get(a, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "[+]host ok")
+		assert.Contains(t, rec.Body.String(), "[-]dht failed: not started")
+		assert.Contains(t, rec.Body.String(), "[-]peers failed: 0 of 1 peers connected")
+	})
+
+	require.NoError(t, node.Start(ctx))
+	other, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer other.Stop(ctx)
+	require.NoError(t, other.Start(ctx))
+	require.NoError(t, connectNodes(ctx, node.Host(), other.Host()))
+
+	t.Run("Ready", func(t *testing.T) {
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return get(a, "/readyz").Code == http.StatusOK
+		}, 10*time.Second, 100*time.Millisecond))
+		assert.Contains(t, get(a, "/readyz").Body.String(), "readyz check passed")
+	})
+}
@@ -0,0 +1,254 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+const (
+	// AppNamespace is the DHT namespace of app records, whose keys are
+	// /libp2p-learn/<publisher peer ID>/<name>
+	AppNamespace = "libp2p-learn"
+
+	// AppDHTPrefix is the protocol prefix of the DHT app records are kept in
+	AppDHTPrefix = "/libp2p-learn"
+
+	// AppRecordDomain is the signature domain of app records
+	AppRecordDomain = "libp2p-learn-app-record"
+
+	// DefaultAppRecordTTL is how long an app record lives unless told otherwise
+	DefaultAppRecordTTL = 24 * time.Hour
+
+	// MaxAppRecordTTL is the longest TTL the validator accepts
+	MaxAppRecordTTL = 7 * 24 * time.Hour
+
+	// appRecordClockSkew is how far in the future a record may have been
+	// created, for publishers whose clocks run ahead
+	appRecordClockSkew = 5 * time.Minute
+)
+
+// appRecordCodec is the payload type of app record envelopes
+var appRecordCodec = []byte("/libp2p-learn/app-record")
+
+// ErrRecordExpired is returned for app records older than their TTL
+var ErrRecordExpired = errors.New("record expired")
+
+// AppRecord is a value a peer publishes in the app DHT namespace, sealed in
+// an envelope it signs. Every DHT peer validates the publisher, the key,
+// the TTL and the expiry, and keeps the record with the highest Seq, so
+// only the publisher can update a record and older ones can't replace it.
+type AppRecord struct {
+	Publisher peer.ID   `json:"publisher"`
+	Name      string    `json:"name"`
+	Value     []byte    `json:"value"`
+	Created   time.Time `json:"created"`
+	TTL       Duration  `json:"ttl"`
+	Seq       uint64    `json:"seq"`
+}
+
+// Domain is the signature domain of app records
+func (r *AppRecord) Domain() string {
+	return AppRecordDomain
+}
+
+// Codec is the payload type of app records
+func (r *AppRecord) Codec() []byte {
+	return appRecordCodec
+}
+
+// MarshalRecord encodes the record as JSON
+func (r *AppRecord) MarshalRecord() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalRecord decodes a JSON record
+func (r *AppRecord) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// Expires returns when the record stops being valid
+func (r *AppRecord) Expires() time.Time {
+	return r.Created.Add(time.Duration(r.TTL))
+}
+
+// RecordFreshness tells how current an app record is. A record is stale
+// once it is past half its TTL, when its publisher should have published
+// it again: one still alive may have been left behind by a publisher that
+// is gone.
+type RecordFreshness struct {
+	Age       Duration  `json:"age"`
+	Remaining Duration  `json:"remaining"`
+	Expires   time.Time `json:"expires"`
+	Stale     bool      `json:"stale"`
+	Expired   bool      `json:"expired"`
+}
+
+// Freshness returns how current the record is at now
+func (r *AppRecord) Freshness(now time.Time) RecordFreshness {
+	age := max(now.Sub(r.Created), 0)
+	remaining := max(r.Expires().Sub(now), 0)
+	return RecordFreshness{
+		Age:       Duration(age),
+		Remaining: Duration(remaining),
+		Expires:   r.Expires(),
+		Stale:     age > time.Duration(r.TTL)/2,
+		Expired:   remaining == 0,
+	}
+}
+
+// AppRecordKey is the DHT key of a publisher's record
+func AppRecordKey(publisher peer.ID, name string) string {
+	return fmt.Sprintf("/%s/%s/%s", AppNamespace, publisher, name)
+}
+
+// AppRecordValidator validates app records for the DHT and selects the one
+// with the highest sequence number, the latest created on a tie
+type AppRecordValidator struct{}
+
+// Validate checks that value is an app record for key, signed by its
+// publisher, with a valid TTL and not expired
+func (AppRecordValidator) Validate(key string, value []byte) error {
+	_, err := consumeAppRecord(key, value, time.Now())
+	return err
+}
+
+// Select returns the index of the best valid record
+func (AppRecordValidator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var bestRec *AppRecord
+	now := time.Now()
+	for i, value := range values {
+		rec, err := consumeAppRecord(key, value, now)
+		if err != nil {
+			continue
+		}
+		if bestRec == nil || rec.Seq > bestRec.Seq || (rec.Seq == bestRec.Seq && rec.Created.After(bestRec.Created)) {
+			best, bestRec = i, rec
+		}
+	}
+	if best < 0 {
+		return 0, fmt.Errorf("no valid app record for %s", key)
+	}
+	return best, nil
+}
+
+// consumeAppRecord opens a sealed app record and checks it against key
+func consumeAppRecord(key string, data []byte, now time.Time) (*AppRecord, error) {
+	var rec AppRecord
+	env, err := record.ConsumeTypedEnvelope(data, &rec)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	if signer != rec.Publisher {
+		return nil, fmt.Errorf("record of %s signed by %s", rec.Publisher, signer)
+	}
+	if want := AppRecordKey(rec.Publisher, rec.Name); key != want {
+		return nil, fmt.Errorf("record for %s stored under %s", want, key)
+	}
+	if rec.TTL <= 0 || time.Duration(rec.TTL) > MaxAppRecordTTL {
+		return nil, fmt.Errorf("record TTL %s is not between 0 and %s", time.Duration(rec.TTL), MaxAppRecordTTL)
+	}
+	if rec.Created.After(now.Add(appRecordClockSkew)) {
+		return nil, fmt.Errorf("record created in the future at %s", rec.Created.Format(time.RFC3339))
+	}
+	if !now.Before(rec.Expires()) {
+		return nil, fmt.Errorf("%w at %s", ErrRecordExpired, rec.Expires().Format(time.RFC3339))
+	}
+	return &rec, nil
+}
+
+// AppRecords publishes and looks up app records in the DHT
+type AppRecords struct {
+	dht  *discovery.DHT
+	self peer.ID
+	key  crypto.PrivKey
+
+	mu   sync.Mutex
+	seqs map[string]uint64
+}
+
+// NewAppRecords publishes records signed with key in d. The DHT must
+// validate the app namespace with AppRecordValidator, which the Amino DHT
+// (/ipfs) refuses to do; Node uses one under AppDHTPrefix.
+func NewAppRecords(d *discovery.DHT, key crypto.PrivKey) (*AppRecords, error) {
+	self, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	return &AppRecords{dht: d, self: self, key: key, seqs: make(map[string]uint64)}, nil
+}
+
+// Put publishes value under name for ttl (DefaultAppRecordTTL if 0). Each
+// Put gets a higher sequence number than the last, so it replaces the
+// previous record. Publish again before half the TTL is over to keep the
+// record fresh.
+func (a *AppRecords) Put(ctx context.Context, name string, value []byte, ttl time.Duration) (*AppRecord, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid record name %q", name)
+	}
+	if ttl == 0 {
+		ttl = DefaultAppRecordTTL
+	}
+	if ttl < 0 || ttl > MaxAppRecordTTL {
+		return nil, fmt.Errorf("record TTL must be between 0 and %s", MaxAppRecordTTL)
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	seq := max(uint64(now.UnixNano()), a.seqs[name]+1)
+	a.seqs[name] = seq
+	a.mu.Unlock()
+
+	rec := &AppRecord{
+		Publisher: a.self,
+		Name:      name,
+		Value:     value,
+		Created:   now,
+		TTL:       Duration(ttl),
+		Seq:       seq,
+	}
+	env, err := record.Seal(rec, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal record: %w", err)
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+	if err := a.dht.PutValue(ctx, AppRecordKey(a.self, name), data); err != nil {
+		return nil, fmt.Errorf("failed to publish record: %w", err)
+	}
+	return rec, nil
+}
+
+// Get looks up the newest record a publisher published under name.
+// Expired records are never returned; check Freshness for stale ones.
+func (a *AppRecords) Get(ctx context.Context, publisher peer.ID, name string) (*AppRecord, error) {
+	key := AppRecordKey(publisher, name)
+	data, err := a.dht.GetValue(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up record: %w", err)
+	}
+	return consumeAppRecord(key, data, time.Now())
+}
+
+// Records returns the app records of the DHT, which is nil until the node
+// is started
+func (n *Node) Records() *AppRecords {
+	return n.records
+}
@@ -0,0 +1,114 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppRecordValidator(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	seal := func(rec *AppRecord, key crypto.PrivKey) []byte {
+		env, err := record.Seal(rec, key)
+		require.NoError(t, err)
+		data, err := env.Marshal()
+		require.NoError(t, err)
+		return data
+	}
+	rec := func(seq uint64, created time.Time, ttl time.Duration) *AppRecord {
+		return &AppRecord{Publisher: id, Name: "name", Value: []byte("v"), Created: created, TTL: Duration(ttl), Seq: seq}
+	}
+	now := time.Now()
+	key1 := AppRecordKey(id, "name")
+	v := AppRecordValidator{}
+
+	assert.NoError(t, v.Validate(key1, seal(rec(1, now, time.Hour), key)))
+	assert.Error(t, v.Validate(key1, seal(rec(1, now, time.Hour), other)), "records signed by another peer must be rejected")
+	assert.Error(t, v.Validate(AppRecordKey(id, "other"), seal(rec(1, now, time.Hour), key)), "records must match their key")
+	assert.Error(t, v.Validate(key1, seal(rec(1, now, 0), key)), "records need a TTL")
+	assert.Error(t, v.Validate(key1, seal(rec(1, now, MaxAppRecordTTL+time.Hour), key)))
+	assert.Error(t, v.Validate(key1, seal(rec(1, now.Add(time.Hour), time.Hour), key)), "records created in the future must be rejected")
+
+	err = v.Validate(key1, seal(rec(1, now.Add(-2*time.Hour), time.Hour), key))
+	assert.True(t, errors.Is(err, ErrRecordExpired))
+
+	best, err := v.Select(key1, [][]byte{
+		seal(rec(1, now, time.Hour), key),
+		seal(rec(3, now.Add(-2*time.Hour), time.Hour), key), // expired
+		seal(rec(2, now, time.Hour), key),
+		seal(rec(5, now, time.Hour), other),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, best)
+
+	_, err = v.Select(key1, [][]byte{[]byte("garbage")})
+	assert.Error(t, err)
+}
+
+func TestAppRecordFreshness(t *testing.T) {
+	created := time.Now()
+	rec := &AppRecord{Created: created, TTL: Duration(time.Hour)}
+
+	f := rec.Freshness(created.Add(10 * time.Minute))
+	assert.Equal(t, Duration(10*time.Minute), f.Age)
+	assert.Equal(t, Duration(50*time.Minute), f.Remaining)
+	assert.False(t, f.Stale)
+	assert.False(t, f.Expired)
+
+	f = rec.Freshness(created.Add(40 * time.Minute))
+	assert.True(t, f.Stale)
+	assert.False(t, f.Expired)
+
+	f = rec.Freshness(created.Add(2 * time.Hour))
+	assert.Equal(t, Duration(0), f.Remaining)
+	assert.True(t, f.Expired)
+}
+
+func TestAppRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	publisher, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	reader, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	require.NoError(t, publisher.Start(ctx))
+	defer publisher.Stop(ctx)
+	require.NoError(t, reader.Start(ctx))
+	defer reader.Stop(ctx)
+
+	require.NoError(t, connectNodes(ctx, publisher.Host(), reader.Host()))
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		return publisher.AppDHT().RoutingTable().Size() > 0 && reader.AppDHT().RoutingTable().Size() > 0
+	}, 10*time.Second, 50*time.Millisecond))
+
+	_, err = publisher.Records().Put(ctx, "bad/name", nil, 0)
+	assert.Error(t, err)
+
+	first, err := publisher.Records().Put(ctx, "inbox", []byte("v1"), time.Hour)
+	require.NoError(t, err)
+	second, err := publisher.Records().Put(ctx, "inbox", []byte("v2"), time.Hour)
+	require.NoError(t, err)
+	assert.Greater(t, second.Seq, first.Seq)
+
+	got, err := reader.Records().Get(ctx, publisher.Host().ID(), "inbox")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), got.Value)
+	assert.Equal(t, second.Seq, got.Seq)
+	assert.Equal(t, Duration(time.Hour), got.TTL)
+	assert.False(t, got.Freshness(time.Now()).Stale)
+}
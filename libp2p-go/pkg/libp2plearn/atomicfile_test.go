@@ -0,0 +1,32 @@
+package libp2plearn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	require.NoError(t, writeFileAtomic(path, []byte("first"), 0600))
+	require.NoError(t, writeFileAtomic(path, []byte("second"), 0600))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files should be left behind")
+
+	assert.Error(t, writeFileAtomic(filepath.Join(dir, "missing", "state.json"), nil, 0600))
+}
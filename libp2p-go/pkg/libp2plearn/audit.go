@@ -0,0 +1,361 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Audit entry types
+const (
+	AuditStream = "stream"
+	AuditAdmin  = "admin"
+)
+
+// auditHashSuffix starts the hash that ends every chained entry
+const auditHashSuffix = `,"hash":"`
+
+// AuditEntry is one line of the audit log. Streams record the bytes moved
+// and whether they were closed or reset; admin actions record the command
+// and its outcome.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Peer       string    `json:"peer"`
+	Protocol   string    `json:"protocol,omitempty"`
+	BytesIn    int64     `json:"bytes_in,omitempty"`
+	BytesOut   int64     `json:"bytes_out,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	Args       []string  `json:"args,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+
+	// With chaining, the hash of the previous entry and of this one
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// AuditLog appends entries to a file as JSON lines. With chaining, each entry
+// carries the SHA-256 of the previous one, so editing or removing an entry
+// breaks every hash after it. A nil AuditLog records nothing.
+type AuditLog struct {
+	path    string
+	chain   bool
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	last string
+}
+
+// OpenAuditLog opens the audit log at path for appending, picking up the
+// hash chain where it left off. The file is rotated once it grows past
+// maxSize bytes (0 to never rotate).
+func OpenAuditLog(path string, chain bool, maxSize int64) (*AuditLog, error) {
+	a := &AuditLog{path: path, chain: chain, maxSize: maxSize}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	if chain {
+		last, err := lastAuditHash(a.file, a.size)
+		if err != nil {
+			a.file.Close()
+			return nil, err
+		}
+		a.last = last
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file":  path,
+		"chain": chain,
+	}).Info("Audit log enabled")
+	return a, nil
+}
+
+// Close closes the file
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// Record appends an entry, filling in its time if unset
+func (a *AuditLog) Record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry.Prev, entry.Hash = "", ""
+	if a.chain {
+		entry.Prev = a.last
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode audit entry")
+		return
+	}
+	if a.chain {
+		sum := sha256.Sum256(line)
+		a.last = hex.EncodeToString(sum[:])
+		line = append(line[:len(line)-1], auditHashSuffix+a.last+`"}`...)
+	}
+	line = append(line, '\n')
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			logrus.WithError(err).Error("Failed to rotate audit log")
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write audit entry")
+	}
+}
+
+// Action records an admin action by a peer and its outcome
+func (a *AuditLog) Action(p peer.ID, proto protocol.ID, action string, args []string, err error) {
+	entry := AuditEntry{
+		Type:     AuditAdmin,
+		Peer:     p.String(),
+		Protocol: string(proto),
+		Action:   action,
+		Args:     args,
+		Result:   "ok",
+	}
+	if err != nil {
+		entry.Result, entry.Error = "error", err.Error()
+	}
+	a.Record(entry)
+}
+
+// WrapHost returns h with every stream handler registered through it audited
+func (a *AuditLog) WrapHost(h host.Host) host.Host {
+	if a == nil {
+		return h
+	}
+	return &auditedHost{Host: h, audit: a}
+}
+
+// open opens the current file for appending, and for reading so a reopened
+// chain can find its last hash; a.mu must be held or a not yet shared
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	a.file, a.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the current file aside with a timestamp and starts a new
+// one; the chain continues into it. a.mu must be held.
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(a.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(a.path, ext), time.Now().UTC().Format("20060102T150405.000000000"), ext)
+	if err := os.Rename(a.path, rotated); err != nil {
+		// Keep appending to the old file rather than losing entries
+		logrus.WithError(err).Warn("Failed to move audit log aside")
+	}
+	logrus.WithField("file", rotated).Info("Rotated audit log")
+	return a.open()
+}
+
+// lastAuditHash reads the hash of the last entry of a chained log
+func lastAuditHash(r io.ReaderAt, size int64) (string, error) {
+	if size == 0 {
+		return "", nil
+	}
+	// Entries are small, so the last one is within the final 64 KiB
+	start := max(size-64*1024, 0)
+	buf := make([]byte, size-start)
+	if _, err := r.ReadAt(buf, start); err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	line := buf[bytes.LastIndexByte(buf, '\n')+1:]
+	_, hash, err := splitAuditHash(line)
+	if err != nil {
+		// Chaining was just turned on: start a new chain after the unchained entries
+		logrus.Warn("Audit log doesn't end with a chained entry, starting a new chain")
+		return "", nil
+	}
+	return hash, nil
+}
+
+// splitAuditHash returns the bytes a chained entry's hash covers, and the hash
+func splitAuditHash(line []byte) ([]byte, string, error) {
+	const hashLen = 2 * sha256.Size
+	end := len(line) - len(`"}`) - hashLen
+	if end < len(auditHashSuffix) || !bytes.HasSuffix(line, []byte(`"}`)) ||
+		string(line[end-len(auditHashSuffix):end]) != auditHashSuffix {
+		return nil, "", fmt.Errorf("entry has no hash")
+	}
+	hash := string(line[end : end+hashLen])
+	body := append(append([]byte{}, line[:end-len(auditHashSuffix)]...), '}')
+	return body, hash, nil
+}
+
+// VerifyAuditLog checks the hash chain of audit log files given oldest first,
+// e.g. the rotated files followed by the current one, and returns how many
+// entries it checked. The first file may start mid-chain, since older files
+// may have been archived.
+func VerifyAuditLog(paths ...string) (int, error) {
+	count := 0
+	prev := ""
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return count, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			body, hash, err := splitAuditHash(line)
+			if err != nil {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			var entry AuditEntry
+			if err := json.Unmarshal(body, &entry); err != nil {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: invalid entry: %w", path, lineNo, err)
+			}
+			if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != hash {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: entry was modified", path, lineNo)
+			}
+			if entry.Prev != prev && !(i == 0 && count == 0) {
+				file.Close()
+				return count, fmt.Errorf("%s:%d: chain broken, an entry before it was removed or changed", path, lineNo)
+			}
+			prev = hash
+			count++
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return count, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return count, nil
+}
+
+// auditedHost audits the streams of every handler registered through it
+type auditedHost struct {
+	host.Host
+	audit *AuditLog
+}
+
+func (h *auditedHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.wrap(handler))
+}
+
+func (h *auditedHost) SetStreamHandlerMatch(pid protocol.ID, match func(protocol.ID) bool, handler network.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, h.wrap(handler))
+}
+
+func (h *auditedHost) wrap(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		handler(&auditedStream{Stream: s, audit: h.audit, start: time.Now()})
+	}
+}
+
+// auditedStream records the stream once it is closed or reset
+type auditedStream struct {
+	network.Stream
+	audit *AuditLog
+	start time.Time
+	in    atomic.Int64
+	out   atomic.Int64
+	once  sync.Once
+}
+
+func (s *auditedStream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	s.in.Add(int64(n))
+	return n, err
+}
+
+func (s *auditedStream) Write(b []byte) (int, error) {
+	n, err := s.Stream.Write(b)
+	s.out.Add(int64(n))
+	return n, err
+}
+
+func (s *auditedStream) Close() error {
+	err := s.Stream.Close()
+	s.finish("closed")
+	return err
+}
+
+func (s *auditedStream) Reset() error {
+	err := s.Stream.Reset()
+	s.finish("reset")
+	return err
+}
+
+func (s *auditedStream) ResetWithError(code network.StreamErrorCode) error {
+	err := s.Stream.ResetWithError(code)
+	s.finish("reset")
+	return err
+}
+
+func (s *auditedStream) finish(result string) {
+	s.once.Do(func() {
+		s.audit.Record(AuditEntry{
+			Type:       AuditStream,
+			Peer:       s.Conn().RemotePeer().String(),
+			Protocol:   string(s.Protocol()),
+			BytesIn:    s.in.Load(),
+			BytesOut:   s.out.Load(),
+			DurationMs: time.Since(s.start).Milliseconds(),
+			Result:     result,
+		})
+	})
+}
@@ -0,0 +1,191 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAuditLog returns the entries of an audit log file
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var entries []AuditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry AuditEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("ChainVerifies", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		audit, err := OpenAuditLog(path, true, 0)
+		require.NoError(t, err)
+		for i := range 3 {
+			audit.Record(AuditEntry{Type: AuditAdmin, Peer: "peer", Action: fmt.Sprint("cmd", i), Result: "ok"})
+		}
+		require.NoError(t, audit.Close())
+
+		count, err := VerifyAuditLog(path)
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		entries := readAuditLog(t, path)
+		assert.Empty(t, entries[0].Prev)
+		assert.Equal(t, entries[0].Hash, entries[1].Prev)
+		assert.Equal(t, entries[1].Hash, entries[2].Prev)
+	})
+
+	t.Run("ChainResumesAfterReopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		audit, err := OpenAuditLog(path, true, 0)
+		require.NoError(t, err)
+		audit.Record(AuditEntry{Type: AuditAdmin, Action: "before", Result: "ok"})
+		require.NoError(t, audit.Close())
+
+		audit, err = OpenAuditLog(path, true, 0)
+		require.NoError(t, err)
+		audit.Record(AuditEntry{Type: AuditAdmin, Action: "after", Result: "ok"})
+		require.NoError(t, audit.Close())
+
+		count, err := VerifyAuditLog(path)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("DetectsTampering", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		audit, err := OpenAuditLog(path, true, 0)
+		require.NoError(t, err)
+		audit.Record(AuditEntry{Type: AuditAdmin, Action: "disconnect", Result: "ok"})
+		audit.Record(AuditEntry{Type: AuditAdmin, Action: "log_level", Result: "ok"})
+		audit.Record(AuditEntry{Type: AuditAdmin, Action: "peers", Result: "ok"})
+		require.NoError(t, audit.Close())
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		edited := filepath.Join(t.TempDir(), "edited.log")
+		require.NoError(t, os.WriteFile(edited, []byte(strings.Replace(string(data), "log_level", "loglevel!", 1)), 0600))
+		_, err = VerifyAuditLog(edited)
+		assert.ErrorContains(t, err, "modified")
+
+		lines := strings.SplitAfter(string(data), "\n")
+		removed := filepath.Join(t.TempDir(), "removed.log")
+		require.NoError(t, os.WriteFile(removed, []byte(lines[0]+lines[2]), 0600))
+		_, err = VerifyAuditLog(removed)
+		assert.ErrorContains(t, err, "chain broken")
+	})
+
+	t.Run("Rotates", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "audit.log")
+		audit, err := OpenAuditLog(path, true, 600)
+		require.NoError(t, err)
+		for i := range 10 {
+			audit.Record(AuditEntry{Type: AuditStream, Peer: "peer", Protocol: EchoProtocol, BytesIn: int64(i), Result: "closed"})
+		}
+		require.NoError(t, audit.Close())
+
+		rotated, err := filepath.Glob(filepath.Join(dir, "audit-*.log"))
+		require.NoError(t, err)
+		require.NotEmpty(t, rotated)
+		for _, file := range rotated {
+			info, err := os.Stat(file)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, info.Size(), int64(600))
+		}
+
+		// Rotated files sort oldest first; the chain runs through all of them
+		count, err := VerifyAuditLog(append(rotated, path)...)
+		require.NoError(t, err)
+		assert.Equal(t, 10, count)
+
+		// The newest file alone verifies too, starting mid-chain
+		_, err = VerifyAuditLog(path)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unchained", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		audit, err := OpenAuditLog(path, false, 0)
+		require.NoError(t, err)
+		audit.Record(AuditEntry{Type: AuditAdmin, Action: "stats", Result: "ok"})
+		require.NoError(t, audit.Close())
+
+		entries := readAuditLog(t, path)
+		require.Len(t, entries, 1)
+		assert.Empty(t, entries[0].Hash)
+		_, err = VerifyAuditLog(path)
+		assert.ErrorContains(t, err, "no hash")
+	})
+
+	t.Run("NilRecordsNothing", func(t *testing.T) {
+		var audit *AuditLog
+		audit.Record(AuditEntry{Type: AuditAdmin})
+		assert.NoError(t, audit.Close())
+	})
+}
+
+func TestAuditedHost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path, true, 0)
+	require.NoError(t, err)
+	defer audit.Close()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	audited := audit.WrapHost(server)
+	audited.SetStreamHandler(EchoProtocol, func(s network.Stream) {
+		defer s.Close()
+		line, err := bufio.NewReader(s).ReadString('\n')
+		if err == nil {
+			s.Write([]byte(line))
+		}
+	})
+
+	s, err := client.NewStream(ctx, server.ID(), EchoProtocol)
+	require.NoError(t, err)
+	_, err = s.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(s)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(resp))
+	s.Close()
+
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() > 0
+	}, 5*time.Second, 50*time.Millisecond))
+
+	entries := readAuditLog(t, path)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AuditStream, entries[0].Type)
+	assert.Equal(t, client.ID().String(), entries[0].Peer)
+	assert.Equal(t, EchoProtocol, entries[0].Protocol)
+	assert.Equal(t, int64(6), entries[0].BytesIn)
+	assert.Equal(t, int64(6), entries[0].BytesOut)
+	assert.Equal(t, "closed", entries[0].Result)
+}
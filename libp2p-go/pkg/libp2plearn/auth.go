@@ -0,0 +1,350 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// authTimeout bounds the token handshake at the start of a stream
+	authTimeout = 10 * time.Second
+
+	// maxAuthMessageSize bounds a token or handshake reply
+	maxAuthMessageSize = 8 * 1024
+
+	// authClockSkew is how far token times may be off from our clock
+	authClockSkew = time.Minute
+)
+
+// errTokenSignature is returned for tokens whose signature doesn't verify
+var errTokenSignature = errors.New("invalid token signature")
+
+// tokenHeader is the JWT header of every token; tokens are signed with the
+// issuer's Ed25519 peer key
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT"}`))
+
+// TokenClaims are the claims of an authorization token. Issuer, subject and
+// audience are peer IDs: the token lets the subject use the audience's
+// protocols that need one of the scopes.
+type TokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	Scopes    []string `json:"scopes,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Expiry    int64    `json:"exp"`
+}
+
+// HasScope reports whether the token grants a scope
+func (c *TokenClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// IssueToken signs claims as a JWT with the issuer's key. The issuer is set
+// from the key and IssuedAt defaults to now.
+func IssueToken(key crypto.PrivKey, claims TokenClaims) (string, error) {
+	issuer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive issuer ID: %w", err)
+	}
+	if key.Type() != crypto.Ed25519 {
+		return "", fmt.Errorf("tokens need an Ed25519 issuer key, have %s", key.Type())
+	}
+	claims.Issuer = issuer.String()
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token claims: %w", err)
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := key.Sign([]byte(signed))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ParseToken checks a token's signature against its issuer and returns its
+// claims. Whether the token is current and grants anything is up to the caller.
+func ParseToken(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, fmt.Errorf("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token claims: %w", err)
+	}
+	issuer, err := peer.Decode(claims.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token issuer: %w", err)
+	}
+	pub, err := issuer.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issuer public key: %w", err)
+	}
+	ok, err := pub.Verify([]byte(parts[0]+"."+parts[1]), sig)
+	if err != nil || !ok {
+		return nil, errTokenSignature
+	}
+	return &claims, nil
+}
+
+// Auth requires a token at the start of every stream for private protocols.
+// The initiator sends its token before anything else and the handler only
+// runs once the token is accepted.
+type Auth struct {
+	host      host.Host
+	protocols map[protocol.ID]string
+	issuers   map[peer.ID]bool
+
+	mu     sync.RWMutex
+	tokens map[peer.ID][]string // by audience
+}
+
+// NewAuth creates a token check for the protocols in scopes, which maps
+// protocol IDs to the scope a token needs for them ("" for any token). Tokens
+// issued by this node or by one of issuers are accepted.
+func NewAuth(h host.Host, scopes map[string]string, issuers []string) (*Auth, error) {
+	a := &Auth{
+		host:      h,
+		protocols: make(map[protocol.ID]string, len(scopes)),
+		issuers:   map[peer.ID]bool{h.ID(): true},
+		tokens:    make(map[peer.ID][]string),
+	}
+	for proto, scope := range scopes {
+		a.protocols[protocol.ID(proto)] = scope
+	}
+	for _, id := range issuers {
+		p, err := peer.Decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token issuer %q: %w", id, err)
+		}
+		a.issuers[p] = true
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"protocols": len(a.protocols),
+		"issuers":   len(a.issuers),
+	}).Info("Token authorization enabled")
+	return a, nil
+}
+
+// AddToken stores a token to present to its audience
+func (a *Auth) AddToken(token string) error {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return err
+	}
+	audience, err := peer.Decode(claims.Audience)
+	if err != nil {
+		return fmt.Errorf("invalid token audience: %w", err)
+	}
+	if claims.Subject != a.host.ID().String() {
+		return fmt.Errorf("token was issued to %s, not this node", claims.Subject)
+	}
+
+	a.mu.Lock()
+	a.tokens[audience] = append(a.tokens[audience], token)
+	a.mu.Unlock()
+	return nil
+}
+
+// Protected reports whether a protocol needs a token
+func (a *Auth) Protected(proto protocol.ID) bool {
+	_, ok := a.protocols[baseProtocol(proto)]
+	return ok
+}
+
+// Check validates a token presented by a peer for a protocol
+func (a *Auth) Check(token string, from peer.ID, proto protocol.ID) (*TokenClaims, error) {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	issuer, _ := peer.Decode(claims.Issuer) // checked by ParseToken
+	if !a.issuers[issuer] {
+		return nil, fmt.Errorf("token issuer %s is not trusted", issuer)
+	}
+	if claims.Audience != a.host.ID().String() {
+		return nil, fmt.Errorf("token is not valid for this node")
+	}
+	if claims.Subject != from.String() {
+		return nil, fmt.Errorf("token was issued to another peer")
+	}
+
+	now := time.Now()
+	if claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(authClockSkew)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-authClockSkew)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if scope := a.protocols[baseProtocol(proto)]; scope != "" && !claims.HasScope(scope) {
+		return nil, fmt.Errorf("token lacks scope %q", scope)
+	}
+	return claims, nil
+}
+
+// Middleware runs the token handshake before handlers of protected protocols
+func (a *Auth) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	if !a.Protected(proto) {
+		return next
+	}
+	return func(s network.Stream) {
+		remote := s.Conn().RemotePeer()
+		s.SetDeadline(time.Now().Add(authTimeout))
+
+		token, err := readAuthMessage(s)
+		if err != nil {
+			logrus.WithError(err).WithField("peer", remote).Debug("Failed to read token")
+			s.Reset()
+			return
+		}
+		claims, err := a.Check(string(token), remote, proto)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"peer":     remote,
+				"protocol": proto,
+			}).Warn("Rejected stream with invalid token")
+			if errors.Is(err, errTokenSignature) {
+				ReportMisbehavior(a.host, remote, MisbehaviorInvalidSignature, "token")
+			}
+			writeAuthMessage(s, []byte(err.Error()))
+			s.Close()
+			return
+		}
+		if err := writeAuthMessage(s, nil); err != nil {
+			s.Reset()
+			return
+		}
+		s.SetDeadline(time.Time{})
+
+		logrus.WithFields(logrus.Fields{
+			"peer":     remote,
+			"protocol": proto,
+			"issuer":   claims.Issuer,
+		}).Debug("Authorized stream")
+		next(s)
+	}
+}
+
+// Present runs the initiator's side of the handshake on a new stream of a
+// protected protocol, sending the token held for the peer
+func (a *Auth) Present(s network.Stream) error {
+	remote := s.Conn().RemotePeer()
+	token := a.token(remote)
+	if token == "" {
+		return fmt.Errorf("no token for %s", remote)
+	}
+
+	s.SetDeadline(time.Now().Add(authTimeout))
+	defer s.SetDeadline(time.Time{})
+	if err := writeAuthMessage(s, []byte(token)); err != nil {
+		return fmt.Errorf("failed to send token: %w", err)
+	}
+	reply, err := readAuthMessage(s)
+	if err != nil {
+		return fmt.Errorf("failed to read token reply: %w", err)
+	}
+	if len(reply) > 0 {
+		return fmt.Errorf("peer rejected token: %s", reply)
+	}
+	return nil
+}
+
+// Opener presents tokens on streams for protected protocols opened through o
+func (a *Auth) Opener(o StreamOpener) StreamOpener {
+	return &authOpener{auth: a, next: o}
+}
+
+// token returns a current token for a peer, or "" if there is none
+func (a *Auth) token(p peer.ID) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	now := time.Now().Unix()
+	for _, token := range a.tokens[p] {
+		claims, err := ParseToken(token)
+		if err == nil && claims.Expiry > now {
+			return token
+		}
+	}
+	return ""
+}
+
+// authOpener runs the handshake on outgoing streams
+type authOpener struct {
+	auth *Auth
+	next StreamOpener
+}
+
+func (o *authOpener) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := o.next.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	if !o.auth.Protected(s.Protocol()) {
+		return s, nil
+	}
+	if err := o.auth.Present(s); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeAuthMessage writes a big-endian uint16 length and the message
+func writeAuthMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readAuthMessage reads exactly one message, so nothing after it is consumed
+func readAuthMessage(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint16(size[:])
+	if n > maxAuthMessageSize {
+		return nil, fmt.Errorf("auth message too large: %d bytes", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
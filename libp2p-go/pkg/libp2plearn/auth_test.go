@@ -0,0 +1,174 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	issuerKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	issuer, err := peer.IDFromPrivateKey(issuerKey)
+	require.NoError(t, err)
+
+	token, err := IssueToken(issuerKey, TokenClaims{
+		Subject:  "subject",
+		Audience: "audience",
+		Scopes:   []string{"blobstore:read"},
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	claims, err := ParseToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, issuer.String(), claims.Issuer)
+	assert.True(t, claims.HasScope("blobstore:read"))
+	assert.False(t, claims.HasScope("blobstore:write"))
+	assert.NotZero(t, claims.IssuedAt)
+
+	// Changing the claims breaks the signature
+	other, err := IssueToken(issuerKey, TokenClaims{Subject: "subject", Audience: "audience", Scopes: []string{"admin"}})
+	require.NoError(t, err)
+	otherParts, parts := strings.Split(other, "."), strings.Split(token, ".")
+	_, err = ParseToken(otherParts[0] + "." + otherParts[1] + "." + parts[2])
+	assert.ErrorContains(t, err, "invalid token signature")
+
+	_, err = ParseToken("not.a.token")
+	assert.Error(t, err)
+}
+
+func TestAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const privateProtocol = "/libp2p-learn/test-private/1.0.0"
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	issuerKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	issuer, err := peer.IDFromPrivateKey(issuerKey)
+	require.NoError(t, err)
+
+	serverAuth, err := NewAuth(server, map[string]string{privateProtocol: "blobstore:read"}, []string{issuer.String()})
+	require.NoError(t, err)
+	serverHandler := NewProtocolHandler(server)
+	serverHandler.Use(serverAuth.Middleware)
+	serverHandler.Handle(privateProtocol, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	serverHandler.SetupProtocols()
+
+	issue := func(claims TokenClaims) string {
+		if claims.Subject == "" {
+			claims.Subject = client.ID().String()
+		}
+		if claims.Audience == "" {
+			claims.Audience = server.ID().String()
+		}
+		if claims.Expiry == 0 {
+			claims.Expiry = time.Now().Add(time.Hour).Unix()
+		}
+		token, err := IssueToken(issuerKey, claims)
+		require.NoError(t, err)
+		return token
+	}
+
+	// call opens a private stream presenting token and echoes a line
+	call := func(token string) (string, error) {
+		clientAuth, err := NewAuth(client, map[string]string{privateProtocol: ""}, nil)
+		require.NoError(t, err)
+		if token != "" {
+			require.NoError(t, clientAuth.AddToken(token))
+		}
+		s, err := clientAuth.Opener(client).NewStream(ctx, server.ID(), privateProtocol)
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+		if _, err := s.Write([]byte("private data\n")); err != nil {
+			return "", err
+		}
+		return bufio.NewReader(s).ReadString('\n')
+	}
+
+	t.Run("ValidToken", func(t *testing.T) {
+		resp, err := call(issue(TokenClaims{Scopes: []string{"blobstore:read"}}))
+		require.NoError(t, err)
+		assert.Equal(t, "private data\n", resp)
+	})
+
+	t.Run("NoToken", func(t *testing.T) {
+		_, err := call("")
+		assert.ErrorContains(t, err, "no token")
+	})
+
+	t.Run("MissingScope", func(t *testing.T) {
+		_, err := call(issue(TokenClaims{Scopes: []string{"blobstore:write"}}))
+		assert.ErrorContains(t, err, "lacks scope")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		// Tokens past their expiry are not even presented
+		_, err := call(issue(TokenClaims{Scopes: []string{"blobstore:read"}, Expiry: time.Now().Add(-time.Hour).Unix()}))
+		assert.ErrorContains(t, err, "no token")
+
+		token := issue(TokenClaims{Scopes: []string{"blobstore:read"}, Expiry: time.Now().Add(-time.Hour).Unix()})
+		_, err = serverAuth.Check(token, client.ID(), privateProtocol)
+		assert.ErrorContains(t, err, "expired")
+	})
+
+	t.Run("WrongAudienceOrSubject", func(t *testing.T) {
+		token := issue(TokenClaims{Scopes: []string{"blobstore:read"}, Audience: client.ID().String()})
+		_, err := serverAuth.Check(token, client.ID(), privateProtocol)
+		assert.ErrorContains(t, err, "not valid for this node")
+
+		_, err = serverAuth.Check(issue(TokenClaims{Scopes: []string{"blobstore:read"}}), server.ID(), privateProtocol)
+		assert.ErrorContains(t, err, "issued to another peer")
+	})
+
+	t.Run("UntrustedIssuer", func(t *testing.T) {
+		otherKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		token, err := IssueToken(otherKey, TokenClaims{
+			Subject:  client.ID().String(),
+			Audience: server.ID().String(),
+			Scopes:   []string{"blobstore:read"},
+			Expiry:   time.Now().Add(time.Hour).Unix(),
+		})
+		require.NoError(t, err)
+		_, err = call(token)
+		assert.ErrorContains(t, err, "not trusted")
+	})
+
+	t.Run("PublicProtocolsUnaffected", func(t *testing.T) {
+		clientAuth, err := NewAuth(client, map[string]string{privateProtocol: ""}, nil)
+		require.NoError(t, err)
+		handler := NewProtocolHandler(client)
+		handler.SetStreamOpener(clientAuth.Opener(client))
+		resp, err := handler.SendPing(ctx, server.ID(), "hi")
+		require.NoError(t, err)
+		assert.Contains(t, resp, "pong")
+	})
+
+	assert.True(t, serverAuth.Protected(protocol.ID(privateProtocol)))
+}
@@ -0,0 +1,235 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/autonat"
+	"github.com/libp2p/go-libp2p/p2p/host/autonat/pb"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// autonatQuotaWindow is the period a peer's dial-back quota covers
+const autonatQuotaWindow = 24 * time.Hour
+
+// Reasons the AutoNAT service refuses to dial a peer back. libp2p's own
+// reasons, such as "rate limited", are counted alongside.
+const (
+	autonatRefusedNetwork = "target network"
+	autonatRefusedQuota   = "peer quota"
+)
+
+// autonatDialBacks counts answered dial-back requests by result: ok or
+// dial_error for attempts, dial_refused when libp2p refused the request
+var autonatDialBacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "autonat",
+	Name:      "dialbacks_total",
+	Help:      "Number of AutoNAT dial-back requests answered, by result",
+}, []string{"result"})
+
+// autonatRefused counts refused dial-back requests by reason
+var autonatRefused = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "autonat",
+	Name:      "refused_total",
+	Help:      "Number of AutoNAT dial-back requests refused by reason",
+}, []string{"reason"})
+
+// AutoNATPolicy limits how the AutoNAT service dials peers back
+type AutoNATPolicy struct {
+	// Rate is the number of dial-backs per Interval across all peers, and
+	// PeerRate the number for a single peer
+	Rate     int
+	PeerRate int
+	Interval time.Duration
+	// PeerQuota caps the dial-backs for one peer per day (0 for no cap)
+	PeerQuota int
+	// Networks are the CIDRs peers may be dialed back in. Empty allows any
+	// public address; private addresses are never dialed back.
+	Networks []string
+}
+
+// peerQuota is how many dial-backs a peer used in its current window
+type peerQuota struct {
+	used  int
+	start time.Time
+}
+
+// AutoNATService answers AutoNAT requests by dialing peers back from a
+// separate dialer host, so they learn whether they are reachable. libp2p
+// runs the protocol; the policy decides who gets an answer, so a public
+// node can't be used to scan ports.
+type AutoNATService struct {
+	host     host.Host
+	dialer   host.Host
+	service  autonat.AutoNAT
+	policy   AutoNATPolicy
+	networks []*net.IPNet
+
+	mu     sync.Mutex
+	quotas map[peer.ID]*peerQuota
+}
+
+// NewAutoNATService starts answering AutoNAT requests under the policy
+func NewAutoNATService(h host.Host, policy AutoNATPolicy) (*AutoNATService, error) {
+	s := &AutoNATService{
+		host:   h,
+		policy: policy,
+		quotas: make(map[peer.ID]*peerQuota),
+	}
+	for _, cidr := range policy.Networks {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid autonat network %q: %w", cidr, err)
+		}
+		s.networks = append(s.networks, ipNet)
+	}
+
+	// Dial back from a host of its own, with another peer ID and no
+	// listeners, so a successful dial proves the peer is reachable
+	dialer, err := libp2p.New(libp2p.NoListenAddrs, libp2p.DisableRelay(), libp2p.DisableMetrics())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create autonat dialer: %w", err)
+	}
+	s.dialer = dialer
+
+	// The service reports its host as publicly reachable, which it tells
+	// its own event bus rather than the node's
+	service, err := autonat.New(&autonatServiceHost{Host: h, bus: eventbus.NewBus(), service: s},
+		autonat.EnableService(dialer.Network()),
+		autonat.WithReachability(network.ReachabilityPublic),
+		autonat.WithThrottling(policy.Rate, policy.Interval),
+		autonat.WithPeerThrottling(policy.PeerRate),
+		autonat.WithMetricsTracer(autonatMetrics{}))
+	if err != nil {
+		dialer.Close()
+		return nil, fmt.Errorf("failed to start autonat service: %w", err)
+	}
+	s.service = service
+
+	logrus.WithFields(logrus.Fields{
+		"rate":       policy.Rate,
+		"peer_rate":  policy.PeerRate,
+		"interval":   policy.Interval,
+		"peer_quota": policy.PeerQuota,
+		"networks":   policy.Networks,
+	}).Info("Started AutoNAT service")
+	return s, nil
+}
+
+// Close stops answering requests and closes the dialer
+func (s *AutoNATService) Close() {
+	s.service.Close()
+	s.dialer.Close()
+}
+
+// allow decides whether a peer asking from ip gets dialed back, and
+// otherwise returns why not. Dial-backs only ever go to the IP address a
+// request came from, so that is the target network.
+func (s *AutoNATService) allow(p peer.ID, ip net.IP) (string, bool) {
+	if len(s.networks) > 0 {
+		allowed := false
+		for _, ipNet := range s.networks {
+			if ipNet.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return autonatRefusedNetwork, false
+		}
+	}
+
+	if s.policy.PeerQuota <= 0 {
+		return "", true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	quota, ok := s.quotas[p]
+	if !ok || now.Sub(quota.start) > autonatQuotaWindow {
+		for id, q := range s.quotas {
+			if now.Sub(q.start) > autonatQuotaWindow {
+				delete(s.quotas, id)
+			}
+		}
+		quota = &peerQuota{start: now}
+		s.quotas[p] = quota
+	}
+	if quota.used >= s.policy.PeerQuota {
+		return autonatRefusedQuota, false
+	}
+	quota.used++
+	return "", true
+}
+
+// middleware refuses requests the policy doesn't allow by resetting the
+// stream, which the peer takes as a failed request
+func (s *AutoNATService) middleware(next network.StreamHandler) network.StreamHandler {
+	return func(stream network.Stream) {
+		remote := stream.Conn().RemotePeer()
+		ip, err := manet.ToIP(stream.Conn().RemoteMultiaddr())
+		reason, ok := autonatRefusedNetwork, false
+		if err == nil {
+			reason, ok = s.allow(remote, ip)
+		}
+		if !ok {
+			autonatRefused.WithLabelValues(reason).Inc()
+			logrus.WithFields(logrus.Fields{
+				"peer":   remote,
+				"reason": reason,
+			}).Debug("Refused AutoNAT dial-back")
+			stream.Reset()
+			return
+		}
+		next(stream)
+	}
+}
+
+// autonatServiceHost is the node's host as the AutoNAT service sees it:
+// handlers it registers go through the policy, and its events stay private
+type autonatServiceHost struct {
+	host.Host
+	bus     event.Bus
+	service *AutoNATService
+}
+
+func (h *autonatServiceHost) EventBus() event.Bus {
+	return h.bus
+}
+
+func (h *autonatServiceHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.service.middleware(handler))
+}
+
+// autonatMetrics counts what the service does in our metrics. Only the
+// service side is used, since the service's reachability is fixed.
+type autonatMetrics struct{}
+
+func (autonatMetrics) OutgoingDialResponse(status pb.Message_ResponseStatus) {
+	result := strings.ToLower(strings.TrimPrefix(status.String(), "E_"))
+	autonatDialBacks.WithLabelValues(result).Inc()
+}
+
+func (autonatMetrics) OutgoingDialRefused(reason string) {
+	autonatRefused.WithLabelValues(reason).Inc()
+}
+
+func (autonatMetrics) ReachabilityStatus(network.Reachability)        {}
+func (autonatMetrics) ReachabilityStatusConfidence(int)               {}
+func (autonatMetrics) ReceivedDialResponse(pb.Message_ResponseStatus) {}
+func (autonatMetrics) NextProbeTime(time.Time)                        {}
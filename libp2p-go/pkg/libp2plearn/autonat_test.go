@@ -0,0 +1,103 @@
+package libp2plearn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/autonat"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoNATService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	policy := DefaultConfig().AutoNATPolicy()
+	policy.Networks = []string{"203.0.113.0/24"}
+	service, err := NewAutoNATService(server, policy)
+	require.NoError(t, err)
+	assert.Contains(t, server.Mux().Protocols(), protocol.ID(autonat.AutoNATProto))
+
+	t.Run("requests from outside the networks are refused", func(t *testing.T) {
+		require.NoError(t, connectNodes(ctx, client, server))
+		before := testutil.ToFloat64(autonatRefused.WithLabelValues(autonatRefusedNetwork))
+
+		s, err := client.NewStream(ctx, server.ID(), autonat.AutoNATProto)
+		require.NoError(t, err)
+		defer s.Close()
+		_, err = s.Read(make([]byte, 1))
+		assert.Error(t, err)
+
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return testutil.ToFloat64(autonatRefused.WithLabelValues(autonatRefusedNetwork)) == before+1
+		}, 5*time.Second, 10*time.Millisecond))
+	})
+
+	service.Close()
+	assert.NotContains(t, server.Mux().Protocols(), autonat.AutoNATProto)
+}
+
+func TestAutoNATPolicy(t *testing.T) {
+	service := &AutoNATService{
+		policy: AutoNATPolicy{PeerQuota: 2},
+		quotas: make(map[peer.ID]*peerQuota),
+	}
+	_, ipNet, err := net.ParseCIDR("203.0.113.0/24")
+	require.NoError(t, err)
+	service.networks = []*net.IPNet{ipNet}
+
+	inside := net.ParseIP("203.0.113.7")
+	reason, ok := service.allow("peer", net.ParseIP("198.51.100.9"))
+	assert.False(t, ok)
+	assert.Equal(t, autonatRefusedNetwork, reason)
+
+	t.Run("peers get a daily quota", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			_, ok := service.allow("peer", inside)
+			assert.True(t, ok)
+		}
+		reason, ok := service.allow("peer", inside)
+		assert.False(t, ok)
+		assert.Equal(t, autonatRefusedQuota, reason)
+
+		_, ok = service.allow("other", inside)
+		assert.True(t, ok)
+
+		service.quotas["peer"].start = time.Now().Add(-autonatQuotaWindow - time.Minute)
+		_, ok = service.allow("peer", inside)
+		assert.True(t, ok)
+	})
+}
+
+func TestAutoNATConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.EnableAutoNATService = true
+	assert.NoError(t, cfg.Validate())
+
+	cfg.AutoNATServiceNetworks = []string{"not-a-cidr"}
+	assert.Error(t, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.EnableAutoNATService = true
+	cfg.AutoNATServicePeerRate = 0
+	assert.Error(t, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.EnableAutoNATService = true
+	cfg.ProxyStrict = true
+	cfg.ProxyAddr = "127.0.0.1:9050"
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,292 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/sirupsen/logrus"
+)
+
+// BlobPin is pinned content
+type BlobPin struct {
+	CID     string    `json:"cid"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// BlobGCResult is the outcome of a garbage collection pass
+type BlobGCResult struct {
+	Removed int   `json:"removed"` // blocks removed
+	Freed   int64 `json:"freed"`   // bytes freed
+	Size    int64 `json:"size"`    // bytes left in the store
+}
+
+// BlobStat summarizes the blob store
+type BlobStat struct {
+	Blocks int   `json:"blocks"`
+	Size   int64 `json:"size"`
+	Quota  int64 `json:"quota"` // 0 for no quota
+	Pins   int   `json:"pins"`
+}
+
+// SetQuota sets how many bytes the store may hold before EnforceQuota
+// collects unpinned blocks (0 for no quota)
+func (bs *BlobStore) SetQuota(quota int64) {
+	bs.mu.Lock()
+	bs.quota = quota
+	bs.mu.Unlock()
+}
+
+// Pin keeps content out of garbage collection: the block itself and, for a
+// manifest, all of its chunks. The content must be complete in the store.
+func (bs *BlobStore) Pin(root cid.Cid) error {
+	size, err := bs.complete(root)
+	if err != nil {
+		return err
+	}
+
+	bs.mu.Lock()
+	if _, ok := bs.pins[root.String()]; !ok {
+		bs.pins[root.String()] = BlobPin{CID: root.String(), Size: size, Created: time.Now()}
+	}
+	bs.mu.Unlock()
+	logrus.WithField("cid", root).Info("Pinned blob")
+	return bs.savePins()
+}
+
+// Unpin lets garbage collection remove content again
+func (bs *BlobStore) Unpin(root cid.Cid) error {
+	bs.mu.Lock()
+	_, ok := bs.pins[root.String()]
+	delete(bs.pins, root.String())
+	bs.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s is not pinned", root)
+	}
+	logrus.WithField("cid", root).Info("Unpinned blob")
+	return bs.savePins()
+}
+
+// Pins lists the pinned content, oldest first
+func (bs *BlobStore) Pins() []BlobPin {
+	bs.mu.Lock()
+	pins := make([]BlobPin, 0, len(bs.pins))
+	for _, pin := range bs.pins {
+		pins = append(pins, pin)
+	}
+	bs.mu.Unlock()
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Created.Before(pins[j].Created) })
+	return pins
+}
+
+// Stat returns the size of the store
+func (bs *BlobStore) Stat() (BlobStat, error) {
+	blocks, err := bs.blocks()
+	if err != nil {
+		return BlobStat{}, err
+	}
+	bs.mu.Lock()
+	stat := BlobStat{Blocks: len(blocks), Quota: bs.quota, Pins: len(bs.pins)}
+	bs.mu.Unlock()
+	for _, b := range blocks {
+		stat.Size += b.size
+	}
+	return stat, nil
+}
+
+// EnforceQuota collects unpinned blocks, least recently added first, once
+// the store holds more than its quota
+func (bs *BlobStore) EnforceQuota() (BlobGCResult, error) {
+	bs.mu.Lock()
+	quota := bs.quota
+	bs.mu.Unlock()
+	if quota <= 0 {
+		return BlobGCResult{}, nil
+	}
+	return bs.GC(quota)
+}
+
+// GC removes unpinned blocks, least recently added first, until the store
+// holds at most target bytes (0 to remove every unpinned block). Adds and
+// downloads in progress finish before it starts.
+func (bs *BlobStore) GC(target int64) (BlobGCResult, error) {
+	bs.gcMu.Lock()
+	defer bs.gcMu.Unlock()
+
+	blocks, err := bs.blocks()
+	if err != nil {
+		return BlobGCResult{}, err
+	}
+	var result BlobGCResult
+	for _, b := range blocks {
+		result.Size += b.size
+	}
+	if result.Size <= target {
+		return result, nil
+	}
+
+	pinned := bs.pinned()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].added.Before(blocks[j].added) })
+	for _, b := range blocks {
+		if result.Size <= target {
+			break
+		}
+		if pinned[b.cid] {
+			continue
+		}
+		if err := bs.remove(b.cid); err != nil {
+			return result, err
+		}
+		result.Removed++
+		result.Freed += b.size
+		result.Size -= b.size
+	}
+	logrus.WithFields(logrus.Fields{
+		"removed": result.Removed,
+		"freed":   result.Freed,
+		"size":    result.Size,
+	}).Info("Collected unpinned blobs")
+	return result, nil
+}
+
+// complete checks that content is entirely in the store and returns its size
+func (bs *BlobStore) complete(root cid.Cid) (int64, error) {
+	if root.Prefix().Codec != cid.DagJSON {
+		data, err := bs.Block(root)
+		return int64(len(data)), err
+	}
+	manifest, err := bs.Manifest(root)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range manifest.Chunks {
+		if !bs.Has(c) {
+			return 0, fmt.Errorf("chunk %s of %s is not in the store", c, root)
+		}
+	}
+	return manifest.Size, nil
+}
+
+// pinned returns the blocks pins protect
+func (bs *BlobStore) pinned() map[cid.Cid]bool {
+	bs.mu.Lock()
+	roots := make([]string, 0, len(bs.pins))
+	for c := range bs.pins {
+		roots = append(roots, c)
+	}
+	bs.mu.Unlock()
+
+	pinned := make(map[cid.Cid]bool)
+	for _, s := range roots {
+		root, err := cid.Decode(s)
+		if err != nil {
+			continue
+		}
+		pinned[root] = true
+		if manifest, err := bs.Manifest(root); err == nil {
+			for _, c := range manifest.Chunks {
+				pinned[c] = true
+			}
+		}
+	}
+	return pinned
+}
+
+// storedBlock is a block in the store
+type storedBlock struct {
+	cid   cid.Cid
+	size  int64
+	added time.Time
+}
+
+// blocks lists the blocks in the store
+func (bs *BlobStore) blocks() ([]storedBlock, error) {
+	results, err := bs.store.Query(context.Background(), query.Query{Prefix: blobAddedKey.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+	var blocks []storedBlock
+	for _, e := range entries {
+		c, err := cid.Decode(datastore.RawKey(e.Key).BaseNamespace())
+		if err != nil || len(e.Value) != 16 {
+			continue
+		}
+		blocks = append(blocks, storedBlock{
+			cid:   c,
+			added: time.Unix(0, int64(binary.BigEndian.Uint64(e.Value[:8]))),
+			size:  int64(binary.BigEndian.Uint64(e.Value[8:])),
+		})
+	}
+	return blocks, nil
+}
+
+// loadPins reads the pins, if there are any
+func (bs *BlobStore) loadPins() error {
+	data, err := bs.store.Get(context.Background(), blobPinsKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to read pins: %w", err)
+	}
+	var pins []BlobPin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return fmt.Errorf("failed to parse pins: %w", err)
+	}
+	for _, pin := range pins {
+		bs.pins[pin.CID] = pin
+	}
+	return nil
+}
+
+// savePins writes the pins. Saves are serialized, so the last one written
+// always holds the latest pins.
+func (bs *BlobStore) savePins() error {
+	bs.saveMu.Lock()
+	defer bs.saveMu.Unlock()
+	data, err := json.Marshal(bs.Pins())
+	if err != nil {
+		return fmt.Errorf("failed to encode pins: %w", err)
+	}
+	if err := bs.store.Put(context.Background(), blobPinsKey, data); err != nil {
+		return fmt.Errorf("failed to write pins: %w", err)
+	}
+	return nil
+}
+
+// PinBlob fetches content into the blob store if it isn't complete there
+// yet, and pins it
+func (n *Node) PinBlob(ctx context.Context, root cid.Cid) error {
+	if n.blobs == nil {
+		return fmt.Errorf("blob store is not enabled")
+	}
+	if _, err := n.blobs.complete(root); err != nil {
+		if err := n.fetchBlob(ctx, root, nil); err != nil {
+			return err
+		}
+	}
+	if err := n.blobs.Pin(root); err != nil {
+		return err
+	}
+	n.enforceBlobQuota()
+	return nil
+}
+
+// enforceBlobQuota runs garbage collection if the blob store is over its quota
+func (n *Node) enforceBlobQuota() {
+	if _, err := n.blobs.EnforceQuota(); err != nil {
+		logrus.WithError(err).Warn("Failed to collect unpinned blobs")
+	}
+}
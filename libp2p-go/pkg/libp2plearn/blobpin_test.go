@@ -0,0 +1,117 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobPinning(t *testing.T) {
+	h, err := createNodeWithOptions(context.Background(), 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+
+	// content returns distinct content of size bytes
+	seed := int64(0)
+	content := func(size int) []byte {
+		seed++
+		data := make([]byte, size)
+		rand.New(rand.NewSource(seed)).Read(data)
+		return data
+	}
+
+	// add adds content and backdates its blocks so additions are ordered
+	add := func(t *testing.T, bs *BlobStore, data []byte, age time.Duration) cid.Cid {
+		root, err := bs.Add(bytes.NewReader(data))
+		require.NoError(t, err)
+		manifest, err := bs.Manifest(root)
+		require.NoError(t, err)
+		for _, c := range append(manifest.Chunks, root) {
+			block, err := bs.Block(c)
+			require.NoError(t, err)
+			require.NoError(t, bs.touch(c, len(block), time.Now().Add(-age)))
+		}
+		return root
+	}
+
+	// complete reports whether all of root is in the store
+	complete := func(bs *BlobStore, root cid.Cid) bool {
+		_, err := bs.complete(root)
+		return err == nil
+	}
+
+	t.Run("PinsSurviveGC", func(t *testing.T) {
+		store := NewMemoryDatastore()
+		bs, err := NewBlobStore(h, store, BlobChunkerFixed)
+		require.NoError(t, err)
+		defer bs.Close()
+
+		pinned := add(t, bs, content(3*blobAvgChunk), time.Hour)
+		loose := add(t, bs, content(2*blobAvgChunk), 2*time.Hour)
+		require.NoError(t, bs.Pin(pinned))
+
+		result, err := bs.GC(0)
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.Removed, "two chunks and the manifest")
+		assert.Greater(t, result.Freed, int64(2*blobAvgChunk))
+		assert.True(t, complete(bs, pinned))
+		assert.False(t, bs.Has(loose))
+
+		// Pins are kept across restarts
+		bs.Close()
+		reopened, err := NewBlobStore(h, store, BlobChunkerFixed)
+		require.NoError(t, err)
+		defer reopened.Close()
+		pins := reopened.Pins()
+		require.Len(t, pins, 1)
+		assert.Equal(t, pinned.String(), pins[0].CID)
+		assert.Equal(t, int64(3*blobAvgChunk), pins[0].Size)
+
+		require.NoError(t, reopened.Unpin(pinned))
+		assert.Error(t, reopened.Unpin(pinned))
+		_, err = reopened.GC(0)
+		require.NoError(t, err)
+		stat, err := reopened.Stat()
+		require.NoError(t, err)
+		assert.Zero(t, stat.Blocks)
+	})
+
+	t.Run("QuotaRemovesOldestFirst", func(t *testing.T) {
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), BlobChunkerFixed)
+		require.NoError(t, err)
+		defer bs.Close()
+
+		old := add(t, bs, content(2*blobAvgChunk), 2*time.Hour)
+		recent := add(t, bs, content(2*blobAvgChunk), time.Hour)
+
+		result, err := bs.EnforceQuota()
+		require.NoError(t, err)
+		assert.Zero(t, result.Removed, "no quota, nothing to collect")
+
+		bs.SetQuota(3 * blobAvgChunk)
+		result, err = bs.EnforceQuota()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, result.Size, int64(3*blobAvgChunk))
+		assert.False(t, complete(bs, old))
+		assert.True(t, complete(bs, recent))
+	})
+
+	t.Run("PinRequiresCompleteContent", func(t *testing.T) {
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), BlobChunkerFixed)
+		require.NoError(t, err)
+		defer bs.Close()
+
+		root := add(t, bs, content(2*blobAvgChunk), 0)
+		manifest, err := bs.Manifest(root)
+		require.NoError(t, err)
+		require.NoError(t, bs.remove(manifest.Chunks[1]))
+		assert.ErrorContains(t, bs.Pin(root), "not in the store")
+		assert.Empty(t, bs.Pins())
+	})
+}
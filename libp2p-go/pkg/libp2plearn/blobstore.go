@@ -0,0 +1,487 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// BlobProtocol serves blocks of the blob store by CID
+	BlobProtocol = "/libp2p-learn/blob/1.0.0"
+
+	// Chunkers splitting content added to the blob store
+	BlobChunkerFixed   = "fixed"   // fixed-size chunks
+	BlobChunkerRolling = "rolling" // content-defined chunks, so an edit only changes the chunks around it
+
+	// Chunk sizes: fixed chunks are blobAvgChunk long, rolling chunks
+	// average it within the minimum and maximum
+	blobMinChunk = 64 * 1024
+	blobAvgChunk = 256 * 1024
+	blobMaxChunk = 1024 * 1024
+
+	// maxBlobManifestSize bounds the size of a manifest, about 250k chunks
+	maxBlobManifestSize = 16 << 20
+
+	// maxBlobRequestSize bounds the size of a block request line
+	maxBlobRequestSize = 1024
+
+	// blobRequestTimeout bounds one block request
+	blobRequestTimeout = 30 * time.Second
+)
+
+// gearTable drives the rolling hash of the content-defined chunker
+var gearTable = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// gearMask selects the top bits of the rolling hash; a chunk ends where they
+// are all zero, on average every blobAvgChunk bytes
+const gearMask uint64 = 0xffffc00000000000 // top 18 bits
+
+// BlobManifest lists the chunks of a piece of content in order. Its CID is
+// the root of a one-level Merkle DAG: it commits to every chunk CID, and each
+// chunk CID to the chunk's bytes, so chunks can be verified one by one as
+// they arrive from any provider.
+type BlobManifest struct {
+	Size    int64     `json:"size"`
+	Chunker string    `json:"chunker"`
+	Chunks  []cid.Cid `json:"chunks"`
+	Sizes   []int     `json:"sizes"`
+}
+
+// blobRequest asks for a block, sent as one JSON line
+type blobRequest struct {
+	CID string `json:"cid"`
+}
+
+// blobResponse answers a block request as one JSON line, followed by the block
+type blobResponse struct {
+	Size  int    `json:"size,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// BlobStore keeps content as blocks in a datastore, addressed by CID, and
+// serves them to peers. Content is added as chunks plus a manifest, whose CID
+// identifies the content. Pinned content is kept; the rest is removed by
+// garbage collection, least recently added first.
+type BlobStore struct {
+	host    host.Host
+	store   Datastore
+	chunker string
+
+	// gcMu is held for reading while blocks are added and for writing by GC,
+	// so content is never collected halfway through being added
+	gcMu   sync.RWMutex
+	mu     sync.Mutex
+	saveMu sync.Mutex
+	pins   map[string]BlobPin
+	quota  int64
+}
+
+// Keys of the blob store in its datastore
+var (
+	blobBlocksKey = datastore.NewKey("/blocks") // block data by CID
+	blobAddedKey  = datastore.NewKey("/added")  // when each block was last added, and its size
+	blobPinsKey   = datastore.NewKey("/pins")
+)
+
+// NewBlobStore opens the blob store kept in store and registers the protocol
+// handler serving its blocks. chunker is BlobChunkerFixed or BlobChunkerRolling.
+func NewBlobStore(h host.Host, store Datastore, chunker string) (*BlobStore, error) {
+	if chunker != BlobChunkerFixed && chunker != BlobChunkerRolling {
+		return nil, fmt.Errorf("unknown chunker %q", chunker)
+	}
+	bs := &BlobStore{host: h, store: store, chunker: chunker, pins: make(map[string]BlobPin)}
+	if err := bs.loadPins(); err != nil {
+		return nil, err
+	}
+
+	h.SetStreamHandler(protocol.ID(BlobProtocol), RecoveryMiddleware(protocol.ID(BlobProtocol), bs.handleBlob))
+	logrus.WithFields(logrus.Fields{
+		"protocol": BlobProtocol,
+		"chunker":  chunker,
+	}).Info("Registered blob protocol handler")
+	return bs, nil
+}
+
+// Close unregisters the blob protocol
+func (bs *BlobStore) Close() {
+	bs.host.RemoveStreamHandler(protocol.ID(BlobProtocol))
+}
+
+// Add chunks content into the store and returns the CID of its manifest
+func (bs *BlobStore) Add(r io.Reader) (cid.Cid, error) {
+	bs.gcMu.RLock()
+	defer bs.gcMu.RUnlock()
+
+	manifest := BlobManifest{Chunker: bs.chunker}
+	reader := bufio.NewReaderSize(r, blobMaxChunk)
+	buf := make([]byte, blobMaxChunk)
+	for {
+		chunk, err := nextChunk(reader, buf, bs.chunker)
+		if len(chunk) > 0 {
+			// buf is reused for the next chunk, and stores may keep the slice
+			c, putErr := bs.put(cid.Raw, bytes.Clone(chunk))
+			if putErr != nil {
+				return cid.Undef, putErr
+			}
+			manifest.Chunks = append(manifest.Chunks, c)
+			manifest.Sizes = append(manifest.Sizes, len(chunk))
+			manifest.Size += int64(len(chunk))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cid.Undef, fmt.Errorf("failed to read content: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	root, err := bs.put(cid.DagJSON, data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"cid":    root,
+		"size":   manifest.Size,
+		"chunks": len(manifest.Chunks),
+	}).Info("Added blob")
+	return root, nil
+}
+
+// Manifest returns the manifest of content in the store
+func (bs *BlobStore) Manifest(root cid.Cid) (*BlobManifest, error) {
+	data, err := bs.Block(root)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlobManifest(root, data)
+}
+
+// Has reports whether a block is in the store
+func (bs *BlobStore) Has(c cid.Cid) bool {
+	ok, err := bs.store.Has(context.Background(), blobBlockKey(c))
+	return err == nil && ok
+}
+
+// Block returns a block from the store
+func (bs *BlobStore) Block(c cid.Cid) ([]byte, error) {
+	data, err := bs.store.Get(context.Background(), blobBlockKey(c))
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, fmt.Errorf("block %s not found", c)
+		}
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+	return data, nil
+}
+
+// Cat writes the content under root to w. All of its chunks must be in the store.
+func (bs *BlobStore) Cat(root cid.Cid, w io.Writer) error {
+	manifest, err := bs.Manifest(root)
+	if err != nil {
+		return err
+	}
+	for _, c := range manifest.Chunks {
+		data, err := bs.Block(c)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch downloads the content under root into the store from the given
+// providers, verifying every chunk against its CID as it arrives
+func (bs *BlobStore) Fetch(ctx context.Context, root cid.Cid, providers []peer.ID) error {
+	return NewDownloader(bs, nil).Download(ctx, root, providers...)
+}
+
+// put stores a block under its CID
+func (bs *BlobStore) put(codec uint64, data []byte) (cid.Cid, error) {
+	c, err := blobPrefix(codec).Sum(data)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to hash block: %w", err)
+	}
+
+	// Record the block before storing it, so garbage collection can always
+	// find it; added again, it goes to the back of the line
+	if err := bs.touch(c, len(data), time.Now()); err != nil {
+		return cid.Undef, err
+	}
+	if bs.Has(c) {
+		return c, nil
+	}
+	if err := bs.store.Put(context.Background(), blobBlockKey(c), data); err != nil {
+		return cid.Undef, fmt.Errorf("failed to write block: %w", err)
+	}
+	return c, nil
+}
+
+// touch records when a block was last added, and its size
+func (bs *BlobStore) touch(c cid.Cid, size int, at time.Time) error {
+	var value [16]byte
+	binary.BigEndian.PutUint64(value[:8], uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(value[8:], uint64(size))
+	if err := bs.store.Put(context.Background(), blobAddedKey.ChildString(c.String()), value[:]); err != nil {
+		return fmt.Errorf("failed to record block: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a block and its record
+func (bs *BlobStore) remove(c cid.Cid) error {
+	ctx := context.Background()
+	if err := bs.store.Delete(ctx, blobBlockKey(c)); err != nil {
+		return fmt.Errorf("failed to remove block: %w", err)
+	}
+	if err := bs.store.Delete(ctx, blobAddedKey.ChildString(c.String())); err != nil {
+		return fmt.Errorf("failed to remove block: %w", err)
+	}
+	return nil
+}
+
+func blobBlockKey(c cid.Cid) datastore.Key {
+	return blobBlocksKey.ChildString(c.String())
+}
+
+// handleBlob serves one block
+func (bs *BlobStore) handleBlob(s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+
+	s.SetDeadline(time.Now().Add(blobRequestTimeout))
+	line, err := bufio.NewReaderSize(s, maxBlobRequestSize).ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read blob request")
+		return
+	}
+	var req blobRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Received invalid blob request")
+		ReportMisbehavior(bs.host, remote, MisbehaviorProtocolError, "invalid blob request")
+		return
+	}
+
+	resp := blobResponse{}
+	var data []byte
+	c, err := cid.Decode(req.CID)
+	if err == nil {
+		data, err = bs.Block(c)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Size = len(data)
+	}
+	header, _ := json.Marshal(resp)
+	if _, err := s.Write(append(header, '\n')); err != nil {
+		return
+	}
+	s.Write(data)
+}
+
+// fetchBlock requests a block from a peer and verifies it against its CID.
+// size is the expected size, or -1 for a manifest of unknown size.
+func fetchBlock(ctx context.Context, h host.Host, p peer.ID, c cid.Cid, size int) ([]byte, error) {
+	if prefix := c.Prefix(); prefix.Version != 1 || prefix.MhType != multihash.SHA2_256 ||
+		(prefix.Codec != cid.Raw && prefix.Codec != cid.DagJSON) {
+		return nil, fmt.Errorf("unsupported CID %s", c)
+	}
+	s, err := h.NewStream(ctx, p, protocol.ID(BlobProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	// Interrupt blocked reads and writes when ctx is done
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+	s.SetDeadline(time.Now().Add(blobRequestTimeout))
+
+	req, _ := json.Marshal(blobRequest{CID: c.String()})
+	if _, err := s.Write(append(req, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send blob request: %w", err)
+	}
+	s.CloseWrite()
+
+	reader := bufio.NewReader(s)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob response: %w", err)
+	}
+	var resp blobResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse blob response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	limit := size
+	if limit < 0 {
+		limit = maxBlobManifestSize
+	}
+	if resp.Size < 0 || resp.Size > limit || (size >= 0 && resp.Size != size) {
+		ReportMisbehavior(h, p, MisbehaviorProtocolError, "blob of wrong size")
+		return nil, fmt.Errorf("block %s has %d bytes, expected %d", c, resp.Size, size)
+	}
+
+	data := make([]byte, resp.Size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+	if got, err := c.Prefix().Sum(data); err != nil || !got.Equals(c) {
+		ReportMisbehavior(h, p, MisbehaviorProtocolError, "blob doesn't match its CID")
+		return nil, fmt.Errorf("block from %s doesn't match %s", p, c)
+	}
+	return data, nil
+}
+
+// parseBlobManifest decodes a manifest block and checks it is consistent
+func parseBlobManifest(root cid.Cid, data []byte) (*BlobManifest, error) {
+	if root.Prefix().Codec != cid.DagJSON {
+		return nil, fmt.Errorf("%s is not a blob manifest", root)
+	}
+	var manifest BlobManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if len(manifest.Sizes) != len(manifest.Chunks) {
+		return nil, fmt.Errorf("manifest lists %d chunks but %d sizes", len(manifest.Chunks), len(manifest.Sizes))
+	}
+	var total int64
+	for i, size := range manifest.Sizes {
+		if size <= 0 || size > blobMaxChunk {
+			return nil, fmt.Errorf("manifest chunk %d has invalid size %d", i, size)
+		}
+		if manifest.Chunks[i].Prefix().Codec != cid.Raw {
+			return nil, fmt.Errorf("manifest chunk %d is not a raw block", i)
+		}
+		total += int64(size)
+	}
+	if total != manifest.Size {
+		return nil, fmt.Errorf("manifest chunks add up to %d bytes, not %d", total, manifest.Size)
+	}
+	return &manifest, nil
+}
+
+// blobPrefix is the CID format of blocks: CIDv1 with a SHA-256 multihash
+func blobPrefix(codec uint64) cid.Prefix {
+	return cid.Prefix{Version: 1, Codec: codec, MhType: multihash.SHA2_256, MhLength: -1}
+}
+
+// nextChunk reads the next chunk into buf, returning io.EOF with the last one
+func nextChunk(r *bufio.Reader, buf []byte, chunker string) ([]byte, error) {
+	if chunker == BlobChunkerFixed {
+		n, err := io.ReadFull(r, buf[:blobAvgChunk])
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return buf[:n], err
+	}
+
+	// Gear hash: a chunk ends where the top bits of the hash of the
+	// preceding bytes are zero, so boundaries move along with inserted data
+	var hash uint64
+	n := 0
+	for n < blobMaxChunk {
+		b, err := r.ReadByte()
+		if err != nil {
+			return buf[:n], err
+		}
+		buf[n] = b
+		n++
+		hash = hash<<1 + gearTable[b]
+		if n >= blobMinChunk && hash&gearMask == 0 {
+			break
+		}
+	}
+	return buf[:n], nil
+}
+
+// AddBlob adds content to the blob store, pins it and, once the node is
+// started, announces it in the DHT
+func (n *Node) AddBlob(ctx context.Context, r io.Reader) (cid.Cid, error) {
+	if n.blobs == nil {
+		return cid.Undef, fmt.Errorf("blob store is not enabled")
+	}
+	root, err := n.blobs.Add(r)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := n.blobs.Pin(root); err != nil {
+		return cid.Undef, err
+	}
+	n.enforceBlobQuota()
+	if n.dht != nil {
+		if err := n.dht.Provide(ctx, root, true); err != nil {
+			logrus.WithError(err).WithField("cid", root).Warn("Failed to announce blob in the DHT")
+		}
+	}
+	return root, nil
+}
+
+// FetchBlob downloads content into the blob store from the given providers
+// and the ones the DHT knows for it, and then provides it as well. Unless
+// pinned, it may be collected again once the store is over its quota.
+func (n *Node) FetchBlob(ctx context.Context, root cid.Cid, providers ...peer.ID) error {
+	if err := n.fetchBlob(ctx, root, providers); err != nil {
+		return err
+	}
+	n.enforceBlobQuota()
+	return nil
+}
+
+func (n *Node) fetchBlob(ctx context.Context, root cid.Cid, providers []peer.ID) error {
+	if n.blobs == nil {
+		return fmt.Errorf("blob store is not enabled")
+	}
+	var router routing.ContentDiscovery
+	if n.dht != nil {
+		router = n.dht
+	}
+	if err := NewDownloader(n.blobs, router).Download(ctx, root, providers...); err != nil {
+		return err
+	}
+	if n.dht != nil {
+		if err := n.dht.Provide(ctx, root, true); err != nil {
+			logrus.WithError(err).WithField("cid", root).Debug("Failed to announce blob in the DHT")
+		}
+	}
+	return nil
+}
+
+// Blobs returns the blob store, which is nil unless blob_dir is set
+func (n *Node) Blobs() *BlobStore {
+	return n.blobs
+}
@@ -0,0 +1,152 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobChunking(t *testing.T) {
+	content := make([]byte, 5*blobMaxChunk+1234)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	// chunks adds content to a fresh store and returns its manifest
+	chunks := func(t *testing.T, chunker string, data []byte) *BlobManifest {
+		h, err := createNodeWithOptions(context.Background(), 0, false, false)
+		require.NoError(t, err)
+		defer h.Close()
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), chunker)
+		require.NoError(t, err)
+		defer bs.Close()
+
+		root, err := bs.Add(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(cid.DagJSON), root.Prefix().Codec)
+		manifest, err := bs.Manifest(root)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), manifest.Size)
+
+		var out bytes.Buffer
+		require.NoError(t, bs.Cat(root, &out))
+		assert.Equal(t, data, out.Bytes())
+		return manifest
+	}
+
+	t.Run("Fixed", func(t *testing.T) {
+		manifest := chunks(t, BlobChunkerFixed, content)
+		for _, size := range manifest.Sizes[:len(manifest.Sizes)-1] {
+			assert.Equal(t, blobAvgChunk, size)
+		}
+	})
+
+	t.Run("RollingSurvivesInsertion", func(t *testing.T) {
+		before := chunks(t, BlobChunkerRolling, content)
+		for _, size := range before.Sizes[:len(before.Sizes)-1] {
+			assert.GreaterOrEqual(t, size, blobMinChunk)
+			assert.LessOrEqual(t, size, blobMaxChunk)
+		}
+
+		// Inserting bytes near the start only changes the chunks around them
+		edited := append([]byte("inserted"), content...)
+		after := chunks(t, BlobChunkerRolling, edited)
+		known := make(map[cid.Cid]bool)
+		for _, c := range before.Chunks {
+			known[c] = true
+		}
+		shared := 0
+		for _, c := range after.Chunks {
+			if known[c] {
+				shared++
+			}
+		}
+		assert.GreaterOrEqual(t, shared, len(before.Chunks)-2)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		manifest := chunks(t, BlobChunkerRolling, nil)
+		assert.Empty(t, manifest.Chunks)
+	})
+}
+
+func TestBlobFetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	content := make([]byte, 3*blobMaxChunk)
+	rand.New(rand.NewSource(2)).Read(content)
+
+	// store creates a node with a blob store holding content
+	store := func(t *testing.T) (*BlobStore, cid.Cid) {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), BlobChunkerFixed)
+		require.NoError(t, err)
+		t.Cleanup(bs.Close)
+		root, err := bs.Add(bytes.NewReader(content))
+		require.NoError(t, err)
+		return bs, root
+	}
+
+	full, root := store(t)
+	partial, _ := store(t)
+	corrupt, _ := store(t)
+	manifest, err := full.Manifest(root)
+	require.NoError(t, err)
+
+	// One provider lost half its chunks, another serves garbage for all of them
+	for i, c := range manifest.Chunks {
+		if i%2 == 0 {
+			require.NoError(t, partial.store.Delete(ctx, blobBlockKey(c)))
+		}
+		require.NoError(t, corrupt.store.Put(ctx, blobBlockKey(c), bytes.Repeat([]byte{0}, manifest.Sizes[i])))
+	}
+
+	// fetch fetches root into a fresh store from the providers
+	fetch := func(t *testing.T, providers ...*BlobStore) (*BlobStore, error) {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), BlobChunkerFixed)
+		require.NoError(t, err)
+		t.Cleanup(bs.Close)
+
+		var ids []peer.ID
+		for _, p := range providers {
+			require.NoError(t, connectNodes(ctx, h, p.host))
+			ids = append(ids, p.host.ID())
+		}
+		return bs, bs.Fetch(ctx, root, ids)
+	}
+
+	t.Run("FromSeveralProviders", func(t *testing.T) {
+		bs, err := fetch(t, partial, full)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		require.NoError(t, bs.Cat(root, &out))
+		assert.Equal(t, content, out.Bytes())
+	})
+
+	t.Run("SkipsCorruptProvider", func(t *testing.T) {
+		bs, err := fetch(t, corrupt, full)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		require.NoError(t, bs.Cat(root, &out))
+		assert.Equal(t, content, out.Bytes())
+	})
+
+	t.Run("FailsWithoutGoodProvider", func(t *testing.T) {
+		bs, err := fetch(t, corrupt)
+		assert.ErrorContains(t, err, "no provider could supply")
+		for _, c := range manifest.Chunks {
+			assert.False(t, bs.Has(c), "unverified chunks are never stored")
+		}
+	})
+}
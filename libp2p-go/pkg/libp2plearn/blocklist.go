@@ -0,0 +1,135 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// Blocklist is a connection gater that refuses connections to and from blocked peers
+type Blocklist struct {
+	mu      sync.RWMutex
+	blocked map[peer.ID]time.Time // when the block ends, zero for never
+}
+
+// NewBlocklist creates a blocklist from peer ID strings
+func NewBlocklist(ids []string) (*Blocklist, error) {
+	b := &Blocklist{blocked: make(map[peer.ID]time.Time)}
+	for _, id := range ids {
+		p, err := peer.Decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %q: %w", id, err)
+		}
+		b.blocked[p] = time.Time{}
+	}
+	return b, nil
+}
+
+// Block refuses all future connections with the peer
+func (b *Blocklist) Block(p peer.ID) {
+	b.mu.Lock()
+	b.blocked[p] = time.Time{}
+	b.mu.Unlock()
+	logrus.WithField("peer", p).Info("Blocked peer")
+}
+
+// BlockUntil refuses connections with the peer until a time
+func (b *Blocklist) BlockUntil(p peer.ID, until time.Time) {
+	b.mu.Lock()
+	b.blocked[p] = until
+	b.mu.Unlock()
+	logrus.WithFields(logrus.Fields{
+		"peer":  p,
+		"until": until.Format(time.RFC3339),
+	}).Info("Blocked peer temporarily")
+}
+
+// Unblock allows connections with the peer again
+func (b *Blocklist) Unblock(p peer.ID) {
+	b.mu.Lock()
+	delete(b.blocked, p)
+	b.mu.Unlock()
+	logrus.WithField("peer", p).Info("Unblocked peer")
+}
+
+// IsBlocked reports whether the peer is blocked
+func (b *Blocklist) IsBlocked(p peer.ID) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	until, ok := b.blocked[p]
+	return ok && (until.IsZero() || time.Now().Before(until))
+}
+
+// Blocked returns the blocked peers
+func (b *Blocklist) Blocked() []peer.ID {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	now := time.Now()
+	peers := make([]peer.ID, 0, len(b.blocked))
+	for p, until := range b.blocked {
+		if until.IsZero() || now.Before(until) {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+func (b *Blocklist) InterceptPeerDial(p peer.ID) bool {
+	return !b.IsBlocked(p)
+}
+
+func (b *Blocklist) InterceptAddrDial(p peer.ID, _ multiaddr.Multiaddr) bool {
+	return !b.IsBlocked(p)
+}
+
+func (b *Blocklist) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (b *Blocklist) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return !b.IsBlocked(p)
+}
+
+func (b *Blocklist) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// dialGater is the blocklist with addresses the dial policy backs off or
+// the address filters drop refused as well, and inbound connections refused
+// during maintenance. It also marks when connections start and are secured
+// for the connection timings.
+type dialGater struct {
+	*Blocklist
+	policy      *DialPolicy
+	filter      *AddrFilter
+	timings     *ConnTimings
+	maintenance *Maintenance
+}
+
+func (g *dialGater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	allowed := g.Blocklist.InterceptAddrDial(p, addr) && g.filter.Allowed(addr) && g.policy.Allowed(p, addr)
+	if allowed {
+		g.timings.started(addr)
+	}
+	return allowed
+}
+
+func (g *dialGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	g.timings.started(addrs.RemoteMultiaddr())
+	return g.Blocklist.InterceptAccept(addrs)
+}
+
+func (g *dialGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	allowed := g.Blocklist.InterceptSecured(dir, p, addrs) &&
+		!(dir == network.DirInbound && g.maintenance.refuses(p))
+	if allowed {
+		g.timings.secured(addrs.RemoteMultiaddr())
+	}
+	return allowed
+}
@@ -0,0 +1,474 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/multiformats/go-multihash"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CapabilitiesProtocol exchanges signed service records with peers
+	CapabilitiesProtocol = "/libp2p-learn/capabilities/1.0.0"
+
+	// ServiceRecordDomain is the signature domain of service records
+	ServiceRecordDomain = "libp2p-learn-service-record"
+
+	// capabilitiesTimeout bounds fetching the records of one peer
+	capabilitiesTimeout = 10 * time.Second
+
+	// maxCapabilitiesMessageSize bounds the size of a record exchange response
+	maxCapabilitiesMessageSize = 1 << 20
+
+	// maxKnownServiceRecords bounds how many peers' records we keep
+	maxKnownServiceRecords = 1024
+
+	// maxExchangedServiceRecords bounds how many other peers' records we pass on
+	maxExchangedServiceRecords = 64
+
+	// serviceRecordTTL is how long a sealed record is accepted
+	serviceRecordTTL = 24 * time.Hour
+
+	// serviceRecordRefresh is how old our record may get before it is sealed again
+	serviceRecordRefresh = serviceRecordTTL / 2
+
+	// serviceReprovideInterval is how often advertised services are announced in the DHT
+	serviceReprovideInterval = 12 * time.Hour
+)
+
+// serviceRecordCodec is the payload type of service record envelopes
+var serviceRecordCodec = []byte("/libp2p-learn/service-record")
+
+// ServiceRecord lists the application services a peer provides. It is sealed
+// in an envelope signed by the peer's key, so it can be passed on by others.
+type ServiceRecord struct {
+	PeerID   peer.ID  `json:"peer_id"`
+	Seq      uint64   `json:"seq"` // time it was sealed, in Unix nanoseconds
+	Services []string `json:"services"`
+}
+
+// Domain is the signature domain of service records
+func (r *ServiceRecord) Domain() string {
+	return ServiceRecordDomain
+}
+
+// Codec is the payload type of service records
+func (r *ServiceRecord) Codec() []byte {
+	return serviceRecordCodec
+}
+
+// MarshalRecord encodes the record as JSON
+func (r *ServiceRecord) MarshalRecord() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalRecord decodes a JSON record
+func (r *ServiceRecord) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// Provides reports whether the record lists the service
+func (r *ServiceRecord) Provides(name string) bool {
+	return slices.Contains(r.Services, name)
+}
+
+// Sealed returns when the record was signed
+func (r *ServiceRecord) Sealed() time.Time {
+	return time.Unix(0, int64(r.Seq))
+}
+
+// capabilitiesResponse carries signed records, the responder's own first, as one JSON line
+type capabilitiesResponse struct {
+	Records [][]byte `json:"records"`
+}
+
+// knownRecord is a verified record and the envelope it came in
+type knownRecord struct {
+	record   *ServiceRecord
+	envelope []byte
+}
+
+// Capabilities advertises the services this node provides and learns the
+// ones other peers provide. Records are exchanged with every peer whose
+// identify lists the protocol, along with the records it learned from others.
+type Capabilities struct {
+	host host.Host
+
+	mu       sync.Mutex
+	services []string
+	own      *knownRecord
+	known    map[peer.ID]*knownRecord
+	changed  chan struct{} // signals provideServices when services change
+}
+
+// NewCapabilities creates the capabilities service and registers its protocol handler
+func NewCapabilities(h host.Host) *Capabilities {
+	c := &Capabilities{
+		host:    h,
+		known:   make(map[peer.ID]*knownRecord),
+		changed: make(chan struct{}, 1),
+	}
+	h.SetStreamHandler(protocol.ID(CapabilitiesProtocol), RecoveryMiddleware(protocol.ID(CapabilitiesProtocol), c.handleCapabilities))
+	logrus.WithField("protocol", CapabilitiesProtocol).Info("Registered capabilities protocol")
+	return c
+}
+
+// Close unregisters the capabilities protocol
+func (c *Capabilities) Close() {
+	c.host.RemoveStreamHandler(protocol.ID(CapabilitiesProtocol))
+}
+
+// SetServices replaces the services this node advertises
+func (c *Capabilities) SetServices(names []string) {
+	names = slices.Clone(names)
+	sort.Strings(names)
+	names = slices.Compact(names)
+
+	c.mu.Lock()
+	c.services = names
+	c.own = nil
+	c.mu.Unlock()
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Services returns the services this node advertises
+func (c *Capabilities) Services() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.services)
+}
+
+// Record returns the signed record of another peer, if we know a current one
+func (c *Capabilities) Record(p peer.ID) (*ServiceRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	known, ok := c.known[p]
+	if !ok || time.Since(known.record.Sealed()) > serviceRecordTTL {
+		return nil, false
+	}
+	return known.record, true
+}
+
+// Providers returns the peers known to provide a service
+func (c *Capabilities) Providers(name string) []peer.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var providers []peer.ID
+	for p, known := range c.known {
+		if known.record.Provides(name) && time.Since(known.record.Sealed()) <= serviceRecordTTL {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// Run fetches the records of peers as they finish identify, delivered by a
+// subscription to EvtPeerIdentificationCompleted, until ctx is done
+func (c *Capabilities) Run(ctx context.Context, identified event.Subscription) {
+	defer identified.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-identified.Out():
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			if !slices.Contains(evt.Protocols, protocol.ID(CapabilitiesProtocol)) {
+				continue
+			}
+			go func() {
+				if _, err := c.Fetch(ctx, evt.Peer); err != nil {
+					logrus.WithError(err).WithField("peer", evt.Peer).Debug("Failed to fetch service records")
+				}
+			}()
+		}
+	}
+}
+
+// Fetch asks a peer for its signed record and the records it knows, keeps
+// the ones that verify and returns the peer's own
+func (c *Capabilities) Fetch(ctx context.Context, p peer.ID) (*ServiceRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, capabilitiesTimeout)
+	defer cancel()
+
+	s, err := c.host.NewStream(ctx, p, protocol.ID(CapabilitiesProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	s.CloseWrite()
+
+	deadline, _ := ctx.Deadline()
+	s.SetReadDeadline(deadline)
+	line, err := bufio.NewReader(io.LimitReader(s, maxCapabilitiesMessageSize)).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service records: %w", err)
+	}
+	var resp capabilitiesResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode service records: %w", err)
+	}
+
+	var own *ServiceRecord
+	for _, data := range resp.Records {
+		rec, err := consumeServiceRecord(data)
+		if err != nil {
+			logrus.WithError(err).WithField("peer", p).Debug("Ignoring invalid service record")
+			if errors.Is(err, record.ErrInvalidSignature) {
+				ReportMisbehavior(c.host, p, MisbehaviorInvalidSignature, "service record")
+			}
+			continue
+		}
+		if rec.PeerID == c.host.ID() {
+			continue
+		}
+		if rec.PeerID == p {
+			own = rec
+		}
+		c.store(rec, data)
+	}
+	if own == nil {
+		return nil, fmt.Errorf("peer %s sent no service record of its own", p)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":     p,
+		"services": own.Services,
+		"records":  len(resp.Records),
+	}).Debug("Fetched service records")
+	return own, nil
+}
+
+// store keeps a verified record unless we have a newer one from the same peer
+func (c *Capabilities) store(rec *ServiceRecord, envelope []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if known, ok := c.known[rec.PeerID]; ok {
+		if known.record.Seq >= rec.Seq {
+			return
+		}
+	} else if len(c.known) >= maxKnownServiceRecords {
+		c.expire()
+		if len(c.known) >= maxKnownServiceRecords {
+			return
+		}
+	}
+	c.known[rec.PeerID] = &knownRecord{record: rec, envelope: envelope}
+}
+
+// expire drops records past their TTL; c.mu must be held
+func (c *Capabilities) expire() {
+	for p, known := range c.known {
+		if time.Since(known.record.Sealed()) > serviceRecordTTL {
+			delete(c.known, p)
+		}
+	}
+}
+
+// handleCapabilities sends our record followed by the ones we know
+func (c *Capabilities) handleCapabilities(s network.Stream) {
+	defer s.Close()
+
+	own, err := c.ownRecord()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to seal service record")
+		s.Reset()
+		return
+	}
+
+	resp := capabilitiesResponse{Records: [][]byte{own}}
+	remote := s.Conn().RemotePeer()
+	c.mu.Lock()
+	c.expire()
+	for p, known := range c.known {
+		if len(resp.Records) > maxExchangedServiceRecords {
+			break
+		}
+		if p != remote && len(known.record.Services) > 0 {
+			resp.Records = append(resp.Records, known.envelope)
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode service records")
+		s.Reset()
+		return
+	}
+	s.SetWriteDeadline(time.Now().Add(capabilitiesTimeout))
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to send service records")
+	}
+}
+
+// ownRecord returns our sealed record, sealing it again when it changed or
+// is getting old
+func (c *Capabilities) ownRecord() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.own != nil && time.Since(c.own.record.Sealed()) < serviceRecordRefresh {
+		return c.own.envelope, nil
+	}
+
+	rec := &ServiceRecord{
+		PeerID:   c.host.ID(),
+		Seq:      uint64(time.Now().UnixNano()),
+		Services: c.services,
+	}
+	env, err := record.Seal(rec, c.host.Peerstore().PrivKey(c.host.ID()))
+	if err != nil {
+		return nil, err
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	c.own = &knownRecord{record: rec, envelope: data}
+	return data, nil
+}
+
+// consumeServiceRecord verifies a sealed record: the signature, that it was
+// signed by the peer it describes, and that it hasn't expired
+func consumeServiceRecord(data []byte) (*ServiceRecord, error) {
+	var rec ServiceRecord
+	env, err := record.ConsumeTypedEnvelope(data, &rec)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	if signer != rec.PeerID {
+		return nil, fmt.Errorf("record for %s signed by %s", rec.PeerID, signer)
+	}
+	if age := time.Since(rec.Sealed()); age > serviceRecordTTL || age < -serviceRecordTTL {
+		return nil, fmt.Errorf("record sealed at %s is expired", rec.Sealed().Format(time.RFC3339))
+	}
+	return &rec, nil
+}
+
+// serviceKey is the DHT key providers of a service are announced under
+func serviceKey(name string) cid.Cid {
+	mh, _ := multihash.Sum([]byte("/libp2p-learn/service/"+name), multihash.SHA2_256, -1)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+// Capabilities returns the service advertisement and discovery service
+func (n *Node) Capabilities() *Capabilities {
+	return n.capabilities
+}
+
+// AdvertiseServices replaces the services this node advertises to peers and,
+// once started, announces in the DHT
+func (n *Node) AdvertiseServices(names ...string) {
+	n.capabilities.SetServices(names)
+}
+
+// FindService returns up to limit peers providing a named application
+// service (limit <= 0 for no limit). Peers already known from record
+// exchange come first, lowest latency first, then providers announced in the
+// DHT whose signed record confirms the service.
+func (n *Node) FindService(ctx context.Context, name string, limit int) ([]peer.AddrInfo, error) {
+	var providers []peer.AddrInfo
+	seen := map[peer.ID]bool{n.host.ID(): true}
+	full := func() bool {
+		return limit > 0 && len(providers) >= limit
+	}
+
+	known := n.capabilities.Providers(name)
+	sortByLatency(n.host.Peerstore(), known)
+	for _, p := range known {
+		if full() {
+			return providers, nil
+		}
+		seen[p] = true
+		providers = append(providers, peer.AddrInfo{ID: p, Addrs: n.host.Peerstore().Addrs(p)})
+	}
+	if full() || n.dht == nil {
+		return providers, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for info := range n.dht.FindProvidersAsync(ctx, serviceKey(name), 0) {
+		if seen[info.ID] {
+			continue
+		}
+		seen[info.ID] = true
+		n.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+
+		rec, err := n.capabilities.Fetch(ctx, info.ID)
+		if err != nil {
+			logrus.WithError(err).WithField("peer", info.ID).Debug("Failed to verify service provider")
+			continue
+		}
+		if !rec.Provides(name) {
+			logrus.WithFields(logrus.Fields{
+				"peer":    info.ID,
+				"service": name,
+			}).Debug("Provider's record doesn't list the service")
+			continue
+		}
+		providers = append(providers, peer.AddrInfo{ID: info.ID, Addrs: n.host.Peerstore().Addrs(info.ID)})
+		if full() {
+			break
+		}
+	}
+	if len(providers) == 0 && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return providers, nil
+}
+
+// provideServices announces the advertised services in the DHT, again every
+// serviceReprovideInterval and whenever they change, until ctx is done
+func (n *Node) provideServices(ctx context.Context) {
+	ticker := time.NewTicker(serviceReprovideInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range n.capabilities.Services() {
+			if err := n.dht.Provide(ctx, serviceKey(name), true); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logrus.WithError(err).WithField("service", name).Debug("Failed to announce service in the DHT")
+				continue
+			}
+			logrus.WithField("service", name).Debug("Announced service in the DHT")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.capabilities.changed:
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := func(services ...string) *Node {
+		cfg := testNodeConfig()
+		cfg.Services = services
+		n, err := New(WithConfig(cfg))
+		require.NoError(t, err)
+		require.NoError(t, n.Start(ctx))
+		t.Cleanup(func() { n.Stop(context.Background()) })
+		return n
+	}
+	provider := start("relay", "blobstore")
+	hub := start()
+	client := start()
+
+	require.NoError(t, connectNodes(ctx, hub.Host(), provider.Host()))
+
+	t.Run("LearnsRecordsOnIdentify", func(t *testing.T) {
+		err := WaitWithCondition(ctx, func() bool {
+			return len(hub.Capabilities().Providers("blobstore")) == 1
+		}, 10*time.Second, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		rec, ok := hub.Capabilities().Record(provider.Host().ID())
+		require.True(t, ok)
+		assert.Equal(t, []string{"blobstore", "relay"}, rec.Services)
+	})
+
+	t.Run("PeerExchange", func(t *testing.T) {
+		require.NoError(t, connectNodes(ctx, client.Host(), hub.Host()))
+
+		// The client never talked to the provider, only to the hub that passed its record on
+		err := WaitWithCondition(ctx, func() bool {
+			return len(client.Capabilities().Providers("relay")) == 1
+		}, 10*time.Second, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		found, err := client.FindService(ctx, "blobstore", 1)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		assert.Equal(t, provider.Host().ID(), found[0].ID)
+
+		found, err = client.FindService(ctx, "mailbox", 1)
+		require.NoError(t, err)
+		assert.Empty(t, found)
+	})
+
+	t.Run("FetchWithoutServices", func(t *testing.T) {
+		rec, err := client.Capabilities().Fetch(ctx, hub.Host().ID())
+		require.NoError(t, err)
+		assert.Equal(t, hub.Host().ID(), rec.PeerID)
+		assert.Empty(t, rec.Services)
+	})
+
+	t.Run("AdvertiseServices", func(t *testing.T) {
+		provider.AdvertiseServices("mailbox")
+
+		rec, err := hub.Capabilities().Fetch(ctx, provider.Host().ID())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"mailbox"}, rec.Services)
+		assert.Empty(t, hub.Capabilities().Providers("blobstore"))
+		assert.Len(t, hub.Capabilities().Providers("mailbox"), 1)
+	})
+}
+
+func TestServiceRecordVerification(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	seal := func(rec *ServiceRecord, key crypto.PrivKey) []byte {
+		env, err := record.Seal(rec, key)
+		require.NoError(t, err)
+		data, err := env.Marshal()
+		require.NoError(t, err)
+		return data
+	}
+	now := uint64(time.Now().UnixNano())
+
+	rec, err := consumeServiceRecord(seal(&ServiceRecord{PeerID: id, Seq: now, Services: []string{"relay"}}, key))
+	require.NoError(t, err)
+	assert.True(t, rec.Provides("relay"))
+
+	_, err = consumeServiceRecord(seal(&ServiceRecord{PeerID: id, Seq: now, Services: []string{"relay"}}, other))
+	assert.Error(t, err, "records signed by another peer must be rejected")
+
+	old := uint64(time.Now().Add(-2 * serviceRecordTTL).UnixNano())
+	_, err = consumeServiceRecord(seal(&ServiceRecord{PeerID: id, Seq: old}, key))
+	assert.Error(t, err, "expired records must be rejected")
+
+	data := seal(&ServiceRecord{PeerID: id, Seq: now}, key)
+	data[len(data)-1] ^= 0xff
+	_, err = consumeServiceRecord(data)
+	assert.Error(t, err, "tampered records must be rejected")
+
+	assert.Equal(t, serviceKey("relay"), serviceKey("relay"))
+	assert.NotEqual(t, serviceKey("relay"), serviceKey("mailbox"))
+}
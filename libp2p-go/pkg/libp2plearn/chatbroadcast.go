@@ -0,0 +1,302 @@
+package libp2plearn
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SlowPeerDropOldest drops the oldest message queued for a peer that
+	// can't keep up, so it sees the latest ones
+	SlowPeerDropOldest = "drop-oldest"
+
+	// SlowPeerDropNewest drops new messages for a peer until its queue drains
+	SlowPeerDropNewest = "drop-newest"
+
+	// SlowPeerDisconnect closes the session of a peer that can't keep up
+	SlowPeerDisconnect = "disconnect"
+)
+
+var (
+	// chatBroadcastQueued is the number of messages waiting to be sent to room members
+	chatBroadcastQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "libp2p_learn",
+		Subsystem: "chat_broadcast",
+		Name:      "queued_messages",
+		Help:      "Number of broadcast chat messages waiting to be sent, over all peers",
+	})
+
+	// chatBroadcastQueueDepth is the depth of a peer's queue as messages are added
+	chatBroadcastQueueDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "libp2p_learn",
+		Subsystem: "chat_broadcast",
+		Name:      "queue_depth",
+		Help:      "Messages queued for a peer, including the new one, each time a message is broadcast",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// chatBroadcastDropped counts messages dropped for slow peers by policy
+	chatBroadcastDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "libp2p_learn",
+		Subsystem: "chat_broadcast",
+		Name:      "dropped_total",
+		Help:      "Number of broadcast chat messages dropped for slow peers, by slow peer policy",
+	}, []string{"policy"})
+)
+
+// ErrSlowPeer is reported for a broadcast message dropped because the peer
+// couldn't keep up
+var ErrSlowPeer = errors.New("peer too slow, message dropped")
+
+// ChatSentFunc is told how sending a broadcast message to one session went:
+// its message ID, or why it wasn't sent
+type ChatSentFunc func(sess *ChatSession, id uint64, err error)
+
+// ChatQueueStats is the state of one member's send queue
+type ChatQueueStats struct {
+	Peer    peer.ID `json:"peer"`
+	Queued  int     `json:"queued"`
+	Dropped int     `json:"dropped"` // messages dropped since the member joined
+}
+
+// chatBroadcast is a message waiting to be sent to one member
+type chatBroadcast struct {
+	text string
+	sent ChatSentFunc
+}
+
+// chatQueue holds what is waiting to be sent to one member. A typing
+// indicator is merged with the one already waiting, and dropped when a
+// message is, since a message ends it anyway.
+type chatQueue struct {
+	sess     *ChatSession
+	messages []chatBroadcast
+	typing   bool
+	dropped  int
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+// ChatBroadcaster sends chat messages and typing indicators to every member
+// of a room. Each member has its own bounded queue and sender, so a slow
+// peer only delays itself. When its queue is full, the slow peer policy
+// decides what gives.
+type ChatBroadcaster struct {
+	size   int
+	policy string
+
+	mu      sync.Mutex
+	members map[*ChatSession]*chatQueue
+	wg      sync.WaitGroup
+}
+
+// NewChatBroadcaster creates a broadcaster queueing up to size messages per
+// member, handling slow peers with one of the SlowPeer policies
+func NewChatBroadcaster(size int, policy string) *ChatBroadcaster {
+	return &ChatBroadcaster{
+		size:    max(size, 1),
+		policy:  policy,
+		members: make(map[*ChatSession]*chatQueue),
+	}
+}
+
+// Add makes a session a member of the room
+func (b *ChatBroadcaster) Add(sess *ChatSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.members[sess]; ok {
+		return
+	}
+	q := &chatQueue{
+		sess: sess,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	b.members[sess] = q
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.run(q)
+	}()
+}
+
+// Remove takes a session out of the room. Messages still queued for it are
+// not sent.
+func (b *ChatBroadcaster) Remove(sess *ChatSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.members[sess]; ok {
+		b.remove(q)
+	}
+}
+
+// remove stops a member's sender; b.mu must be held
+func (b *ChatBroadcaster) remove(q *chatQueue) {
+	delete(b.members, q.sess)
+	chatBroadcastQueued.Sub(float64(len(q.messages)))
+	q.messages = nil
+	close(q.done)
+}
+
+// Close takes every session out of the room and waits for their senders
+func (b *ChatBroadcaster) Close() {
+	b.mu.Lock()
+	for _, q := range b.members {
+		b.remove(q)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// Broadcast queues a message for every member and returns how many it was
+// queued for. sent, if set, is called from another goroutine for each of
+// them once the message is sent, fails or is dropped.
+func (b *ChatBroadcaster) Broadcast(text string, sent ChatSentFunc) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queued := 0
+	for _, q := range b.members {
+		if b.enqueue(q, chatBroadcast{text: text, sent: sent}) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// enqueue adds a message to a member's queue, applying the slow peer policy
+// when it is full; b.mu must be held
+func (b *ChatBroadcaster) enqueue(q *chatQueue, msg chatBroadcast) bool {
+	if len(q.messages) >= b.size {
+		chatBroadcastDropped.WithLabelValues(b.policy).Inc()
+		switch b.policy {
+		case SlowPeerDropNewest:
+			q.dropped++
+			msg.report(q.sess, ErrSlowPeer)
+			return false
+		case SlowPeerDisconnect:
+			logrus.WithFields(logrus.Fields{
+				"peer":   q.sess.Peer(),
+				"queued": len(q.messages),
+			}).Warn("Closing chat session of a peer that can't keep up")
+			for _, dropped := range q.messages {
+				dropped.report(q.sess, ErrSlowPeer)
+			}
+			msg.report(q.sess, ErrSlowPeer)
+			b.remove(q)
+			go q.sess.Close()
+			return false
+		default:
+			q.dropped++
+			q.messages[0].report(q.sess, ErrSlowPeer)
+			q.messages = q.messages[1:]
+			chatBroadcastQueued.Dec()
+		}
+	}
+
+	q.messages = append(q.messages, msg)
+	q.typing = false
+	chatBroadcastQueued.Inc()
+	chatBroadcastQueueDepth.Observe(float64(len(q.messages)))
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// report tells sent that the message wasn't sent to a session, from
+// another goroutine since the broadcaster's lock is held
+func (msg chatBroadcast) report(sess *ChatSession, err error) {
+	if msg.sent != nil {
+		go msg.sent(sess, 0, err)
+	}
+}
+
+// Typing tells every member we are typing. Members with messages still
+// queued are skipped, and indicators waiting to be sent are merged.
+func (b *ChatBroadcaster) Typing() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range b.members {
+		if len(q.messages) > 0 || q.typing {
+			continue
+		}
+		q.typing = true
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Queue returns the state of a member's send queue
+func (b *ChatBroadcaster) Queue(sess *ChatSession) ChatQueueStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := ChatQueueStats{Peer: sess.Peer()}
+	if q, ok := b.members[sess]; ok {
+		stats.Queued = len(q.messages)
+		stats.Dropped = q.dropped
+	}
+	return stats
+}
+
+// Queues returns the state of every member's send queue
+func (b *ChatBroadcaster) Queues() []ChatQueueStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make([]ChatQueueStats, 0, len(b.members))
+	for _, q := range b.members {
+		stats = append(stats, ChatQueueStats{Peer: q.sess.Peer(), Queued: len(q.messages), Dropped: q.dropped})
+	}
+	return stats
+}
+
+// run sends what is queued for a member until it is removed
+func (b *ChatBroadcaster) run(q *chatQueue) {
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.wake:
+		}
+
+		for {
+			b.mu.Lock()
+			var msg *chatBroadcast
+			typing := false
+			if len(q.messages) > 0 {
+				next := q.messages[0]
+				msg = &next
+				q.messages = q.messages[1:]
+				chatBroadcastQueued.Dec()
+			} else if q.typing {
+				q.typing = false
+				typing = true
+			}
+			b.mu.Unlock()
+			if msg == nil && !typing {
+				break
+			}
+
+			if msg != nil {
+				id, err := q.sess.Send(msg.text)
+				if err != nil {
+					logrus.WithError(err).WithField("peer", q.sess.Peer()).Debug("Failed to send broadcast chat message")
+				}
+				if msg.sent != nil {
+					msg.sent(q.sess, id, err)
+				}
+			} else if err := q.sess.Typing(); err != nil {
+				logrus.WithError(err).WithField("peer", q.sess.Peer()).Debug("Failed to send typing indicator")
+			}
+		}
+	}
+}
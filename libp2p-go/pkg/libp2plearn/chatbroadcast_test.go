@@ -0,0 +1,193 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stalledOpener opens streams whose writes wait until release is closed,
+// like those to a peer that stopped reading
+type stalledOpener struct {
+	host    host.Host
+	release chan struct{}
+}
+
+func (o *stalledOpener) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := o.host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return &stalledStream{Stream: s, release: o.release, closed: make(chan struct{})}, nil
+}
+
+type stalledStream struct {
+	network.Stream
+	release chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (s *stalledStream) Write(p []byte) (int, error) {
+	select {
+	case <-s.release:
+		return s.Stream.Write(p)
+	case <-s.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (s *stalledStream) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return s.Stream.Close()
+}
+
+// chatServer accepts chat sessions and hands them over
+func chatServer(t *testing.T, ctx context.Context) (host.Host, chan *ChatSession) {
+	t.Helper()
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	handler := NewProtocolHandler(h)
+	handler.SetupProtocols()
+	sessions := make(chan *ChatSession, 1)
+	handler.SetChatSessionHandler(func(sess *ChatSession) {
+		sessions <- sess
+	})
+	return h, sessions
+}
+
+// nextChatMessage waits for the next message of a session, skipping
+// typing indicators
+func nextChatMessage(t *testing.T, sess *ChatSession) string {
+	t.Helper()
+	for {
+		if evt := nextChatEvent(t, sess); evt.Type == ChatEventMessage {
+			return evt.Text
+		}
+	}
+}
+
+func TestChatBroadcaster(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	fast, fastSessions := chatServer(t, ctx)
+	defer fast.Close()
+	slow, slowSessions := chatServer(t, ctx)
+	defer slow.Close()
+	require.NoError(t, connectNodes(ctx, client, fast))
+	require.NoError(t, connectNodes(ctx, client, slow))
+
+	// openStalled opens a session to the slow peer whose writes wait until
+	// release is closed
+	openStalled := func(release chan struct{}) *ChatSession {
+		handler := NewProtocolHandler(client)
+		handler.SetStreamOpener(&stalledOpener{host: client, release: release})
+		sess, err := handler.OpenChatSession(ctx, slow.ID())
+		require.NoError(t, err)
+		return sess
+	}
+
+	t.Run("DropOldest", func(t *testing.T) {
+		fastSess, err := NewProtocolHandler(client).OpenChatSession(ctx, fast.ID())
+		require.NoError(t, err)
+		defer fastSess.Close()
+		release := make(chan struct{})
+		slowSess := openStalled(release)
+		defer slowSess.Close()
+
+		room := NewChatBroadcaster(2, SlowPeerDropOldest)
+		defer room.Close()
+		room.Add(fastSess)
+		room.Add(slowSess)
+
+		var mu sync.Mutex
+		var slowErrs []error
+		sent := func(sess *ChatSession, id uint64, err error) {
+			if sess == slowSess && err != nil {
+				mu.Lock()
+				slowErrs = append(slowErrs, err)
+				mu.Unlock()
+			}
+		}
+
+		// The first message is taken off the queue and stalls on the wire
+		assert.Equal(t, 2, room.Broadcast("m1", sent))
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return room.Queue(slowSess).Queued == 0
+		}, 5*time.Second, 10*time.Millisecond))
+		for i := 2; i <= 5; i++ {
+			assert.Equal(t, 2, room.Broadcast(fmt.Sprintf("m%d", i), sent))
+			require.NoError(t, WaitWithCondition(ctx, func() bool {
+				return room.Queue(fastSess).Queued == 0
+			}, 5*time.Second, 10*time.Millisecond))
+		}
+
+		// The fast peer gets everything while the slow one is stuck
+		fastRemote := <-fastSessions
+		defer fastRemote.Close()
+		for i := 1; i <= 5; i++ {
+			assert.Equal(t, fmt.Sprintf("m%d", i), nextChatMessage(t, fastRemote))
+		}
+
+		stats := room.Queue(slowSess)
+		assert.Equal(t, 2, stats.Queued)
+		assert.Equal(t, 2, stats.Dropped)
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(slowErrs) == 2
+		}, 5*time.Second, 10*time.Millisecond))
+		mu.Lock()
+		assert.ErrorIs(t, slowErrs[0], ErrSlowPeer)
+		mu.Unlock()
+
+		// Once it catches up, it sees the first message and the latest ones
+		close(release)
+		slowRemote := <-slowSessions
+		defer slowRemote.Close()
+		for _, want := range []string{"m1", "m4", "m5"} {
+			assert.Equal(t, want, nextChatMessage(t, slowRemote))
+		}
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		slowSess := openStalled(release)
+
+		room := NewChatBroadcaster(1, SlowPeerDisconnect)
+		defer room.Close()
+		room.Add(slowSess)
+
+		assert.Equal(t, 1, room.Broadcast("m1", nil))
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return room.Queue(slowSess).Queued == 0
+		}, 5*time.Second, 10*time.Millisecond))
+		assert.Equal(t, 1, room.Broadcast("m2", nil))
+		assert.Equal(t, 0, room.Broadcast("m3", nil))
+		assert.Empty(t, room.Queues())
+
+		// The session of the peer that couldn't keep up is closed
+		select {
+		case _, ok := <-slowSess.Events():
+			assert.False(t, ok)
+		case <-ctx.Done():
+			t.Fatal("slow session was never closed")
+		}
+	})
+}
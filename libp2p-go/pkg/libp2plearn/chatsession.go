@@ -0,0 +1,605 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ChatSessionProtocol carries a long-lived chat with typing indicators and read receipts
+	ChatSessionProtocol = "/libp2p-learn/chat-session/1.0.0"
+
+	// ChatResumeProtocol moves a chat session onto a new stream, such as from
+	// a relayed connection onto a direct one
+	ChatResumeProtocol = "/libp2p-learn/chat-resume/1.0.0"
+
+	// ChatTypingTimeout is how long a typing indicator should be shown
+	// unless it is refreshed or a message arrives
+	ChatTypingTimeout = 5 * time.Second
+
+	// chatTypingInterval is how often Typing sends a refresh at most
+	chatTypingInterval = 3 * time.Second
+
+	// chatWriteTimeout bounds sending one frame
+	chatWriteTimeout = 10 * time.Second
+
+	// maxChatFrameSize bounds the size of one frame
+	maxChatFrameSize = 64 * 1024
+
+	// chatEventBufferSize is how many events are queued for a slow reader
+	chatEventBufferSize = 64
+
+	// chatMoveTimeout bounds waiting for the stream a session moves to
+	chatMoveTimeout = 10 * time.Second
+)
+
+// ChatEventType says what a chat session frame carries
+type ChatEventType string
+
+// Chat session frame types
+const (
+	ChatEventMessage ChatEventType = "message"
+	ChatEventTyping  ChatEventType = "typing"
+	ChatEventRead    ChatEventType = "read"
+)
+
+// Frames that move a session between streams, which aren't delivered as events
+const (
+	// chatFrameHello carries the token of a session that may move, from
+	// the side that opened it
+	chatFrameHello ChatEventType = "hello"
+	// chatFrameMoved is the last frame on the stream a session leaves
+	chatFrameMoved ChatEventType = "moved"
+	// chatFrameResume opens the stream a session moves to with its token,
+	// and is answered with an empty one once the session is there
+	chatFrameResume ChatEventType = "resume"
+)
+
+// ChatEvent is something the peer did in a chat session. For messages, ID
+// is the peer's message ID; for read receipts, the highest ID of ours the
+// peer has read.
+type ChatEvent struct {
+	Type ChatEventType
+	ID   uint64
+	Text string
+	Time time.Time
+}
+
+// chatFrame is one chat session frame, sent as one JSON line
+type chatFrame struct {
+	Type ChatEventType `json:"type"`
+	ID   uint64        `json:"id,omitempty"`
+	Text string        `json:"text,omitempty"`
+	Time int64         `json:"time,omitempty"` // Unix milliseconds, for messages
+
+	// Sealed is the message text encrypted with secure chat, instead of Text
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+// chatStream is a stream a session reads frames from
+type chatStream struct {
+	stream network.Stream
+	reader *bufio.Reader
+}
+
+// ChatSession is an open chat with one peer. Messages are numbered by the
+// sender starting at 1, so read receipts can acknowledge everything up to
+// an ID with a single frame.
+//
+// A session opened over a relay moves onto a direct connection to the peer
+// once there is one, such as after a hole punch. Each side ends the old
+// stream with a moved frame and goes on with the new one, so no frame is
+// lost or reordered.
+type ChatSession struct {
+	peer   peer.ID
+	secure *SecureChat
+	events chan ChatEvent
+	done   chan struct{}
+	once   sync.Once
+
+	// stream changes when the session moves, with both smu and wmu held
+	smu    sync.Mutex
+	stream network.Stream
+	token  string          // names a session that may move; empty if it can't
+	moved  chan chatStream // the stream to read once the old one ends
+
+	wmu        sync.Mutex
+	nextID     uint64
+	lastTyping time.Time
+	lastRead   uint64
+
+	readUpTo atomic.Uint64
+}
+
+// SetChatSessionHandler sets the function incoming chat sessions are handed
+// to. It must not block; sessions are refused while no handler is set.
+func (p *ProtocolHandler) SetChatSessionHandler(fn func(*ChatSession)) {
+	p.hooksMu.Lock()
+	p.sessionHandler = fn
+	p.hooksMu.Unlock()
+}
+
+// SetSecureChat makes chat sessions encrypt messages end to end. Sessions
+// then refuse unencrypted messages, so both peers need it enabled.
+func (p *ProtocolHandler) SetSecureChat(sc *SecureChat) {
+	p.hooksMu.Lock()
+	p.secureChat = sc
+	p.hooksMu.Unlock()
+}
+
+// OpenChatSession starts a chat session with a peer
+func (p *ProtocolHandler) OpenChatSession(ctx context.Context, peerID peer.ID) (*ChatSession, error) {
+	s, err := p.newStream(ctx, peerID, 0, protocol.ID(ChatSessionProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	p.hooksMu.RLock()
+	sess := newChatSession(s, p.secureChat)
+	p.hooksMu.RUnlock()
+
+	// A relayed session moves onto a direct connection once there is one
+	if isRelayed(s.Conn()) {
+		token := make([]byte, 16)
+		rand.Read(token)
+		sess.token = hex.EncodeToString(token)
+		sess.wmu.Lock()
+		err := sess.write(chatFrame{Type: chatFrameHello, Text: sess.token})
+		sess.wmu.Unlock()
+		if err != nil {
+			s.Reset()
+			return nil, fmt.Errorf("failed to start chat session: %w", err)
+		}
+		go sess.moveWhenDirect(p.host, func(ctx context.Context) (network.Stream, error) {
+			return p.newStream(ctx, peerID, 0, protocol.ID(ChatResumeProtocol))
+		})
+	}
+
+	go sess.run(nil, nil)
+	return sess, nil
+}
+
+// handleChatSession hands an incoming session to the session handler and
+// reads its frames until it ends
+func (p *ProtocolHandler) handleChatSession(s network.Stream) {
+	p.hooksMu.RLock()
+	handler := p.sessionHandler
+	secure := p.secureChat
+	p.hooksMu.RUnlock()
+
+	remote := s.Conn().RemotePeer()
+	if handler == nil {
+		logrus.WithField("peer", remote).Debug("Refused chat session without a session handler")
+		s.Reset()
+		return
+	}
+
+	logrus.WithField("peer", remote).Info("Chat session opened")
+	sess := newChatSession(s, secure)
+	handler(sess)
+	sess.run(func(text string) {
+		p.notifyMessage(protocol.ID(ChatSessionProtocol), remote, text)
+	}, &p.movable)
+	logrus.WithField("peer", remote).Info("Chat session closed")
+}
+
+// handleChatResume moves an incoming session onto the stream, for the peer
+// that opened it
+func (p *ProtocolHandler) handleChatResume(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	s.SetReadDeadline(time.Now().Add(chatWriteTimeout))
+	reader := bufio.NewReaderSize(s, maxChatFrameSize)
+	frame, err := readChatFrame(reader)
+	if err != nil || frame.Type != chatFrameResume {
+		logrus.WithError(err).WithField("peer", remote).Debug("Received invalid chat resume request")
+		s.Reset()
+		return
+	}
+	sess := p.movable.find(remote, frame.Text)
+	if sess == nil {
+		logrus.WithField("peer", remote).Debug("Refused to resume unknown chat session")
+		s.Reset()
+		return
+	}
+	s.SetReadDeadline(time.Time{})
+
+	if err := sess.resume(chatStream{stream: s, reader: reader}); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to move chat session")
+		s.Reset()
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"peer":    remote,
+		"relayed": isRelayed(s.Conn()),
+	}).Info("Chat session moved to a new stream")
+}
+
+func newChatSession(s network.Stream, secure *SecureChat) *ChatSession {
+	return &ChatSession{
+		peer:   s.Conn().RemotePeer(),
+		stream: s,
+		secure: secure,
+		events: make(chan ChatEvent, chatEventBufferSize),
+		done:   make(chan struct{}),
+		moved:  make(chan chatStream, 1),
+	}
+}
+
+// chatSessions finds incoming sessions that may move by peer and token
+type chatSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*ChatSession
+}
+
+func (r *chatSessions) add(p peer.ID, token string, sess *ChatSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[string]*ChatSession)
+	}
+	r.sessions[p.String()+"/"+token] = sess
+}
+
+func (r *chatSessions) remove(sess *ChatSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, s := range r.sessions {
+		if s == sess {
+			delete(r.sessions, key)
+		}
+	}
+}
+
+func (r *chatSessions) find(p peer.ID, token string) *ChatSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[p.String()+"/"+token]
+}
+
+// Peer returns the peer on the other end of the session
+func (c *ChatSession) Peer() peer.ID {
+	return c.peer
+}
+
+// Relayed reports whether the session currently goes through a relay
+func (c *ChatSession) Relayed() bool {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return isRelayed(c.stream.Conn())
+}
+
+// Events delivers what the peer does until the session ends, when it is
+// closed. Messages and read receipts wait for a slow reader; typing
+// indicators are dropped instead.
+func (c *ChatSession) Events() <-chan ChatEvent {
+	return c.events
+}
+
+// ReadUpTo returns the highest ID of our messages the peer has read
+func (c *ChatSession) ReadUpTo() uint64 {
+	return c.readUpTo.Load()
+}
+
+// Send sends a message and returns its ID
+func (c *ChatSession) Send(text string) (uint64, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	frame := chatFrame{Type: ChatEventMessage, ID: c.nextID + 1, Text: text, Time: time.Now().UnixMilli()}
+	if c.secure != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), chatWriteTimeout)
+		sealed, err := c.secure.Encrypt(ctx, c.Peer(), []byte(text))
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt chat message: %w", err)
+		}
+		frame.Text, frame.Sealed = "", sealed
+	}
+	if err := c.write(frame); err != nil {
+		return 0, fmt.Errorf("failed to send chat message: %w", err)
+	}
+	c.nextID = frame.ID
+	// Sending a message ends our typing indicator on the other side
+	c.lastTyping = time.Time{}
+	return frame.ID, nil
+}
+
+// Typing tells the peer we are typing. Call it on every keystroke; refreshes
+// are sent at most every few seconds.
+func (c *ChatSession) Typing() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if time.Since(c.lastTyping) < chatTypingInterval {
+		return nil
+	}
+	if err := c.write(chatFrame{Type: ChatEventTyping}); err != nil {
+		return fmt.Errorf("failed to send typing indicator: %w", err)
+	}
+	c.lastTyping = time.Now()
+	return nil
+}
+
+// MarkRead tells the peer we have read its messages up to id. Receipts that
+// don't move forward are not sent.
+func (c *ChatSession) MarkRead(id uint64) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if id <= c.lastRead {
+		return nil
+	}
+	if err := c.write(chatFrame{Type: ChatEventRead, ID: id}); err != nil {
+		return fmt.Errorf("failed to send read receipt: %w", err)
+	}
+	c.lastRead = id
+	return nil
+}
+
+// Close ends the session
+func (c *ChatSession) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.smu.Lock()
+		close(c.done)
+		s := c.stream
+		c.smu.Unlock()
+		err = s.Close()
+	})
+	return err
+}
+
+// write sends one frame; c.wmu must be held
+func (c *ChatSession) write(frame chatFrame) error {
+	return writeChatFrame(c.stream, frame)
+}
+
+// writeChatFrame sends one frame on a stream
+func writeChatFrame(s network.Stream, frame chatFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	s.SetWriteDeadline(time.Now().Add(chatWriteTimeout))
+	_, err = s.Write(append(data, '\n'))
+	return err
+}
+
+// readChatFrame reads one frame
+func readChatFrame(r *bufio.Reader) (chatFrame, error) {
+	var frame chatFrame
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return frame, err
+	}
+	err = json.Unmarshal(line, &frame)
+	return frame, err
+}
+
+// moveWhenDirect moves the session onto a stream opened with open once the
+// host has a direct connection to the peer. It gives up if that fails,
+// leaving the session on the relay.
+func (c *ChatSession) moveWhenDirect(h host.Host, open func(context.Context) (network.Stream, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	select {
+	case <-waitDirect(ctx, h, c.peer):
+	case <-ctx.Done():
+		return
+	}
+
+	moveCtx, moveCancel := context.WithTimeout(ctx, chatMoveTimeout)
+	defer moveCancel()
+	if err := c.move(moveCtx, open); err != nil {
+		logrus.WithError(err).WithField("peer", c.peer).Debug("Failed to move chat session to a direct connection")
+		return
+	}
+	logrus.WithField("peer", c.peer).Info("Chat session moved to a direct connection")
+}
+
+// move moves the session onto a stream opened with open, which must not go
+// through a relay. Only the side that opened the session moves it.
+func (c *ChatSession) move(ctx context.Context, open func(context.Context) (network.Stream, error)) error {
+	if c.token == "" {
+		return errors.New("chat session can't be moved")
+	}
+	s, err := open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	if isRelayed(s.Conn()) {
+		s.Reset()
+		return errors.New("new stream is relayed too")
+	}
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	// Nothing is sent on the old stream while the peer switches over
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	reader := bufio.NewReaderSize(s, maxChatFrameSize)
+	if err := writeChatFrame(s, chatFrame{Type: chatFrameResume, Text: c.token}); err != nil {
+		s.Reset()
+		return fmt.Errorf("failed to send chat resume request: %w", err)
+	}
+	if ack, err := readChatFrame(reader); err != nil || ack.Type != chatFrameResume {
+		s.Reset()
+		return fmt.Errorf("peer refused to move chat session: %v", err)
+	}
+	if !stop() {
+		return ctx.Err()
+	}
+	return c.switchTo(chatStream{stream: s, reader: reader})
+}
+
+// resume moves the session onto a stream the peer opened, confirming it
+// with an empty resume frame
+func (c *ChatSession) resume(next chatStream) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := writeChatFrame(next.stream, chatFrame{Type: chatFrameResume}); err != nil {
+		return fmt.Errorf("failed to confirm chat resume: %w", err)
+	}
+	return c.switchTo(next)
+}
+
+// switchTo ends the old stream with a moved frame and writes to next from
+// then on. The reader goes on with next once the peer ends the old stream
+// the same way. c.wmu must be held.
+func (c *ChatSession) switchTo(next chatStream) error {
+	select {
+	case c.moved <- next:
+	default:
+		return errors.New("chat session is already moving")
+	}
+
+	old := c.stream
+	if err := c.write(chatFrame{Type: chatFrameMoved}); err != nil {
+		logrus.WithError(err).WithField("peer", c.peer).Debug("Failed to end old chat stream")
+	}
+	old.CloseWrite()
+
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	select {
+	case <-c.done:
+		next.stream.Reset()
+		return errors.New("chat session closed")
+	default:
+	}
+	c.stream = next.stream
+	return nil
+}
+
+// next waits for the stream the session moves to after the peer ended the
+// old one with a moved frame
+func (c *ChatSession) next() (chatStream, bool) {
+	timer := time.NewTimer(chatMoveTimeout)
+	defer timer.Stop()
+	select {
+	case next := <-c.moved:
+		return next, true
+	case <-timer.C:
+	case <-c.done:
+	}
+	return chatStream{}, false
+}
+
+// run reads frames into events until the stream ends or the session is
+// closed. onMessage, if set, sees the text of every message. Sessions that
+// may move are added to movable, if set, until they end.
+func (c *ChatSession) run(onMessage func(string), movable *chatSessions) {
+	defer close(c.events)
+	defer c.Close()
+	if movable != nil {
+		defer movable.remove(c)
+	}
+
+	cur := chatStream{stream: c.stream}
+	cur.reader = bufio.NewReaderSize(cur.stream, maxChatFrameSize)
+	moving := false
+	for {
+		line, err := cur.reader.ReadSlice('\n')
+		if err != nil {
+			if moving {
+				if next, ok := c.next(); ok {
+					cur.stream.Close()
+					cur, moving = next, false
+					continue
+				}
+			}
+			select {
+			case <-c.done:
+			default:
+				logrus.WithError(err).WithField("peer", c.Peer()).Debug("Chat session ended")
+			}
+			return
+		}
+
+		var frame chatFrame
+		if err := json.Unmarshal(line, &frame); err != nil {
+			logrus.WithError(err).WithField("peer", c.Peer()).Warn("Received invalid chat frame")
+			cur.stream.Reset()
+			return
+		}
+
+		switch frame.Type {
+		case ChatEventMessage:
+			if (frame.Sealed != nil) != (c.secure != nil) {
+				// Never fall back to plain text once secure chat is on
+				logrus.WithFields(logrus.Fields{
+					"peer":   c.Peer(),
+					"sealed": frame.Sealed != nil,
+				}).Warn("Refused chat message: secure chat must be enabled on both sides")
+				cur.stream.Reset()
+				return
+			}
+			if c.secure != nil {
+				text, err := c.secure.Decrypt(c.Peer(), frame.Sealed)
+				if err != nil {
+					logrus.WithError(err).WithField("peer", c.Peer()).Warn("Dropped chat message that failed to decrypt")
+					continue
+				}
+				frame.Text = string(text)
+			}
+			if onMessage != nil {
+				onMessage(frame.Text)
+			}
+			if !c.deliver(ChatEvent{Type: ChatEventMessage, ID: frame.ID, Text: frame.Text, Time: time.UnixMilli(frame.Time)}) {
+				return
+			}
+		case ChatEventRead:
+			if frame.ID > c.readUpTo.Load() {
+				c.readUpTo.Store(frame.ID)
+			}
+			if !c.deliver(ChatEvent{Type: ChatEventRead, ID: frame.ID, Time: time.Now()}) {
+				return
+			}
+		case ChatEventTyping:
+			select {
+			case c.events <- ChatEvent{Type: ChatEventTyping, Time: time.Now()}:
+			default:
+			}
+		case chatFrameHello:
+			if movable != nil && frame.Text != "" {
+				movable.add(c.peer, frame.Text, c)
+			}
+		case chatFrameMoved:
+			moving = true
+		default:
+			// Ignore frames from newer versions of the protocol
+		}
+	}
+}
+
+// deliver queues an event, waiting for room; false once the session is closed
+func (c *ChatSession) deliver(evt ChatEvent) bool {
+	select {
+	case c.events <- evt:
+		return true
+	case <-c.done:
+		return false
+	}
+}
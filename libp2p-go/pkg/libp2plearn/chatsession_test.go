@@ -0,0 +1,207 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextChatEvent waits for the next event of a session
+func nextChatEvent(t *testing.T, sess *ChatSession) ChatEvent {
+	t.Helper()
+	select {
+	case evt, ok := <-sess.Events():
+		require.True(t, ok, "session ended")
+		return evt
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for chat event")
+		return ChatEvent{}
+	}
+}
+
+func TestChatSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	serverHandler := NewProtocolHandler(server)
+	serverHandler.SetupProtocols()
+
+	sessions := make(chan *ChatSession, 1)
+	serverHandler.SetChatSessionHandler(func(sess *ChatSession) {
+		sessions <- sess
+	})
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+	handler := NewProtocolHandler(client)
+
+	local, err := handler.OpenChatSession(ctx, server.ID())
+	require.NoError(t, err)
+	defer local.Close()
+
+	// The session reaches the server with the first frame
+	id, err := local.Send("hi")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), id)
+
+	var remote *ChatSession
+	select {
+	case remote = <-sessions:
+	case <-ctx.Done():
+		t.Fatal("server never saw the session")
+	}
+	defer remote.Close()
+	assert.Equal(t, client.ID(), remote.Peer())
+
+	t.Run("Message", func(t *testing.T) {
+		evt := nextChatEvent(t, remote)
+		assert.Equal(t, ChatEventMessage, evt.Type)
+		assert.Equal(t, uint64(1), evt.ID)
+		assert.Equal(t, "hi", evt.Text)
+	})
+
+	t.Run("ReadReceipt", func(t *testing.T) {
+		require.NoError(t, remote.MarkRead(1))
+		// Receipts that don't move forward are not sent
+		require.NoError(t, remote.MarkRead(1))
+
+		evt := nextChatEvent(t, local)
+		assert.Equal(t, ChatEventRead, evt.Type)
+		assert.Equal(t, uint64(1), evt.ID)
+		assert.Equal(t, uint64(1), local.ReadUpTo())
+	})
+
+	t.Run("TypingIsRateLimited", func(t *testing.T) {
+		require.NoError(t, local.Typing())
+		require.NoError(t, local.Typing())
+		_, err := local.Send("second")
+		require.NoError(t, err)
+
+		assert.Equal(t, ChatEventTyping, nextChatEvent(t, remote).Type)
+		evt := nextChatEvent(t, remote)
+		assert.Equal(t, ChatEventMessage, evt.Type)
+		assert.Equal(t, uint64(2), evt.ID)
+		assert.Equal(t, "second", evt.Text)
+	})
+
+	t.Run("CloseEndsPeerEvents", func(t *testing.T) {
+		require.NoError(t, local.Close())
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			select {
+			case _, ok := <-remote.Events():
+				return !ok
+			default:
+				return false
+			}
+		}, 5*time.Second, 50*time.Millisecond))
+	})
+
+	t.Run("RefusedWithoutHandler", func(t *testing.T) {
+		other, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer other.Close()
+		NewProtocolHandler(other).SetupProtocols()
+		require.NoError(t, connectNodes(ctx, client, other))
+
+		sess, err := handler.OpenChatSession(ctx, other.ID())
+		require.NoError(t, err)
+		defer sess.Close()
+		sess.Send("anyone there?")
+
+		select {
+		case _, ok := <-sess.Events():
+			assert.False(t, ok)
+		case <-time.After(5 * time.Second):
+			t.Fatal("refused session never ended")
+		}
+	})
+}
+
+func TestChatSessionMovesOffRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relayHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer relayHost.Close()
+	relay, err := relayv2.New(relayHost)
+	require.NoError(t, err)
+	defer relay.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	server, sessions := chatServer(t, ctx)
+	defer server.Close()
+	reservations := NewRelayReservations(server, []peer.AddrInfo{relayInfo})
+	reservations.Start()
+	defer reservations.Close()
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		_, ok := reservations.Expiry(relayHost.ID())
+		return ok
+	}, 10*time.Second, 100*time.Millisecond))
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Reach the server through the relay only
+	relayCtx := network.WithAllowLimitedConn(ctx, "test")
+	circuit := multiaddr.StringCast(fmt.Sprintf("%s/p2p/%s/p2p-circuit", relayInfo.Addrs[0], relayHost.ID()))
+	require.NoError(t, client.Connect(relayCtx, peer.AddrInfo{ID: server.ID(), Addrs: []multiaddr.Multiaddr{circuit}}))
+
+	local, err := NewProtocolHandler(client).OpenChatSession(relayCtx, server.ID())
+	require.NoError(t, err)
+	defer local.Close()
+	assert.True(t, local.Relayed())
+
+	_, err = local.Send("over the relay")
+	require.NoError(t, err)
+	var remote *ChatSession
+	select {
+	case remote = <-sessions:
+	case <-ctx.Done():
+		t.Fatal("server never saw the session")
+	}
+	defer remote.Close()
+	assert.Equal(t, "over the relay", nextChatMessage(t, remote))
+
+	// A direct connection comes up, as after a hole punch
+	var direct []multiaddr.Multiaddr
+	for _, addr := range server.Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_TCP); err == nil {
+			direct = append(direct, addr)
+		}
+	}
+	client.Peerstore().ClearAddrs(server.ID())
+	punchCtx := network.WithForceDirectDial(ctx, "test")
+	require.NoError(t, client.Connect(punchCtx, peer.AddrInfo{ID: server.ID(), Addrs: direct}))
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		return !local.Relayed() && !remote.Relayed()
+	}, 10*time.Second, 50*time.Millisecond))
+
+	// The session goes on where it left off
+	id, err := local.Send("direct")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), id)
+	evt := nextChatEvent(t, remote)
+	assert.Equal(t, uint64(2), evt.ID)
+	assert.Equal(t, "direct", evt.Text)
+
+	require.NoError(t, remote.MarkRead(2))
+	evt = nextChatEvent(t, local)
+	assert.Equal(t, ChatEventRead, evt.Type)
+	assert.Equal(t, uint64(2), evt.ID)
+}
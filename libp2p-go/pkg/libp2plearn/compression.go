@@ -0,0 +1,227 @@
+package libp2plearn
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Compression algorithms for protocol streams
+const (
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// compressionAlgorithms are the algorithms every node accepts, in order of preference
+var compressionAlgorithms = []string{CompressionZstd, CompressionSnappy, CompressionGzip}
+
+// compressibleProtocols are the protocols that can be spoken compressed
+var compressibleProtocols = map[string]bool{
+	ChatProtocol:   true,
+	EchoProtocol:   true,
+	EchoV2Protocol: true,
+}
+
+// CompressionConfig compresses the streams a node opens for one protocol
+type CompressionConfig struct {
+	Algorithm string `json:"algorithm"` // gzip, zstd or snappy
+	MinSize   int    `json:"min_size"`  // messages smaller than this are sent uncompressed
+}
+
+// CompressedProtocol returns the ID a protocol is negotiated under when its
+// stream is compressed with alg, e.g. /libp2p-learn/echo/1.0.0-zstd
+func CompressedProtocol(proto protocol.ID, alg string) protocol.ID {
+	return protocol.ID(fmt.Sprintf("%s-%s", proto, alg))
+}
+
+// compressionOf returns the algorithm a negotiated protocol ID is compressed with, if any
+func compressionOf(id protocol.ID) string {
+	for _, alg := range compressionAlgorithms {
+		if strings.HasSuffix(string(id), "-"+alg) {
+			return alg
+		}
+	}
+	return ""
+}
+
+// baseProtocol returns a negotiated protocol ID without its compression suffix,
+// so limits and priorities set for a protocol apply to its compressed streams
+func baseProtocol(id protocol.ID) protocol.ID {
+	if alg := compressionOf(id); alg != "" {
+		return id[:len(id)-len(alg)-1]
+	}
+	return id
+}
+
+// validCompression reports whether alg is a supported algorithm
+func validCompression(alg string) bool {
+	for _, a := range compressionAlgorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// compressor is a streaming compressor that can push out what was written so far
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newCompressor(alg string, w io.Writer) (compressor, error) {
+	switch alg {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case CompressionSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", alg)
+}
+
+func newDecompressor(alg string, r io.Reader) (io.Reader, func(), error) {
+	switch alg {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() { zr.Close() }, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case CompressionSnappy:
+		return snappy.NewReader(r), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported compression %q", alg)
+}
+
+// compressedStream compresses what is written to a stream and decompresses
+// what is read from it. Every Write is flushed, so request/response protocols
+// see each message as soon as it is sent.
+type compressedStream struct {
+	network.Stream
+	alg string
+
+	wmu    sync.Mutex
+	w      compressor
+	wDone  bool
+	rmu    sync.Mutex
+	r      io.Reader
+	rClose func()
+}
+
+// compressStream wraps a stream whose negotiated protocol carries a
+// compression suffix; other streams are returned as they are
+func compressStream(s network.Stream) (network.Stream, error) {
+	alg := compressionOf(s.Protocol())
+	if alg == "" {
+		return s, nil
+	}
+	w, err := newCompressor(alg, s)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedStream{Stream: s, alg: alg, w: w}, nil
+}
+
+// Read decompresses data from the stream. The decompressor is created on
+// first use since some read a header before returning.
+func (c *compressedStream) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.r == nil {
+		r, closeFn, err := newDecompressor(c.alg, c.Stream)
+		if err != nil {
+			return 0, err
+		}
+		c.r, c.rClose = r, closeFn
+	}
+	n, err := c.r.Read(p)
+	if err != nil {
+		c.releaseReader()
+	}
+	return n, err
+}
+
+// Write compresses p and flushes it to the stream
+func (c *compressedStream) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.wDone {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+// finishWrite ends the compressed stream so the peer's decompressor sees EOF
+func (c *compressedStream) finishWrite() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.wDone {
+		return nil
+	}
+	c.wDone = true
+	return c.w.Close()
+}
+
+// CloseWrite ends the compressed data, then closes the stream for writing
+func (c *compressedStream) CloseWrite() error {
+	if err := c.finishWrite(); err != nil {
+		c.Reset()
+		return err
+	}
+	return c.Stream.CloseWrite()
+}
+
+// Close ends the compressed data and closes the stream
+func (c *compressedStream) Close() error {
+	if err := c.finishWrite(); err != nil {
+		c.Reset()
+		return err
+	}
+	err := c.Stream.Close()
+	c.rmu.Lock()
+	c.releaseReader()
+	c.rmu.Unlock()
+	return err
+}
+
+// Reset resets the stream without finishing the compressed data
+func (c *compressedStream) Reset() error {
+	c.wmu.Lock()
+	c.wDone = true
+	c.wmu.Unlock()
+
+	// Resetting unblocks a pending Read, so the decompressor is free afterwards
+	err := c.Stream.Reset()
+	c.rmu.Lock()
+	c.releaseReader()
+	c.rmu.Unlock()
+	return err
+}
+
+// releaseReader frees the decompressor's resources; c.rmu must be held
+func (c *compressedStream) releaseReader() {
+	if c.rClose != nil {
+		c.rClose()
+		c.rClose = nil
+	}
+}
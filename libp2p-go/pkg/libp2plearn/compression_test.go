@@ -0,0 +1,164 @@
+package libp2plearn
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wireRecorder opens streams through a host and records the negotiated
+// protocol and how many bytes were written on the wire
+type wireRecorder struct {
+	host host.Host
+
+	mu       sync.Mutex
+	protocol protocol.ID
+	written  int
+}
+
+func (w *wireRecorder) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := w.host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	w.protocol, w.written = s.Protocol(), 0
+	w.mu.Unlock()
+	return &countingStream{Stream: s, w: w}, nil
+}
+
+func (w *wireRecorder) last() (protocol.ID, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.protocol, w.written
+}
+
+type countingStream struct {
+	network.Stream
+	w *wireRecorder
+}
+
+func (s *countingStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.w.mu.Lock()
+	s.w.written += n
+	s.w.mu.Unlock()
+	return n, err
+}
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	NewProtocolHandler(server).SetupProtocols()
+	// Compression is set for echo v1, which SendEcho only picks without v2
+	server.RemoveStreamHandler(protocol.ID(EchoV2Protocol))
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	recorder := &wireRecorder{host: client}
+	handler := NewProtocolHandler(client)
+	handler.SetStreamOpener(recorder)
+
+	payload := strings.Repeat("the same line over and over again\n", 1000)
+
+	for _, alg := range compressionAlgorithms {
+		t.Run("Echo/"+alg, func(t *testing.T) {
+			handler.SetCompression(map[string]CompressionConfig{
+				EchoProtocol: {Algorithm: alg, MinSize: 1024},
+			})
+
+			resp, err := handler.SendEcho(ctx, server.ID(), payload)
+			require.NoError(t, err)
+			assert.Equal(t, payload, resp)
+
+			proto, written := recorder.last()
+			assert.Equal(t, CompressedProtocol(EchoProtocol, alg), proto)
+			assert.Less(t, written, len(payload)/10, "repetitive data should shrink on the wire")
+		})
+	}
+
+	t.Run("Chat", func(t *testing.T) {
+		handler.SetCompression(map[string]CompressionConfig{
+			ChatProtocol: {Algorithm: CompressionGzip},
+		})
+
+		resp, err := handler.SendChatMessage(ctx, server.ID(), "hello")
+		require.NoError(t, err)
+		assert.Contains(t, resp, "Echo: hello")
+
+		proto, _ := recorder.last()
+		assert.Equal(t, CompressedProtocol(ChatProtocol, CompressionGzip), proto)
+	})
+
+	t.Run("BelowMinSize", func(t *testing.T) {
+		handler.SetCompression(map[string]CompressionConfig{
+			EchoProtocol: {Algorithm: CompressionZstd, MinSize: 1024},
+		})
+
+		resp, err := handler.SendEcho(ctx, server.ID(), "short")
+		require.NoError(t, err)
+		assert.Equal(t, "short", resp)
+
+		proto, _ := recorder.last()
+		assert.Equal(t, protocol.ID(EchoProtocol), proto)
+	})
+
+	t.Run("FallsBackToPlain", func(t *testing.T) {
+		// A peer that only speaks the plain protocol
+		legacy, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer legacy.Close()
+		legacy.SetStreamHandler(protocol.ID(EchoProtocol), func(s network.Stream) {
+			defer s.Close()
+			io.Copy(s, s)
+		})
+		require.NoError(t, connectNodes(ctx, client, legacy))
+
+		handler.SetCompression(map[string]CompressionConfig{
+			EchoProtocol: {Algorithm: CompressionSnappy},
+		})
+
+		resp, err := handler.SendEcho(ctx, legacy.ID(), payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, resp)
+
+		proto, _ := recorder.last()
+		assert.Equal(t, protocol.ID(EchoProtocol), proto)
+	})
+}
+
+func TestCompressionConfig(t *testing.T) {
+	cfg := testNodeConfig()
+	cfg.Compression = map[string]CompressionConfig{ChatProtocol: {Algorithm: CompressionZstd, MinSize: 256}}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Compression = map[string]CompressionConfig{ChatProtocol: {Algorithm: "brotli"}}
+	assert.ErrorContains(t, cfg.Validate(), "invalid compression algorithm")
+
+	cfg.Compression = map[string]CompressionConfig{PingProtocol: {Algorithm: CompressionGzip}}
+	assert.ErrorContains(t, cfg.Validate(), "compression is not supported")
+
+	cfg.Compression = map[string]CompressionConfig{EchoProtocol: {Algorithm: CompressionGzip, MinSize: -1}}
+	assert.ErrorContains(t, cfg.Validate(), "must not be negative")
+
+	// Throttling and QoS classes set for a protocol cover its compressed streams
+	assert.Equal(t, protocol.ID(EchoProtocol), baseProtocol(CompressedProtocol(EchoProtocol, CompressionZstd)))
+	assert.Equal(t, protocol.ID(EchoProtocol), baseProtocol(EchoProtocol))
+}
@@ -0,0 +1,1426 @@
+package libp2plearn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	golog "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+// Config represents the application configuration
+type Config struct {
+	// Network settings
+	ListenPort     int      `json:"listen_port"`
+	TCPPort        *int     `json:"tcp_port,omitempty"`
+	QUICPort       *int     `json:"quic_port,omitempty"`
+	WSPort         *int     `json:"ws_port,omitempty"`
+	WSSPort        *int     `json:"wss_port,omitempty"`
+	Interfaces     []string `json:"interfaces"`
+	BootstrapPeers []string `json:"bootstrap_peers"`
+	PinnedPeers    []string `json:"pinned_peers"`
+	BlockedPeers   []string `json:"blocked_peers"`
+	IdentityFile   string   `json:"identity_file"`
+	
+	// Addresses the node neither dials nor advertises, see AddressFilters
+	AddressFilters AddressFilters `json:"address_filters"`
+	
+	// Storage behind the peerstore, DHT and blob store: "memory" (nothing
+	// survives a restart), "fs" or "badger" under datastore_path, "s3", or
+	// "plugin" served by the plugin whose manifest is datastore_plugin
+	Datastore       string            `json:"datastore"`
+	DatastorePath   string            `json:"datastore_path"`
+	DatastoreS3     S3DatastoreConfig `json:"datastore_s3"`
+	DatastorePlugin string            `json:"datastore_plugin"`
+	
+	// Peerstore: how long addresses are kept per TTL class ("default",
+	// "temp", "recently_connected", "own_observed"; unset classes keep
+	// libp2p's), and a GC every peerstore_gc_interval (0 disables) dropping
+	// peers unseen for peerstore_max_age, then the longest unseen beyond
+	// peerstore_max_peers (0 for no limit)
+	PeerstoreAddrTTLs   map[string]Duration `json:"peerstore_addr_ttls"`
+	PeerstoreGCInterval Duration            `json:"peerstore_gc_interval"`
+	PeerstoreMaxAge     Duration            `json:"peerstore_max_age"`
+	PeerstoreMaxPeers   int                 `json:"peerstore_max_peers"`
+	
+	// Dialing
+	DialStrategy   string   `json:"dial_strategy"`
+	DialStagger    Duration `json:"dial_stagger"`
+	DialTimeout    Duration `json:"dial_timeout"`
+	ConnectTimeout Duration `json:"connect_timeout"`
+	
+	// Dial policy for the node's own dials: failed addresses are skipped for
+	// dial_backoff_base, doubling per failure up to dial_backoff_max, at most
+	// dial_max_concurrent dials run at once, and a peer gets
+	// dial_peer_attempts per dial_peer_window (0 disables each)
+	DialBackoffBase   Duration `json:"dial_backoff_base"`
+	DialBackoffMax    Duration `json:"dial_backoff_max"`
+	DialMaxConcurrent int      `json:"dial_max_concurrent"`
+	DialPeerAttempts  int      `json:"dial_peer_attempts"`
+	DialPeerWindow    Duration `json:"dial_peer_window"`
+	
+	// QUIC tuning for the connections this node dials (0 keeps libp2p's
+	// defaults): idle timeout, keepalive interval, and the maximum stream
+	// and connection flow control windows in bytes
+	QUICMaxIdleTimeout   Duration `json:"quic_max_idle_timeout"`
+	QUICKeepAlive        Duration `json:"quic_keepalive"`
+	QUICStreamWindow     int      `json:"quic_stream_window"`
+	QUICConnectionWindow int      `json:"quic_connection_window"`
+	
+	// TCP socket options for the connections this node dials: keepalive
+	// probe interval (0 keeps Go's), Nagle's algorithm off with tcp_nodelay,
+	// and the kernel receive and send buffer sizes in bytes (0 keeps the
+	// system's)
+	TCPKeepAliveInterval Duration `json:"tcp_keepalive_interval"`
+	TCPNoDelay           bool     `json:"tcp_nodelay"`
+	TCPReadBuffer        int      `json:"tcp_read_buffer"`
+	TCPWriteBuffer       int      `json:"tcp_write_buffer"`
+	
+	// Connection management
+	MaxConnections int `json:"max_connections"`
+	LowWater       int `json:"low_water"`
+	HighWater      int `json:"high_water"`
+	
+	// Peer diversity, kept when trimming and choosing peers to dial: peers
+	// kept connected per transport, and the largest share of peers from
+	// one public IPv4 /16 or IPv6 /32 (0 for no cap)
+	DiversityMinPerTransport map[string]int `json:"diversity_min_per_transport"`
+	DiversityMaxSubnetShare  float64        `json:"diversity_max_subnet_share"`
+	
+	// Eclipse and sybil detection, checking the DHT routing table every
+	// eclipse_check_interval. An alert is raised when one ASN or prefix
+	// holds more than eclipse_max_prefix_share of it, more than
+	// eclipse_max_turnover of it was replaced since the last check, or more
+	// than eclipse_max_agent_share of it runs the same agent version
+	// (0 disables a check). Routing tables under eclipse_min_peers aren't
+	// checked.
+	EnableEclipseDetection bool     `json:"enable_eclipse_detection"`
+	EclipseCheckInterval   Duration `json:"eclipse_check_interval"`
+	EclipseMaxPrefixShare  float64  `json:"eclipse_max_prefix_share"`
+	EclipseMaxTurnover     float64  `json:"eclipse_max_turnover"`
+	EclipseMaxAgentShare   float64  `json:"eclipse_max_agent_share"`
+	EclipseMinPeers        int      `json:"eclipse_min_peers"`
+	
+	// Score added to a peer's connection manager tag for each "ping", "chat"
+	// or "dht" activity, halving every activity_decay, so trimming keeps
+	// active peers (0 or empty to disable)
+	ActivityWeights map[string]int `json:"activity_weights"`
+	ActivityDecay   Duration       `json:"activity_decay"`
+	
+	// Standard libp2p ping of connected peers (0 disables). The measured
+	// round-trip times rank peers in service discovery and downloads.
+	PingInterval Duration `json:"ping_interval"`
+	
+	// How long advertised addresses must settle after changing before our
+	// DHT records are published again with them (0 disables)
+	RepublishDelay Duration `json:"republish_delay"`
+	
+	// DHT mode: "auto" (default) serves DHT queries while AutoNAT finds us
+	// publicly reachable, "auto-server" also serves while reachability is
+	// unknown, and "client" or "server" force the mode. Offline nodes
+	// always serve.
+	DHTMode string `json:"dht_mode"`
+	
+	// Offline-first operation for networks without internet access: public
+	// and DNS bootstrap peers are skipped, the DHT only talks to peers on
+	// private addresses, DHT writes wait until it has peers, and mDNS finds
+	// peers on the local network. enable_mdns runs mDNS without the rest,
+	// querying every mdns_interval.
+	Offline      bool     `json:"offline"`
+	EnableMDNS   bool     `json:"enable_mdns"`
+	MDNSInterval Duration `json:"mdns_interval"`
+	
+	// Connection prewarming at startup
+	EnablePrewarm      bool     `json:"enable_prewarm"`
+	PrewarmClosest     int      `json:"prewarm_closest"`
+	PrewarmConcurrency int      `json:"prewarm_concurrency"`
+	PrewarmInterval    Duration `json:"prewarm_interval"`
+	
+	// Reconnect to last-known peers after restart
+	EnableReconnect      bool     `json:"enable_reconnect"`
+	PeerHistoryFile      string   `json:"peer_history_file"`
+	ReconnectMax         int      `json:"reconnect_max"`
+	ReconnectConcurrency int      `json:"reconnect_concurrency"`
+	ReconnectMaxAge      Duration `json:"reconnect_max_age"`
+	
+	// Bandwidth throttling in bytes per second (0 for unlimited)
+	PeerBandwidth     BandwidthLimit            `json:"peer_bandwidth"`
+	ProtocolBandwidth map[string]BandwidthLimit `json:"protocol_bandwidth"`
+	
+	// Daily or monthly traffic caps over all streams and through our relay
+	// service, with usage kept across restarts and what happens once the
+	// total is used up
+	TrafficQuota      QuotaLimit `json:"traffic_quota"`
+	RelayQuota        QuotaLimit `json:"relay_quota"`
+	QuotaAction       string     `json:"quota_action"`
+	QuotaThrottleRate int        `json:"quota_throttle_rate"`
+	QuotaFile         string     `json:"quota_file"`
+	
+	// Inbound streams a single peer may have open at once on each protocol
+	// (0 for unlimited), with per-protocol overrides
+	MaxStreamsPerPeer    int            `json:"max_streams_per_peer"`
+	ProtocolStreamLimits map[string]int `json:"protocol_stream_limits"`
+	
+	// Stream compression for chat and echo, keyed by protocol ID
+	Compression map[string]CompressionConfig `json:"compression"`
+	
+	// End-to-end encrypted chat sessions, with ratchet state kept across restarts
+	EnableSecureChat bool   `json:"enable_secure_chat"`
+	SecureChatFile   string `json:"secure_chat_file"`
+	
+	// Messages queued for each peer in a chat room, and what to do with a
+	// peer whose queue is full: drop-oldest, drop-newest or disconnect
+	ChatQueueSize int    `json:"chat_queue_size"`
+	ChatSlowPeers string `json:"chat_slow_peers"`
+	
+	// Largest payload accepted by echo v2 in bytes (0 for unlimited)
+	EchoMaxSize int64 `json:"echo_max_size"`
+	
+	// Stream QoS priorities
+	EnableQoS       bool              `json:"enable_qos"`
+	QoSClasses      map[string]string `json:"qos_classes"`
+	QoSActiveWindow Duration          `json:"qos_active_window"`
+	QoSYieldRate    int               `json:"qos_yield_rate"`
+	
+	// Transport failover for important peers
+	FailoverPeers    []string `json:"failover_peers"`
+	FailoverAttempts int      `json:"failover_attempts"`
+	
+	// Multipath connections and stream scheduling
+	MultipathPeers      []string          `json:"multipath_peers"`
+	MultipathTransports []string          `json:"multipath_transports"`
+	MultipathPolicy     string            `json:"multipath_policy"`
+	MultipathPins       map[string]string `json:"multipath_pins"`
+	
+	// Relays to hold a reservation with, so unreachable peers can reach us
+	RelayReservations []string `json:"relay_reservations"`
+	
+	// Remote administration over libp2p
+	AdminPeers []string `json:"admin_peers"`
+	
+	// How long maintenance mode waits for connections to go idle before
+	// closing the rest
+	MaintenanceDrain Duration `json:"maintenance_drain"`
+	
+	// Token authorization for private protocols: the scope each protocol
+	// needs, the peers trusted to issue tokens and the tokens we present
+	AuthProtocols map[string]string `json:"auth_protocols"`
+	AuthIssuers   []string          `json:"auth_issuers"`
+	AuthTokens    []string          `json:"auth_tokens"`
+	
+	// Remote shell for authorized peers (off by default)
+	EnableShell  bool     `json:"enable_shell"`
+	ShellPeers   []string `json:"shell_peers"`
+	ShellCommand string   `json:"shell_command"`
+	
+	// Directories received from authorized peers, stored under transfer_dir.
+	// Large files are received through transfer_resume_dir (empty to disable)
+	// so interrupted transfers resume; unfinished ones are dropped after
+	// transfer_resume_max_age.
+	TransferDir          string   `json:"transfer_dir"`
+	TransferPeers        []string `json:"transfer_peers"`
+	TransferResumeDir    string   `json:"transfer_resume_dir"`
+	TransferResumeMaxAge Duration `json:"transfer_resume_max_age"`
+	
+	// Content-addressed blob store, kept in the node datastore with
+	// enable_blobs or in a filesystem datastore of its own in blob_dir.
+	// Content is chunked with a "fixed" or "rolling" chunker. Unpinned blocks
+	// are collected once the store holds more than blob_quota bytes (0 for
+	// no quota).
+	EnableBlobs bool   `json:"enable_blobs"`
+	BlobDir     string `json:"blob_dir"`
+	BlobChunker string `json:"blob_chunker"`
+	BlobQuota   int64  `json:"blob_quota"`
+	
+	// Append-only audit log of inbound streams and admin actions, optionally
+	// hash-chained, rotated once it grows past audit_max_size bytes
+	AuditLog     string `json:"audit_log"`
+	AuditChain   bool   `json:"audit_chain"`
+	AuditMaxSize int64  `json:"audit_max_size"`
+	
+	// Peer reputation: penalties per misbehavior kind, halving every
+	// reputation_decay, then gating with backoff and finally a ban
+	EnableReputation      bool               `json:"enable_reputation"`
+	ReputationFile        string             `json:"reputation_file"`
+	ReputationPenalties   map[string]float64 `json:"reputation_penalties"`
+	ReputationDecay       Duration           `json:"reputation_decay"`
+	ReputationGateScore   float64            `json:"reputation_gate_score"`
+	ReputationGateBackoff Duration           `json:"reputation_gate_backoff"`
+	ReputationGateMax     Duration           `json:"reputation_gate_max"`
+	ReputationBanAfter    int                `json:"reputation_ban_after"`
+	
+	// Outbox of chat messages and directories for peers that aren't
+	// reachable, kept in outbox_file and delivered when they connect, or for
+	// chat messages handed to a mailbox peer, until outbox_ttl passes
+	EnableOutbox bool     `json:"enable_outbox"`
+	OutboxFile   string   `json:"outbox_file"`
+	OutboxTTL    Duration `json:"outbox_ttl"`
+	
+	// Mailbox nodes keep sealed chat messages for peers while they are
+	// offline. We fetch ours from mailbox_peers and the outbox hands
+	// messages to them; enable_mailbox makes this node one, holding at most
+	// mailbox_max_per_peer messages for each recipient,
+	// mailbox_max_per_sender from each sender and mailbox_max_bytes in all.
+	MailboxPeers        []string `json:"mailbox_peers"`
+	EnableMailbox       bool     `json:"enable_mailbox"`
+	MailboxMaxPerPeer   int      `json:"mailbox_max_per_peer"`
+	MailboxMaxPerSender int      `json:"mailbox_max_per_sender"`
+	MailboxMaxBytes     int64    `json:"mailbox_max_bytes"`
+	
+	// Private chat rooms, whose signed member lists are kept in rooms_file
+	// and synced with the other members as they connect
+	EnableRooms bool   `json:"enable_rooms"`
+	RoomsFile   string `json:"rooms_file"`
+	
+	// TCP port forwarding between peers
+	Expose   []ExposeConfig  `json:"expose"`
+	Forwards []ForwardConfig `json:"forwards"`
+	
+	// Clock synchronization with selected peers
+	TimeSyncPeers    []string `json:"time_sync_peers"`
+	TimeSyncInterval Duration `json:"time_sync_interval"`
+	
+	// Shared key/value spaces
+	KVSpaces       []string `json:"kv_spaces"`
+	KVSyncInterval Duration `json:"kv_sync_interval"`
+	
+	// Replicated log shared with a fixed set of peers
+	RaftPeers             []string `json:"raft_peers"`
+	RaftElectionTimeout   Duration `json:"raft_election_timeout"`
+	RaftHeartbeatInterval Duration `json:"raft_heartbeat_interval"`
+	
+	// Application services advertised to peers and in the DHT
+	Services []string `json:"services"`
+	
+	// External plugins handling protocols or discovering peers, by manifest path
+	Plugins []string `json:"plugins"`
+	
+	// Protocols served by sandboxed WASM modules
+	WASMHandlers []WASMHandlerConfig `json:"wasm_handlers"`
+	
+	// Starlark scripts run on received messages and new peers
+	Scripts []ScriptConfig `json:"scripts"`
+	
+	// Features
+	EnableRelay       bool `json:"enable_relay"`
+	EnableHolePunch   bool `json:"enable_hole_punch"`
+	EnableAutoNAT     bool `json:"enable_autonat"`
+	EnableWebSocket   bool `json:"enable_websocket"`
+	
+	// Reservations the relay service holds at once (0 for libp2p's default
+	// of 128)
+	RelayMaxReservations int `json:"relay_max_reservations"`
+	
+	// Leave out the ping, chat and echo protocols, as on a bootstrap server
+	NoAppProtocols bool `json:"no_app_protocols"`
+	
+	// Advertise a public address peers observe us at only once
+	// observed_addr_min_observers distinct networks reported it and at least
+	// observed_addr_min_confidence of the observers on its transport agree
+	ObservedAddrMinObservers  int     `json:"observed_addr_min_observers"`
+	ObservedAddrMinConfidence float64 `json:"observed_addr_min_confidence"`
+	
+	// AutoNAT service: dial peers back so they learn whether they are
+	// reachable. At most autonat_service_rate dial-backs per
+	// autonat_service_interval (0 for no limit), autonat_service_peer_rate
+	// per peer, and autonat_service_peer_quota per peer per day (0 for no
+	// cap), only to peers in autonat_service_networks (CIDRs, empty for any
+	// public address)
+	EnableAutoNATService    bool     `json:"enable_autonat_service"`
+	AutoNATServiceRate      int      `json:"autonat_service_rate"`
+	AutoNATServicePeerRate  int      `json:"autonat_service_peer_rate"`
+	AutoNATServiceInterval  Duration `json:"autonat_service_interval"`
+	AutoNATServicePeerQuota int      `json:"autonat_service_peer_quota"`
+	AutoNATServiceNetworks  []string `json:"autonat_service_networks"`
+	
+	// HTTP gateway
+	EnableGateway  bool     `json:"enable_gateway"`
+	GatewayAddr    string   `json:"gateway_addr"`
+	GatewayOrigins []string `json:"gateway_origins"` // Browser origins allowed to call the gateway
+	
+	// Metrics exporters ("prometheus", "statsd", "otlp")
+	MetricsExporters []string          `json:"metrics_exporters"`
+	MetricsAddr      string            `json:"metrics_addr"`
+	MetricsInterval  Duration          `json:"metrics_interval"`
+	StatsDAddr       string            `json:"statsd_addr"`
+	OTLPEndpoint     string            `json:"otlp_endpoint"`
+	OTLPHeaders      map[string]string `json:"otlp_headers"`
+	
+	// Operator HTTP endpoints (/healthz, /readyz) and the readiness thresholds
+	AdminHTTPAddr        string `json:"admin_http_addr"`
+	ReadyMinPeers        int    `json:"ready_min_peers"`
+	ReadyMinRoutingPeers int    `json:"ready_min_routing_peers"`
+	
+	// HTTP over libp2p streams
+	EnableHTTPService bool `json:"enable_http_service"`
+	
+	// Outbound SOCKS5 proxy (e.g. Tor)
+	ProxyAddr      string `json:"proxy_addr"`
+	ProxyUsername  string `json:"proxy_username"`
+	ProxyPassword  string `json:"proxy_password"`
+	ProxyTCP       bool   `json:"proxy_tcp"`
+	ProxyWebSocket bool   `json:"proxy_websocket"`
+	ProxyStrict    bool   `json:"proxy_strict"`
+	
+	// Logging, with levels for libp2p subsystems such as swarm2 or dht
+	LogLevel      string            `json:"log_level"`
+	LogFile       string            `json:"log_file"`
+	LogSubsystems map[string]string `json:"log_subsystems"`
+	
+	// Remote log streaming
+	LogCollector    string   `json:"log_collector"`
+	LogForwardLevel string   `json:"log_forward_level"`
+	CollectLogsFrom []string `json:"collect_logs_from"`
+}
+
+// Duration is a time.Duration that is written to JSON as a string like "30s"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string such as "250ms" or "1m30s"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultConfig returns a configuration with sensible defaults
+func DefaultConfig() *Config {
+	return &Config{
+		ListenPort:     0, // Random port
+		BootstrapPeers: []string{
+			// Default IPFS bootstrap nodes
+			"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+			"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+		},
+		Datastore:         DatastoreMemory,
+		DatastorePath:     "data/datastore",
+		PeerstoreGCInterval: Duration(time.Hour),
+		PeerstoreMaxAge:     Duration(30 * 24 * time.Hour),
+		PeerstoreMaxPeers:   10000,
+		DialStrategy:      DialStrategySmart,
+		DialStagger:       Duration(250 * time.Millisecond),
+		DialTimeout:       Duration(10 * time.Second),
+		ConnectTimeout:    Duration(30 * time.Second),
+		DialBackoffBase:   Duration(5 * time.Second),
+		DialBackoffMax:    Duration(10 * time.Minute),
+		DialMaxConcurrent: 32,
+		DialPeerAttempts:  10,
+		DialPeerWindow:    Duration(time.Hour),
+		TCPNoDelay:        true,
+		MaxConnections:    1000,
+		EclipseCheckInterval:  Duration(time.Minute),
+		EclipseMaxPrefixShare: 0.5,
+		EclipseMaxTurnover:    0.5,
+		EclipseMaxAgentShare:  0.8,
+		EclipseMinPeers:       10,
+		PingInterval:      Duration(time.Minute),
+		RepublishDelay:    Duration(10 * time.Second),
+		DHTMode:           discovery.DHTModeAuto,
+		MDNSInterval:      Duration(30 * time.Second),
+		PrewarmClosest:     8,
+		PrewarmConcurrency: 4,
+		PrewarmInterval:    Duration(100 * time.Millisecond),
+		PeerHistoryFile:      "data/peers.json",
+		ReconnectMax:         50,
+		ReconnectConcurrency: 8,
+		ReconnectMaxAge:      Duration(7 * 24 * time.Hour),
+		MaxStreamsPerPeer: 32,
+		TrafficQuota:      QuotaLimit{Period: QuotaPeriodMonth},
+		RelayQuota:        QuotaLimit{Period: QuotaPeriodMonth},
+		QuotaAction:       QuotaActionRefuse,
+		QuotaThrottleRate: 16 * 1024,
+		QuotaFile:         "data/quota.json",
+		QoSClasses:        defaultQoSClasses(),
+		QoSActiveWindow:   Duration(500 * time.Millisecond),
+		QoSYieldRate:      64 * 1024,
+		FailoverAttempts:  5,
+		MaintenanceDrain:  Duration(time.Minute),
+		ChatQueueSize:     64,
+		ChatSlowPeers:     SlowPeerDropOldest,
+		MultipathTransports: []string{"quic", "tcp"},
+		MultipathPolicy:     SchedulePolicyRoundRobin,
+		ShellCommand:        "/bin/sh",
+		TransferDir:          "data/received",
+		TransferResumeDir:    "data/partial",
+		TransferResumeMaxAge: Duration(7 * 24 * time.Hour),
+		BlobChunker:          BlobChunkerRolling,
+		TimeSyncInterval:    Duration(time.Minute),
+		KVSyncInterval:      Duration(30 * time.Second),
+		RaftElectionTimeout:   Duration(time.Second),
+		RaftHeartbeatInterval: Duration(100 * time.Millisecond),
+		EchoMaxSize:         64 << 20,
+		SecureChatFile:      "data/secure-chat.json",
+		AuditChain:          true,
+		AuditMaxSize:        100 << 20,
+		ReputationFile:        "data/reputation.json",
+		ReputationPenalties:   defaultReputationPenalties(),
+		ReputationDecay:       Duration(time.Hour),
+		ReputationGateScore:   100,
+		ReputationGateBackoff: Duration(time.Minute),
+		ReputationGateMax:     Duration(24 * time.Hour),
+		ReputationBanAfter:    5,
+		OutboxFile:            "data/outbox.json",
+		OutboxTTL:             Duration(24 * time.Hour),
+		MailboxMaxPerPeer:     100,
+		MailboxMaxPerSender:   500,
+		MailboxMaxBytes:       64 << 20,
+		RoomsFile:             DefaultRoomsFile,
+		LowWater:         50,
+		HighWater:        200,
+		ActivityWeights:  defaultActivityWeights(),
+		ActivityDecay:    Duration(10 * time.Minute),
+		EnableRelay:       false,
+		EnableHolePunch:   true,
+		EnableAutoNAT:     true,
+		ObservedAddrMinObservers:  2,
+		ObservedAddrMinConfidence: 0.5,
+		AutoNATServiceRate:      30,
+		AutoNATServicePeerRate:  3,
+		AutoNATServiceInterval:  Duration(time.Minute),
+		AutoNATServicePeerQuota: 50,
+		EnableWebSocket:   true,
+		EnableGateway:     false,
+		GatewayAddr:       "127.0.0.1:8081",
+		MetricsAddr:       "127.0.0.1:9464",
+		MetricsInterval:   Duration(15 * time.Second),
+		StatsDAddr:        "127.0.0.1:8125",
+		OTLPEndpoint:      "http://127.0.0.1:4318/v1/metrics",
+		ReadyMinPeers:        1,
+		ReadyMinRoutingPeers: 1,
+		EnableHTTPService: false,
+		ProxyAddr:         "",
+		ProxyTCP:          true,
+		ProxyWebSocket:    true,
+		ProxyStrict:       false,
+		LogLevel:         "info",
+		LogFile:          "",
+		LogForwardLevel:  "info",
+	}
+}
+
+// BootstrapServerConfig returns the defaults of a bootstrap server, a seed
+// that other nodes join the network through: it always serves the DHT,
+// relays and dials peers back for AutoNAT, keeps many more connections, and
+// serves none of the application protocols
+func BootstrapServerConfig() *Config {
+	c := DefaultConfig()
+	c.DHTMode = discovery.DHTModeServer
+	c.EnableAutoNATService = true
+	c.RelayMaxReservations = 1024
+	c.MaxConnections = 4000
+	c.LowWater = 1000
+	c.HighWater = 2000
+	c.NoAppProtocols = true
+	return c
+}
+
+// LoadConfig loads configuration from a file
+func LoadConfig(filepath string) (*Config, error) {
+	return loadConfig(DefaultConfig(), filepath)
+}
+
+// LoadBootstrapServerConfig loads configuration from a file over the
+// defaults of a bootstrap server
+func LoadBootstrapServerConfig(filepath string) (*Config, error) {
+	return loadConfig(BootstrapServerConfig(), filepath)
+}
+
+// loadConfig decodes a configuration file over config
+func loadConfig(config *Config, filepath string) (*Config, error) {
+	if filepath == "" {
+		return config, nil
+	}
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.WithField("file", filepath).Info("Config file not found, using defaults")
+			return config, nil
+		}
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	logrus.WithField("file", filepath).Info("Configuration loaded")
+	return config, nil
+}
+
+// SaveConfig saves configuration to a file
+func (c *Config) SaveConfig(path string) error {
+	// Create directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	logrus.WithField("file", path).Info("Configuration saved")
+	return nil
+}
+
+// Validate checks if the configuration is valid
+func (c *Config) Validate() error {
+	if c.MaxConnections <= 0 {
+		return fmt.Errorf("max_connections must be positive")
+	}
+
+	if c.LowWater <= 0 || c.HighWater <= 0 {
+		return fmt.Errorf("low_water and high_water must be positive")
+	}
+
+	if c.LowWater >= c.HighWater {
+		return fmt.Errorf("low_water must be less than high_water")
+	}
+
+	for transport, min := range c.DiversityMinPerTransport {
+		if !slices.Contains(transportNames, transport) {
+			return fmt.Errorf("invalid diversity_min_per_transport transport %q, expected one of %s", transport, strings.Join(transportNames, ", "))
+		}
+		if min < 0 {
+			return fmt.Errorf("diversity_min_per_transport for %s must not be negative", transport)
+		}
+	}
+	if c.DiversityMaxSubnetShare < 0 || c.DiversityMaxSubnetShare > 1 {
+		return fmt.Errorf("diversity_max_subnet_share must be between 0 and 1")
+	}
+
+	if c.EnableEclipseDetection {
+		if c.EclipseCheckInterval <= 0 {
+			return fmt.Errorf("eclipse_check_interval must be positive")
+		}
+		for _, share := range []float64{c.EclipseMaxPrefixShare, c.EclipseMaxTurnover, c.EclipseMaxAgentShare} {
+			if share < 0 || share > 1 {
+				return fmt.Errorf("eclipse_max_prefix_share, eclipse_max_turnover and eclipse_max_agent_share must be between 0 and 1")
+			}
+		}
+		if c.EclipseMinPeers < 0 {
+			return fmt.Errorf("eclipse_min_peers must not be negative")
+		}
+	}
+
+	for kind, weight := range c.ActivityWeights {
+		switch kind {
+		case ActivityPing, ActivityChat, ActivityDHT:
+		default:
+			return fmt.Errorf("invalid activity kind %q: must be %q, %q or %q", kind, ActivityPing, ActivityChat, ActivityDHT)
+		}
+		if weight < 0 {
+			return fmt.Errorf("activity weight for %s must not be negative", kind)
+		}
+	}
+	if c.ActivityTagged() && time.Duration(c.ActivityDecay) < activityResolution {
+		return fmt.Errorf("activity_decay must be at least %s", activityResolution)
+	}
+
+	if c.QUICMaxIdleTimeout < 0 || c.QUICKeepAlive < 0 {
+		return fmt.Errorf("quic_max_idle_timeout and quic_keepalive must not be negative")
+	}
+	if c.QUICMaxIdleTimeout > 0 && c.QUICKeepAlive >= c.QUICMaxIdleTimeout {
+		return fmt.Errorf("quic_keepalive must be shorter than quic_max_idle_timeout")
+	}
+	if c.QUICStreamWindow < 0 || c.QUICConnectionWindow < 0 {
+		return fmt.Errorf("quic_stream_window and quic_connection_window must not be negative")
+	}
+	if c.QUICStreamWindow > 0 && c.QUICConnectionWindow > 0 && c.QUICStreamWindow > c.QUICConnectionWindow {
+		return fmt.Errorf("quic_stream_window must not exceed quic_connection_window")
+	}
+	if c.TCPKeepAliveInterval < 0 {
+		return fmt.Errorf("tcp_keepalive_interval must not be negative")
+	}
+	if c.TCPReadBuffer < 0 || c.TCPWriteBuffer < 0 {
+		return fmt.Errorf("tcp_read_buffer and tcp_write_buffer must not be negative")
+	}
+
+	if c.PingInterval < 0 {
+		return fmt.Errorf("ping_interval must not be negative")
+	}
+	if c.RepublishDelay < 0 {
+		return fmt.Errorf("republish_delay must not be negative")
+	}
+	if c.MaintenanceDrain < 0 {
+		return fmt.Errorf("maintenance_drain must not be negative")
+	}
+	if c.MDNSEnabled() && c.MDNSInterval <= 0 {
+		return fmt.Errorf("mdns_interval must be positive")
+	}
+
+	if c.ListenPort < 0 || c.ListenPort > 65535 {
+		return fmt.Errorf("listen_port must be between 0 and 65535")
+	}
+
+	transportPorts := map[string]*int{
+		"tcp_port":  c.TCPPort,
+		"quic_port": c.QUICPort,
+		"ws_port":   c.WSPort,
+		"wss_port":  c.WSSPort,
+	}
+	for name, port := range transportPorts {
+		if port != nil && (*port < 0 || *port > 65535) {
+			return fmt.Errorf("%s must be between 0 and 65535", name)
+		}
+	}
+
+	switch c.Datastore {
+	case "", DatastoreMemory, DatastoreFS, DatastoreBadger:
+	case DatastoreS3:
+		if c.DatastoreS3.Bucket == "" {
+			return fmt.Errorf("datastore_s3.bucket is required when datastore is %q", DatastoreS3)
+		}
+	case DatastorePlugin:
+		if c.DatastorePlugin == "" {
+			return fmt.Errorf("datastore_plugin is required when datastore is %q", DatastorePlugin)
+		}
+	default:
+		return fmt.Errorf("datastore must be %q, %q, %q, %q or %q", DatastoreMemory, DatastoreFS, DatastoreBadger, DatastoreS3, DatastorePlugin)
+	}
+	if (c.Datastore == DatastoreFS || c.Datastore == DatastoreBadger) && c.DatastorePath == "" {
+		return fmt.Errorf("datastore_path is required when datastore is %q", c.Datastore)
+	}
+
+	for class, ttl := range c.PeerstoreAddrTTLs {
+		if _, ok := addrTTLs()[class]; !ok {
+			return fmt.Errorf("invalid address TTL class %q: must be %q, %q, %q or %q",
+				class, AddrTTLDefault, AddrTTLTemp, AddrTTLRecentlyConnected, AddrTTLOwnObserved)
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("address TTL for %s must be positive", class)
+		}
+	}
+	if c.PeerstoreGCInterval < 0 || c.PeerstoreMaxAge < 0 {
+		return fmt.Errorf("peerstore_gc_interval and peerstore_max_age must not be negative")
+	}
+	if c.PeerstoreMaxPeers < 0 {
+		return fmt.Errorf("peerstore_max_peers must not be negative")
+	}
+
+	if c.ObservedAddrMinObservers < 0 {
+		return fmt.Errorf("observed_addr_min_observers must not be negative")
+	}
+	if c.ObservedAddrMinConfidence < 0 || c.ObservedAddrMinConfidence > 1 {
+		return fmt.Errorf("observed_addr_min_confidence must be between 0 and 1")
+	}
+
+	if c.EnableAutoNATService {
+		if c.AutoNATServiceRate < 0 || c.AutoNATServicePeerQuota < 0 {
+			return fmt.Errorf("autonat_service_rate and autonat_service_peer_quota must not be negative")
+		}
+		if c.AutoNATServicePeerRate <= 0 {
+			return fmt.Errorf("autonat_service_peer_rate must be positive")
+		}
+		if c.AutoNATServiceInterval <= 0 {
+			return fmt.Errorf("autonat_service_interval must be positive")
+		}
+		for _, cidr := range c.AutoNATServiceNetworks {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid autonat_service_networks entry %q: %w", cidr, err)
+			}
+		}
+		if c.ProxyStrict {
+			return fmt.Errorf("enable_autonat_service can't be used with proxy_strict: dial-backs can't go through the proxy")
+		}
+	}
+
+	if c.EnableGateway && c.GatewayAddr == "" {
+		return fmt.Errorf("gateway_addr is required when the gateway is enabled")
+	}
+
+	if c.ReadyMinPeers < 0 || c.ReadyMinRoutingPeers < 0 {
+		return fmt.Errorf("ready_min_peers and ready_min_routing_peers must not be negative")
+	}
+
+	for _, name := range c.MetricsExporters {
+		switch name {
+		case MetricsPrometheus:
+			if c.MetricsAddr == "" {
+				return fmt.Errorf("metrics_addr is required when the %s exporter is enabled", name)
+			}
+		case MetricsStatsD:
+			if c.StatsDAddr == "" {
+				return fmt.Errorf("statsd_addr is required when the %s exporter is enabled", name)
+			}
+		case MetricsOTLP:
+			if c.OTLPEndpoint == "" {
+				return fmt.Errorf("otlp_endpoint is required when the %s exporter is enabled", name)
+			}
+		default:
+			return fmt.Errorf("metrics_exporters must be %q, %q or %q, got %q", MetricsPrometheus, MetricsStatsD, MetricsOTLP, name)
+		}
+	}
+	if len(c.MetricsExporters) > 0 && c.MetricsInterval <= 0 {
+		return fmt.Errorf("metrics_interval must be positive")
+	}
+
+	if c.ProxyStrict {
+		if c.ProxyAddr == "" {
+			return fmt.Errorf("proxy_strict requires proxy_addr")
+		}
+		if !c.ProxyTCP {
+			return fmt.Errorf("proxy_strict requires proxy_tcp")
+		}
+		if c.EnableWebSocket && !c.ProxyWebSocket {
+			return fmt.Errorf("proxy_strict requires proxy_websocket when websocket is enabled")
+		}
+	}
+
+	for _, name := range c.Interfaces {
+		if name == "" {
+			return fmt.Errorf("interfaces must not contain empty names")
+		}
+	}
+
+	if _, err := parsePinnedPeers(c.PinnedPeers); err != nil {
+		return fmt.Errorf("invalid pinned_peers: %w", err)
+	}
+
+	if c.EnablePrewarm {
+		if c.PrewarmClosest < 0 {
+			return fmt.Errorf("prewarm_closest must not be negative")
+		}
+		if c.PrewarmConcurrency <= 0 {
+			return fmt.Errorf("prewarm_concurrency must be positive")
+		}
+		if c.PrewarmInterval < 0 {
+			return fmt.Errorf("prewarm_interval must not be negative")
+		}
+	}
+
+	for _, cidrs := range [][]string{c.AddressFilters.Deny, c.AddressFilters.Allow} {
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid address_filters entry %q: %w", cidr, err)
+			}
+		}
+	}
+
+	for _, id := range c.BlockedPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid blocked peer %q: %w", id, err)
+		}
+	}
+
+	if c.EnableReconnect {
+		if c.PeerHistoryFile == "" {
+			return fmt.Errorf("peer_history_file is required when reconnect is enabled")
+		}
+		if c.ReconnectMax <= 0 || c.ReconnectConcurrency <= 0 {
+			return fmt.Errorf("reconnect_max and reconnect_concurrency must be positive")
+		}
+		if c.ReconnectMaxAge <= 0 {
+			return fmt.Errorf("reconnect_max_age must be positive")
+		}
+	}
+
+	if c.PeerBandwidth.Upload < 0 || c.PeerBandwidth.Download < 0 {
+		return fmt.Errorf("peer_bandwidth limits must not be negative")
+	}
+	for proto, limit := range c.ProtocolBandwidth {
+		if limit.Upload < 0 || limit.Download < 0 {
+			return fmt.Errorf("protocol_bandwidth limits for %s must not be negative", proto)
+		}
+	}
+
+	for name, limit := range map[string]QuotaLimit{"traffic_quota": c.TrafficQuota, "relay_quota": c.RelayQuota} {
+		if limit.Bytes < 0 {
+			return fmt.Errorf("%s bytes must not be negative", name)
+		}
+		switch limit.Period {
+		case QuotaPeriodDay, QuotaPeriodMonth:
+		default:
+			return fmt.Errorf("invalid %s period: %s", name, limit.Period)
+		}
+	}
+	if c.QuotaLimited() {
+		switch c.QuotaAction {
+		case QuotaActionRefuse, QuotaActionThrottle:
+		default:
+			return fmt.Errorf("invalid quota_action: %s", c.QuotaAction)
+		}
+		if c.QuotaAction == QuotaActionThrottle && c.QuotaThrottleRate <= 0 {
+			return fmt.Errorf("quota_throttle_rate must be positive")
+		}
+		if c.QuotaFile == "" {
+			return fmt.Errorf("quota_file is required when a traffic quota is set")
+		}
+	}
+
+	if c.MaxStreamsPerPeer < 0 {
+		return fmt.Errorf("max_streams_per_peer must not be negative")
+	}
+	for proto, limit := range c.ProtocolStreamLimits {
+		if limit < 0 {
+			return fmt.Errorf("protocol_stream_limits for %s must not be negative", proto)
+		}
+	}
+
+	for proto, comp := range c.Compression {
+		if !compressibleProtocols[proto] {
+			return fmt.Errorf("compression is not supported for %s", proto)
+		}
+		if !validCompression(comp.Algorithm) {
+			return fmt.Errorf("invalid compression algorithm for %s: %q", proto, comp.Algorithm)
+		}
+		if comp.MinSize < 0 {
+			return fmt.Errorf("compression min_size for %s must not be negative", proto)
+		}
+	}
+
+	if c.EchoMaxSize < 0 {
+		return fmt.Errorf("echo_max_size must not be negative")
+	}
+
+	if c.EnableSecureChat && c.SecureChatFile == "" {
+		return fmt.Errorf("secure_chat_file is required when secure chat is enabled")
+	}
+	if c.ChatQueueSize <= 0 {
+		return fmt.Errorf("chat_queue_size must be positive")
+	}
+	if c.RelayMaxReservations < 0 {
+		return fmt.Errorf("relay_max_reservations must not be negative")
+	}
+
+	switch c.DHTMode {
+	case discovery.DHTModeAuto, discovery.DHTModeAutoServer, discovery.DHTModeClient, discovery.DHTModeServer:
+	default:
+		return fmt.Errorf("invalid dht_mode: %s", c.DHTMode)
+	}
+
+	switch c.ChatSlowPeers {
+	case SlowPeerDropOldest, SlowPeerDropNewest, SlowPeerDisconnect:
+	default:
+		return fmt.Errorf("invalid chat_slow_peers: %s", c.ChatSlowPeers)
+	}
+
+	if c.EnableQoS {
+		for proto, class := range c.QoSClasses {
+			if _, err := ParsePriority(class); err != nil {
+				return fmt.Errorf("invalid qos_classes entry for %s: %w", proto, err)
+			}
+		}
+		if c.QoSActiveWindow <= 0 || c.QoSYieldRate <= 0 {
+			return fmt.Errorf("qos_active_window and qos_yield_rate must be positive")
+		}
+	}
+
+	for _, id := range c.FailoverPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid failover peer %q: %w", id, err)
+		}
+	}
+
+	if len(c.FailoverPeers) > 0 && c.FailoverAttempts <= 0 {
+		return fmt.Errorf("failover_attempts must be positive")
+	}
+
+	if _, err := parsePinnedPeers(c.RelayReservations); err != nil {
+		return fmt.Errorf("invalid relay_reservations: %w", err)
+	}
+
+	for _, id := range c.MultipathPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid multipath peer %q: %w", id, err)
+		}
+	}
+
+	for _, id := range c.AdminPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid admin peer %q: %w", id, err)
+		}
+	}
+
+	for proto := range c.AuthProtocols {
+		if !strings.HasPrefix(proto, "/") {
+			return fmt.Errorf("invalid auth protocol %q: must start with /", proto)
+		}
+	}
+	for _, id := range c.AuthIssuers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid auth issuer %q: %w", id, err)
+		}
+	}
+	for i, token := range c.AuthTokens {
+		if _, err := ParseToken(token); err != nil {
+			return fmt.Errorf("invalid auth token %d: %w", i+1, err)
+		}
+	}
+
+	if c.EnableShell {
+		if len(c.ShellPeers) == 0 {
+			return fmt.Errorf("shell_peers is required when the shell is enabled")
+		}
+		if c.ShellCommand == "" {
+			return fmt.Errorf("shell_command is required when the shell is enabled")
+		}
+	}
+	for _, id := range c.ShellPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid shell peer %q: %w", id, err)
+		}
+	}
+
+	if len(c.TransferPeers) > 0 && c.TransferDir == "" {
+		return fmt.Errorf("transfer_dir is required when transfer_peers is set")
+	}
+	if c.TransferResumeMaxAge < 0 {
+		return fmt.Errorf("transfer_resume_max_age must not be negative")
+	}
+	for _, id := range c.TransferPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid transfer peer %q: %w", id, err)
+		}
+	}
+
+	if c.BlobChunker != BlobChunkerFixed && c.BlobChunker != BlobChunkerRolling {
+		return fmt.Errorf("blob_chunker must be %q or %q", BlobChunkerFixed, BlobChunkerRolling)
+	}
+	if c.BlobQuota < 0 {
+		return fmt.Errorf("blob_quota must not be negative")
+	}
+
+	if c.AuditMaxSize < 0 {
+		return fmt.Errorf("audit_max_size must not be negative")
+	}
+
+	if c.EnableReputation {
+		if c.ReputationFile == "" {
+			return fmt.Errorf("reputation_file is required when reputation is enabled")
+		}
+		if c.ReputationGateScore <= 0 || c.ReputationGateBackoff <= 0 {
+			return fmt.Errorf("reputation_gate_score and reputation_gate_backoff must be positive")
+		}
+		if c.ReputationGateMax < c.ReputationGateBackoff {
+			return fmt.Errorf("reputation_gate_max must be at least reputation_gate_backoff")
+		}
+		if c.ReputationDecay < 0 || c.ReputationBanAfter < 0 {
+			return fmt.Errorf("reputation_decay and reputation_ban_after must not be negative")
+		}
+		for kind, penalty := range c.ReputationPenalties {
+			if penalty < 0 {
+				return fmt.Errorf("invalid reputation penalty for %s: must not be negative", kind)
+			}
+		}
+	}
+
+	if c.EnableOutbox {
+		if c.OutboxFile == "" {
+			return fmt.Errorf("outbox_file is required when the outbox is enabled")
+		}
+		if c.OutboxTTL <= 0 {
+			return fmt.Errorf("outbox_ttl must be positive")
+		}
+	}
+
+	for _, id := range c.MailboxPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid mailbox peer %q: %w", id, err)
+		}
+	}
+
+	if c.EnableMailbox {
+		if c.MailboxMaxPerPeer <= 0 {
+			return fmt.Errorf("mailbox_max_per_peer must be positive")
+		}
+		if c.MailboxMaxPerSender <= 0 {
+			return fmt.Errorf("mailbox_max_per_sender must be positive")
+		}
+		if c.MailboxMaxBytes <= 0 {
+			return fmt.Errorf("mailbox_max_bytes must be positive")
+		}
+	}
+
+	if c.EnableRooms && c.RoomsFile == "" {
+		return fmt.Errorf("rooms_file is required when rooms are enabled")
+	}
+
+	for _, e := range c.Expose {
+		if !strings.HasPrefix(e.Protocol, "/") {
+			return fmt.Errorf("invalid expose protocol %q: must start with /", e.Protocol)
+		}
+		if _, _, err := net.SplitHostPort(e.Target); err != nil {
+			return fmt.Errorf("invalid expose target for %s: %w", e.Protocol, err)
+		}
+		if len(e.Allow) == 0 {
+			return fmt.Errorf("expose entry for %s requires allow (use \"%s\" for any peer)", e.Protocol, ExposeAllowAny)
+		}
+		for _, id := range e.Allow {
+			if id == ExposeAllowAny {
+				continue
+			}
+			if _, err := peer.Decode(id); err != nil {
+				return fmt.Errorf("invalid allowed peer %q for %s: %w", id, e.Protocol, err)
+			}
+		}
+	}
+	for _, f := range c.Forwards {
+		if _, _, err := net.SplitHostPort(f.Listen); err != nil {
+			return fmt.Errorf("invalid forward listen address: %w", err)
+		}
+		if _, _, err := ParseForwardTarget(f.Target); err != nil {
+			return fmt.Errorf("invalid forward target %q: %w", f.Target, err)
+		}
+	}
+
+	for _, id := range c.TimeSyncPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid time sync peer %q: %w", id, err)
+		}
+	}
+
+	if len(c.TimeSyncPeers) > 0 && c.TimeSyncInterval <= 0 {
+		return fmt.Errorf("time_sync_interval must be positive")
+	}
+
+	for _, name := range c.KVSpaces {
+		if name == "" {
+			return fmt.Errorf("kv_spaces must not contain empty names")
+		}
+	}
+
+	if c.KVSyncInterval <= 0 {
+		return fmt.Errorf("kv_sync_interval must be positive")
+	}
+
+	for _, id := range c.RaftPeers {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid raft peer %q: %w", id, err)
+		}
+	}
+
+	if len(c.RaftPeers) > 0 {
+		if c.RaftHeartbeatInterval <= 0 {
+			return fmt.Errorf("raft_heartbeat_interval must be positive")
+		}
+		if c.RaftElectionTimeout <= c.RaftHeartbeatInterval {
+			return fmt.Errorf("raft_election_timeout must be longer than raft_heartbeat_interval")
+		}
+	}
+
+	for _, name := range c.Services {
+		if name == "" {
+			return fmt.Errorf("services must not contain empty names")
+		}
+	}
+
+	for _, path := range c.Plugins {
+		if path == "" {
+			return fmt.Errorf("plugins must not contain empty paths")
+		}
+	}
+
+	for _, w := range c.WASMHandlers {
+		if !strings.HasPrefix(w.Protocol, "/") {
+			return fmt.Errorf("invalid wasm handler protocol %q: must start with /", w.Protocol)
+		}
+		if w.Module == "" {
+			return fmt.Errorf("wasm handler for %s requires module", w.Protocol)
+		}
+		if w.Timeout < 0 || w.MaxRequestSize < 0 {
+			return fmt.Errorf("wasm handler timeout and max_request_size must not be negative")
+		}
+	}
+
+	for _, s := range c.Scripts {
+		if s.File == "" {
+			return fmt.Errorf("scripts require file")
+		}
+		for _, proto := range s.Protocols {
+			if proto != PingProtocol && proto != ChatProtocol {
+				return fmt.Errorf("invalid script protocol %q: scripts run on %s and %s", proto, PingProtocol, ChatProtocol)
+			}
+		}
+	}
+
+	switch c.MultipathPolicy {
+	case SchedulePolicyRoundRobin, SchedulePolicyLatency, SchedulePolicyPinned:
+	default:
+		return fmt.Errorf("invalid multipath_policy: %s", c.MultipathPolicy)
+	}
+
+	validTransports := map[string]bool{"tcp": true, "quic": true, "websocket": true, "webtransport": true}
+	for _, tpt := range c.MultipathTransports {
+		if !validTransports[tpt] {
+			return fmt.Errorf("invalid multipath transport: %s", tpt)
+		}
+	}
+	for proto, tpt := range c.MultipathPins {
+		if !validTransports[tpt] {
+			return fmt.Errorf("invalid transport %s pinned for %s", tpt, proto)
+		}
+	}
+
+	switch c.DialStrategy {
+	case DialStrategySmart, DialStrategyStaggered, DialStrategyParallel, DialStrategyMeasured:
+	default:
+		return fmt.Errorf("invalid dial_strategy: %s", c.DialStrategy)
+	}
+
+	if c.DialStagger < 0 {
+		return fmt.Errorf("dial_stagger must not be negative")
+	}
+
+	if c.DialTimeout <= 0 || c.ConnectTimeout <= 0 {
+		return fmt.Errorf("dial_timeout and connect_timeout must be positive")
+	}
+
+	if c.DialBackoffBase < 0 || c.DialBackoffMax < 0 || c.DialPeerWindow < 0 {
+		return fmt.Errorf("dial_backoff_base, dial_backoff_max and dial_peer_window must not be negative")
+	}
+	if c.DialBackoffBase > 0 && c.DialBackoffMax < c.DialBackoffBase {
+		return fmt.Errorf("dial_backoff_max must be at least dial_backoff_base")
+	}
+	if c.DialMaxConcurrent < 0 || c.DialPeerAttempts < 0 {
+		return fmt.Errorf("dial_max_concurrent and dial_peer_attempts must not be negative")
+	}
+	if c.DialPeerAttempts > 0 && c.DialPeerWindow <= 0 {
+		return fmt.Errorf("dial_peer_attempts requires dial_peer_window")
+	}
+
+	validLogLevels := map[string]bool{
+		"trace": true, "debug": true, "info": true,
+		"warn": true, "error": true, "fatal": true, "panic": true,
+	}
+	if !validLogLevels[c.LogLevel] {
+		return fmt.Errorf("invalid log_level: %s", c.LogLevel)
+	}
+	subsystems := golog.GetSubsystems()
+	for subsystem, level := range c.LogSubsystems {
+		if subsystem != "*" && !slices.Contains(subsystems, subsystem) {
+			return fmt.Errorf("unknown libp2p subsystem in log_subsystems: %s", subsystem)
+		}
+		if _, err := golog.LevelFromString(level); err != nil {
+			return fmt.Errorf("invalid log_subsystems level for %s: %s", subsystem, level)
+		}
+	}
+
+	if c.LogCollector != "" {
+		if _, err := peer.AddrInfoFromString(c.LogCollector); err != nil {
+			return fmt.Errorf("invalid log_collector: %w", err)
+		}
+		if !validLogLevels[c.LogForwardLevel] {
+			return fmt.Errorf("invalid log_forward_level: %s", c.LogForwardLevel)
+		}
+	}
+	for _, id := range c.CollectLogsFrom {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("invalid collect_logs_from peer %q: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// BandwidthLimited reports whether any bandwidth cap is configured
+func (c *Config) BandwidthLimited() bool {
+	return c.PeerBandwidth.Upload > 0 || c.PeerBandwidth.Download > 0 || len(c.ProtocolBandwidth) > 0
+}
+
+// DiversityLimited reports whether any peer diversity requirement is configured
+func (c *Config) DiversityLimited() bool {
+	return len(c.DiversityMinPerTransport) > 0 || c.DiversityMaxSubnetShare > 0
+}
+
+// DiversityPolicy returns the peer diversity requirements
+func (c *Config) DiversityPolicy() DiversityPolicy {
+	return DiversityPolicy{
+		MinPerTransport: c.DiversityMinPerTransport,
+		MaxSubnetShare:  c.DiversityMaxSubnetShare,
+	}
+}
+
+// MailboxPeerIDs returns the IDs of the mailbox peers
+func (c *Config) MailboxPeerIDs() []peer.ID {
+	ids := make([]peer.ID, 0, len(c.MailboxPeers))
+	for _, id := range c.MailboxPeers {
+		p, _ := peer.Decode(id) // validated with the config
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// EclipseThresholds returns the thresholds of eclipse and sybil detection
+func (c *Config) EclipseThresholds() EclipseThresholds {
+	return EclipseThresholds{
+		MaxPrefixShare: c.EclipseMaxPrefixShare,
+		MaxTurnover:    c.EclipseMaxTurnover,
+		MaxAgentShare:  c.EclipseMaxAgentShare,
+		MinPeers:       c.EclipseMinPeers,
+	}
+}
+
+// QuotaLimited reports whether any traffic quota is configured
+func (c *Config) QuotaLimited() bool {
+	return c.TrafficQuota.Bytes > 0 || c.RelayQuota.Bytes > 0
+}
+
+// ReputationPolicy returns the escalation policy of the peer reputation
+func (c *Config) ReputationPolicy() ReputationPolicy {
+	penalties := make(map[Misbehavior]float64, len(c.ReputationPenalties))
+	for kind, penalty := range c.ReputationPenalties {
+		penalties[Misbehavior(kind)] = penalty
+	}
+	return ReputationPolicy{
+		Penalties:   penalties,
+		Decay:       time.Duration(c.ReputationDecay),
+		GateScore:   c.ReputationGateScore,
+		GateBackoff: time.Duration(c.ReputationGateBackoff),
+		GateMax:     time.Duration(c.ReputationGateMax),
+		BanAfter:    c.ReputationBanAfter,
+	}
+}
+
+// MailboxLimits returns the limits of the mailbox this node keeps
+func (c *Config) MailboxLimits() MailboxLimits {
+	return MailboxLimits{
+		MaxPerPeer:   c.MailboxMaxPerPeer,
+		MaxPerSender: c.MailboxMaxPerSender,
+		MaxBytes:     c.MailboxMaxBytes,
+	}
+}
+
+// AutoNATPolicy returns the limits of the AutoNAT service
+func (c *Config) AutoNATPolicy() AutoNATPolicy {
+	return AutoNATPolicy{
+		Rate:      c.AutoNATServiceRate,
+		PeerRate:  c.AutoNATServicePeerRate,
+		Interval:  time.Duration(c.AutoNATServiceInterval),
+		PeerQuota: c.AutoNATServicePeerQuota,
+		Networks:  c.AutoNATServiceNetworks,
+	}
+}
+
+// DialLimits returns the limits of the dial policy
+func (c *Config) DialLimits() DialLimits {
+	return DialLimits{
+		BackoffBase:   time.Duration(c.DialBackoffBase),
+		BackoffMax:    time.Duration(c.DialBackoffMax),
+		MaxConcurrent: c.DialMaxConcurrent,
+		PeerAttempts:  c.DialPeerAttempts,
+		PeerWindow:    time.Duration(c.DialPeerWindow),
+	}
+}
+
+// DialLimited reports whether any dial limit is configured
+func (c *Config) DialLimited() bool {
+	return c.DialBackoffBase > 0 || c.DialMaxConcurrent > 0 || c.DialPeerAttempts > 0
+}
+
+// ActivityTagged reports whether any kind of activity tags peers
+func (c *Config) ActivityTagged() bool {
+	for _, weight := range c.ActivityWeights {
+		if weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MDNSEnabled reports whether mDNS discovery runs, as it does offline
+func (c *Config) MDNSEnabled() bool {
+	return c.EnableMDNS || c.Offline
+}
+
+// QUICTuned reports whether any QUIC parameter is configured
+func (c *Config) QUICTuned() bool {
+	return c.QUICMaxIdleTimeout > 0 || c.QUICKeepAlive > 0 || c.QUICStreamWindow > 0 || c.QUICConnectionWindow > 0
+}
+
+// TCPTuned reports whether any TCP socket option differs from Go's defaults
+func (c *Config) TCPTuned() bool {
+	return c.TCPKeepAliveInterval > 0 || !c.TCPNoDelay || c.TCPReadBuffer > 0 || c.TCPWriteBuffer > 0
+}
+
+// StreamLimited reports whether any per-peer stream cap is configured
+func (c *Config) StreamLimited() bool {
+	return c.MaxStreamsPerPeer > 0 || len(c.ProtocolStreamLimits) > 0
+}
+
+// TransportPorts resolves the listen port of each transport, falling back to
+// listen_port for transports without their own setting
+func (c *Config) TransportPorts() TransportPorts {
+	ports := uniformPorts(c.ListenPort)
+	if c.TCPPort != nil {
+		ports.TCP = *c.TCPPort
+	}
+	if c.QUICPort != nil {
+		ports.QUIC = *c.QUICPort
+	}
+	if c.WSPort != nil {
+		ports.WS = *c.WSPort
+	}
+	if c.WSSPort != nil {
+		ports.WSS = *c.WSSPort
+	}
+	return ports
+}
+
+// SetupLogging configures the logging system based on config
+func (c *Config) SetupLogging() error {
+	level, err := logrus.ParseLevel(c.LogLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	logrus.SetLevel(level)
+	for subsystem, level := range c.LogSubsystems {
+		if err := SetLogLevel(subsystem, level); err != nil {
+			return err
+		}
+	}
+
+	// Set JSON formatter for structured logging
+	logrus.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat: "2006-01-02 15:04:05",
+	})
+
+	// Set up log file if specified
+	if c.LogFile != "" {
+		// Create log directory
+		if err := os.MkdirAll(filepath.Dir(c.LogFile), 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %w", err)
+		}
+
+		file, err := os.OpenFile(c.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		logrus.SetOutput(file)
+		logrus.WithField("file", c.LogFile).Info("Logging to file")
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ConfigPushProtocol carries signed configuration updates from admin peers
+	ConfigPushProtocol = "/libp2p-learn/config/1.0.0"
+
+	// configSignatureDomain separates config update signatures from any other use of the key
+	configSignatureDomain = "libp2p-learn-config:"
+
+	// maxConfigUpdateAge rejects updates issued too long ago, or too far in the future
+	maxConfigUpdateAge = 5 * time.Minute
+
+	// maxConfigUpdateSize bounds the size of a config update or acknowledgement line
+	maxConfigUpdateSize = 1 << 20
+)
+
+// errConfigSignature is returned for updates whose signature doesn't verify
+var errConfigSignature = errors.New("invalid signature")
+
+// ConfigUpdate is a partial configuration, in config file JSON, to apply on
+// top of the current one. Target is the node it is for, so that it can't be
+// replayed to other nodes.
+type ConfigUpdate struct {
+	Seq    uint64          `json:"seq"`
+	Issued time.Time       `json:"issued"`
+	Patch  json.RawMessage `json:"patch"`
+	Target peer.ID         `json:"target"`
+}
+
+// SignedConfigUpdate is a ConfigUpdate signed by an admin peer, sent as one JSON line
+type SignedConfigUpdate struct {
+	Signer    string `json:"signer"`
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+// ConfigAck acknowledges a config update, sent as one JSON line
+type ConfigAck struct {
+	OK              bool     `json:"ok"`
+	Error           string   `json:"error,omitempty"`
+	Seq             uint64   `json:"seq"`
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// SignConfigUpdate signs an update with the admin's private key
+func SignConfigUpdate(key crypto.PrivKey, update ConfigUpdate) (*SignedConfigUpdate, error) {
+	signer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive signer ID: %w", err)
+	}
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config update: %w", err)
+	}
+	sig, err := key.Sign(append([]byte(configSignatureDomain), payload...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign config update: %w", err)
+	}
+	return &SignedConfigUpdate{Signer: signer.String(), Payload: payload, Signature: sig}, nil
+}
+
+// Verify checks the signature against the signer's peer ID and returns the update
+func (u *SignedConfigUpdate) Verify() (*ConfigUpdate, peer.ID, error) {
+	signer, err := peer.Decode(u.Signer)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid signer: %w", err)
+	}
+	pub, err := signer.ExtractPublicKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get signer public key: %w", err)
+	}
+	ok, err := pub.Verify(append([]byte(configSignatureDomain), u.Payload...), u.Signature)
+	if err != nil || !ok {
+		return nil, "", errConfigSignature
+	}
+
+	var update ConfigUpdate
+	if err := json.Unmarshal(u.Payload, &update); err != nil {
+		return nil, "", fmt.Errorf("failed to parse config update: %w", err)
+	}
+	return &update, signer, nil
+}
+
+// ConfigApplier validates and applies a config patch, returning the fields that need a restart
+type ConfigApplier func(patch json.RawMessage) ([]string, error)
+
+// ConfigPush accepts signed configuration updates from authorized peers
+type ConfigPush struct {
+	host       host.Host
+	authorized func(peer.ID) bool
+	apply      ConfigApplier
+	audit      *AuditLog
+
+	// since is when the protocol was registered. Updates issued earlier are
+	// rejected, since the sequence numbers applied before a restart are lost.
+	since time.Time
+
+	mu      sync.Mutex
+	lastSeq uint64
+}
+
+// NewConfigPush registers the config push protocol. Updates signed by a peer
+// for which authorized returns true are passed to apply.
+func NewConfigPush(h host.Host, authorized func(peer.ID) bool, apply ConfigApplier) *ConfigPush {
+	c := &ConfigPush{host: h, authorized: authorized, apply: apply, since: time.Now()}
+	h.SetStreamHandler(protocol.ID(ConfigPushProtocol), RecoveryMiddleware(protocol.ID(ConfigPushProtocol), c.handleUpdate))
+	logrus.WithField("protocol", ConfigPushProtocol).Info("Registered config push protocol")
+	return c
+}
+
+// SetAuditLog records every update, applied or rejected, to the audit log
+func (c *ConfigPush) SetAuditLog(audit *AuditLog) {
+	c.audit = audit
+}
+
+// Close unregisters the config push protocol
+func (c *ConfigPush) Close() {
+	c.host.RemoveStreamHandler(protocol.ID(ConfigPushProtocol))
+}
+
+// handleUpdate verifies, applies and acknowledges one config update
+func (c *ConfigPush) handleUpdate(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(adminTimeout))
+
+	reader := bufio.NewReaderSize(s, maxConfigUpdateSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read config update")
+		return
+	}
+
+	var signed SignedConfigUpdate
+	if err := json.Unmarshal(line, &signed); err != nil {
+		ReportMisbehavior(c.host, remote, MisbehaviorProtocolError, "invalid config update")
+		c.audit.Action(remote, ConfigPushProtocol, "config_push", nil, fmt.Errorf("invalid update: %w", err))
+		writeConfigAck(s, ConfigAck{Error: fmt.Sprintf("invalid update: %v", err)})
+		return
+	}
+
+	ack := c.process(remote, &signed)
+	fields := logrus.Fields{
+		"peer":   remote,
+		"signer": signed.Signer,
+		"seq":    ack.Seq,
+	}
+	var auditErr error
+	if ack.OK {
+		logrus.WithFields(fields).WithField("restart_required", ack.RestartRequired).Info("Applied pushed config update")
+	} else {
+		logrus.WithFields(fields).WithField("error", ack.Error).Warn("Rejected pushed config update")
+		auditErr = fmt.Errorf("%s", ack.Error)
+	}
+	c.audit.Action(remote, ConfigPushProtocol, "config_push", []string{signed.Signer, fmt.Sprint(ack.Seq)}, auditErr)
+	writeConfigAck(s, ack)
+}
+
+// process checks the signature, signer and freshness of an update from a peer and applies it
+func (c *ConfigPush) process(from peer.ID, signed *SignedConfigUpdate) ConfigAck {
+	update, signer, err := signed.Verify()
+	if err != nil {
+		if errors.Is(err, errConfigSignature) {
+			ReportMisbehavior(c.host, from, MisbehaviorInvalidSignature, "config update")
+		}
+		return ConfigAck{Error: err.Error()}
+	}
+	if !c.authorized(signer) {
+		return ConfigAck{Seq: update.Seq, Error: "signer is not an admin peer"}
+	}
+	if update.Target != c.host.ID() {
+		return ConfigAck{Seq: update.Seq, Error: "update is for another node"}
+	}
+	if age := time.Since(update.Issued); age > maxConfigUpdateAge || age < -maxConfigUpdateAge {
+		return ConfigAck{Seq: update.Seq, Error: "update is stale"}
+	}
+	if update.Issued.Before(c.since) {
+		return ConfigAck{Seq: update.Seq, Error: "update was issued before the node started"}
+	}
+
+	// Serialize updates so sequence numbers only move forward and a replay is never applied
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if update.Seq <= c.lastSeq {
+		return ConfigAck{Seq: update.Seq, Error: fmt.Sprintf("sequence %d already applied", update.Seq)}
+	}
+
+	restart, err := c.apply(update.Patch)
+	if err != nil {
+		return ConfigAck{Seq: update.Seq, Error: err.Error()}
+	}
+	c.lastSeq = update.Seq
+	return ConfigAck{OK: true, Seq: update.Seq, RestartRequired: restart}
+}
+
+// writeConfigAck sends an acknowledgement as one JSON line
+func writeConfigAck(s network.Stream, ack ConfigAck) {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode config ack")
+		return
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).Debug("Failed to write config ack")
+	}
+}
+
+// PushConfig signs a config patch with the host's key and pushes it to a peer
+// that lists the host as an admin peer
+func PushConfig(ctx context.Context, h host.Host, p peer.ID, patch json.RawMessage) (*ConfigAck, error) {
+	key := h.Peerstore().PrivKey(h.ID())
+	if key == nil {
+		return nil, fmt.Errorf("host private key not available")
+	}
+	now := time.Now()
+	signed, err := SignConfigUpdate(key, ConfigUpdate{Seq: uint64(now.UnixNano()), Issued: now, Target: p, Patch: patch})
+	if err != nil {
+		return nil, err
+	}
+	return SendConfigUpdate(ctx, h, p, signed)
+}
+
+// SendConfigUpdate delivers an already signed config update to a peer and waits for the acknowledgement
+func SendConfigUpdate(ctx context.Context, h host.Host, p peer.ID, signed *SignedConfigUpdate) (*ConfigAck, error) {
+	s, err := h.NewStream(ctx, p, protocol.ID(ConfigPushProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config update: %w", err)
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send config update: %w", err)
+	}
+	s.CloseWrite()
+
+	reader := bufio.NewReaderSize(s, maxConfigUpdateSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config ack: %w", err)
+	}
+
+	var ack ConfigAck
+	if err := json.Unmarshal(line, &ack); err != nil {
+		return nil, fmt.Errorf("failed to parse config ack: %w", err)
+	}
+	if !ack.OK {
+		return &ack, fmt.Errorf("config update rejected: %s", ack.Error)
+	}
+	return &ack, nil
+}
@@ -0,0 +1,167 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPush(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	operator, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer operator.Close()
+
+	outsider, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer outsider.Close()
+
+	cfg := testNodeConfig()
+	cfg.AdminPeers = []string{operator.ID().String()}
+	managed, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	require.NoError(t, managed.Start(ctx))
+	defer managed.Stop(context.Background())
+
+	require.NoError(t, connectNodes(ctx, operator, managed.Host()))
+	require.NoError(t, connectNodes(ctx, outsider, managed.Host()))
+
+	operatorKey := operator.Peerstore().PrivKey(operator.ID())
+
+	t.Run("AppliesReloadableFields", func(t *testing.T) {
+		previous := logrus.GetLevel()
+		defer logrus.SetLevel(previous)
+
+		blocked, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer blocked.Close()
+
+		patch := `{"blocked_peers":["` + blocked.ID().String() + `"],"log_level":"debug"}`
+		ack, err := PushConfig(ctx, operator, managed.Host().ID(), json.RawMessage(patch))
+		require.NoError(t, err)
+		assert.True(t, ack.OK)
+		assert.Empty(t, ack.RestartRequired)
+
+		assert.True(t, managed.Blocklist().IsBlocked(blocked.ID()))
+		assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+		assert.Equal(t, "debug", managed.Config().LogLevel)
+	})
+
+	t.Run("ReportsRestartRequired", func(t *testing.T) {
+		ack, err := PushConfig(ctx, operator, managed.Host().ID(), json.RawMessage(`{"enable_relay":true}`))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"enable_relay"}, ack.RestartRequired)
+	})
+
+	t.Run("RejectsInvalidConfig", func(t *testing.T) {
+		_, err := PushConfig(ctx, operator, managed.Host().ID(), json.RawMessage(`{"dial_strategy":"bogus"}`))
+		assert.ErrorContains(t, err, "invalid configuration")
+		assert.NotEqual(t, "bogus", managed.Config().DialStrategy)
+	})
+
+	t.Run("RejectsUnauthorizedSigner", func(t *testing.T) {
+		_, err := PushConfig(ctx, outsider, managed.Host().ID(), json.RawMessage(`{"log_level":"error"}`))
+		assert.ErrorContains(t, err, "not an admin peer")
+		assert.Equal(t, "debug", managed.Config().LogLevel)
+	})
+
+	t.Run("RejectsForgedSigner", func(t *testing.T) {
+		signed, err := SignConfigUpdate(outsider.Peerstore().PrivKey(outsider.ID()), ConfigUpdate{
+			Seq:    uint64(time.Now().UnixNano()),
+			Issued: time.Now(),
+			Target: managed.Host().ID(),
+			Patch:  json.RawMessage(`{"log_level":"error"}`),
+		})
+		require.NoError(t, err)
+		signed.Signer = operator.ID().String()
+
+		_, err = SendConfigUpdate(ctx, outsider, managed.Host().ID(), signed)
+		assert.ErrorContains(t, err, "invalid signature")
+	})
+
+	t.Run("RejectsReplay", func(t *testing.T) {
+		signed, err := SignConfigUpdate(operatorKey, ConfigUpdate{
+			Seq:    uint64(time.Now().UnixNano()),
+			Issued: time.Now(),
+			Target: managed.Host().ID(),
+			Patch:  json.RawMessage(`{"log_level":"info"}`),
+		})
+		require.NoError(t, err)
+
+		_, err = SendConfigUpdate(ctx, operator, managed.Host().ID(), signed)
+		require.NoError(t, err)
+
+		// The same update relayed by another peer is still a replay
+		_, err = SendConfigUpdate(ctx, outsider, managed.Host().ID(), signed)
+		assert.ErrorContains(t, err, "already applied")
+	})
+
+	t.Run("RejectsOtherTarget", func(t *testing.T) {
+		// An update for another node can't be replayed to this one
+		signed, err := SignConfigUpdate(operatorKey, ConfigUpdate{
+			Seq:    uint64(time.Now().UnixNano()),
+			Issued: time.Now(),
+			Target: outsider.ID(),
+			Patch:  json.RawMessage(`{"log_level":"warn"}`),
+		})
+		require.NoError(t, err)
+
+		_, err = SendConfigUpdate(ctx, outsider, managed.Host().ID(), signed)
+		assert.ErrorContains(t, err, "for another node")
+		assert.Equal(t, "info", managed.Config().LogLevel)
+	})
+
+	t.Run("RejectsIssuedBeforeStart", func(t *testing.T) {
+		// Still fresh, but the node may have applied it before a restart
+		signed, err := SignConfigUpdate(operatorKey, ConfigUpdate{
+			Seq:    uint64(time.Now().UnixNano()),
+			Issued: time.Now().Add(-maxConfigUpdateAge / 2),
+			Target: managed.Host().ID(),
+			Patch:  json.RawMessage(`{"log_level":"warn"}`),
+		})
+		require.NoError(t, err)
+
+		_, err = SendConfigUpdate(ctx, operator, managed.Host().ID(), signed)
+		assert.ErrorContains(t, err, "before the node started")
+		assert.Equal(t, "info", managed.Config().LogLevel)
+	})
+
+	t.Run("RejectsStaleUpdate", func(t *testing.T) {
+		issued := time.Now().Add(-2 * maxConfigUpdateAge)
+		signed, err := SignConfigUpdate(operatorKey, ConfigUpdate{
+			Seq:    uint64(time.Now().UnixNano()),
+			Issued: issued,
+			Target: managed.Host().ID(),
+			Patch:  json.RawMessage(`{"log_level":"warn"}`),
+		})
+		require.NoError(t, err)
+
+		_, err = SendConfigUpdate(ctx, operator, managed.Host().ID(), signed)
+		assert.ErrorContains(t, err, "stale")
+		assert.Equal(t, "info", managed.Config().LogLevel)
+	})
+}
+
+func TestMergeConfig(t *testing.T) {
+	cfg := testNodeConfig()
+	cfg.BlockedPeers = []string{"a", "b"}
+
+	merged, err := mergeConfig(cfg, json.RawMessage(`{"blocked_peers":["c"],"listen_port":4001}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, merged.BlockedPeers, "lists should be replaced")
+	assert.Equal(t, 4001, merged.ListenPort)
+	assert.Equal(t, cfg.DialStrategy, merged.DialStrategy, "missing fields should be kept")
+	assert.Equal(t, []string{"a", "b"}, cfg.BlockedPeers, "the original config should not change")
+
+	assert.ElementsMatch(t, []string{"listen_port"}, changedFields(cfg, merged, reloadableFields))
+
+	_, err = mergeConfig(cfg, json.RawMessage(`{"listen_port":"high"}`))
+	assert.Error(t, err)
+}
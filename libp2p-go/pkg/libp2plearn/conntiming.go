@@ -0,0 +1,338 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// connTimingIdentifyTimeout bounds waiting for identify on a new connection
+	connTimingIdentifyTimeout = 30 * time.Second
+
+	// connTimingMaxAge is how long a connection may take to establish before
+	// its timestamps are dropped, such as those of dials that failed
+	connTimingMaxAge = time.Minute
+)
+
+// Phases of establishing a connection
+const (
+	connPhaseHandshake = "handshake"
+	connPhaseSecurity  = "security"
+	connPhaseMuxer     = "muxer"
+	connPhaseIdentify  = "identify"
+	connPhaseTotal     = "total"
+)
+
+// connPhaseDuration records how long each phase of establishing a
+// connection took, from 1ms to ~16s
+var connPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "conn",
+	Name:      "phase_duration_seconds",
+	Help:      "Duration of each phase of establishing a connection",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+}, []string{"transport", "direction", "phase"})
+
+// EvtConnectionTimed is emitted on the host event bus once a new connection
+// has finished identify, with how long each phase of establishing it took
+type EvtConnectionTimed struct {
+	Timing ConnTiming
+}
+
+// ConnTiming breaks down how long establishing a connection took. Handshake
+// is the transport handshake up to the security handshake, which includes
+// negotiating the security protocol. Phases a connection doesn't have, or
+// that can't be seen, are zero: QUIC's TLS handshake is part of its
+// transport handshake and it needs no muxer, and the TCP handshake of an
+// inbound connection is over before the node sees the connection.
+type ConnTiming struct {
+	Peer      peer.ID  `json:"peer"`
+	Addr      string   `json:"addr"`
+	Transport string   `json:"transport"`
+	Direction string   `json:"direction"`
+	Handshake Duration `json:"handshake"`
+	Security  Duration `json:"security"`
+	Muxer     Duration `json:"muxer"`
+	Identify  Duration `json:"identify"`
+	Total     Duration `json:"total"`
+}
+
+// connPhases are the timestamps of a connection being established
+type connPhases struct {
+	start       time.Time
+	secureStart time.Time
+	secured     time.Time
+	muxed       time.Time
+}
+
+// ConnTimings times each phase of establishing connections. The connection
+// gater marks when a dial or accept starts and when the connection is
+// secured, wrapped security transports when the security handshake starts,
+// and the wrapped muxer when the muxer is chosen. Connections are matched
+// up by their remote address.
+type ConnTimings struct {
+	host    host.Host
+	ids     identify.IDService
+	emitter event.Emitter
+	notifee *network.NotifyBundle
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	pending map[string]*connPhases
+	pruned  time.Time
+}
+
+// NewConnTimings creates the timings, which see nothing until the host is
+// built with Options and Attach is called
+func NewConnTimings() *ConnTimings {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConnTimings{
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[string]*connPhases),
+	}
+}
+
+// Options are the libp2p default security transports and muxer, wrapped
+// to time their handshakes
+func (t *ConnTimings) Options() []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.Security(tls.ID, func(id protocol.ID, key crypto.PrivKey, muxers []tptu.StreamMuxer) (*timedSecurity, error) {
+			st, err := tls.New(id, key, muxers)
+			if err != nil {
+				return nil, err
+			}
+			return &timedSecurity{SecureTransport: st, timings: t}, nil
+		}),
+		libp2p.Security(noise.ID, func(id protocol.ID, key crypto.PrivKey, muxers []tptu.StreamMuxer) (*timedSecurity, error) {
+			st, err := noise.New(id, key, muxers)
+			if err != nil {
+				return nil, err
+			}
+			return &timedSecurity{SecureTransport: st, timings: t}, nil
+		}),
+		libp2p.Muxer(yamux.ID, &timedMuxer{Multiplexer: yamux.DefaultTransport, timings: t}),
+	}
+}
+
+// Attach starts reporting the connections of h, which must be the host
+// built with Options rather than a wrapper, since identify is needed
+func (t *ConnTimings) Attach(h host.Host) error {
+	withIDs, ok := h.(interface{ IDService() identify.IDService })
+	if !ok {
+		return fmt.Errorf("host does not expose its identify service")
+	}
+	emitter, err := h.EventBus().Emitter(new(EvtConnectionTimed))
+	if err != nil {
+		return fmt.Errorf("failed to create connection timing emitter: %w", err)
+	}
+	t.host = h
+	t.ids = withIDs.IDService()
+	t.emitter = emitter
+	t.notifee = &network.NotifyBundle{ConnectedF: t.connected}
+	h.Network().Notify(t.notifee)
+	return nil
+}
+
+// Close stops reporting connections
+func (t *ConnTimings) Close() {
+	t.cancel()
+	if t.host != nil {
+		t.host.Network().StopNotify(t.notifee)
+		t.emitter.Close()
+	}
+}
+
+// started marks the start of a dial or of accepting a connection. Nil
+// timings mark nothing.
+func (t *ConnTimings) started(addr multiaddr.Multiaddr) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[timingKey(addr)] = &connPhases{start: now}
+
+	if now.Sub(t.pruned) > connTimingMaxAge/6 {
+		t.pruned = now
+		for key, phases := range t.pending {
+			if now.Sub(phases.start) > connTimingMaxAge {
+				delete(t.pending, key)
+			}
+		}
+	}
+}
+
+// mark sets a timestamp of the connection with a remote address, unless set
+func (t *ConnTimings) mark(addr multiaddr.Multiaddr, field func(*connPhases) *time.Time) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if phases, ok := t.pending[timingKey(addr)]; ok {
+		if ts := field(phases); ts.IsZero() {
+			*ts = now
+		}
+	}
+}
+
+// markNet is mark for a net.Addr, as seen by security transports and muxers
+func (t *ConnTimings) markNet(addr net.Addr, field func(*connPhases) *time.Time) {
+	maddr, err := manet.FromNetAddr(addr)
+	if err != nil {
+		return
+	}
+	t.mark(maddr, field)
+}
+
+// secured marks the end of the security handshake
+func (t *ConnTimings) secured(addr multiaddr.Multiaddr) {
+	t.mark(addr, func(p *connPhases) *time.Time { return &p.secured })
+}
+
+// connected waits for identify on a new connection and reports its timing
+func (t *ConnTimings) connected(_ network.Network, conn network.Conn) {
+	key := timingKey(conn.RemoteMultiaddr())
+	t.mu.Lock()
+	phases, ok := t.pending[key]
+	delete(t.pending, key)
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	connectedAt := time.Now()
+
+	go func() {
+		timer := time.NewTimer(connTimingIdentifyTimeout)
+		defer timer.Stop()
+		var identified time.Time
+		select {
+		case <-t.ids.IdentifyWait(conn):
+			identified = time.Now()
+		case <-timer.C:
+		case <-t.ctx.Done():
+			return
+		}
+		t.report(conn, phases, connectedAt, identified)
+	}()
+}
+
+// report computes the timing of a connection, records it in the metrics and
+// emits it
+func (t *ConnTimings) report(conn network.Conn, p *connPhases, connectedAt, identified time.Time) {
+	timing := ConnTiming{
+		Peer:      conn.RemotePeer(),
+		Addr:      conn.RemoteMultiaddr().String(),
+		Transport: transportName(conn.RemoteMultiaddr()),
+		Direction: strings.ToLower(conn.Stat().Direction.String()),
+	}
+	secured := p.secured
+	if secured.IsZero() {
+		secured = connectedAt
+	}
+	if !p.secureStart.IsZero() {
+		timing.Handshake = Duration(p.secureStart.Sub(p.start))
+		timing.Security = Duration(secured.Sub(p.secureStart))
+	} else {
+		timing.Handshake = Duration(secured.Sub(p.start))
+	}
+	if !p.muxed.IsZero() {
+		timing.Muxer = Duration(p.muxed.Sub(secured))
+	}
+	end := connectedAt
+	if !identified.IsZero() {
+		timing.Identify = Duration(identified.Sub(connectedAt))
+		end = identified
+	}
+	timing.Total = Duration(end.Sub(p.start))
+
+	for phase, d := range map[string]Duration{
+		connPhaseHandshake: timing.Handshake,
+		connPhaseSecurity:  timing.Security,
+		connPhaseMuxer:     timing.Muxer,
+		connPhaseIdentify:  timing.Identify,
+		connPhaseTotal:     timing.Total,
+	} {
+		if d > 0 {
+			connPhaseDuration.WithLabelValues(timing.Transport, timing.Direction, phase).Observe(time.Duration(d).Seconds())
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":      timing.Peer,
+		"transport": timing.Transport,
+		"direction": timing.Direction,
+		"handshake": time.Duration(timing.Handshake),
+		"security":  time.Duration(timing.Security),
+		"muxer":     time.Duration(timing.Muxer),
+		"identify":  time.Duration(timing.Identify),
+		"total":     time.Duration(timing.Total),
+	}).Debug("Connection established")
+	if err := t.emitter.Emit(EvtConnectionTimed{Timing: timing}); err != nil {
+		logrus.WithError(err).Debug("Failed to emit connection timing")
+	}
+}
+
+// timingKey identifies a connection being established by its remote
+// address, without a trailing /p2p component
+func timingKey(addr multiaddr.Multiaddr) string {
+	if rest, last := multiaddr.SplitLast(addr); last != nil && last.Protocol().Code == multiaddr.P_P2P {
+		return rest.String()
+	}
+	return addr.String()
+}
+
+// timedSecurity marks when its security handshakes start
+type timedSecurity struct {
+	sec.SecureTransport
+	timings *ConnTimings
+}
+
+func (s *timedSecurity) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
+	s.timings.markNet(insecure.RemoteAddr(), func(p *connPhases) *time.Time { return &p.secureStart })
+	return s.SecureTransport.SecureInbound(ctx, insecure, p)
+}
+
+func (s *timedSecurity) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
+	s.timings.markNet(insecure.RemoteAddr(), func(p *connPhases) *time.Time { return &p.secureStart })
+	return s.SecureTransport.SecureOutbound(ctx, insecure, p)
+}
+
+// timedMuxer marks when it takes over a connection, after the muxer was
+// negotiated
+type timedMuxer struct {
+	network.Multiplexer
+	timings *ConnTimings
+}
+
+func (m *timedMuxer) NewConn(c net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	m.timings.markNet(c.RemoteAddr(), func(p *connPhases) *time.Time { return &p.muxed })
+	return m.Multiplexer.NewConn(c, isServer, scope)
+}
@@ -0,0 +1,61 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnTimings(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node1, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	node2, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+
+	dialed, cancelDialed := node1.SubscribeEvents(EventConnectionTimed)
+	defer cancelDialed()
+	accepted, cancelAccepted := node2.SubscribeEvents(EventConnectionTimed)
+	defer cancelAccepted()
+
+	require.NoError(t, node1.Start(ctx))
+	defer node1.Stop(ctx)
+	require.NoError(t, node2.Start(ctx))
+	defer node2.Stop(ctx)
+
+	addr := fmt.Sprintf("%s/p2p/%s", node2.Host().Addrs()[0], node2.Host().ID())
+	require.NoError(t, node1.Connect(ctx, addr))
+
+	e := nextEvent(t, ctx, dialed)
+	assert.Equal(t, node2.Host().ID(), e.Peer)
+	timing := e.Raw.(EvtConnectionTimed).Timing
+	assert.Equal(t, "outbound", timing.Direction)
+	assert.Equal(t, transportName(node2.Host().Addrs()[0]), timing.Transport)
+	assert.Equal(t, timing.Transport, e.Message)
+	assert.Greater(t, timing.Handshake, Duration(0))
+	assert.Greater(t, timing.Identify, Duration(0))
+	assert.GreaterOrEqual(t, timing.Total, timing.Handshake+timing.Security+timing.Muxer+timing.Identify)
+	if timing.Transport == "tcp" {
+		assert.Greater(t, timing.Security, Duration(0))
+	}
+
+	e = nextEvent(t, ctx, accepted)
+	assert.Equal(t, node1.Host().ID(), e.Peer)
+	timing = e.Raw.(EvtConnectionTimed).Timing
+	assert.Equal(t, "inbound", timing.Direction)
+	assert.Greater(t, timing.Total, Duration(0))
+}
+
+func TestTimingKey(t *testing.T) {
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	withPeer := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001/p2p/12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK")
+	assert.Equal(t, timingKey(addr), timingKey(withPeer))
+	assert.Equal(t, "/ip4/127.0.0.1/tcp/4001", timingKey(addr))
+}
@@ -0,0 +1,261 @@
+package libp2plearn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// DefaultContactsFile is where the address book is kept unless told otherwise
+const DefaultContactsFile = "data/contacts.json"
+
+// contactName is what contact names look like, so they can't be mistaken
+// for multiaddrs
+var contactName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Contact is a peer known by a name, with the addresses to reach it at.
+// Verified contacts had their safety number compared out of band.
+type Contact struct {
+	Name     string   `json:"name"`
+	ID       peer.ID  `json:"id"`
+	Addrs    []string `json:"addrs"`
+	Verified bool     `json:"verified,omitempty"`
+}
+
+// AddrInfo returns the peer and addresses of the contact
+func (c Contact) AddrInfo() peer.AddrInfo {
+	info := peer.AddrInfo{ID: c.ID}
+	for _, s := range c.Addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		if transport, _ := peer.SplitAddr(addr); transport != nil {
+			info.Addrs = append(info.Addrs, transport)
+		}
+	}
+	return info
+}
+
+// AddressBook maps human-friendly names to peers, so commands can take
+// "alice" wherever a peer multiaddr or peer ID goes, and show names for the
+// peers they list
+type AddressBook struct {
+	path     string
+	mu       sync.Mutex
+	contacts map[string]*Contact
+}
+
+// LoadAddressBook reads the address book file, starting empty if it doesn't
+// exist
+func LoadAddressBook(path string) (*AddressBook, error) {
+	book := &AddressBook{
+		path:     path,
+		contacts: make(map[string]*Contact),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return book, nil
+		}
+		return nil, fmt.Errorf("failed to read address book: %w", err)
+	}
+
+	var contacts []*Contact
+	if err := json.Unmarshal(data, &contacts); err != nil {
+		return nil, fmt.Errorf("failed to parse address book: %w", err)
+	}
+	for _, c := range contacts {
+		book.contacts[c.Name] = c
+	}
+	return book, nil
+}
+
+// Add names the peer of target, a peer ID or a multiaddr ending in
+// /p2p/<peer ID>. Adding more addresses of the same peer under a name adds
+// them to the contact; a name for another peer must be removed first.
+func (b *AddressBook) Add(name, target string) (Contact, error) {
+	if !contactName.MatchString(name) {
+		return Contact{}, fmt.Errorf("invalid contact name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	if _, err := peer.Decode(name); err == nil {
+		return Contact{}, fmt.Errorf("invalid contact name %q: it is a peer ID", name)
+	}
+
+	var info peer.AddrInfo
+	if strings.HasPrefix(target, "/") {
+		parsed, err := peer.AddrInfoFromString(target)
+		if err != nil {
+			return Contact{}, fmt.Errorf("invalid peer multiaddr: %w", err)
+		}
+		info = *parsed
+	} else {
+		id, err := peer.Decode(target)
+		if err != nil {
+			return Contact{}, fmt.Errorf("invalid peer ID: %w", err)
+		}
+		info.ID = id
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.contacts[name]
+	if !ok {
+		c = &Contact{Name: name, ID: info.ID, Addrs: []string{}}
+		b.contacts[name] = c
+	} else if c.ID != info.ID {
+		return Contact{}, fmt.Errorf("%s is already %s, remove it first", name, c.ID)
+	}
+	for _, addr := range info.Addrs {
+		s := addr.Encapsulate(multiaddr.StringCast("/p2p/" + info.ID.String())).String()
+		if !slices.Contains(c.Addrs, s) {
+			c.Addrs = append(c.Addrs, s)
+		}
+	}
+	return *c, nil
+}
+
+// Remove forgets a name, reporting whether it was known
+func (b *AddressBook) Remove(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.contacts[name]
+	delete(b.contacts, name)
+	return ok
+}
+
+// Contacts returns every contact by name
+func (b *AddressBook) Contacts() []Contact {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	contacts := make([]Contact, 0, len(b.contacts))
+	for _, c := range b.contacts {
+		contacts = append(contacts, *c)
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Name < contacts[j].Name
+	})
+	return contacts
+}
+
+// SetVerified marks a contact as verified, once its safety number was
+// compared, or no longer verified
+func (b *AddressBook) SetVerified(name string, verified bool) (Contact, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.contacts[name]
+	if !ok {
+		return Contact{}, fmt.Errorf("no contact named %s", name)
+	}
+	c.Verified = verified
+	return *c, nil
+}
+
+// IsVerified reports whether a contact for the peer is verified. A nil
+// AddressBook verifies no peer.
+func (b *AddressBook) IsVerified(id peer.ID) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.contacts {
+		if c.ID == id && c.Verified {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the contact with a name
+func (b *AddressBook) Lookup(name string) (Contact, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.contacts[name]
+	if !ok {
+		return Contact{}, false
+	}
+	return *c, true
+}
+
+// Resolve returns the peer to dial for ref, a contact name or a peer
+// multiaddr
+func (b *AddressBook) Resolve(ref string) (peer.AddrInfo, error) {
+	if c, ok := b.Lookup(ref); ok {
+		info := c.AddrInfo()
+		if len(info.Addrs) == 0 {
+			return peer.AddrInfo{}, fmt.Errorf("contact %s has no addresses", ref)
+		}
+		return info, nil
+	}
+	info, err := peer.AddrInfoFromString(ref)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("%q is neither a contact nor a peer multiaddr: %w", ref, err)
+	}
+	return *info, nil
+}
+
+// ResolveID returns the peer ID for ref, a contact name or a peer ID
+func (b *AddressBook) ResolveID(ref string) (peer.ID, error) {
+	if c, ok := b.Lookup(ref); ok {
+		return c.ID, nil
+	}
+	id, err := peer.Decode(ref)
+	if err != nil {
+		return "", fmt.Errorf("%q is neither a contact nor a peer ID: %w", ref, err)
+	}
+	return id, nil
+}
+
+// Name returns the name of a peer, or "" if it has none. Of several names
+// for a peer, the first in order is used.
+func (b *AddressBook) Name(id peer.ID) string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var name string
+	for _, c := range b.contacts {
+		if c.ID == id && (name == "" || c.Name < name) {
+			name = c.Name
+		}
+	}
+	return name
+}
+
+// Label returns "name (peer ID)" for a named peer and the peer ID otherwise.
+// A nil AddressBook labels every peer by ID.
+func (b *AddressBook) Label(id peer.ID) string {
+	if name := b.Name(id); name != "" {
+		return fmt.Sprintf("%s (%s)", name, id)
+	}
+	return id.String()
+}
+
+// Save writes the address book to its file
+func (b *AddressBook) Save() error {
+	data, err := json.MarshalIndent(b.Contacts(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode address book: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("failed to create address book directory: %w", err)
+	}
+
+	if err := writeFileAtomic(b.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write address book: %w", err)
+	}
+	return nil
+}
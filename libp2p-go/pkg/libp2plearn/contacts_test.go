@@ -0,0 +1,77 @@
+package libp2plearn
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contacts.json")
+	alice, err := peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	require.NoError(t, err)
+	bob, err := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	require.NoError(t, err)
+
+	book, err := LoadAddressBook(path)
+	require.NoError(t, err)
+	assert.Empty(t, book.Contacts())
+
+	_, err = book.Add("alice", "/ip4/192.168.1.20/tcp/4001/p2p/"+alice.String())
+	require.NoError(t, err)
+	c, err := book.Add("alice", "/ip4/192.168.1.20/udp/4001/quic-v1/p2p/"+alice.String())
+	require.NoError(t, err)
+	assert.Len(t, c.Addrs, 2)
+	_, err = book.Add("bob", bob.String())
+	require.NoError(t, err)
+
+	_, err = book.Add("alice", bob.String())
+	assert.Error(t, err, "a name belongs to one peer")
+	_, err = book.Add("/ip4/1.2.3.4", bob.String())
+	assert.Error(t, err)
+	_, err = book.Add(bob.String(), bob.String())
+	assert.Error(t, err, "a peer ID is not a name")
+	c, err = book.SetVerified("bob", true)
+	require.NoError(t, err)
+	assert.True(t, c.Verified)
+	_, err = book.SetVerified("carol", true)
+	assert.Error(t, err)
+	require.NoError(t, book.Save())
+
+	book, err = LoadAddressBook(path)
+	require.NoError(t, err)
+	require.Len(t, book.Contacts(), 2)
+	assert.True(t, book.IsVerified(bob))
+	assert.False(t, book.IsVerified(alice))
+
+	info, err := book.Resolve("alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice, info.ID)
+	assert.Len(t, info.Addrs, 2)
+	_, err = book.Resolve("bob")
+	assert.Error(t, err, "bob has no addresses to dial")
+	info, err = book.Resolve("/ip4/10.0.0.1/tcp/4001/p2p/" + bob.String())
+	require.NoError(t, err)
+	assert.Equal(t, bob, info.ID)
+
+	id, err := book.ResolveID("bob")
+	require.NoError(t, err)
+	assert.Equal(t, bob, id)
+	id, err = book.ResolveID(alice.String())
+	require.NoError(t, err)
+	assert.Equal(t, alice, id)
+	_, err = book.ResolveID("carol")
+	assert.Error(t, err)
+
+	assert.Equal(t, "alice ("+alice.String()+")", book.Label(alice))
+	assert.True(t, book.Remove("alice"))
+	assert.False(t, book.Remove("alice"))
+	assert.Equal(t, alice.String(), book.Label(alice))
+
+	var none *AddressBook
+	assert.Equal(t, bob.String(), none.Label(bob))
+	assert.False(t, none.IsVerified(bob))
+}
@@ -0,0 +1,118 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/sirupsen/logrus"
+)
+
+// Datastore backends
+const (
+	DatastoreMemory = "memory" // kept in memory, nothing survives a restart
+	DatastoreFS     = "fs"     // one file per key under datastore_path
+	DatastoreBadger = "badger" // a Badger database in datastore_path
+	DatastoreS3     = "s3"     // objects in an S3-compatible bucket
+	DatastorePlugin = "plugin" // served by an external plugin
+)
+
+// Datastore is the key-value storage behind the blob store, the peerstore
+// and the DHT. It is go-datastore's batching interface, so any of its
+// implementations plug in, and ours plug straight into libp2p.
+type Datastore interface {
+	datastore.Batching
+}
+
+// Namespaces of the node datastore
+var (
+	datastorePeers  = datastore.NewKey("/peers")
+	datastoreDHT    = datastore.NewKey("/dht")
+	datastoreAppDHT = datastore.NewKey("/appdht")
+	datastoreBlobs  = datastore.NewKey("/blobs")
+)
+
+// OpenDatastore opens the datastore backend selected in the configuration
+func OpenDatastore(cfg *Config) (Datastore, error) {
+	var store Datastore
+	var err error
+	switch cfg.Datastore {
+	case DatastoreMemory, "":
+		return NewMemoryDatastore(), nil
+	case DatastoreFS:
+		store, err = NewFSDatastore(cfg.DatastorePath)
+	case DatastoreBadger:
+		store, err = NewBadgerDatastore(cfg.DatastorePath)
+	case DatastoreS3:
+		store, err = NewS3Datastore(cfg.DatastoreS3)
+	case DatastorePlugin:
+		store, err = NewPluginDatastore(cfg.DatastorePlugin)
+	default:
+		return nil, fmt.Errorf("unknown datastore %q", cfg.Datastore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"datastore": cfg.Datastore,
+		"path":      cfg.DatastorePath,
+	}).Info("Opened datastore")
+	return store, nil
+}
+
+// NewMemoryDatastore creates an empty datastore kept in memory
+func NewMemoryDatastore() Datastore {
+	return dssync.MutexWrap(datastore.NewMapDatastore())
+}
+
+// namespaced returns the part of the datastore under prefix. Closing it
+// leaves the datastore open: the node closes that once everything using it
+// is closed.
+func namespaced(store Datastore, prefix datastore.Key) Datastore {
+	return unclosable{namespace.Wrap(store, prefix)}
+}
+
+// unclosable ignores Close
+type unclosable struct {
+	Datastore
+}
+
+func (unclosable) Close() error {
+	return nil
+}
+
+// peerstoreOption keeps the peerstore in a persistent datastore and applies
+// the configured address TTLs to it. A memory datastore gains nothing over
+// libp2p's own memory peerstore, so without TTLs it is left alone.
+func peerstoreOption(ctx context.Context, cfg *Config, store Datastore) (libp2p.Option, error) {
+	var ps peerstore.Peerstore
+	if cfg.Datastore == DatastoreMemory || cfg.Datastore == "" {
+		if len(cfg.PeerstoreAddrTTLs) == 0 {
+			return nil, nil
+		}
+		mem, err := pstoremem.NewPeerstore()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create peerstore: %w", err)
+		}
+		ps = mem
+	} else {
+		// Purge expired addresses from the datastore along with the peerstore GC
+		opts := pstoreds.DefaultOpts()
+		if cfg.PeerstoreGCInterval > 0 {
+			opts.GCPurgeInterval = time.Duration(cfg.PeerstoreGCInterval)
+		}
+		pds, err := pstoreds.NewPeerstore(ctx, namespaced(store, datastorePeers), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create peerstore: %w", err)
+		}
+		ps = pds
+	}
+	return libp2p.Peerstore(newAddrTTLPeerstore(ps, cfg.PeerstoreAddrTTLs)), nil
+}
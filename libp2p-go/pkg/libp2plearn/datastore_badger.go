@@ -0,0 +1,166 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/sirupsen/logrus"
+)
+
+// badgerGCInterval is how often space held by overwritten and deleted
+// values is reclaimed
+const badgerGCInterval = 15 * time.Minute
+
+// BadgerDatastore keeps the keys in a Badger database, which holds up better
+// than one file per key when there are many small ones
+type BadgerDatastore struct {
+	db   *badger.DB
+	done chan struct{}
+}
+
+// NewBadgerDatastore opens the Badger database in dir, creating it if needed
+func NewBadgerDatastore(dir string) (*BadgerDatastore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("datastore directory is required")
+	}
+	opts := badger.DefaultOptions(dir).
+		WithLogger(logrus.WithField("subsystem", "badger")).
+		WithLoggingLevel(badger.WARNING)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger datastore: %w", err)
+	}
+	d := &BadgerDatastore{db: db, done: make(chan struct{})}
+	go d.collectGarbage()
+	return d, nil
+}
+
+// Get returns the value of a key
+func (d *BadgerDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	var value []byte
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key.Bytes())
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	return value, badgerError(err)
+}
+
+// Has reports whether a key is set
+func (d *BadgerDatastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	_, err := d.GetSize(ctx, key)
+	if err == datastore.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetSize returns the size of the value of a key
+func (d *BadgerDatastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	size := -1
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key.Bytes())
+		if err != nil {
+			return err
+		}
+		size = int(item.ValueSize())
+		return nil
+	})
+	return size, badgerError(err)
+}
+
+// Put sets the value of a key
+func (d *BadgerDatastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key.Bytes(), value)
+	})
+}
+
+// Delete removes a key; removing a missing key is not an error
+func (d *BadgerDatastore) Delete(ctx context.Context, key datastore.Key) error {
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key.Bytes())
+	})
+}
+
+// Sync flushes written values to disk
+func (d *BadgerDatastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	return d.db.Sync()
+}
+
+// Query iterates the keys under the query prefix, with their values unless
+// only keys are wanted
+func (d *BadgerDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	prefix := datastore.NewKey(q.Prefix).String()
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	var entries []query.Entry
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix), PrefetchValues: !q.KeysOnly})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			e := query.Entry{Key: string(item.KeyCopy(nil)), Size: int(item.ValueSize())}
+			if !q.KeysOnly {
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				e.Value = value
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query badger datastore: %w", err)
+	}
+	return query.NaiveQueryApply(q, query.ResultsWithEntries(q, entries)), nil
+}
+
+// Batch returns a batch that applies its operations one by one
+func (d *BadgerDatastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
+// Close stops the garbage collection and closes the database
+func (d *BadgerDatastore) Close() error {
+	close(d.done)
+	return d.db.Close()
+}
+
+// collectGarbage reclaims value log space every badgerGCInterval until the
+// datastore is closed
+func (d *BadgerDatastore) collectGarbage() {
+	ticker := time.NewTicker(badgerGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			// Each run rewrites at most one file, so run until nothing is left
+			for d.db.RunValueLogGC(0.5) == nil {
+			}
+		}
+	}
+}
+
+// badgerError maps Badger's missing key error to the datastore's
+func badgerError(err error) error {
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return datastore.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// fsValueSuffix marks the files holding values. Dots in key segments are
+// escaped, so a value file can never clash with the directory of a longer key.
+const fsValueSuffix = ".data"
+
+// FSDatastore keeps every key in its own file under a directory: the value
+// of /a/b is in a/b.data. Values are written to a temporary file, synced and
+// renamed into place, so a crash never leaves a truncated value.
+type FSDatastore struct {
+	dir string
+}
+
+// NewFSDatastore opens the filesystem datastore in dir, creating it if needed
+func NewFSDatastore(dir string) (*FSDatastore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("datastore directory is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create datastore directory: %w", err)
+	}
+	return &FSDatastore{dir: dir}, nil
+}
+
+// Get returns the value of a key
+func (d *FSDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	data, err := os.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, datastore.ErrNotFound
+	}
+	return data, err
+}
+
+// Has reports whether a key is set
+func (d *FSDatastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	_, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetSize returns the size of the value of a key
+func (d *FSDatastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	info, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return -1, datastore.ErrNotFound
+	}
+	if err != nil {
+		return -1, err
+	}
+	return int(info.Size()), nil
+}
+
+// Put sets the value of a key
+func (d *FSDatastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create datastore directory: %w", err)
+	}
+	if err := writeFileAtomic(path, value, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes a key; removing a missing key is not an error
+func (d *FSDatastore) Delete(ctx context.Context, key datastore.Key) error {
+	if err := os.Remove(d.path(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Sync does nothing: every Put is synced before it returns
+func (d *FSDatastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	return nil
+}
+
+// Query walks the directory of the query prefix
+func (d *FSDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	root := d.dir
+	if prefix := datastore.NewKey(q.Prefix); prefix.String() != "/" {
+		root = filepath.Join(d.dir, d.segments(prefix))
+	}
+
+	var entries []query.Entry
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, fsValueSuffix) {
+			return nil
+		}
+		key, ok := d.key(path)
+		if !ok {
+			return nil
+		}
+		e := query.Entry{Key: key.String()}
+		if q.KeysOnly {
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			e.Size = int(info.Size())
+		} else {
+			if e.Value, err = os.ReadFile(path); err != nil {
+				if os.IsNotExist(err) {
+					return nil // deleted while walking
+				}
+				return err
+			}
+			e.Size = len(e.Value)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query datastore: %w", err)
+	}
+	return query.NaiveQueryApply(q, query.ResultsWithEntries(q, entries)), nil
+}
+
+// Batch returns a batch that applies its operations one by one
+func (d *FSDatastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
+// Close does nothing; there is nothing to release
+func (d *FSDatastore) Close() error {
+	return nil
+}
+
+// path returns the file holding the value of a key
+func (d *FSDatastore) path(key datastore.Key) string {
+	return filepath.Join(d.dir, d.segments(key)) + fsValueSuffix
+}
+
+// segments escapes the namespaces of a key into a relative path
+func (d *FSDatastore) segments(key datastore.Key) string {
+	var parts []string
+	for _, ns := range key.Namespaces() {
+		parts = append(parts, strings.ReplaceAll(url.PathEscape(ns), ".", "%2E"))
+	}
+	return filepath.Join(parts...)
+}
+
+// key returns the key of a value file
+func (d *FSDatastore) key(path string) (datastore.Key, bool) {
+	rel, err := filepath.Rel(d.dir, strings.TrimSuffix(path, fsValueSuffix))
+	if err != nil {
+		return datastore.Key{}, false
+	}
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		ns, err := url.PathUnescape(part)
+		if err != nil {
+			return datastore.Key{}, false
+		}
+		parts = append(parts, ns)
+	}
+	return datastore.KeyWithNamespaces(parts), true
+}
@@ -0,0 +1,121 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// Datastore operations sent to a plugin
+const (
+	pluginOpGet     = "get"
+	pluginOpHas     = "has"
+	pluginOpGetSize = "get_size"
+	pluginOpPut     = "put"
+	pluginOpDelete  = "delete"
+	pluginOpSync    = "sync"
+	pluginOpQuery   = "query"
+)
+
+// PluginDatastore keeps the node's data in an external plugin, which
+// answers one ds message per operation. The plugin runs for as long as the
+// datastore is open.
+type PluginDatastore struct {
+	plugin *Plugin
+}
+
+// NewPluginDatastore starts the plugin described by the manifest at path
+// and uses it as a datastore
+func NewPluginDatastore(path string) (*PluginDatastore, error) {
+	m, err := LoadPluginManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPlugin(nil, m)
+	if err := p.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	return &PluginDatastore{plugin: p}, nil
+}
+
+// Get returns the value of a key
+func (d *PluginDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	res, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpGet, Key: key.String()})
+	if err != nil {
+		return nil, err
+	}
+	if !res.Found {
+		return nil, datastore.ErrNotFound
+	}
+	return res.Data, nil
+}
+
+// Has reports whether a key is set
+func (d *PluginDatastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	res, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpHas, Key: key.String()})
+	if err != nil {
+		return false, err
+	}
+	return res.Found, nil
+}
+
+// GetSize returns the size of the value of a key
+func (d *PluginDatastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	res, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpGetSize, Key: key.String()})
+	if err != nil {
+		return -1, err
+	}
+	if !res.Found {
+		return -1, datastore.ErrNotFound
+	}
+	return res.Size, nil
+}
+
+// Put sets the value of a key
+func (d *PluginDatastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	if _, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpPut, Key: key.String(), Data: value}); err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes a key; removing a missing key is not an error
+func (d *PluginDatastore) Delete(ctx context.Context, key datastore.Key) error {
+	if _, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpDelete, Key: key.String()}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Sync asks the plugin to persist the keys under prefix
+func (d *PluginDatastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	_, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpSync, Prefix: prefix.String()})
+	return err
+}
+
+// Query asks the plugin for the entries under the query prefix and applies
+// the rest of the query here
+func (d *PluginDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	prefix := datastore.NewKey(q.Prefix).String()
+	res, err := d.plugin.request(ctx, &pluginMessage{Op: pluginOpQuery, Prefix: prefix, KeysOnly: q.KeysOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", prefix, err)
+	}
+	entries := make([]query.Entry, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		entries = append(entries, query.Entry{Key: datastore.NewKey(e.Key).String(), Value: e.Value, Size: e.Size})
+	}
+	return query.NaiveQueryApply(q, query.ResultsWithEntries(q, entries)), nil
+}
+
+// Batch returns a batch that applies its operations one by one
+func (d *PluginDatastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
+// Close stops the plugin
+func (d *PluginDatastore) Close() error {
+	return d.plugin.Close()
+}
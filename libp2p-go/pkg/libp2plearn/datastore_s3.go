@@ -0,0 +1,360 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+const (
+	// s3RequestTimeout bounds one S3 request
+	s3RequestTimeout = 60 * time.Second
+
+	// maxS3ErrorSize bounds how much of an error response is read
+	maxS3ErrorSize = 4096
+)
+
+// S3DatastoreConfig locates the bucket of an S3 datastore. Credentials left
+// empty are taken from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+type S3DatastoreConfig struct {
+	Endpoint     string `json:"endpoint"` // e.g. https://minio.local:9000, default AWS for the region
+	Region       string `json:"region"`
+	Bucket       string `json:"bucket"`
+	Prefix       string `json:"prefix"` // prepended to every object key
+	AccessKey    string `json:"access_key"`
+	SecretKey    string `json:"secret_key"`
+	SessionToken string `json:"session_token"`
+	PathStyle    bool   `json:"path_style"` // bucket in the path rather than the host name, as most non-AWS servers need
+}
+
+// S3Datastore keeps every key as an object in an S3-compatible bucket, so a
+// node on an ephemeral cloud instance keeps its state when the instance is
+// replaced. Requests are signed with AWS Signature Version 4.
+type S3Datastore struct {
+	cfg      S3DatastoreConfig
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Datastore creates a datastore in the configured bucket
+func NewS3Datastore(cfg S3DatastoreConfig) (*S3Datastore, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 credentials are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	if cfg.Prefix != "" {
+		cfg.Prefix += "/"
+	}
+	return &S3Datastore{cfg: cfg, endpoint: endpoint, client: &http.Client{Timeout: s3RequestTimeout}}, nil
+}
+
+// Get returns the value of a key
+func (d *S3Datastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	resp, err := d.do(ctx, http.MethodGet, d.object(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, datastore.ErrNotFound
+	}
+	if err := s3Error(resp); err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Has reports whether a key is set
+func (d *S3Datastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	_, err := d.GetSize(ctx, key)
+	if err == datastore.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetSize returns the size of the value of a key
+func (d *S3Datastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	resp, err := d.do(ctx, http.MethodHead, d.object(key), nil, nil)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return -1, datastore.ErrNotFound
+	}
+	if err := s3Error(resp); err != nil {
+		return -1, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return int(resp.ContentLength), nil
+}
+
+// Put sets the value of a key
+func (d *S3Datastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	resp, err := d.do(ctx, http.MethodPut, d.object(key), nil, value)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes a key; removing a missing key is not an error
+func (d *S3Datastore) Delete(ctx context.Context, key datastore.Key) error {
+	resp, err := d.do(ctx, http.MethodDelete, d.object(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Sync does nothing: S3 has stored an object once its PUT returns
+func (d *S3Datastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	return nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response we use
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// Query lists the objects under the query prefix, fetching their values
+// unless only keys are wanted
+func (d *S3Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	prefix := d.cfg.Prefix
+	if p := datastore.NewKey(q.Prefix); p.String() != "/" {
+		prefix += strings.TrimPrefix(p.String(), "/") + "/"
+	}
+
+	var entries []query.Entry
+	params := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := d.do(ctx, http.MethodGet, "", params, nil)
+		if err != nil {
+			return nil, err
+		}
+		var list s3ListResult
+		err = s3Error(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&list)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, obj := range list.Contents {
+			key := datastore.NewKey(strings.TrimPrefix(obj.Key, d.cfg.Prefix))
+			e := query.Entry{Key: key.String(), Size: obj.Size}
+			if !q.KeysOnly {
+				if e.Value, err = d.Get(ctx, key); err != nil {
+					if err == datastore.ErrNotFound {
+						continue // deleted while listing
+					}
+					return nil, err
+				}
+			}
+			entries = append(entries, e)
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		params.Set("continuation-token", list.NextContinuationToken)
+	}
+	return query.NaiveQueryApply(q, query.ResultsWithEntries(q, entries)), nil
+}
+
+// Batch returns a batch that applies its operations one by one
+func (d *S3Datastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
+// Close does nothing; there is nothing to release
+func (d *S3Datastore) Close() error {
+	return nil
+}
+
+// object returns the object key of a datastore key
+func (d *S3Datastore) object(key datastore.Key) string {
+	return d.cfg.Prefix + strings.TrimPrefix(key.String(), "/")
+}
+
+// do sends a signed request for an object, or for the bucket if object is empty
+func (d *S3Datastore) do(ctx context.Context, method, object string, params url.Values, body []byte) (*http.Response, error) {
+	u := *d.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if d.cfg.PathStyle {
+		path += "/" + d.cfg.Bucket
+	} else {
+		u.Host = d.cfg.Bucket + "." + u.Host
+	}
+	path += "/" + object
+	u.Path = path
+	u.RawPath = s3Escape(path, false)
+	u.RawQuery = s3Query(params)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	d.sign(req, u.RawPath, body, time.Now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 to the request
+func (d *S3Datastore) sign(req *http.Request, path string, body []byte, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if d.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := day + "/" + d.cfg.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+d.cfg.SecretKey), day)
+	key = hmacSHA256(key, d.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and
+// slashes unless escapeSlash is set, as Signature Version 4 requires
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !escapeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes query parameters sorted by name, as Signature Version 4 requires
+func s3Query(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range params[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Error turns an unsuccessful response into an error carrying the S3 error code
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxS3ErrorSize))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("%s: %s", body.Code, body.Message)
+	}
+	return fmt.Errorf("unexpected status %s", resp.Status)
+}
@@ -0,0 +1,229 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves a path-style bucket from memory, checking that requests are
+// signed and listing at most two objects per page
+func fakeS3(t *testing.T, bucket string) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") ||
+			r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+			return
+		}
+		key, ok := strings.CutPrefix(r.URL.Path, "/"+bucket+"/")
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case key == "" && r.Method == http.MethodGet:
+			var keys []string
+			for k := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			var list s3ListResult
+			if len(keys) > 2 {
+				keys = keys[:2]
+				list.IsTruncated = true
+				list.NextContinuationToken = keys[1]
+			}
+			for _, k := range keys {
+				list.Contents = append(list.Contents, struct {
+					Key  string `xml:"Key"`
+					Size int    `xml:"Size"`
+				}{k, len(objects[k])})
+			}
+			xml.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodPut:
+			objects[key] = body
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			data, ok := objects[key]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			if r.Method == http.MethodGet {
+				w.Write(data)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDatastores(t *testing.T) {
+	ctx := context.Background()
+	backends := map[string]func(t *testing.T) Datastore{
+		"Memory": func(t *testing.T) Datastore {
+			return NewMemoryDatastore()
+		},
+		"FS": func(t *testing.T) Datastore {
+			d, err := NewFSDatastore(t.TempDir())
+			require.NoError(t, err)
+			return d
+		},
+		"Badger": func(t *testing.T) Datastore {
+			d, err := NewBadgerDatastore(t.TempDir())
+			require.NoError(t, err)
+			return d
+		},
+		"S3": func(t *testing.T) Datastore {
+			srv := fakeS3(t, "bucket")
+			d, err := NewS3Datastore(S3DatastoreConfig{
+				Endpoint:  srv.URL,
+				Bucket:    "bucket",
+				Prefix:    "node1",
+				AccessKey: "test",
+				SecretKey: "secret",
+				PathStyle: true,
+			})
+			require.NoError(t, err)
+			return d
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			d := open(t)
+			defer d.Close()
+
+			key := datastore.NewKey("/blobs/blocks/bafy")
+			_, err := d.Get(ctx, key)
+			assert.ErrorIs(t, err, datastore.ErrNotFound)
+			has, err := d.Has(ctx, key)
+			require.NoError(t, err)
+			assert.False(t, has)
+
+			require.NoError(t, d.Put(ctx, key, []byte("block")))
+			value, err := d.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("block"), value)
+			size, err := d.GetSize(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, 5, size)
+
+			// A key and a longer one under it, keys with dots and escapes,
+			// and a sibling sharing the prefix as a string only
+			require.NoError(t, d.Put(ctx, datastore.NewKey("/blobs"), []byte("parent")))
+			require.NoError(t, d.Put(ctx, datastore.NewKey("/blobs/blocks/x.data/y"), []byte("dots")))
+			require.NoError(t, d.Put(ctx, datastore.NewKey("/blobs/blocks/a b%2F"), []byte("odd")))
+			require.NoError(t, d.Put(ctx, datastore.NewKey("/blobs/blocksmore"), []byte("sibling")))
+
+			results, err := d.Query(ctx, query.Query{Prefix: "/blobs/blocks"})
+			require.NoError(t, err)
+			entries, err := results.Rest()
+			require.NoError(t, err)
+			got := make(map[string]string)
+			for _, e := range entries {
+				got[e.Key] = string(e.Value)
+			}
+			assert.Equal(t, map[string]string{
+				"/blobs/blocks/bafy":     "block",
+				"/blobs/blocks/x.data/y": "dots",
+				"/blobs/blocks/a b%2F":   "odd",
+			}, got)
+
+			results, err = d.Query(ctx, query.Query{KeysOnly: true})
+			require.NoError(t, err)
+			entries, err = results.Rest()
+			require.NoError(t, err)
+			assert.Len(t, entries, 5)
+
+			require.NoError(t, d.Delete(ctx, key))
+			require.NoError(t, d.Delete(ctx, key), "deleting a missing key is fine")
+			_, err = d.Get(ctx, key)
+			assert.ErrorIs(t, err, datastore.ErrNotFound)
+		})
+	}
+
+	t.Run("S3RejectsBadCredentials", func(t *testing.T) {
+		srv := fakeS3(t, "bucket")
+		d, err := NewS3Datastore(S3DatastoreConfig{Endpoint: srv.URL, Bucket: "bucket", AccessKey: "other", SecretKey: "x", PathStyle: true})
+		require.NoError(t, err)
+		err = d.Put(ctx, datastore.NewKey("/a"), []byte("a"))
+		assert.ErrorContains(t, err, "AccessDenied")
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		_, err := NewS3Datastore(S3DatastoreConfig{AccessKey: "a", SecretKey: "b"})
+		assert.ErrorContains(t, err, "bucket is required")
+		_, err = OpenDatastore(&Config{Datastore: "bogus"})
+		assert.Error(t, err)
+	})
+}
+
+func TestNodeDatastore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.IdentityFile = filepath.Join(t.TempDir(), "node.key")
+	cfg.Datastore = DatastoreFS
+	cfg.DatastorePath = t.TempDir()
+	cfg.EnableBlobs = true
+
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	other, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	addr := multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001")
+
+	node, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	require.NoError(t, node.Start(ctx))
+	node.Host().Peerstore().AddAddr(other, addr, peerstore.PermanentAddrTTL)
+	root, err := node.AddBlob(ctx, bytes.NewReader([]byte("kept across restarts")))
+	require.NoError(t, err)
+	require.NoError(t, node.Stop(ctx))
+
+	// The peerstore and blob store come back from the datastore
+	node, err = New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node.Stop(ctx)
+	assert.Contains(t, node.Host().Peerstore().Addrs(other), addr)
+	var out bytes.Buffer
+	require.NoError(t, node.Blobs().Cat(root, &out))
+	assert.Equal(t, "kept across restarts", out.String())
+	assert.Len(t, node.Blobs().Pins(), 1)
+}
@@ -0,0 +1,142 @@
+package libp2plearn
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DialStrategySmart uses libp2p's happy-eyeballs style ranking
+	DialStrategySmart = "smart"
+
+	// DialStrategyStaggered dials QUIC, then TCP, then everything else, one address per stagger interval
+	DialStrategyStaggered = "staggered"
+
+	// DialStrategyParallel dials every address at once
+	DialStrategyParallel = "parallel"
+
+	// DialStrategyMeasured dials the addresses that performed best first,
+	// one address per stagger interval
+	DialStrategyMeasured = "measured"
+)
+
+// dialOptions returns the libp2p options for the configured dial strategy
+// and timeouts. perf ranks addresses for the measured strategy.
+func dialOptions(cfg *Config, perf *TransportPerf) []libp2p.Option {
+	opts := []libp2p.Option{
+		libp2p.WithDialTimeout(time.Duration(cfg.DialTimeout)),
+		libp2p.SwarmOpts(swarmOptions(cfg, perf)...),
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"strategy":        cfg.DialStrategy,
+		"stagger":         time.Duration(cfg.DialStagger),
+		"dial_timeout":    time.Duration(cfg.DialTimeout),
+		"connect_timeout": time.Duration(cfg.ConnectTimeout),
+	}).Debug("Dial policy configured")
+
+	return opts
+}
+
+// swarmOptions returns the swarm options derived from the configuration.
+// libp2p.SwarmOpts replaces previously set swarm options, so all of them
+// must be collected here.
+func swarmOptions(cfg *Config, perf *TransportPerf) []swarm.Option {
+	return []swarm.Option{
+		swarm.WithDialRanker(dialRanker(cfg, perf)),
+		swarm.WithDialTimeoutLocal(time.Duration(cfg.DialTimeout)),
+	}
+}
+
+// dialRanker returns the dial ranker for the configured strategy
+func dialRanker(cfg *Config, perf *TransportPerf) network.DialRanker {
+	switch cfg.DialStrategy {
+	case DialStrategyStaggered:
+		return staggeredDialRanker(time.Duration(cfg.DialStagger))
+	case DialStrategyMeasured:
+		return perf.Ranker(time.Duration(cfg.DialStagger))
+	case DialStrategyParallel:
+		return swarm.NoDelayDialRanker
+	default:
+		return swarm.DefaultDialRanker
+	}
+}
+
+// staggeredDialRanker dials direct addresses before relayed ones, preferring
+// QUIC over TCP over other transports, and starts one dial per interval
+func staggeredDialRanker(interval time.Duration) network.DialRanker {
+	return func(addrs []multiaddr.Multiaddr) []network.AddrDelay {
+		ranked := make([]multiaddr.Multiaddr, len(addrs))
+		copy(ranked, addrs)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return transportRank(ranked[i]) < transportRank(ranked[j])
+		})
+
+		delays := make([]network.AddrDelay, 0, len(ranked))
+		for i, addr := range ranked {
+			delays = append(delays, network.AddrDelay{
+				Addr:  addr,
+				Delay: time.Duration(i) * interval,
+			})
+		}
+		return delays
+	}
+}
+
+// transportRank orders addresses by dial preference (lower dials first)
+func transportRank(addr multiaddr.Multiaddr) int {
+	rank := 0
+	if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		rank += 10
+	}
+	if !manet.IsPrivateAddr(addr) {
+		rank += 5
+	}
+
+	switch {
+	case hasProtocol(addr, multiaddr.P_QUIC_V1) && !hasProtocol(addr, multiaddr.P_WEBTRANSPORT):
+		return rank
+	case hasProtocol(addr, multiaddr.P_TCP) && !hasProtocol(addr, multiaddr.P_WS) && !hasProtocol(addr, multiaddr.P_WSS):
+		return rank + 1
+	case hasProtocol(addr, multiaddr.P_WS) || hasProtocol(addr, multiaddr.P_WSS):
+		return rank + 2
+	default:
+		return rank + 3
+	}
+}
+
+// hasProtocol reports whether the multiaddr contains the given protocol
+func hasProtocol(addr multiaddr.Multiaddr, code int) bool {
+	_, err := addr.ValueForProtocol(code)
+	return err == nil
+}
+
+// transportNames are the names transportName returns for known transports
+var transportNames = []string{"tcp", "quic", "websocket", "webtransport", "webrtc", "relay"}
+
+// transportName returns a short name for the transport an address uses
+func transportName(addr multiaddr.Multiaddr) string {
+	switch {
+	case hasProtocol(addr, multiaddr.P_CIRCUIT):
+		return "relay"
+	case hasProtocol(addr, multiaddr.P_WEBTRANSPORT):
+		return "webtransport"
+	case hasProtocol(addr, multiaddr.P_WEBRTC_DIRECT):
+		return "webrtc"
+	case hasProtocol(addr, multiaddr.P_QUIC_V1):
+		return "quic"
+	case hasProtocol(addr, multiaddr.P_WS) || hasProtocol(addr, multiaddr.P_WSS):
+		return "websocket"
+	case hasProtocol(addr, multiaddr.P_TCP):
+		return "tcp"
+	default:
+		return "unknown"
+	}
+}
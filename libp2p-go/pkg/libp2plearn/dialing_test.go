@@ -0,0 +1,50 @@
+package libp2plearn
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialing(t *testing.T) {
+	t.Run("StaggeredRanking", func(t *testing.T) {
+		addrs := []multiaddr.Multiaddr{
+			multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001/ws"),
+			multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001"),
+			multiaddr.StringCast("/ip4/10.0.0.1/udp/4001/quic-v1"),
+		}
+
+		delays := staggeredDialRanker(100 * time.Millisecond)(addrs)
+		require.Len(t, delays, 3)
+
+		assert.Equal(t, addrs[2], delays[0].Addr, "QUIC should be dialed first")
+		assert.Equal(t, addrs[1], delays[1].Addr, "TCP should be dialed second")
+		assert.Equal(t, addrs[0], delays[2].Addr, "WebSocket should be dialed last")
+
+		for i, d := range delays {
+			assert.Equal(t, time.Duration(i)*100*time.Millisecond, d.Delay)
+		}
+	})
+
+	t.Run("DurationJSON", func(t *testing.T) {
+		data, err := json.Marshal(Duration(1500 * time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, `"1.5s"`, string(data))
+
+		var d Duration
+		require.NoError(t, json.Unmarshal([]byte(`"250ms"`), &d))
+		assert.Equal(t, 250*time.Millisecond, time.Duration(d))
+
+		assert.Error(t, json.Unmarshal([]byte(`"soon"`), &d))
+	})
+
+	t.Run("InvalidStrategy", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.DialStrategy = "random"
+		assert.Error(t, cfg.Validate())
+	})
+}
@@ -0,0 +1,251 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDialBudget is returned when a peer has used its dial attempts for the window
+var ErrDialBudget = errors.New("dial budget exhausted")
+
+// Reasons the dial policy refuses a dial
+const (
+	dialRefusedBackoff = "backoff"
+	dialRefusedBudget  = "budget"
+)
+
+// dialPolicyRefused counts dials the policy refused by reason
+var dialPolicyRefused = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "dial_policy",
+	Name:      "refused_total",
+	Help:      "Number of dials refused by the dial policy, by reason",
+}, []string{"reason"})
+
+// DialLimits bounds how hard the node dials peers
+type DialLimits struct {
+	// BackoffBase is how long an address is skipped after its first failed
+	// dial, doubling with each further failure up to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// MaxConcurrent caps the dials running at once (0 for no cap)
+	MaxConcurrent int
+	// PeerAttempts caps the dials to one peer per PeerWindow (0 for no cap)
+	PeerAttempts int
+	PeerWindow   time.Duration
+}
+
+// addrKey identifies an address of a peer
+type addrKey struct {
+	peer peer.ID
+	addr string
+}
+
+// addrBackoff is how often an address failed and until when it is skipped
+type addrBackoff struct {
+	failures int
+	until    time.Time
+}
+
+// DialPolicy throttles the node's own dials: failed addresses back off
+// exponentially, concurrent dials are capped and each peer gets a budget of
+// attempts per window. Connects through the wrapped host count against the
+// budget and the cap; the backoff also applies to dials libp2p makes on its
+// own, through the connection gater.
+type DialPolicy struct {
+	limits DialLimits
+	slots  chan struct{}
+
+	mu       sync.Mutex
+	backoffs map[addrKey]*addrBackoff
+	attempts map[peer.ID]*peerQuota
+}
+
+// NewDialPolicy creates a dial policy with the given limits
+func NewDialPolicy(limits DialLimits) *DialPolicy {
+	d := &DialPolicy{
+		limits:   limits,
+		backoffs: make(map[addrKey]*addrBackoff),
+		attempts: make(map[peer.ID]*peerQuota),
+	}
+	if limits.MaxConcurrent > 0 {
+		d.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return d
+}
+
+// WrapHost returns h with its Connect going through the policy
+func (d *DialPolicy) WrapHost(h host.Host) host.Host {
+	if d == nil {
+		return h
+	}
+	return &dialPolicyHost{Host: h, policy: d}
+}
+
+// Connect connects h to the peer within the limits, recording which
+// addresses failed. Already connected peers cost nothing.
+func (d *DialPolicy) Connect(ctx context.Context, h host.Host, pi peer.AddrInfo) error {
+	if h.Network().Connectedness(pi.ID) == network.Connected {
+		return h.Connect(ctx, pi)
+	}
+
+	if !d.spend(pi.ID) {
+		dialPolicyRefused.WithLabelValues(dialRefusedBudget).Inc()
+		return fmt.Errorf("failed to dial %s: %w", pi.ID, ErrDialBudget)
+	}
+
+	if d.slots != nil {
+		select {
+		case d.slots <- struct{}{}:
+			defer func() { <-d.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := h.Connect(ctx, pi)
+	var dialErr *swarm.DialError
+	if errors.As(err, &dialErr) && d.limits.BackoffBase > 0 {
+		for _, te := range dialErr.DialErrors {
+			if dialAttempted(te.Cause) {
+				d.failed(pi.ID, te.Address)
+			}
+		}
+	}
+	if err == nil {
+		for _, conn := range h.Network().ConnsToPeer(pi.ID) {
+			d.succeeded(pi.ID, conn.RemoteMultiaddr())
+		}
+	}
+	return err
+}
+
+// Allowed reports whether an address of the peer may be dialed, that is
+// whether it isn't backing off. A nil policy allows everything.
+func (d *DialPolicy) Allowed(p peer.ID, addr multiaddr.Multiaddr) bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.backoffs[addrKey{p, addr.String()}]
+	if !ok || !time.Now().Before(b.until) {
+		return true
+	}
+	dialPolicyRefused.WithLabelValues(dialRefusedBackoff).Inc()
+	return false
+}
+
+// spend uses one of the peer's attempts, reporting false when none are left
+func (d *DialPolicy) spend(p peer.ID) bool {
+	if d.limits.PeerAttempts <= 0 {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	quota, ok := d.attempts[p]
+	if !ok || now.Sub(quota.start) > d.limits.PeerWindow {
+		for id, q := range d.attempts {
+			if now.Sub(q.start) > d.limits.PeerWindow {
+				delete(d.attempts, id)
+			}
+		}
+		quota = &peerQuota{start: now}
+		d.attempts[p] = quota
+	}
+	if quota.used >= d.limits.PeerAttempts {
+		return false
+	}
+	quota.used++
+	return true
+}
+
+// failed backs the address off, twice as long as the last time. An address
+// that has stayed quiet for BackoffMax past its backoff starts over.
+func (d *DialPolicy) failed(p peer.ID, addr multiaddr.Multiaddr) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for key, b := range d.backoffs {
+		if now.After(b.until.Add(d.limits.BackoffMax)) {
+			delete(d.backoffs, key)
+		}
+	}
+
+	key := addrKey{p, addr.String()}
+	b, ok := d.backoffs[key]
+	if !ok {
+		b = &addrBackoff{}
+		d.backoffs[key] = b
+	}
+	b.failures++
+	delay := d.limits.BackoffBase
+	for i := 1; i < b.failures && delay < d.limits.BackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.limits.BackoffMax)
+	b.until = now.Add(delay)
+
+	logrus.WithFields(logrus.Fields{
+		"peer":     p,
+		"addr":     addr,
+		"failures": b.failures,
+		"backoff":  delay,
+	}).Debug("Backing off peer address")
+}
+
+// succeeded clears the address's backoff
+func (d *DialPolicy) succeeded(p peer.ID, addr multiaddr.Multiaddr) {
+	d.mu.Lock()
+	delete(d.backoffs, addrKey{p, addr.String()})
+	d.mu.Unlock()
+}
+
+// dialAttempted reports whether a dial failed with cause after reaching the
+// network, rather than being skipped by the swarm or refused by the gater
+func dialAttempted(cause error) bool {
+	return !errors.Is(cause, swarm.ErrGaterDisallowedConnection) &&
+		!errors.Is(cause, swarm.ErrDialBackoff) &&
+		!errors.Is(cause, swarm.ErrDialToSelf) &&
+		!errors.Is(cause, swarm.ErrDialRefusedBlackHole)
+}
+
+// dialPolicyHost is a host whose Connect goes through the dial policy
+type dialPolicyHost struct {
+	host.Host
+	policy *DialPolicy
+}
+
+func (h *dialPolicyHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	return h.policy.Connect(ctx, h.Host, pi)
+}
+
+// dialTimeoutHost is a host bounding how long connecting to a single peer
+// may take across all its addresses, for the dials its Connect and NewStream
+// start. Dials libp2p starts itself keep its default.
+type dialTimeoutHost struct {
+	host.Host
+	timeout time.Duration
+}
+
+func (h *dialTimeoutHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	return h.Host.Connect(network.WithDialPeerTimeout(ctx, h.timeout), pi)
+}
+
+func (h *dialTimeoutHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	return h.Host.NewStream(network.WithDialPeerTimeout(ctx, h.timeout), p, pids...)
+}
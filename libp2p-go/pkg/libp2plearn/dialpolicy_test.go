@@ -0,0 +1,145 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.DialPeerAttempts = 2
+	node, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node.Stop(ctx)
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	// Nothing listens on port 1, so the dial fails right away
+	unreachable := peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")}}
+
+	require.Error(t, node.Host().Connect(ctx, unreachable))
+
+	// The failed address backs off, so the next attempt doesn't dial it
+	before := testutil.ToFloat64(dialPolicyRefused.WithLabelValues(dialRefusedBackoff))
+	err = node.Host().Connect(ctx, unreachable)
+	var dialErr *swarm.DialError
+	require.True(t, errors.As(err, &dialErr))
+	require.Len(t, dialErr.DialErrors, 1)
+	assert.ErrorIs(t, dialErr.DialErrors[0].Cause, swarm.ErrGaterDisallowedConnection)
+	assert.Equal(t, before+1, testutil.ToFloat64(dialPolicyRefused.WithLabelValues(dialRefusedBackoff)))
+
+	// Both attempts are spent
+	assert.ErrorIs(t, node.Host().Connect(ctx, unreachable), ErrDialBudget)
+
+	t.Run("connected peers cost nothing", func(t *testing.T) {
+		other, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		defer other.Stop(ctx)
+
+		info := peer.AddrInfo{ID: other.Host().ID(), Addrs: other.Host().Addrs()}
+		for i := 0; i < 4; i++ {
+			require.NoError(t, node.Host().Connect(ctx, info))
+		}
+	})
+}
+
+func TestDialPolicyBackoff(t *testing.T) {
+	policy := NewDialPolicy(DialLimits{BackoffBase: time.Second, BackoffMax: 5 * time.Second})
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")
+	other := multiaddr.StringCast("/ip4/127.0.0.1/tcp/2")
+
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		policy.failed("peer", addr)
+		delays = append(delays, time.Until(policy.backoffs[addrKey{"peer", addr.String()}].until).Round(time.Second))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+	assert.False(t, policy.Allowed("peer", addr))
+	assert.True(t, policy.Allowed("peer", other))
+	assert.True(t, policy.Allowed("other", addr))
+
+	policy.succeeded("peer", addr)
+	assert.True(t, policy.Allowed("peer", addr))
+
+	t.Run("budget refills after the window", func(t *testing.T) {
+		policy := NewDialPolicy(DialLimits{PeerAttempts: 1, PeerWindow: time.Hour})
+		assert.True(t, policy.spend("peer"))
+		assert.False(t, policy.spend("peer"))
+		assert.True(t, policy.spend("other"))
+
+		policy.attempts["peer"].start = time.Now().Add(-2 * time.Hour)
+		assert.True(t, policy.spend("peer"))
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.DialBackoffMax = Duration(time.Second)
+		assert.Error(t, cfg.Validate())
+
+		cfg = testNodeConfig()
+		cfg.DialPeerWindow = 0
+		assert.Error(t, cfg.Validate())
+
+		cfg = testNodeConfig()
+		cfg.DialBackoffBase = 0
+		cfg.DialMaxConcurrent = 0
+		cfg.DialPeerAttempts = 0
+		assert.NoError(t, cfg.Validate())
+		assert.False(t, cfg.DialLimited())
+	})
+}
+
+func TestConnectTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.ConnectTimeout = Duration(300 * time.Millisecond)
+	node, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node.Stop(ctx)
+
+	// The listener accepts but never answers, so the handshake hangs
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/" + fmt.Sprint(listener.Addr().(*net.TCPAddr).Port))
+
+	start := time.Now()
+	require.Error(t, node.Host().Connect(ctx, peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{addr}}))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 60*time.Second, network.DialPeerTimeout, "the timeout must not leak to other hosts")
+}
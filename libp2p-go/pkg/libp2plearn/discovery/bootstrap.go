@@ -1,4 +1,4 @@
-package main
+package discovery
 
 import (
 	"context"
@@ -8,11 +8,12 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/sirupsen/logrus"
 )
 
-// bootstrapPeers connects to the given bootstrap peers
-func bootstrapPeers(ctx context.Context, h host.Host, peers []string) error {
+// BootstrapPeers connects to the given bootstrap peers
+func BootstrapPeers(ctx context.Context, h host.Host, peers []string) error {
 	if len(peers) == 0 {
 		return nil
 	}
@@ -24,7 +25,7 @@ func bootstrapPeers(ctx context.Context, h host.Host, peers []string) error {
 		wg.Add(1)
 		go func(addr string) {
 			defer wg.Done()
-			if err := connectToPeer(ctx, h, addr); err != nil {
+			if err := ConnectToPeer(ctx, h, addr); err != nil {
 				logrus.WithError(err).WithField("peer", addr).Error("Failed to connect to bootstrap peer")
 			}
 		}(peerAddr)
@@ -35,8 +36,8 @@ func bootstrapPeers(ctx context.Context, h host.Host, peers []string) error {
 	return nil
 }
 
-// connectToPeer connects to a single peer
-func connectToPeer(ctx context.Context, h host.Host, peerAddr string) error {
+// ConnectToPeer connects to a single peer
+func ConnectToPeer(ctx context.Context, h host.Host, peerAddr string) error {
 	addr, err := multiaddr.NewMultiaddr(peerAddr)
 	if err != nil {
 		return fmt.Errorf("invalid multiaddr %s: %w", peerAddr, err)
@@ -55,13 +56,29 @@ func connectToPeer(ctx context.Context, h host.Host, peerAddr string) error {
 	return nil
 }
 
+// LocalBootstrapPeers returns the bootstrap peers reachable without the
+// internet: those on private IP addresses or local domain names, such as
+// static peers in a lab. Invalid addresses are kept, to fail as before.
+func LocalBootstrapPeers(peers []string) []string {
+	var local []string
+	for _, peerAddr := range peers {
+		addr, err := multiaddr.NewMultiaddr(peerAddr)
+		if err == nil && manet.IsPublicAddr(addr) {
+			logrus.WithField("peer", peerAddr).Debug("Offline, skipping public bootstrap peer")
+			continue
+		}
+		local = append(local, peerAddr)
+	}
+	return local
+}
+
 // getConnectedPeers returns information about currently connected peers
 func getConnectedPeers(h host.Host) []peer.ID {
 	return h.Network().Peers()
 }
 
-// printPeerInfo displays information about connected peers
-func printPeerInfo(h host.Host) {
+// PrintPeerInfo displays information about connected peers
+func PrintPeerInfo(h host.Host) {
 	peers := getConnectedPeers(h)
 	logrus.WithField("count", len(peers)).Info("Connected peers")
 
@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of DHT operations
+const (
+	dhtSuccess  = "success"
+	dhtNotFound = "not_found"
+	dhtTimeout  = "timeout"
+	dhtCanceled = "canceled"
+	dhtError    = "error"
+)
+
+// dhtQueryDuration records how long DHT operations take, from 10ms to ~40s
+var dhtQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "dht",
+	Name:      "query_duration_seconds",
+	Help:      "Duration of DHT operations",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 13),
+}, []string{"op"})
+
+// dhtQueries counts DHT operations by outcome
+var dhtQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "dht",
+	Name:      "queries_total",
+	Help:      "Number of DHT operations by outcome",
+}, []string{"op", "outcome"})
+
+// DHT is the Kademlia DHT with its queries instrumented: each records its
+// duration in libp2p_learn_dht_query_duration_seconds and its outcome in
+// libp2p_learn_dht_queries_total. Everything else is the embedded DHT's.
+// With the queue enabled, writes made without DHT peers wait for them.
+type DHT struct {
+	*dht.IpfsDHT
+	queue *dhtQueue
+}
+
+// GetValue looks up the best value of a key
+func (d *DHT) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	start := time.Now()
+	value, err := d.IpfsDHT.GetValue(ctx, key, opts...)
+	observeDHT("get_value", start, err)
+	return value, err
+}
+
+// SearchValue streams ever better values of a key
+func (d *DHT) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	start := time.Now()
+	ch, err := d.IpfsDHT.SearchValue(ctx, key, opts...)
+	if err != nil {
+		observeDHT("search_value", start, err)
+		return nil, err
+	}
+	return observeDHTStream(ctx, "search_value", start, ch), nil
+}
+
+// PutValue stores a value under a key on the closest peers
+func (d *DHT) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	if len(opts) == 0 && d.deferred() {
+		return d.queuePutValue(key, value)
+	}
+	start := time.Now()
+	err := d.IpfsDHT.PutValue(ctx, key, value, opts...)
+	observeDHT("put_value", start, err)
+	return err
+}
+
+// FindPeer looks up the addresses of a peer
+func (d *DHT) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	start := time.Now()
+	info, err := d.IpfsDHT.FindPeer(ctx, id)
+	observeDHT("find_peer", start, err)
+	return info, err
+}
+
+// Provide announces that this node provides a key
+func (d *DHT) Provide(ctx context.Context, key cid.Cid, brdcst bool) error {
+	if brdcst && d.deferred() {
+		return d.queueProvide(key)
+	}
+	start := time.Now()
+	err := d.IpfsDHT.Provide(ctx, key, brdcst)
+	observeDHT("provide", start, err)
+	return err
+}
+
+// FindProviders looks up the providers of a key
+func (d *DHT) FindProviders(ctx context.Context, key cid.Cid) ([]peer.AddrInfo, error) {
+	start := time.Now()
+	providers, err := d.IpfsDHT.FindProviders(ctx, key)
+	if err == nil && len(providers) == 0 {
+		observeDHT("find_providers", start, routing.ErrNotFound)
+	} else {
+		observeDHT("find_providers", start, err)
+	}
+	return providers, err
+}
+
+// FindProvidersAsync streams up to count providers of a key, or all of them
+// if count is 0
+func (d *DHT) FindProvidersAsync(ctx context.Context, key cid.Cid, count int) <-chan peer.AddrInfo {
+	start := time.Now()
+	return observeDHTStream(ctx, "find_providers", start, d.IpfsDHT.FindProvidersAsync(ctx, key, count))
+}
+
+// GetClosestPeers looks up the peers closest to a key
+func (d *DHT) GetClosestPeers(ctx context.Context, key string) ([]peer.ID, error) {
+	start := time.Now()
+	peers, err := d.IpfsDHT.GetClosestPeers(ctx, key)
+	observeDHT("get_closest_peers", start, err)
+	return peers, err
+}
+
+// observeDHT records an operation that started at start and ended with err
+func observeDHT(op string, start time.Time, err error) {
+	dhtQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	dhtQueries.WithLabelValues(op, dhtOutcome(err)).Inc()
+}
+
+// observeDHTStream forwards the results of a streaming operation and records
+// it once the stream ends: finding anything counts as success. Results the
+// caller no longer reads after ctx is done are dropped.
+func observeDHTStream[T any](ctx context.Context, op string, start time.Time, ch <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		found := false
+		for v := range ch {
+			found = true
+			select {
+			case out <- v:
+			case <-ctx.Done():
+			}
+		}
+		var err error
+		if !found {
+			if err = ctx.Err(); err == nil {
+				err = routing.ErrNotFound
+			}
+		}
+		observeDHT(op, start, err)
+	}()
+	return out
+}
+
+// dhtOutcome classifies the error an operation ended with
+func dhtOutcome(err error) string {
+	switch {
+	case err == nil:
+		return dhtSuccess
+	case errors.Is(err, routing.ErrNotFound):
+		return dhtNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return dhtTimeout
+	case errors.Is(err, context.Canceled):
+		return dhtCanceled
+	default:
+		return dhtError
+	}
+}
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHTMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	nodes, dhts := newTestDHTs(t, ctx, 2)
+	connect(t, ctx, nodes[0], nodes[1])
+	require.Eventually(t, func() bool {
+		return dhts[0].RoutingTable().Size() > 0 && dhts[1].RoutingTable().Size() > 0
+	}, 10*time.Second, 100*time.Millisecond)
+
+	queries := func(op, outcome string) float64 {
+		return testutil.ToFloat64(dhtQueries.WithLabelValues(op, outcome))
+	}
+	observed := func(op string) uint64 {
+		var m dto.Metric
+		require.NoError(t, dhtQueryDuration.WithLabelValues(op).(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	t.Run("Provide", func(t *testing.T) {
+		key := testCid(t, "provided")
+		provides, found := queries("provide", dhtSuccess), queries("find_providers", dhtSuccess)
+		provideCount := observed("provide")
+
+		require.NoError(t, dhts[0].Provide(ctx, key, true))
+		assert.Equal(t, provides+1, queries("provide", dhtSuccess))
+		assert.Equal(t, provideCount+1, observed("provide"))
+
+		var providers []peer.AddrInfo
+		for info := range dhts[1].FindProvidersAsync(ctx, key, 1) {
+			providers = append(providers, info)
+		}
+		require.Len(t, providers, 1)
+		assert.Equal(t, nodes[0].ID(), providers[0].ID)
+		assert.Equal(t, found+1, queries("find_providers", dhtSuccess))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		key := testCid(t, "nobody")
+		before := queries("find_providers", dhtNotFound)
+		for range dhts[1].FindProvidersAsync(ctx, key, 0) {
+			t.Fatal("nobody provides the key")
+		}
+		assert.Equal(t, before+1, queries("find_providers", dhtNotFound))
+	})
+
+	t.Run("FindPeer", func(t *testing.T) {
+		before := queries("find_peer", dhtSuccess)
+		info, err := dhts[1].FindPeer(ctx, nodes[0].ID())
+		require.NoError(t, err)
+		assert.Equal(t, nodes[0].ID(), info.ID)
+		assert.Equal(t, before+1, queries("find_peer", dhtSuccess))
+	})
+
+	t.Run("Outcomes", func(t *testing.T) {
+		assert.Equal(t, dhtSuccess, dhtOutcome(nil))
+		assert.Equal(t, dhtNotFound, dhtOutcome(fmt.Errorf("lookup: %w", routing.ErrNotFound)))
+		assert.Equal(t, dhtTimeout, dhtOutcome(context.DeadlineExceeded))
+		assert.Equal(t, dhtCanceled, dhtOutcome(context.Canceled))
+		assert.Equal(t, dhtError, dhtOutcome(errors.New("failed to find any peer in table")))
+	})
+}
@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/sirupsen/logrus"
+)
+
+// DHT modes. In the auto modes the DHT serves queries while AutoNAT finds
+// the node publicly reachable and is a client otherwise; auto-server also
+// serves while reachability is unknown. client and server force the mode.
+const (
+	DHTModeAuto       = "auto"
+	DHTModeAutoServer = "auto-server"
+	DHTModeClient     = "client"
+	DHTModeServer     = "server"
+)
+
+// EvtDHTModeChanged is emitted on the host event bus when the DHT starts or
+// stops serving queries. Mode is DHTModeServer or DHTModeClient, and
+// Reachability what AutoNAT last reported.
+type EvtDHTModeChanged struct {
+	Mode         string
+	Reachability network.Reachability
+}
+
+// ModeOption returns the DHT option of a configured mode
+func ModeOption(mode string) dht.Option {
+	switch mode {
+	case DHTModeAutoServer:
+		return dht.Mode(dht.ModeAutoServer)
+	case DHTModeClient:
+		return dht.Mode(dht.ModeClient)
+	case DHTModeServer:
+		return dht.Mode(dht.ModeServer)
+	}
+	return dht.Mode(dht.ModeAuto)
+}
+
+// ModeOf returns whether the host's DHT is serving queries, which it does
+// by handling the DHT protocol
+func ModeOf(h host.Host) string {
+	if slices.Contains(h.Mux().Protocols(), dht.ProtocolDHT) {
+		return DHTModeServer
+	}
+	return DHTModeClient
+}
+
+// WatchMode emits EvtDHTModeChanged whenever the DHT switches mode, until
+// ctx is done. sub must deliver EvtLocalProtocolsUpdated, which the host
+// emits as the DHT adds or removes its handler, and
+// EvtLocalReachabilityChanged.
+func WatchMode(ctx context.Context, h host.Host, sub event.Subscription, emitter event.Emitter) {
+	defer sub.Close()
+	defer emitter.Close()
+
+	mode := ModeOf(h)
+	reachability := network.ReachabilityUnknown
+	logrus.WithField("mode", mode).Info("DHT mode")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			if evt, ok := e.(event.EvtLocalReachabilityChanged); ok {
+				reachability = evt.Reachability
+			}
+			if current := ModeOf(h); current != mode {
+				mode = current
+				logrus.WithFields(logrus.Fields{
+					"mode":         mode,
+					"reachability": reachability,
+				}).Info("DHT mode changed")
+				if err := emitter.Emit(EvtDHTModeChanged{Mode: mode, Reachability: reachability}); err != nil {
+					logrus.WithError(err).Debug("Failed to emit DHT mode change")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHTMode(t *testing.T) {
+	// newDHT starts a DHT in mode, and returns an emitter of reachability
+	// changes and the mode changes it reports
+	newDHT := func(t *testing.T, ctx context.Context, mode string) (event.Emitter, <-chan interface{}) {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+
+		d, err := dht.New(ctx, h, ModeOption(mode))
+		require.NoError(t, err)
+		t.Cleanup(func() { d.Close() })
+
+		sub, err := h.EventBus().Subscribe([]interface{}{
+			new(event.EvtLocalProtocolsUpdated),
+			new(event.EvtLocalReachabilityChanged),
+		})
+		require.NoError(t, err)
+		emitter, err := h.EventBus().Emitter(new(EvtDHTModeChanged))
+		require.NoError(t, err)
+		changes, err := h.EventBus().Subscribe(new(EvtDHTModeChanged))
+		require.NoError(t, err)
+		t.Cleanup(func() { changes.Close() })
+		go WatchMode(ctx, h, sub, emitter)
+
+		reachability, err := h.EventBus().Emitter(new(event.EvtLocalReachabilityChanged))
+		require.NoError(t, err)
+		t.Cleanup(func() { reachability.Close() })
+		return reachability, changes.Out()
+	}
+	next := func(t *testing.T, changes <-chan interface{}) EvtDHTModeChanged {
+		select {
+		case e := <-changes:
+			return e.(EvtDHTModeChanged)
+		case <-time.After(5 * time.Second):
+			t.Fatal("DHT mode didn't change")
+			return EvtDHTModeChanged{}
+		}
+	}
+
+	t.Run("FollowsReachability", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		reachability, changes := newDHT(t, ctx, DHTModeAuto)
+
+		require.NoError(t, reachability.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPublic}))
+		assert.Equal(t, EvtDHTModeChanged{Mode: DHTModeServer, Reachability: network.ReachabilityPublic}, next(t, changes))
+
+		require.NoError(t, reachability.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPrivate}))
+		assert.Equal(t, EvtDHTModeChanged{Mode: DHTModeClient, Reachability: network.ReachabilityPrivate}, next(t, changes))
+	})
+
+	t.Run("Forced", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		reachability, changes := newDHT(t, ctx, DHTModeClient)
+
+		require.NoError(t, reachability.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPublic}))
+		select {
+		case e := <-changes:
+			t.Fatalf("forced DHT mode changed: %v", e)
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// dhtQueueLimit bounds the operations waiting for DHT peers
+	dhtQueueLimit = 1024
+
+	// dhtQueueInterval is how often the routing table is checked for peers
+	dhtQueueInterval = 5 * time.Second
+
+	// dhtQueueTimeout bounds running one queued operation
+	dhtQueueTimeout = time.Minute
+)
+
+// dhtQueued is the number of DHT operations waiting for peers
+var dhtQueued = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "libp2p_learn",
+	Subsystem: "dht",
+	Name:      "queued_operations",
+	Help:      "Number of DHT writes waiting for the routing table to have peers",
+})
+
+// ErrDHTQueueFull is returned for DHT writes that can't be queued
+var ErrDHTQueueFull = errors.New("DHT queue full")
+
+// dhtQueue holds the DHT writes made while the routing table is empty, to
+// run them once it has peers. Each key is queued once, with its latest
+// value.
+type dhtQueue struct {
+	mu    sync.Mutex
+	keys  []string
+	ops   map[string]func(context.Context, *DHT) error
+	ready chan struct{}
+}
+
+// newDHTQueue creates an empty queue
+func newDHTQueue() *dhtQueue {
+	return &dhtQueue{
+		ops:   make(map[string]func(context.Context, *DHT) error),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// add queues op under key, replacing the one already queued there
+func (q *dhtQueue) add(key string, op func(context.Context, *DHT) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.ops[key]; !ok {
+		if len(q.keys) >= dhtQueueLimit {
+			return ErrDHTQueueFull
+		}
+		q.keys = append(q.keys, key)
+		dhtQueued.Inc()
+	}
+	q.ops[key] = op
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take removes and returns the queued operations, oldest first
+func (q *dhtQueue) take() ([]string, []func(context.Context, *DHT) error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	keys := q.keys
+	ops := make([]func(context.Context, *DHT) error, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, q.ops[key])
+	}
+	q.keys = nil
+	clear(q.ops)
+	dhtQueued.Sub(float64(len(keys)))
+	return keys, ops
+}
+
+// len returns the number of queued operations
+func (q *dhtQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.keys)
+}
+
+// EnableQueue makes Provide and PutValue queue their operation and return
+// nil while the routing table is empty, instead of failing for want of
+// peers. Run the queued operations with RunQueue.
+func (d *DHT) EnableQueue() {
+	d.queue = newDHTQueue()
+}
+
+// Pending returns the number of operations waiting for DHT peers
+func (d *DHT) Pending() int {
+	if d.queue == nil {
+		return 0
+	}
+	return d.queue.len()
+}
+
+// RunQueue runs the queued operations whenever the routing table has peers,
+// until ctx is done. Operations that fail are logged and dropped.
+func (d *DHT) RunQueue(ctx context.Context) {
+	if d.queue == nil {
+		return
+	}
+	ticker := time.NewTicker(dhtQueueInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.queue.ready:
+		}
+		if d.queue.len() == 0 || d.RoutingTable().Size() == 0 {
+			continue
+		}
+
+		keys, ops := d.queue.take()
+		logrus.WithField("count", len(ops)).Info("DHT has peers, running queued operations")
+		for i, op := range ops {
+			opCtx, cancel := context.WithTimeout(ctx, dhtQueueTimeout)
+			err := op(opCtx, d)
+			cancel()
+			if err != nil {
+				logrus.WithError(err).WithField("key", keys[i]).Warn("Queued DHT operation failed")
+			}
+		}
+	}
+}
+
+// deferred reports whether DHT writes must be queued rather than run
+func (d *DHT) deferred() bool {
+	return d.queue != nil && d.RoutingTable().Size() == 0
+}
+
+// queueProvide queues announcing a key
+func (d *DHT) queueProvide(key cid.Cid) error {
+	logrus.WithField("cid", key).Debug("No DHT peers, queueing provide")
+	return d.queue.add("provide/"+key.String(), func(ctx context.Context, d *DHT) error {
+		return d.Provide(ctx, key, true)
+	})
+}
+
+// queuePutValue queues storing a value
+func (d *DHT) queuePutValue(key string, value []byte) error {
+	logrus.WithField("key", key).Debug("No DHT peers, queueing put")
+	return d.queue.add("put/"+key, func(ctx context.Context, d *DHT) error {
+		return d.PutValue(ctx, key, value)
+	})
+}
@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDHTQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	nodes, dhts := newTestDHTs(t, ctx, 2)
+	dhts[0].EnableQueue()
+
+	// Without peers, provides are queued once per key
+	key := testCid(t, "queued")
+	require.NoError(t, dhts[0].Provide(ctx, key, true))
+	require.NoError(t, dhts[0].Provide(ctx, key, true))
+	assert.Equal(t, 1, dhts[0].Pending())
+	assert.Equal(t, 0, dhts[1].Pending())
+
+	go dhts[0].RunQueue(ctx)
+	connect(t, ctx, nodes[0], nodes[1])
+	require.Eventually(t, func() bool {
+		return dhts[0].Pending() == 0
+	}, 20*time.Second, 100*time.Millisecond)
+
+	providers, err := dhts[1].FindProviders(ctx, key)
+	require.NoError(t, err)
+	require.Len(t, providers, 1)
+	assert.Equal(t, nodes[0].ID(), providers[0].ID)
+}
+
+func TestLocalBootstrapPeers(t *testing.T) {
+	peers := []string{
+		"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+		"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/ip4/192.168.1.20/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/dns4/lab-node.local/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	}
+	assert.Equal(t, peers[2:], LocalBootstrapPeers(peers))
+	var public []string
+	for _, addr := range dht.DefaultBootstrapPeers {
+		public = append(public, addr.String())
+	}
+	assert.Empty(t, LocalBootstrapPeers(public))
+}
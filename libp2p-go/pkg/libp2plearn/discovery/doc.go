@@ -0,0 +1,7 @@
+// Package discovery finds and connects to peers: bootstrap peers, peers on
+// the local network over mDNS, and the Kademlia DHT, wrapped to record
+// metrics and to queue writes made before the routing table has peers.
+//
+// It depends on libp2p only, so it can be used on any libp2p host; the
+// libp2plearn node wires it up from its Config.
+package discovery
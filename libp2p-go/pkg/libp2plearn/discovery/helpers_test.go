@@ -0,0 +1,44 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDHTs starts count hosts on localhost, each with a DHT server
+func newTestDHTs(t *testing.T, ctx context.Context, count int) ([]host.Host, []*DHT) {
+	nodes := make([]host.Host, count)
+	dhts := make([]*DHT, count)
+	for i := range nodes {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		nodes[i] = h
+
+		kademliaDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeServer))
+		require.NoError(t, err)
+		t.Cleanup(func() { kademliaDHT.Close() })
+		dhts[i] = &DHT{IpfsDHT: kademliaDHT}
+	}
+	return nodes, dhts
+}
+
+// connect connects from to to
+func connect(t *testing.T, ctx context.Context, from, to host.Host) {
+	require.NoError(t, from.Connect(ctx, peer.AddrInfo{ID: to.ID(), Addrs: to.Addrs()}))
+}
+
+// testCid returns the CID of a raw block holding s
+func testCid(t *testing.T, s string) cid.Cid {
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
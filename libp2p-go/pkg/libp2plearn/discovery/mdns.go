@@ -0,0 +1,292 @@
+package discovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// mdnsServiceName is what libp2p peers are browsed as
+	mdnsServiceName = "_p2p._udp.local."
+
+	// mdnsDNSAddrPrefix prefixes each address in a TXT record
+	mdnsDNSAddrPrefix = "dnsaddr="
+
+	// mdnsTTL is how long answers may be cached, in seconds
+	mdnsTTL = 120
+
+	// mdnsMaxAddrs bounds the addresses in one answer, to keep it in one packet
+	mdnsMaxAddrs = 10
+
+	// mdnsConnectTimeout bounds connecting to a discovered peer
+	mdnsConnectTimeout = 10 * time.Second
+)
+
+// mdnsGroup is the IPv4 multicast DNS group
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// MDNS finds peers on the local network with multicast DNS and connects to
+// them, following the libp2p mDNS discovery spec: peers are browsed as
+// _p2p._udp.local, with their addresses in dnsaddr TXT records
+type MDNS struct {
+	host     host.Host
+	name     string
+	listener *net.UDPConn
+	sender   *net.UDPConn
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	found map[peer.ID]time.Time
+}
+
+// NewMDNS starts answering queries and asking for peers every interval
+func NewMDNS(h host.Host, interval time.Duration) (*MDNS, error) {
+	listener, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join the mDNS group: %w", err)
+	}
+	sender, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to open mDNS socket: %w", err)
+	}
+	// Announce under a random instance name, as the spec asks
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &MDNS{
+		host:     h,
+		name:     hex.EncodeToString(id) + "." + mdnsServiceName,
+		listener: listener,
+		sender:   sender,
+		cancel:   cancel,
+		found:    make(map[peer.ID]time.Time),
+	}
+	m.wg.Add(3)
+	go m.read(ctx, listener)
+	go m.read(ctx, sender)
+	go m.query(ctx, interval)
+	logrus.Info("mDNS discovery started")
+	return m, nil
+}
+
+// Close stops discovery
+func (m *MDNS) Close() {
+	m.cancel()
+	m.listener.Close()
+	m.sender.Close()
+	m.wg.Wait()
+}
+
+// Peers returns the peers found on the local network whose answers haven't
+// expired
+func (m *MDNS) Peers() []peer.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var peers []peer.ID
+	for id, seen := range m.found {
+		if time.Since(seen) > mdnsTTL*time.Second {
+			delete(m.found, id)
+			continue
+		}
+		peers = append(peers, id)
+	}
+	return peers
+}
+
+// query announces us and asks for peers now and every interval
+func (m *MDNS) query(ctx context.Context, interval time.Duration) {
+	defer m.wg.Done()
+	if answer, err := m.answer(); err == nil {
+		m.sender.WriteTo(answer, mdnsGroup)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		query, err := mdnsQuery()
+		if err == nil {
+			_, err = m.sender.WriteTo(query, mdnsGroup)
+		}
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Debug("Failed to send mDNS query")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read handles the packets arriving on conn until it is closed
+func (m *MDNS) read(ctx context.Context, conn *net.UDPConn) {
+	defer m.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		if !msg.Header.Response {
+			m.handleQuery(&msg, from)
+			continue
+		}
+		for _, info := range mdnsPeers(&msg) {
+			if info.ID != m.host.ID() {
+				m.mu.Lock()
+				m.found[info.ID] = time.Now()
+				m.mu.Unlock()
+				go m.connect(ctx, info)
+			}
+		}
+	}
+}
+
+// handleQuery answers a query for libp2p peers. Queries from port 5353 are
+// answered to the group, others to the asker only, as RFC 6762 asks.
+func (m *MDNS) handleQuery(msg *dnsmessage.Message, from *net.UDPAddr) {
+	for _, q := range msg.Questions {
+		if q.Name.String() != mdnsServiceName || (q.Type != dnsmessage.TypePTR && q.Type != dnsmessage.TypeALL) {
+			continue
+		}
+		answer, err := m.answer()
+		if err != nil {
+			logrus.WithError(err).Debug("Failed to build mDNS answer")
+			return
+		}
+		to := mdnsGroup
+		if from.Port != mdnsGroup.Port {
+			to = from
+		}
+		m.sender.WriteTo(answer, to)
+		return
+	}
+}
+
+// connect connects to a discovered peer unless already connected
+func (m *MDNS) connect(ctx context.Context, info peer.AddrInfo) {
+	if m.host.Network().Connectedness(info.ID) == network.Connected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, mdnsConnectTimeout)
+	defer cancel()
+	if err := m.host.Connect(ctx, info); err != nil {
+		logrus.WithError(err).WithField("peer", info.ID).Debug("Failed to connect to peer found by mDNS")
+		return
+	}
+	logrus.WithField("peer", info.ID).Info("Connected to peer found by mDNS")
+}
+
+// answer builds the answer listing our addresses
+func (m *MDNS) answer() ([]byte, error) {
+	var txt []string
+	for _, addr := range m.host.Addrs() {
+		if len(txt) == mdnsMaxAddrs {
+			break
+		}
+		if !mdnsSuitable(addr) {
+			continue
+		}
+		txt = append(txt, fmt.Sprintf("%s%s/p2p/%s", mdnsDNSAddrPrefix, addr, m.host.ID()))
+	}
+	if len(txt) == 0 {
+		return nil, fmt.Errorf("no addresses to announce")
+	}
+	return mdnsAnswer(m.name, txt)
+}
+
+// mdnsSuitable reports whether an address is worth announcing on the local
+// network: a direct IP address
+func mdnsSuitable(addr multiaddr.Multiaddr) bool {
+	if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return false
+	}
+	_, err := manet.ToIP(addr)
+	return err == nil
+}
+
+// mdnsQuery builds a query for libp2p peers
+func mdnsQuery() ([]byte, error) {
+	name, err := dnsmessage.NewName(mdnsServiceName)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// mdnsAnswer builds an answer pointing the service at instance, whose TXT
+// record holds the dnsaddr entries
+func mdnsAnswer(instance string, txt []string) ([]byte, error) {
+	service, err := dnsmessage.NewName(mdnsServiceName)
+	if err != nil {
+		return nil, err
+	}
+	name, err := dnsmessage.NewName(instance)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: mdnsTTL},
+			Body:   &dnsmessage.PTRResource{PTR: name},
+		}},
+		Additionals: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: mdnsTTL},
+			Body:   &dnsmessage.TXTResource{TXT: txt},
+		}},
+	}
+	return msg.Pack()
+}
+
+// mdnsPeers returns the peers whose addresses an answer lists
+func mdnsPeers(msg *dnsmessage.Message) []peer.AddrInfo {
+	var addrs []multiaddr.Multiaddr
+	for _, res := range append(msg.Answers, msg.Additionals...) {
+		txt, ok := res.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		for _, entry := range txt.TXT {
+			s, ok := strings.CutPrefix(entry, mdnsDNSAddrPrefix)
+			if !ok {
+				continue
+			}
+			addr, err := multiaddr.NewMultiaddr(s)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil
+	}
+	return infos
+}
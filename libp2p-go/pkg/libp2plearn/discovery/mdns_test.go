@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestMDNSMessages(t *testing.T) {
+	id, err := peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	require.NoError(t, err)
+	txt := []string{
+		fmt.Sprintf("%s/ip4/192.168.1.20/tcp/4001/p2p/%s", mdnsDNSAddrPrefix, id),
+		fmt.Sprintf("%s/ip4/192.168.1.20/udp/4001/quic-v1/p2p/%s", mdnsDNSAddrPrefix, id),
+		"unrelated=entry",
+	}
+
+	packed, err := mdnsAnswer("abc123."+mdnsServiceName, txt)
+	require.NoError(t, err)
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(packed))
+	assert.True(t, msg.Header.Response)
+
+	infos := mdnsPeers(&msg)
+	require.Len(t, infos, 1)
+	assert.Equal(t, id, infos[0].ID)
+	assert.Len(t, infos[0].Addrs, 2)
+
+	packed, err = mdnsQuery()
+	require.NoError(t, err)
+	require.NoError(t, msg.Unpack(packed))
+	assert.False(t, msg.Header.Response)
+	require.Len(t, msg.Questions, 1)
+	assert.Equal(t, mdnsServiceName, msg.Questions[0].Name.String())
+	assert.Equal(t, dnsmessage.TypePTR, msg.Questions[0].Type)
+}
+
+func TestMDNSSuitable(t *testing.T) {
+	assert.True(t, mdnsSuitable(multiaddr.StringCast("/ip4/192.168.1.20/tcp/4001")))
+	assert.True(t, mdnsSuitable(multiaddr.StringCast("/ip6/fe80::1/udp/4001/quic-v1")))
+	assert.False(t, mdnsSuitable(multiaddr.StringCast("/dns4/example.com/tcp/4001")))
+	assert.False(t, mdnsSuitable(multiaddr.StringCast("/ip4/203.0.113.7/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit")))
+}
@@ -0,0 +1,295 @@
+package libp2plearn
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// diversityTag protects peers kept for a transport minimum, and tags
+	// peers over the subnet cap
+	diversityTag = "diversity"
+
+	// diversityPenalty is the tag of peers over the subnet cap, below any
+	// activity score, so trimming drops them first
+	diversityPenalty = -1000
+)
+
+// DiversityPolicy keeps connected peers spread over transports and networks,
+// so no single network can crowd out everyone else, as in an eclipse attack
+type DiversityPolicy struct {
+	MinPerTransport map[string]int // peers kept connected over each transport, by transportName
+	MaxSubnetShare  float64        // largest share of peers from one IPv4 /16 or IPv6 /32 (0 for no cap)
+}
+
+// diversityPeer is what the policy looks at of a connected peer
+type diversityPeer struct {
+	id         peer.ID
+	transports []string
+	subnet     string    // empty for private addresses, which the cap doesn't count
+	opened     time.Time // when its oldest connection opened
+}
+
+// subnetOf returns the /16 of a public IPv4 address or the /32 of a public
+// IPv6 one, and "" for anything else
+func subnetOf(addr multiaddr.Multiaddr) string {
+	if !manet.IsPublicAddr(addr) {
+		return ""
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
+
+// subnetCap returns how many of total peers may come from one subnet
+func subnetCap(share float64, total int) int {
+	return max(1, int(share*float64(total)))
+}
+
+// planDiversity returns the peers to protect for the transport minimums and
+// the peers over the subnet cap. The longest connected peers are kept first,
+// so peers that flood in later from one network are the ones trimmed.
+func planDiversity(peers []diversityPeer, policy DiversityPolicy) (protect, penalize map[peer.ID]bool) {
+	peers = slices.Clone(peers)
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].opened.Before(peers[j].opened)
+	})
+
+	protect = make(map[peer.ID]bool)
+	kept := make(map[string]int)
+	for _, p := range peers {
+		for _, t := range p.transports {
+			if kept[t] < policy.MinPerTransport[t] {
+				kept[t]++
+				protect[p.id] = true
+			}
+		}
+	}
+
+	penalize = make(map[peer.ID]bool)
+	if policy.MaxSubnetShare <= 0 {
+		return protect, penalize
+	}
+	limit := subnetCap(policy.MaxSubnetShare, len(peers))
+	counts := make(map[string]int)
+	for _, p := range peers {
+		if p.subnet == "" {
+			continue
+		}
+		counts[p.subnet]++
+		if counts[p.subnet] > limit && !protect[p.id] {
+			penalize[p.id] = true
+		}
+	}
+	return protect, penalize
+}
+
+// Diversity applies a DiversityPolicy to the connection manager, protecting
+// peers for the transport minimums and tagging peers over the subnet cap so
+// trimming drops them first. Filter applies it to dial targets.
+type Diversity struct {
+	host   host.Host
+	policy DiversityPolicy
+	kick   chan struct{}
+	done   chan struct{}
+
+	mu        sync.Mutex
+	protected map[peer.ID]bool
+	penalized map[peer.ID]bool
+}
+
+// NewDiversity starts applying the policy as peers connect and disconnect
+func NewDiversity(h host.Host, policy DiversityPolicy) *Diversity {
+	d := &Diversity{
+		host:      h,
+		policy:    policy,
+		kick:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		protected: make(map[peer.ID]bool),
+		penalized: make(map[peer.ID]bool),
+	}
+	h.Network().Notify(d)
+	go d.run()
+
+	logrus.WithFields(logrus.Fields{
+		"min_per_transport": policy.MinPerTransport,
+		"max_subnet_share":  policy.MaxSubnetShare,
+	}).Info("Peer diversity enabled")
+	return d
+}
+
+// Close stops applying the policy
+func (d *Diversity) Close() {
+	d.host.Network().StopNotify(d)
+	close(d.done)
+}
+
+// run rebalances whenever the connected peers change
+func (d *Diversity) run() {
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-d.kick:
+			d.rebalance()
+		}
+	}
+}
+
+// peers describes the connected peers
+func (d *Diversity) peers() []diversityPeer {
+	var peers []diversityPeer
+	for _, p := range d.host.Network().Peers() {
+		conns := d.host.Network().ConnsToPeer(p)
+		if len(conns) == 0 {
+			continue
+		}
+		dp := diversityPeer{id: p, opened: conns[0].Stat().Opened}
+		for _, c := range conns {
+			if t := transportName(c.RemoteMultiaddr()); !slices.Contains(dp.transports, t) {
+				dp.transports = append(dp.transports, t)
+			}
+			if c.Stat().Opened.Before(dp.opened) {
+				dp.opened = c.Stat().Opened
+			}
+			if dp.subnet == "" {
+				dp.subnet = subnetOf(c.RemoteMultiaddr())
+			}
+		}
+		peers = append(peers, dp)
+	}
+	return peers
+}
+
+// rebalance protects and tags peers according to the policy
+func (d *Diversity) rebalance() {
+	protect, penalize := planDiversity(d.peers(), d.policy)
+	cm := d.host.ConnManager()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for p := range d.protected {
+		if !protect[p] {
+			cm.Unprotect(p, diversityTag)
+		}
+	}
+	for p := range protect {
+		if !d.protected[p] {
+			cm.Protect(p, diversityTag)
+		}
+	}
+	for p := range d.penalized {
+		if !penalize[p] {
+			cm.UntagPeer(p, diversityTag)
+		}
+	}
+	for p := range penalize {
+		if !d.penalized[p] {
+			logrus.WithField("peer", p).Debug("Peer is over the subnet cap, trimming it first")
+			cm.TagPeer(p, diversityTag, diversityPenalty)
+		}
+	}
+	d.protected, d.penalized = protect, penalize
+}
+
+// Protected reports whether a peer is kept for a transport minimum
+func (d *Diversity) Protected(p peer.ID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.protected[p]
+}
+
+// Penalized reports whether a peer is over the subnet cap
+func (d *Diversity) Penalized(p peer.ID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.penalized[p]
+}
+
+// Filter orders dial targets so those reachable over a transport below its
+// minimum come first, and drops those whose subnet would go over the cap
+// counting the connected peers and the targets kept before them. A nil
+// Diversity returns the targets as they are.
+func (d *Diversity) Filter(targets []peer.AddrInfo) []peer.AddrInfo {
+	if d == nil {
+		return targets
+	}
+
+	peers := d.peers()
+	transports := make(map[string]int)
+	subnets := make(map[string]int)
+	for _, p := range peers {
+		for _, t := range p.transports {
+			transports[t]++
+		}
+		if p.subnet != "" {
+			subnets[p.subnet]++
+		}
+	}
+
+	total := len(peers)
+	var wanted, rest []peer.AddrInfo
+	for _, info := range targets {
+		subnet, needed := "", false
+		for _, addr := range info.Addrs {
+			if subnet == "" {
+				subnet = subnetOf(addr)
+			}
+			t := transportName(addr)
+			needed = needed || transports[t] < d.policy.MinPerTransport[t]
+		}
+
+		if subnet != "" && d.policy.MaxSubnetShare > 0 {
+			if subnets[subnet]+1 > subnetCap(d.policy.MaxSubnetShare, total+1) {
+				logrus.WithFields(logrus.Fields{
+					"peer":   info.ID,
+					"subnet": subnet,
+				}).Debug("Skipping dial target over the subnet cap")
+				continue
+			}
+			subnets[subnet]++
+		}
+		total++
+		if needed {
+			wanted = append(wanted, info)
+		} else {
+			rest = append(rest, info)
+		}
+	}
+	return append(wanted, rest...)
+}
+
+// Connected rebalances once a peer connects
+func (d *Diversity) Connected(network.Network, network.Conn) {
+	d.rebalanceLater()
+}
+
+// Disconnected rebalances once a peer disconnects
+func (d *Diversity) Disconnected(network.Network, network.Conn) {
+	d.rebalanceLater()
+}
+
+func (d *Diversity) rebalanceLater() {
+	select {
+	case d.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Diversity) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (d *Diversity) ListenClose(network.Network, multiaddr.Multiaddr) {}
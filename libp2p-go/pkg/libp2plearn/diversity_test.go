@@ -0,0 +1,100 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubnetOf(t *testing.T) {
+	assert.Equal(t, "8.8.0.0/16", subnetOf(multiaddr.StringCast("/ip4/8.8.4.4/tcp/4001")))
+	assert.Equal(t, "2001:4860::/32", subnetOf(multiaddr.StringCast("/ip6/2001:4860:4860::8888/udp/4001/quic-v1")))
+	assert.Empty(t, subnetOf(multiaddr.StringCast("/ip4/192.168.1.5/tcp/4001")))
+	assert.Empty(t, subnetOf(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")))
+}
+
+func TestPlanDiversity(t *testing.T) {
+	start := time.Now()
+	peers := []diversityPeer{
+		{id: "a", transports: []string{"tcp"}, subnet: "8.8.0.0/16", opened: start},
+		{id: "b", transports: []string{"tcp"}, subnet: "8.8.0.0/16", opened: start.Add(time.Second)},
+		{id: "c", transports: []string{"quic"}, subnet: "8.8.0.0/16", opened: start.Add(2 * time.Second)},
+		{id: "d", transports: []string{"tcp"}, subnet: "1.1.0.0/16", opened: start.Add(3 * time.Second)},
+		{id: "e", transports: []string{"tcp"}, subnet: "", opened: start.Add(4 * time.Second)},
+	}
+
+	protect, penalize := planDiversity(peers, DiversityPolicy{
+		MinPerTransport: map[string]int{"quic": 1, "tcp": 1},
+		MaxSubnetShare:  0.2,
+	})
+
+	// The oldest peer of each transport is kept
+	assert.Equal(t, map[peer.ID]bool{"a": true, "c": true}, protect)
+	// One of five peers may come from a subnet: b is over the cap, while c
+	// is kept for QUIC
+	assert.Equal(t, map[peer.ID]bool{"b": true}, penalize)
+}
+
+func TestDiversity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+	remote, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer remote.Close()
+
+	diversity := NewDiversity(h, DiversityPolicy{
+		MinPerTransport: map[string]int{"tcp": 1},
+		MaxSubnetShare:  0.5,
+	})
+	defer diversity.Close()
+
+	t.Run("ProtectsTransportMinimum", func(t *testing.T) {
+		var tcp []multiaddr.Multiaddr
+		for _, addr := range remote.Addrs() {
+			if transportName(addr) == "tcp" {
+				tcp = append(tcp, addr)
+			}
+		}
+		require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: remote.ID(), Addrs: tcp}))
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return diversity.Protected(remote.ID())
+		}, 5*time.Second, 50*time.Millisecond))
+		assert.True(t, h.ConnManager().IsProtected(remote.ID(), diversityTag))
+		assert.False(t, diversity.Penalized(remote.ID()))
+	})
+
+	t.Run("FiltersDialTargets", func(t *testing.T) {
+		target := func(id, addr string) peer.AddrInfo {
+			return peer.AddrInfo{ID: peer.ID(id), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast(addr)}}
+		}
+		targets := diversity.Filter([]peer.AddrInfo{
+			target("x1", "/ip4/8.8.1.1/tcp/4001"),
+			target("x2", "/ip4/8.8.2.2/tcp/4001"),
+			target("y", "/ip4/1.1.1.1/udp/4001/quic-v1"),
+			target("x3", "/ip4/8.8.3.3/tcp/4001"),
+			target("lan", "/ip4/192.168.1.5/tcp/4001"),
+		})
+
+		var ids []peer.ID
+		for _, info := range targets {
+			ids = append(ids, info.ID)
+		}
+		// With the connected peer, half of the peers may come from 8.8.0.0/16
+		assert.Equal(t, []peer.ID{"x1", "y", "x3", "lan"}, ids)
+	})
+
+	t.Run("NilPassesThrough", func(t *testing.T) {
+		var none *Diversity
+		targets := []peer.AddrInfo{{ID: "a"}}
+		assert.Equal(t, targets, none.Filter(targets))
+	})
+}
@@ -0,0 +1,32 @@
+// Package libp2plearn is an embeddable libp2p node with TCP, QUIC and
+// WebSocket transports, hole punching, a Kademlia DHT and a set of custom
+// protocols (ping, chat, echo and goodbye).
+//
+// A node is created with New, configured through a Config and functional
+// options, and runs until Stop:
+//
+//	node, err := libp2plearn.New(
+//		libp2plearn.WithListenPort(4001),
+//		libp2plearn.WithBootstrapPeers(addr),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	if err := node.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer node.Stop(context.Background())
+//
+//	reply, err := node.Protocols().SendPing(ctx, peerID, "hello")
+//
+// The services the node wires together (Failover, Multipath, Throttle, QoS,
+// Gateway, HTTPService and others) are exported as well and can be used on
+// any libp2p host.
+//
+// Peer discovery lives in the discovery subpackage. The node, its
+// configuration and its protocols stay in this package with the services
+// because they all depend on each other: Config holds the policy types of
+// most services, ProtocolHandler is built on several of them (secure chat,
+// compression, latency tracking, the protocol cache), and Node wires them
+// together. Separate packages would import each other in a cycle.
+package libp2plearn
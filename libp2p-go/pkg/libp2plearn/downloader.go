@@ -0,0 +1,423 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// downloadInitialWindow is how many chunks a new provider is asked for at once
+	downloadInitialWindow = 2
+
+	// downloadMaxWindow bounds how many chunks one provider is asked for at once
+	downloadMaxWindow = 16
+
+	// downloadFirstTimeout bounds a provider's requests until its rate is known
+	downloadFirstTimeout = 10 * time.Second
+
+	// downloadMinTimeout is the shortest time a request gets before it counts as stalled
+	downloadMinTimeout = 2 * time.Second
+
+	// downloadStallFactor is how many times its expected duration a request
+	// may take before it counts as stalled
+	downloadStallFactor = 4
+
+	// downloadRateSmoothing weighs the newest chunk in a provider's rate
+	downloadRateSmoothing = 0.3
+
+	// downloadMaxRequesters is how many providers may be asked for the same
+	// chunk once nothing else is left, so one slow provider can't hold up
+	// the end of a download
+	downloadMaxRequesters = 2
+
+	// downloadMaxFailures is how many failed requests in a row drop a provider
+	downloadMaxFailures = 3
+
+	// downloadMaxProviders bounds how many providers are taken from the router
+	downloadMaxProviders = 8
+)
+
+// Downloader fetches blob content from a swarm of providers. Providers come
+// from a content router, such as the DHT, and from the caller. Each provider
+// is asked for a window of chunks at once: the window grows while its chunks
+// keep arriving at its usual rate and shrinks when they slow down or fail.
+// A request taking several times longer than the provider's rate predicts is
+// abandoned and the chunk goes to another provider.
+type Downloader struct {
+	store  *BlobStore
+	router routing.ContentDiscovery
+
+	firstTimeout time.Duration
+}
+
+// NewDownloader creates a downloader into the store. router may be nil, in
+// which case only the providers passed to Download are used.
+func NewDownloader(store *BlobStore, router routing.ContentDiscovery) *Downloader {
+	return &Downloader{store: store, router: router, firstTimeout: downloadFirstTimeout}
+}
+
+// Download fetches the content under root into the store. The given
+// providers are tried first, then up to downloadMaxProviders found by the router
+// as they turn up.
+func (d *Downloader) Download(ctx context.Context, root cid.Cid, providers ...peer.ID) error {
+	d.store.gcMu.RLock()
+	defer d.store.gcMu.RUnlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := d.findProviders(ctx, root, providers)
+
+	manifest, peers, err := d.manifest(ctx, root, found)
+	if err != nil {
+		return err
+	}
+
+	dl := &download{
+		Downloader: d,
+		manifest:   manifest,
+		done:       make([]bool, len(manifest.Chunks)),
+		requests:   make(map[int]map[peer.ID]context.CancelFunc),
+		peers:      make(map[peer.ID]*downloadPeer),
+		results:    make(chan downloadResult),
+	}
+	for i, c := range manifest.Chunks {
+		if d.store.Has(c) {
+			dl.done[i] = true
+			continue
+		}
+		dl.pending = append(dl.pending, i)
+		dl.remaining++
+	}
+	if dl.remaining == 0 {
+		return nil
+	}
+	for _, p := range peers {
+		dl.addPeer(p)
+	}
+
+	start := time.Now()
+	if err := dl.run(ctx, found); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"cid":       root,
+		"size":      manifest.Size,
+		"providers": len(dl.peers),
+		"duration":  time.Since(start).Round(time.Millisecond),
+	}).Info("Fetched blob")
+	return nil
+}
+
+// findProviders streams the given providers, then the ones the router finds
+func (d *Downloader) findProviders(ctx context.Context, root cid.Cid, providers []peer.ID) <-chan peer.ID {
+	out := make(chan peer.ID)
+	go func() {
+		defer close(out)
+		self := d.store.host.ID()
+		seen := map[peer.ID]bool{self: true}
+		send := func(p peer.ID) bool {
+			if seen[p] {
+				return true
+			}
+			seen[p] = true
+			select {
+			case out <- p:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, p := range providers {
+			if !send(p) {
+				return
+			}
+		}
+		if d.router == nil {
+			return
+		}
+		for info := range d.router.FindProvidersAsync(ctx, root, downloadMaxProviders) {
+			if !seen[info.ID] {
+				d.store.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+			}
+			if !send(info.ID) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// manifest returns the manifest of root from the store, or else from the
+// first provider that has it, along with that provider
+func (d *Downloader) manifest(ctx context.Context, root cid.Cid, found <-chan peer.ID) (*BlobManifest, []peer.ID, error) {
+	if manifest, err := d.store.Manifest(root); err == nil {
+		return manifest, nil, nil
+	}
+	if root.Prefix().Codec != cid.DagJSON {
+		return nil, nil, fmt.Errorf("%s is not a blob manifest", root)
+	}
+
+	err := fmt.Errorf("no providers found")
+	for p := range found {
+		var data []byte
+		data, err = fetchBlock(ctx, d.store.host, p, root, -1)
+		if err != nil {
+			logrus.WithError(err).WithField("peer", p).Debug("Failed to fetch blob manifest")
+			continue
+		}
+		var manifest *BlobManifest
+		if manifest, err = parseBlobManifest(root, data); err != nil {
+			continue
+		}
+		if _, err = d.store.put(cid.DagJSON, data); err != nil {
+			return nil, nil, err
+		}
+		return manifest, []peer.ID{p}, nil
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return nil, nil, fmt.Errorf("failed to fetch manifest: %w", err)
+}
+
+// downloadPeer is what a download knows about one provider
+type downloadPeer struct {
+	id       peer.ID
+	window   int     // chunks it may be asked for at once
+	inflight int     // chunks it is being asked for
+	rate     float64 // smoothed bytes per second of one request, 0 until known
+	failures int     // failed or stalled requests in a row
+}
+
+// timeout returns how long a request for size bytes may take before it
+// counts as stalled
+func (p *downloadPeer) timeout(size int, first time.Duration) time.Duration {
+	if p.rate == 0 {
+		return first
+	}
+	expected := time.Duration(float64(size) / p.rate * float64(time.Second))
+	return min(max(expected*downloadStallFactor, downloadMinTimeout), blobRequestTimeout)
+}
+
+// succeeded widens the window if the chunk came at the usual rate and
+// narrows it if the provider is slowing down
+func (p *downloadPeer) succeeded(size int, elapsed time.Duration) {
+	rate := float64(size) / max(elapsed.Seconds(), 1e-3)
+	switch {
+	case p.rate == 0:
+		p.rate = rate
+	case rate >= 0.8*p.rate:
+		p.window = min(p.window+1, downloadMaxWindow)
+		p.rate += downloadRateSmoothing * (rate - p.rate)
+	default:
+		p.window = max(p.window-1, 1)
+		p.rate += downloadRateSmoothing * (rate - p.rate)
+	}
+	p.failures = 0
+}
+
+// failed halves the window after a failed or stalled request
+func (p *downloadPeer) failed() {
+	p.window = max(p.window/2, 1)
+	p.failures++
+}
+
+// downloadResult is the outcome of one chunk request
+type downloadResult struct {
+	peer    peer.ID
+	chunk   int
+	data    []byte
+	err     error
+	stalled bool
+	elapsed time.Duration
+}
+
+// download is the state of one Download, owned by its run loop
+type download struct {
+	*Downloader
+	manifest  *BlobManifest
+	pending   []int // chunks no provider is being asked for, in order
+	done      []bool
+	remaining int
+	requests  map[int]map[peer.ID]context.CancelFunc // chunk -> providers being asked for it
+	active    int
+	peers     map[peer.ID]*downloadPeer
+	results   chan downloadResult
+}
+
+// run requests chunks until all are stored, adding providers as they are found
+func (dl *download) run(ctx context.Context, found <-chan peer.ID) error {
+	defer func() {
+		for _, reqs := range dl.requests {
+			for _, cancel := range reqs {
+				cancel()
+			}
+		}
+	}()
+
+	for dl.remaining > 0 {
+		dl.dispatch(ctx)
+		if dl.active == 0 && found == nil {
+			return fmt.Errorf("no provider could supply %d of %d chunks", dl.remaining, len(dl.manifest.Chunks))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-found:
+			if !ok {
+				found = nil
+				continue
+			}
+			dl.addPeer(p)
+		case res := <-dl.results:
+			if err := dl.handle(res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (dl *download) addPeer(p peer.ID) {
+	if _, ok := dl.peers[p]; !ok {
+		dl.peers[p] = &downloadPeer{id: p, window: downloadInitialWindow}
+	}
+}
+
+// dispatch fills the windows of the providers, fastest first. Until their
+// rates are known, providers with the lowest round-trip time go first.
+func (dl *download) dispatch(ctx context.Context) {
+	peers := make([]*downloadPeer, 0, len(dl.peers))
+	for _, p := range dl.peers {
+		peers = append(peers, p)
+	}
+	metrics := dl.store.host.Peerstore()
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].rate != peers[j].rate {
+			return peers[i].rate > peers[j].rate
+		}
+		return fasterPeer(metrics, peers[i].id, peers[j].id)
+	})
+
+	for _, p := range peers {
+		for p.inflight < p.window {
+			i, ok := dl.next(p.id)
+			if !ok {
+				break
+			}
+			dl.request(ctx, p, i)
+		}
+	}
+}
+
+// next picks the chunk to ask a provider for: the first pending one or, once
+// none are left, one that is taking long elsewhere
+func (dl *download) next(p peer.ID) (int, bool) {
+	for len(dl.pending) > 0 {
+		i := dl.pending[0]
+		dl.pending = dl.pending[1:]
+		if !dl.done[i] {
+			return i, true
+		}
+	}
+	for i, reqs := range dl.requests {
+		if _, asked := reqs[p]; !asked && len(reqs) < downloadMaxRequesters && !dl.done[i] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// request asks a provider for a chunk in the background
+func (dl *download) request(ctx context.Context, p *downloadPeer, i int) {
+	c, size := dl.manifest.Chunks[i], dl.manifest.Sizes[i]
+	reqCtx, cancel := context.WithTimeout(ctx, p.timeout(size, dl.firstTimeout))
+	if dl.requests[i] == nil {
+		dl.requests[i] = make(map[peer.ID]context.CancelFunc)
+	}
+	dl.requests[i][p.id] = cancel
+	p.inflight++
+	dl.active++
+
+	go func() {
+		start := time.Now()
+		data, err := fetchBlock(reqCtx, dl.store.host, p.id, c, size)
+		res := downloadResult{
+			peer:    p.id,
+			chunk:   i,
+			data:    data,
+			err:     err,
+			stalled: errors.Is(reqCtx.Err(), context.DeadlineExceeded),
+			elapsed: time.Since(start),
+		}
+		cancel()
+		select {
+		case dl.results <- res:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// handle records the outcome of a chunk request
+func (dl *download) handle(res downloadResult) error {
+	dl.active--
+	delete(dl.requests[res.chunk], res.peer)
+	if len(dl.requests[res.chunk]) == 0 {
+		delete(dl.requests, res.chunk)
+	}
+	p := dl.peers[res.peer]
+	if p != nil {
+		p.inflight--
+	}
+	if dl.done[res.chunk] {
+		// Another provider delivered it first
+		return nil
+	}
+
+	if res.err == nil {
+		if _, err := dl.store.put(cid.Raw, res.data); err != nil {
+			return err
+		}
+		dl.done[res.chunk] = true
+		dl.remaining--
+		for _, cancel := range dl.requests[res.chunk] {
+			cancel()
+		}
+		if p != nil {
+			p.succeeded(len(res.data), res.elapsed)
+		}
+		return nil
+	}
+
+	if p != nil {
+		p.failed()
+		log := logrus.WithError(res.err).WithFields(logrus.Fields{
+			"peer":    res.peer,
+			"chunk":   res.chunk,
+			"stalled": res.stalled,
+			"window":  p.window,
+		})
+		if p.failures >= downloadMaxFailures {
+			delete(dl.peers, res.peer)
+			log.Debug("Dropped blob provider")
+		} else {
+			log.Debug("Failed to fetch blob chunk")
+		}
+	}
+	if len(dl.requests[res.chunk]) == 0 {
+		// Nobody else is on it: first in line for the next provider
+		dl.pending = append([]int{res.chunk}, dl.pending...)
+	}
+	return nil
+}
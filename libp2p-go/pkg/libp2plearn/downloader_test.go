@@ -0,0 +1,145 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticRouter finds the same providers for every CID
+type staticRouter []peer.AddrInfo
+
+func (r staticRouter) FindProvidersAsync(ctx context.Context, _ cid.Cid, _ int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, len(r))
+	for _, info := range r {
+		out <- info
+	}
+	close(out)
+	return out
+}
+
+func TestDownloadPeer(t *testing.T) {
+	p := &downloadPeer{window: downloadInitialWindow}
+	assert.Equal(t, time.Second, p.timeout(blobAvgChunk, time.Second), "the first timeout applies until the rate is known")
+
+	p.succeeded(1<<20, time.Second)
+	assert.InDelta(t, 1<<20, p.rate, 1)
+	assert.Equal(t, downloadMinTimeout, p.timeout(1024, time.Second))
+	assert.Equal(t, 4*time.Second, p.timeout(1<<20, time.Second))
+
+	// Chunks at the usual rate widen the window, slower ones narrow it
+	p.succeeded(1<<20, time.Second)
+	assert.Equal(t, downloadInitialWindow+1, p.window)
+	p.succeeded(1<<20, 4*time.Second)
+	assert.Equal(t, downloadInitialWindow, p.window)
+	assert.Less(t, p.rate, float64(1<<20))
+
+	for range 20 {
+		p.succeeded(1<<20, time.Millisecond)
+	}
+	assert.Equal(t, downloadMaxWindow, p.window)
+
+	p.failed()
+	assert.Equal(t, downloadMaxWindow/2, p.window)
+	assert.Equal(t, 1, p.failures)
+	p.succeeded(1<<20, time.Millisecond)
+	assert.Zero(t, p.failures)
+}
+
+func TestDownloader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	content := make([]byte, 4*blobMaxChunk)
+	rand.New(rand.NewSource(3)).Read(content)
+
+	// store creates a node with a blob store holding content
+	store := func(t *testing.T) (*BlobStore, cid.Cid) {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), BlobChunkerFixed)
+		require.NoError(t, err)
+		t.Cleanup(bs.Close)
+		root, err := bs.Add(bytes.NewReader(content))
+		require.NoError(t, err)
+		return bs, root
+	}
+
+	// check verifies that bs holds content under root
+	check := func(t *testing.T, bs *BlobStore, root cid.Cid) {
+		var out bytes.Buffer
+		require.NoError(t, bs.Cat(root, &out))
+		assert.Equal(t, content, out.Bytes())
+	}
+
+	full, root := store(t)
+	empty := func(t *testing.T) *BlobStore {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		bs, err := NewBlobStore(h, NewMemoryDatastore(), BlobChunkerFixed)
+		require.NoError(t, err)
+		t.Cleanup(bs.Close)
+		return bs
+	}
+
+	t.Run("FindsProvidersThroughRouter", func(t *testing.T) {
+		bs := empty(t)
+		router := staticRouter{{ID: full.host.ID(), Addrs: full.host.Addrs()}}
+		require.NoError(t, NewDownloader(bs, router).Download(ctx, root))
+		check(t, bs, root)
+	})
+
+	t.Run("FallsBackFromStalledPeer", func(t *testing.T) {
+		// A provider that hands out the manifest but never answers for chunks
+		staller, _ := store(t)
+		staller.host.SetStreamHandler(protocol.ID(BlobProtocol), func(s network.Stream) {
+			line, err := bufio.NewReader(s).ReadSlice('\n')
+			if err != nil {
+				s.Reset()
+				return
+			}
+			var req blobRequest
+			json.Unmarshal(line, &req)
+			if req.CID != root.String() {
+				io.Copy(io.Discard, s)
+				return
+			}
+			data, _ := staller.Block(root)
+			header, _ := json.Marshal(blobResponse{Size: len(data)})
+			s.Write(append(header, '\n'))
+			s.Write(data)
+			s.Close()
+		})
+
+		bs := empty(t)
+		require.NoError(t, connectNodes(ctx, bs.host, staller.host))
+		require.NoError(t, connectNodes(ctx, bs.host, full.host))
+		d := NewDownloader(bs, nil)
+		d.firstTimeout = 300 * time.Millisecond
+
+		start := time.Now()
+		require.NoError(t, d.Download(ctx, root, staller.host.ID(), full.host.ID()))
+		assert.Less(t, time.Since(start), 10*time.Second)
+		check(t, bs, root)
+	})
+
+	t.Run("NoProviders", func(t *testing.T) {
+		bs := empty(t)
+		err := NewDownloader(bs, staticRouter{}).Download(ctx, root)
+		assert.ErrorContains(t, err, "no providers found")
+	})
+}
@@ -0,0 +1,311 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EchoV2Protocol echoes payloads in checksummed chunks followed by a digest of the whole
+	EchoV2Protocol = "/libp2p-learn/echo/2.0.0"
+
+	// echoChunkSize is how much data the client puts in one chunk
+	echoChunkSize = 16 * 1024
+
+	// maxEchoChunkSize bounds the data in one chunk
+	maxEchoChunkSize = 64 * 1024
+
+	// echoRejectTimeout bounds waiting for a rejected client to stop sending
+	echoRejectTimeout = 10 * time.Second
+)
+
+// Echo v2 frame types. Each frame is a type byte, a big-endian uint32
+// length and the payload.
+const (
+	echoFrameData  byte = iota + 1 // CRC-32C of the data as uint32, then the data
+	echoFrameEnd                   // total length as uint64, then the SHA-256 of all data
+	echoFrameError                 // why the payload was rejected, as text
+)
+
+// echoCRCTable is the Castagnoli table chunk checksums are computed with
+var echoCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// EchoResult describes a verified echo v2 exchange
+type EchoResult struct {
+	Bytes  int64
+	Digest []byte // SHA-256 of the payload
+}
+
+// handleEchoV2 echoes every verified chunk as it arrives, without buffering
+// the payload, then confirms the total length and digest
+func (p *ProtocolHandler) handleEchoV2(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	logrus.WithField("peer", remote).Debug("Received echo v2 connection")
+
+	reader := bufio.NewReader(s)
+	limit := p.echoMaxSize.Load()
+	digest := sha256.New()
+	var total int64
+
+	// Closing while the client still sends would reset the stream and lose
+	// the error, so discard the rest until the client gives up
+	reject := func(err error) {
+		logrus.WithError(err).WithField("peer", remote).Warn("Rejected echo payload")
+		writeEchoFrame(s, echoFrameError, []byte(err.Error()))
+		s.CloseWrite()
+		s.SetReadDeadline(time.Now().Add(echoRejectTimeout))
+		io.Copy(io.Discard, reader)
+	}
+
+	for {
+		typ, payload, err := readEchoFrame(reader)
+		if err != nil {
+			logrus.WithError(err).WithField("peer", remote).Debug("Echo v2 stream ended early")
+			s.Reset()
+			return
+		}
+
+		switch typ {
+		case echoFrameData:
+			data, err := verifyEchoChunk(payload)
+			if err != nil {
+				ReportMisbehavior(p.host, remote, MisbehaviorProtocolError, "corrupt echo chunk")
+				reject(err)
+				return
+			}
+			total += int64(len(data))
+			if limit > 0 && total > limit {
+				ReportMisbehavior(p.host, remote, MisbehaviorRateLimit, "echo payload too large")
+				reject(fmt.Errorf("payload exceeds the limit of %d bytes", limit))
+				return
+			}
+			digest.Write(data)
+			if err := writeEchoFrame(s, echoFrameData, payload); err != nil {
+				logrus.WithError(err).WithField("peer", remote).Debug("Failed to echo chunk")
+				s.Reset()
+				return
+			}
+
+		case echoFrameEnd:
+			if err := checkEchoEnd(payload, total, digest); err != nil {
+				reject(err)
+				return
+			}
+			writeEchoFrame(s, echoFrameEnd, payload)
+			logrus.WithFields(logrus.Fields{
+				"peer":  remote,
+				"bytes": total,
+			}).Info("Handled echo request")
+			return
+
+		default:
+			ReportMisbehavior(p.host, remote, MisbehaviorProtocolError, "unexpected echo frame")
+			reject(fmt.Errorf("unexpected echo frame type %d", typ))
+			return
+		}
+	}
+}
+
+// EchoStream sends everything read from r to a peer's echo v2 service and
+// writes the echoed data to w as it arrives. Every chunk is checked against
+// its checksum and the whole payload against the digest both sides computed.
+func (p *ProtocolHandler) EchoStream(ctx context.Context, peerID peer.ID, r io.Reader, w io.Writer) (EchoResult, error) {
+	s, err := p.newStream(ctx, peerID, echoChunkSize, protocol.ID(EchoV2Protocol))
+	if err != nil {
+		return EchoResult{}, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	return echoV2(ctx, s, r, w)
+}
+
+// echoV2 runs the client side of an echo v2 exchange on an open stream
+func echoV2(ctx context.Context, s network.Stream, r io.Reader, w io.Writer) (EchoResult, error) {
+	// Interrupt blocked reads and writes when ctx is done
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	// Send while receiving, so large payloads never fill both directions' windows
+	type sendResult struct {
+		total  int64
+		digest []byte
+		err    error
+	}
+	sent := make(chan sendResult, 1)
+	go func() {
+		total, digest, err := sendEchoPayload(s, r)
+		sent <- sendResult{total, digest, err}
+	}()
+
+	fail := func(err error) (EchoResult, error) {
+		s.Reset()
+		<-sent
+		if ctx.Err() != nil {
+			return EchoResult{}, ctx.Err()
+		}
+		return EchoResult{}, err
+	}
+
+	reader := bufio.NewReader(s)
+	digest := sha256.New()
+	var received int64
+	for {
+		typ, payload, err := readEchoFrame(reader)
+		if err != nil {
+			return fail(fmt.Errorf("failed to read echo: %w", err))
+		}
+
+		switch typ {
+		case echoFrameData:
+			data, err := verifyEchoChunk(payload)
+			if err != nil {
+				return fail(err)
+			}
+			received += int64(len(data))
+			digest.Write(data)
+			if _, err := w.Write(data); err != nil {
+				return fail(fmt.Errorf("failed to write echoed data: %w", err))
+			}
+
+		case echoFrameError:
+			return fail(fmt.Errorf("peer rejected echo: %s", payload))
+
+		case echoFrameEnd:
+			result := <-sent
+			if result.err != nil {
+				return EchoResult{}, result.err
+			}
+			if err := checkEchoEnd(payload, received, digest); err != nil {
+				return EchoResult{}, err
+			}
+			if received != result.total || !bytes.Equal(digest.Sum(nil), result.digest) {
+				return EchoResult{}, fmt.Errorf("echoed data differs from the data sent")
+			}
+			return EchoResult{Bytes: received, Digest: result.digest}, nil
+
+		default:
+			return fail(fmt.Errorf("unexpected echo frame type %d", typ))
+		}
+	}
+}
+
+// sendEchoPayload sends r in checksummed chunks followed by the end frame,
+// then closes the stream for writing
+func sendEchoPayload(s network.Stream, r io.Reader) (int64, []byte, error) {
+	digest := sha256.New()
+	var total int64
+	buf := make([]byte, 4+echoChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			data := buf[4 : 4+n]
+			binary.BigEndian.PutUint32(buf[:4], crc32.Checksum(data, echoCRCTable))
+			if werr := writeEchoFrame(s, echoFrameData, buf[:4+n]); werr != nil {
+				return 0, nil, fmt.Errorf("failed to send echo data: %w", werr)
+			}
+			digest.Write(data)
+			total += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read echo payload: %w", err)
+		}
+	}
+
+	sum := digest.Sum(nil)
+	end := make([]byte, 8, 8+len(sum))
+	binary.BigEndian.PutUint64(end, uint64(total))
+	if err := writeEchoFrame(s, echoFrameEnd, append(end, sum...)); err != nil {
+		return 0, nil, fmt.Errorf("failed to send echo digest: %w", err)
+	}
+	s.CloseWrite()
+	return total, sum, nil
+}
+
+// verifyEchoChunk checks a data frame's checksum and returns its data
+func verifyEchoChunk(payload []byte) ([]byte, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("echo chunk too short")
+	}
+	data := payload[4:]
+	if crc32.Checksum(data, echoCRCTable) != binary.BigEndian.Uint32(payload[:4]) {
+		return nil, fmt.Errorf("echo chunk checksum mismatch")
+	}
+	return data, nil
+}
+
+// checkEchoEnd compares an end frame with the data seen so far
+func checkEchoEnd(payload []byte, total int64, digest hash.Hash) error {
+	if len(payload) != 8+sha256.Size {
+		return fmt.Errorf("invalid echo end frame")
+	}
+	if int64(binary.BigEndian.Uint64(payload[:8])) != total {
+		return fmt.Errorf("echo length mismatch: got %d bytes, %d announced", total, binary.BigEndian.Uint64(payload[:8]))
+	}
+	if !bytes.Equal(payload[8:], digest.Sum(nil)) {
+		return fmt.Errorf("echo digest mismatch")
+	}
+	return nil
+}
+
+// writeEchoFrame writes one frame with a single write
+func writeEchoFrame(w io.Writer, typ byte, payload []byte) error {
+	frame := make([]byte, 5+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readEchoFrame reads one frame
+func readEchoFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > 4+maxEchoChunkSize {
+		return 0, nil, fmt.Errorf("echo frame too large: %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// sendEchoV1 echoes data with the original protocol, which returns
+// everything once the peer has seen the end of the input
+func sendEchoV1(s network.Stream, data string) (string, error) {
+	if _, err := s.Write([]byte(data)); err != nil {
+		return "", fmt.Errorf("failed to send data: %w", err)
+	}
+
+	// Close write side to signal EOF
+	s.CloseWrite()
+
+	var response strings.Builder
+	if _, err := io.Copy(&response, s); err != nil {
+		return "", fmt.Errorf("failed to read echo: %w", err)
+	}
+	return response.String(), nil
+}
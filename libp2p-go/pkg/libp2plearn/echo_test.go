@@ -0,0 +1,119 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEchoV2(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	serverHandler := NewProtocolHandler(server)
+	serverHandler.SetEchoMaxSize(1 << 20)
+	serverHandler.SetupProtocols()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	recorder := &wireRecorder{host: client}
+	handler := NewProtocolHandler(client)
+	handler.SetStreamOpener(recorder)
+
+	t.Run("StreamsLargePayload", func(t *testing.T) {
+		payload := make([]byte, 600*1024+123)
+		_, err := rand.Read(payload)
+		require.NoError(t, err)
+
+		var echoed bytes.Buffer
+		result, err := handler.EchoStream(ctx, server.ID(), bytes.NewReader(payload), &echoed)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(payload)), result.Bytes)
+		sum := sha256.Sum256(payload)
+		assert.Equal(t, sum[:], result.Digest)
+		assert.True(t, bytes.Equal(payload, echoed.Bytes()))
+	})
+
+	t.Run("EmptyPayload", func(t *testing.T) {
+		var echoed bytes.Buffer
+		result, err := handler.EchoStream(ctx, server.ID(), strings.NewReader(""), &echoed)
+		require.NoError(t, err)
+		assert.Zero(t, result.Bytes)
+		assert.Zero(t, echoed.Len())
+	})
+
+	t.Run("SendEchoPrefersV2", func(t *testing.T) {
+		resp, err := handler.SendEcho(ctx, server.ID(), "hello v2")
+		require.NoError(t, err)
+		assert.Equal(t, "hello v2", resp)
+
+		proto, _ := recorder.last()
+		assert.Equal(t, protocol.ID(EchoV2Protocol), proto)
+	})
+
+	t.Run("RejectsPayloadOverLimit", func(t *testing.T) {
+		payload := io.LimitReader(zeroReader{}, 2<<20)
+		_, err := handler.EchoStream(ctx, server.ID(), payload, io.Discard)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds the limit")
+	})
+
+	t.Run("RejectsCorruptChunk", func(t *testing.T) {
+		s, err := client.NewStream(ctx, server.ID(), protocol.ID(EchoV2Protocol))
+		require.NoError(t, err)
+		defer s.Close()
+
+		// A chunk whose checksum doesn't match its data
+		require.NoError(t, writeEchoFrame(s, echoFrameData, []byte{0, 0, 0, 0, 'b', 'a', 'd'}))
+		typ, payload, err := readEchoFrame(bufio.NewReader(s))
+		require.NoError(t, err)
+		assert.Equal(t, echoFrameError, typ)
+		assert.Contains(t, string(payload), "checksum mismatch")
+	})
+
+	t.Run("FallsBackToV1", func(t *testing.T) {
+		legacy, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer legacy.Close()
+		legacy.SetStreamHandler(protocol.ID(EchoProtocol), func(s network.Stream) {
+			defer s.Close()
+			io.Copy(s, s)
+		})
+		require.NoError(t, connectNodes(ctx, client, legacy))
+
+		resp, err := handler.SendEcho(ctx, legacy.ID(), "hello v1")
+		require.NoError(t, err)
+		assert.Equal(t, "hello v1", resp)
+
+		proto, _ := recorder.last()
+		assert.Equal(t, protocol.ID(EchoProtocol), proto)
+
+		_, err = handler.EchoStream(ctx, legacy.ID(), strings.NewReader("x"), io.Discard)
+		assert.Error(t, err, "EchoStream needs a peer that speaks echo v2")
+	})
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
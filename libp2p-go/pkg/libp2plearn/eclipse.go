@@ -0,0 +1,291 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	asnutil "github.com/libp2p/go-libp2p-asn-util"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+// EclipseKind names a condition that suggests an eclipse or sybil attack
+type EclipseKind string
+
+const (
+	// EclipsePrefixDominance is one ASN or network prefix holding too much
+	// of the routing table
+	EclipsePrefixDominance EclipseKind = "prefix_dominance"
+	// EclipsePeerTurnover is most of the routing table being replaced
+	// between two checks
+	EclipsePeerTurnover EclipseKind = "peer_turnover"
+	// EclipseAgentMonoculture is too many routing table peers reporting
+	// the same agent version
+	EclipseAgentMonoculture EclipseKind = "agent_monoculture"
+)
+
+// EclipseThresholds are the shares of the routing table past which the
+// monitor raises an alert. A threshold of 0 disables its check.
+type EclipseThresholds struct {
+	MaxPrefixShare float64 // routing table peers in one ASN or prefix
+	MaxTurnover    float64 // routing table peers replaced since the last check
+	MaxAgentShare  float64 // routing table peers with the same agent version
+	MinPeers       int     // smaller routing tables aren't checked
+}
+
+// EclipseAlert is a suspicious condition of the routing table
+type EclipseAlert struct {
+	Kind    EclipseKind `json:"kind"`
+	Message string      `json:"message"`
+	Share   float64     `json:"share"`
+	Since   time.Time   `json:"since"`
+}
+
+// EvtEclipseSuspected is emitted on the host event bus when a suspicious
+// condition starts
+type EvtEclipseSuspected struct {
+	Alert EclipseAlert
+}
+
+// eclipseSample is what the monitor looks at of the routing table
+type eclipseSample struct {
+	groups map[peer.ID]string // ASN or prefix, empty if the peer has no public address
+	agents map[peer.ID]string // agent version, empty if the peer wasn't identified
+}
+
+// networkGroup returns the network a peer is in: the ASN of its first public
+// IPv6 address if it is known, or else the prefix subnetOf gives, and "" if
+// the peer has no public address
+func networkGroup(addrs []multiaddr.Multiaddr) string {
+	for _, addr := range addrs {
+		subnet := subnetOf(addr)
+		if subnet == "" {
+			continue
+		}
+		if ip, err := manet.ToIP(addr); err == nil && ip.To4() == nil {
+			if asn := asnutil.AsnForIPv6(ip); asn != 0 {
+				return fmt.Sprintf("AS%d", asn)
+			}
+		}
+		return subnet
+	}
+	return ""
+}
+
+// largestShare returns the most common non-empty value and its share of the
+// non-empty values, and how many there are
+func largestShare(values map[peer.ID]string) (value string, share float64, total int) {
+	counts := make(map[string]int)
+	for _, v := range values {
+		if v != "" {
+			counts[v]++
+			total++
+		}
+	}
+	most := 0
+	for v, n := range counts {
+		if n > most || n == most && v < value {
+			value, most = v, n
+		}
+	}
+	if total > 0 {
+		share = float64(most) / float64(total)
+	}
+	return value, share, total
+}
+
+// checkEclipse returns the conditions of cur that cross the thresholds. prev
+// is the sample of the last check, if any.
+func checkEclipse(prev, cur eclipseSample, th EclipseThresholds) []EclipseAlert {
+	var alerts []EclipseAlert
+
+	if th.MaxPrefixShare > 0 {
+		group, share, total := largestShare(cur.groups)
+		if total >= th.MinPeers && share > th.MaxPrefixShare {
+			alerts = append(alerts, EclipseAlert{
+				Kind:    EclipsePrefixDominance,
+				Message: fmt.Sprintf("%.0f%% of %d routing table peers are in %s", share*100, total, group),
+				Share:   share,
+			})
+		}
+	}
+
+	if th.MaxTurnover > 0 && len(prev.groups) >= th.MinPeers {
+		gone, added := 0, 0
+		for p := range prev.groups {
+			if _, ok := cur.groups[p]; !ok {
+				gone++
+			}
+		}
+		for p := range cur.groups {
+			if _, ok := prev.groups[p]; !ok {
+				added++
+			}
+		}
+		// Peers that left without being replaced are churn, not takeover
+		share := float64(min(gone, added)) / float64(len(prev.groups))
+		if share > th.MaxTurnover {
+			alerts = append(alerts, EclipseAlert{
+				Kind:    EclipsePeerTurnover,
+				Message: fmt.Sprintf("%d of %d routing table peers were replaced", min(gone, added), len(prev.groups)),
+				Share:   share,
+			})
+		}
+	}
+
+	if th.MaxAgentShare > 0 {
+		agent, share, total := largestShare(cur.agents)
+		if total >= th.MinPeers && share > th.MaxAgentShare {
+			alerts = append(alerts, EclipseAlert{
+				Kind:    EclipseAgentMonoculture,
+				Message: fmt.Sprintf("%.0f%% of %d routing table peers run %q", share*100, total, agent),
+				Share:   share,
+			})
+		}
+	}
+	return alerts
+}
+
+// EclipseMonitor watches the DHT routing table for signs of an eclipse or
+// sybil attack: one network dominating it, most of it being replaced at
+// once, or many peers running the same agent. Alerts are logged and emitted
+// as EvtEclipseSuspected when they start, and last until the condition ends.
+type EclipseMonitor struct {
+	host       host.Host
+	thresholds EclipseThresholds
+	emitter    event.Emitter
+
+	mu     sync.Mutex
+	last   *eclipseSample
+	active map[EclipseKind]EclipseAlert
+}
+
+// NewEclipseMonitor creates a monitor that emits on the host event bus
+func NewEclipseMonitor(h host.Host, thresholds EclipseThresholds) (*EclipseMonitor, error) {
+	emitter, err := h.EventBus().Emitter(new(EvtEclipseSuspected))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create eclipse emitter: %w", err)
+	}
+	return &EclipseMonitor{
+		host:       h,
+		thresholds: thresholds,
+		emitter:    emitter,
+		active:     make(map[EclipseKind]EclipseAlert),
+	}, nil
+}
+
+// Close stops emitting alerts
+func (m *EclipseMonitor) Close() {
+	m.emitter.Close()
+}
+
+// Watch checks the routing table every interval until ctx is done
+func (m *EclipseMonitor) Watch(ctx context.Context, d *discovery.DHT, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(d.RoutingTable().ListPeers())
+		}
+	}
+}
+
+// Check looks at the given routing table peers, raising alerts for
+// conditions that started and clearing those that ended
+func (m *EclipseMonitor) Check(table []peer.ID) {
+	cur := m.sample(table)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var prev eclipseSample
+	if m.last != nil {
+		prev = *m.last
+	}
+	m.last = &cur
+
+	now := time.Now()
+	raised := make(map[EclipseKind]bool)
+	for _, alert := range checkEclipse(prev, cur, m.thresholds) {
+		raised[alert.Kind] = true
+		if active, ok := m.active[alert.Kind]; ok {
+			alert.Since = active.Since
+			m.active[alert.Kind] = alert
+			continue
+		}
+		alert.Since = now
+		m.active[alert.Kind] = alert
+		logrus.WithFields(logrus.Fields{
+			"kind":  alert.Kind,
+			"share": alert.Share,
+		}).Warn("Possible eclipse attack: " + alert.Message)
+		if err := m.emitter.Emit(EvtEclipseSuspected{Alert: alert}); err != nil {
+			logrus.WithError(err).Debug("Failed to emit eclipse alert")
+		}
+	}
+	for kind := range m.active {
+		if !raised[kind] {
+			delete(m.active, kind)
+			logrus.WithField("kind", kind).Info("Eclipse alert cleared")
+		}
+	}
+}
+
+// sample looks up the network and agent of each peer in the peerstore
+func (m *EclipseMonitor) sample(table []peer.ID) eclipseSample {
+	s := eclipseSample{
+		groups: make(map[peer.ID]string, len(table)),
+		agents: make(map[peer.ID]string, len(table)),
+	}
+	ps := m.host.Peerstore()
+	for _, p := range table {
+		s.groups[p] = networkGroup(ps.Addrs(p))
+		if agent, err := ps.Get(p, "AgentVersion"); err == nil {
+			s.agents[p], _ = agent.(string)
+		}
+	}
+	return s
+}
+
+// Alerts returns the active alerts, oldest first
+func (m *EclipseMonitor) Alerts() []EclipseAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alerts := make([]EclipseAlert, 0, len(m.active))
+	for _, alert := range m.active {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].Since.Equal(alerts[j].Since) {
+			return alerts[i].Since.Before(alerts[j].Since)
+		}
+		return alerts[i].Kind < alerts[j].Kind
+	})
+	return alerts
+}
+
+// health reports the node degraded while any alert is active
+func (m *EclipseMonitor) health(ctx context.Context) (string, string) {
+	alerts := m.Alerts()
+	if len(alerts) == 0 {
+		return HealthOK, ""
+	}
+	messages := make([]string, len(alerts))
+	for i, alert := range alerts {
+		messages[i] = alert.Message
+	}
+	return HealthDegraded, strings.Join(messages, "; ")
+}
@@ -0,0 +1,105 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEclipse(t *testing.T) {
+	th := EclipseThresholds{MaxPrefixShare: 0.5, MaxTurnover: 0.5, MaxAgentShare: 0.8, MinPeers: 4}
+	sample := func(first int, group, agent func(i int) string) eclipseSample {
+		s := eclipseSample{groups: map[peer.ID]string{}, agents: map[peer.ID]string{}}
+		for i := first; i < first+4; i++ {
+			id := peer.ID(fmt.Sprintf("peer-%d", i))
+			s.groups[id], s.agents[id] = group(i), agent(i)
+		}
+		return s
+	}
+	distinct := func(i int) string { return fmt.Sprintf("group-%d", i) }
+	same := func(int) string { return "same" }
+
+	healthy := sample(0, distinct, distinct)
+	assert.Empty(t, checkEclipse(eclipseSample{}, healthy, th))
+
+	alerts := checkEclipse(healthy, sample(3, same, same), th)
+	require.Len(t, alerts, 3)
+	assert.Equal(t, EclipsePrefixDominance, alerts[0].Kind)
+	assert.Equal(t, 1.0, alerts[0].Share)
+	// Three of four peers were replaced
+	assert.Equal(t, EclipsePeerTurnover, alerts[1].Kind)
+	assert.Equal(t, 0.75, alerts[1].Share)
+	assert.Equal(t, EclipseAgentMonoculture, alerts[2].Kind)
+
+	t.Run("IgnoresUnknownAndSmallTables", func(t *testing.T) {
+		unknown := sample(0, func(int) string { return "" }, func(int) string { return "" })
+		assert.Empty(t, checkEclipse(unknown, unknown, th))
+		assert.Empty(t, checkEclipse(eclipseSample{}, sample(0, same, same), EclipseThresholds{MinPeers: 5, MaxPrefixShare: 0.5}))
+	})
+}
+
+func TestEclipseMonitor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+
+	monitor, err := NewEclipseMonitor(h, EclipseThresholds{MaxPrefixShare: 0.5, MinPeers: 4})
+	require.NoError(t, err)
+	defer monitor.Close()
+	sub, err := h.EventBus().Subscribe(new(EvtEclipseSuspected))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	var table []peer.ID
+	for i := 0; i < 4; i++ {
+		id := peer.ID(fmt.Sprintf("peer-%d", i))
+		addr := multiaddr.StringCast(fmt.Sprintf("/ip4/8.8.%d.1/tcp/4001", i))
+		h.Peerstore().AddAddr(id, addr, peerstore.PermanentAddrTTL)
+		table = append(table, id)
+	}
+
+	t.Run("RaisesOnce", func(t *testing.T) {
+		monitor.Check(table)
+		monitor.Check(table)
+
+		select {
+		case e := <-sub.Out():
+			alert := e.(EvtEclipseSuspected).Alert
+			assert.Equal(t, EclipsePrefixDominance, alert.Kind)
+			assert.Contains(t, alert.Message, "8.8.0.0/16")
+		case <-ctx.Done():
+			t.Fatal("no eclipse alert")
+		}
+		select {
+		case e := <-sub.Out():
+			t.Fatalf("alert raised again: %v", e)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		require.Len(t, monitor.Alerts(), 1)
+		status, message := monitor.health(ctx)
+		assert.Equal(t, HealthDegraded, status)
+		assert.Contains(t, message, "100% of 4")
+	})
+
+	t.Run("Clears", func(t *testing.T) {
+		for i, id := range table[:2] {
+			h.Peerstore().ClearAddrs(id)
+			h.Peerstore().AddAddr(id, multiaddr.StringCast(fmt.Sprintf("/ip4/%d.1.1.1/tcp/4001", i+1)), peerstore.PermanentAddrTTL)
+		}
+		monitor.Check(table)
+		assert.Empty(t, monitor.Alerts())
+		status, _ := monitor.health(ctx)
+		assert.Equal(t, HealthOK, status)
+	})
+}
@@ -0,0 +1,227 @@
+package libp2plearn
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before events are dropped
+const eventBufferSize = 64
+
+// EventType identifies the kind of a node event
+type EventType string
+
+const (
+	EventPeerConnected       EventType = "peer_connected"
+	EventPeerDisconnected    EventType = "peer_disconnected"
+	EventProtocolMessage     EventType = "protocol_message"
+	EventRelayReservation    EventType = "relay_reservation"
+	EventReachabilityChanged EventType = "reachability_changed"
+	EventAddressesUpdated    EventType = "addresses_updated"
+	EventPeerGoodbye         EventType = "peer_goodbye"
+	EventConnectionMigrated  EventType = "connection_migrated"
+	EventPeerMisbehaved      EventType = "peer_misbehaved"
+	EventConnectionTimed     EventType = "connection_timed"
+	EventRecordsRepublished  EventType = "records_republished"
+	EventPeerPaired          EventType = "peer_paired"
+	EventEclipseSuspected    EventType = "eclipse_suspected"
+	EventDHTModeChanged      EventType = "dht_mode_changed"
+	EventOutboxStatus        EventType = "outbox_status"
+	EventRoomMembership      EventType = "room_membership"
+)
+
+// Event is a libp2p or application event delivered to subscribers
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Peer     peer.ID     // remote peer, if the event concerns one
+	Protocol protocol.ID // protocol of a received message
+	Message  string      // received message, goodbye message or new reachability
+
+	// Raw is the original event bus event, if any
+	Raw interface{}
+}
+
+// busEvents are the event bus types translated into node events
+var busEvents = []interface{}{
+	new(event.EvtPeerConnectednessChanged),
+	new(event.EvtLocalReachabilityChanged),
+	new(event.EvtLocalAddressesUpdated),
+	new(event.EvtAutoRelayAddrsUpdated),
+	new(EvtPeerGoodbye),
+	new(EvtConnectionMigrated),
+	new(EvtPeerMisbehaved),
+	new(EvtConnectionTimed),
+	new(EvtRecordsRepublished),
+	new(EvtPeerPaired),
+	new(EvtEclipseSuspected),
+	new(discovery.EvtDHTModeChanged),
+	new(EvtOutboxStatus),
+	new(EvtRoomMembership),
+}
+
+// subscriber is one SubscribeEvents channel and the event types it wants
+type subscriber struct {
+	ch      chan Event
+	filters map[EventType]bool
+}
+
+func (s *subscriber) wants(t EventType) bool {
+	return len(s.filters) == 0 || s.filters[t]
+}
+
+// eventHub fans node events out to subscribers
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// SubscribeEvents returns a channel of node events of the given types, or of
+// every type if none are given. Slow subscribers miss events rather than
+// stalling the node. cancel closes the channel; it is also closed on Stop.
+func (n *Node) SubscribeEvents(filters ...EventType) (<-chan Event, func()) {
+	sub := &subscriber{
+		ch:      make(chan Event, eventBufferSize),
+		filters: make(map[EventType]bool, len(filters)),
+	}
+	for _, t := range filters {
+		sub.filters[t] = true
+	}
+
+	h := &n.events
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[sub] = struct{}{}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if _, ok := h.subs[sub]; ok {
+				delete(h.subs, sub)
+				close(sub.ch)
+			}
+		})
+	}
+	return sub.ch, cancel
+}
+
+// publish delivers an event to every interested subscriber without blocking
+func (h *eventHub) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.wants(e.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			logrus.WithField("type", e.Type).Debug("Dropped event for slow subscriber")
+		}
+	}
+}
+
+// close closes every subscriber channel and refuses new subscriptions
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.ch)
+	}
+	h.subs = nil
+}
+
+// translateEvent converts an event bus event into a node event
+func translateEvent(e interface{}) (Event, bool) {
+	switch evt := e.(type) {
+	case event.EvtPeerConnectednessChanged:
+		switch evt.Connectedness {
+		case network.Connected:
+			return Event{Type: EventPeerConnected, Peer: evt.Peer, Raw: e}, true
+		case network.NotConnected:
+			return Event{Type: EventPeerDisconnected, Peer: evt.Peer, Raw: e}, true
+		}
+	case event.EvtLocalReachabilityChanged:
+		return Event{Type: EventReachabilityChanged, Message: evt.Reachability.String(), Raw: e}, true
+	case event.EvtLocalAddressesUpdated:
+		return Event{Type: EventAddressesUpdated, Raw: e}, true
+	case event.EvtAutoRelayAddrsUpdated:
+		if len(evt.RelayAddrs) > 0 {
+			return Event{Type: EventRelayReservation, Raw: e}, true
+		}
+	case EvtPeerGoodbye:
+		return Event{Type: EventPeerGoodbye, Peer: evt.Peer, Message: evt.Message, Raw: e}, true
+	case EvtConnectionMigrated:
+		return Event{Type: EventConnectionMigrated, Peer: evt.Peer, Raw: e}, true
+	case EvtPeerMisbehaved:
+		return Event{Type: EventPeerMisbehaved, Peer: evt.Peer, Message: string(evt.Kind), Raw: e}, true
+	case EvtConnectionTimed:
+		return Event{Type: EventConnectionTimed, Peer: evt.Timing.Peer, Message: evt.Timing.Transport, Raw: e}, true
+	case EvtRecordsRepublished:
+		return Event{Type: EventRecordsRepublished, Message: strings.Join(evt.Reasons, ","), Raw: e}, true
+	case EvtPeerPaired:
+		return Event{Type: EventPeerPaired, Peer: evt.Peer, Message: evt.Addr.String(), Raw: e}, true
+	case EvtEclipseSuspected:
+		return Event{Type: EventEclipseSuspected, Message: evt.Alert.Message, Raw: e}, true
+	case discovery.EvtDHTModeChanged:
+		return Event{Type: EventDHTModeChanged, Message: evt.Mode, Raw: e}, true
+	case EvtOutboxStatus:
+		return Event{Type: EventOutboxStatus, Peer: evt.Message.To, Message: string(evt.Message.Status), Raw: e}, true
+	case EvtRoomMembership:
+		return Event{Type: EventRoomMembership, Message: evt.Membership.Room, Raw: e}, true
+	}
+	return Event{}, false
+}
+
+// watchEvents turns event bus events into node events and peer hooks until ctx is done
+func (n *Node) watchEvents(ctx context.Context, sub event.Subscription) {
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			evt, ok := translateEvent(e)
+			if !ok {
+				continue
+			}
+
+			switch evt.Type {
+			case EventPeerConnected:
+				n.hooks.firePeer(evt.Peer, true)
+			case EventPeerDisconnected:
+				n.hooks.firePeer(evt.Peer, false)
+			}
+			n.events.publish(evt)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent waits for the next event on the channel
+func nextEvent(t *testing.T, ctx context.Context, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		require.True(t, ok, "event channel closed")
+		return e
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for event")
+		return Event{}
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node1, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	node2, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+
+	all, cancelAll := node2.SubscribeEvents()
+	messages, cancelMessages := node2.SubscribeEvents(EventProtocolMessage)
+	defer cancelMessages()
+
+	require.NoError(t, node1.Start(ctx))
+	defer node1.Stop(ctx)
+	require.NoError(t, node2.Start(ctx))
+
+	t.Run("MultiplexesBusAndProtocolEvents", func(t *testing.T) {
+		addr := fmt.Sprintf("%s/p2p/%s", node2.Host().Addrs()[0], node2.Host().ID())
+		require.NoError(t, node1.Connect(ctx, addr))
+
+		_, err := node1.Protocols().SendPing(ctx, node2.Host().ID(), "hello events")
+		require.NoError(t, err)
+
+		e := nextEvent(t, ctx, messages)
+		assert.Equal(t, EventProtocolMessage, e.Type)
+		assert.Equal(t, node1.Host().ID(), e.Peer)
+		assert.Equal(t, PingProtocol, string(e.Protocol))
+		assert.Equal(t, "hello events", e.Message)
+		assert.False(t, e.Time.IsZero())
+
+		seen := make(map[EventType]bool)
+		for !seen[EventPeerConnected] || !seen[EventProtocolMessage] {
+			seen[nextEvent(t, ctx, all).Type] = true
+		}
+	})
+
+	t.Run("CancelClosesChannel", func(t *testing.T) {
+		cancelAll()
+		cancelAll() // idempotent
+		for range all {
+		}
+	})
+
+	t.Run("StopClosesChannels", func(t *testing.T) {
+		require.NoError(t, node2.Stop(ctx))
+		for range messages {
+		}
+
+		late, _ := node2.SubscribeEvents()
+		_, ok := <-late
+		assert.False(t, ok, "subscribing after Stop should return a closed channel")
+	})
+}
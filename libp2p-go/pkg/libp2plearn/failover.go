@@ -0,0 +1,248 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// failoverTag protects watched peers from being pruned by the connection manager
+	failoverTag = "failover"
+
+	// failoverBackoff is the delay before the first reconnect attempt, doubled after each failure
+	failoverBackoff = time.Second
+
+	// failoverGoodbyeGrace is how long after a goodbye a disconnect counts as intentional
+	failoverGoodbyeGrace = 10 * time.Second
+)
+
+// EvtConnectionMigrated is emitted on the host event bus after the connection
+// to a watched peer was lost and re-established
+type EvtConnectionMigrated struct {
+	Peer          peer.ID
+	From          multiaddr.Multiaddr
+	To            multiaddr.Multiaddr
+	FromTransport string
+	ToTransport   string
+	Streams       []protocol.ID
+}
+
+// StreamReopener takes over a stream re-opened on the new connection after a migration
+type StreamReopener func(s network.Stream)
+
+// Failover re-establishes lost connections to important peers and re-opens
+// registered application streams on the new connection
+type Failover struct {
+	host     host.Host
+	emitter  event.Emitter
+	goodbyes event.Subscription
+	attempts int
+
+	mu        sync.Mutex
+	peers     map[peer.ID]bool
+	migrating map[peer.ID]bool
+	departed  map[peer.ID]time.Time
+	reopeners map[protocol.ID]StreamReopener
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewFailover creates a failover manager that makes up to attempts reconnects per lost connection
+func NewFailover(h host.Host, attempts int) (*Failover, error) {
+	emitter, err := h.EventBus().Emitter(new(EvtConnectionMigrated))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration emitter: %w", err)
+	}
+
+	goodbyes, err := h.EventBus().Subscribe(new(EvtPeerGoodbye))
+	if err != nil {
+		emitter.Close()
+		return nil, fmt.Errorf("failed to subscribe to goodbyes: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Failover{
+		host:      h,
+		emitter:   emitter,
+		goodbyes:  goodbyes,
+		attempts:  attempts,
+		peers:     make(map[peer.ID]bool),
+		migrating: make(map[peer.ID]bool),
+		departed:  make(map[peer.ID]time.Time),
+		reopeners: make(map[protocol.ID]StreamReopener),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Start begins watching for lost connections
+func (f *Failover) Start() {
+	f.host.Network().Notify(f)
+
+	// Peers that said goodbye left on purpose, so don't chase them
+	go func() {
+		for e := range f.goodbyes.Out() {
+			evt := e.(EvtPeerGoodbye)
+			f.mu.Lock()
+			f.departed[evt.Peer] = time.Now()
+			f.mu.Unlock()
+		}
+	}()
+	logrus.WithField("peers", len(f.peers)).Info("Transport failover started")
+}
+
+// Close stops watching and aborts any reconnects in progress
+func (f *Failover) Close() error {
+	f.host.Network().StopNotify(f)
+	f.cancel()
+	f.goodbyes.Close()
+	return f.emitter.Close()
+}
+
+// Watch marks a peer as important so its connection is re-established when lost
+func (f *Failover) Watch(p peer.ID) {
+	f.mu.Lock()
+	f.peers[p] = true
+	f.mu.Unlock()
+
+	f.host.ConnManager().Protect(p, failoverTag)
+	logrus.WithField("peer", p).Debug("Watching peer for failover")
+}
+
+// Unwatch stops re-establishing the connection to a peer
+func (f *Failover) Unwatch(p peer.ID) {
+	f.mu.Lock()
+	delete(f.peers, p)
+	f.mu.Unlock()
+
+	f.host.ConnManager().Unprotect(p, failoverTag)
+}
+
+// RegisterStream re-opens a stream for the protocol on every migrated connection
+func (f *Failover) RegisterStream(p protocol.ID, reopen StreamReopener) {
+	f.mu.Lock()
+	f.reopeners[p] = reopen
+	f.mu.Unlock()
+}
+
+// migrate reconnects to a peer whose last connection closed and re-opens its streams
+func (f *Failover) migrate(p peer.ID, from multiaddr.Multiaddr) {
+	logger := logrus.WithFields(logrus.Fields{
+		"peer":      p,
+		"from":      from,
+		"transport": transportName(from),
+	})
+	logger.Warn("Connection to watched peer lost, failing over")
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.migrating, p)
+		f.mu.Unlock()
+	}()
+
+	// Dial every known address; the transport that just failed may be
+	// blocked, so whichever alternative connects first wins
+	backoff := failoverBackoff
+	var conn network.Conn
+	for attempt := 1; attempt <= f.attempts; attempt++ {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		// The host bounds each attempt by the connect timeout
+		err := f.host.Connect(f.ctx, f.host.Peerstore().PeerInfo(p))
+		if err == nil {
+			if conns := f.host.Network().ConnsToPeer(p); len(conns) > 0 {
+				conn = conns[0]
+				break
+			}
+		}
+
+		logger.WithError(err).WithField("attempt", attempt).Debug("Failover reconnect failed")
+		backoff *= 2
+	}
+
+	if conn == nil {
+		logger.WithField("attempts", f.attempts).Error("Failed to re-establish connection to watched peer")
+		return
+	}
+
+	f.mu.Lock()
+	reopeners := make(map[protocol.ID]StreamReopener, len(f.reopeners))
+	for proto, reopen := range f.reopeners {
+		reopeners[proto] = reopen
+	}
+	f.mu.Unlock()
+
+	var reopened []protocol.ID
+	for proto, reopen := range reopeners {
+		s, err := f.host.NewStream(f.ctx, p, proto)
+		if err != nil {
+			logger.WithError(err).WithField("protocol", proto).Warn("Failed to re-open stream after migration")
+			continue
+		}
+		reopened = append(reopened, proto)
+		go reopen(s)
+	}
+
+	evt := EvtConnectionMigrated{
+		Peer:          p,
+		From:          from,
+		To:            conn.RemoteMultiaddr(),
+		FromTransport: transportName(from),
+		ToTransport:   transportName(conn.RemoteMultiaddr()),
+		Streams:       reopened,
+	}
+	if err := f.emitter.Emit(evt); err != nil {
+		logger.WithError(err).Error("Failed to emit migration event")
+	}
+
+	logger.WithFields(logrus.Fields{
+		"to":            evt.To,
+		"new_transport": evt.ToTransport,
+		"streams":       len(reopened),
+	}).Info("Connection migrated")
+}
+
+// Disconnected starts a failover when the last connection to a watched peer closes
+func (f *Failover) Disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+
+	if n.Connectedness(p) == network.Connected || f.ctx.Err() != nil {
+		return
+	}
+
+	f.mu.Lock()
+	departed := time.Since(f.departed[p]) < failoverGoodbyeGrace
+	delete(f.departed, p)
+	start := f.peers[p] && !f.migrating[p] && !departed
+	if start {
+		f.migrating[p] = true
+	}
+	f.mu.Unlock()
+
+	if departed {
+		logrus.WithField("peer", p).Info("Watched peer said goodbye, not failing over")
+	}
+
+	if start {
+		go f.migrate(p, c.RemoteMultiaddr())
+	}
+}
+
+func (f *Failover) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (f *Failover) ListenClose(network.Network, multiaddr.Multiaddr) {}
+func (f *Failover) Connected(network.Network, network.Conn)          {}
@@ -0,0 +1,74 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	NewProtocolHandler(server).SetupProtocols()
+
+	failover, err := NewFailover(client, 3)
+	require.NoError(t, err)
+	defer failover.Close()
+
+	reopened := make(chan network.Stream, 1)
+	failover.RegisterStream(protocol.ID(EchoProtocol), func(s network.Stream) {
+		reopened <- s
+	})
+	failover.Watch(server.ID())
+	failover.Start()
+
+	sub, err := client.EventBus().Subscribe(new(EvtConnectionMigrated))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	err = connectNodes(ctx, client, server)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, client, server, 10*time.Second)
+	require.NoError(t, err)
+
+	t.Run("ReconnectAfterConnectionLoss", func(t *testing.T) {
+		require.NoError(t, client.Network().ClosePeer(server.ID()))
+
+		select {
+		case e := <-sub.Out():
+			evt := e.(EvtConnectionMigrated)
+			assert.Equal(t, server.ID(), evt.Peer)
+			assert.NotNil(t, evt.To)
+			assert.Contains(t, evt.Streams, protocol.ID(EchoProtocol))
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for migration event")
+		}
+
+		assert.Equal(t, network.Connected, client.Network().Connectedness(server.ID()))
+	})
+
+	t.Run("StreamReopened", func(t *testing.T) {
+		select {
+		case s := <-reopened:
+			assert.Equal(t, protocol.ID(EchoProtocol), s.Protocol())
+			s.Close()
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for re-opened stream")
+		}
+	})
+}
@@ -0,0 +1,323 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tunnelDialTimeout bounds opening the far side of a tunnelled connection
+	tunnelDialTimeout = 30 * time.Second
+
+	// ExposeAllowAny in an expose entry's allow list admits every peer
+	ExposeAllowAny = "*"
+)
+
+// ExposeConfig makes a local TCP service reachable by peers under a protocol ID
+type ExposeConfig struct {
+	Protocol string   `json:"protocol"`
+	Target   string   `json:"target"` // host:port of the local service
+	Allow    []string `json:"allow"`  // peer IDs allowed to connect, or "*"
+}
+
+// ForwardConfig forwards a local TCP port to a service exposed by a peer
+type ForwardConfig struct {
+	Listen string `json:"listen"` // local host:port to accept connections on
+	Target string `json:"target"` // <peer ID or multiaddr>:<protocol>
+}
+
+// ParseForwardTarget splits a "<peer>:<protocol>" forward target, where the
+// peer is a peer ID or a multiaddr ending in /p2p/<peer ID>
+func ParseForwardTarget(target string) (peer.AddrInfo, protocol.ID, error) {
+	i := strings.LastIndex(target, ":/")
+	if i <= 0 {
+		return peer.AddrInfo{}, "", fmt.Errorf("forward target must look like <peer>:/protocol")
+	}
+	addr, proto := target[:i], protocol.ID(target[i+1:])
+
+	if strings.HasPrefix(addr, "/") {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return peer.AddrInfo{}, "", fmt.Errorf("invalid forward peer address: %w", err)
+		}
+		return *info, proto, nil
+	}
+	id, err := peer.Decode(addr)
+	if err != nil {
+		return peer.AddrInfo{}, "", fmt.Errorf("invalid forward peer ID: %w", err)
+	}
+	return peer.AddrInfo{ID: id}, proto, nil
+}
+
+// exposeAllowList converts an expose entry's allow list, where "*" admits any peer
+func exposeAllowList(ids []string) []peer.ID {
+	allowed := make([]peer.ID, 0, len(ids))
+	for _, id := range ids {
+		if id == ExposeAllowAny {
+			return nil
+		}
+		p, _ := peer.Decode(id) // validated with the config
+		allowed = append(allowed, p)
+	}
+	return allowed
+}
+
+// Tunnel pipes connections accepted on one side to connections it dials on
+// the other, until closed
+type Tunnel struct {
+	proto    protocol.ID
+	listener net.Listener
+	dial     func(ctx context.Context) (net.Conn, error)
+	allow    func(net.Conn) bool
+	onClose  func()
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// ExposeTCP lets peers reach the TCP service at target by opening streams of
+// proto. A nil allowed list admits any peer; otherwise streams from other
+// peers are reset.
+func (n *Node) ExposeTCP(proto protocol.ID, target string, allowed []peer.ID) (*Tunnel, error) {
+	l, err := n.ListenService(proto)
+	if err != nil {
+		return nil, err
+	}
+
+	var allow func(net.Conn) bool
+	if allowed != nil {
+		peers := make(map[string]bool, len(allowed))
+		for _, p := range allowed {
+			peers[p.String()] = true
+		}
+		allow = func(c net.Conn) bool {
+			return peers[c.RemoteAddr().String()]
+		}
+	}
+
+	dial := func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", target)
+	}
+
+	t := n.startTunnel(proto, l, dial, allow)
+	logrus.WithFields(logrus.Fields{
+		"protocol": proto,
+		"target":   target,
+		"allowed":  len(allowed),
+	}).Info("Exposing TCP service to peers")
+	return t, nil
+}
+
+// ForwardTCP accepts TCP connections on listenAddr and forwards each to the
+// service a peer exposes under proto. Without known addresses, the peer is
+// looked up in the DHT.
+func (n *Node) ForwardTCP(listenAddr string, target peer.AddrInfo, proto protocol.ID) (*Tunnel, error) {
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	if len(target.Addrs) > 0 {
+		n.host.Peerstore().AddAddrs(target.ID, target.Addrs, peerstore.PermanentAddrTTL)
+	}
+
+	dial := func(ctx context.Context) (net.Conn, error) {
+		if err := n.connectForward(ctx, target.ID); err != nil {
+			return nil, err
+		}
+		return n.DialService(ctx, target.ID, proto)
+	}
+
+	t := n.startTunnel(proto, l, dial, nil)
+	logrus.WithFields(logrus.Fields{
+		"listen":   l.Addr(),
+		"peer":     target.ID,
+		"protocol": proto,
+	}).Info("Forwarding TCP port to peer")
+	return t, nil
+}
+
+// connectForward makes sure we are connected to a forward target
+func (n *Node) connectForward(ctx context.Context, p peer.ID) error {
+	if n.host.Network().Connectedness(p) == network.Connected {
+		return nil
+	}
+	info := peer.AddrInfo{ID: p, Addrs: n.host.Peerstore().Addrs(p)}
+	if len(info.Addrs) == 0 && n.dht != nil {
+		found, err := n.dht.FindPeer(ctx, p)
+		if err != nil {
+			return fmt.Errorf("failed to find peer %s: %w", p, err)
+		}
+		info = found
+	}
+	if err := n.host.Connect(ctx, info); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", p, err)
+	}
+	return nil
+}
+
+// startTunnel registers the tunnel with the node and starts accepting connections
+func (n *Node) startTunnel(proto protocol.ID, l net.Listener, dial func(context.Context) (net.Conn, error), allow func(net.Conn) bool) *Tunnel {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Tunnel{
+		proto:    proto,
+		listener: l,
+		dial:     dial,
+		allow:    allow,
+		ctx:      ctx,
+		cancel:   cancel,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	n.services.mu.Lock()
+	if n.services.tunnels == nil {
+		n.services.tunnels = make(map[*Tunnel]struct{})
+	}
+	n.services.tunnels[t] = struct{}{}
+	n.services.mu.Unlock()
+	t.onClose = func() {
+		n.services.mu.Lock()
+		defer n.services.mu.Unlock()
+		delete(n.services.tunnels, t)
+	}
+
+	t.wg.Add(1)
+	go t.accept()
+	return t
+}
+
+// Addr returns the address the tunnel accepts connections on
+func (t *Tunnel) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops accepting connections and closes the ones in progress
+func (t *Tunnel) Close() error {
+	t.cancel()
+	err := t.listener.Close()
+	t.onClose()
+
+	t.mu.Lock()
+	for c := range t.conns {
+		c.Close()
+	}
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return err
+}
+
+// accept handles connections until the listener is closed
+func (t *Tunnel) accept() {
+	defer t.wg.Done()
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			if t.ctx.Err() == nil {
+				logrus.WithError(err).WithField("protocol", t.proto).Debug("Tunnel stopped accepting connections")
+			}
+			return
+		}
+		if t.allow != nil && !t.allow(c) {
+			logrus.WithFields(logrus.Fields{
+				"protocol": t.proto,
+				"peer":     c.RemoteAddr(),
+			}).Warn("Rejected tunnel connection from unauthorized peer")
+			if s, ok := c.(network.Stream); ok {
+				s.Reset()
+			} else {
+				c.Close()
+			}
+			continue
+		}
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.handle(c)
+		}()
+	}
+}
+
+// handle dials the far side for an accepted connection and pipes data both ways
+func (t *Tunnel) handle(c net.Conn) {
+	ctx, cancel := context.WithTimeout(t.ctx, tunnelDialTimeout)
+	remote, err := t.dial(ctx)
+	cancel()
+	if err != nil {
+		logrus.WithError(err).WithField("protocol", t.proto).Warn("Failed to open tunnel connection")
+		c.Close()
+		return
+	}
+
+	if !t.track(c, remote) {
+		c.Close()
+		remote.Close()
+		return
+	}
+	defer t.untrack(c, remote)
+
+	logrus.WithFields(logrus.Fields{
+		"protocol": t.proto,
+		"from":     c.RemoteAddr(),
+	}).Debug("Tunnel connection opened")
+	pipe(c, remote)
+}
+
+// track records open connections so Close can interrupt them; false once closed
+func (t *Tunnel) track(conns ...net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx.Err() != nil {
+		return false
+	}
+	for _, c := range conns {
+		t.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (t *Tunnel) untrack(conns ...net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range conns {
+		delete(t.conns, c)
+	}
+}
+
+// pipe copies data between two connections until both directions are done,
+// passing half-closes through so request/response protocols finish cleanly
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,127 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer runs a TCP server that echoes each connection until it is half-closed
+func startEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestPortForwarding(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	server, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer server.Stop(context.Background())
+
+	client, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer client.Stop(context.Background())
+
+	stranger, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer stranger.Stop(context.Background())
+
+	const proto = "/x/echo"
+	exposed, err := server.ExposeTCP(proto, echo.Addr().String(), []peer.ID{client.Host().ID()})
+	require.NoError(t, err)
+	defer exposed.Close()
+
+	serverInfo := peer.AddrInfo{ID: server.Host().ID(), Addrs: server.Host().Addrs()}
+
+	t.Run("ForwardsTraffic", func(t *testing.T) {
+		forward, err := client.ForwardTCP("127.0.0.1:0", serverInfo, proto)
+		require.NoError(t, err)
+		defer forward.Close()
+
+		conn, err := net.Dial("tcp", forward.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		_, err = conn.Write([]byte("hello through libp2p"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		// The half-close has to cross the tunnel for the echo server to finish
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, "hello through libp2p", string(data))
+	})
+
+	t.Run("RejectsUnauthorizedPeers", func(t *testing.T) {
+		forward, err := stranger.ForwardTCP("127.0.0.1:0", serverInfo, proto)
+		require.NoError(t, err)
+		defer forward.Close()
+
+		conn, err := net.Dial("tcp", forward.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		conn.Write([]byte("let me in"))
+		data, _ := io.ReadAll(conn)
+		assert.Empty(t, data, "unauthorized peers should not reach the service")
+	})
+
+	t.Run("CloseStopsForwarding", func(t *testing.T) {
+		forward, err := client.ForwardTCP("127.0.0.1:0", serverInfo, proto)
+		require.NoError(t, err)
+		addr := forward.Addr().String()
+		require.NoError(t, forward.Close())
+
+		_, err = net.Dial("tcp", addr)
+		assert.Error(t, err)
+	})
+}
+
+func TestParseForwardTarget(t *testing.T) {
+	ctx := context.Background()
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+	id := h.ID().String()
+
+	info, proto, err := ParseForwardTarget(id + ":/x/postgres")
+	require.NoError(t, err)
+	assert.Equal(t, h.ID(), info.ID)
+	assert.Empty(t, info.Addrs)
+	assert.Equal(t, "/x/postgres", string(proto))
+
+	info, proto, err = ParseForwardTarget(fmt.Sprintf("/ip6/::1/tcp/4001/p2p/%s:/x/postgres", id))
+	require.NoError(t, err)
+	assert.Equal(t, h.ID(), info.ID)
+	assert.Len(t, info.Addrs, 1)
+	assert.Equal(t, "/x/postgres", string(proto))
+
+	for _, bad := range []string{id, ":/x/postgres", "not-a-peer:/x/postgres", "/ip4/1.2.3.4/tcp/1:/x"} {
+		_, _, err := ParseForwardTarget(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,165 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxGatewayBodySize bounds the request payload forwarded to a peer
+	maxGatewayBodySize = 1 << 20
+
+	// gatewayRequestTimeout bounds a single proxied request
+	gatewayRequestTimeout = 30 * time.Second
+)
+
+// gatewaySender forwards a payload to a peer over one protocol
+type gatewaySender func(ctx context.Context, peerID peer.ID, payload string) (string, error)
+
+// Gateway translates HTTP requests into streams to libp2p protocols
+type Gateway struct {
+	handler  *ProtocolHandler
+	routes   map[string]gatewaySender
+	origins  []string
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewGateway creates a gateway that proxies requests through the given protocol handler
+func NewGateway(handler *ProtocolHandler, listenAddr string) *Gateway {
+	g := &Gateway{
+		handler: handler,
+		routes: map[string]gatewaySender{
+			"ping": handler.SendPing,
+			"chat": handler.SendChatMessage,
+			"echo": handler.SendEcho,
+		},
+	}
+	g.server = &http.Server{
+		Addr:              listenAddr,
+		Handler:           g,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return g
+}
+
+// AllowOrigins lets web pages served from origins (such as
+// "https://app.example") call the gateway from a browser. Requests from
+// other origins are refused, so a page the user happens to have open can't
+// make the node message peers. Requests without an Origin header, such as
+// curl's, and ones from the gateway's own origin are always accepted.
+func (g *Gateway) AllowOrigins(origins ...string) {
+	g.origins = append(g.origins, origins...)
+}
+
+// Start listens on the gateway address and serves HTTP requests in the
+// background
+func (g *Gateway) Start() error {
+	listener, err := net.Listen("tcp", g.server.Addr)
+	if err != nil {
+		return err
+	}
+	g.listener = listener
+	go func() {
+		if err := g.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).WithField("addr", listener.Addr()).Error("Gateway server failed")
+		}
+	}()
+	logrus.WithField("addr", listener.Addr()).Info("HTTP gateway started")
+	return nil
+}
+
+// Addr returns the address the gateway listens on, nil until it is started
+func (g *Gateway) Addr() net.Addr {
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Addr()
+}
+
+// Close shuts the gateway down, waiting for in-flight requests
+func (g *Gateway) Close(ctx context.Context) error {
+	return g.server.Shutdown(ctx)
+}
+
+// ServeHTTP handles requests of the form POST /p2p/<peerID>/<protocol>
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Browsers send Origin with cross-site POSTs, which need no preflight
+	if origin := r.Header.Get("Origin"); origin != "" && !g.allowedOrigin(origin, r.Host) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "p2p" {
+		http.Error(w, "expected /p2p/<peerID>/<protocol>", http.StatusNotFound)
+		return
+	}
+
+	peerID, err := peer.Decode(parts[1])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid peer ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	send, ok := g.routes[parts[2]]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown protocol: %s", parts[2]), http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGatewayBodySize))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), gatewayRequestTimeout)
+	defer cancel()
+
+	response, err := send(ctx, peerID, string(body))
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"peer":     peerID,
+			"protocol": parts[2],
+		}).Warn("Gateway request failed")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.WriteString(w, response); err != nil {
+		logrus.WithError(err).Error("Failed to write gateway response")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":     peerID,
+		"protocol": parts[2],
+	}).Debug("Handled gateway request")
+}
+
+// allowedOrigin reports whether a browser request from origin may use the
+// gateway reached as host
+func (g *Gateway) allowedOrigin(origin, host string) bool {
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && u.Host == host {
+		return true
+	}
+	return slices.Contains(g.origins, origin)
+}
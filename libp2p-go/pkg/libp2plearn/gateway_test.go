@@ -0,0 +1,106 @@
+package libp2plearn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node2.Close()
+
+	handler1 := NewProtocolHandler(node1)
+	handler2 := NewProtocolHandler(node2)
+	handler2.SetupProtocols()
+
+	err = connectNodes(ctx, node1, node2)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, node1, node2, 10*time.Second)
+	require.NoError(t, err)
+
+	gateway := NewGateway(handler1, "127.0.0.1:0")
+
+	t.Run("EchoRequest", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/p2p/"+node2.ID().String()+"/echo", strings.NewReader("gateway-data"))
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "gateway-data", rec.Body.String())
+	})
+
+	t.Run("PingRequest", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/p2p/"+node2.ID().String()+"/ping", strings.NewReader("gateway-ping"))
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "pong")
+	})
+
+	t.Run("RejectsInvalidRequests", func(t *testing.T) {
+		cases := []struct {
+			method string
+			path   string
+			status int
+		}{
+			{http.MethodGet, "/p2p/" + node2.ID().String() + "/echo", http.StatusMethodNotAllowed},
+			{http.MethodPost, "/p2p/not-a-peer/echo", http.StatusBadRequest},
+			{http.MethodPost, "/p2p/" + node2.ID().String() + "/unknown", http.StatusNotFound},
+			{http.MethodPost, "/other", http.StatusNotFound},
+		}
+
+		for _, c := range cases {
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader("x"))
+			rec := httptest.NewRecorder()
+			gateway.ServeHTTP(rec, req)
+			assert.Equal(t, c.status, rec.Code, "%s %s", c.method, c.path)
+		}
+	})
+
+	t.Run("ChecksOrigin", func(t *testing.T) {
+		gateway.AllowOrigins("https://app.example")
+		cases := []struct {
+			origin string
+			status int
+		}{
+			{"https://evil.example", http.StatusForbidden},
+			{"null", http.StatusForbidden},
+			{"http://example.com", http.StatusOK},
+			{"https://app.example", http.StatusOK},
+		}
+
+		for _, c := range cases {
+			req := httptest.NewRequest(http.MethodPost, "/p2p/"+node2.ID().String()+"/echo", strings.NewReader("x"))
+			req.Header.Set("Origin", c.origin)
+			rec := httptest.NewRecorder()
+			gateway.ServeHTTP(rec, req)
+			assert.Equal(t, c.status, rec.Code, c.origin)
+		}
+	})
+
+	t.Run("StartFailsOnBusyAddress", func(t *testing.T) {
+		first := NewGateway(handler1, "127.0.0.1:0")
+		require.NoError(t, first.Start())
+		defer first.Close(ctx)
+
+		second := NewGateway(handler1, first.Addr().String())
+		assert.Error(t, second.Start())
+	})
+}
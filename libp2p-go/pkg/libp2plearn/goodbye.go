@@ -0,0 +1,196 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// GoodbyeProtocol announces an intentional disconnect and its reason
+	GoodbyeProtocol = "/libp2p-learn/goodbye/1.0.0"
+
+	// goodbyeTimeout bounds how long we wait for a peer to accept a goodbye
+	goodbyeTimeout = 2 * time.Second
+
+	// maxGoodbyeSize bounds the size of a goodbye message
+	maxGoodbyeSize = 1024
+)
+
+// GoodbyeReason tells the remote side why the connection is going away
+type GoodbyeReason uint8
+
+const (
+	GoodbyeUnknown GoodbyeReason = iota
+	GoodbyeShutdown
+	GoodbyePruned
+	GoodbyeBanned
+	GoodbyeMaintenance
+)
+
+func (r GoodbyeReason) String() string {
+	switch r {
+	case GoodbyeShutdown:
+		return "shutdown"
+	case GoodbyePruned:
+		return "pruned"
+	case GoodbyeBanned:
+		return "banned"
+	case GoodbyeMaintenance:
+		return "maintenance"
+	default:
+		return "unknown"
+	}
+}
+
+// goodbyeMessage is the wire format of a goodbye, sent as one JSON line
+type goodbyeMessage struct {
+	Reason  GoodbyeReason `json:"reason"`
+	Message string        `json:"message,omitempty"`
+}
+
+// EvtPeerGoodbye is emitted on the host event bus when a peer announces it is disconnecting
+type EvtPeerGoodbye struct {
+	Peer    peer.ID
+	Reason  GoodbyeReason
+	Message string
+}
+
+// Goodbye sends and receives goodbye messages before intentional disconnects
+type Goodbye struct {
+	host    host.Host
+	emitter event.Emitter
+}
+
+// NewGoodbye creates the goodbye service and registers its protocol handler
+func NewGoodbye(h host.Host) (*Goodbye, error) {
+	emitter, err := h.EventBus().Emitter(new(EvtPeerGoodbye))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create goodbye emitter: %w", err)
+	}
+
+	g := &Goodbye{host: h, emitter: emitter}
+	h.SetStreamHandler(protocol.ID(GoodbyeProtocol), RecoveryMiddleware(protocol.ID(GoodbyeProtocol), g.handleGoodbye))
+	logrus.WithField("protocol", GoodbyeProtocol).Info("Registered goodbye protocol")
+	return g, nil
+}
+
+// Close unregisters the goodbye protocol
+func (g *Goodbye) Close() error {
+	g.host.RemoveStreamHandler(protocol.ID(GoodbyeProtocol))
+	return g.emitter.Close()
+}
+
+// Disconnect tells the peer why we are disconnecting, then closes the connection.
+// Peers that don't support the protocol are disconnected anyway.
+func (g *Goodbye) Disconnect(ctx context.Context, p peer.ID, reason GoodbyeReason, message string) error {
+	if err := g.send(ctx, p, goodbyeMessage{Reason: reason, Message: message}); err != nil {
+		logrus.WithError(err).WithField("peer", p).Debug("Failed to send goodbye")
+	}
+
+	if err := g.host.Network().ClosePeer(p); err != nil {
+		return fmt.Errorf("failed to close connection to %s: %w", p, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":   p,
+		"reason": reason.String(),
+	}).Info("Disconnected from peer")
+	return nil
+}
+
+// Notify tells the peer we are about to disconnect, without disconnecting
+func (g *Goodbye) Notify(ctx context.Context, p peer.ID, reason GoodbyeReason, message string) error {
+	return g.send(ctx, p, goodbyeMessage{Reason: reason, Message: message})
+}
+
+// DisconnectAll says goodbye to every connected peer in parallel
+func (g *Goodbye) DisconnectAll(ctx context.Context, reason GoodbyeReason, message string) {
+	var wg sync.WaitGroup
+	for _, p := range g.host.Network().Peers() {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := g.Disconnect(ctx, p, reason, message); err != nil {
+				logrus.WithError(err).WithField("peer", p).Debug("Failed to disconnect")
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// send writes a goodbye message to the peer and waits for it to be read
+func (g *Goodbye) send(ctx context.Context, p peer.ID, msg goodbyeMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, goodbyeTimeout)
+	defer cancel()
+
+	// Never dial just to say goodbye
+	if g.host.Network().Connectedness(p) != network.Connected {
+		return fmt.Errorf("not connected")
+	}
+
+	s, err := g.host.NewStream(network.WithNoDial(ctx, "goodbye"), p, protocol.ID(GoodbyeProtocol))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode goodbye: %w", err)
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send goodbye: %w", err)
+	}
+	s.CloseWrite()
+
+	// Wait for the remote to close its side so the message isn't lost when the connection closes
+	buf := make([]byte, 1)
+	s.Read(buf)
+	return nil
+}
+
+// handleGoodbye records why a peer is disconnecting
+func (g *Goodbye) handleGoodbye(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	s.SetReadDeadline(time.Now().Add(goodbyeTimeout))
+
+	reader := bufio.NewReaderSize(s, maxGoodbyeSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read goodbye")
+		return
+	}
+
+	var msg goodbyeMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Received invalid goodbye")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":    remote,
+		"reason":  msg.Reason.String(),
+		"message": msg.Message,
+	}).Info("Peer said goodbye")
+
+	if err := g.emitter.Emit(EvtPeerGoodbye{Peer: remote, Reason: msg.Reason, Message: msg.Message}); err != nil {
+		logrus.WithError(err).Error("Failed to emit goodbye event")
+	}
+}
@@ -0,0 +1,66 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoodbye(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node2.Close()
+
+	goodbye1, err := NewGoodbye(node1)
+	require.NoError(t, err)
+	defer goodbye1.Close()
+
+	goodbye2, err := NewGoodbye(node2)
+	require.NoError(t, err)
+	defer goodbye2.Close()
+
+	sub, err := node2.EventBus().Subscribe(new(EvtPeerGoodbye))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	err = connectNodes(ctx, node1, node2)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, node1, node2, 10*time.Second)
+	require.NoError(t, err)
+
+	t.Run("ReasonDelivered", func(t *testing.T) {
+		err := goodbye1.Disconnect(ctx, node2.ID(), GoodbyeBanned, "test ban")
+		require.NoError(t, err)
+
+		select {
+		case e := <-sub.Out():
+			evt := e.(EvtPeerGoodbye)
+			assert.Equal(t, node1.ID(), evt.Peer)
+			assert.Equal(t, GoodbyeBanned, evt.Reason)
+			assert.Equal(t, "test ban", evt.Message)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for goodbye")
+		}
+
+		assert.NotEqual(t, network.Connected, node1.Network().Connectedness(node2.ID()))
+	})
+
+	t.Run("DisconnectWithoutConnection", func(t *testing.T) {
+		// Saying goodbye to a peer we're not connected to must not dial it
+		err := goodbye1.Disconnect(ctx, node2.ID(), GoodbyeShutdown, "")
+		require.NoError(t, err)
+		assert.NotEqual(t, network.Connected, node1.Network().Connectedness(node2.ID()))
+	})
+}
@@ -0,0 +1,283 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HealthProtocol serves a health report to monitoring peers
+	HealthProtocol = "/libp2p-learn/health/1.0.0"
+
+	// healthTimeout bounds one health probe
+	healthTimeout = 10 * time.Second
+
+	// maxHealthReportSize bounds the size of a health report
+	maxHealthReportSize = 64 * 1024
+)
+
+// Health statuses, from best to worst
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// healthRank orders the statuses so the worst one wins
+var healthRank = map[string]int{HealthOK: 0, HealthDegraded: 1, HealthDown: 2}
+
+// HealthReport is what a node reports about itself. Status is the worst
+// status of its subsystems.
+type HealthReport struct {
+	PeerID       string            `json:"peer_id"`
+	Status       string            `json:"status"`
+	Uptime       Duration          `json:"uptime"`
+	Reachability string            `json:"reachability"` // public, private or unknown
+	Peers        int               `json:"peers"`
+	Connections  int               `json:"connections"`
+	Streams      int               `json:"streams"`
+	Resources    HealthResources   `json:"resources"`
+	Subsystems   []SubsystemHealth `json:"subsystems"`
+	Time         time.Time         `json:"time"`
+}
+
+// HealthResources is the resource usage of the process and of libp2p's
+// resource manager
+type HealthResources struct {
+	Goroutines int    `json:"goroutines"`
+	HeapBytes  uint64 `json:"heap_bytes"`
+	SysBytes   uint64 `json:"sys_bytes"`
+	Memory     int64  `json:"libp2p_memory"` // reserved through the resource manager
+	FDs        int    `json:"libp2p_fds"`
+}
+
+// SubsystemHealth is the status of one subsystem
+type SubsystemHealth struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthCheck reports the status of a subsystem and, if it isn't ok, why
+type HealthCheck func(ctx context.Context) (status, message string)
+
+// Health serves health reports to peers and probes theirs. Subsystems add
+// checks that run for every report.
+type Health struct {
+	host    host.Host
+	started time.Time
+	sub     event.Subscription
+
+	mu           sync.RWMutex
+	reachability network.Reachability
+	checks       map[string]HealthCheck
+}
+
+// NewHealth creates the health service and registers its protocol handler
+func NewHealth(h host.Host) (*Health, error) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to reachability changes: %w", err)
+	}
+	hs := &Health{
+		host:    h,
+		started: time.Now(),
+		sub:     sub,
+		checks:  make(map[string]HealthCheck),
+	}
+	go hs.watchReachability()
+
+	h.SetStreamHandler(protocol.ID(HealthProtocol), RecoveryMiddleware(protocol.ID(HealthProtocol), hs.handleHealth))
+	logrus.WithField("protocol", HealthProtocol).Info("Registered health protocol")
+	return hs, nil
+}
+
+// Close unregisters the health protocol
+func (hs *Health) Close() {
+	hs.host.RemoveStreamHandler(protocol.ID(HealthProtocol))
+	hs.sub.Close()
+}
+
+// SetCheck adds or replaces the check of a subsystem; a nil check removes it
+func (hs *Health) SetCheck(name string, check HealthCheck) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if check == nil {
+		delete(hs.checks, name)
+		return
+	}
+	hs.checks[name] = check
+}
+
+// watchReachability tracks what AutoNAT found out about our reachability
+func (hs *Health) watchReachability() {
+	for e := range hs.sub.Out() {
+		evt := e.(event.EvtLocalReachabilityChanged)
+		hs.mu.Lock()
+		hs.reachability = evt.Reachability
+		hs.mu.Unlock()
+	}
+}
+
+// Report runs the checks and reports on this node
+func (hs *Health) Report(ctx context.Context) HealthReport {
+	hs.mu.RLock()
+	reachability := hs.reachability
+	checks := make(map[string]HealthCheck, len(hs.checks))
+	for name, check := range hs.checks {
+		checks[name] = check
+	}
+	hs.mu.RUnlock()
+
+	report := HealthReport{
+		PeerID:       hs.host.ID().String(),
+		Status:       HealthOK,
+		Uptime:       Duration(time.Since(hs.started).Round(time.Second)),
+		Reachability: strings.ToLower(reachability.String()),
+		Peers:        len(hs.host.Network().Peers()),
+		Time:         time.Now(),
+	}
+	for _, c := range hs.host.Network().Conns() {
+		report.Connections++
+		report.Streams += len(c.GetStreams())
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	report.Resources = HealthResources{
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  mem.HeapAlloc,
+		SysBytes:   mem.Sys,
+	}
+	hs.host.Network().ResourceManager().ViewSystem(func(scope network.ResourceScope) error {
+		stat := scope.Stat()
+		report.Resources.Memory = stat.Memory
+		report.Resources.FDs = stat.NumFD
+		return nil
+	})
+
+	for name, check := range checks {
+		status, message := check(ctx)
+		report.Subsystems = append(report.Subsystems, SubsystemHealth{Name: name, Status: status, Message: message})
+		if healthRank[status] > healthRank[report.Status] {
+			report.Status = status
+		}
+	}
+	sort.Slice(report.Subsystems, func(i, j int) bool { return report.Subsystems[i].Name < report.Subsystems[j].Name })
+	return report
+}
+
+// handleHealth sends our health report as one JSON line
+func (hs *Health) handleHealth(s network.Stream) {
+	defer s.Close()
+
+	s.SetDeadline(time.Now().Add(healthTimeout))
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(hs.Report(ctx))
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode health report")
+		s.Reset()
+		return
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).WithField("peer", s.Conn().RemotePeer()).Debug("Failed to send health report")
+	}
+}
+
+// Check asks a peer for its health report
+func (hs *Health) Check(ctx context.Context, p peer.ID) (*HealthReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	s, err := hs.host.NewStream(ctx, p, protocol.ID(HealthProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	s.CloseWrite()
+
+	line, err := bufio.NewReaderSize(io.LimitReader(s, maxHealthReportSize), maxHealthReportSize).ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read health report: %w", err)
+	}
+	var report HealthReport
+	if err := json.Unmarshal(line, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse health report: %w", err)
+	}
+	return &report, nil
+}
+
+// Health returns the health service
+func (n *Node) Health() *Health {
+	return n.health
+}
+
+// addHealthChecks checks the subsystems every node has, and the replicated
+// log, eclipse detection and blob store if they are enabled
+func (n *Node) addHealthChecks() {
+	n.health.SetCheck("dht", func(ctx context.Context) (string, string) {
+		switch {
+		case n.dht == nil:
+			return HealthDown, "not started"
+		case n.dht.RoutingTable().Size() == 0:
+			return HealthDegraded, "routing table is empty"
+		}
+		return HealthOK, ""
+	})
+	n.health.SetCheck("peers", func(ctx context.Context) (string, string) {
+		if len(n.host.Network().Peers()) == 0 {
+			return HealthDegraded, "no connected peers"
+		}
+		return HealthOK, ""
+	})
+	n.health.SetCheck("datastore", func(ctx context.Context) (string, string) {
+		if _, err := n.datastore.Has(ctx, datastore.NewKey("/health")); err != nil {
+			return HealthDown, err.Error()
+		}
+		return HealthOK, ""
+	})
+	if n.raft != nil {
+		n.health.SetCheck("raft", func(ctx context.Context) (string, string) {
+			if n.raft.Status().Leader == "" {
+				return HealthDegraded, "no leader"
+			}
+			return HealthOK, ""
+		})
+	}
+	if n.eclipse != nil {
+		n.health.SetCheck("eclipse", n.eclipse.health)
+	}
+	if n.blobs != nil {
+		n.health.SetCheck("blobs", func(ctx context.Context) (string, string) {
+			stat, err := n.blobs.Stat()
+			if err != nil {
+				return HealthDown, err.Error()
+			}
+			if stat.Quota > 0 && stat.Size > stat.Quota {
+				return HealthDegraded, fmt.Sprintf("%d bytes stored, over the %d byte quota", stat.Size, stat.Quota)
+			}
+			return HealthOK, ""
+		})
+	}
+}
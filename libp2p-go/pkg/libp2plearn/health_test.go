@@ -0,0 +1,67 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	monitored, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer monitored.Stop(ctx)
+
+	monitor, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer monitor.Stop(ctx)
+
+	t.Run("NotStarted", func(t *testing.T) {
+		report := monitored.Health().Report(ctx)
+		assert.Equal(t, HealthDown, report.Status)
+		assert.Contains(t, report.Subsystems, SubsystemHealth{Name: "dht", Status: HealthDown, Message: "not started"})
+		assert.Contains(t, report.Subsystems, SubsystemHealth{Name: "peers", Status: HealthDegraded, Message: "no connected peers"})
+		assert.Equal(t, "unknown", report.Reachability)
+	})
+
+	require.NoError(t, monitored.Start(ctx))
+	require.NoError(t, connectNodes(ctx, monitor.Host(), monitored.Host()))
+
+	t.Run("Remote", func(t *testing.T) {
+		report, err := monitor.Health().Check(ctx, monitored.Host().ID())
+		require.NoError(t, err)
+		assert.Equal(t, monitored.Host().ID().String(), report.PeerID)
+		assert.Equal(t, 1, report.Peers)
+		assert.Positive(t, report.Resources.Goroutines)
+		assert.Positive(t, report.Resources.HeapBytes)
+		assert.WithinDuration(t, time.Now(), report.Time, 5*time.Second)
+
+		statuses := make(map[string]string)
+		for _, sub := range report.Subsystems {
+			statuses[sub.Name] = sub.Status
+		}
+		assert.Equal(t, HealthOK, statuses["peers"])
+		assert.Equal(t, HealthOK, statuses["datastore"])
+		assert.Contains(t, statuses, "dht")
+	})
+
+	t.Run("WorstSubsystemWins", func(t *testing.T) {
+		monitored.Health().SetCheck("custom", func(ctx context.Context) (string, string) {
+			return HealthDown, "disk full"
+		})
+		report, err := monitor.Health().Check(ctx, monitored.Host().ID())
+		require.NoError(t, err)
+		assert.Equal(t, HealthDown, report.Status)
+		assert.Contains(t, report.Subsystems, SubsystemHealth{Name: "custom", Status: HealthDown, Message: "disk full"})
+
+		monitored.Health().SetCheck("custom", nil)
+		report, err = monitor.Health().Check(ctx, monitored.Host().ID())
+		require.NoError(t, err)
+		assert.NotContains(t, report.Subsystems, SubsystemHealth{Name: "custom", Status: HealthDown, Message: "disk full"})
+	})
+}
@@ -1,15 +1,15 @@
-package main
+package libp2plearn
 
 import (
 	"context"
 	"fmt"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
-	"github.com/multiformats/go-multiaddr"
 )
 
 // WaitForConnection waits for two nodes to be connected using channels
@@ -17,28 +17,29 @@ func WaitForConnection(ctx context.Context, node1, node2 host.Host, timeout time
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	// Subscribe before checking so a connection in between isn't missed
+	sub, err := node1.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to connection events: %w", err)
+	}
+	defer sub.Close()
+
 	// Check if already connected
 	if isConnected(node1, node2.ID()) && isConnected(node2, node1.ID()) {
 		return nil
 	}
 
-	// Create a channel to signal connection
-	connected := make(chan struct{})
-
-	// Set up notification for node1
-	notifiee1 := &connectionNotifiee{
-		targetPeer: node2.ID(),
-		connected:  connected,
-	}
-	node1.Network().Notify(notifiee1)
-	defer node1.Network().StopNotify(notifiee1)
-
 	// Wait for connection or timeout
-	select {
-	case <-connected:
-		return nil
-	case <-ctx.Done():
-		return fmt.Errorf("timeout waiting for connection")
+	for {
+		select {
+		case e := <-sub.Out():
+			evt := e.(event.EvtPeerConnectednessChanged)
+			if evt.Peer == node2.ID() && evt.Connectedness == network.Connected {
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for connection")
+		}
 	}
 }
 
@@ -125,24 +126,6 @@ func WaitForAllConnections(ctx context.Context, nodes []host.Host, timeout time.
 	return nil
 }
 
-// connectionNotifiee implements network.Notifiee to listen for connection events
-type connectionNotifiee struct {
-	targetPeer peer.ID
-	connected  chan struct{}
-	notified   bool
-}
-
-func (n *connectionNotifiee) Listen(network.Network, multiaddr.Multiaddr)      {}
-func (n *connectionNotifiee) ListenClose(network.Network, multiaddr.Multiaddr) {}
-func (n *connectionNotifiee) Disconnected(network.Network, network.Conn) {}
-
-func (n *connectionNotifiee) Connected(net network.Network, conn network.Conn) {
-	if !n.notified && conn.RemotePeer() == n.targetPeer {
-		n.notified = true
-		close(n.connected)
-	}
-}
-
 // isConnected checks if two nodes are connected
 func isConnected(node host.Host, peerID peer.ID) bool {
 	peers := node.Network().Peers()
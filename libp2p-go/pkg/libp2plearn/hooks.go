@@ -0,0 +1,114 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// LifecycleHook runs when the node starts or stops
+type LifecycleHook func(ctx context.Context) error
+
+// PeerHook runs when a peer connects or disconnects
+type PeerHook func(p peer.ID)
+
+// MessageHook runs for every message received by a custom protocol
+type MessageHook func(proto protocol.ID, from peer.ID, msg string)
+
+// MessageAction is what a MessageFilter decides to do with a message
+type MessageAction struct {
+	Message string // the message, possibly rewritten
+	Reply   string // sent instead of the protocol's usual response if set
+	Drop    bool   // ignore the message: no hooks, and the stream closes without a response
+}
+
+// MessageFilter may rewrite, drop or answer a ping or chat message before
+// the message hooks see it and the protocol responds
+type MessageFilter func(proto protocol.ID, from peer.ID, msg string) MessageAction
+
+// hooks holds the callbacks registered by embedding applications
+type hooks struct {
+	mu                 sync.RWMutex
+	onStart            []LifecycleHook
+	onStop             []LifecycleHook
+	onPeerConnected    []PeerHook
+	onPeerDisconnected []PeerHook
+}
+
+// OnStart registers a hook that runs at the end of Start. An error fails Start.
+func (n *Node) OnStart(fn LifecycleHook) {
+	n.hooks.mu.Lock()
+	n.hooks.onStart = append(n.hooks.onStart, fn)
+	n.hooks.mu.Unlock()
+}
+
+// OnStop registers a hook that runs at the beginning of Stop, while peers are still connected
+func (n *Node) OnStop(fn LifecycleHook) {
+	n.hooks.mu.Lock()
+	n.hooks.onStop = append(n.hooks.onStop, fn)
+	n.hooks.mu.Unlock()
+}
+
+// OnPeerConnected registers a hook that runs when the first connection to a peer opens.
+// Peer hooks run on a single goroutine and must not block.
+func (n *Node) OnPeerConnected(fn PeerHook) {
+	n.hooks.mu.Lock()
+	n.hooks.onPeerConnected = append(n.hooks.onPeerConnected, fn)
+	n.hooks.mu.Unlock()
+}
+
+// OnPeerDisconnected registers a hook that runs when the last connection to a peer closes
+func (n *Node) OnPeerDisconnected(fn PeerHook) {
+	n.hooks.mu.Lock()
+	n.hooks.onPeerDisconnected = append(n.hooks.onPeerDisconnected, fn)
+	n.hooks.mu.Unlock()
+}
+
+// OnProtocolMessage registers a hook that runs for every ping and chat message received
+func (n *Node) OnProtocolMessage(fn MessageHook) {
+	n.protocols.OnMessage(fn)
+}
+
+// FilterProtocolMessages registers a filter for every ping and chat message
+// received, run before the message hooks
+func (n *Node) FilterProtocolMessages(fn MessageFilter) {
+	n.protocols.AddFilter(fn)
+}
+
+// runLifecycleHooks runs the hooks in registration order, stopping at the first error
+func runLifecycleHooks(ctx context.Context, stage string, fns []LifecycleHook) error {
+	for i, fn := range fns {
+		if err := fn(ctx); err != nil {
+			return fmt.Errorf("%s hook %d failed: %w", stage, i, err)
+		}
+	}
+	return nil
+}
+
+// lifecycle returns a snapshot of the start or stop hooks
+func (h *hooks) lifecycle(start bool) []LifecycleHook {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if start {
+		return append([]LifecycleHook(nil), h.onStart...)
+	}
+	return append([]LifecycleHook(nil), h.onStop...)
+}
+
+// firePeer runs the connected or disconnected hooks for a peer
+func (h *hooks) firePeer(p peer.ID, connected bool) {
+	h.mu.RLock()
+	fns := h.onPeerDisconnected
+	if connected {
+		fns = h.onPeerConnected
+	}
+	fns = append([]PeerHook(nil), fns...)
+	h.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(p)
+	}
+}
@@ -0,0 +1,175 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeHooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("PeerAndMessageHooks", func(t *testing.T) {
+		node1, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		node2, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+
+		started := make(chan struct{}, 1)
+		stopped := make(chan struct{}, 1)
+		connected := make(chan peer.ID, 1)
+		disconnected := make(chan peer.ID, 1)
+		messages := make(chan string, 1)
+
+		node2.OnStart(func(ctx context.Context) error {
+			started <- struct{}{}
+			return nil
+		})
+		node2.OnStop(func(ctx context.Context) error {
+			stopped <- struct{}{}
+			return nil
+		})
+		node2.OnPeerConnected(func(p peer.ID) { connected <- p })
+		node2.OnPeerDisconnected(func(p peer.ID) { disconnected <- p })
+		node2.OnProtocolMessage(func(proto protocol.ID, from peer.ID, msg string) {
+			if proto == PingProtocol && from == node1.Host().ID() {
+				messages <- msg
+			}
+		})
+
+		require.NoError(t, node1.Start(ctx))
+		require.NoError(t, node2.Start(ctx))
+		defer node2.Stop(ctx)
+		<-started
+
+		addr := fmt.Sprintf("%s/p2p/%s", node2.Host().Addrs()[0], node2.Host().ID())
+		require.NoError(t, node1.Connect(ctx, addr))
+
+		select {
+		case p := <-connected:
+			assert.Equal(t, node1.Host().ID(), p)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for peer connected hook")
+		}
+
+		_, err = node1.Protocols().SendPing(ctx, node2.Host().ID(), "hello hooks")
+		require.NoError(t, err)
+		select {
+		case msg := <-messages:
+			assert.Equal(t, "hello hooks", msg)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for protocol message hook")
+		}
+
+		require.NoError(t, node1.Stop(ctx))
+		select {
+		case p := <-disconnected:
+			assert.Equal(t, node1.Host().ID(), p)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for peer disconnected hook")
+		}
+
+		require.NoError(t, node2.Stop(ctx))
+		select {
+		case <-stopped:
+		default:
+			t.Fatal("stop hook did not run")
+		}
+	})
+
+	t.Run("MessageFilters", func(t *testing.T) {
+		h1, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer h1.Close()
+		h2, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer h2.Close()
+		require.NoError(t, connectNodes(ctx, h1, h2))
+
+		p1 := NewProtocolHandler(h1)
+		p2 := NewProtocolHandler(h2)
+		p2.SetupProtocols()
+
+		var mu sync.Mutex
+		var seen []string
+		p2.OnMessage(func(proto protocol.ID, from peer.ID, msg string) {
+			mu.Lock()
+			seen = append(seen, msg)
+			mu.Unlock()
+		})
+		p2.AddFilter(func(proto protocol.ID, from peer.ID, msg string) MessageAction {
+			return MessageAction{Message: strings.ToUpper(msg), Drop: msg == "spam"}
+		})
+		p2.AddFilter(func(proto protocol.ID, from peer.ID, msg string) MessageAction {
+			if proto == ChatProtocol && msg == "HELP" {
+				return MessageAction{Message: msg, Reply: "try /help"}
+			}
+			return MessageAction{Message: msg}
+		})
+
+		pong, err := p1.SendPing(ctx, h2.ID(), "quiet")
+		require.NoError(t, err)
+		assert.Equal(t, "pong: QUIET", pong)
+
+		reply, err := p1.SendChatMessage(ctx, h2.ID(), "help")
+		require.NoError(t, err)
+		assert.Equal(t, "try /help", reply)
+
+		_, err = p1.SendChatMessage(ctx, h2.ID(), "spam")
+		assert.Error(t, err, "dropped messages get no response")
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{"QUIET", "HELP"}, seen)
+	})
+
+	t.Run("FailingStartHook", func(t *testing.T) {
+		node, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		defer node.Stop(ctx)
+
+		node.OnStart(func(ctx context.Context) error {
+			return errors.New("not ready")
+		})
+		assert.ErrorContains(t, node.Start(ctx), "not ready")
+	})
+
+	t.Run("HooksUseNode", func(t *testing.T) {
+		node, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+
+		// Hooks may read and reload the configuration without deadlocking
+		reload := func(ctx context.Context) error {
+			cfg := *node.Config()
+			_, err := node.Reload(&cfg)
+			return err
+		}
+		node.OnStart(reload)
+		node.OnStop(reload)
+
+		done := make(chan error, 1)
+		go func() {
+			if err := node.Start(ctx); err != nil {
+				done <- err
+				return
+			}
+			done <- node.Stop(ctx)
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Start or Stop deadlocked")
+		}
+	})
+}
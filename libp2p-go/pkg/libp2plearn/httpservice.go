@@ -0,0 +1,98 @@
+package libp2plearn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	libp2phttp "github.com/libp2p/go-libp2p/p2p/http"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HTTPEchoProtocol is a demo HTTP service served over libp2p streams
+	HTTPEchoProtocol = "/libp2p-learn/http-echo/1.0.0"
+)
+
+// HTTPService serves and consumes HTTP semantics over libp2p streams
+type HTTPService struct {
+	httpHost *libp2phttp.Host
+}
+
+// NewHTTPService creates an HTTP service on top of the given libp2p host
+func NewHTTPService(h host.Host) *HTTPService {
+	return &HTTPService{
+		httpHost: &libp2phttp.Host{StreamHost: h},
+	}
+}
+
+// SetupHandlers mounts the built-in HTTP handlers
+func (s *HTTPService) SetupHandlers() {
+	s.Handle(protocol.ID(HTTPEchoProtocol), http.HandlerFunc(s.handleEcho))
+}
+
+// Handle mounts an HTTP handler for a protocol and advertises it in the well-known resource
+func (s *HTTPService) Handle(p protocol.ID, handler http.Handler) {
+	s.httpHost.SetHTTPHandler(p, handler)
+	logrus.WithField("protocol", p).Info("Registered HTTP handler")
+}
+
+// Start serves HTTP requests arriving on libp2p streams in the background
+func (s *HTTPService) Start() {
+	go func() {
+		if err := s.httpHost.Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).Error("HTTP service stopped")
+		}
+	}()
+	logrus.WithField("protocol", libp2phttp.ProtocolIDForMultistreamSelect).Info("HTTP service started")
+}
+
+// Close stops serving HTTP requests
+func (s *HTTPService) Close() error {
+	return s.httpHost.Close()
+}
+
+// Client returns an HTTP client scoped to a protocol served by the given peer
+func (s *HTTPService) Client(peerID peer.ID, p protocol.ID) (http.Client, error) {
+	client, err := s.httpHost.NamespacedClient(p, peer.AddrInfo{ID: peerID})
+	if err != nil {
+		return http.Client{}, fmt.Errorf("failed to create HTTP client for %s: %w", p, err)
+	}
+	return client, nil
+}
+
+// Protocols fetches the HTTP protocols a peer advertises via its well-known resource
+func (s *HTTPService) Protocols(peerID peer.ID) (libp2phttp.PeerMeta, error) {
+	rt, err := s.httpHost.NewConstrainedRoundTripper(peer.AddrInfo{ID: peerID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create round tripper: %w", err)
+	}
+
+	getter, ok := rt.(libp2phttp.PeerMetadataGetter)
+	if !ok {
+		return nil, fmt.Errorf("round tripper does not support protocol discovery")
+	}
+
+	meta, err := getter.GetPeerMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get protocols from %s: %w", peerID, err)
+	}
+	return meta, nil
+}
+
+// handleEcho writes the request body back to the caller
+func (s *HTTPService) handleEcho(w http.ResponseWriter, r *http.Request) {
+	remote := libp2phttp.ClientPeerID(r)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, io.LimitReader(r.Body, maxGatewayBodySize)); err != nil {
+		logrus.WithError(err).Error("Failed to echo HTTP body")
+		return
+	}
+
+	logrus.WithField("peer", remote).Debug("Handled HTTP echo request")
+}
@@ -0,0 +1,58 @@
+package libp2plearn
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	serverService := NewHTTPService(server)
+	serverService.SetupHandlers()
+	serverService.Start()
+	defer serverService.Close()
+
+	clientService := NewHTTPService(client)
+
+	err = connectNodes(ctx, client, server)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, client, server, 10*time.Second)
+	require.NoError(t, err)
+
+	t.Run("WellKnownProtocols", func(t *testing.T) {
+		meta, err := clientService.Protocols(server.ID())
+		require.NoError(t, err)
+		assert.Contains(t, meta, protocol.ID(HTTPEchoProtocol), "Server should advertise the HTTP echo protocol")
+	})
+
+	t.Run("EchoOverStreams", func(t *testing.T) {
+		httpClient, err := clientService.Client(server.ID(), HTTPEchoProtocol)
+		require.NoError(t, err)
+
+		resp, err := httpClient.Post("/", "text/plain", strings.NewReader("hello over libp2p"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello over libp2p", string(body))
+	})
+}
@@ -0,0 +1,50 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// LoadIdentity reads the node's private key from path, generating and saving
+// a new Ed25519 key if the file doesn't exist, so the peer ID survives restarts
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity: %w", err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity: %w", err)
+	}
+	data, err = crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode identity: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write identity: %w", err)
+	}
+
+	id, _ := peer.IDFromPrivateKey(key)
+	logrus.WithFields(logrus.Fields{
+		"file":    path,
+		"peer_id": id,
+	}).Info("Generated new node identity")
+	return key, nil
+}
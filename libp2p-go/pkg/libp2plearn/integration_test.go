@@ -1,4 +1,4 @@
-package main
+package libp2plearn
 
 import (
 	"context"
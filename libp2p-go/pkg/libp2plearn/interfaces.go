@@ -0,0 +1,61 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+// interfaceHosts returns the multiaddr IP prefixes (e.g. /ip4/192.168.1.5)
+// of the addresses assigned to the named network interfaces
+func interfaceHosts(names []string) ([]string, error) {
+	var hosts []string
+
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find interface %s: %w", name, err)
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+		}
+
+		found := 0
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			// Link-local addresses need a zone to be dialable, so skip them
+			if ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				hosts = append(hosts, "/ip4/"+ip4.String())
+			} else {
+				hosts = append(hosts, "/ip6/"+ipNet.IP.String())
+			}
+			found++
+		}
+
+		if found == 0 {
+			logrus.WithField("interface", name).Warn("Interface has no usable addresses")
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no usable addresses on interfaces %v", names)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"interfaces": names,
+		"hosts":      hosts,
+	}).Info("Binding to network interfaces")
+
+	return hosts, nil
+}
@@ -0,0 +1,448 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Secure channels and muxers of interop cases
+	InteropNoise = "noise"
+	InteropTLS   = "tls"
+	InteropYamux = "yamux"
+
+	interopMessage     = "interop"
+	interopStopTimeout = 5 * time.Second
+)
+
+// interopSecurityIDs and interopMuxerIDs are the protocol IDs libp2p
+// negotiates for each secure channel and muxer
+var (
+	interopSecurityIDs = map[string]string{InteropNoise: "/noise", InteropTLS: "/tls/1.0.0"}
+	interopMuxerIDs    = map[string]string{InteropYamux: "/yamux/1.0.0"}
+)
+
+// InteropCase is one combination of transport, secure channel and muxer to
+// test another implementation with. Transports are named as transportName
+// names them; Security and Muxer are empty for transports that bring their
+// own (QUIC and WebTransport).
+type InteropCase struct {
+	Transport string `json:"transport"`
+	Security  string `json:"security,omitempty"`
+	Muxer     string `json:"muxer,omitempty"`
+}
+
+func (c InteropCase) String() string {
+	parts := []string{c.Transport}
+	for _, part := range []string{c.Security, c.Muxer} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// InteropImplementation is another libp2p implementation, run as a peer
+// process speaking the interop harness protocol: started with TRANSPORT,
+// SECURITY and MUXER in its environment, it listens on 127.0.0.1 with only
+// those, prints {"id", "addrs"} as one JSON line, and serves
+// /ipfs/ping/1.0.0, identify and our ping and echo protocols. Each
+// {"addr", "protocol", "data"} line it reads then makes it dial addr, run
+// the client side of protocol and print {"response", "error"}, where the
+// response of a line protocol is the line without its newline.
+type InteropImplementation struct {
+	Name       string
+	Command    []string
+	Transports []string
+	Securities []string
+	Muxers     []string
+}
+
+// Cases returns every combination the implementation supports
+func (impl InteropImplementation) Cases() []InteropCase {
+	var cases []InteropCase
+	for _, tpt := range impl.Transports {
+		if tpt == "quic" || tpt == "webtransport" {
+			cases = append(cases, InteropCase{Transport: tpt})
+			continue
+		}
+		for _, sec := range impl.Securities {
+			for _, mux := range impl.Muxers {
+				cases = append(cases, InteropCase{Transport: tpt, Security: sec, Muxer: mux})
+			}
+		}
+	}
+	return cases
+}
+
+// InteropImplementations returns the peers under interop/ in the source
+// tree at dir. With docker they run from the images make interop-images
+// builds, otherwise with node and cargo from the tree. Neither js-libp2p
+// in Node.js nor native rust-libp2p can dial WebTransport, so only a
+// browser peer could cover it.
+func InteropImplementations(dir string, docker bool) []InteropImplementation {
+	js := InteropImplementation{
+		Name:       "js",
+		Command:    []string{"node", filepath.Join(dir, "interop", "js", "peer.mjs")},
+		Transports: []string{"tcp", "websocket"},
+		Securities: []string{InteropNoise, InteropTLS},
+		Muxers:     []string{InteropYamux},
+	}
+	rust := InteropImplementation{
+		Name:       "rust",
+		Command:    []string{"cargo", "run", "--quiet", "--release", "--manifest-path", filepath.Join(dir, "interop", "rust", "Cargo.toml")},
+		Transports: []string{"tcp", "quic", "websocket"},
+		Securities: []string{InteropNoise, InteropTLS},
+		Muxers:     []string{InteropYamux},
+	}
+	if docker {
+		js.Command = interopDockerCommand("libp2p-learn-interop-js")
+		rust.Command = interopDockerCommand("libp2p-learn-interop-rust")
+	}
+	return []InteropImplementation{js, rust}
+}
+
+// interopDockerCommand runs an image on the host network, passing the case
+// through from our environment
+func interopDockerCommand(image string) []string {
+	return []string{"docker", "run", "--rm", "-i", "--network", "host",
+		"-e", "TRANSPORT", "-e", "SECURITY", "-e", "MUXER", image}
+}
+
+// InteropPeer is a running peer of another implementation
+type InteropPeer struct {
+	ID    peer.ID
+	Addrs []multiaddr.Multiaddr
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *io.PipeWriter
+	mu     sync.Mutex
+}
+
+// interopHello is the first line a peer prints
+type interopHello struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// interopRequest asks a peer to run the client side of a protocol
+type interopRequest struct {
+	Addr     string `json:"addr"`
+	Protocol string `json:"protocol"`
+	Data     string `json:"data,omitempty"`
+}
+
+// interopReply is what a peer got back
+type interopReply struct {
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StartInteropPeer starts a peer of the implementation for the case and
+// waits until it listens
+func StartInteropPeer(ctx context.Context, impl InteropImplementation, c InteropCase) (*InteropPeer, error) {
+	if len(impl.Command) == 0 {
+		return nil, fmt.Errorf("no command for %s", impl.Name)
+	}
+	cmd := exec.Command(impl.Command[0], impl.Command[1:]...)
+	cmd.Env = append(os.Environ(), "TRANSPORT="+c.Transport, "SECURITY="+c.Security, "MUXER="+c.Muxer)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peer stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peer stdout: %w", err)
+	}
+	stderr := logrus.WithField("implementation", impl.Name).WriterLevel(logrus.DebugLevel)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("failed to start %s peer: %w", impl.Name, err)
+	}
+
+	p := &InteropPeer{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout), stderr: stderr}
+	var hello interopHello
+	if err := p.readLine(ctx, &hello); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("%s peer didn't start: %w", impl.Name, err)
+	}
+	if p.ID, err = peer.Decode(hello.ID); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("invalid peer ID from %s peer: %w", impl.Name, err)
+	}
+	for _, s := range hello.Addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("invalid address from %s peer: %w", impl.Name, err)
+		}
+		// Some implementations print their addresses with their peer ID
+		addr, _ = peer.SplitAddr(addr)
+		p.Addrs = append(p.Addrs, addr)
+	}
+	return p, nil
+}
+
+// readLine reads one JSON line from the peer into v
+func (p *InteropPeer) readLine(ctx context.Context, v interface{}) error {
+	type result struct {
+		line []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := p.stdout.ReadBytes('\n')
+		done <- result{line, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return fmt.Errorf("failed to read from peer: %w", r.err)
+		}
+		return json.Unmarshal(r.line, v)
+	case <-ctx.Done():
+		// Killing the peer ends the read
+		p.cmd.Process.Kill()
+		return ctx.Err()
+	}
+}
+
+// Call makes the peer dial addr and run the client side of proto, sending
+// data, and returns the response it got
+func (p *InteropPeer) Call(ctx context.Context, addr multiaddr.Multiaddr, proto, data string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req, err := json.Marshal(interopRequest{Addr: addr.String(), Protocol: proto, Data: data})
+	if err != nil {
+		return "", err
+	}
+	if _, err := p.stdin.Write(append(req, '\n')); err != nil {
+		return "", fmt.Errorf("failed to send request to peer: %w", err)
+	}
+	var reply interopReply
+	if err := p.readLine(ctx, &reply); err != nil {
+		return "", err
+	}
+	if reply.Error != "" {
+		return "", errors.New(reply.Error)
+	}
+	return reply.Response, nil
+}
+
+// Close stops the peer, killing it if it doesn't exit once its stdin closes
+func (p *InteropPeer) Close() error {
+	p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(interopStopTimeout):
+		p.cmd.Process.Kill()
+		err = <-exited
+	}
+	p.stderr.Close()
+	return err
+}
+
+// InteropCheck is the outcome of one check of an interop run
+type InteropCheck struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// InteropResult is the outcome of running a case against an implementation
+type InteropResult struct {
+	Implementation string         `json:"implementation"`
+	Case           InteropCase    `json:"case"`
+	Checks         []InteropCheck `json:"checks"`
+}
+
+// Failed returns the checks that failed
+func (r *InteropResult) Failed() []InteropCheck {
+	var failed []InteropCheck
+	for _, check := range r.Checks {
+		if check.Error != "" {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// check runs a check and records its outcome
+func (r *InteropResult) check(name string, f func() error) bool {
+	err := f()
+	check := InteropCheck{Name: name}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	r.Checks = append(r.Checks, check)
+	return err == nil
+}
+
+// RunInterop starts a peer of the implementation for the case and checks
+// both directions against the node. First the peer dials us and runs our
+// ping, echo and health protocols. Then we dial it, verify the negotiated
+// secure channel and muxer, identify and ping it and run our ping and echo
+// protocols. The node must listen on the case's transport.
+func RunInterop(ctx context.Context, n *Node, impl InteropImplementation, c InteropCase) (*InteropResult, error) {
+	h := n.Host()
+	local := interopAddr(h.Addrs(), c.Transport)
+	if local == nil {
+		return nil, fmt.Errorf("node doesn't listen on %s", c.Transport)
+	}
+	local = local.Encapsulate(multiaddr.StringCast("/p2p/" + h.ID().String()))
+
+	remote, err := StartInteropPeer(ctx, impl, c)
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Close()
+
+	result := &InteropResult{Implementation: impl.Name, Case: c}
+	result.check("remote-ping", func() error {
+		if err := checkInteropResponse(remote.Call(ctx, local, PingProtocol, interopMessage)); err != nil {
+			return err
+		}
+		return checkInteropConn(h.Network().ConnsToPeer(remote.ID), c)
+	})
+	result.check("remote-echo", func() error {
+		response, err := remote.Call(ctx, local, EchoProtocol, interopMessage)
+		if err != nil {
+			return err
+		}
+		if response != interopMessage {
+			return fmt.Errorf("unexpected echo %q", response)
+		}
+		return nil
+	})
+	result.check("remote-health", func() error {
+		response, err := remote.Call(ctx, local, HealthProtocol, "")
+		if err != nil {
+			return err
+		}
+		var report HealthReport
+		if err := json.Unmarshal([]byte(response), &report); err != nil {
+			return fmt.Errorf("failed to parse health report: %w", err)
+		}
+		if report.PeerID != h.ID().String() {
+			return fmt.Errorf("health report of %s", report.PeerID)
+		}
+		return nil
+	})
+
+	// Drop the peer's connection so we dial our own. The peer makes no more
+	// calls, so it never reuses the connection we closed.
+	h.Network().ClosePeer(remote.ID)
+	if !result.check("dial", func() error {
+		addr := interopAddr(remote.Addrs, c.Transport)
+		if addr == nil {
+			return fmt.Errorf("peer doesn't listen on %s: %v", c.Transport, remote.Addrs)
+		}
+		if err := h.Connect(ctx, peer.AddrInfo{ID: remote.ID, Addrs: []multiaddr.Multiaddr{addr}}); err != nil {
+			return err
+		}
+		return checkInteropConn(h.Network().ConnsToPeer(remote.ID), c)
+	}) {
+		return result, nil
+	}
+	result.check("identify", func() error {
+		for {
+			if agent, err := h.Peerstore().Get(remote.ID, "AgentVersion"); err == nil && agent != "" {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("peer wasn't identified: %w", ctx.Err())
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	})
+	result.check("libp2p-ping", func() error {
+		pctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		return (<-ping.Ping(pctx, h, remote.ID)).Error
+	})
+	result.check("ping", func() error {
+		return checkInteropResponse(n.Protocols().SendPing(ctx, remote.ID, interopMessage))
+	})
+	result.check("echo", func() error {
+		response, err := n.Protocols().SendEcho(ctx, remote.ID, interopMessage)
+		if err != nil {
+			return err
+		}
+		if response != interopMessage {
+			return fmt.Errorf("unexpected echo %q", response)
+		}
+		return nil
+	})
+	return result, nil
+}
+
+// checkInteropResponse checks a reply of our ping protocol, without its
+// newline
+func checkInteropResponse(response string, err error) error {
+	if err != nil {
+		return err
+	}
+	if response != "pong: "+interopMessage {
+		return fmt.Errorf("unexpected pong %q", response)
+	}
+	return nil
+}
+
+// checkInteropConn checks that a connection uses the case's transport,
+// secure channel and muxer
+func checkInteropConn(conns []network.Conn, c InteropCase) error {
+	if len(conns) == 0 {
+		return errors.New("not connected")
+	}
+	for _, conn := range conns {
+		if tpt := transportName(conn.RemoteMultiaddr()); tpt != c.Transport {
+			return fmt.Errorf("connected over %s", tpt)
+		}
+		state := conn.ConnState()
+		if c.Security != "" && string(state.Security) != interopSecurityIDs[c.Security] {
+			return fmt.Errorf("negotiated secure channel %s", state.Security)
+		}
+		if c.Muxer != "" && string(state.StreamMultiplexer) != interopMuxerIDs[c.Muxer] {
+			return fmt.Errorf("negotiated muxer %s", state.StreamMultiplexer)
+		}
+	}
+	return nil
+}
+
+// interopAddr returns the first loopback address using the transport.
+// Secure WebSockets are left out since the peers have no certificates.
+func interopAddr(addrs []multiaddr.Multiaddr, tpt string) multiaddr.Multiaddr {
+	for _, addr := range addrs {
+		if transportName(addr) == tpt && manet.IsIPLoopback(addr) &&
+			!hasProtocol(addr, multiaddr.P_WSS) && !hasProtocol(addr, multiaddr.P_TLS) {
+			return addr
+		}
+	}
+	return nil
+}
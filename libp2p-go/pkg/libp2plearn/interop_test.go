@@ -0,0 +1,185 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInteropCases(t *testing.T) {
+	impl := InteropImplementation{
+		Transports: []string{"tcp", "quic"},
+		Securities: []string{InteropNoise, InteropTLS},
+		Muxers:     []string{InteropYamux},
+	}
+	var names []string
+	for _, c := range impl.Cases() {
+		names = append(names, c.String())
+	}
+	assert.Equal(t, []string{"tcp/noise/yamux", "tcp/tls/yamux", "quic"}, names)
+
+	addrs := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/192.0.2.1/tcp/4001"),
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/4002/wss"),
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/4003/ws"),
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001"),
+	}
+	assert.Equal(t, addrs[3], interopAddr(addrs, "tcp"))
+	assert.Equal(t, addrs[2], interopAddr(addrs, "websocket"))
+	assert.Nil(t, interopAddr(addrs, "quic"))
+}
+
+// TestInterop runs the interop harness against other libp2p
+// implementations. Set LIBP2P_LEARN_INTEROP to the ones to test, such as
+// js,rust or all, after building their images with make interop-images, or
+// also set LIBP2P_LEARN_INTEROP_LOCAL=1 to run them with node and cargo.
+func TestInterop(t *testing.T) {
+	want := os.Getenv("LIBP2P_LEARN_INTEROP")
+	if want == "" {
+		t.Skip("set LIBP2P_LEARN_INTEROP to test other implementations")
+	}
+	docker := os.Getenv("LIBP2P_LEARN_INTEROP_LOCAL") == ""
+	for _, impl := range InteropImplementations(filepath.Join("..", ".."), docker) {
+		if want != "all" && !slices.Contains(strings.Split(want, ","), impl.Name) {
+			continue
+		}
+		t.Run(impl.Name, func(t *testing.T) {
+			runInteropCases(t, impl, 2*time.Minute)
+		})
+	}
+}
+
+// TestInteropHarness runs the harness against a go-libp2p peer, this test
+// binary run as TestInteropHelperPeer
+func TestInteropHarness(t *testing.T) {
+	t.Setenv("LIBP2P_LEARN_INTEROP_PEER", "1")
+	impl := InteropImplementation{
+		Name:       "go",
+		Command:    []string{os.Args[0], "-test.run=^TestInteropHelperPeer$"},
+		Transports: []string{"tcp", "websocket"},
+		Securities: []string{InteropNoise, InteropTLS},
+		Muxers:     []string{InteropYamux},
+	}
+	runInteropCases(t, impl, 30*time.Second)
+}
+
+// runInteropCases checks every case of the implementation against a node
+func runInteropCases(t *testing.T, impl InteropImplementation, timeout time.Duration) {
+	cfg := testNodeConfig()
+	cfg.EnableWebSocket = true
+	node, err := New(WithConfig(cfg),
+		WithLibp2pOptions(libp2p.ListenAddrStrings("/ip4/127.0.0.1/udp/0/quic-v1/webtransport")))
+	require.NoError(t, err)
+	defer node.Stop(context.Background())
+
+	for _, c := range impl.Cases() {
+		t.Run(c.String(), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			result, err := RunInterop(ctx, node, impl, c)
+			require.NoError(t, err)
+			assert.Empty(t, result.Failed())
+			assert.Len(t, result.Checks, 8)
+		})
+	}
+}
+
+// TestInteropHelperPeer is the peer TestInteropHarness starts. It does
+// nothing when run as a test.
+func TestInteropHelperPeer(t *testing.T) {
+	if os.Getenv("LIBP2P_LEARN_INTEROP_PEER") == "" {
+		return
+	}
+	c := InteropCase{Transport: os.Getenv("TRANSPORT"), Security: os.Getenv("SECURITY"), Muxer: os.Getenv("MUXER")}
+	opts := []libp2p.Option{libp2p.Muxer(yamux.ID, yamux.DefaultTransport)}
+	switch c.Transport {
+	case "tcp":
+		opts = append(opts, libp2p.Transport(tcp.NewTCPTransport), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	case "websocket":
+		opts = append(opts, libp2p.Transport(websocket.New), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0/ws"))
+	case "quic":
+		opts = append(opts, libp2p.Transport(libp2pquic.NewTransport), libp2p.ListenAddrStrings("/ip4/127.0.0.1/udp/0/quic-v1"))
+	}
+	switch c.Security {
+	case InteropNoise:
+		opts = append(opts, libp2p.Security(noise.ID, noise.New))
+	case InteropTLS:
+		opts = append(opts, libp2p.Security(libp2ptls.ID, libp2ptls.New))
+	}
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	defer h.Close()
+	handler := NewProtocolHandler(h)
+	handler.SetupProtocols()
+	health, err := NewHealth(h)
+	require.NoError(t, err)
+	defer health.Close()
+
+	hello := interopHello{ID: h.ID().String()}
+	for _, addr := range h.Addrs() {
+		hello.Addrs = append(hello.Addrs, addr.String())
+	}
+	out := json.NewEncoder(os.Stdout)
+	require.NoError(t, out.Encode(hello))
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req interopRequest
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &req))
+		response, err := interopHelperCall(h, handler, health, req)
+		reply := interopReply{Response: response}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		require.NoError(t, out.Encode(reply))
+	}
+}
+
+// interopHelperCall runs the client side of a request of the harness
+func interopHelperCall(h host.Host, handler *ProtocolHandler, health *Health, req interopRequest) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	info, err := peer.AddrInfoFromString(req.Addr)
+	if err != nil {
+		return "", err
+	}
+	if err := h.Connect(ctx, *info); err != nil {
+		return "", err
+	}
+	switch req.Protocol {
+	case PingProtocol:
+		return handler.SendPing(ctx, info.ID, req.Data)
+	case EchoProtocol:
+		return handler.SendEcho(ctx, info.ID, req.Data)
+	case HealthProtocol:
+		report, err := health.Check(ctx, info.ID)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(report)
+		return string(data), err
+	}
+	return "", fmt.Errorf("unknown protocol %s", req.Protocol)
+}
@@ -0,0 +1,153 @@
+package libp2plearn
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// InviteTokenPrefix starts every invite token and names its format
+	InviteTokenPrefix = "P2P1:"
+
+	// inviteAddrsPerClass bounds the public, private and relayed addresses
+	// of an invite each, to keep the token short
+	inviteAddrsPerClass = 2
+)
+
+// inviteEncoding is unpadded base32, whose characters the QR alphanumeric
+// mode holds, so the QR code of a token stays small
+var inviteEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrInvalidInvite is returned for tokens that aren't invites or were
+// mistyped
+var ErrInvalidInvite = errors.New("invalid invite token")
+
+// Invite is what another node needs to connect to this one: its peer ID and
+// the addresses most likely to reach it. As a token it is the prefix and
+// the base32 of the peer ID, each address and a checksum.
+type Invite struct {
+	ID    peer.ID
+	Addrs []multiaddr.Multiaddr
+}
+
+// NewInvite picks the best dialable addresses of a peer for an invite: up
+// to two public addresses, two private ones for peers on the same network
+// and two relayed ones, each in the order dials prefer. Loopback addresses
+// are only used when there is nothing else.
+func NewInvite(id peer.ID, addrs []multiaddr.Multiaddr) Invite {
+	var public, private, relayed, loopback []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		if transport, _ := peer.SplitAddr(addr); transport != nil {
+			addr = transport
+		}
+		switch {
+		case hasProtocol(addr, multiaddr.P_CIRCUIT):
+			relayed = append(relayed, addr)
+		case manet.IsIPLoopback(addr):
+			loopback = append(loopback, addr)
+		case manet.IsPublicAddr(addr):
+			public = append(public, addr)
+		default:
+			private = append(private, addr)
+		}
+	}
+
+	invite := Invite{ID: id}
+	for _, class := range [][]multiaddr.Multiaddr{public, private, relayed} {
+		invite.Addrs = append(invite.Addrs, bestAddrs(class)...)
+	}
+	if len(invite.Addrs) == 0 {
+		invite.Addrs = bestAddrs(loopback)
+	}
+	return invite
+}
+
+// bestAddrs returns the addresses dials try first
+func bestAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	addrs = append([]multiaddr.Multiaddr(nil), addrs...)
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return transportRank(addrs[i]) < transportRank(addrs[j])
+	})
+	return addrs[:min(len(addrs), inviteAddrsPerClass)]
+}
+
+// Token encodes the invite
+func (i Invite) Token() string {
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(i.ID)))
+	payload = append(payload, i.ID...)
+	for _, addr := range i.Addrs {
+		payload = binary.AppendUvarint(payload, uint64(len(addr.Bytes())))
+		payload = append(payload, addr.Bytes()...)
+	}
+	payload = binary.BigEndian.AppendUint32(payload, crc32.ChecksumIEEE(payload))
+	return InviteTokenPrefix + inviteEncoding.EncodeToString(payload)
+}
+
+// AddrInfo returns the peer and addresses to connect to
+func (i Invite) AddrInfo() peer.AddrInfo {
+	return peer.AddrInfo{ID: i.ID, Addrs: i.Addrs}
+}
+
+// ParseInvite decodes an invite token. Case and surrounding whitespace
+// don't matter, since tokens get read aloud and retyped.
+func ParseInvite(token string) (Invite, error) {
+	token = strings.ToUpper(strings.TrimSpace(token))
+	encoded, ok := strings.CutPrefix(token, InviteTokenPrefix)
+	if !ok {
+		return Invite{}, fmt.Errorf("%w: it doesn't start with %s", ErrInvalidInvite, InviteTokenPrefix)
+	}
+	payload, err := inviteEncoding.DecodeString(encoded)
+	if err != nil || len(payload) < 4 {
+		return Invite{}, fmt.Errorf("%w: it has been mistyped or cut short", ErrInvalidInvite)
+	}
+	payload, sum := payload[:len(payload)-4], payload[len(payload)-4:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum) {
+		return Invite{}, fmt.Errorf("%w: it has been mistyped or cut short", ErrInvalidInvite)
+	}
+
+	var fields [][]byte
+	for len(payload) > 0 {
+		n, read := binary.Uvarint(payload)
+		if read <= 0 || uint64(len(payload)-read) < n {
+			return Invite{}, fmt.Errorf("%w: truncated field", ErrInvalidInvite)
+		}
+		fields = append(fields, payload[read:read+int(n)])
+		payload = payload[read+int(n):]
+	}
+	if len(fields) == 0 {
+		return Invite{}, fmt.Errorf("%w: no peer ID", ErrInvalidInvite)
+	}
+
+	id, err := peer.IDFromBytes(fields[0])
+	if err != nil {
+		return Invite{}, fmt.Errorf("%w: %w", ErrInvalidInvite, err)
+	}
+	invite := Invite{ID: id}
+	for _, field := range fields[1:] {
+		addr, err := multiaddr.NewMultiaddrBytes(field)
+		if err != nil {
+			return Invite{}, fmt.Errorf("%w: %w", ErrInvalidInvite, err)
+		}
+		invite.Addrs = append(invite.Addrs, addr)
+	}
+	if len(invite.Addrs) == 0 {
+		return Invite{}, fmt.Errorf("%w: no addresses", ErrInvalidInvite)
+	}
+	return invite, nil
+}
+
+// Invite returns an invite to connect to this node at its advertised
+// addresses
+func (n *Node) Invite() Invite {
+	return NewInvite(n.host.ID(), n.host.Addrs())
+}
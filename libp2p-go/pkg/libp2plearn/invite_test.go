@@ -0,0 +1,90 @@
+package libp2plearn
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInvite(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	id, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+
+	addrs := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		multiaddr.StringCast("/ip4/192.168.1.20/tcp/4001"),
+		multiaddr.StringCast("/ip4/192.168.1.20/udp/4001/quic-v1"),
+		multiaddr.StringCast("/ip4/192.168.1.20/tcp/4002/ws"),
+		multiaddr.StringCast("/ip4/104.131.131.82/tcp/4001"),
+		multiaddr.StringCast("/ip4/104.131.131.82/udp/4001/quic-v1"),
+		multiaddr.StringCast("/ip4/198.51.100.1/tcp/4001/p2p/" + id.String() + "/p2p-circuit"),
+	}
+	invite := NewInvite(id, addrs)
+	assert.Equal(t, []multiaddr.Multiaddr{addrs[5], addrs[4], addrs[2], addrs[1], addrs[6]}, invite.Addrs)
+
+	// Loopback only when there is nothing else
+	invite = NewInvite(id, addrs[:1])
+	assert.Equal(t, addrs[:1], invite.Addrs)
+
+	parsed, err := ParseInvite(NewInvite(id, addrs).Token())
+	require.NoError(t, err)
+	assert.Equal(t, id, parsed.ID)
+	assert.Len(t, parsed.Addrs, 5)
+}
+
+func TestParseInvite(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	id, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	token := NewInvite(id, []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/192.168.1.20/udp/4001/quic-v1")}).Token()
+
+	// Tokens survive being retyped in lower case
+	parsed, err := ParseInvite("  " + strings.ToLower(token) + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, id, parsed.ID)
+
+	typo := []byte(token)
+	typo[10] ^= 'A' ^ 'B'
+	for _, bad := range []string{
+		"",
+		"/ip4/192.168.1.20/tcp/4001",
+		token[:len(token)-3],
+		string(typo),
+		NewInvite(id, nil).Token(),
+	} {
+		_, err := ParseInvite(bad)
+		assert.ErrorIs(t, err, ErrInvalidInvite, bad)
+	}
+
+	// A token fits a small QR code
+	q, err := EncodeQR(token)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, q.version, 5)
+}
+
+func TestInviteJoin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	host, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer host.Stop(ctx)
+	guest, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer guest.Stop(ctx)
+
+	invite, err := ParseInvite(host.Invite().Token())
+	require.NoError(t, err)
+	require.NoError(t, guest.Host().Connect(ctx, invite.AddrInfo()))
+	assert.NotEmpty(t, guest.Host().Network().ConnsToPeer(host.Host().ID()))
+}
@@ -0,0 +1,450 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// KVProtocol replicates the entries of shared key/value spaces
+	KVProtocol = "/libp2p-learn/kv/1.0.0"
+
+	// kvTimeout bounds one delta or sync exchange with a peer
+	kvTimeout = 10 * time.Second
+
+	// maxKVMessageSize bounds the size of one message, and so of a space's full state
+	maxKVMessageSize = 4 << 20
+
+	// kvUpdateBufferSize is how many updates a subscriber may fall behind before updates are dropped
+	kvUpdateBufferSize = 64
+)
+
+// kv message types
+const (
+	kvMessageDelta = "delta" // entries written or learned by the sender, no reply
+	kvMessageSync  = "sync"  // the sender's full state, answered with the receiver's
+)
+
+// KVEntry is the replicated state of one key. Entries are last-writer-wins
+// registers: the higher Lamport clock wins and the writer's peer ID breaks
+// ties, so every node picks the same winner. Deletes are kept as tombstones
+// so they replicate like writes.
+type KVEntry struct {
+	Value   []byte  `json:"value,omitempty"`
+	Clock   uint64  `json:"clock"`
+	Writer  peer.ID `json:"writer"`
+	Deleted bool    `json:"deleted,omitempty"`
+}
+
+// newerThan reports whether e wins over o
+func (e KVEntry) newerThan(o KVEntry) bool {
+	if e.Clock != o.Clock {
+		return e.Clock > o.Clock
+	}
+	return e.Writer > o.Writer
+}
+
+// KVUpdate describes a change to a key, delivered to subscribers
+type KVUpdate struct {
+	Key     string
+	Value   []byte
+	Deleted bool
+	Writer  peer.ID // peer that made the change
+}
+
+// kvMessage is the wire format of the kv protocol, sent as one JSON line
+type kvMessage struct {
+	Type    string             `json:"type"`
+	Space   string             `json:"space"`
+	Entries map[string]KVEntry `json:"entries"`
+}
+
+// KVStore holds the key/value spaces this node has joined and replicates them
+// with connected peers. Writes are pushed to every connected peer, which pass
+// on what they didn't know yet; a periodic full-state sync with each peer
+// repairs anything a push missed.
+type KVStore struct {
+	host host.Host
+
+	mu     sync.Mutex
+	spaces map[string]*KVSpace
+}
+
+// NewKVStore creates the store and registers the kv protocol handler
+func NewKVStore(h host.Host) *KVStore {
+	k := &KVStore{
+		host:   h,
+		spaces: make(map[string]*KVSpace),
+	}
+	h.SetStreamHandler(protocol.ID(KVProtocol), RecoveryMiddleware(protocol.ID(KVProtocol), k.handleKV))
+	logrus.WithField("protocol", KVProtocol).Info("Registered key/value protocol")
+	return k
+}
+
+// Join returns the named space, creating it if this node hasn't joined it yet
+func (k *KVStore) Join(name string) (*KVSpace, error) {
+	if name == "" {
+		return nil, fmt.Errorf("space name must not be empty")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if s, ok := k.spaces[name]; ok {
+		return s, nil
+	}
+	s := &KVSpace{
+		name:    name,
+		store:   k,
+		entries: make(map[string]KVEntry),
+		subs:    make(map[chan KVUpdate]struct{}),
+	}
+	k.spaces[name] = s
+	logrus.WithField("space", name).Info("Joined key/value space")
+	return s, nil
+}
+
+// Close unregisters the kv protocol and closes every subscription
+func (k *KVStore) Close() {
+	k.host.RemoveStreamHandler(protocol.ID(KVProtocol))
+	for _, s := range k.joined() {
+		s.close()
+	}
+}
+
+// Run syncs every joined space with every connected peer each interval until ctx is done
+func (k *KVStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, s := range k.joined() {
+				for _, p := range k.host.Network().Peers() {
+					if err := s.Sync(ctx, p); err != nil {
+						logrus.WithError(err).WithFields(logrus.Fields{
+							"space": s.name,
+							"peer":  p,
+						}).Debug("Key/value sync failed")
+					}
+				}
+			}
+		}
+	}
+}
+
+// joined returns the joined spaces
+func (k *KVStore) joined() []*KVSpace {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	spaces := make([]*KVSpace, 0, len(k.spaces))
+	for _, s := range k.spaces {
+		spaces = append(spaces, s)
+	}
+	return spaces
+}
+
+// handleKV merges the entries a peer sent and, for a sync, replies with our state
+func (k *KVStore) handleKV(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(kvTimeout))
+
+	reader := bufio.NewReaderSize(s, maxKVMessageSize)
+	msg, err := readKVMessage(reader)
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read key/value message")
+		return
+	}
+
+	k.mu.Lock()
+	space, ok := k.spaces[msg.Space]
+	k.mu.Unlock()
+	if !ok {
+		// Tell the sender we don't replicate this space
+		s.Reset()
+		return
+	}
+
+	changed := space.merge(msg.Entries)
+	if msg.Type == kvMessageSync {
+		reply := kvMessage{Type: kvMessageSync, Space: space.name, Entries: space.state()}
+		if err := writeKVMessage(s, reply); err != nil {
+			logrus.WithError(err).WithField("peer", remote).Debug("Failed to send key/value state")
+		}
+	}
+
+	// Pass on what we learned so it reaches peers the sender isn't connected to
+	if len(changed) > 0 {
+		go space.broadcast(context.Background(), changed, remote)
+	}
+}
+
+// send delivers a message to a connected peer and, for a sync, returns its reply
+func (k *KVStore) send(ctx context.Context, p peer.ID, msg kvMessage) (*kvMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, kvTimeout)
+	defer cancel()
+
+	s, err := k.host.NewStream(network.WithNoDial(ctx, "kv"), p, protocol.ID(KVProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	if err := writeKVMessage(s, msg); err != nil {
+		return nil, err
+	}
+	s.CloseWrite()
+
+	if msg.Type != kvMessageSync {
+		return nil, nil
+	}
+	reply, err := readKVMessage(bufio.NewReaderSize(s, maxKVMessageSize))
+	if err != nil {
+		return nil, fmt.Errorf("peer does not replicate space %q: %w", msg.Space, err)
+	}
+	return reply, nil
+}
+
+// readKVMessage reads one JSON line
+func readKVMessage(r *bufio.Reader) (*kvMessage, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	var msg kvMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, fmt.Errorf("invalid key/value message: %w", err)
+	}
+	return &msg, nil
+}
+
+// writeKVMessage sends a message as one JSON line
+func writeKVMessage(s network.Stream, msg kvMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode key/value message: %w", err)
+	}
+	if len(data) >= maxKVMessageSize {
+		return fmt.Errorf("key/value message too large")
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send key/value message: %w", err)
+	}
+	return nil
+}
+
+// KVSpace is a named key/value map that converges on every node that joined it
+type KVSpace struct {
+	name  string
+	store *KVStore
+
+	mu      sync.Mutex
+	clock   uint64
+	entries map[string]KVEntry
+	subs    map[chan KVUpdate]struct{}
+	closed  bool
+}
+
+// Name returns the name of the space
+func (s *KVSpace) Name() string {
+	return s.name
+}
+
+// Get returns the current value of a key
+func (s *KVSpace) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	return append([]byte(nil), e.Value...), true
+}
+
+// Keys returns the keys that currently have a value, sorted
+func (s *KVSpace) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.entries))
+	for key, e := range s.entries {
+		if !e.Deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Put sets a key and pushes the write to connected peers. Peers that miss the
+// push catch up on the next sync, so delivery failures are not returned.
+func (s *KVSpace) Put(ctx context.Context, key string, value []byte) error {
+	return s.write(ctx, key, KVEntry{Value: append([]byte(nil), value...)})
+}
+
+// Delete removes a key and pushes the deletion to connected peers
+func (s *KVSpace) Delete(ctx context.Context, key string) error {
+	return s.write(ctx, key, KVEntry{Deleted: true})
+}
+
+// Subscribe returns a channel of changes to the space, whether made locally or
+// by other peers. Slow subscribers miss updates rather than stalling
+// replication. cancel closes the channel.
+func (s *KVSpace) Subscribe() (<-chan KVUpdate, func()) {
+	ch := make(chan KVUpdate, kvUpdateBufferSize)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	s.subs[ch] = struct{}{}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.subs[ch]; ok {
+				delete(s.subs, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, cancel
+}
+
+// Sync exchanges full state with a connected peer, so both end up with the
+// newest entry of every key either of them knows
+func (s *KVSpace) Sync(ctx context.Context, p peer.ID) error {
+	reply, err := s.store.send(ctx, p, kvMessage{Type: kvMessageSync, Space: s.name, Entries: s.state()})
+	if err != nil {
+		return err
+	}
+	if changed := s.merge(reply.Entries); len(changed) > 0 {
+		s.broadcast(ctx, changed, p)
+	}
+	return nil
+}
+
+// write applies a local change with the next clock value and pushes it to peers
+func (s *KVSpace) write(ctx context.Context, key string, e KVEntry) error {
+	if key == "" {
+		return fmt.Errorf("key must not be empty")
+	}
+
+	s.mu.Lock()
+	s.clock++
+	e.Clock = s.clock
+	e.Writer = s.store.host.ID()
+	s.entries[key] = e
+	s.notify(key, e)
+	s.mu.Unlock()
+
+	s.broadcast(ctx, map[string]KVEntry{key: e}, "")
+	return nil
+}
+
+// merge applies the entries that win over ours and returns them
+func (s *KVSpace) merge(entries map[string]KVEntry) map[string]KVEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := make(map[string]KVEntry)
+	for key, e := range entries {
+		if key == "" {
+			continue
+		}
+		// Keep the Lamport clock ahead of everything seen so local writes win over them
+		s.clock = max(s.clock, e.Clock)
+		if cur, ok := s.entries[key]; ok && !e.newerThan(cur) {
+			continue
+		}
+		s.entries[key] = e
+		changed[key] = e
+		s.notify(key, e)
+	}
+	return changed
+}
+
+// state returns a copy of every entry, tombstones included
+func (s *KVSpace) state() map[string]KVEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make(map[string]KVEntry, len(s.entries))
+	for key, e := range s.entries {
+		entries[key] = e
+	}
+	return entries
+}
+
+// broadcast pushes entries to every connected peer except one, in parallel
+func (s *KVSpace) broadcast(ctx context.Context, entries map[string]KVEntry, except peer.ID) {
+	msg := kvMessage{Type: kvMessageDelta, Space: s.name, Entries: entries}
+
+	var wg sync.WaitGroup
+	for _, p := range s.store.host.Network().Peers() {
+		if p == except {
+			continue
+		}
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if _, err := s.store.send(ctx, p, msg); err != nil {
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"space": s.name,
+					"peer":  p,
+				}).Debug("Failed to push key/value update")
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// notify delivers a change to subscribers without blocking; s.mu must be held
+func (s *KVSpace) notify(key string, e KVEntry) {
+	update := KVUpdate{Key: key, Deleted: e.Deleted, Writer: e.Writer}
+	if !e.Deleted {
+		update.Value = append([]byte(nil), e.Value...)
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- update:
+		default:
+			logrus.WithFields(logrus.Fields{
+				"space": s.name,
+				"key":   key,
+			}).Debug("Dropped key/value update for slow subscriber")
+		}
+	}
+}
+
+// close closes every subscription and refuses new ones
+func (s *KVSpace) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+}
@@ -0,0 +1,118 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVEntryOrdering(t *testing.T) {
+	a, b := peer.ID("a"), peer.ID("b")
+
+	assert.True(t, KVEntry{Clock: 2, Writer: a}.newerThan(KVEntry{Clock: 1, Writer: b}), "higher clock should win")
+	assert.True(t, KVEntry{Clock: 1, Writer: b}.newerThan(KVEntry{Clock: 1, Writer: a}), "writer should break ties")
+	assert.False(t, KVEntry{Clock: 1, Writer: a}.newerThan(KVEntry{Clock: 1, Writer: a}), "an entry should not win over itself")
+}
+
+func TestKVStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// a - b - c, with a and c only reachable through b
+	hosts := make([]host.Host, 3)
+	stores := make([]*KVStore, 3)
+	spaces := make([]*KVSpace, 3)
+	for i := range hosts {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer h.Close()
+
+		hosts[i] = h
+		stores[i] = NewKVStore(h)
+		defer stores[i].Close()
+
+		spaces[i], err = stores[i].Join("test")
+		require.NoError(t, err)
+	}
+	require.NoError(t, connectNodes(ctx, hosts[0], hosts[1]))
+	require.NoError(t, connectNodes(ctx, hosts[1], hosts[2]))
+
+	hasValue := func(s *KVSpace, key, want string) func() bool {
+		return func() bool {
+			v, ok := s.Get(key)
+			return ok && string(v) == want
+		}
+	}
+
+	t.Run("PutReachesIndirectPeers", func(t *testing.T) {
+		updates, stop := spaces[2].Subscribe()
+		defer stop()
+
+		require.NoError(t, spaces[0].Put(ctx, "color", []byte("blue")))
+
+		err := WaitWithCondition(ctx, hasValue(spaces[2], "color", "blue"), 5*time.Second, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		update := <-updates
+		assert.Equal(t, "color", update.Key)
+		assert.Equal(t, []byte("blue"), update.Value)
+		assert.Equal(t, hosts[0].ID(), update.Writer)
+	})
+
+	t.Run("LaterWriteWins", func(t *testing.T) {
+		require.NoError(t, spaces[2].Put(ctx, "color", []byte("green")))
+
+		for _, s := range spaces {
+			err := WaitWithCondition(ctx, hasValue(s, "color", "green"), 5*time.Second, 50*time.Millisecond)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("DeletePropagates", func(t *testing.T) {
+		require.NoError(t, spaces[1].Delete(ctx, "color"))
+
+		err := WaitWithCondition(ctx, func() bool {
+			_, ok0 := spaces[0].Get("color")
+			_, ok2 := spaces[2].Get("color")
+			return !ok0 && !ok2
+		}, 5*time.Second, 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Empty(t, spaces[0].Keys())
+	})
+
+	t.Run("SyncConvergesConcurrentWrites", func(t *testing.T) {
+		// Only a and c replicate this space, and they aren't connected
+		left, err := stores[0].Join("offline")
+		require.NoError(t, err)
+		right, err := stores[2].Join("offline")
+		require.NoError(t, err)
+
+		require.NoError(t, left.Put(ctx, "shared", []byte("left")))
+		require.NoError(t, right.Put(ctx, "shared", []byte("right")))
+		require.NoError(t, left.Put(ctx, "left-only", []byte("1")))
+		require.NoError(t, right.Put(ctx, "right-only", []byte("2")))
+
+		assert.Error(t, left.Sync(ctx, hosts[1].ID()), "peers that haven't joined the space should refuse to sync")
+
+		require.NoError(t, connectNodes(ctx, hosts[0], hosts[2]))
+		require.NoError(t, left.Sync(ctx, hosts[2].ID()))
+
+		assert.Equal(t, []string{"left-only", "right-only", "shared"}, left.Keys())
+		assert.Equal(t, left.Keys(), right.Keys())
+
+		lv, _ := left.Get("shared")
+		rv, _ := right.Get("shared")
+		assert.Equal(t, lv, rv, "both sides should pick the same winner")
+	})
+
+	t.Run("RejectsEmptyKey", func(t *testing.T) {
+		assert.Error(t, spaces[0].Put(ctx, "", []byte("x")))
+		_, err := stores[0].Join("")
+		assert.Error(t, err)
+	})
+}
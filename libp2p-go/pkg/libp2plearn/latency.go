@@ -0,0 +1,156 @@
+package libp2plearn
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// latencyWindow is how many recent round trips are kept per peer
+	latencyWindow = 256
+
+	// latencyMaxAge is how long a peer's round trips are kept after its last
+	// one, so peers that went away are forgotten
+	latencyMaxAge = time.Hour
+
+	// echoLatencyMaxSize is the largest echo whose round trip is recorded
+	echoLatencyMaxSize = 1024
+)
+
+// LatencyStats summarizes the recent round trips to a peer. Jitter is the
+// mean difference between consecutive round trips, as in RFC 3550.
+type LatencyStats struct {
+	Peer    string   `json:"peer"`
+	Samples int      `json:"samples"`
+	Min     Duration `json:"min"`
+	P50     Duration `json:"p50"`
+	P95     Duration `json:"p95"`
+	P99     Duration `json:"p99"`
+	Max     Duration `json:"max"`
+	Jitter  Duration `json:"jitter"`
+	Last    Duration `json:"last"`
+}
+
+// peerLatency is a ring of a peer's recent round trips, oldest first once
+// full
+type peerLatency struct {
+	samples []time.Duration
+	next    int
+	updated time.Time
+}
+
+// LatencyTracker keeps the recent round trips to each peer, measured by the
+// periodic standard ping and by the ping and echo protocols, so operators
+// can see percentiles and jitter rather than only the smoothed average the
+// peerstore keeps
+type LatencyTracker struct {
+	mu     sync.Mutex
+	peers  map[peer.ID]*peerLatency
+	pruned time.Time
+}
+
+// NewLatencyTracker creates an empty tracker
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{peers: make(map[peer.ID]*peerLatency)}
+}
+
+// Record adds a round trip to a peer. A nil tracker records nothing.
+func (t *LatencyTracker) Record(p peer.ID, rtt time.Duration) {
+	if t == nil || rtt <= 0 {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pl, ok := t.peers[p]
+	if !ok {
+		pl = &peerLatency{samples: make([]time.Duration, 0, latencyWindow)}
+		t.peers[p] = pl
+	}
+	if len(pl.samples) < latencyWindow {
+		pl.samples = append(pl.samples, rtt)
+	} else {
+		pl.samples[pl.next] = rtt
+		pl.next = (pl.next + 1) % latencyWindow
+	}
+	pl.updated = now
+
+	if now.Sub(t.pruned) > time.Minute {
+		t.pruned = now
+		for id, other := range t.peers {
+			if now.Sub(other.updated) > latencyMaxAge {
+				delete(t.peers, id)
+			}
+		}
+	}
+}
+
+// Stats summarizes the round trips to a peer, reporting false if there are
+// none
+func (t *LatencyTracker) Stats(p peer.ID) (LatencyStats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pl, ok := t.peers[p]
+	if !ok {
+		return LatencyStats{}, false
+	}
+	return pl.stats(p), true
+}
+
+// All summarizes the round trips to every peer, by peer ID
+func (t *LatencyTracker) All() []LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	all := make([]LatencyStats, 0, len(t.peers))
+	for p, pl := range t.peers {
+		all = append(all, pl.stats(p))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Peer < all[j].Peer })
+	return all
+}
+
+// ordered returns the samples oldest first
+func (pl *peerLatency) ordered() []time.Duration {
+	return append(append([]time.Duration(nil), pl.samples[pl.next:]...), pl.samples[:pl.next]...)
+}
+
+// stats summarizes the samples
+func (pl *peerLatency) stats(p peer.ID) LatencyStats {
+	ordered := pl.ordered()
+	stats := LatencyStats{
+		Peer:    p.String(),
+		Samples: len(ordered),
+		Last:    Duration(ordered[len(ordered)-1]),
+	}
+	var jitter time.Duration
+	for i := 1; i < len(ordered); i++ {
+		diff := ordered[i] - ordered[i-1]
+		if diff < 0 {
+			diff = -diff
+		}
+		jitter += diff
+	}
+	if len(ordered) > 1 {
+		stats.Jitter = Duration(jitter / time.Duration(len(ordered)-1))
+	}
+
+	sorted := ordered
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	stats.Min = Duration(sorted[0])
+	stats.Max = Duration(sorted[len(sorted)-1])
+	stats.P50 = Duration(percentile(sorted, 0.50))
+	stats.P95 = Duration(percentile(sorted, 0.95))
+	stats.P99 = Duration(percentile(sorted, 0.99))
+	return stats
+}
+
+// percentile returns the nearest-rank percentile q of sorted samples
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
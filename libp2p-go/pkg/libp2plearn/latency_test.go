@@ -0,0 +1,77 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyTrackerStats(t *testing.T) {
+	tracker := NewLatencyTracker()
+	p := peer.ID("peer")
+
+	_, ok := tracker.Stats(p)
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		tracker.Record(p, time.Duration(i)*time.Millisecond)
+	}
+	stats, ok := tracker.Stats(p)
+	require.True(t, ok)
+	assert.Equal(t, 100, stats.Samples)
+	assert.Equal(t, Duration(time.Millisecond), stats.Min)
+	assert.Equal(t, Duration(50*time.Millisecond), stats.P50)
+	assert.Equal(t, Duration(95*time.Millisecond), stats.P95)
+	assert.Equal(t, Duration(99*time.Millisecond), stats.P99)
+	assert.Equal(t, Duration(100*time.Millisecond), stats.Max)
+	assert.Equal(t, Duration(100*time.Millisecond), stats.Last)
+	assert.Equal(t, Duration(time.Millisecond), stats.Jitter)
+
+	// The window keeps only the latest round trips
+	for i := 0; i < latencyWindow; i++ {
+		tracker.Record(p, 5*time.Millisecond)
+	}
+	stats, _ = tracker.Stats(p)
+	assert.Equal(t, latencyWindow, stats.Samples)
+	assert.Equal(t, Duration(5*time.Millisecond), stats.Max)
+	assert.Zero(t, stats.Jitter)
+
+	tracker.Record(peer.ID("other"), time.Millisecond)
+	var peers []string
+	for _, stats := range tracker.All() {
+		peers = append(peers, stats.Peer)
+	}
+	assert.ElementsMatch(t, []string{p.String(), peer.ID("other").String()}, peers)
+}
+
+func TestLatencyTrackedByPings(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node1, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer node1.Stop(ctx)
+
+	node2, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer node2.Stop(ctx)
+
+	require.NoError(t, connectNodes(ctx, node1.Host(), node2.Host()))
+	target := node2.Host().ID()
+
+	_, err = node1.Ping(ctx, target)
+	require.NoError(t, err)
+	_, err = node1.Protocols().SendPing(ctx, target, "hello")
+	require.NoError(t, err)
+	_, err = node1.Protocols().SendEcho(ctx, target, "hello")
+	require.NoError(t, err)
+
+	stats, ok := node1.Latency().Stats(target)
+	require.True(t, ok)
+	assert.Equal(t, 3, stats.Samples)
+	assert.Greater(t, stats.P50, Duration(0))
+}
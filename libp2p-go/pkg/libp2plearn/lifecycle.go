@@ -0,0 +1,1308 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+const (
+	// historySaveInterval is how often the peer history is written to disk while running
+	historySaveInterval = 30 * time.Second
+
+	// goodbyeShutdownTimeout bounds how long Stop spends saying goodbye to peers
+	goodbyeShutdownTimeout = 3 * time.Second
+)
+
+// Node is a libp2p node and the services configured on top of it
+type Node struct {
+	cfg          atomic.Pointer[Config]
+	host         host.Host
+	datastore    Datastore
+	dht          *discovery.DHT
+	appDHT       *discovery.DHT
+	blocklist    *Blocklist
+	protocols    *ProtocolHandler
+	goodbye      *Goodbye
+	admin        *Admin
+	config       *ConfigPush
+	collector    *LogCollector
+	shell        *Shell
+	transfer     *Transfer
+	blobs        *BlobStore
+	timeSync     *TimeSync
+	kv           *KVStore
+	raft         *Raft
+	activity     *Activity
+	diversity    *Diversity
+	eclipse      *EclipseMonitor
+	peerstoreGC  *PeerstoreGC
+	observed     *ObservedAddrs
+	autonat      *AutoNATService
+	plugins      []*Plugin
+	wasm         []*WASMHandler
+	capabilities *Capabilities
+	mailbox      *Mailbox
+	outbox       *Outbox
+	rooms        *RoomBook
+	roomSync     *RoomSync
+	secureChat   *SecureChat
+	auth         *Auth
+	audit        *AuditLog
+	reputation   *Reputation
+	health       *Health
+	latency      *LatencyTracker
+	timings      *ConnTimings
+	transports   *TransportPerf
+	streams      *StreamTracker
+	maintenance  *Maintenance
+	protoCache   *ProtocolCache
+	records      *AppRecords
+	mdns         *discovery.MDNS
+	pairing      *Pairing
+	reflect      *Reflect
+
+	throttle    *Throttle
+	quotas      *Quotas
+	streamLimit *StreamLimit
+	qos         *QoS
+	gateway     *Gateway
+	metrics     *Metrics
+	adminHTTP   *AdminHTTP
+	httpService *HTTPService
+	failover    *Failover
+	multipath   *Multipath
+	relays      *RelayReservations
+	peerHistory *PeerHistory
+
+	hooks    hooks
+	events   eventHub
+	services services
+
+	// lifecycleMu serializes Start and Stop. mu guards the state shared
+	// with Reload and is never held while hooks or background tasks run.
+	lifecycleMu sync.Mutex
+	mu          sync.Mutex
+	started     bool
+	stopped     bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	group       *errgroup.Group
+}
+
+// New creates a node from DefaultConfig adjusted by the options. The host is
+// listening and the protocols are registered, but nothing runs until Start.
+func New(opts ...Option) (*Node, error) {
+	o := &nodeOptions{cfg: DefaultConfig()}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+	}
+
+	cfg := o.cfg
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	blocklist, err := NewBlocklist(cfg.BlockedPeers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blocklist: %w", err)
+	}
+
+	// Keep the peerstore, DHT records and blobs in the configured datastore
+	store, err := OpenDatastore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open datastore: %w", err)
+	}
+	// Advertise public addresses peers observe us at only once enough of them agree
+	observed := NewObservedAddrs(cfg.ObservedAddrMinObservers, cfg.ObservedAddrMinConfidence)
+	// Neither dial nor advertise the addresses the filters drop
+	addrFilter, err := NewAddrFilter(cfg.AddressFilters)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	// Time each phase of establishing connections
+	timings := NewConnTimings()
+	// Measure how each transport performs, to rank dial addresses by
+	transports := NewTransportPerf()
+	if o.transportPolicy != nil {
+		transports.SetPolicy(o.transportPolicy)
+	}
+	// Refuse inbound connections and streams while in maintenance
+	maintenance := NewMaintenance(time.Duration(cfg.MaintenanceDrain))
+	gater := &dialGater{Blocklist: blocklist, filter: addrFilter, timings: timings, maintenance: maintenance}
+	// Back off failing addresses and budget dials, so discovery can't hammer unreachable peers
+	if cfg.DialLimited() {
+		gater.policy = NewDialPolicy(cfg.DialLimits())
+	}
+	hostOpts := []libp2p.Option{
+		libp2p.ConnectionGater(gater),
+		libp2p.AddrsFactory(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return addrFilter.Filter(observed.Filter(addrs))
+		}),
+	}
+	hostOpts = append(hostOpts, timings.Options()...)
+	// Count traffic against the quotas, loading what was used before a restart
+	var quotas *Quotas
+	if cfg.QuotaLimited() {
+		quotas, err = NewQuotas(cfg.QuotaFile, cfg.TrafficQuota, cfg.RelayQuota, cfg.QuotaAction, cfg.QuotaThrottleRate)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		hostOpts = append(hostOpts, quotas.Options(relayServiceOptions(cfg)...)...)
+	}
+	psOption, err := peerstoreOption(context.Background(), cfg, store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if psOption != nil {
+		hostOpts = append(hostOpts, psOption)
+	}
+
+	h, err := newHost(cfg, transports, append(hostOpts, o.libp2pOpts...)...)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
+	if err := timings.Attach(h); err != nil {
+		h.Close()
+		store.Close()
+		return nil, err
+	}
+	if err := transports.Attach(h); err != nil {
+		timings.Close()
+		h.Close()
+		store.Close()
+		return nil, err
+	}
+
+	h = &dialTimeoutHost{Host: h, timeout: time.Duration(cfg.ConnectTimeout)}
+
+	// Record every inbound stream, so the host is wrapped before anything registers a handler
+	var audit *AuditLog
+	if cfg.AuditLog != "" {
+		audit, err = OpenAuditLog(cfg.AuditLog, cfg.AuditChain, cfg.AuditMaxSize)
+		if err != nil {
+			transports.Close()
+			timings.Close()
+			h.Close()
+			store.Close()
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		h = audit.WrapHost(h)
+	}
+	h = gater.policy.WrapHost(h)
+
+	n := &Node{
+		host:        h,
+		datastore:   store,
+		blocklist:   blocklist,
+		audit:       audit,
+		protocols:   NewProtocolHandler(h),
+		observed:    observed,
+		latency:     NewLatencyTracker(),
+		timings:     timings,
+		transports:  transports,
+		streams:     NewStreamTracker(h),
+		maintenance: maintenance,
+		protoCache:  NewProtocolCache(),
+	}
+	n.cfg.Store(cfg)
+	n.protocols.SetLatencyTracker(n.latency)
+	n.protocols.SetProtocolCache(n.protoCache)
+	n.protocols.Use(n.maintenance.Middleware)
+	n.protocols.Use(n.streams.Middleware)
+	if err := observed.Track(h); err != nil {
+		n.close()
+		return nil, err
+	}
+	if err := n.protoCache.Track(h); err != nil {
+		n.close()
+		return nil, err
+	}
+
+	// Help other peers find out whether they are reachable, within limits
+	if cfg.EnableAutoNATService {
+		n.autonat, err = NewAutoNATService(h, cfg.AutoNATPolicy())
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+	}
+
+	if cfg.StreamLimited() {
+		n.streamLimit = NewStreamLimit(h, cfg.MaxStreamsPerPeer, cfg.ProtocolStreamLimits)
+		n.protocols.Use(n.streamLimit.Middleware)
+	}
+	if cfg.BandwidthLimited() {
+		n.throttle = NewThrottle(h, cfg.PeerBandwidth, cfg.ProtocolBandwidth)
+		n.protocols.Use(n.throttle.Middleware)
+	}
+	if quotas != nil {
+		n.quotas = quotas
+		n.quotas.Start()
+		n.protocols.Use(n.quotas.Middleware)
+	}
+	if cfg.EnableQoS {
+		n.qos, err = NewQoS(cfg.QoSClasses, time.Duration(cfg.QoSActiveWindow), cfg.QoSYieldRate)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up QoS: %w", err)
+		}
+		n.protocols.Use(n.qos.Middleware)
+	}
+	if len(cfg.AuthProtocols) > 0 {
+		n.auth, err = NewAuth(h, cfg.AuthProtocols, cfg.AuthIssuers)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up token authorization: %w", err)
+		}
+		for _, token := range cfg.AuthTokens {
+			if err := n.auth.AddToken(token); err != nil {
+				n.close()
+				return nil, fmt.Errorf("failed to add auth token: %w", err)
+			}
+		}
+		n.protocols.Use(n.auth.Middleware)
+	}
+	n.protocols.SetCompression(cfg.Compression)
+	n.protocols.SetEchoMaxSize(cfg.EchoMaxSize)
+	if !cfg.NoAppProtocols {
+		n.protocols.SetupProtocols()
+	}
+	n.protocols.OnMessage(func(proto protocol.ID, from peer.ID, msg string) {
+		n.events.publish(Event{Type: EventProtocolMessage, Peer: from, Protocol: proto, Message: msg})
+	})
+
+	// Keep peers we recently worked with when connections are trimmed
+	if cfg.ActivityTagged() {
+		n.activity, err = NewActivity(h, cfg.ActivityWeights, time.Duration(cfg.ActivityDecay))
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up activity tags: %w", err)
+		}
+		n.protocols.OnMessage(func(proto protocol.ID, from peer.ID, msg string) {
+			n.activity.Bump(from, ActivityChat)
+		})
+	}
+	// Keep peers spread over transports and networks when trimming and dialing
+	if cfg.DiversityLimited() {
+		n.diversity = NewDiversity(h, cfg.DiversityPolicy())
+	}
+	// Warn of routing tables that look eclipsed
+	if cfg.EnableEclipseDetection {
+		n.eclipse, err = NewEclipseMonitor(h, cfg.EclipseThresholds())
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+	}
+
+	// Drop peers the peerstore has kept too long or has too many of
+	if cfg.PeerstoreGCInterval > 0 {
+		n.peerstoreGC = NewPeerstoreGC(h, time.Duration(cfg.PeerstoreMaxAge), cfg.PeerstoreMaxPeers)
+	}
+
+	// Encrypt chat sessions end to end
+	if cfg.EnableSecureChat {
+		n.secureChat, err = NewSecureChat(h, cfg.SecureChatFile)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up secure chat: %w", err)
+		}
+		n.protocols.SetSecureChat(n.secureChat)
+	}
+
+	// Tell peers why we disconnect from them
+	n.goodbye, err = NewGoodbye(h)
+	if err != nil {
+		n.close()
+		return nil, fmt.Errorf("failed to set up goodbye protocol: %w", err)
+	}
+	n.maintenance.Attach(h, n.goodbye)
+
+	// Let peers on the local network pair with a PIN
+	n.pairing, err = NewPairing(h)
+	if err != nil {
+		n.close()
+		return nil, fmt.Errorf("failed to set up pairing protocol: %w", err)
+	}
+
+	// Escalate from penalties to gating to bans for misbehaving peers
+	if cfg.EnableReputation {
+		n.reputation, err = NewReputation(h, blocklist, n.goodbye, cfg.ReputationFile, cfg.ReputationPolicy())
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up peer reputation: %w", err)
+		}
+	}
+
+	// Let admin peers manage and reconfigure the node remotely
+	if len(cfg.AdminPeers) > 0 {
+		n.admin, err = NewAdmin(h, n.goodbye, cfg.AdminPeers)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up admin protocol: %w", err)
+		}
+		n.admin.SetAuditLog(n.audit)
+		n.admin.SetProtocols(n.protocols)
+		n.admin.SetObservedAddrs(n.observed)
+		n.admin.SetLatencyTracker(n.latency)
+		n.admin.SetTransportPerf(n.transports)
+		n.admin.SetStreamTracker(n.streams)
+		n.admin.SetMaintenance(n.maintenance)
+		if n.quotas != nil {
+			n.admin.SetQuotas(n.quotas)
+		}
+		n.maintenance.SetExempt(n.admin.IsAdmin)
+		n.config = NewConfigPush(h, n.admin.IsAdmin, n.applyConfigPatch)
+		n.config.SetAuditLog(n.audit)
+	}
+
+	// Let authorized peers run commands, only when explicitly enabled
+	if cfg.EnableShell {
+		n.shell, err = NewShell(h, cfg.ShellPeers, cfg.ShellCommand)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up shell protocol: %w", err)
+		}
+		n.shell.SetAuditLog(n.audit)
+	}
+
+	// Receive directories sent by authorized peers
+	if len(cfg.TransferPeers) > 0 {
+		n.transfer, err = NewTransfer(h, cfg.TransferDir, cfg.TransferPeers)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up transfer protocol: %w", err)
+		}
+		if cfg.TransferResumeDir != "" {
+			partial, err := NewPartialStore(cfg.TransferResumeDir, time.Duration(cfg.TransferResumeMaxAge))
+			if err != nil {
+				n.close()
+				return nil, fmt.Errorf("failed to set up transfer resumption: %w", err)
+			}
+			n.transfer.SetPartialStore(partial)
+		}
+	}
+
+	// Store content in chunks that peers can fetch and verify
+	if cfg.EnableBlobs || cfg.BlobDir != "" {
+		blobStore := namespaced(n.datastore, datastoreBlobs)
+		if cfg.BlobDir != "" {
+			blobStore, err = NewFSDatastore(cfg.BlobDir)
+			if err != nil {
+				n.close()
+				return nil, fmt.Errorf("failed to set up blob store: %w", err)
+			}
+		}
+		n.blobs, err = NewBlobStore(h, blobStore, cfg.BlobChunker)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up blob store: %w", err)
+		}
+		n.blobs.SetQuota(cfg.BlobQuota)
+		if n.admin != nil {
+			n.admin.SetBlobStore(n.blobs, n.PinBlob)
+		}
+	}
+
+	// Gather logs streamed by other nodes
+	if len(cfg.CollectLogsFrom) > 0 {
+		n.collector, err = NewLogCollector(h, cfg.CollectLogsFrom, nil)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up log collector: %w", err)
+		}
+	}
+
+	// Answer time queries and measure the clocks of selected peers
+	n.timeSync = NewTimeSync(h)
+	for _, id := range cfg.TimeSyncPeers {
+		peerID, _ := peer.Decode(id) // validated with the config
+		n.timeSync.Watch(peerID)
+	}
+
+	// Replicate shared key/value spaces with peers
+	n.kv = NewKVStore(h)
+	for _, name := range cfg.KVSpaces {
+		if _, err := n.kv.Join(name); err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to join key/value space: %w", err)
+		}
+	}
+
+	// Take part in the replicated log shared with the raft peers
+	if len(cfg.RaftPeers) > 0 {
+		members := make([]peer.ID, 0, len(cfg.RaftPeers))
+		for _, id := range cfg.RaftPeers {
+			peerID, _ := peer.Decode(id) // validated with the config
+			members = append(members, peerID)
+		}
+		n.raft = NewRaft(h, members, time.Duration(cfg.RaftElectionTimeout), time.Duration(cfg.RaftHeartbeatInterval))
+	}
+
+	// Describe the protocols we serve to peers that ask
+	n.reflect = NewReflect(h)
+
+	// Relay the protocols of external plugins to them
+	for _, path := range cfg.Plugins {
+		manifest, err := LoadPluginManifest(path)
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+		plugin := NewPlugin(h, manifest)
+		n.plugins = append(n.plugins, plugin)
+		for _, proto := range plugin.Protocols() {
+			if err := n.protocols.Register(proto, plugin.HandleStream); err != nil {
+				n.close()
+				return nil, fmt.Errorf("failed to register plugin %s: %w", plugin.Name(), err)
+			}
+			n.reflect.Document(ProtocolDoc{ID: string(proto), Summary: fmt.Sprintf("Served by the %s plugin", plugin.Name())})
+		}
+	}
+
+	// Serve protocols with sandboxed WASM modules
+	for _, w := range cfg.WASMHandlers {
+		handler, err := NewWASMHandler(w)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to load wasm handler for %s: %w", w.Protocol, err)
+		}
+		n.wasm = append(n.wasm, handler)
+		if err := n.protocols.Register(protocol.ID(w.Protocol), handler.Handle); err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to register wasm handler: %w", err)
+		}
+		n.reflect.Document(ProtocolDoc{
+			ID:       w.Protocol,
+			Summary:  "Served by a WASM module: the request is read until the client closes its side, then answered",
+			Encoding: EncodingRaw,
+			Limits:   protocolLimits(handler.maxRequestSize, handler.timeout),
+		})
+	}
+
+	// Filter, rewrite and answer messages and greet peers with user scripts
+	for _, sc := range cfg.Scripts {
+		script, err := LoadScript(sc)
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+		n.protocols.AddFilter(script.Filter)
+		n.OnPeerConnected(func(p peer.ID) {
+			go script.greet(n.protocols, p)
+		})
+	}
+
+	// Advertise our application services and learn the ones peers provide
+	n.capabilities = NewCapabilities(h)
+	n.capabilities.SetServices(cfg.Services)
+
+	// Keep chat messages for offline peers, and say so to the others
+	if cfg.EnableMailbox {
+		n.mailbox = NewMailbox(h, cfg.MailboxLimits())
+		if !slices.Contains(cfg.Services, MailboxService) {
+			n.capabilities.SetServices(append(slices.Clone(cfg.Services), MailboxService))
+		}
+	}
+
+	// Queue messages for peers that can't be reached
+	if cfg.EnableOutbox {
+		n.outbox, err = NewOutbox(h, n.protocols, cfg.OutboxFile, time.Duration(cfg.OutboxTTL), cfg.MailboxPeerIDs())
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up outbox: %w", err)
+		}
+	}
+
+	// Keep the member lists of private chat rooms in sync with the members
+	if cfg.EnableRooms {
+		n.rooms, err = LoadRoomBook(cfg.RoomsFile, h.Peerstore().PrivKey(h.ID()))
+		if err != nil {
+			n.close()
+			return nil, err
+		}
+		n.roomSync, err = NewRoomSync(h, n.rooms)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("failed to set up room sync: %w", err)
+		}
+	}
+
+	// Report our health to monitoring peers
+	n.health, err = NewHealth(h)
+	if err != nil {
+		n.close()
+		return nil, fmt.Errorf("failed to set up health protocol: %w", err)
+	}
+	n.addHealthChecks()
+
+	return n, nil
+}
+
+// Host returns the underlying libp2p host
+func (n *Node) Host() host.Host {
+	return n.host
+}
+
+// NATStatus reports reachability, the advertised addresses, and how
+// confident peers' reports make us of each observed public address
+func (n *Node) NATStatus() NATStatus {
+	return n.observed.Status()
+}
+
+// Latency returns the recent round trips to each peer, from the periodic
+// ping and from ping and echo exchanges
+func (n *Node) Latency() *LatencyTracker {
+	return n.latency
+}
+
+// TransportPerf returns how each transport and address performed, which
+// the measured dial strategy ranks addresses by
+func (n *Node) TransportPerf() *TransportPerf {
+	return n.transports
+}
+
+// Datastore returns the datastore behind the peerstore, DHT and blob store
+func (n *Node) Datastore() Datastore {
+	return n.datastore
+}
+
+// Metrics returns the metrics exporters, which are nil until the node is
+// started with metrics_exporters set
+func (n *Node) Metrics() *Metrics {
+	return n.metrics
+}
+
+// DHT returns the Kademlia DHT, which is nil until the node is started
+func (n *Node) DHT() *discovery.DHT {
+	return n.dht
+}
+
+// AppDHT returns the DHT holding app records, which is nil until the node
+// is started
+func (n *Node) AppDHT() *discovery.DHT {
+	return n.appDHT
+}
+
+// Protocols returns the handler of the custom protocols
+func (n *Node) Protocols() *ProtocolHandler {
+	return n.protocols
+}
+
+// Auth returns the token check for private protocols, which is nil unless
+// auth_protocols is set
+func (n *Node) Auth() *Auth {
+	return n.auth
+}
+
+// Reputation returns the peer reputation, which is nil unless enabled
+func (n *Node) Reputation() *Reputation {
+	return n.reputation
+}
+
+// AuditLog returns the audit log, which is nil unless audit_log is set
+func (n *Node) AuditLog() *AuditLog {
+	return n.audit
+}
+
+// Transfer returns the directory transfer receiver, which is nil unless
+// transfer_peers is set
+func (n *Node) Transfer() *Transfer {
+	return n.transfer
+}
+
+// SecureChat returns the end-to-end chat encryption, which is nil unless enabled
+func (n *Node) SecureChat() *SecureChat {
+	return n.secureChat
+}
+
+// Blocklist returns the connection gater of blocked peers
+func (n *Node) Blocklist() *Blocklist {
+	return n.blocklist
+}
+
+// Goodbye returns the goodbye service used to disconnect from peers
+func (n *Node) Goodbye() *Goodbye {
+	return n.goodbye
+}
+
+// Config returns the current configuration, which Reload may replace. The
+// configuration returned is never modified.
+func (n *Node) Config() *Config {
+	return n.cfg.Load()
+}
+
+// Raft returns the replicated log, which is nil unless raft_peers is set
+func (n *Node) Raft() *Raft {
+	return n.raft
+}
+
+// Plugins returns the external plugins configured for the node
+func (n *Node) Plugins() []*Plugin {
+	return n.plugins
+}
+
+// TimeSync returns the clock synchronization service
+func (n *Node) TimeSync() *TimeSync {
+	return n.timeSync
+}
+
+// JoinSpace returns the named shared key/value space, joining it if needed.
+// Spaces listed in kv_spaces are joined by New.
+func (n *Node) JoinSpace(name string) (*KVSpace, error) {
+	return n.kv.Join(name)
+}
+
+// Connect connects to a peer by its multiaddr, which must include /p2p/<peer ID>
+func (n *Node) Connect(ctx context.Context, addr string) error {
+	return discovery.ConnectToPeer(ctx, n.host, addr)
+}
+
+// Start sets up routing and the configured services, bootstraps, and runs
+// background tasks until ctx is cancelled or Stop is called
+func (n *Node) Start(ctx context.Context) error {
+	n.lifecycleMu.Lock()
+	defer n.lifecycleMu.Unlock()
+
+	n.mu.Lock()
+	if n.started {
+		n.mu.Unlock()
+		return fmt.Errorf("node already started")
+	}
+	n.started = true
+	ctx, n.cancel = context.WithCancel(ctx)
+	n.group, ctx = errgroup.WithContext(ctx)
+	n.ctx = ctx
+	n.mu.Unlock()
+	cfg := n.Config()
+
+	dhtOpts := []dht.Option{
+		dht.Datastore(namespaced(n.datastore, datastoreDHT)),
+		discovery.ModeOption(cfg.DHTMode),
+	}
+	// The public Amino DHT only accepts its pk and ipns namespaces, so app
+	// records live in a DHT of their own between the nodes of this app. Few
+	// peers run it, so in auto mode it serves unless AutoNAT finds the node
+	// behind NAT.
+	appMode := cfg.DHTMode
+	if appMode == discovery.DHTModeAuto {
+		appMode = discovery.DHTModeAutoServer
+	}
+	appDHTOpts := []dht.Option{
+		dht.ProtocolPrefix(AppDHTPrefix),
+		discovery.ModeOption(appMode),
+		dht.Datastore(namespaced(n.datastore, datastoreAppDHT)),
+		dht.NamespacedValidator(AppNamespace, AppRecordValidator{}),
+	}
+	// Offline, the DHTs are the local network's: they serve the peers found
+	// there and never query public addresses
+	if cfg.Offline {
+		offline := []dht.Option{
+			dht.Mode(dht.ModeServer),
+			dht.RoutingTableFilter(dht.PrivateRoutingTableFilter),
+			dht.QueryFilter(dht.PrivateQueryFilter),
+		}
+		dhtOpts = append(dhtOpts, offline...)
+		appDHTOpts = append(appDHTOpts, offline...)
+	}
+	var err error
+	n.dht, err = setupRouting(ctx, n.host, dhtOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to setup routing: %w", err)
+	}
+	n.appDHT, err = setupRouting(ctx, n.host, appDHTOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to setup app routing: %w", err)
+	}
+	if cfg.Offline {
+		for _, d := range []*discovery.DHT{n.dht, n.appDHT} {
+			d.EnableQueue()
+			n.group.Go(func() error {
+				d.RunQueue(ctx)
+				return nil
+			})
+		}
+	}
+	n.records, err = NewAppRecords(n.appDHT, n.host.Peerstore().PrivKey(n.host.ID()))
+	if err != nil {
+		return err
+	}
+
+	// Credit routing table peers that help our DHT queries
+	if n.activity != nil {
+		n.group.Go(func() error {
+			n.activity.WatchDHT(ctx, n.dht, time.Duration(cfg.ActivityDecay))
+			return nil
+		})
+	}
+	if n.eclipse != nil {
+		n.group.Go(func() error {
+			n.eclipse.Watch(ctx, n.dht, time.Duration(cfg.EclipseCheckInterval))
+			return nil
+		})
+	}
+
+	// Start HTTP gateway
+	if cfg.EnableGateway {
+		gateway := NewGateway(n.protocols, cfg.GatewayAddr)
+		gateway.AllowOrigins(cfg.GatewayOrigins...)
+		if err := gateway.Start(); err != nil {
+			return fmt.Errorf("failed to start gateway: %w", err)
+		}
+		n.gateway = gateway
+	}
+
+	// Serve liveness and readiness to orchestrators
+	if cfg.AdminHTTPAddr != "" {
+		n.adminHTTP = NewAdminHTTP(cfg.AdminHTTPAddr, n.readiness)
+		n.adminHTTP.Start()
+	}
+
+	// Export metrics to the configured telemetry backends
+	if len(cfg.MetricsExporters) > 0 {
+		n.metrics, err = NewMetrics(cfg, prometheus.DefaultGatherer, n.host.ID().String())
+		if err != nil {
+			return err
+		}
+		n.group.Go(func() error {
+			n.metrics.Run(ctx)
+			return nil
+		})
+	}
+
+	// Start HTTP-over-libp2p service
+	if cfg.EnableHTTPService {
+		n.httpService = NewHTTPService(n.host)
+		n.httpService.SetupHandlers()
+		n.httpService.Start()
+	}
+
+	// Tunnel TCP services to and from peers
+	for _, e := range cfg.Expose {
+		if _, err := n.ExposeTCP(protocol.ID(e.Protocol), e.Target, exposeAllowList(e.Allow)); err != nil {
+			return fmt.Errorf("failed to expose %s: %w", e.Protocol, err)
+		}
+	}
+	for _, f := range cfg.Forwards {
+		target, proto, _ := ParseForwardTarget(f.Target) // validated with the config
+		if _, err := n.ForwardTCP(f.Listen, target, proto); err != nil {
+			return fmt.Errorf("failed to forward %s: %w", f.Listen, err)
+		}
+	}
+
+	// Re-establish lost connections to important peers
+	if len(cfg.FailoverPeers) > 0 {
+		n.failover, err = NewFailover(n.host, cfg.FailoverAttempts)
+		if err != nil {
+			return fmt.Errorf("failed to create failover: %w", err)
+		}
+		for _, id := range cfg.FailoverPeers {
+			peerID, _ := peer.Decode(id) // validated with the config
+			n.failover.Watch(peerID)
+		}
+		n.failover.Start()
+	}
+
+	// Keep connections over several transports and schedule streams across them
+	if len(cfg.MultipathPeers) > 0 {
+		n.multipath, err = NewMultipath(n.host, cfg.MultipathPolicy, cfg.MultipathTransports, cfg.MultipathPins)
+		if err != nil {
+			return fmt.Errorf("failed to create multipath scheduler: %w", err)
+		}
+		n.multipath.SetDialTimeout(time.Duration(cfg.ConnectTimeout))
+		for _, id := range cfg.MultipathPeers {
+			peerID, _ := peer.Decode(id) // validated with the config
+			n.multipath.Watch(peerID)
+		}
+		n.multipath.Start()
+		n.protocols.SetStreamOpener(n.multipath)
+	}
+
+	// Hold reservations with relays so unreachable peers can connect through them
+	if len(cfg.RelayReservations) > 0 {
+		relays, _ := parsePinnedPeers(cfg.RelayReservations) // validated with the config
+		n.relays = NewRelayReservations(n.host, relays)
+		n.relays.Start()
+		if n.admin != nil {
+			n.admin.SetRelayReservations(n.relays)
+		}
+	}
+	if n.throttle != nil {
+		n.protocols.SetStreamOpener(n.throttle.Opener(n.protocols.StreamOpener()))
+	}
+	if n.quotas != nil {
+		n.protocols.SetStreamOpener(n.quotas.Opener(n.protocols.StreamOpener()))
+	}
+	if n.qos != nil {
+		n.protocols.SetStreamOpener(n.qos.Opener(n.protocols.StreamOpener()))
+	}
+	if n.auth != nil {
+		n.protocols.SetStreamOpener(n.auth.Opener(n.protocols.StreamOpener()))
+	}
+	n.protocols.SetStreamOpener(n.streams.Opener(n.protocols.StreamOpener()))
+
+	// Remember connected peers and reconnect to the ones from the last run
+	if cfg.EnableReconnect {
+		n.peerHistory, err = LoadPeerHistory(cfg.PeerHistoryFile)
+		if err != nil {
+			return fmt.Errorf("failed to load peer history: %w", err)
+		}
+		n.group.Go(func() error {
+			reconnectPeers(ctx, n.host, n.peerHistory, n.blocklist, n.diversity, cfg)
+			return nil
+		})
+		if err := n.peerHistory.Track(n.host); err != nil {
+			return fmt.Errorf("failed to track peers: %w", err)
+		}
+		n.group.Go(func() error {
+			n.saveHistoryPeriodically(ctx)
+			return nil
+		})
+	}
+
+	// Stream our logs to the collector peer
+	if cfg.LogCollector != "" {
+		forwarder, err := NewLogForwarder(n.host, cfg.LogCollector, cfg.LogForwardLevel)
+		if err != nil {
+			return fmt.Errorf("failed to create log forwarder: %w", err)
+		}
+		n.group.Go(func() error {
+			forwarder.Run(ctx)
+			return nil
+		})
+	}
+
+	// Keep the latencies of connected peers current
+	if cfg.PingInterval > 0 {
+		n.group.Go(func() error {
+			pingPeers(ctx, n.host, time.Duration(cfg.PingInterval), func(p peer.ID, rtt time.Duration) {
+				n.activity.Bump(p, ActivityPing)
+				n.latency.Record(p, rtt)
+				n.transports.RecordRTT(p, rtt)
+			})
+			return nil
+		})
+	}
+
+	// Keep the peerstore from growing without bound
+	if n.peerstoreGC != nil {
+		n.group.Go(func() error {
+			n.peerstoreGC.Run(ctx, time.Duration(cfg.PeerstoreGCInterval))
+			return nil
+		})
+	}
+
+	// Keep clock offsets to the selected peers up to date
+	if len(cfg.TimeSyncPeers) > 0 {
+		n.group.Go(func() error {
+			n.timeSync.Run(ctx, time.Duration(cfg.TimeSyncInterval))
+			return nil
+		})
+	}
+
+	// Repair key/value spaces that missed pushed updates
+	n.group.Go(func() error {
+		n.kv.Run(ctx, time.Duration(cfg.KVSyncInterval))
+		return nil
+	})
+
+	// Elect a leader and replicate the log with the raft peers
+	if n.raft != nil {
+		n.group.Go(func() error {
+			n.raft.Run(ctx)
+			return nil
+		})
+	}
+
+	// Start external plugins, which restart on their own if configured to
+	for _, plugin := range n.plugins {
+		if err := plugin.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Exchange service records with identified peers and announce ours in the DHT
+	identified, err := n.host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to identify events: %w", err)
+	}
+	n.group.Go(func() error {
+		n.capabilities.Run(ctx, identified)
+		return nil
+	})
+	n.group.Go(func() error {
+		n.provideServices(ctx)
+		return nil
+	})
+
+	// Retry queued messages, drop expired letters, and pick up the ones left
+	// for us at mailboxes
+	if n.outbox != nil {
+		n.group.Go(func() error {
+			n.outbox.Run(ctx, outboxSweepInterval)
+			return nil
+		})
+	}
+	if n.mailbox != nil {
+		n.group.Go(func() error {
+			n.mailbox.Run(ctx, mailboxSweepInterval)
+			return nil
+		})
+	}
+	if len(cfg.MailboxPeers) > 0 {
+		mailboxes := cfg.MailboxPeerIDs()
+		n.group.Go(func() error {
+			n.collectMailPeriodically(ctx, mailboxes)
+			return nil
+		})
+	}
+
+	// Report the DHT switching between client and server mode
+	modeChanges, err := n.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalProtocolsUpdated),
+		new(event.EvtLocalReachabilityChanged),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to DHT mode changes: %w", err)
+	}
+	modeEmitter, err := n.host.EventBus().Emitter(new(discovery.EvtDHTModeChanged))
+	if err != nil {
+		modeChanges.Close()
+		return fmt.Errorf("failed to create DHT mode emitter: %w", err)
+	}
+	n.group.Go(func() error {
+		discovery.WatchMode(ctx, n.host, modeChanges, modeEmitter)
+		return nil
+	})
+
+	// Publish our DHT records again when our addresses change
+	if cfg.RepublishDelay > 0 {
+		addrChanges, err := n.host.EventBus().Subscribe([]interface{}{
+			new(event.EvtLocalAddressesUpdated),
+			new(event.EvtLocalReachabilityChanged),
+			new(event.EvtAutoRelayAddrsUpdated),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to address changes: %w", err)
+		}
+		emitter, err := n.host.EventBus().Emitter(new(EvtRecordsRepublished))
+		if err != nil {
+			addrChanges.Close()
+			return fmt.Errorf("failed to create republish emitter: %w", err)
+		}
+		n.group.Go(func() error {
+			n.republishOnAddrChange(ctx, addrChanges, emitter)
+			return nil
+		})
+	}
+
+	// Open connections to pinned and nearby peers in the background
+	if cfg.EnablePrewarm {
+		n.group.Go(func() error {
+			prewarmConnections(ctx, n.host, n.dht, cfg, n.diversity)
+			return nil
+		})
+	}
+
+	// Deliver events to subscribers and peer hooks for the lifetime of the node
+	busSub, err := n.host.EventBus().Subscribe(busEvents)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to events: %w", err)
+	}
+	n.group.Go(func() error {
+		n.watchEvents(ctx, busSub)
+		return nil
+	})
+
+	bootstrapPeers := cfg.BootstrapPeers
+	if cfg.Offline {
+		bootstrapPeers = discovery.LocalBootstrapPeers(bootstrapPeers)
+		logrus.WithField("bootstrap_peers", len(bootstrapPeers)).Info("Offline mode, using local peers only")
+	}
+	if cfg.MDNSEnabled() {
+		n.mdns, err = discovery.NewMDNS(n.host, time.Duration(cfg.MDNSInterval))
+		if err != nil {
+			return fmt.Errorf("failed to start mDNS: %w", err)
+		}
+	}
+	if err := discovery.BootstrapPeers(ctx, n.host, bootstrapPeers); err != nil {
+		return fmt.Errorf("failed to bootstrap: %w", err)
+	}
+
+	if err := runLifecycleHooks(ctx, "start", n.hooks.lifecycle(true)); err != nil {
+		return err
+	}
+
+	// Tell systemd the node is up, and feed its watchdog while the node is alive
+	if ok, err := SdNotify(SdReady); err != nil {
+		logrus.WithError(err).Warn("Failed to notify systemd")
+	} else if ok {
+		if interval := SdWatchdogInterval(); interval > 0 {
+			n.group.Go(func() error {
+				sdWatchdog(ctx, interval, n.alive)
+				return nil
+			})
+		}
+	}
+
+	logrus.WithField("peer_id", n.host.ID()).Info("Node started")
+	return nil
+}
+
+// saveHistoryPeriodically writes the peer history to disk until ctx is done
+func (n *Node) saveHistoryPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(historySaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.peerHistory.Save(); err != nil {
+				logrus.WithError(err).Error("Failed to save peer history")
+			}
+		}
+	}
+}
+
+// Stop stops the background tasks, says goodbye to connected peers and shuts
+// the services and host down. ctx bounds the graceful part of the shutdown.
+func (n *Node) Stop(ctx context.Context) error {
+	n.lifecycleMu.Lock()
+	defer n.lifecycleMu.Unlock()
+
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil
+	}
+	n.stopped = true
+	started := n.started
+	n.mu.Unlock()
+
+	var errs []error
+	if started {
+		SdNotify(SdStopping)
+		if err := runLifecycleHooks(ctx, "stop", n.hooks.lifecycle(false)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if n.cancel != nil {
+		n.cancel()
+		n.group.Wait()
+	}
+
+	if n.gateway != nil {
+		if err := n.gateway.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop gateway: %w", err))
+		}
+	}
+	if n.adminHTTP != nil {
+		if err := n.adminHTTP.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop admin HTTP endpoints: %w", err))
+		}
+	}
+	if n.metrics != nil {
+		if err := n.metrics.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop metrics: %w", err))
+		}
+	}
+	if n.peerHistory != nil {
+		if err := n.peerHistory.Save(); err != nil {
+			errs = append(errs, err)
+		}
+		n.peerHistory.Close()
+	}
+	if n.failover != nil {
+		n.failover.Close()
+	}
+	if n.multipath != nil {
+		n.multipath.Close()
+	}
+	if n.relays != nil {
+		n.relays.Close()
+	}
+	n.maintenance.Close()
+
+	// Say goodbye once nothing will try to re-establish the connections
+	goodbyeCtx, cancel := context.WithTimeout(ctx, goodbyeShutdownTimeout)
+	n.goodbye.DisconnectAll(goodbyeCtx, GoodbyeShutdown, "node shutting down")
+	cancel()
+
+	if err := n.close(); err != nil {
+		errs = append(errs, err)
+	}
+	n.events.close()
+	return errors.Join(errs...)
+}
+
+// close releases the services created by New and Start, then the host
+func (n *Node) close() error {
+	var errs []error
+	n.closeServices()
+	if n.throttle != nil {
+		n.throttle.Close()
+	}
+	if n.quotas != nil {
+		if err := n.quotas.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if n.admin != nil {
+		n.admin.Close()
+	}
+	if n.config != nil {
+		n.config.Close()
+	}
+	if n.collector != nil {
+		n.collector.Close()
+	}
+	if n.shell != nil {
+		n.shell.Close()
+	}
+	if n.transfer != nil {
+		n.transfer.Close()
+	}
+	if n.blobs != nil {
+		n.blobs.Close()
+	}
+	if n.timeSync != nil {
+		n.timeSync.Close()
+	}
+	if n.kv != nil {
+		n.kv.Close()
+	}
+	if n.raft != nil {
+		n.raft.Close()
+	}
+	for _, plugin := range n.plugins {
+		plugin.Close()
+	}
+	if n.activity != nil {
+		n.activity.Close()
+	}
+	if n.diversity != nil {
+		n.diversity.Close()
+	}
+	if n.eclipse != nil {
+		n.eclipse.Close()
+	}
+	if n.peerstoreGC != nil {
+		n.peerstoreGC.Close()
+	}
+	if n.observed != nil {
+		n.observed.Close()
+	}
+	if n.protoCache != nil {
+		n.protoCache.Close()
+	}
+	if n.mdns != nil {
+		n.mdns.Close()
+	}
+	if n.transports != nil {
+		n.transports.Close()
+	}
+	if n.timings != nil {
+		n.timings.Close()
+	}
+	if n.autonat != nil {
+		n.autonat.Close()
+	}
+	for _, handler := range n.wasm {
+		handler.Close()
+	}
+	if n.capabilities != nil {
+		n.capabilities.Close()
+	}
+	if n.mailbox != nil {
+		n.mailbox.Close()
+	}
+	if n.outbox != nil {
+		if err := n.outbox.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save outbox: %w", err))
+		}
+	}
+	if n.roomSync != nil {
+		n.roomSync.Close()
+	}
+	if n.rooms != nil {
+		if err := n.rooms.Save(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save rooms: %w", err))
+		}
+	}
+	if n.health != nil {
+		n.health.Close()
+	}
+	if n.reflect != nil {
+		n.reflect.Close()
+	}
+	if n.secureChat != nil {
+		n.secureChat.Close()
+	}
+	if n.reputation != nil {
+		if err := n.reputation.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save peer reputation: %w", err))
+		}
+	}
+	if n.goodbye != nil {
+		if err := n.goodbye.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close goodbye: %w", err))
+		}
+	}
+	if n.pairing != nil {
+		if err := n.pairing.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close pairing: %w", err))
+		}
+	}
+	if n.httpService != nil {
+		if err := n.httpService.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop HTTP service: %w", err))
+		}
+	}
+	if n.dht != nil {
+		if err := n.dht.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close DHT: %w", err))
+		}
+	}
+	if n.appDHT != nil {
+		if err := n.appDHT.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close app DHT: %w", err))
+		}
+	}
+	if err := n.host.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close host: %w", err))
+	}
+	if err := n.datastore.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close datastore: %w", err))
+	}
+	if err := n.audit.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close audit log: %w", err))
+	}
+	return errors.Join(errs...)
+}
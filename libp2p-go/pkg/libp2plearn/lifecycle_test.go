@@ -0,0 +1,139 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+func testNodeConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.ListenPort = 0
+	cfg.EnableWebSocket = false
+	cfg.BootstrapPeers = nil
+	// Loopback peers are never publicly reachable, so auto mode would
+	// leave every test node a DHT client
+	cfg.DHTMode = discovery.DHTModeServer
+	return cfg
+}
+
+func TestNodeLifecycle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.DialStrategy = "bogus"
+		_, err := New(WithConfig(cfg))
+		assert.Error(t, err)
+	})
+
+	t.Run("StartAndStop", func(t *testing.T) {
+		node1, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		assert.Nil(t, node1.DHT(), "DHT should not exist before Start")
+
+		require.NoError(t, node1.Start(ctx))
+		assert.NotNil(t, node1.DHT())
+		assert.Error(t, node1.Start(ctx), "Starting twice should fail")
+
+		node2, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		require.NoError(t, node2.Start(ctx))
+		defer node2.Stop(ctx)
+
+		addr := fmt.Sprintf("%s/p2p/%s", node2.Host().Addrs()[0], node2.Host().ID())
+		require.NoError(t, node1.Connect(ctx, addr))
+		err = WaitForConnection(ctx, node1.Host(), node2.Host(), 10*time.Second)
+		require.NoError(t, err)
+
+		response, err := node1.Protocols().SendPing(ctx, node2.Host().ID(), "hello")
+		require.NoError(t, err)
+		assert.Contains(t, response, "hello")
+
+		require.NoError(t, node1.Stop(ctx))
+		assert.NoError(t, node1.Stop(ctx), "Stopping twice should be a no-op")
+		assert.Empty(t, node1.Host().Network().Peers())
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		other, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer other.Close()
+
+		node, err := New(
+			WithConfig(testNodeConfig()),
+			WithListenPort(0),
+			WithBlockedPeers(other.ID().String()),
+		)
+		require.NoError(t, err)
+		defer node.Stop(ctx)
+		assert.True(t, node.Blocklist().IsBlocked(other.ID()))
+
+		_, err = New(WithConfig(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("BootstrapServer", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"high_water": 3000, "bootstrap_peers": [], "enable_websocket": false}`), 0644))
+		cfg, err := LoadBootstrapServerConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, discovery.DHTModeServer, cfg.DHTMode)
+		assert.Equal(t, 3000, cfg.HighWater, "the file overrides the defaults")
+
+		node, err := New(WithConfig(cfg), WithListenPort(0))
+		require.NoError(t, err)
+		defer node.Stop(ctx)
+		protos := node.Host().Mux().Protocols()
+		assert.NotContains(t, protos, protocol.ID(ChatProtocol))
+		assert.NotContains(t, protos, protocol.ID(EchoProtocol))
+		assert.Contains(t, protos, protocol.ID(HealthProtocol))
+	})
+
+	t.Run("Mailbox", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.EnableMailbox = true
+		cfg.EnableOutbox = true
+		cfg.OutboxFile = filepath.Join(t.TempDir(), "outbox.json")
+
+		node, err := New(WithConfig(cfg), WithListenPort(0))
+		require.NoError(t, err)
+		defer node.Stop(ctx)
+		assert.NotNil(t, node.Outbox())
+		assert.NotNil(t, node.Mailbox())
+		assert.Contains(t, node.Host().Mux().Protocols(), protocol.ID(MailboxProtocol))
+		assert.Contains(t, node.Capabilities().Services(), MailboxService)
+	})
+
+	t.Run("Rooms", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.EnableRooms = true
+		cfg.RoomsFile = filepath.Join(t.TempDir(), "rooms.json")
+
+		node, err := New(WithConfig(cfg), WithListenPort(0))
+		require.NoError(t, err)
+		_, err = node.Rooms().Create("team")
+		require.NoError(t, err)
+		assert.Contains(t, node.Host().Mux().Protocols(), protocol.ID(RoomProtocol))
+
+		// Stopping saves the rooms
+		require.NoError(t, node.Stop(ctx))
+		assert.FileExists(t, cfg.RoomsFile)
+	})
+
+	t.Run("StopWithoutStart", func(t *testing.T) {
+		node, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		assert.NoError(t, node.Stop(ctx))
+	})
+}
@@ -0,0 +1,78 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	golog "github.com/ipfs/go-log/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// LogLevels is the level of the node's own logs, and the levels set for
+// libp2p subsystems
+type LogLevels struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems"` // levels set with log_subsystems or at runtime
+	Available  []string          `json:"available"`  // every libp2p subsystem, such as swarm2 or dht
+}
+
+var (
+	subsystemLevelsMu sync.Mutex
+	subsystemLevels   = make(map[string]string)
+)
+
+// SetLogLevel changes the level of the node's own logs if subsystem is
+// empty, or of a libp2p subsystem, or of all of them with "*". libp2p
+// subsystems don't have the trace level.
+func SetLogLevel(subsystem, level string) error {
+	if subsystem == "" {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("invalid log level: %w", err)
+		}
+		logrus.SetLevel(lvl)
+		return nil
+	}
+
+	if _, err := golog.LevelFromString(level); err != nil {
+		return fmt.Errorf("invalid log level for %s: %w", subsystem, err)
+	}
+	subsystemLevelsMu.Lock()
+	defer subsystemLevelsMu.Unlock()
+	if err := golog.SetLogLevel(subsystem, level); err != nil {
+		return fmt.Errorf("failed to set log level of %s: %w", subsystem, err)
+	}
+	if subsystem == "*" {
+		clear(subsystemLevels)
+		for _, name := range golog.GetSubsystems() {
+			subsystemLevels[name] = level
+		}
+	} else {
+		subsystemLevels[subsystem] = level
+	}
+	logrus.WithFields(logrus.Fields{
+		"subsystem": subsystem,
+		"level":     level,
+	}).Info("Changed log level of libp2p subsystem")
+	return nil
+}
+
+// CurrentLogLevels returns the level of the node's own logs and the levels
+// set for libp2p subsystems. Subsystems not listed log at their default,
+// error unless GOLOG_LOG_LEVEL says otherwise.
+func CurrentLogLevels() LogLevels {
+	levels := LogLevels{
+		Level:      logrus.GetLevel().String(),
+		Subsystems: make(map[string]string),
+		Available:  golog.GetSubsystems(),
+	}
+	sort.Strings(levels.Available)
+
+	subsystemLevelsMu.Lock()
+	defer subsystemLevelsMu.Unlock()
+	for name, level := range subsystemLevels {
+		levels.Subsystems[name] = level
+	}
+	return levels
+}
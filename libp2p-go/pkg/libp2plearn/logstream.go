@@ -0,0 +1,334 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LogStreamProtocol carries structured log entries to a collector peer
+	LogStreamProtocol = "/libp2p-learn/logs/1.0.0"
+
+	// logQueueSize is how many entries wait for the collector before new ones are dropped
+	logQueueSize = 1024
+
+	// maxLogEntrySize bounds the size of one forwarded log line
+	maxLogEntrySize = 64 * 1024
+
+	// logRetryMin and logRetryMax bound the backoff between collector reconnects
+	logRetryMin = time.Second
+	logRetryMax = time.Minute
+
+	// logInternalField marks entries logged by log streaming itself, which are never forwarded
+	logInternalField = "log_stream"
+
+	// logSourceField marks entries re-logged by a collector on behalf of a remote peer
+	logSourceField = "log_source"
+)
+
+// LogEntry is a forwarded log entry, sent as one JSON line
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogForwarder streams local log entries at or above a level to a collector peer.
+// Entries queue while the collector is unreachable and are dropped, and counted,
+// once the queue is full so logging never blocks the node.
+type LogForwarder struct {
+	host      host.Host
+	collector peer.AddrInfo
+	level     logrus.Level
+	entries   chan []byte
+	pending   []byte
+	dropped   atomic.Int64
+}
+
+// NewLogForwarder creates a forwarder to the collector multiaddr, which must include /p2p/<peer ID>
+func NewLogForwarder(h host.Host, collectorAddr string, level string) (*LogForwarder, error) {
+	collector, err := peer.AddrInfoFromString(collectorAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log collector address: %w", err)
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log forward level: %w", err)
+	}
+
+	return &LogForwarder{
+		host:      h,
+		collector: *collector,
+		level:     lvl,
+		entries:   make(chan []byte, logQueueSize),
+	}, nil
+}
+
+// Levels implements logrus.Hook
+func (f *LogForwarder) Levels() []logrus.Level {
+	var levels []logrus.Level
+	for _, l := range logrus.AllLevels {
+		if l <= f.level {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// Fire implements logrus.Hook by queueing the entry without blocking
+func (f *LogForwarder) Fire(e *logrus.Entry) error {
+	if _, ok := e.Data[logInternalField]; ok {
+		return nil
+	}
+	if _, ok := e.Data[logSourceField]; ok {
+		return nil
+	}
+
+	line, err := encodeLogEntry(e)
+	if err != nil {
+		return nil
+	}
+	select {
+	case f.entries <- line:
+	default:
+		f.dropped.Add(1)
+	}
+	return nil
+}
+
+// Run forwards queued entries to the collector, reconnecting with backoff, until ctx is done
+func (f *LogForwarder) Run(ctx context.Context) {
+	logrus.AddHook(f)
+	defer f.removeHook()
+
+	backoff := logRetryMin
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := f.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A stream that stayed up for a while means the collector is healthy again
+		if time.Since(start) > logRetryMax {
+			backoff = logRetryMin
+		}
+		logrus.WithError(err).WithFields(logrus.Fields{
+			logInternalField: true,
+			"collector":      f.collector.ID,
+			"retry_in":       backoff,
+		}).Debug("Log stream to collector interrupted")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, logRetryMax)
+	}
+}
+
+// Dropped returns how many entries were dropped because the queue was full
+func (f *LogForwarder) Dropped() int64 {
+	return f.dropped.Load()
+}
+
+// stream connects to the collector and writes entries until an error or ctx is done
+func (f *LogForwarder) stream(ctx context.Context) error {
+	if err := f.host.Connect(ctx, f.collector); err != nil {
+		return fmt.Errorf("failed to connect to collector: %w", err)
+	}
+	s, err := f.host.NewStream(ctx, f.collector.ID, protocol.ID(LogStreamProtocol))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	logrus.WithFields(logrus.Fields{
+		logInternalField: true,
+		"collector":      f.collector.ID,
+	}).Info("Streaming logs to collector")
+
+	w := bufio.NewWriter(s)
+	for {
+		if f.pending == nil {
+			select {
+			case <-ctx.Done():
+				w.Flush()
+				return nil
+			case f.pending = <-f.entries:
+			}
+		}
+
+		// Tell the collector about entries lost while it was unreachable
+		if n := f.dropped.Swap(0); n > 0 {
+			notice, _ := json.Marshal(LogEntry{
+				Time:    time.Now(),
+				Level:   logrus.WarnLevel.String(),
+				Message: fmt.Sprintf("dropped %d log entries", n),
+			})
+			if _, err := w.Write(append(notice, '\n')); err != nil {
+				f.dropped.Add(n)
+				return fmt.Errorf("failed to write log entry: %w", err)
+			}
+		}
+
+		if _, err := w.Write(f.pending); err != nil {
+			return fmt.Errorf("failed to write log entry: %w", err)
+		}
+
+		// Flush once the queue is drained so bursts share a write
+		if len(f.entries) == 0 {
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("failed to flush log entries: %w", err)
+			}
+		}
+		f.pending = nil
+	}
+}
+
+// removeHook unregisters the forwarder from the standard logger
+func (f *LogForwarder) removeHook() {
+	hooks := make(logrus.LevelHooks)
+	for level, levelHooks := range logrus.StandardLogger().Hooks {
+		for _, h := range levelHooks {
+			if h != f {
+				hooks[level] = append(hooks[level], h)
+			}
+		}
+	}
+	logrus.StandardLogger().ReplaceHooks(hooks)
+}
+
+// encodeLogEntry converts a logrus entry into a JSON line
+func encodeLogEntry(e *logrus.Entry) ([]byte, error) {
+	entry := LogEntry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+	if len(e.Data) > 0 {
+		entry.Fields = make(map[string]interface{}, len(e.Data))
+		for k, v := range e.Data {
+			switch v := v.(type) {
+			case error:
+				entry.Fields[k] = v.Error()
+			case fmt.Stringer:
+				entry.Fields[k] = v.String()
+			default:
+				entry.Fields[k] = v
+			}
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// Fall back to plain strings for values JSON can't encode
+		for k, v := range entry.Fields {
+			entry.Fields[k] = fmt.Sprint(v)
+		}
+		if data, err = json.Marshal(entry); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > maxLogEntrySize {
+		return nil, fmt.Errorf("log entry too large")
+	}
+	return append(data, '\n'), nil
+}
+
+// LogSink receives log entries forwarded by a peer
+type LogSink func(from peer.ID, entry LogEntry)
+
+// LogCollector accepts log streams from known peers
+type LogCollector struct {
+	host    host.Host
+	sources map[peer.ID]bool
+	sink    LogSink
+}
+
+// NewLogCollector accepts logs from the given peer IDs and passes them to sink.
+// A nil sink re-logs each entry locally, tagged with its source peer.
+func NewLogCollector(h host.Host, sourceIDs []string, sink LogSink) (*LogCollector, error) {
+	c := &LogCollector{
+		host:    h,
+		sources: make(map[peer.ID]bool, len(sourceIDs)),
+		sink:    sink,
+	}
+	if c.sink == nil {
+		c.sink = relogEntry
+	}
+	for _, id := range sourceIDs {
+		p, err := peer.Decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log source %q: %w", id, err)
+		}
+		c.sources[p] = true
+	}
+
+	h.SetStreamHandler(protocol.ID(LogStreamProtocol), RecoveryMiddleware(protocol.ID(LogStreamProtocol), c.handleLogs))
+	logrus.WithFields(logrus.Fields{
+		"protocol": LogStreamProtocol,
+		"sources":  len(c.sources),
+	}).Info("Registered log collector")
+	return c, nil
+}
+
+// Close unregisters the log collector
+func (c *LogCollector) Close() {
+	c.host.RemoveStreamHandler(protocol.ID(LogStreamProtocol))
+}
+
+// handleLogs reads log entries from a source peer until it closes the stream
+func (c *LogCollector) handleLogs(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !c.sources[remote] {
+		logrus.WithField("peer", remote).Warn("Rejected log stream from unknown peer")
+		s.Reset()
+		return
+	}
+	defer s.Close()
+
+	reader := bufio.NewReaderSize(s, maxLogEntrySize)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			logrus.WithError(err).WithField("peer", remote).Debug("Log stream closed")
+			return
+		}
+
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logrus.WithError(err).WithField("peer", remote).Warn("Received invalid log entry")
+			continue
+		}
+		c.sink(remote, entry)
+	}
+}
+
+// relogEntry writes a forwarded entry to the local logger at its original level
+func relogEntry(from peer.ID, entry LogEntry) {
+	level, err := logrus.ParseLevel(entry.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	// Never let a remote panic or fatal entry take the collector down
+	if level < logrus.ErrorLevel {
+		level = logrus.ErrorLevel
+	}
+	logrus.WithFields(entry.Fields).
+		WithField(logSourceField, from.String()).
+		WithTime(entry.Time).
+		Log(level, entry.Message)
+}
@@ -0,0 +1,103 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStreaming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	source, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer source.Close()
+
+	collectorHost, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer collectorHost.Close()
+
+	received := make(chan LogEntry, 16)
+	collector, err := NewLogCollector(collectorHost, []string{source.ID().String()}, func(from peer.ID, entry LogEntry) {
+		if from == source.ID() {
+			received <- entry
+		}
+	})
+	require.NoError(t, err)
+	defer collector.Close()
+
+	collectorAddr := fmt.Sprintf("%s/p2p/%s", collectorHost.Addrs()[0], collectorHost.ID())
+
+	t.Run("ForwardsEntriesAboveLevel", func(t *testing.T) {
+		forwarder, err := NewLogForwarder(source, collectorAddr, "warn")
+		require.NoError(t, err)
+
+		runCtx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			forwarder.Run(runCtx)
+			close(done)
+		}()
+		defer func() {
+			stop()
+			<-done
+		}()
+
+		// Keep logging until the stream is up, since entries before the hook is added are lost
+		err = WaitWithCondition(ctx, func() bool {
+			logrus.WithField("test", "logstream").Info("not forwarded")
+			logrus.WithField("test", "logstream").Warn("forwarded")
+			select {
+			case entry := <-received:
+				assert.Equal(t, "forwarded", entry.Message)
+				assert.Equal(t, "warning", entry.Level)
+				assert.Equal(t, "logstream", entry.Fields["test"])
+				return true
+			default:
+				return false
+			}
+		}, 10*time.Second, 200*time.Millisecond)
+		require.NoError(t, err)
+	})
+
+	t.Run("RejectsUnknownSources", func(t *testing.T) {
+		stranger, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer stranger.Close()
+
+		require.NoError(t, connectNodes(ctx, stranger, collectorHost))
+		s, err := stranger.NewStream(ctx, collectorHost.ID(), LogStreamProtocol)
+		require.NoError(t, err)
+		defer s.Close()
+
+		s.Write([]byte(`{"level":"info","message":"spoofed"}` + "\n"))
+		_, err = s.Read(make([]byte, 1))
+		assert.Error(t, err, "collector should reset streams from unknown peers")
+
+		// Entries the previous forwarder sent may still be queued
+		for len(received) > 0 {
+			if entry := <-received; entry.Message == "spoofed" {
+				t.Fatalf("unexpected entry from unknown source: %v", entry)
+			}
+		}
+	})
+
+	t.Run("QueueDropsWhenFull", func(t *testing.T) {
+		forwarder, err := NewLogForwarder(source, collectorAddr, "info")
+		require.NoError(t, err)
+
+		entry := logrus.NewEntry(logrus.StandardLogger())
+		entry.Message = "filler"
+		for i := 0; i < logQueueSize+10; i++ {
+			require.NoError(t, forwarder.Fire(entry))
+		}
+		assert.Equal(t, int64(10), forwarder.Dropped())
+	})
+}
@@ -0,0 +1,478 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MailboxProtocol stores sealed chat messages for offline peers and
+	// hands them over when they ask
+	MailboxProtocol = "/libp2p-learn/mailbox/1.0.0"
+
+	// MailboxLetterDomain is the signature domain of mailbox letters
+	MailboxLetterDomain = "libp2p-learn-mailbox-letter"
+
+	// MailboxService is the service name mailbox nodes advertise
+	MailboxService = "mailbox"
+
+	// mailboxTimeout bounds one mailbox exchange
+	mailboxTimeout = 10 * time.Second
+
+	// maxMailboxMessageSize bounds the size of a mailbox request or response
+	maxMailboxMessageSize = 1 << 20
+
+	// maxLetterSize bounds the size of one sealed letter
+	maxLetterSize = 64 << 10
+
+	// maxLetterTTL is the longest a mailbox keeps a letter
+	maxLetterTTL = 7 * 24 * time.Hour
+
+	// mailboxPollInterval is how often mailbox peers are asked for our letters
+	mailboxPollInterval = 5 * time.Minute
+
+	// mailboxSweepInterval is how often expired letters are dropped
+	mailboxSweepInterval = time.Minute
+)
+
+// Mailbox operations
+const (
+	mailboxPut   = "put"
+	mailboxFetch = "fetch"
+)
+
+// mailboxLetterCodec is the payload type of letter envelopes
+var mailboxLetterCodec = []byte("/libp2p-learn/mailbox-letter")
+
+// errMailboxFull is why a mailbox refused a letter
+var errMailboxFull = errors.New("mailbox full")
+
+// MailboxLetter is a chat message left at a mailbox. It is sealed in an
+// envelope signed by the sender, then encrypted for the recipient, so the
+// mailbox can neither read nor forge it.
+type MailboxLetter struct {
+	ID   string  `json:"id"`
+	From peer.ID `json:"from"`
+	To   peer.ID `json:"to"`
+	Text string  `json:"text"`
+	Sent int64   `json:"sent"` // Unix nanoseconds
+}
+
+// Domain is the signature domain of mailbox letters
+func (l *MailboxLetter) Domain() string {
+	return MailboxLetterDomain
+}
+
+// Codec is the payload type of mailbox letters
+func (l *MailboxLetter) Codec() []byte {
+	return mailboxLetterCodec
+}
+
+// MarshalRecord encodes the letter as JSON
+func (l *MailboxLetter) MarshalRecord() ([]byte, error) {
+	return json.Marshal(l)
+}
+
+// UnmarshalRecord decodes a JSON letter
+func (l *MailboxLetter) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, l)
+}
+
+// SealLetter signs a letter with the sender's key and encrypts it for the
+// recipient
+func SealLetter(key crypto.PrivKey, letter *MailboxLetter) ([]byte, error) {
+	env, err := record.Seal(letter, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign letter: %w", err)
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode letter: %w", err)
+	}
+	return EncryptFor(letter.To, data)
+}
+
+// OpenLetter decrypts a letter sealed for the owner of key and verifies that
+// it was signed by its sender and is addressed to the owner
+func OpenLetter(key crypto.PrivKey, sealed []byte) (*MailboxLetter, error) {
+	data, err := Decrypt(key, sealed)
+	if err != nil {
+		return nil, err
+	}
+	var letter MailboxLetter
+	env, err := record.ConsumeTypedEnvelope(data, &letter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid letter: %w", err)
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	if signer != letter.From {
+		return nil, fmt.Errorf("letter from %s signed by %s", letter.From, signer)
+	}
+	self, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if letter.To != self {
+		return nil, fmt.Errorf("letter addressed to %s", letter.To)
+	}
+	return &letter, nil
+}
+
+// mailboxRequest leaves a letter for a peer, or asks for the letters left
+// for the requester, as one JSON line
+type mailboxRequest struct {
+	Op      string    `json:"op"`
+	To      peer.ID   `json:"to,omitempty"`
+	Letter  []byte    `json:"letter,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// mailboxResponse answers a mailbox request as one JSON line
+type mailboxResponse struct {
+	Error   string   `json:"error,omitempty"`
+	Letters [][]byte `json:"letters,omitempty"`
+}
+
+// MailboxLimits bound the letters a mailbox keeps
+type MailboxLimits struct {
+	MaxPerPeer   int   // letters waiting for one recipient
+	MaxPerSender int   // letters left by one sender, for all recipients
+	MaxBytes     int64 // size of all letters
+}
+
+// storedLetter is a sealed letter waiting for its recipient
+type storedLetter struct {
+	from    peer.ID
+	data    []byte
+	expires time.Time
+}
+
+// Mailbox keeps sealed letters for peers until they fetch them or the
+// letters expire. Letters are held in memory within the limits, and only
+// the recipient can fetch them.
+type Mailbox struct {
+	host   host.Host
+	limits MailboxLimits
+
+	mu      sync.Mutex
+	letters map[peer.ID][]storedLetter
+	senders map[peer.ID]int // letters stored per sender
+	bytes   int64
+}
+
+// NewMailbox creates the mailbox service and registers its protocol handler.
+// Run drops the letters that expire.
+func NewMailbox(h host.Host, limits MailboxLimits) *Mailbox {
+	m := &Mailbox{
+		host:    h,
+		limits:  limits,
+		letters: make(map[peer.ID][]storedLetter),
+		senders: make(map[peer.ID]int),
+	}
+	h.SetStreamHandler(protocol.ID(MailboxProtocol), RecoveryMiddleware(protocol.ID(MailboxProtocol), m.handleMailbox))
+	logrus.WithField("protocol", MailboxProtocol).Info("Registered mailbox protocol")
+	return m
+}
+
+// Close unregisters the mailbox protocol
+func (m *Mailbox) Close() {
+	m.host.RemoveStreamHandler(protocol.ID(MailboxProtocol))
+}
+
+// Pending returns how many letters wait for the peer
+func (m *Mailbox) Pending(p peer.ID) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(p, time.Now())
+	return len(m.letters[p])
+}
+
+// Run drops expired letters every interval until ctx is done, including
+// those for recipients that never fetch them
+func (m *Mailbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweep()
+		}
+	}
+}
+
+// sweep drops the expired letters of every recipient
+func (m *Mailbox) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for p := range m.letters {
+		m.expire(p, now)
+	}
+}
+
+// put stores a letter from a peer for another
+func (m *Mailbox) put(from, to peer.ID, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(to, time.Now())
+	if len(m.letters[to]) >= m.limits.MaxPerPeer ||
+		m.senders[from] >= m.limits.MaxPerSender ||
+		m.bytes+int64(len(data)) > m.limits.MaxBytes {
+		return errMailboxFull
+	}
+	m.letters[to] = append(m.letters[to], storedLetter{from: from, data: data, expires: expires})
+	m.senders[from]++
+	m.bytes += int64(len(data))
+	return nil
+}
+
+// take removes and returns the letters for a peer
+func (m *Mailbox) take(p peer.ID) [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(p, time.Now())
+	stored := m.letters[p]
+	delete(m.letters, p)
+
+	letters := make([][]byte, len(stored))
+	for i, l := range stored {
+		m.release(l)
+		letters[i] = l.data
+	}
+	return letters
+}
+
+// expire drops the peer's letters expired at now; m.mu must be held
+func (m *Mailbox) expire(p peer.ID, now time.Time) {
+	kept := m.letters[p][:0]
+	for _, l := range m.letters[p] {
+		if now.Before(l.expires) {
+			kept = append(kept, l)
+		} else {
+			m.release(l)
+		}
+	}
+	if len(kept) == 0 {
+		delete(m.letters, p)
+		return
+	}
+	m.letters[p] = kept
+}
+
+// release takes a letter leaving the mailbox off its sender's count and the
+// total size; m.mu must be held
+func (m *Mailbox) release(l storedLetter) {
+	m.bytes -= int64(len(l.data))
+	m.senders[l.from]--
+	if m.senders[l.from] <= 0 {
+		delete(m.senders, l.from)
+	}
+}
+
+// handleMailbox stores a letter or hands the requester its letters
+func (m *Mailbox) handleMailbox(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(mailboxTimeout))
+
+	reader := bufio.NewReaderSize(io.LimitReader(s, maxMailboxMessageSize), maxMailboxMessageSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read mailbox request")
+		return
+	}
+	var req mailboxRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Received invalid mailbox request")
+		return
+	}
+
+	var resp mailboxResponse
+	switch req.Op {
+	case mailboxPut:
+		if err := m.accept(remote, req); err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		logrus.WithFields(logrus.Fields{"from": remote, "to": req.To}).Debug("Stored letter")
+	case mailboxFetch:
+		resp.Letters = m.take(remote)
+		if len(resp.Letters) > 0 {
+			logrus.WithFields(logrus.Fields{"peer": remote, "letters": len(resp.Letters)}).Info("Handed over letters")
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown operation %q", req.Op)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode mailbox response")
+		s.Reset()
+		return
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to send mailbox response")
+	}
+}
+
+// accept checks and stores a letter left by a peer
+func (m *Mailbox) accept(from peer.ID, req mailboxRequest) error {
+	if req.To == "" {
+		return fmt.Errorf("no recipient")
+	}
+	if len(req.Letter) == 0 || len(req.Letter) > maxLetterSize {
+		return fmt.Errorf("letter must be 1 to %d bytes", maxLetterSize)
+	}
+	expires := req.Expires
+	if latest := time.Now().Add(maxLetterTTL); expires.IsZero() || expires.After(latest) {
+		expires = latest
+	}
+	if !time.Now().Before(expires) {
+		return fmt.Errorf("letter expired")
+	}
+	return m.put(from, req.To, req.Letter, expires)
+}
+
+// PutLetter leaves a sealed letter for a peer at a mailbox node, which
+// keeps it until expires
+func PutLetter(ctx context.Context, h host.Host, mailbox, to peer.ID, sealed []byte, expires time.Time) error {
+	_, err := mailboxExchange(ctx, h, mailbox, mailboxRequest{Op: mailboxPut, To: to, Letter: sealed, Expires: expires})
+	return err
+}
+
+// FetchLetters takes the sealed letters a mailbox node holds for us
+func FetchLetters(ctx context.Context, h host.Host, mailbox peer.ID) ([][]byte, error) {
+	resp, err := mailboxExchange(ctx, h, mailbox, mailboxRequest{Op: mailboxFetch})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Letters, nil
+}
+
+// mailboxExchange sends one request to a mailbox node and reads its response
+func mailboxExchange(ctx context.Context, h host.Host, mailbox peer.ID, req mailboxRequest) (*mailboxResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, mailboxTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, mailbox, protocol.ID(MailboxProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode mailbox request: %w", err)
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send mailbox request: %w", err)
+	}
+	s.CloseWrite()
+
+	reader := bufio.NewReaderSize(io.LimitReader(s, maxMailboxMessageSize), maxMailboxMessageSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mailbox response: %w", err)
+	}
+	var resp mailboxResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid mailbox response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("mailbox refused request: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// Mailbox returns the mailbox this node keeps for others, which is nil
+// unless enable_mailbox is set
+func (n *Node) Mailbox() *Mailbox {
+	return n.mailbox
+}
+
+// CollectMail fetches our letters from a mailbox node and delivers them like
+// received chat messages, to message filters, hooks and subscribers. It
+// returns how many were delivered.
+func (n *Node) CollectMail(ctx context.Context, mailbox peer.ID) (int, error) {
+	letters, err := FetchLetters(ctx, n.host, mailbox)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch letters from %s: %w", mailbox, err)
+	}
+
+	key := n.host.Peerstore().PrivKey(n.host.ID())
+	delivered := 0
+	for _, sealed := range letters {
+		letter, err := OpenLetter(key, sealed)
+		if err != nil {
+			logrus.WithError(err).WithField("mailbox", mailbox).Warn("Dropped invalid letter")
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"peer":    letter.From,
+			"mailbox": mailbox,
+			"message": letter.Text,
+		}).Info("Received chat message from mailbox")
+		action := n.protocols.filterMessage(protocol.ID(ChatProtocol), letter.From, letter.Text)
+		if action.Drop {
+			logrus.WithField("peer", letter.From).Debug("Dropped filtered chat message")
+			continue
+		}
+		n.protocols.notifyMessage(protocol.ID(ChatProtocol), letter.From, action.Message)
+		delivered++
+	}
+	return delivered, nil
+}
+
+// collectMailPeriodically fetches our letters from the mailbox peers as
+// they connect and every mailboxPollInterval, until ctx is done
+func (n *Node) collectMailPeriodically(ctx context.Context, mailboxes []peer.ID) {
+	collect := func(p peer.ID) {
+		if _, err := n.CollectMail(ctx, p); err != nil {
+			logrus.WithError(err).WithField("mailbox", p).Debug("Failed to collect mail")
+		}
+	}
+	n.OnPeerConnected(func(p peer.ID) {
+		for _, mailbox := range mailboxes {
+			if p == mailbox {
+				go collect(p)
+			}
+		}
+	})
+
+	ticker := time.NewTicker(mailboxPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, mailbox := range mailboxes {
+			collect(mailbox)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailboxLetter(t *testing.T) {
+	sender, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	recipient, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	from, err := peer.IDFromPrivateKey(sender)
+	require.NoError(t, err)
+	to, err := peer.IDFromPrivateKey(recipient)
+	require.NoError(t, err)
+
+	letter := &MailboxLetter{ID: "1", From: from, To: to, Text: "see you tomorrow", Sent: time.Now().UnixNano()}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		sealed, err := SealLetter(sender, letter)
+		require.NoError(t, err)
+		assert.NotContains(t, string(sealed), letter.Text)
+
+		opened, err := OpenLetter(recipient, sealed)
+		require.NoError(t, err)
+		assert.Equal(t, letter, opened)
+
+		_, err = OpenLetter(sender, sealed)
+		assert.Error(t, err)
+	})
+
+	t.Run("ForgedSender", func(t *testing.T) {
+		forged := *letter
+		forged.From = to
+		sealed, err := SealLetter(sender, &forged)
+		require.NoError(t, err)
+
+		_, err = OpenLetter(recipient, sealed)
+		assert.ErrorContains(t, err, "signed by")
+	})
+}
+
+func TestMailbox(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	sender, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer sender.Close()
+	recipient, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer recipient.Close()
+
+	mailbox := NewMailbox(server, MailboxLimits{MaxPerPeer: 2, MaxPerSender: 10, MaxBytes: 1 << 20})
+	defer mailbox.Close()
+	require.NoError(t, connectNodes(ctx, sender, server))
+	require.NoError(t, connectNodes(ctx, recipient, server))
+
+	key := sender.Peerstore().PrivKey(sender.ID())
+	seal := func(text string) []byte {
+		sealed, err := SealLetter(key, &MailboxLetter{From: sender.ID(), To: recipient.ID(), Text: text})
+		require.NoError(t, err)
+		return sealed
+	}
+	expires := time.Now().Add(time.Hour)
+
+	require.NoError(t, PutLetter(ctx, sender, server.ID(), recipient.ID(), seal("first"), expires))
+	require.NoError(t, PutLetter(ctx, sender, server.ID(), recipient.ID(), seal("second"), expires))
+	assert.ErrorContains(t, PutLetter(ctx, sender, server.ID(), recipient.ID(), seal("third"), expires), "mailbox full")
+	assert.Equal(t, 2, mailbox.Pending(recipient.ID()))
+
+	t.Run("OnlyRecipientFetches", func(t *testing.T) {
+		letters, err := FetchLetters(ctx, sender, server.ID())
+		require.NoError(t, err)
+		assert.Empty(t, letters)
+		assert.Equal(t, 2, mailbox.Pending(recipient.ID()))
+	})
+
+	t.Run("Fetch", func(t *testing.T) {
+		letters, err := FetchLetters(ctx, recipient, server.ID())
+		require.NoError(t, err)
+		require.Len(t, letters, 2)
+		letter, err := OpenLetter(recipient.Peerstore().PrivKey(recipient.ID()), letters[0])
+		require.NoError(t, err)
+		assert.Equal(t, "first", letter.Text)
+		assert.Equal(t, sender.ID(), letter.From)
+
+		letters, err = FetchLetters(ctx, recipient, server.ID())
+		require.NoError(t, err)
+		assert.Empty(t, letters)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		err := PutLetter(ctx, sender, server.ID(), recipient.ID(), seal("late"), time.Now().Add(-time.Second))
+		assert.ErrorContains(t, err, "expired")
+		assert.Zero(t, mailbox.Pending(recipient.ID()))
+	})
+}
+
+func TestMailboxLimits(t *testing.T) {
+	m := &Mailbox{
+		limits:  MailboxLimits{MaxPerPeer: 2, MaxPerSender: 3, MaxBytes: 10},
+		letters: make(map[peer.ID][]storedLetter),
+		senders: make(map[peer.ID]int),
+	}
+	expires := time.Now().Add(time.Hour)
+
+	t.Run("PerSender", func(t *testing.T) {
+		// Made up recipients don't get a sender around its quota
+		for _, to := range []peer.ID{"a", "b", "c"} {
+			require.NoError(t, m.put("spammer", to, []byte("x"), expires))
+		}
+		assert.ErrorIs(t, m.put("spammer", "d", []byte("x"), expires), errMailboxFull)
+		assert.NoError(t, m.put("friend", "d", []byte("x"), expires))
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		assert.ErrorIs(t, m.put("friend", "e", []byte("1234567"), expires), errMailboxFull)
+		assert.NoError(t, m.put("friend", "e", []byte("123456"), expires))
+		assert.Equal(t, int64(10), m.bytes)
+	})
+
+	t.Run("Take", func(t *testing.T) {
+		assert.Len(t, m.take("a"), 1)
+		assert.NoError(t, m.put("spammer", "f", []byte("x"), expires))
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		for to := range m.letters {
+			for i := range m.letters[to] {
+				m.letters[to][i].expires = time.Now().Add(-time.Second)
+			}
+		}
+		m.sweep()
+		assert.Empty(t, m.letters)
+		assert.Empty(t, m.senders)
+		assert.Zero(t, m.bytes)
+	})
+}
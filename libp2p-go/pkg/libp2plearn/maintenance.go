@@ -0,0 +1,260 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// maintenanceDrainInterval is how often idle connections are closed while draining
+const maintenanceDrainInterval = time.Second
+
+// MaintenanceStatus is the state of maintenance mode
+type MaintenanceStatus struct {
+	Active     bool       `json:"active"`
+	Since      *time.Time `json:"since,omitempty"`
+	DrainUntil *time.Time `json:"drain_until,omitempty"`
+	Peers      int        `json:"peers"` // connected peers maintenance applies to
+}
+
+// Maintenance takes the node out of service ahead of a restart. While it is
+// active, new inbound connections and streams are refused. Connected peers
+// are told with a goodbye, and their connections are closed once idle, or
+// at the end of the drain period. Peers exempt from it, such as admin
+// peers, keep their connections.
+type Maintenance struct {
+	drain   time.Duration
+	host    host.Host
+	goodbye *Goodbye
+
+	mu     sync.Mutex
+	exempt func(peer.ID) bool
+	active bool
+	since  time.Time
+	until  time.Time
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMaintenance creates maintenance mode, draining connections over the
+// given period by default
+func NewMaintenance(drain time.Duration) *Maintenance {
+	return &Maintenance{drain: drain}
+}
+
+// Attach sets the host whose connections are drained and the goodbye
+// service peers are told with
+func (m *Maintenance) Attach(h host.Host, goodbye *Goodbye) {
+	m.mu.Lock()
+	m.host = h
+	m.goodbye = goodbye
+	m.mu.Unlock()
+}
+
+// SetExempt sets which peers maintenance doesn't apply to
+func (m *Maintenance) SetExempt(exempt func(peer.ID) bool) {
+	m.mu.Lock()
+	m.exempt = exempt
+	m.mu.Unlock()
+}
+
+// Enter starts maintenance, draining connections over the drain period, or
+// the default period if drain isn't positive
+func (m *Maintenance) Enter(drain time.Duration) (MaintenanceStatus, error) {
+	if drain <= 0 {
+		drain = m.drain
+	}
+
+	m.mu.Lock()
+	if m.host == nil {
+		m.mu.Unlock()
+		return MaintenanceStatus{}, fmt.Errorf("maintenance is not attached to a host")
+	}
+	if m.active {
+		m.mu.Unlock()
+		return MaintenanceStatus{}, fmt.Errorf("already in maintenance since %s", m.since.Format(time.RFC3339))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.active = true
+	m.since = time.Now()
+	m.until = m.since.Add(drain)
+	m.cancel = cancel
+	until := m.until
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run(ctx, drain, until)
+	}()
+
+	logrus.WithField("drain", drain).Warn("Entered maintenance, refusing new inbound connections and streams")
+	return m.Status(), nil
+}
+
+// Exit ends maintenance. Connections closed while draining stay closed.
+func (m *Maintenance) Exit() (MaintenanceStatus, error) {
+	m.mu.Lock()
+	if !m.active {
+		m.mu.Unlock()
+		return MaintenanceStatus{}, fmt.Errorf("not in maintenance")
+	}
+	m.active = false
+	m.cancel()
+	m.mu.Unlock()
+	m.wg.Wait()
+
+	logrus.Info("Exited maintenance, accepting connections again")
+	return m.Status(), nil
+}
+
+// Close stops draining
+func (m *Maintenance) Close() {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// Active reports whether maintenance is on
+func (m *Maintenance) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active
+}
+
+// Status reports whether maintenance is on, and how many peers it still
+// applies to
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := MaintenanceStatus{Active: m.active}
+	if !m.active {
+		return status
+	}
+	since, until := m.since, m.until
+	status.Since = &since
+	status.DrainUntil = &until
+	for _, p := range m.host.Network().Peers() {
+		if m.exempt == nil || !m.exempt(p) {
+			status.Peers++
+		}
+	}
+	return status
+}
+
+// refuses reports whether a new inbound connection or stream from the peer
+// is refused. It is safe to call on a nil Maintenance.
+func (m *Maintenance) refuses(p peer.ID) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active && (m.exempt == nil || !m.exempt(p))
+}
+
+// drained returns the peers maintenance applies to
+func (m *Maintenance) drained() []peer.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var peers []peer.ID
+	for _, p := range m.host.Network().Peers() {
+		if m.exempt == nil || !m.exempt(p) {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// Middleware resets streams accepted by a protocol handler during maintenance
+func (m *Maintenance) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		if m.refuses(s.Conn().RemotePeer()) {
+			logrus.WithFields(logrus.Fields{
+				"peer":     s.Conn().RemotePeer(),
+				"protocol": proto,
+			}).Debug("Refused stream during maintenance")
+			s.Reset()
+			return
+		}
+		next(s)
+	}
+}
+
+// run tells connected peers about the maintenance, then closes their
+// connections once idle, and the rest at the end of the drain period
+func (m *Maintenance) run(ctx context.Context, drain time.Duration, until time.Time) {
+	message := fmt.Sprintf("maintenance, closing within %s", drain)
+	var wg sync.WaitGroup
+	for _, p := range m.drained() {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := m.goodbye.Notify(ctx, p, GoodbyeMaintenance, message); err != nil {
+				logrus.WithError(err).WithField("peer", p).Debug("Failed to announce maintenance")
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	ticker := time.NewTicker(maintenanceDrainInterval)
+	defer ticker.Stop()
+	for {
+		final := !time.Now().Before(until)
+		closed := 0
+		for _, p := range m.drained() {
+			for _, c := range m.host.Network().ConnsToPeer(p) {
+				if !final && len(c.GetStreams()) > 0 {
+					continue
+				}
+				if err := c.Close(); err != nil {
+					logrus.WithError(err).WithField("peer", p).Debug("Failed to close connection")
+					continue
+				}
+				closed++
+			}
+		}
+		if closed > 0 {
+			logrus.WithFields(logrus.Fields{
+				"connections": closed,
+				"final":       final,
+			}).Info("Drained connections for maintenance")
+		}
+		if final {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnterMaintenance refuses new inbound connections and streams, tells
+// connected peers, and drains their connections over the drain period, or
+// maintenance_drain if drain isn't positive
+func (n *Node) EnterMaintenance(drain time.Duration) (MaintenanceStatus, error) {
+	return n.maintenance.Enter(drain)
+}
+
+// ExitMaintenance accepts connections and streams again
+func (n *Node) ExitMaintenance() (MaintenanceStatus, error) {
+	return n.maintenance.Exit()
+}
+
+// MaintenanceStatus reports whether the node is in maintenance
+func (n *Node) MaintenanceStatus() MaintenanceStatus {
+	return n.maintenance.Status()
+}
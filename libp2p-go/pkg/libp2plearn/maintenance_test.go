@@ -0,0 +1,122 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	operator, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer operator.Close()
+
+	cfg := testNodeConfig()
+	cfg.AdminPeers = []string{operator.ID().String()}
+	node, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node.Stop(context.Background())
+
+	idle, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer idle.Close()
+	idleGoodbye, err := NewGoodbye(idle)
+	require.NoError(t, err)
+	defer idleGoodbye.Close()
+	goodbyes, err := idle.EventBus().Subscribe(new(EvtPeerGoodbye))
+	require.NoError(t, err)
+	defer goodbyes.Close()
+
+	busy, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer busy.Close()
+
+	require.NoError(t, connectNodes(ctx, operator, node.Host()))
+	require.NoError(t, connectNodes(ctx, idle, node.Host()))
+	require.NoError(t, connectNodes(ctx, busy, node.Host()))
+
+	// An echo stream stays open until we close our side
+	s, err := busy.NewStream(ctx, node.Host().ID(), protocol.ID(EchoProtocol))
+	require.NoError(t, err)
+	defer s.Reset()
+
+	status, err := node.EnterMaintenance(2 * time.Second)
+	require.NoError(t, err)
+	assert.True(t, status.Active)
+	require.NotNil(t, status.DrainUntil)
+
+	_, err = node.EnterMaintenance(0)
+	assert.ErrorContains(t, err, "already in maintenance")
+
+	t.Run("Goodbye", func(t *testing.T) {
+		select {
+		case e := <-goodbyes.Out():
+			evt := e.(EvtPeerGoodbye)
+			assert.Equal(t, node.Host().ID(), evt.Peer)
+			assert.Equal(t, GoodbyeMaintenance, evt.Reason)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the maintenance goodbye")
+		}
+	})
+
+	t.Run("RefusesStreams", func(t *testing.T) {
+		_, err := NewProtocolHandler(busy).SendPing(ctx, node.Host().ID(), "hello")
+		assert.Error(t, err)
+	})
+
+	t.Run("Drains", func(t *testing.T) {
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return !isConnected(node.Host(), idle.ID())
+		}, 5*time.Second, 50*time.Millisecond))
+		// The busy connection is only closed at the end of the drain period
+		assert.True(t, isConnected(node.Host(), busy.ID()))
+
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return !isConnected(node.Host(), busy.ID())
+		}, 5*time.Second, 50*time.Millisecond))
+		// Admin peers stay connected to end maintenance
+		assert.True(t, isConnected(node.Host(), operator.ID()))
+	})
+
+	t.Run("RefusesConnections", func(t *testing.T) {
+		late, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer late.Close()
+		// The dialer may finish its handshake before the node refuses the
+		// connection, so it can look connected for a moment
+		connectNodes(ctx, late, node.Host())
+		assert.False(t, isConnected(node.Host(), late.ID()))
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return !isConnected(late, node.Host().ID())
+		}, 5*time.Second, 50*time.Millisecond))
+	})
+
+	t.Run("AdminExit", func(t *testing.T) {
+		result, err := SendAdminCommand(ctx, operator, node.Host().ID(), AdminCmdMaintenance, "status")
+		require.NoError(t, err)
+		var status MaintenanceStatus
+		require.NoError(t, json.Unmarshal(result, &status))
+		assert.True(t, status.Active)
+		assert.Zero(t, status.Peers)
+
+		_, err = SendAdminCommand(ctx, operator, node.Host().ID(), AdminCmdMaintenance, "enter", "soon")
+		assert.ErrorContains(t, err, "invalid drain period")
+
+		_, err = SendAdminCommand(ctx, operator, node.Host().ID(), AdminCmdMaintenance, "exit")
+		require.NoError(t, err)
+		assert.False(t, node.MaintenanceStatus().Active)
+
+		late, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer late.Close()
+		assert.NoError(t, connectNodes(ctx, late, node.Host()))
+	})
+}
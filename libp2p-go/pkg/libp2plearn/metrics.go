@@ -0,0 +1,212 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// Metrics exporters
+const (
+	MetricsPrometheus = "prometheus" // served on metrics_addr for scraping
+	MetricsStatsD     = "statsd"     // pushed to statsd_addr over UDP
+	MetricsOTLP       = "otlp"       // pushed to otlp_endpoint over OTLP/HTTP
+)
+
+// metricsExportTimeout bounds one push to an exporter
+const metricsExportTimeout = 10 * time.Second
+
+// MetricsExporter pushes metrics to a telemetry backend. Export gets every
+// metric gathered from the registry, with counters as running totals.
+type MetricsExporter interface {
+	Export(ctx context.Context, families []*dto.MetricFamily) error
+	Close() error
+}
+
+// Metrics exports the metrics registered with Prometheus, ours and libp2p's,
+// to the configured telemetry backends. Prometheus scrapes them over HTTP;
+// push exporters get them every interval and once more when the node stops.
+type Metrics struct {
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	server   *http.Server
+	listener net.Listener
+
+	mu        sync.Mutex
+	exporters map[string]MetricsExporter
+}
+
+// NewMetrics creates the exporters selected in the configuration, reading
+// metrics from gatherer. instance identifies the node to push backends.
+func NewMetrics(cfg *Config, gatherer prometheus.Gatherer, instance string) (*Metrics, error) {
+	m := &Metrics{
+		gatherer:  gatherer,
+		interval:  time.Duration(cfg.MetricsInterval),
+		exporters: make(map[string]MetricsExporter),
+	}
+	for _, name := range cfg.MetricsExporters {
+		var err error
+		switch name {
+		case MetricsPrometheus:
+			err = m.listen(cfg.MetricsAddr)
+		case MetricsStatsD:
+			var statsd *StatsDExporter
+			if statsd, err = NewStatsDExporter(cfg.StatsDAddr); err == nil {
+				m.AddExporter(name, statsd)
+			}
+		case MetricsOTLP:
+			m.AddExporter(name, NewOTLPExporter(cfg.OTLPEndpoint, cfg.OTLPHeaders, instance))
+		default:
+			err = fmt.Errorf("unknown metrics exporter %q", name)
+		}
+		if err != nil {
+			m.Close(context.Background())
+			return nil, fmt.Errorf("failed to set up %s metrics: %w", name, err)
+		}
+	}
+	return m, nil
+}
+
+// AddExporter adds a push exporter, replacing any with the same name
+func (m *Metrics) AddExporter(name string, exporter MetricsExporter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.exporters[name]; ok {
+		old.Close()
+	}
+	m.exporters[name] = exporter
+}
+
+// Addr returns the address Prometheus scrapes, or nil if it isn't served
+func (m *Metrics) Addr() net.Addr {
+	if m.listener == nil {
+		return nil
+	}
+	return m.listener.Addr()
+}
+
+// listen serves the metrics in the Prometheus text format on addr
+func (m *Metrics) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{}))
+	m.listener = listener
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := m.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).WithField("addr", addr).Error("Metrics server failed")
+		}
+	}()
+	logrus.WithField("addr", listener.Addr()).Info("Serving Prometheus metrics")
+	return nil
+}
+
+// Run pushes the metrics every interval until ctx is done, then once more
+func (m *Metrics) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush what changed since the last push
+			flushCtx, cancel := context.WithTimeout(context.Background(), metricsExportTimeout)
+			m.Push(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			m.Push(ctx)
+		}
+	}
+}
+
+// Push gathers the metrics and hands them to every push exporter
+func (m *Metrics) Push(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.exporters) == 0 {
+		return
+	}
+
+	families, err := m.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could along with the error
+		logrus.WithError(err).Warn("Failed to gather some metrics")
+	}
+	for name, exporter := range m.exporters {
+		exportCtx, cancel := context.WithTimeout(ctx, metricsExportTimeout)
+		if err := exporter.Export(exportCtx, families); err != nil {
+			logrus.WithError(err).WithField("exporter", name).Warn("Failed to export metrics")
+		}
+		cancel()
+	}
+}
+
+// Close stops serving metrics and closes the exporters
+func (m *Metrics) Close(ctx context.Context) error {
+	var errs []error
+	if m.server != nil {
+		if err := m.server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, exporter := range m.exporters {
+		if err := exporter.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// metricValue is one sample of a metric family, flattened for push exporters
+type metricValue struct {
+	name   string
+	labels []*dto.LabelPair
+	value  float64
+	kind   dto.MetricType // COUNTER or GAUGE
+}
+
+// flatten turns the families into counters and gauges. Histograms and
+// summaries become their _sum and _count counters.
+func flatten(families []*dto.MetricFamily) []metricValue {
+	var values []metricValue
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			labels := metric.GetLabel()
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				values = append(values, metricValue{name, labels, metric.GetCounter().GetValue(), dto.MetricType_COUNTER})
+			case dto.MetricType_GAUGE:
+				values = append(values, metricValue{name, labels, metric.GetGauge().GetValue(), dto.MetricType_GAUGE})
+			case dto.MetricType_UNTYPED:
+				values = append(values, metricValue{name, labels, metric.GetUntyped().GetValue(), dto.MetricType_GAUGE})
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := metric.GetHistogram()
+				values = append(values,
+					metricValue{name + "_sum", labels, h.GetSampleSum(), dto.MetricType_COUNTER},
+					metricValue{name + "_count", labels, float64(h.GetSampleCount()), dto.MetricType_COUNTER})
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				values = append(values,
+					metricValue{name + "_sum", labels, s.GetSampleSum(), dto.MetricType_COUNTER},
+					metricValue{name + "_count", labels, float64(s.GetSampleCount()), dto.MetricType_COUNTER})
+			}
+		}
+	}
+	return values
+}
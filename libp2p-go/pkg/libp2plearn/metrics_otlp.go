@@ -0,0 +1,250 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OTLP aggregation temporality of cumulative sums and histograms
+const otlpCumulative = 2
+
+// OTLPExporter pushes metrics to an OpenTelemetry collector over OTLP/HTTP
+// with the JSON encoding, so no gRPC or protobuf stack is needed. Counters
+// become cumulative monotonic sums; histograms keep their buckets.
+type OTLPExporter struct {
+	endpoint string
+	headers  map[string]string
+	instance string
+	started  time.Time
+	client   *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint, usually a
+// collector's /v1/metrics. headers are added to every request, e.g. for
+// authentication; instance becomes the service.instance.id attribute.
+func NewOTLPExporter(endpoint string, headers map[string]string, instance string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		instance: instance,
+		started:  time.Now(),
+		client:   &http.Client{Timeout: metricsExportTimeout},
+	}
+}
+
+// OTLP/HTTP JSON messages. 64-bit integers are encoded as strings.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpMetric struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Sum         *otlpSum       `json:"sum,omitempty"`
+		Gauge       *otlpGauge     `json:"gauge,omitempty"`
+		Histogram   *otlpHistogram `json:"histogram,omitempty"`
+		Summary     *otlpSummary   `json:"summary,omitempty"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	}
+	otlpHistogram struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	}
+	otlpSummary struct {
+		DataPoints []otlpSummaryPoint `json:"dataPoints"`
+	}
+	otlpPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+	}
+	otlpNumberPoint struct {
+		otlpPoint
+		AsDouble float64 `json:"asDouble"`
+	}
+	otlpHistogramPoint struct {
+		otlpPoint
+		Count          string    `json:"count"`
+		Sum            float64   `json:"sum"`
+		BucketCounts   []string  `json:"bucketCounts"`
+		ExplicitBounds []float64 `json:"explicitBounds"`
+	}
+	otlpSummaryPoint struct {
+		otlpPoint
+		Count          string         `json:"count"`
+		Sum            float64        `json:"sum"`
+		QuantileValues []otlpQuantile `json:"quantileValues"`
+	}
+	otlpQuantile struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	}
+)
+
+// Export posts the metrics as one ExportMetricsServiceRequest
+func (e *OTLPExporter) Export(ctx context.Context, families []*dto.MetricFamily) error {
+	body, err := json.Marshal(e.request(families, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("OTLP endpoint returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close does nothing; requests don't outlive Export
+func (e *OTLPExporter) Close() error {
+	return nil
+}
+
+// request converts the families into OTLP metrics
+func (e *OTLPExporter) request(families []*dto.MetricFamily, now time.Time) otlpRequest {
+	start := strconv.FormatInt(e.started.UnixNano(), 10)
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+
+	var metrics []otlpMetric
+	for _, family := range families {
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+		for _, m := range family.GetMetric() {
+			point := otlpPoint{Attributes: otlpLabels(m.GetLabel()), StartTimeUnixNano: start, TimeUnixNano: ts}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if metric.Sum == nil {
+					metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+				}
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberPoint{point, m.GetCounter().GetValue()})
+			case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				if metric.Gauge == nil {
+					metric.Gauge = &otlpGauge{}
+				}
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberPoint{point, value})
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				if metric.Histogram == nil {
+					metric.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+				}
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, otlpHistogramPointOf(point, m.GetHistogram()))
+			case dto.MetricType_SUMMARY:
+				if metric.Summary == nil {
+					metric.Summary = &otlpSummary{}
+				}
+				s := m.GetSummary()
+				sp := otlpSummaryPoint{
+					otlpPoint: point,
+					Count:     strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:       s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					sp.QuantileValues = append(sp.QuantileValues, otlpQuantile{q.GetQuantile(), q.GetValue()})
+				}
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, sp)
+			}
+		}
+		if metric.Sum != nil || metric.Gauge != nil || metric.Histogram != nil || metric.Summary != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	resource := []otlpAttribute{otlpAttr("service.name", "libp2p-learn")}
+	if e.instance != "" {
+		resource = append(resource, otlpAttr("service.instance.id", e.instance))
+	}
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "libp2p-learn"},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// otlpHistogramPointOf converts Prometheus' cumulative buckets into OTLP's
+// per-bucket counts, with the last count for values above every bound
+func otlpHistogramPointOf(point otlpPoint, h *dto.Histogram) otlpHistogramPoint {
+	hp := otlpHistogramPoint{
+		otlpPoint: point,
+		Count:     strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:       h.GetSampleSum(),
+	}
+	var below uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			break
+		}
+		hp.ExplicitBounds = append(hp.ExplicitBounds, b.GetUpperBound())
+		hp.BucketCounts = append(hp.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-below, 10))
+		below = b.GetCumulativeCount()
+	}
+	hp.BucketCounts = append(hp.BucketCounts, strconv.FormatUint(h.GetSampleCount()-below, 10))
+	return hp
+}
+
+// otlpLabels converts Prometheus labels into OTLP attributes
+func otlpLabels(labels []*dto.LabelPair) []otlpAttribute {
+	var attrs []otlpAttribute
+	for _, l := range labels {
+		attrs = append(attrs, otlpAttr(l.GetName(), l.GetValue()))
+	}
+	return attrs
+}
+
+func otlpAttr(key, value string) otlpAttribute {
+	attr := otlpAttribute{Key: key}
+	attr.Value.StringValue = value
+	return attr
+}
@@ -0,0 +1,100 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdPacketSize keeps datagrams under a typical MTU
+const statsdPacketSize = 1432
+
+// StatsDExporter pushes metrics to a StatsD server over UDP. Counters are
+// sent as the increase since the last push, gauges as their value, and
+// labels as DogStatsD tags, which Datadog, Telegraf and statsd_exporter read.
+type StatsDExporter struct {
+	conn net.Conn
+	last map[string]float64 // counter totals at the last push
+}
+
+// NewStatsDExporter creates an exporter pushing to the StatsD server at addr
+func NewStatsDExporter(addr string) (*StatsDExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd: %w", err)
+	}
+	return &StatsDExporter{conn: conn, last: make(map[string]float64)}, nil
+}
+
+// Export sends every metric that changed, packing lines into datagrams
+func (e *StatsDExporter) Export(ctx context.Context, families []*dto.MetricFamily) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+
+	for _, m := range flatten(families) {
+		tags := statsdTags(m.labels)
+		value, kind := m.value, "g"
+		if m.kind == dto.MetricType_COUNTER {
+			key := m.name + tags
+			last, seen := e.last[key]
+			e.last[key] = m.value
+			if seen && m.value >= last {
+				value -= last
+			}
+			// A counter that went down was reset, so all of it is new
+			if value == 0 {
+				continue
+			}
+			kind = "c"
+		}
+
+		line := m.name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + tags
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			if err := flush(); err != nil {
+				return fmt.Errorf("failed to send metrics: %w", err)
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("failed to send metrics: %w", err)
+	}
+	return nil
+}
+
+// Close closes the UDP socket
+func (e *StatsDExporter) Close() error {
+	return e.conn.Close()
+}
+
+// statsdTags formats labels as a DogStatsD tag suffix, sorted by name
+func statsdTags(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(labels))
+	for _, l := range labels {
+		tags = append(tags, l.GetName()+":"+statsdEscape(l.GetValue()))
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
+
+// statsdEscape replaces the characters that delimit StatsD lines and tags
+var statsdEscape = strings.NewReplacer("\n", "_", "|", "_", ",", "_", "#", "_").Replace
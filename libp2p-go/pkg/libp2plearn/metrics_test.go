@@ -0,0 +1,144 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry returns a registry with a counter, a gauge and a histogram
+func testRegistry() (*prometheus.Registry, *prometheus.CounterVec, prometheus.Histogram) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_streams_total", Help: "Streams"}, []string{"protocol"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_peers", Help: "Peers"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Help: "Latency", Buckets: []float64{0.1, 1}})
+	reg.MustRegister(counter, gauge, histogram)
+
+	counter.WithLabelValues("/chat").Add(3)
+	gauge.Set(7)
+	histogram.Observe(0.0625)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	return reg, counter, histogram
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Prometheus", func(t *testing.T) {
+		reg, _, _ := testRegistry()
+		cfg := DefaultConfig()
+		cfg.MetricsExporters = []string{MetricsPrometheus}
+		cfg.MetricsAddr = "127.0.0.1:0"
+		m, err := NewMetrics(cfg, reg, "")
+		require.NoError(t, err)
+		defer m.Close(ctx)
+
+		resp, err := http.Get("http://" + m.Addr().String() + "/metrics")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), `test_streams_total{protocol="/chat"} 3`)
+		assert.Contains(t, string(body), "test_peers 7")
+	})
+
+	t.Run("StatsD", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		read := func() []string {
+			buf := make([]byte, statsdPacketSize)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			require.NoError(t, err)
+			return strings.Split(string(buf[:n]), "\n")
+		}
+
+		reg, counter, _ := testRegistry()
+		cfg := DefaultConfig()
+		cfg.MetricsExporters = []string{MetricsStatsD}
+		cfg.StatsDAddr = conn.LocalAddr().String()
+		m, err := NewMetrics(cfg, reg, "")
+		require.NoError(t, err)
+		defer m.Close(ctx)
+
+		m.Push(ctx)
+		assert.ElementsMatch(t, []string{
+			"test_latency_seconds_sum:5.5625|c",
+			"test_latency_seconds_count:3|c",
+			"test_peers:7|g",
+			"test_streams_total:3|c|#protocol:/chat",
+		}, read())
+
+		// Counters are sent as the increase since the last push
+		counter.WithLabelValues("/chat").Add(2)
+		m.Push(ctx)
+		assert.ElementsMatch(t, []string{
+			"test_peers:7|g",
+			"test_streams_total:2|c|#protocol:/chat",
+		}, read())
+	})
+
+	t.Run("OTLP", func(t *testing.T) {
+		requests := make(chan otlpRequest, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/metrics" || r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			var req otlpRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			requests <- req
+		}))
+		defer srv.Close()
+
+		reg, _, _ := testRegistry()
+		exporter := NewOTLPExporter(srv.URL+"/v1/metrics", map[string]string{"Authorization": "Bearer token"}, "node1")
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		require.NoError(t, exporter.Export(ctx, families))
+
+		req := <-requests
+		require.Len(t, req.ResourceMetrics, 1)
+		assert.Contains(t, req.ResourceMetrics[0].Resource.Attributes, otlpAttr("service.instance.id", "node1"))
+		metrics := make(map[string]otlpMetric)
+		for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			metrics[m.Name] = m
+		}
+
+		sum := metrics["test_streams_total"].Sum
+		require.NotNil(t, sum)
+		assert.True(t, sum.IsMonotonic)
+		assert.Equal(t, 3.0, sum.DataPoints[0].AsDouble)
+		assert.Equal(t, []otlpAttribute{otlpAttr("protocol", "/chat")}, sum.DataPoints[0].Attributes)
+		require.NotNil(t, metrics["test_peers"].Gauge)
+		assert.Equal(t, 7.0, metrics["test_peers"].Gauge.DataPoints[0].AsDouble)
+		histogram := metrics["test_latency_seconds"].Histogram
+		require.NotNil(t, histogram)
+		assert.Equal(t, "3", histogram.DataPoints[0].Count)
+		assert.Equal(t, []float64{0.1, 1}, histogram.DataPoints[0].ExplicitBounds)
+		assert.Equal(t, []string{"1", "1", "1"}, histogram.DataPoints[0].BucketCounts)
+
+		err = NewOTLPExporter(srv.URL+"/v1/metrics", nil, "").Export(ctx, families)
+		assert.ErrorContains(t, err, "401")
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.MetricsExporters = []string{"graphite"}
+		assert.Error(t, cfg.Validate())
+		cfg.MetricsExporters = []string{MetricsOTLP}
+		cfg.OTLPEndpoint = ""
+		assert.ErrorContains(t, cfg.Validate(), "otlp_endpoint is required")
+	})
+}
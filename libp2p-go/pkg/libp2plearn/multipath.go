@@ -0,0 +1,556 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	msmux "github.com/multiformats/go-multistream"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SchedulePolicyRoundRobin spreads new streams evenly across paths
+	SchedulePolicyRoundRobin = "round-robin"
+
+	// SchedulePolicyLatency opens new streams on the path with the lowest measured latency
+	SchedulePolicyLatency = "latency"
+
+	// SchedulePolicyPinned opens streams on the transport pinned for their protocol
+	SchedulePolicyPinned = "pinned"
+
+	// multipathRefreshInterval is how often missing paths to watched peers are re-dialed
+	multipathRefreshInterval = 30 * time.Second
+)
+
+// streamPath is one connection a new stream can be scheduled on
+type streamPath struct {
+	id        string
+	transport string
+	newStream func(context.Context) (network.Stream, error)
+}
+
+// Multipath keeps connections over several transports to watched peers and
+// schedules new streams across them
+type Multipath struct {
+	host       host.Host
+	swarm      *swarm.Swarm
+	policy     string
+	transports []string
+	pins       map[protocol.ID]string
+	timeout    time.Duration
+
+	mu       sync.Mutex
+	peers    map[peer.ID]bool
+	extra    map[peer.ID][]*pathConn
+	rtt      map[string]time.Duration
+	cursor   map[peer.ID]int
+	ensuring map[peer.ID]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewMultipath creates a stream scheduler that keeps a connection per
+// transport to watched peers. pins maps protocol IDs to transport names.
+func NewMultipath(h host.Host, policy string, transports []string, pins map[string]string) (*Multipath, error) {
+	sw, ok := h.Network().(*swarm.Swarm)
+	if !ok {
+		return nil, fmt.Errorf("multipath requires a swarm network")
+	}
+
+	pinned := make(map[protocol.ID]string, len(pins))
+	for proto, tpt := range pins {
+		pinned[protocol.ID(proto)] = tpt
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Multipath{
+		host:       h,
+		swarm:      sw,
+		policy:     policy,
+		transports: transports,
+		pins:       pinned,
+		timeout:    network.DialPeerTimeout,
+		peers:      make(map[peer.ID]bool),
+		extra:      make(map[peer.ID][]*pathConn),
+		rtt:        make(map[string]time.Duration),
+		cursor:     make(map[peer.ID]int),
+		ensuring:   make(map[peer.ID]bool),
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// SetDialTimeout bounds how long dialing an extra connection may take
+func (m *Multipath) SetDialTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// Watch keeps a connection over every configured transport to the peer
+func (m *Multipath) Watch(p peer.ID) {
+	m.mu.Lock()
+	m.peers[p] = true
+	m.mu.Unlock()
+
+	if m.host.Network().Connectedness(p) == network.Connected {
+		go m.ensure(p)
+	}
+}
+
+// Start opens missing paths whenever a watched peer connects and refreshes them periodically
+func (m *Multipath) Start() {
+	m.host.Network().Notify(m)
+
+	go func() {
+		ticker := time.NewTicker(multipathRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.mu.Lock()
+				peers := make([]peer.ID, 0, len(m.peers))
+				for p := range m.peers {
+					peers = append(peers, p)
+				}
+				m.mu.Unlock()
+
+				for _, p := range peers {
+					if m.host.Network().Connectedness(p) == network.Connected {
+						m.ensure(p)
+					}
+				}
+			}
+		}
+	}()
+
+	logrus.WithFields(logrus.Fields{
+		"policy":     m.policy,
+		"transports": m.transports,
+	}).Info("Multipath scheduling started")
+}
+
+// Close closes the extra connections
+func (m *Multipath) Close() error {
+	m.host.Network().StopNotify(m)
+	m.cancel()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p, conns := range m.extra {
+		for _, pc := range conns {
+			pc.Close()
+		}
+		delete(m.extra, p)
+	}
+	return nil
+}
+
+// NewStream opens a stream on the path chosen by the scheduling policy,
+// falling back to the remaining paths if it fails
+func (m *Multipath) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("no protocol given")
+	}
+
+	paths := m.paths(p)
+	if len(paths) == 0 {
+		if err := m.host.Connect(ctx, m.host.Peerstore().PeerInfo(p)); err != nil {
+			return nil, fmt.Errorf("failed to connect: %w", err)
+		}
+		paths = m.paths(p)
+	}
+
+	var lastErr error
+	for _, path := range m.order(p, pids[0], paths) {
+		s, err := m.openOn(ctx, path, pids)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"peer":      p,
+				"transport": path.transport,
+			}).Debug("Failed to open stream on path")
+			lastErr = err
+			continue
+		}
+		return s, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no usable connection")
+	}
+	return nil, fmt.Errorf("failed to open stream to %s: %w", p, lastErr)
+}
+
+// openOn opens a stream on the path and negotiates the protocol, recording the round trip
+func (m *Multipath) openOn(ctx context.Context, path streamPath, pids []protocol.ID) (network.Stream, error) {
+	s, err := path.newStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	start := time.Now()
+	selected, err := msmux.SelectOneOf(pids, s)
+	if err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("failed to negotiate protocol: %w", err)
+	}
+	m.observe(path.id, time.Since(start))
+
+	s.SetDeadline(time.Time{})
+	if err := s.SetProtocol(selected); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("failed to set protocol: %w", err)
+	}
+	return s, nil
+}
+
+// observe updates the smoothed round trip time of a path
+func (m *Multipath) observe(id string, rtt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prev, ok := m.rtt[id]; ok {
+		rtt = (prev*7 + rtt) / 8
+	}
+	m.rtt[id] = rtt
+}
+
+// paths returns the open connections to a peer, both swarm-managed and extra
+func (m *Multipath) paths(p peer.ID) []streamPath {
+	var paths []streamPath
+	for _, c := range m.host.Network().ConnsToPeer(p) {
+		if c.IsClosed() || c.Stat().Limited {
+			continue
+		}
+		paths = append(paths, streamPath{
+			id:        c.ID(),
+			transport: transportName(c.RemoteMultiaddr()),
+			newStream: c.NewStream,
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pc := range m.extra[p] {
+		if pc.IsClosed() {
+			continue
+		}
+		paths = append(paths, streamPath{
+			id:        pc.ID(),
+			transport: transportName(pc.RemoteMultiaddr()),
+			newStream: pc.NewStream,
+		})
+	}
+	return paths
+}
+
+// order sorts the paths by preference according to the scheduling policy
+func (m *Multipath) order(p peer.ID, proto protocol.ID, paths []streamPath) []streamPath {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.policy {
+	case SchedulePolicyPinned:
+		if pinned, ok := m.pins[proto]; ok {
+			sort.SliceStable(paths, func(i, j int) bool {
+				return paths[i].transport == pinned && paths[j].transport != pinned
+			})
+		}
+	case SchedulePolicyLatency:
+		// Paths without a measurement sort first so they get probed
+		sort.SliceStable(paths, func(i, j int) bool {
+			return m.rtt[paths[i].id] < m.rtt[paths[j].id]
+		})
+	default:
+		if len(paths) > 0 {
+			next := m.cursor[p] % len(paths)
+			m.cursor[p] = next + 1
+			rotated := make([]streamPath, 0, len(paths))
+			paths = append(append(rotated, paths[next:]...), paths[:next]...)
+		}
+	}
+	return paths
+}
+
+// ensure dials the configured transports that have no open path to the peer yet
+func (m *Multipath) ensure(p peer.ID) {
+	m.mu.Lock()
+	if m.ensuring[p] {
+		m.mu.Unlock()
+		return
+	}
+	m.ensuring[p] = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.ensuring, p)
+		m.mu.Unlock()
+	}()
+
+	have := make(map[string]bool)
+	for _, path := range m.paths(p) {
+		have[path.transport] = true
+	}
+
+	for _, tpt := range m.transports {
+		if have[tpt] {
+			continue
+		}
+
+		addr := m.addrForTransport(p, tpt)
+		if addr == nil {
+			continue
+		}
+
+		if err := m.dialPath(p, addr); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"peer":      p,
+				"transport": tpt,
+			}).Debug("Failed to open additional path")
+		}
+	}
+}
+
+// addrForTransport returns a known address of the peer using the transport
+func (m *Multipath) addrForTransport(p peer.ID, tpt string) multiaddr.Multiaddr {
+	for _, addr := range m.host.Peerstore().Addrs(p) {
+		if transportName(addr) == tpt && m.swarm.CanDial(p, addr) {
+			return addr
+		}
+	}
+	return nil
+}
+
+// dialPath opens an extra connection directly on the transport, bypassing
+// the swarm which would otherwise reuse the existing connection
+func (m *Multipath) dialPath(p peer.ID, addr multiaddr.Multiaddr) error {
+	tpt := m.swarm.TransportForDialing(addr)
+	if tpt == nil {
+		return fmt.Errorf("no transport for %s", addr)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.timeout)
+	defer cancel()
+
+	cc, err := tpt.Dial(ctx, addr, p)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+
+	pc := newPathConn(cc)
+	m.mu.Lock()
+	m.extra[p] = append(m.extra[p], pc)
+	m.mu.Unlock()
+
+	go m.acceptStreams(p, pc)
+
+	logrus.WithFields(logrus.Fields{
+		"peer":      p,
+		"addr":      addr,
+		"transport": transportName(addr),
+	}).Info("Opened additional path")
+	return nil
+}
+
+// acceptStreams serves streams the remote opens on an extra connection and
+// forgets the connection once it closes
+func (m *Multipath) acceptStreams(p peer.ID, pc *pathConn) {
+	defer m.removePath(p, pc)
+
+	for {
+		ms, err := pc.AcceptStream()
+		if err != nil {
+			return
+		}
+
+		s := pc.track(ms, network.DirInbound)
+		go func() {
+			proto, handle, err := m.host.Mux().Negotiate(s)
+			if err != nil {
+				s.ResetWithError(network.StreamProtocolNegotiationFailed)
+				return
+			}
+			s.SetProtocol(proto)
+			handle(proto, s)
+		}()
+	}
+}
+
+// removePath drops a closed extra connection
+func (m *Multipath) removePath(p peer.ID, pc *pathConn) {
+	pc.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conns := m.extra[p]
+	for i, c := range conns {
+		if c == pc {
+			m.extra[p] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(m.extra[p]) == 0 {
+		delete(m.extra, p)
+	}
+	delete(m.rtt, pc.ID())
+}
+
+// Connected opens the missing paths when a watched peer connects
+func (m *Multipath) Connected(n network.Network, c network.Conn) {
+	m.mu.Lock()
+	watched := m.peers[c.RemotePeer()]
+	m.mu.Unlock()
+
+	if watched {
+		go m.ensure(c.RemotePeer())
+	}
+}
+
+func (m *Multipath) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (m *Multipath) ListenClose(network.Network, multiaddr.Multiaddr) {}
+func (m *Multipath) Disconnected(network.Network, network.Conn)       {}
+
+var pathConnCounter atomic.Int64
+
+// pathConn is a connection opened outside the swarm, adapted to network.Conn
+type pathConn struct {
+	transport.CapableConn
+	id     string
+	opened time.Time
+
+	mu      sync.Mutex
+	streams map[*pathStream]struct{}
+}
+
+func newPathConn(cc transport.CapableConn) *pathConn {
+	return &pathConn{
+		CapableConn: cc,
+		id:          fmt.Sprintf("multipath-%d", pathConnCounter.Add(1)),
+		opened:      time.Now(),
+		streams:     make(map[*pathStream]struct{}),
+	}
+}
+
+func (c *pathConn) ID() string {
+	return c.id
+}
+
+func (c *pathConn) NewStream(ctx context.Context) (network.Stream, error) {
+	ms, err := c.OpenStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.track(ms, network.DirOutbound), nil
+}
+
+func (c *pathConn) GetStreams() []network.Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	streams := make([]network.Stream, 0, len(c.streams))
+	for s := range c.streams {
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+func (c *pathConn) Stat() network.ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return network.ConnStats{
+		Stats:      network.Stats{Direction: network.DirOutbound, Opened: c.opened},
+		NumStreams: len(c.streams),
+	}
+}
+
+// track wraps a muxed stream and registers it with the connection
+func (c *pathConn) track(ms network.MuxedStream, dir network.Direction) *pathStream {
+	s := &pathStream{
+		MuxedStream: ms,
+		conn:        c,
+		id:          fmt.Sprintf("%s-%d", c.id, pathConnCounter.Add(1)),
+		stat:        network.Stats{Direction: dir, Opened: time.Now()},
+	}
+
+	c.mu.Lock()
+	c.streams[s] = struct{}{}
+	c.mu.Unlock()
+	return s
+}
+
+func (c *pathConn) forget(s *pathStream) {
+	c.mu.Lock()
+	delete(c.streams, s)
+	c.mu.Unlock()
+}
+
+// pathStream is a stream on a pathConn, adapted to network.Stream
+type pathStream struct {
+	network.MuxedStream
+	conn *pathConn
+	id   string
+	stat network.Stats
+
+	proto atomic.Value
+}
+
+func (s *pathStream) ID() string {
+	return s.id
+}
+
+func (s *pathStream) Protocol() protocol.ID {
+	proto, _ := s.proto.Load().(protocol.ID)
+	return proto
+}
+
+func (s *pathStream) SetProtocol(id protocol.ID) error {
+	s.proto.Store(id)
+	return nil
+}
+
+func (s *pathStream) Stat() network.Stats {
+	return s.stat
+}
+
+func (s *pathStream) Conn() network.Conn {
+	return s.conn
+}
+
+func (s *pathStream) Scope() network.StreamScope {
+	return &network.NullScope{}
+}
+
+func (s *pathStream) Close() error {
+	s.conn.forget(s)
+	return s.MuxedStream.Close()
+}
+
+func (s *pathStream) Reset() error {
+	s.conn.forget(s)
+	return s.MuxedStream.Reset()
+}
+
+func (s *pathStream) ResetWithError(errCode network.StreamErrorCode) error {
+	s.conn.forget(s)
+	return s.MuxedStream.ResetWithError(errCode)
+}
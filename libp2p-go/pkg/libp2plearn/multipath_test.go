@@ -0,0 +1,109 @@
+package libp2plearn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipath(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	NewProtocolHandler(server).SetupProtocols()
+
+	multipath, err := NewMultipath(client, SchedulePolicyRoundRobin, []string{"quic", "tcp"}, nil)
+	require.NoError(t, err)
+	defer multipath.Close()
+	multipath.Start()
+
+	err = connectNodes(ctx, client, server)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, client, server, 10*time.Second)
+	require.NoError(t, err)
+
+	multipath.Watch(server.ID())
+
+	t.Run("OpensPathPerTransport", func(t *testing.T) {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for len(multipath.paths(server.ID())) < 2 {
+			select {
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for second path")
+			case <-ticker.C:
+			}
+		}
+
+		transports := make(map[string]bool)
+		for _, path := range multipath.paths(server.ID()) {
+			transports[path.transport] = true
+		}
+		assert.True(t, transports["quic"], "Should have a QUIC path")
+		assert.True(t, transports["tcp"], "Should have a TCP path")
+	})
+
+	t.Run("RoundRobinAcrossPaths", func(t *testing.T) {
+		conns := make(map[string]bool)
+		for i := 0; i < 2; i++ {
+			s, err := multipath.NewStream(ctx, server.ID(), protocol.ID(EchoProtocol))
+			require.NoError(t, err)
+
+			_, err = s.Write([]byte("hello"))
+			require.NoError(t, err)
+			s.CloseWrite()
+
+			response, err := io.ReadAll(s)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(response))
+
+			conns[s.Conn().ID()] = true
+			s.Close()
+		}
+		assert.Len(t, conns, 2, "Streams should be spread across both paths")
+	})
+
+	t.Run("ProtocolHandlerUsesScheduler", func(t *testing.T) {
+		handler := NewProtocolHandler(client)
+		handler.SetStreamOpener(multipath)
+
+		response, err := handler.SendPing(ctx, server.ID(), "multipath")
+		require.NoError(t, err)
+		assert.Equal(t, "pong: multipath", response)
+	})
+}
+
+func TestMultipathPinnedOrder(t *testing.T) {
+	m := &Multipath{
+		policy: SchedulePolicyPinned,
+		pins:   map[protocol.ID]string{protocol.ID(EchoProtocol): "tcp"},
+	}
+
+	paths := func() []streamPath {
+		return []streamPath{
+			{id: "a", transport: "quic"},
+			{id: "b", transport: "tcp"},
+		}
+	}
+
+	ordered := m.order("", protocol.ID(EchoProtocol), paths())
+	assert.Equal(t, "tcp", ordered[0].transport, "Pinned transport should be tried first")
+
+	ordered = m.order("", protocol.ID(PingProtocol), paths())
+	assert.Equal(t, "quic", ordered[0].transport, "Unpinned protocols keep the default order")
+}
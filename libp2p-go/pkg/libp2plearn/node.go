@@ -0,0 +1,252 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+func createNode(ctx context.Context, port int, enableRelay bool) (host.Host, error) {
+	return createNodeWithOptions(ctx, port, enableRelay, true) // Enable WebSocket by default
+}
+
+func createNodeWithOptions(ctx context.Context, port int, enableRelay bool, enableWS bool) (host.Host, error) {
+	cfg := DefaultConfig()
+	cfg.ListenPort = port
+	cfg.EnableRelay = enableRelay
+	cfg.EnableWebSocket = enableWS
+	return createNodeFromConfig(ctx, cfg)
+}
+
+// createNodeFromConfig creates a node from the configuration, applying any extra libp2p options last
+func createNodeFromConfig(ctx context.Context, cfg *Config, extraOpts ...libp2p.Option) (host.Host, error) {
+	h, err := newHost(cfg, nil, extraOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up routing (DHT)
+	kademliaDHT, err := setupRouting(ctx, h)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to setup routing: %w", err)
+	}
+
+	// Open connections to pinned and nearby peers in the background
+	if cfg.EnablePrewarm {
+		go prewarmConnections(ctx, h, kademliaDHT, cfg, nil)
+	}
+
+	return h, nil
+}
+
+// newHost creates the libp2p host for the configuration without routing.
+// perf, which may be nil, ranks dial addresses for the measured strategy.
+func newHost(cfg *Config, perf *TransportPerf, extraOpts ...libp2p.Option) (host.Host, error) {
+	logrus.Info("Creating libp2p node...")
+
+	// Build listen addresses
+	listenAddrs, err := listenAddresses(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build listen addresses: %w", err)
+	}
+	if cfg.ProxyStrict {
+		// UDP transports cannot be proxied, so don't listen on them either
+		listenAddrs = filterProxiableAddrs(listenAddrs)
+	}
+
+	// Create libp2p host options
+	opts := []libp2p.Option{
+		// Listen addresses - TCP, QUIC (UDP), and WebSocket
+		libp2p.ListenAddrs(listenAddrs...),
+		
+		// Enable AutoNAT for NAT detection
+		libp2p.EnableAutoNATv2(),
+		
+		// Enable relay client for hole punching
+		libp2p.EnableRelayService(relayServiceOptions(cfg)...),
+		
+		// Answer the standard /ipfs/ping/1.0.0 so stock libp2p nodes can ping us
+		libp2p.Ping(true),
+	}
+
+	// Enable hole punching unless every dial must go through the proxy
+	if !cfg.ProxyStrict {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+
+	// Add relay service if enabled
+	if cfg.EnableRelay {
+		opts = append(opts, libp2p.EnableRelay())
+	}
+
+	// Keep the same peer ID across restarts if an identity file is configured
+	if cfg.IdentityFile != "" {
+		key, err := LoadIdentity(cfg.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load identity: %w", err)
+		}
+		opts = append(opts, libp2p.Identity(key))
+	}
+
+	// Dial ranking and timeouts
+	opts = append(opts, dialOptions(cfg, perf)...)
+
+	// Route outbound dials through the SOCKS5 proxy if configured
+	proxyOpts, err := proxyOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxy: %w", err)
+	}
+	opts = append(opts, proxyOpts...)
+
+	// Set TCP socket options on direct dials if configured
+	opts = append(opts, tcpOptions(cfg)...)
+
+	// Tune QUIC for lossy links and long fat pipes if configured
+	if quicOpt := quicOption(cfg); quicOpt != nil {
+		opts = append(opts, quicOpt)
+	}
+
+	// Trim connections between the water marks, keeping the peers tagged for recent activity
+	cm, err := connmgr.NewConnManager(cfg.LowWater, cfg.HighWater,
+		connmgr.DecayerConfig(&connmgr.DecayerCfg{Resolution: activityResolution}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %w", err)
+	}
+	opts = append(opts, libp2p.ConnectionManager(cm))
+	opts = append(opts, extraOpts...)
+
+	// Create the host
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+	}
+
+	// Set up protocols
+	if err := setupProtocols(h); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to setup protocols: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer_id":    h.ID(),
+		"addrs":      h.Addrs(),
+		"relay":      cfg.EnableRelay,
+		"websocket":  cfg.EnableWebSocket,
+	}).Info("Node created successfully")
+
+	return h, nil
+}
+
+// relayServiceOptions sizes the relay service for the configuration
+func relayServiceOptions(cfg *Config) []relayv2.Option {
+	if cfg.RelayMaxReservations <= 0 {
+		return nil
+	}
+	resources := relayv2.DefaultResources()
+	resources.MaxReservations = cfg.RelayMaxReservations
+	return []relayv2.Option{relayv2.WithResources(resources)}
+}
+
+// TransportPorts holds the listen port of each transport (0 for random)
+type TransportPorts struct {
+	TCP  int
+	QUIC int
+	WS   int
+	WSS  int
+}
+
+// uniformPorts uses the same port for every transport
+func uniformPorts(port int) TransportPorts {
+	return TransportPorts{TCP: port, QUIC: port, WS: port, WSS: port}
+}
+
+func buildListenAddresses(port int, enableWS bool) []multiaddr.Multiaddr {
+	return buildListenAddressesForHosts([]string{"/ip4/0.0.0.0", "/ip6/::"}, uniformPorts(port), enableWS)
+}
+
+// listenAddresses returns the listen addresses for the configuration,
+// restricted to the configured network interfaces if any
+func listenAddresses(cfg *Config) ([]multiaddr.Multiaddr, error) {
+	hosts := []string{"/ip4/0.0.0.0", "/ip6/::"}
+	if len(cfg.Interfaces) > 0 {
+		var err error
+		hosts, err = interfaceHosts(cfg.Interfaces)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buildListenAddressesForHosts(hosts, cfg.TransportPorts(), cfg.EnableWebSocket), nil
+}
+
+// buildListenAddressesForHosts builds the transport addresses for each IP prefix (e.g. /ip4/0.0.0.0)
+func buildListenAddressesForHosts(hosts []string, ports TransportPorts, enableWS bool) []multiaddr.Multiaddr {
+	var addrs []multiaddr.Multiaddr
+
+	for _, ipHost := range hosts {
+		// TCP address
+		tcpAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("%s/tcp/%s", ipHost, portString(ports.TCP)))
+
+		// QUIC address (UDP-based)
+		quicAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("%s/udp/%s/quic-v1", ipHost, portString(ports.QUIC)))
+
+		addrs = append(addrs, tcpAddr, quicAddr)
+
+		// Add WebSocket addresses if enabled
+		if enableWS {
+			wsAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("%s/tcp/%s/ws", ipHost, portString(ports.WS)))
+
+			// WebSocket Secure address
+			wssAddr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("%s/tcp/%s/wss", ipHost, portString(ports.WSS)))
+
+			addrs = append(addrs, wsAddr, wssAddr)
+		}
+	}
+
+	if enableWS {
+		logrus.WithField("websocket", true).Info("WebSocket transport enabled")
+	}
+
+	return addrs
+}
+
+// portString formats a listen port, using 0 (random) for unset ports
+func portString(port int) string {
+	if port > 0 {
+		return fmt.Sprintf("%d", port)
+	}
+	return "0"
+}
+
+func setupRouting(ctx context.Context, h host.Host, opts ...dht.Option) (*discovery.DHT, error) {
+	// Create a DHT for routing
+	kademliaDHT, err := dht.New(ctx, h, append([]dht.Option{dht.Mode(dht.ModeAuto)}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHT: %w", err)
+	}
+
+	// Bootstrap the DHT
+	if err = kademliaDHT.Bootstrap(ctx); err != nil {
+		return nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+
+	logrus.Info("DHT routing setup complete")
+	return &discovery.DHT{IpfsDHT: kademliaDHT}, nil
+}
+
+func setupProtocols(h host.Host) error {
+	// The protocols are automatically set up by libp2p options
+	// Additional custom protocols can be added here
+	
+	logrus.Info("Protocols setup complete")
+	return nil
+}
@@ -1,14 +1,17 @@
-package main
+package libp2plearn
 
 import (
 	"context"
 	"fmt"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
 )
 
 func TestCreateNode(t *testing.T) {
@@ -131,6 +134,58 @@ func TestNodeConfiguration(t *testing.T) {
 		assert.True(t, hasTCPZero, "Should have TCP with random port (0)")
 		assert.True(t, hasUDPZero, "Should have UDP with random port (0)")
 	})
+
+	t.Run("SeparateTransportPorts", func(t *testing.T) {
+		tcpPort, quicPort, wsPort := 4001, 4002, 4003
+
+		cfg := DefaultConfig()
+		cfg.ListenPort = 9000
+		cfg.TCPPort = &tcpPort
+		cfg.QUICPort = &quicPort
+		cfg.WSPort = &wsPort
+		require.NoError(t, cfg.Validate())
+
+		addrs, err := listenAddresses(cfg)
+		require.NoError(t, err)
+
+		for _, addr := range addrs {
+			addrStr := addr.String()
+			switch {
+			case containsProtocol(addrStr, "/wss"):
+				assert.True(t, containsProtocol(addrStr, "/tcp/9000/"), "WSS should fall back to listen_port: %s", addrStr)
+			case containsProtocol(addrStr, "/ws"):
+				assert.True(t, containsProtocol(addrStr, "/tcp/4003/"), "WS should use ws_port: %s", addrStr)
+			case containsProtocol(addrStr, "quic"):
+				assert.True(t, containsProtocol(addrStr, "/udp/4002/"), "QUIC should use quic_port: %s", addrStr)
+			default:
+				assert.True(t, containsProtocol(addrStr, "/tcp/4001"), "TCP should use tcp_port: %s", addrStr)
+			}
+		}
+
+		invalid := 70000
+		cfg.WSSPort = &invalid
+		assert.Error(t, cfg.Validate(), "Out of range transport ports should be rejected")
+	})
+
+	t.Run("InterfaceAddresses", func(t *testing.T) {
+		loopback := findLoopbackInterface(t)
+
+		cfg := DefaultConfig()
+		cfg.Interfaces = []string{loopback}
+		addrs, err := listenAddresses(cfg)
+		require.NoError(t, err)
+		assert.NotEmpty(t, addrs)
+
+		for _, addr := range addrs {
+			addrStr := addr.String()
+			assert.False(t, containsProtocol(addrStr, "0.0.0.0"), "Should not listen on IPv4 wildcard: %s", addrStr)
+			assert.False(t, containsProtocol(addrStr, "/ip6/::/"), "Should not listen on IPv6 wildcard: %s", addrStr)
+		}
+
+		cfg.Interfaces = []string{"does-not-exist0"}
+		_, err = listenAddresses(cfg)
+		assert.Error(t, err, "Unknown interfaces should be rejected")
+	})
 }
 
 func TestTwoNodeConnection(t *testing.T) {
@@ -234,7 +289,7 @@ func TestBootstrapping(t *testing.T) {
 	bootstrapAddr := fmt.Sprintf("%s/p2p/%s", bootstrapAddrs[0], bootstrap.ID())
 
 	// Bootstrap client to bootstrap node
-	err = bootstrapPeers(ctx, client, []string{bootstrapAddr})
+	err = discovery.BootstrapPeers(ctx, client, []string{bootstrapAddr})
 	require.NoError(t, err)
 
 	// Wait for connection instead of arbitrary sleep
@@ -294,6 +349,18 @@ func TestRelayFunctionality(t *testing.T) {
 
 // Helper functions
 
+func findLoopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("No loopback interface available")
+	return ""
+}
+
 func connectNodes(ctx context.Context, from, to host.Host) error {
 	addrs := to.Addrs()
 	if len(addrs) == 0 {
@@ -302,7 +369,7 @@ func connectNodes(ctx context.Context, from, to host.Host) error {
 
 	// Use the first available address
 	addr := addrs[0]
-	return connectToPeer(ctx, from, fmt.Sprintf("%s/p2p/%s", addr, to.ID()))
+	return discovery.ConnectToPeer(ctx, from, fmt.Sprintf("%s/p2p/%s", addr, to.ID()))
 }
 
 func containsProtocol(addr string, protocol string) bool {
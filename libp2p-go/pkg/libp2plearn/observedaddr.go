@@ -0,0 +1,256 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+// ObservedAddrCandidate is a public address peers report seeing us at
+type ObservedAddrCandidate struct {
+	Addr string `json:"addr"`
+	// Observers is the number of distinct networks that reported the
+	// address, so many peers behind one NAT count once
+	Observers int `json:"observers"`
+	// Confidence is the share of the observers of the same transport that
+	// reported this address rather than another
+	Confidence float64 `json:"confidence"`
+	// Advertised is whether the address is confident enough to advertise
+	Advertised bool      `json:"advertised"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// NATStatus is the result of the nat_status admin command
+type NATStatus struct {
+	Reachability string                  `json:"reachability"` // public, private or unknown
+	Addrs        []string                `json:"addrs"`        // what the node advertises
+	Candidates   []ObservedAddrCandidate `json:"candidates"`
+}
+
+// ObservedAddrs aggregates the addresses peers observe us at through
+// Identify, and keeps public addresses out of the advertised ones until
+// enough distinct networks agree on them. A single misconfigured or lying
+// peer then can't make us advertise a bogus address.
+type ObservedAddrs struct {
+	host          host.Host
+	minObservers  int
+	minConfidence float64
+	sub           event.Subscription
+
+	mu           sync.Mutex
+	reachability network.Reachability
+	// observations maps an observed address to the last report from each
+	// observer network
+	observations map[string]map[string]time.Time
+}
+
+// NewObservedAddrs creates an observed address tracker. An address is
+// advertised once minObservers distinct networks reported it and its
+// confidence reaches minConfidence.
+func NewObservedAddrs(minObservers int, minConfidence float64) *ObservedAddrs {
+	return &ObservedAddrs{
+		minObservers:  minObservers,
+		minConfidence: minConfidence,
+		observations:  make(map[string]map[string]time.Time),
+	}
+}
+
+// Track records the addresses the host's peers observe and its reachability
+func (o *ObservedAddrs) Track(h host.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtLocalReachabilityChanged),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to identify events: %w", err)
+	}
+	o.host = h
+	o.sub = sub
+
+	go func() {
+		for e := range sub.Out() {
+			switch evt := e.(type) {
+			case event.EvtPeerIdentificationCompleted:
+				if evt.ObservedAddr != nil && evt.Conn != nil {
+					o.Observe(evt.ObservedAddr, evt.Conn.RemoteMultiaddr())
+				}
+			case event.EvtLocalReachabilityChanged:
+				o.mu.Lock()
+				o.reachability = evt.Reachability
+				o.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops tracking
+func (o *ObservedAddrs) Close() {
+	if o.sub != nil {
+		o.sub.Close()
+	}
+}
+
+// Observe records that the peer at observer saw us at addr. Only public
+// addresses are candidates; private ones are ours to know already.
+func (o *ObservedAddrs) Observe(addr, observer multiaddr.Multiaddr) {
+	if !manet.IsPublicAddr(addr) {
+		return
+	}
+	group, ok := observerGroup(observer)
+	if !ok {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := addr.String()
+	if o.observations[key] == nil {
+		o.observations[key] = make(map[string]time.Time)
+		logrus.WithFields(logrus.Fields{
+			"addr":     key,
+			"observer": observer,
+		}).Debug("Peer observed a new address of ours")
+	}
+	o.observations[key][group] = time.Now()
+}
+
+// Candidates returns the public addresses peers observed us at recently,
+// most confident first. Reports expire after the own_observed address TTL.
+func (o *ObservedAddrs) Candidates() []ObservedAddrCandidate {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.candidates()
+}
+
+// candidates drops expired reports and scores the remaining addresses;
+// o.mu must be held
+func (o *ObservedAddrs) candidates() []ObservedAddrCandidate {
+	cutoff := time.Now().Add(-peerstore.OwnObservedAddrTTL)
+	observers := make(map[string]int)
+	perTransport := make(map[string]map[string]bool)
+	lastSeen := make(map[string]time.Time)
+	for addr, groups := range o.observations {
+		for group, seen := range groups {
+			if seen.Before(cutoff) {
+				delete(groups, group)
+				continue
+			}
+			if seen.After(lastSeen[addr]) {
+				lastSeen[addr] = seen
+			}
+		}
+		if len(groups) == 0 {
+			delete(o.observations, addr)
+			continue
+		}
+		observers[addr] = len(groups)
+
+		transport := addrTransport(addr)
+		if perTransport[transport] == nil {
+			perTransport[transport] = make(map[string]bool)
+		}
+		for group := range groups {
+			perTransport[transport][group] = true
+		}
+	}
+
+	candidates := make([]ObservedAddrCandidate, 0, len(observers))
+	for addr, n := range observers {
+		c := ObservedAddrCandidate{
+			Addr:       addr,
+			Observers:  n,
+			Confidence: float64(n) / float64(len(perTransport[addrTransport(addr)])),
+			LastSeen:   lastSeen[addr],
+		}
+		c.Advertised = c.Observers >= o.minObservers && c.Confidence >= o.minConfidence
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Confidence != candidates[j].Confidence {
+			return candidates[i].Confidence > candidates[j].Confidence
+		}
+		return candidates[i].Addr < candidates[j].Addr
+	})
+	return candidates
+}
+
+// Filter is a libp2p AddrsFactory that leaves out observed addresses that
+// aren't confident enough. Addresses no peer reported, such as listen
+// addresses, are kept.
+func (o *ObservedAddrs) Filter(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	o.mu.Lock()
+	unconfident := make(map[string]bool)
+	for _, c := range o.candidates() {
+		if !c.Advertised {
+			unconfident[c.Addr] = true
+		}
+	}
+	o.mu.Unlock()
+
+	if len(unconfident) == 0 {
+		return addrs
+	}
+	filtered := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if !unconfident[addr.String()] {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// Status reports reachability, the advertised addresses and the candidates
+func (o *ObservedAddrs) Status() NATStatus {
+	candidates := o.Candidates()
+	o.mu.Lock()
+	status := NATStatus{
+		Reachability: strings.ToLower(o.reachability.String()),
+		Candidates:   candidates,
+	}
+	o.mu.Unlock()
+	if o.host != nil {
+		for _, addr := range o.host.Addrs() {
+			status.Addrs = append(status.Addrs, addr.String())
+		}
+	}
+	return status
+}
+
+// observerGroup returns the network an observer is in: its IPv4 address,
+// or the /56 prefix of its IPv6 address, since one host usually has many
+func observerGroup(observer multiaddr.Multiaddr) (string, bool) {
+	ip, err := manet.ToIP(observer)
+	if err != nil {
+		return "", false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String(), true
+	}
+	return ip.Mask(net.CIDRMask(56, 128)).String(), true
+}
+
+// addrTransport returns the protocols of an address without their values,
+// like /ip4/tcp, so addresses on the same transport compete for confidence
+func addrTransport(addr string) string {
+	ma, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return addr
+	}
+	var b strings.Builder
+	for _, p := range ma.Protocols() {
+		b.WriteString("/" + p.Name)
+	}
+	return b.String()
+}
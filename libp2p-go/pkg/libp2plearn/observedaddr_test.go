@@ -0,0 +1,113 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservedAddrs(t *testing.T) {
+	observed := NewObservedAddrs(2, 0.5)
+
+	good := multiaddr.StringCast("/ip4/93.184.216.34/tcp/4001")
+	bogus := multiaddr.StringCast("/ip4/34.120.1.1/tcp/4001")
+	quic := multiaddr.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1")
+	private := multiaddr.StringCast("/ip4/192.168.1.20/tcp/4001")
+
+	observed.Observe(good, multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001"))
+	observed.Observe(good, multiaddr.StringCast("/ip4/8.8.8.8/tcp/4001"))
+	observed.Observe(good, multiaddr.StringCast("/ip4/8.8.8.8/tcp/5001")) // same network again
+	observed.Observe(bogus, multiaddr.StringCast("/ip4/9.9.9.9/tcp/4001"))
+	observed.Observe(quic, multiaddr.StringCast("/ip4/9.9.9.9/udp/4001/quic-v1"))
+	observed.Observe(private, multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001"))
+
+	candidates := observed.Candidates()
+	require.Len(t, candidates, 3)
+	byAddr := make(map[string]ObservedAddrCandidate)
+	for _, c := range candidates {
+		byAddr[c.Addr] = c
+	}
+
+	// Two of the three TCP observer networks agree on the good address
+	assert.Equal(t, 2, byAddr[good.String()].Observers)
+	assert.InDelta(t, 2.0/3, byAddr[good.String()].Confidence, 0.001)
+	assert.True(t, byAddr[good.String()].Advertised)
+
+	assert.Equal(t, 1, byAddr[bogus.String()].Observers)
+	assert.False(t, byAddr[bogus.String()].Advertised)
+
+	// The only QUIC report is unanimous, but a single observer isn't enough
+	assert.Equal(t, 1.0, byAddr[quic.String()].Confidence)
+	assert.False(t, byAddr[quic.String()].Advertised)
+	assert.Equal(t, good.String(), candidates[1].Addr)
+
+	t.Run("Filter leaves out unconfident addresses", func(t *testing.T) {
+		listen := multiaddr.StringCast("/ip4/0.0.0.0/tcp/4001")
+		filtered := observed.Filter([]multiaddr.Multiaddr{listen, private, good, bogus, quic})
+		assert.Equal(t, []multiaddr.Multiaddr{listen, private, good}, filtered)
+	})
+
+	t.Run("IPv6 observers count by prefix", func(t *testing.T) {
+		a, ok := observerGroup(multiaddr.StringCast("/ip6/2001:db8:0:1::1/tcp/4001"))
+		require.True(t, ok)
+		b, ok := observerGroup(multiaddr.StringCast("/ip6/2001:db8:0:2::1/tcp/4001"))
+		require.True(t, ok)
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("reports expire", func(t *testing.T) {
+		saved := peerstore.OwnObservedAddrTTL
+		defer func() { peerstore.OwnObservedAddrTTL = saved }()
+
+		peerstore.OwnObservedAddrTTL = -time.Second
+		assert.Empty(t, observed.Candidates())
+	})
+}
+
+func TestNATStatusAdmin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	operator, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer operator.Close()
+
+	managed, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer managed.Close()
+
+	goodbye, err := NewGoodbye(managed)
+	require.NoError(t, err)
+	defer goodbye.Close()
+
+	admin, err := NewAdmin(managed, goodbye, []string{operator.ID().String()})
+	require.NoError(t, err)
+	defer admin.Close()
+
+	require.NoError(t, connectNodes(ctx, operator, managed))
+
+	_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdNATStatus)
+	assert.ErrorContains(t, err, "not tracked")
+
+	observed := NewObservedAddrs(1, 0.5)
+	require.NoError(t, observed.Track(managed))
+	defer observed.Close()
+	admin.SetObservedAddrs(observed)
+	observed.Observe(multiaddr.StringCast("/ip4/93.184.216.34/tcp/4001"), multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001"))
+
+	result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdNATStatus)
+	require.NoError(t, err)
+
+	var status NATStatus
+	require.NoError(t, json.Unmarshal(result, &status))
+	assert.Equal(t, "unknown", status.Reachability)
+	assert.NotEmpty(t, status.Addrs)
+	require.Len(t, status.Candidates, 1)
+	assert.True(t, status.Candidates[0].Advertised)
+}
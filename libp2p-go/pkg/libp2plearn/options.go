@@ -0,0 +1,87 @@
+package libp2plearn
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+// Option configures a node created with New
+type Option func(*nodeOptions) error
+
+// nodeOptions collects the configuration and extra libp2p options of a new node
+type nodeOptions struct {
+	cfg             *Config
+	libp2pOpts      []libp2p.Option
+	transportPolicy TransportPolicy
+}
+
+// WithConfig uses cfg as the base configuration instead of DefaultConfig.
+// Options after it modify cfg in place.
+func WithConfig(cfg *Config) Option {
+	return func(o *nodeOptions) error {
+		if cfg == nil {
+			return fmt.Errorf("config must not be nil")
+		}
+		o.cfg = cfg
+		return nil
+	}
+}
+
+// WithListenPort sets the port every transport listens on (0 for random)
+func WithListenPort(port int) Option {
+	return func(o *nodeOptions) error {
+		o.cfg.ListenPort = port
+		return nil
+	}
+}
+
+// WithBootstrapPeers replaces the bootstrap peer multiaddrs
+func WithBootstrapPeers(addrs ...string) Option {
+	return func(o *nodeOptions) error {
+		o.cfg.BootstrapPeers = addrs
+		return nil
+	}
+}
+
+// WithRelay enables the circuit relay transport
+func WithRelay() Option {
+	return func(o *nodeOptions) error {
+		o.cfg.EnableRelay = true
+		return nil
+	}
+}
+
+// WithWebSocket enables or disables the WebSocket transports
+func WithWebSocket(enabled bool) Option {
+	return func(o *nodeOptions) error {
+		o.cfg.EnableWebSocket = enabled
+		return nil
+	}
+}
+
+// WithBlockedPeers refuses connections to and from the given peer IDs
+func WithBlockedPeers(ids ...string) Option {
+	return func(o *nodeOptions) error {
+		o.cfg.BlockedPeers = append(o.cfg.BlockedPeers, ids...)
+		return nil
+	}
+}
+
+// WithTransportPolicy ranks dial addresses with policy instead of
+// MeasuredTransportPolicy when dial_strategy is measured
+func WithTransportPolicy(policy TransportPolicy) Option {
+	return func(o *nodeOptions) error {
+		o.transportPolicy = policy
+		return nil
+	}
+}
+
+// WithLibp2pOptions passes extra options to libp2p.New, applied after the ones
+// derived from the configuration
+func WithLibp2pOptions(opts ...libp2p.Option) Option {
+	return func(o *nodeOptions) error {
+		o.libp2pOpts = append(o.libp2pOpts, opts...)
+		return nil
+	}
+}
@@ -0,0 +1,511 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// outboxSweepInterval is how often queued messages are retried and
+	// expired ones dropped
+	outboxSweepInterval = time.Minute
+
+	// outboxChatTimeout bounds delivering one chat message
+	outboxChatTimeout = 30 * time.Second
+
+	// outboxKeep is how long finished messages can still be looked up
+	outboxKeep = 24 * time.Hour
+)
+
+// OutboxKind is what an outbox message carries
+type OutboxKind string
+
+const (
+	OutboxChat OutboxKind = "chat" // a chat message, Body is its text
+	OutboxDir  OutboxKind = "dir"  // a directory transfer, Body is the local path
+)
+
+// OutboxStatus is where an outbox message is on its way to the peer
+type OutboxStatus string
+
+const (
+	// OutboxQueued messages wait for the peer to be reachable
+	OutboxQueued OutboxStatus = "queued"
+	// OutboxDelivered messages were accepted by the peer
+	OutboxDelivered OutboxStatus = "delivered"
+	// OutboxHandedOff messages were left at a mailbox node for the peer
+	OutboxHandedOff OutboxStatus = "handed_off"
+	// OutboxExpired messages weren't delivered before their TTL passed
+	OutboxExpired OutboxStatus = "expired"
+	// OutboxFailed messages can't be delivered, such as a directory the
+	// peer refused
+	OutboxFailed OutboxStatus = "failed"
+)
+
+// OutboxMessage is a message in the outbox and its delivery status
+type OutboxMessage struct {
+	ID       string       `json:"id"`
+	To       peer.ID      `json:"to"`
+	Kind     OutboxKind   `json:"kind"`
+	Body     string       `json:"body"`
+	Status   OutboxStatus `json:"status"`
+	Created  time.Time    `json:"created"`
+	Expires  time.Time    `json:"expires"`
+	Updated  time.Time    `json:"updated"`
+	Attempts int          `json:"attempts"`
+	Mailbox  peer.ID      `json:"mailbox,omitempty"` // where a handed off message was left
+	Error    string       `json:"error,omitempty"`   // why the last attempt failed
+}
+
+// EvtOutboxStatus is emitted on the host event bus when an outbox message
+// is delivered, handed off, expires or fails
+type EvtOutboxStatus struct {
+	Message OutboxMessage
+}
+
+// Outbox queues chat messages and directories for peers that can't be
+// reached. A message is sent right away if possible, and otherwise when the
+// peer connects or on a later retry, until its TTL passes. Chat messages
+// for a peer that can't be reached are handed to the first mailbox node that
+// takes them instead, sealed so only the peer can read them. The queue is
+// kept in a file so it survives restarts.
+type Outbox struct {
+	host      host.Host
+	protocols *ProtocolHandler
+	path      string
+	ttl       time.Duration
+	mailboxes []peer.ID
+	emitter   event.Emitter
+	notifee   *network.NotifyBundle
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup // delivery goroutines
+
+	mu         sync.Mutex
+	messages   map[string]*OutboxMessage
+	delivering map[peer.ID]bool
+
+	saveMu sync.Mutex
+}
+
+// NewOutbox loads the outbox kept in path and starts delivering to peers as
+// they connect. ttl is how long messages are kept by default, and mailboxes
+// the nodes chat messages are handed to.
+func NewOutbox(h host.Host, protocols *ProtocolHandler, path string, ttl time.Duration, mailboxes []peer.ID) (*Outbox, error) {
+	emitter, err := h.EventBus().Emitter(new(EvtOutboxStatus))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox emitter: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &Outbox{
+		host:       h,
+		protocols:  protocols,
+		path:       path,
+		ttl:        ttl,
+		mailboxes:  mailboxes,
+		emitter:    emitter,
+		ctx:        ctx,
+		cancel:     cancel,
+		messages:   make(map[string]*OutboxMessage),
+		delivering: make(map[peer.ID]bool),
+	}
+	if err := o.load(); err != nil {
+		cancel()
+		emitter.Close()
+		return nil, err
+	}
+
+	o.notifee = &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			o.goDeliver(c.RemotePeer())
+		},
+	}
+	h.Network().Notify(o.notifee)
+	return o, nil
+}
+
+// Close stops delivering, waits for deliveries under way and saves the
+// outbox
+func (o *Outbox) Close() error {
+	o.host.Network().StopNotify(o.notifee)
+	o.mu.Lock()
+	o.cancel()
+	o.mu.Unlock()
+	o.wg.Wait()
+	o.emitter.Close()
+	return o.Save()
+}
+
+// SendChat queues a chat message for a peer and tries to deliver it right
+// away. A ttl of 0 keeps it for the outbox's default TTL.
+func (o *Outbox) SendChat(to peer.ID, text string, ttl time.Duration) (OutboxMessage, error) {
+	return o.enqueue(to, OutboxChat, text, ttl)
+}
+
+// SendDir queues a directory transfer to a peer and tries to deliver it
+// right away. A ttl of 0 keeps it for the outbox's default TTL.
+func (o *Outbox) SendDir(to peer.ID, dir string, ttl time.Duration) (OutboxMessage, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return OutboxMessage{}, fmt.Errorf("invalid directory: %w", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return OutboxMessage{}, fmt.Errorf("%s is not a directory", dir)
+	}
+	return o.enqueue(to, OutboxDir, dir, ttl)
+}
+
+// enqueue adds a message to the outbox
+func (o *Outbox) enqueue(to peer.ID, kind OutboxKind, body string, ttl time.Duration) (OutboxMessage, error) {
+	if to == o.host.ID() {
+		return OutboxMessage{}, fmt.Errorf("cannot send to self")
+	}
+	if ttl <= 0 {
+		ttl = o.ttl
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	now := time.Now()
+	m := &OutboxMessage{
+		ID:      hex.EncodeToString(id),
+		To:      to,
+		Kind:    kind,
+		Body:    body,
+		Status:  OutboxQueued,
+		Created: now,
+		Expires: now.Add(ttl),
+		Updated: now,
+	}
+	o.mu.Lock()
+	o.messages[m.ID] = m
+	snapshot := *m
+	o.mu.Unlock()
+
+	if err := o.Save(); err != nil {
+		logrus.WithError(err).Error("Failed to save outbox")
+	}
+	logrus.WithFields(logrus.Fields{"id": m.ID, "peer": to, "kind": kind}).Debug("Queued outbox message")
+	o.goDeliver(to)
+	return snapshot, nil
+}
+
+// Status returns a message by ID
+func (o *Outbox) Status(id string) (OutboxMessage, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m, ok := o.messages[id]
+	if !ok {
+		return OutboxMessage{}, false
+	}
+	return *m, true
+}
+
+// List returns the messages in the outbox, oldest first. Finished messages
+// are listed for a day after they finish.
+func (o *Outbox) List() []OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	messages := make([]OutboxMessage, 0, len(o.messages))
+	for _, m := range o.messages {
+		messages = append(messages, *m)
+	}
+	sortOutbox(messages)
+	return messages
+}
+
+// Run retries queued messages and expires old ones every interval until
+// ctx is done
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		o.sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep expires and prunes messages, then tries the peers with queued
+// messages again
+func (o *Outbox) sweep() {
+	now := time.Now()
+	var expired []OutboxMessage
+	peers := make(map[peer.ID]bool)
+
+	o.mu.Lock()
+	for id, m := range o.messages {
+		switch {
+		case m.Status == OutboxQueued && !now.Before(m.Expires):
+			m.Status, m.Updated = OutboxExpired, now
+			expired = append(expired, *m)
+		case m.Status == OutboxQueued:
+			peers[m.To] = true
+		case now.Sub(m.Updated) > outboxKeep:
+			delete(o.messages, id)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, m := range expired {
+		logrus.WithFields(logrus.Fields{"id": m.ID, "peer": m.To}).Info("Outbox message expired")
+		o.emit(m)
+	}
+	if err := o.Save(); err != nil {
+		logrus.WithError(err).Error("Failed to save outbox")
+	}
+	for p := range peers {
+		o.goDeliver(p)
+	}
+}
+
+// goDeliver delivers to a peer in the background, unless the outbox is
+// closed
+func (o *Outbox) goDeliver(p peer.ID) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ctx.Err() != nil {
+		return
+	}
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		o.deliver(p)
+	}()
+}
+
+// deliver sends the queued messages for a peer in order. Once one can't be
+// sent, the remaining chat messages are handed to a mailbox node.
+func (o *Outbox) deliver(p peer.ID) {
+	o.mu.Lock()
+	if o.delivering[p] {
+		o.mu.Unlock()
+		return
+	}
+	o.delivering[p] = true
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.delivering, p)
+		o.mu.Unlock()
+	}()
+
+	queued := o.queued(p)
+	if len(queued) == 0 {
+		return
+	}
+	for i, m := range queued {
+		if o.ctx.Err() != nil {
+			return
+		}
+		status, err := o.send(m)
+		if status == OutboxQueued {
+			o.update(m.ID, status, "", err)
+			o.handOff(queued[i:])
+			break
+		}
+		o.update(m.ID, status, "", err)
+	}
+	if err := o.Save(); err != nil {
+		logrus.WithError(err).Error("Failed to save outbox")
+	}
+}
+
+// queued returns the queued messages for a peer, oldest first
+func (o *Outbox) queued(p peer.ID) []OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var queued []OutboxMessage
+	now := time.Now()
+	for _, m := range o.messages {
+		if m.To == p && m.Status == OutboxQueued && now.Before(m.Expires) {
+			queued = append(queued, *m)
+		}
+	}
+	sortOutbox(queued)
+	return queued
+}
+
+// send delivers one message, returning OutboxQueued if it should be tried
+// again
+func (o *Outbox) send(m OutboxMessage) (OutboxStatus, error) {
+	switch m.Kind {
+	case OutboxChat:
+		ctx, cancel := context.WithTimeout(o.ctx, outboxChatTimeout)
+		defer cancel()
+		if _, err := o.protocols.SendChatMessage(ctx, m.To, m.Body); err != nil {
+			return OutboxQueued, err
+		}
+	case OutboxDir:
+		if _, err := os.Stat(m.Body); err != nil {
+			return OutboxFailed, fmt.Errorf("directory is gone: %w", err)
+		}
+		ctx, cancel := context.WithDeadline(o.ctx, m.Expires)
+		defer cancel()
+		reply, err := SendDir(ctx, o.host, m.To, m.Body, nil)
+		if err != nil {
+			return OutboxQueued, err
+		}
+		if !reply.OK {
+			return OutboxFailed, fmt.Errorf("peer refused directory: %s", reply.Error)
+		}
+	default:
+		return OutboxFailed, fmt.Errorf("unknown message kind %q", m.Kind)
+	}
+	return OutboxDelivered, nil
+}
+
+// handOff leaves the chat messages with the first mailbox node that takes
+// them
+func (o *Outbox) handOff(messages []OutboxMessage) {
+	if len(o.mailboxes) == 0 {
+		return
+	}
+	key := o.host.Peerstore().PrivKey(o.host.ID())
+	for _, m := range messages {
+		if m.Kind != OutboxChat {
+			continue
+		}
+		sealed, err := SealLetter(key, &MailboxLetter{
+			ID:   m.ID,
+			From: o.host.ID(),
+			To:   m.To,
+			Text: m.Body,
+			Sent: m.Created.UnixNano(),
+		})
+		if err != nil {
+			o.update(m.ID, OutboxFailed, "", err)
+			continue
+		}
+		for _, mailbox := range o.mailboxes {
+			if mailbox == m.To {
+				continue
+			}
+			if err := PutLetter(o.ctx, o.host, mailbox, m.To, sealed, m.Expires); err != nil {
+				logrus.WithError(err).WithField("mailbox", mailbox).Debug("Failed to hand off message")
+				continue
+			}
+			o.update(m.ID, OutboxHandedOff, mailbox, nil)
+			break
+		}
+	}
+}
+
+// update records the outcome of a delivery attempt
+func (o *Outbox) update(id string, status OutboxStatus, mailbox peer.ID, err error) {
+	o.mu.Lock()
+	m, ok := o.messages[id]
+	if !ok || m.Status != OutboxQueued {
+		o.mu.Unlock()
+		return
+	}
+	m.Attempts++
+	m.Status, m.Mailbox, m.Updated = status, mailbox, time.Now()
+	m.Error = ""
+	if err != nil {
+		m.Error = err.Error()
+	}
+	snapshot := *m
+	o.mu.Unlock()
+
+	log := logrus.WithFields(logrus.Fields{"id": id, "peer": snapshot.To, "status": status})
+	switch status {
+	case OutboxQueued:
+		log.WithError(err).Debug("Outbox message not delivered yet")
+		return
+	case OutboxFailed:
+		log.WithError(err).Warn("Outbox message failed")
+	default:
+		log.Info("Outbox message sent")
+	}
+	o.emit(snapshot)
+}
+
+// emit reports a finished message on the event bus
+func (o *Outbox) emit(m OutboxMessage) {
+	if err := o.emitter.Emit(EvtOutboxStatus{Message: m}); err != nil {
+		logrus.WithError(err).Debug("Failed to emit outbox status")
+	}
+}
+
+// Save writes the outbox to its file
+func (o *Outbox) Save() error {
+	o.saveMu.Lock()
+	defer o.saveMu.Unlock()
+
+	o.mu.Lock()
+	messages := make([]OutboxMessage, 0, len(o.messages))
+	for _, m := range o.messages {
+		messages = append(messages, *m)
+	}
+	o.mu.Unlock()
+	sortOutbox(messages)
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	if err := writeFileAtomic(o.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
+
+// load reads the outbox file, if there is one
+func (o *Outbox) load() error {
+	data, err := os.ReadFile(o.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	var messages []*OutboxMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("failed to parse outbox: %w", err)
+	}
+	for _, m := range messages {
+		o.messages[m.ID] = m
+	}
+	return nil
+}
+
+// sortOutbox sorts messages oldest first
+func sortOutbox(messages []OutboxMessage) {
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].Created.Equal(messages[j].Created) {
+			return messages[i].Created.Before(messages[j].Created)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+}
+
+// Outbox returns the queue of messages for unreachable peers, which is nil
+// unless enable_outbox is set
+func (n *Node) Outbox() *Outbox {
+	return n.outbox
+}
@@ -0,0 +1,161 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sender, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer sender.Close()
+	recipient, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer recipient.Close()
+
+	received := make(chan string, 4)
+	handler := NewProtocolHandler(recipient)
+	handler.SetupProtocols()
+	handler.OnMessage(func(proto protocol.ID, from peer.ID, msg string) {
+		received <- msg
+	})
+
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	outbox, err := NewOutbox(sender, NewProtocolHandler(sender), path, time.Hour, nil)
+	require.NoError(t, err)
+	defer outbox.Close()
+	sub, err := sender.EventBus().Subscribe(new(EvtOutboxStatus))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	t.Run("DeliveredOnConnect", func(t *testing.T) {
+		// The sender doesn't know the recipient's addresses yet
+		msg, err := outbox.SendChat(recipient.ID(), "are you there?", 0)
+		require.NoError(t, err)
+		assert.Equal(t, OutboxQueued, msg.Status)
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			m, _ := outbox.Status(msg.ID)
+			return m.Attempts > 0
+		}, 10*time.Second, 10*time.Millisecond))
+		m, _ := outbox.Status(msg.ID)
+		assert.Equal(t, OutboxQueued, m.Status)
+		assert.NotEmpty(t, m.Error)
+
+		require.NoError(t, connectNodes(ctx, recipient, sender))
+		select {
+		case e := <-sub.Out():
+			evt := e.(EvtOutboxStatus)
+			assert.Equal(t, msg.ID, evt.Message.ID)
+			assert.Equal(t, OutboxDelivered, evt.Message.Status)
+		case <-ctx.Done():
+			t.Fatal("message not delivered")
+		}
+		assert.Equal(t, "are you there?", <-received)
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		offline, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+
+		msg, err := outbox.SendChat(offline, "hello?", time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		outbox.sweep()
+
+		m, ok := outbox.Status(msg.ID)
+		require.True(t, ok)
+		assert.Equal(t, OutboxExpired, m.Status)
+	})
+
+	t.Run("Persisted", func(t *testing.T) {
+		require.NoError(t, outbox.Save())
+		reloaded, err := NewOutbox(sender, NewProtocolHandler(sender), path, time.Hour, nil)
+		require.NoError(t, err)
+		defer reloaded.Close()
+		before, after := outbox.List(), reloaded.List()
+		require.Len(t, after, len(before))
+		for i := range before {
+			assert.Equal(t, before[i].ID, after[i].ID)
+			assert.Equal(t, before[i].Status, after[i].Status)
+			assert.True(t, before[i].Expires.Equal(after[i].Expires))
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		offline, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+
+		closed, err := NewOutbox(sender, NewProtocolHandler(sender), filepath.Join(t.TempDir(), "outbox.json"), time.Hour, nil)
+		require.NoError(t, err)
+		first, err := closed.SendChat(offline, "before close", 0)
+		require.NoError(t, err)
+		require.NoError(t, closed.Close())
+
+		// Deliveries under way have finished, and no new ones start
+		before, _ := closed.Status(first.ID)
+		second, err := closed.SendChat(offline, "after close", 0)
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		after, _ := closed.Status(first.ID)
+		assert.Equal(t, before, after)
+		m, _ := closed.Status(second.ID)
+		assert.Zero(t, m.Attempts)
+	})
+}
+
+func TestOutboxHandOff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	sender, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer sender.Close()
+	recipient, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer recipient.Close()
+
+	mailbox := NewMailbox(server, MailboxLimits{MaxPerPeer: 10, MaxPerSender: 10, MaxBytes: 1 << 20})
+	defer mailbox.Close()
+	require.NoError(t, connectNodes(ctx, sender, server))
+
+	outbox, err := NewOutbox(sender, NewProtocolHandler(sender), filepath.Join(t.TempDir(), "outbox.json"), time.Hour, []peer.ID{server.ID()})
+	require.NoError(t, err)
+	defer outbox.Close()
+
+	msg, err := outbox.SendChat(recipient.ID(), "left at the mailbox", 0)
+	require.NoError(t, err)
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		m, _ := outbox.Status(msg.ID)
+		return m.Status == OutboxHandedOff
+	}, 10*time.Second, 10*time.Millisecond))
+	m, _ := outbox.Status(msg.ID)
+	assert.Equal(t, server.ID(), m.Mailbox)
+
+	require.NoError(t, connectNodes(ctx, recipient, server))
+	letters, err := FetchLetters(ctx, recipient, server.ID())
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	letter, err := OpenLetter(recipient.Peerstore().PrivKey(recipient.ID()), letters[0])
+	require.NoError(t, err)
+	assert.Equal(t, "left at the mailbox", letter.Text)
+	assert.Equal(t, msg.ID, letter.ID)
+}
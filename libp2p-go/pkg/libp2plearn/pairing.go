@@ -0,0 +1,374 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PairingProtocol runs a PIN-keyed SPAKE2 handshake between two peers on
+	// the same network: A sends its share, B answers with its share and key
+	// confirmation, A sends its confirmation and B acknowledges the pairing
+	PairingProtocol = "/libp2p-learn/pair/1.0.0"
+
+	// PairingPINDigits is the length of a pairing PIN
+	PairingPINDigits = 6
+
+	// pairingMaxAttempts is how many wrong PINs end a pairing, so a PIN can't
+	// be guessed by trying them all
+	pairingMaxAttempts = 3
+
+	// pairingTimeout bounds one handshake
+	pairingTimeout = 10 * time.Second
+
+	// pairingRetryInterval is how often candidates are tried again
+	pairingRetryInterval = time.Second
+
+	// pairingAccepted acknowledges a successful handshake
+	pairingAccepted = 1
+)
+
+// pairingPIN is what pairing PINs look like
+var pairingPIN = regexp.MustCompile(fmt.Sprintf(`^[0-9]{%d}$`, PairingPINDigits))
+
+var (
+	// ErrPairingPIN is returned when a peer that is pairing rejected our PIN
+	ErrPairingPIN = errors.New("wrong pairing PIN")
+
+	// ErrPairingAttempts is returned when a pairing ends after too many
+	// wrong PINs
+	ErrPairingAttempts = errors.New("too many wrong pairing PINs")
+
+	// ErrPairingBusy is returned when a pairing is already waiting
+	ErrPairingBusy = errors.New("already pairing")
+
+	// errPairingNotReady is returned by peers that aren't pairing yet
+	errPairingNotReady = errors.New("peer isn't pairing")
+)
+
+// EvtPeerPaired is emitted on the host event bus when a peer proved it knows
+// our pairing PIN, or we proved we know its. Addr is the address it was
+// reached at.
+type EvtPeerPaired struct {
+	Peer peer.ID
+	Addr multiaddr.Multiaddr
+}
+
+// NewPairingPIN returns a random PIN to pair with
+func NewPairingPIN() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate pairing PIN: %w", err)
+	}
+	return fmt.Sprintf("%0*d", PairingPINDigits, n), nil
+}
+
+// checkPairingPIN rejects PINs that weren't made by NewPairingPIN
+func checkPairingPIN(pin string) error {
+	if !pairingPIN.MatchString(pin) {
+		return fmt.Errorf("invalid pairing PIN %q: it has %d digits", pin, PairingPINDigits)
+	}
+	return nil
+}
+
+// pairingSession is a PIN waiting for a peer to pair with it
+type pairingSession struct {
+	pin      string
+	failures int
+	done     chan struct{}
+	paired   peer.AddrInfo
+	err      error
+}
+
+// Pairing mutually authenticates two peers on the same network with a short
+// PIN that one of them shows and the other's user types, so devices can be
+// onboarded without copying multiaddrs. The PIN keys a SPAKE2 handshake, so
+// each try tests one PIN only and an eavesdropper learns nothing. Paired
+// peers are protected like pinned peers.
+type Pairing struct {
+	host    host.Host
+	emitter event.Emitter
+
+	mu      sync.Mutex
+	session *pairingSession
+}
+
+// NewPairing creates the pairing service and registers its protocol handler
+func NewPairing(h host.Host) (*Pairing, error) {
+	emitter, err := h.EventBus().Emitter(new(EvtPeerPaired))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pairing emitter: %w", err)
+	}
+
+	p := &Pairing{host: h, emitter: emitter}
+	h.SetStreamHandler(protocol.ID(PairingProtocol), RecoveryMiddleware(protocol.ID(PairingProtocol), p.handlePair))
+	logrus.WithField("protocol", PairingProtocol).Info("Registered pairing protocol")
+	return p, nil
+}
+
+// Close unregisters the pairing protocol
+func (p *Pairing) Close() error {
+	p.host.RemoveStreamHandler(protocol.ID(PairingProtocol))
+	return p.emitter.Close()
+}
+
+// Accept waits for a peer to pair with pin, and returns it. It fails after
+// pairingMaxAttempts wrong PINs.
+func (p *Pairing) Accept(ctx context.Context, pin string) (peer.AddrInfo, error) {
+	if err := checkPairingPIN(pin); err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	session := &pairingSession{pin: pin, done: make(chan struct{})}
+	p.mu.Lock()
+	if p.session != nil {
+		p.mu.Unlock()
+		return peer.AddrInfo{}, ErrPairingBusy
+	}
+	p.session = session
+	p.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		// A peer may have paired just now
+		if p.end(session, peer.AddrInfo{}, ctx.Err()) {
+			return peer.AddrInfo{}, fmt.Errorf("no peer paired: %w", ctx.Err())
+		}
+	case <-session.done:
+	}
+	if session.err != nil {
+		return peer.AddrInfo{}, session.err
+	}
+	p.paired(session.paired)
+	return session.paired, nil
+}
+
+// end ends a session with the peer that paired or the error that ended it,
+// unless it has ended already
+func (p *Pairing) end(session *pairingSession, paired peer.AddrInfo, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.session != session {
+		return false
+	}
+	p.session = nil
+	session.paired, session.err = paired, err
+	close(session.done)
+	return true
+}
+
+// fail counts a wrong PIN, ending the session after too many
+func (p *Pairing) fail(session *pairingSession, from peer.ID) {
+	p.mu.Lock()
+	session.failures++
+	failures := session.failures
+	p.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"peer":     from,
+		"attempts": failures,
+	}).Warn("Peer tried a wrong pairing PIN")
+	if failures >= pairingMaxAttempts {
+		p.end(session, peer.AddrInfo{}, ErrPairingAttempts)
+	}
+}
+
+// handlePair runs side B of the handshake for the current session. Peers
+// that stop after our confirmation count as wrong PINs, since they may have
+// learned that theirs was.
+func (p *Pairing) handlePair(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(pairingTimeout))
+
+	p.mu.Lock()
+	session := p.session
+	p.mu.Unlock()
+	if session == nil {
+		s.Reset()
+		return
+	}
+
+	remote := s.Conn().RemotePeer()
+	share := make([]byte, spake2MessageSize)
+	if _, err := io.ReadFull(s, share); err != nil {
+		s.Reset()
+		return
+	}
+	exchange, err := newSPAKE2(session.pin, remote, s.Conn().LocalPeer(), false)
+	if err == nil {
+		err = exchange.Finish(share)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed pairing handshake")
+		s.Reset()
+		return
+	}
+	if _, err := s.Write(append(exchange.Share(), exchange.Confirm()...)); err != nil {
+		s.Reset()
+		return
+	}
+
+	confirm := make([]byte, len(exchange.Confirm()))
+	if _, err := io.ReadFull(s, confirm); err != nil || exchange.Verify(confirm) != nil {
+		p.fail(session, remote)
+		s.Reset()
+		return
+	}
+	info := peer.AddrInfo{ID: remote, Addrs: []multiaddr.Multiaddr{s.Conn().RemoteMultiaddr()}}
+	if !p.end(session, info, nil) {
+		// Another peer paired first
+		s.Reset()
+		return
+	}
+	s.Write([]byte{pairingAccepted})
+}
+
+// Pair finds the peer showing pin among candidates, which is called again
+// every pairingRetryInterval for newly discovered peers, and pairs with it.
+// Peers that rejected the PIN aren't tried again.
+func (p *Pairing) Pair(ctx context.Context, pin string, candidates func() []peer.ID) (peer.AddrInfo, error) {
+	if err := checkPairingPIN(pin); err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	rejected := make(map[peer.ID]bool)
+	ticker := time.NewTicker(pairingRetryInterval)
+	defer ticker.Stop()
+	for {
+		for _, id := range candidates() {
+			if id == p.host.ID() || rejected[id] {
+				continue
+			}
+			info, err := p.try(ctx, id, pin)
+			switch {
+			case err == nil:
+				p.paired(info)
+				return info, nil
+			case errors.Is(err, ErrPairingPIN):
+				rejected[id] = true
+			default:
+				logrus.WithError(err).WithField("peer", id).Debug("Failed to pair with peer")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if len(rejected) > 0 {
+				return peer.AddrInfo{}, ErrPairingPIN
+			}
+			return peer.AddrInfo{}, fmt.Errorf("no peer is pairing on the local network: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// try runs side A of the handshake with a peer
+func (p *Pairing) try(ctx context.Context, id peer.ID, pin string) (peer.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, pairingTimeout)
+	defer cancel()
+
+	s, err := p.host.NewStream(ctx, id, protocol.ID(PairingProtocol))
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	exchange, err := newSPAKE2(pin, s.Conn().LocalPeer(), id, true)
+	if err != nil {
+		s.Reset()
+		return peer.AddrInfo{}, err
+	}
+	if _, err := s.Write(exchange.Share()); err != nil {
+		s.Reset()
+		return peer.AddrInfo{}, fmt.Errorf("failed to send share: %w", err)
+	}
+	reply := make([]byte, spake2MessageSize+len(exchange.Confirm()))
+	if _, err := io.ReadFull(s, reply); err != nil {
+		s.Reset()
+		return peer.AddrInfo{}, errPairingNotReady
+	}
+	if err := exchange.Finish(reply[:spake2MessageSize]); err != nil {
+		s.Reset()
+		return peer.AddrInfo{}, err
+	}
+	if err := exchange.Verify(reply[spake2MessageSize:]); err != nil {
+		s.Reset()
+		return peer.AddrInfo{}, ErrPairingPIN
+	}
+	if _, err := s.Write(exchange.Confirm()); err != nil {
+		s.Reset()
+		return peer.AddrInfo{}, fmt.Errorf("failed to send confirmation: %w", err)
+	}
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(s, ack); err != nil || ack[0] != pairingAccepted {
+		return peer.AddrInfo{}, fmt.Errorf("peer paired with someone else")
+	}
+	return peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{s.Conn().RemoteMultiaddr()}}, nil
+}
+
+// paired protects a paired peer and announces it
+func (p *Pairing) paired(info peer.AddrInfo) {
+	p.host.ConnManager().Protect(info.ID, pinnedTag)
+	p.emitter.Emit(EvtPeerPaired{Peer: info.ID, Addr: info.Addrs[0]})
+	logrus.WithFields(logrus.Fields{
+		"peer": info.ID,
+		"addr": info.Addrs[0],
+	}).Info("Paired with peer")
+}
+
+// AcceptPairing waits for a peer on the local network to pair with pin, and
+// pins it
+func (n *Node) AcceptPairing(ctx context.Context, pin string) (peer.AddrInfo, error) {
+	info, err := n.pairing.Accept(ctx, pin)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	n.pinPeer(info)
+	return info, nil
+}
+
+// Pair pairs with the peer found by mDNS that shows pin, and pins it
+func (n *Node) Pair(ctx context.Context, pin string) (peer.AddrInfo, error) {
+	if n.mdns == nil {
+		return peer.AddrInfo{}, fmt.Errorf("pairing needs mDNS discovery, enable it")
+	}
+	info, err := n.pairing.Pair(ctx, pin, n.mdns.Peers)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	n.pinPeer(info)
+	return info, nil
+}
+
+// pinPeer adds a paired peer to the pinned peers of the configuration, which
+// SaveConfig keeps for the next run
+func (n *Node) pinPeer(info peer.AddrInfo) {
+	addr := info.Addrs[0].Encapsulate(multiaddr.StringCast("/p2p/" + info.ID.String())).String()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	cfg := *n.Config()
+	if slices.Contains(cfg.PinnedPeers, addr) {
+		return
+	}
+	cfg.PinnedPeers = append(slices.Clip(cfg.PinnedPeers), addr)
+	n.cfg.Store(&cfg)
+}
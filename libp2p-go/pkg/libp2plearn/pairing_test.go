@@ -0,0 +1,138 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPAKE2(t *testing.T) {
+	exchange := func(t *testing.T, pinA, pinB string) (error, error) {
+		a, err := newSPAKE2(pinA, "a", "b", true)
+		require.NoError(t, err)
+		b, err := newSPAKE2(pinB, "a", "b", false)
+		require.NoError(t, err)
+		require.NoError(t, b.Finish(a.Share()))
+		require.NoError(t, a.Finish(b.Share()))
+		return a.Verify(b.Confirm()), b.Verify(a.Confirm())
+	}
+
+	t.Run("SamePIN", func(t *testing.T) {
+		errA, errB := exchange(t, "123456", "123456")
+		assert.NoError(t, errA)
+		assert.NoError(t, errB)
+	})
+
+	t.Run("OtherPIN", func(t *testing.T) {
+		errA, errB := exchange(t, "123456", "123457")
+		assert.ErrorIs(t, errA, errSPAKE2Confirm)
+		assert.ErrorIs(t, errB, errSPAKE2Confirm)
+	})
+
+	t.Run("InvalidShare", func(t *testing.T) {
+		a, err := newSPAKE2("123456", "a", "b", true)
+		require.NoError(t, err)
+		assert.Error(t, a.Finish(make([]byte, spake2MessageSize)))
+		assert.Error(t, a.Finish(a.Share()[1:]))
+
+		// p-1 has order 2, outside the subgroup
+		minusOne := spake2P.FillBytes(make([]byte, spake2MessageSize))
+		minusOne[spake2MessageSize-1]--
+		assert.Error(t, a.Finish(minusOne))
+	})
+}
+
+func TestPairing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	node1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node1.Close()
+
+	node2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node2.Close()
+
+	pairing1, err := NewPairing(node1)
+	require.NoError(t, err)
+	defer pairing1.Close()
+
+	pairing2, err := NewPairing(node2)
+	require.NoError(t, err)
+	defer pairing2.Close()
+
+	require.NoError(t, connectNodes(ctx, node1, node2))
+	candidates := func() []peer.ID { return []peer.ID{node1.ID()} }
+
+	t.Run("PIN", func(t *testing.T) {
+		pin, err := NewPairingPIN()
+		require.NoError(t, err)
+		assert.Len(t, pin, PairingPINDigits)
+		assert.NoError(t, checkPairingPIN(pin))
+		assert.Error(t, checkPairingPIN("12345"))
+		assert.Error(t, checkPairingPIN("12345a"))
+	})
+
+	t.Run("Paired", func(t *testing.T) {
+		sub, err := node2.EventBus().Subscribe(new(EvtPeerPaired))
+		require.NoError(t, err)
+		defer sub.Close()
+
+		accepted := make(chan peer.AddrInfo, 1)
+		go func() {
+			info, err := pairing1.Accept(ctx, "424242")
+			assert.NoError(t, err)
+			accepted <- info
+		}()
+
+		info, err := pairing2.Pair(ctx, "424242", candidates)
+		require.NoError(t, err)
+		assert.Equal(t, node1.ID(), info.ID)
+		assert.Equal(t, node2.ID(), (<-accepted).ID)
+		assert.True(t, node1.ConnManager().IsProtected(node2.ID(), pinnedTag))
+		assert.True(t, node2.ConnManager().IsProtected(node1.ID(), pinnedTag))
+
+		select {
+		case e := <-sub.Out():
+			assert.Equal(t, node1.ID(), e.(EvtPeerPaired).Peer)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for pairing event")
+		}
+	})
+
+	t.Run("WrongPIN", func(t *testing.T) {
+		accepted := make(chan error, 1)
+		go func() {
+			_, err := pairing1.Accept(ctx, "424242")
+			accepted <- err
+		}()
+
+		// Wait for the session before trying, so the tries count
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			pairing1.mu.Lock()
+			defer pairing1.mu.Unlock()
+			return pairing1.session != nil
+		}, 5*time.Second, 50*time.Millisecond))
+
+		for i := 0; i < pairingMaxAttempts; i++ {
+			_, err := pairing2.try(ctx, node1.ID(), "000000")
+			assert.ErrorIs(t, err, ErrPairingPIN)
+		}
+		assert.ErrorIs(t, <-accepted, ErrPairingAttempts)
+
+		_, err := pairing2.try(ctx, node1.ID(), "424242")
+		assert.ErrorIs(t, err, errPairingNotReady)
+	})
+
+	t.Run("NobodyPairing", func(t *testing.T) {
+		tctx, tcancel := context.WithTimeout(ctx, 2*pairingRetryInterval)
+		defer tcancel()
+		_, err := pairing2.Pair(tctx, "424242", candidates)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
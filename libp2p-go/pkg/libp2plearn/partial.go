@@ -0,0 +1,278 @@
+package libp2plearn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// transferChunkSize is the unit in which partially received files are
+// tracked. Files no larger than one chunk are simply sent again.
+const transferChunkSize = 1 << 20
+
+// partialState is the bitmap of the chunks of a file already on disk
+type partialState struct {
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Have      []byte    `json:"have"`
+	Updated   time.Time `json:"updated"`
+}
+
+// PartialStore keeps partially received files by their SHA-256 content hash,
+// so a transfer of the same content can resume where an interrupted one left
+// off, whichever peer sends it. Each file is a <hash>.data file written at
+// chunk offsets and a <hash>.json bitmap of the chunks already written.
+type PartialStore struct {
+	dir string
+
+	mu   sync.Mutex
+	open map[string]bool // hashes being written by a transfer
+}
+
+// NewPartialStore opens the store in dir, dropping partial files that haven't
+// been written to for maxAge (0 to keep them forever)
+func NewPartialStore(dir string, maxAge time.Duration) (*PartialStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create partial transfer directory: %w", err)
+	}
+	ps := &PartialStore{dir: dir, open: make(map[string]bool)}
+	if maxAge > 0 {
+		ps.prune(maxAge)
+	}
+	return ps, nil
+}
+
+// Have returns the bitmap of the chunks already received of the content with
+// this hash and size, or nil if none are
+func (ps *PartialStore) Have(hash string, size int64) []byte {
+	if validContentHash(hash) != nil {
+		return nil
+	}
+	state, err := ps.load(hash)
+	if err != nil || state.Size != size || state.ChunkSize != transferChunkSize {
+		return nil
+	}
+	for _, b := range state.Have {
+		if b != 0 {
+			return state.Have
+		}
+	}
+	return nil
+}
+
+// Open starts or resumes receiving the content with this hash and size. Only
+// one transfer at a time may write the same content.
+func (ps *PartialStore) Open(hash string, size int64) (*PartialFile, error) {
+	if err := validContentHash(hash); err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	if ps.open[hash] {
+		ps.mu.Unlock()
+		return nil, fmt.Errorf("content %s is already being received", hash[:12])
+	}
+	ps.open[hash] = true
+	ps.mu.Unlock()
+
+	state, err := ps.load(hash)
+	if err != nil || state.Size != size || state.ChunkSize != transferChunkSize {
+		// Nothing usable yet: start over
+		state = &partialState{
+			Size:      size,
+			ChunkSize: transferChunkSize,
+			Have:      make([]byte, (chunkCount(size)+7)/8),
+		}
+	}
+	f, err := os.OpenFile(ps.path(hash, ".data"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		ps.release(hash)
+		return nil, fmt.Errorf("failed to open partial file: %w", err)
+	}
+	return &PartialFile{store: ps, hash: hash, f: f, state: state}, nil
+}
+
+// prune removes partial files that haven't been written to for maxAge
+func (ps *PartialStore) prune(maxAge time.Duration) {
+	states, _ := filepath.Glob(filepath.Join(ps.dir, "*.json"))
+	for _, file := range states {
+		hash := strings.TrimSuffix(filepath.Base(file), ".json")
+		state, err := ps.load(hash)
+		if err == nil && time.Since(state.Updated) < maxAge {
+			continue
+		}
+		ps.remove(hash)
+		logrus.WithField("hash", hash).Debug("Dropped stale partial transfer")
+	}
+}
+
+// load reads the bitmap of a hash
+func (ps *PartialStore) load(hash string) (*partialState, error) {
+	data, err := os.ReadFile(ps.path(hash, ".json"))
+	if err != nil {
+		return nil, err
+	}
+	var state partialState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if len(state.Have) != (chunkCount(state.Size)+7)/8 {
+		return nil, fmt.Errorf("bitmap doesn't match the size")
+	}
+	return &state, nil
+}
+
+// remove deletes the data and bitmap of a hash
+func (ps *PartialStore) remove(hash string) {
+	os.Remove(ps.path(hash, ".data"))
+	os.Remove(ps.path(hash, ".json"))
+}
+
+// release lets another transfer open the hash
+func (ps *PartialStore) release(hash string) {
+	ps.mu.Lock()
+	delete(ps.open, hash)
+	ps.mu.Unlock()
+}
+
+func (ps *PartialStore) path(hash, ext string) string {
+	return filepath.Join(ps.dir, hash+ext)
+}
+
+// PartialFile is content being received into a PartialStore
+type PartialFile struct {
+	store *PartialStore
+	hash  string
+	f     *os.File
+	state *partialState
+}
+
+// Missing returns the indexes of the chunks not received yet, in order
+func (pf *PartialFile) Missing() []int {
+	return missingChunks(pf.state.Size, pf.state.Have)
+}
+
+// WriteChunk writes chunk i and records it as received once it is on disk
+func (pf *PartialFile) WriteChunk(i int, data []byte) error {
+	if i < 0 || i >= chunkCount(pf.state.Size) || int64(len(data)) != chunkLen(pf.state.Size, i) {
+		return fmt.Errorf("invalid chunk %d", i)
+	}
+	if _, err := pf.f.WriteAt(data, int64(i)*transferChunkSize); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := pf.f.Sync(); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	pf.state.Have[i/8] |= 1 << (i % 8)
+	return pf.save()
+}
+
+// Finish checks the received content against its hash and moves it to
+// target with the given mode and mtime. Content that doesn't match is
+// dropped, so the next transfer starts over.
+func (pf *PartialFile) Finish(target string, mode os.FileMode, mtime time.Time) error {
+	defer pf.store.release(pf.hash)
+	if missing := pf.Missing(); len(missing) > 0 {
+		pf.f.Close()
+		return fmt.Errorf("%d chunks are still missing", len(missing))
+	}
+
+	h := sha256.New()
+	_, err := io.Copy(h, io.NewSectionReader(pf.f, 0, pf.state.Size))
+	pf.f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to hash received content: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != pf.hash {
+		pf.store.remove(pf.hash)
+		return fmt.Errorf("received content doesn't match its hash")
+	}
+
+	data := pf.store.path(pf.hash, ".data")
+	if err := os.Truncate(data, pf.state.Size); err != nil {
+		return fmt.Errorf("failed to finish received content: %w", err)
+	}
+	if err := os.Chmod(data, mode); err != nil {
+		return fmt.Errorf("failed to finish received content: %w", err)
+	}
+	if err := os.Chtimes(data, mtime, mtime); err != nil {
+		return fmt.Errorf("failed to finish received content: %w", err)
+	}
+	if err := os.Rename(data, target); err != nil {
+		return fmt.Errorf("failed to move received content: %w", err)
+	}
+	os.Remove(pf.store.path(pf.hash, ".json"))
+	return nil
+}
+
+// Close stops writing, keeping what was received for a later transfer
+func (pf *PartialFile) Close() error {
+	defer pf.store.release(pf.hash)
+	return pf.f.Close()
+}
+
+// save writes the bitmap
+func (pf *PartialFile) save() error {
+	pf.state.Updated = time.Now()
+	data, err := json.Marshal(pf.state)
+	if err != nil {
+		return fmt.Errorf("failed to encode partial state: %w", err)
+	}
+
+	if err := writeFileAtomic(pf.store.path(pf.hash, ".json"), data, 0600); err != nil {
+		return fmt.Errorf("failed to write partial state: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// validContentHash checks that a hash is a hex SHA-256, which also keeps it
+// safe to use as a file name
+func validContentHash(hash string) error {
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size || hash != strings.ToLower(hash) {
+		return fmt.Errorf("invalid content hash %q", hash)
+	}
+	return nil
+}
+
+// chunkCount returns the number of chunks of content of this size
+func chunkCount(size int64) int {
+	return int((size + transferChunkSize - 1) / transferChunkSize)
+}
+
+// chunkLen returns the length of chunk i of content of this size
+func chunkLen(size int64, i int) int64 {
+	return min(transferChunkSize, size-int64(i)*transferChunkSize)
+}
+
+// missingChunks returns the indexes of the chunks not set in a bitmap
+func missingChunks(size int64, have []byte) []int {
+	var missing []int
+	for i := range chunkCount(size) {
+		if i/8 >= len(have) || have[i/8]&(1<<(i%8)) == 0 {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,117 @@
+package libp2plearn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialStore(t *testing.T) {
+	content := make([]byte, 2*transferChunkSize+10)
+	rand.Read(content)
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	size := int64(len(content))
+	chunk := func(i int) []byte {
+		return content[int64(i)*transferChunkSize : int64(i)*transferChunkSize+chunkLen(size, i)]
+	}
+
+	t.Run("ResumesChunks", func(t *testing.T) {
+		store, err := NewPartialStore(t.TempDir(), 0)
+		require.NoError(t, err)
+		assert.Nil(t, store.Have(hash, size))
+
+		pf, err := store.Open(hash, size)
+		require.NoError(t, err)
+		assert.Equal(t, []int{0, 1, 2}, pf.Missing())
+		require.NoError(t, pf.WriteChunk(1, chunk(1)))
+		assert.Error(t, pf.WriteChunk(2, chunk(1)), "chunks must have their exact length")
+		require.NoError(t, pf.Close())
+
+		assert.Equal(t, []byte{0b010}, store.Have(hash, size))
+		assert.Nil(t, store.Have(hash, size+1), "a different size is different content")
+
+		pf, err = store.Open(hash, size)
+		require.NoError(t, err)
+		assert.Equal(t, []int{0, 2}, pf.Missing())
+		assert.Error(t, pf.Finish(filepath.Join(t.TempDir(), "out"), 0644, time.Now()))
+
+		pf, err = store.Open(hash, size)
+		require.NoError(t, err)
+		require.NoError(t, pf.WriteChunk(0, chunk(0)))
+		require.NoError(t, pf.WriteChunk(2, chunk(2)))
+		target := filepath.Join(t.TempDir(), "out")
+		mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, pf.Finish(target, 0640, mtime))
+
+		data, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+		info, err := os.Stat(target)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		assert.True(t, info.ModTime().Equal(mtime))
+		assert.Nil(t, store.Have(hash, size))
+	})
+
+	t.Run("OneWriterPerHash", func(t *testing.T) {
+		store, err := NewPartialStore(t.TempDir(), 0)
+		require.NoError(t, err)
+		pf, err := store.Open(hash, size)
+		require.NoError(t, err)
+		_, err = store.Open(hash, size)
+		assert.ErrorContains(t, err, "already being received")
+		require.NoError(t, pf.Close())
+		pf, err = store.Open(hash, size)
+		require.NoError(t, err)
+		pf.Close()
+	})
+
+	t.Run("DropsContentNotMatchingHash", func(t *testing.T) {
+		store, err := NewPartialStore(t.TempDir(), 0)
+		require.NoError(t, err)
+		pf, err := store.Open(hash, size)
+		require.NoError(t, err)
+		for i := range chunkCount(size) {
+			require.NoError(t, pf.WriteChunk(i, make([]byte, chunkLen(size, i))))
+		}
+		assert.ErrorContains(t, pf.Finish(filepath.Join(t.TempDir(), "out"), 0644, time.Now()), "doesn't match")
+		assert.Nil(t, store.Have(hash, size))
+	})
+
+	t.Run("PrunesStaleFiles", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewPartialStore(dir, 0)
+		require.NoError(t, err)
+		pf, err := store.Open(hash, size)
+		require.NoError(t, err)
+		require.NoError(t, pf.WriteChunk(0, chunk(0)))
+		require.NoError(t, pf.Close())
+
+		store, err = NewPartialStore(dir, time.Hour)
+		require.NoError(t, err)
+		assert.NotNil(t, store.Have(hash, size))
+
+		// Nothing was written for longer than a nanosecond
+		store, err = NewPartialStore(dir, time.Nanosecond)
+		require.NoError(t, err)
+		assert.Nil(t, store.Have(hash, size))
+		_, err = os.Stat(store.path(hash, ".data"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("RejectsInvalidHash", func(t *testing.T) {
+		store, err := NewPartialStore(t.TempDir(), 0)
+		require.NoError(t, err)
+		_, err = store.Open("../../etc/passwd", size)
+		assert.ErrorContains(t, err, "invalid content hash")
+		assert.Nil(t, store.Have("../../etc/passwd", size))
+	})
+}
@@ -0,0 +1,227 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// peerRecord is a recently connected peer as stored on disk
+type peerRecord struct {
+	Addrs    []string  `json:"addrs"`
+	LastSeen time.Time `json:"last_seen"`
+	Goodbye  string    `json:"goodbye,omitempty"`
+}
+
+// PeerHistory remembers recently connected peers and their addresses across restarts
+type PeerHistory struct {
+	path     string
+	host     host.Host
+	goodbyes event.Subscription
+	mu       sync.Mutex
+	peers    map[peer.ID]*peerRecord
+}
+
+// LoadPeerHistory reads the peer history file, starting empty if it doesn't exist
+func LoadPeerHistory(path string) (*PeerHistory, error) {
+	history := &PeerHistory{
+		path:  path,
+		peers: make(map[peer.ID]*peerRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return history, nil
+		}
+		return nil, fmt.Errorf("failed to read peer history: %w", err)
+	}
+
+	var records map[string]*peerRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse peer history: %w", err)
+	}
+	for id, record := range records {
+		p, err := peer.Decode(id)
+		if err != nil {
+			logrus.WithField("peer", id).Warn("Skipping invalid peer in history")
+			continue
+		}
+		history.peers[p] = record
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file":  path,
+		"peers": len(history.peers),
+	}).Info("Loaded peer history")
+	return history, nil
+}
+
+// Track records every peer the host connects to and why peers said goodbye
+func (ph *PeerHistory) Track(h host.Host) error {
+	goodbyes, err := h.EventBus().Subscribe(new(EvtPeerGoodbye))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to goodbyes: %w", err)
+	}
+
+	ph.host = h
+	ph.goodbyes = goodbyes
+	h.Network().Notify(ph)
+
+	go func() {
+		for e := range goodbyes.Out() {
+			evt := e.(EvtPeerGoodbye)
+			ph.mu.Lock()
+			if record, ok := ph.peers[evt.Peer]; ok {
+				record.Goodbye = evt.Reason.String()
+			}
+			ph.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Close stops tracking connections
+func (ph *PeerHistory) Close() {
+	if ph.host == nil {
+		return
+	}
+	ph.host.Network().StopNotify(ph)
+	ph.goodbyes.Close()
+}
+
+// Save writes the peer history to disk
+func (ph *PeerHistory) Save() error {
+	ph.mu.Lock()
+	records := make(map[string]*peerRecord, len(ph.peers))
+	for p, record := range ph.peers {
+		records[p.String()] = record
+	}
+	ph.mu.Unlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode peer history: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ph.path), 0755); err != nil {
+		return fmt.Errorf("failed to create peer history directory: %w", err)
+	}
+
+	if err := writeFileAtomic(ph.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write peer history: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file":  ph.path,
+		"peers": len(records),
+	}).Debug("Saved peer history")
+	return nil
+}
+
+// Recent returns up to limit peers seen within maxAge, most recent first,
+// leaving out peers that banned us
+func (ph *PeerHistory) Recent(maxAge time.Duration, limit int) []peer.AddrInfo {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	type recent struct {
+		info     peer.AddrInfo
+		lastSeen time.Time
+	}
+
+	var peers []recent
+	for p, record := range ph.peers {
+		if time.Since(record.LastSeen) > maxAge || record.Goodbye == GoodbyeBanned.String() {
+			continue
+		}
+
+		info := peer.AddrInfo{ID: p}
+		for _, s := range record.Addrs {
+			if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+				info.Addrs = append(info.Addrs, addr)
+			}
+		}
+		if len(info.Addrs) > 0 {
+			peers = append(peers, recent{info: info, lastSeen: record.LastSeen})
+		}
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].lastSeen.After(peers[j].lastSeen)
+	})
+	if len(peers) > limit {
+		peers = peers[:limit]
+	}
+
+	infos := make([]peer.AddrInfo, 0, len(peers))
+	for _, r := range peers {
+		infos = append(infos, r.info)
+	}
+	return infos
+}
+
+// Connected records the peer and its known addresses
+func (ph *PeerHistory) Connected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+
+	addrs := []string{c.RemoteMultiaddr().String()}
+	if ph.host != nil {
+		for _, addr := range ph.host.Peerstore().Addrs(p) {
+			if s := addr.String(); s != addrs[0] {
+				addrs = append(addrs, s)
+			}
+		}
+	}
+
+	ph.mu.Lock()
+	ph.peers[p] = &peerRecord{Addrs: addrs, LastSeen: time.Now()}
+	ph.mu.Unlock()
+}
+
+// Disconnected refreshes the last seen time of the peer
+func (ph *PeerHistory) Disconnected(n network.Network, c network.Conn) {
+	ph.mu.Lock()
+	if record, ok := ph.peers[c.RemotePeer()]; ok {
+		record.LastSeen = time.Now()
+	}
+	ph.mu.Unlock()
+}
+
+func (ph *PeerHistory) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (ph *PeerHistory) ListenClose(network.Network, multiaddr.Multiaddr) {}
+
+// reconnectPeers re-establishes connections to recently seen peers, skipping
+// blocked ones and, with diversity set, those that would crowd a subnet
+func reconnectPeers(ctx context.Context, h host.Host, history *PeerHistory, blocklist *Blocklist, diversity *Diversity, cfg *Config) {
+	var targets []peer.AddrInfo
+	for _, info := range history.Recent(time.Duration(cfg.ReconnectMaxAge), cfg.ReconnectMax) {
+		if blocklist.IsBlocked(info.ID) {
+			continue
+		}
+		targets = append(targets, info)
+	}
+	targets = diversity.Filter(targets)
+	if len(targets) == 0 {
+		return
+	}
+
+	logrus.WithField("count", len(targets)).Info("Reconnecting to last-known peers")
+	connected := dialPeers(ctx, h, targets, cfg.ReconnectConcurrency, 0)
+	logrus.WithFields(logrus.Fields{
+		"targets":   len(targets),
+		"connected": connected,
+	}).Info("Reconnect to last-known peers completed")
+}
@@ -0,0 +1,70 @@
+package libp2plearn
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	node, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node.Close()
+
+	peer1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer peer2.Close()
+
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		history, err := LoadPeerHistory(path)
+		require.NoError(t, err)
+		history.Track(node)
+
+		require.NoError(t, connectNodes(ctx, node, peer1))
+		require.NoError(t, connectNodes(ctx, node, peer2))
+		require.NoError(t, WaitForPeerCount(ctx, node, 2, 10*time.Second))
+
+		require.NoError(t, history.Save())
+
+		loaded, err := LoadPeerHistory(path)
+		require.NoError(t, err)
+		recent := loaded.Recent(time.Hour, 10)
+		assert.Len(t, recent, 2)
+
+		assert.Len(t, loaded.Recent(time.Hour, 1), 1, "Limit should be respected")
+	})
+
+	t.Run("ReconnectSkipsBlocked", func(t *testing.T) {
+		history, err := LoadPeerHistory(path)
+		require.NoError(t, err)
+
+		blocklist, err := NewBlocklist([]string{peer2.ID().String()})
+		require.NoError(t, err)
+
+		cfg := DefaultConfig()
+		cfg.ListenPort = 0
+		restarted, err := createNodeFromConfig(ctx, cfg, libp2p.ConnectionGater(blocklist))
+		require.NoError(t, err)
+		defer restarted.Close()
+
+		reconnectPeers(ctx, restarted, history, blocklist, nil, cfg)
+
+		assert.Equal(t, network.Connected, restarted.Network().Connectedness(peer1.ID()))
+		assert.NotEqual(t, network.Connected, restarted.Network().Connectedness(peer2.ID()))
+	})
+}
@@ -0,0 +1,248 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// Address TTL classes: how long the peerstore keeps an address, by how it
+// was learned
+const (
+	AddrTTLDefault           = "default"            // learned from routing, e.g. DHT lookups
+	AddrTTLTemp              = "temp"               // short-lived, e.g. failed or one-off dials
+	AddrTTLRecentlyConnected = "recently_connected" // of a peer we were connected to
+	AddrTTLOwnObserved       = "own_observed"       // our own addresses as peers observe them
+)
+
+// peerstoreLastSeenKey is the peerstore metadata key holding the Unix time
+// a peer was last connected or first noticed by the GC
+const peerstoreLastSeenKey = "libp2p-learn/last-seen"
+
+// addrTTLs returns libp2p's default TTL for each class
+func addrTTLs() map[string]time.Duration {
+	return map[string]time.Duration{
+		AddrTTLDefault:           peerstore.AddressTTL,
+		AddrTTLTemp:              peerstore.TempAddrTTL,
+		AddrTTLRecentlyConnected: peerstore.RecentlyConnectedAddrTTL,
+		AddrTTLOwnObserved:       peerstore.OwnObservedAddrTTL,
+	}
+}
+
+// addrTTLPeerstore overrides the TTL classes for one peerstore. libp2p adds
+// addresses with a class's default TTL, so those are swapped for the
+// configured ones; any other TTL is kept.
+type addrTTLPeerstore struct {
+	peerstore.Peerstore
+	ttls map[time.Duration]time.Duration
+}
+
+// newAddrTTLPeerstore wraps ps to apply the configured TTL classes. Without
+// any it returns ps unchanged.
+func newAddrTTLPeerstore(ps peerstore.Peerstore, ttls map[string]Duration) peerstore.Peerstore {
+	if len(ttls) == 0 {
+		return ps
+	}
+	defaults := addrTTLs()
+	mapped := make(map[time.Duration]time.Duration, len(ttls))
+	for class, ttl := range ttls {
+		if d, ok := defaults[class]; ok {
+			mapped[d] = time.Duration(ttl)
+		}
+	}
+	return &addrTTLPeerstore{Peerstore: ps, ttls: mapped}
+}
+
+// ttl returns the configured TTL for a class default
+func (ps *addrTTLPeerstore) ttl(d time.Duration) time.Duration {
+	if ttl, ok := ps.ttls[d]; ok {
+		return ttl
+	}
+	return d
+}
+
+func (ps *addrTTLPeerstore) AddAddr(p peer.ID, addr multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddr(p, addr, ps.ttl(ttl))
+}
+
+func (ps *addrTTLPeerstore) AddAddrs(p peer.ID, addrs []multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddrs(p, addrs, ps.ttl(ttl))
+}
+
+func (ps *addrTTLPeerstore) SetAddr(p peer.ID, addr multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddr(p, addr, ps.ttl(ttl))
+}
+
+func (ps *addrTTLPeerstore) SetAddrs(p peer.ID, addrs []multiaddr.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddrs(p, addrs, ps.ttl(ttl))
+}
+
+func (ps *addrTTLPeerstore) UpdateAddrs(p peer.ID, oldTTL, newTTL time.Duration) {
+	ps.Peerstore.UpdateAddrs(p, ps.ttl(oldTTL), ps.ttl(newTTL))
+}
+
+// ConsumePeerRecord and GetPeerRecord keep the wrapped peerstore's signed
+// peer records available, which identify looks for
+func (ps *addrTTLPeerstore) ConsumePeerRecord(env *record.Envelope, ttl time.Duration) (bool, error) {
+	cab, ok := peerstore.GetCertifiedAddrBook(ps.Peerstore)
+	if !ok {
+		return false, errors.New("peerstore does not support peer records")
+	}
+	return cab.ConsumePeerRecord(env, ps.ttl(ttl))
+}
+
+func (ps *addrTTLPeerstore) GetPeerRecord(p peer.ID) *record.Envelope {
+	cab, ok := peerstore.GetCertifiedAddrBook(ps.Peerstore)
+	if !ok {
+		return nil
+	}
+	return cab.GetPeerRecord(p)
+}
+
+// PeerstoreGC keeps the peerstore from growing without bound: it drops
+// peers not seen for maxAge, then the longest unseen ones beyond maxPeers.
+// Connected peers are never dropped.
+type PeerstoreGC struct {
+	host     host.Host
+	maxAge   time.Duration
+	maxPeers int
+}
+
+// NewPeerstoreGC creates a peerstore GC and starts recording when peers are
+// seen. A zero maxAge or maxPeers disables that limit.
+func NewPeerstoreGC(h host.Host, maxAge time.Duration, maxPeers int) *PeerstoreGC {
+	gc := &PeerstoreGC{
+		host:     h,
+		maxAge:   maxAge,
+		maxPeers: maxPeers,
+	}
+	h.Network().Notify(gc)
+	return gc
+}
+
+// Run collects garbage every interval until ctx is done
+func (gc *PeerstoreGC) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			gc.Collect()
+		}
+	}
+}
+
+// Collect drops stale peers and peers beyond the cap, and returns how many
+// it dropped. Peers without a last seen time count as seen now, so peers
+// learned from routing get maxAge to prove useful.
+func (gc *PeerstoreGC) Collect() int {
+	ps := gc.host.Peerstore()
+	now := time.Now()
+
+	type candidate struct {
+		id       peer.ID
+		lastSeen time.Time
+	}
+	var kept []candidate
+	connected, removed := 0, 0
+	for _, p := range ps.Peers() {
+		if p == gc.host.ID() {
+			continue
+		}
+		if gc.host.Network().Connectedness(p) == network.Connected {
+			gc.touch(p, now)
+			connected++
+			continue
+		}
+		lastSeen, ok := gc.lastSeen(p)
+		if !ok {
+			gc.touch(p, now)
+			lastSeen = now
+		}
+		if gc.maxAge > 0 && now.Sub(lastSeen) > gc.maxAge {
+			gc.remove(p)
+			removed++
+			continue
+		}
+		kept = append(kept, candidate{id: p, lastSeen: lastSeen})
+	}
+
+	// Connected peers count toward the cap but are never dropped
+	left := connected + len(kept)
+	if excess := left - gc.maxPeers; gc.maxPeers > 0 && excess > 0 {
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].lastSeen.Before(kept[j].lastSeen)
+		})
+		for _, c := range kept[:min(excess, len(kept))] {
+			gc.remove(c.id)
+			removed++
+			left--
+		}
+	}
+
+	if removed > 0 {
+		logrus.WithFields(logrus.Fields{
+			"removed": removed,
+			"peers":   left,
+		}).Info("Collected peerstore garbage")
+	}
+	return removed
+}
+
+// lastSeen reads a peer's last seen time from the peerstore
+func (gc *PeerstoreGC) lastSeen(p peer.ID) (time.Time, bool) {
+	v, err := gc.host.Peerstore().Get(p, peerstoreLastSeenKey)
+	if err != nil {
+		return time.Time{}, false
+	}
+	unix, ok := v.(int64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// touch records a peer's last seen time in the peerstore, where it persists
+// with the peer
+func (gc *PeerstoreGC) touch(p peer.ID, t time.Time) {
+	if err := gc.host.Peerstore().Put(p, peerstoreLastSeenKey, t.Unix()); err != nil {
+		logrus.WithError(err).WithField("peer", p).Debug("Failed to record peer last seen")
+	}
+}
+
+// remove drops everything the peerstore knows about a peer. RemovePeer
+// leaves the addresses, so they are cleared first.
+func (gc *PeerstoreGC) remove(p peer.ID) {
+	gc.host.Peerstore().ClearAddrs(p)
+	gc.host.Peerstore().RemovePeer(p)
+}
+
+// Close stops recording when peers are seen
+func (gc *PeerstoreGC) Close() {
+	gc.host.Network().StopNotify(gc)
+}
+
+// Connected records the peer as seen
+func (gc *PeerstoreGC) Connected(_ network.Network, c network.Conn) {
+	gc.touch(c.RemotePeer(), time.Now())
+}
+
+// Disconnected records the peer as seen, so its age starts when it left
+func (gc *PeerstoreGC) Disconnected(_ network.Network, c network.Conn) {
+	gc.touch(c.RemotePeer(), time.Now())
+}
+
+func (gc *PeerstoreGC) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (gc *PeerstoreGC) ListenClose(network.Network, multiaddr.Multiaddr) {}
@@ -0,0 +1,120 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerstoreGC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h2.Close()
+	require.NoError(t, connectNodes(ctx, h1, h2))
+
+	gc := NewPeerstoreGC(h1, 24*time.Hour, 3)
+	defer gc.Close()
+
+	// addPeer adds an unconnected peer last seen age ago
+	addr := multiaddr.StringCast("/ip4/192.0.2.1/tcp/4001")
+	addPeer := func(age time.Duration) peer.ID {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		p, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+		h1.Peerstore().AddAddr(p, addr, peerstore.PermanentAddrTTL)
+		gc.touch(p, time.Now().Add(-age))
+		return p
+	}
+
+	stale := addPeer(48 * time.Hour)
+	oldest := addPeer(12 * time.Hour)
+	older := addPeer(6 * time.Hour)
+	recent := addPeer(time.Hour)
+
+	// The stale peer is too old; of the rest, the oldest goes to make room
+	// for the connected peer within the cap of three
+	assert.Equal(t, 2, gc.Collect())
+
+	peers := h1.Peerstore().Peers()
+	assert.NotContains(t, peers, stale)
+	assert.NotContains(t, peers, oldest)
+	assert.Contains(t, peers, older)
+	assert.Contains(t, peers, recent)
+	assert.Contains(t, peers, h2.ID())
+	assert.Empty(t, h1.Peerstore().Addrs(stale))
+
+	t.Run("connected peers are seen now", func(t *testing.T) {
+		lastSeen, ok := gc.lastSeen(h2.ID())
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now(), lastSeen, 2*time.Second)
+	})
+
+	t.Run("unknown peers start aging when noticed", func(t *testing.T) {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		p, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+		h1.Peerstore().AddAddr(p, addr, peerstore.PermanentAddrTTL)
+
+		assert.Equal(t, 1, gc.Collect()) // the cap drops the older peer
+		assert.Contains(t, h1.Peerstore().Peers(), p)
+		_, ok := gc.lastSeen(p)
+		assert.True(t, ok)
+	})
+}
+
+func TestPeerstoreConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PeerstoreAddrTTLs = map[string]Duration{AddrTTLTemp: Duration(time.Minute)}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.PeerstoreAddrTTLs = map[string]Duration{"forever": Duration(time.Minute)}
+	assert.Error(t, cfg.Validate())
+
+	cfg.PeerstoreAddrTTLs = map[string]Duration{AddrTTLTemp: 0}
+	assert.Error(t, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.PeerstoreMaxPeers = -1
+	assert.Error(t, cfg.Validate())
+
+	t.Run("TTL classes apply per node", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.PeerstoreAddrTTLs = map[string]Duration{AddrTTLTemp: Duration(50 * time.Millisecond)}
+		n1, err := New(WithConfig(cfg))
+		require.NoError(t, err)
+		defer n1.Stop(context.Background())
+		n2, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		defer n2.Stop(context.Background())
+
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		p, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+		addr := multiaddr.StringCast("/ip4/192.0.2.1/tcp/4001")
+		n1.Host().Peerstore().AddAddr(p, addr, peerstore.TempAddrTTL)
+		n2.Host().Peerstore().AddAddr(p, addr, peerstore.TempAddrTTL)
+
+		assert.Eventually(t, func() bool {
+			return len(n1.Host().Peerstore().Addrs(p)) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Len(t, n2.Host().Peerstore().Addrs(p), 1)
+		assert.Equal(t, 2*time.Minute, peerstore.TempAddrTTL)
+	})
+}
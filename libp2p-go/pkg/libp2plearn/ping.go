@@ -0,0 +1,102 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StandardPingProtocol is libp2p's own ping, which stock libp2p nodes
+	// answer. PingProtocol is this project's message ping.
+	StandardPingProtocol = ping.ID
+
+	// pingTimeout bounds one standard ping
+	pingTimeout = 10 * time.Second
+)
+
+// Ping measures the round-trip time to a peer with the standard libp2p ping.
+// The peerstore records it, smoothing it into the latency EWMA that ranks
+// peers for service discovery and downloads, and so does Latency.
+func (n *Node) Ping(ctx context.Context, p peer.ID) (time.Duration, error) {
+	rtt, err := pingPeer(ctx, n.host, p)
+	if err == nil {
+		n.activity.Bump(p, ActivityPing)
+		n.latency.Record(p, rtt)
+		n.transports.RecordRTT(p, rtt)
+	}
+	return rtt, err
+}
+
+// pingPeer sends one standard ping; ping.Ping records the RTT in the peerstore
+func pingPeer(ctx context.Context, h host.Host, p peer.ID) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	res, ok := <-ping.Ping(ctx, h, p)
+	if !ok {
+		return 0, fmt.Errorf("failed to ping %s: %w", p, ctx.Err())
+	}
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to ping %s: %w", p, res.Error)
+	}
+	return res.RTT, nil
+}
+
+// pingPeers pings every connected peer each interval until ctx is done, so
+// their latencies stay current, and calls answered with the round trip of
+// each that answers
+func pingPeers(ctx context.Context, h host.Host, interval time.Duration, answered func(peer.ID, time.Duration)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var wg sync.WaitGroup
+		for _, p := range h.Network().Peers() {
+			wg.Add(1)
+			go func(p peer.ID) {
+				defer wg.Done()
+				rtt, err := pingPeer(ctx, h, p)
+				if err != nil {
+					logrus.WithError(err).WithField("peer", p).Debug("Failed to ping peer")
+					return
+				}
+				answered(p, rtt)
+			}(p)
+		}
+		wg.Wait()
+	}
+}
+
+// fasterPeer reports whether a has a lower smoothed round-trip time than b.
+// Peers never measured are slower than any measured peer.
+func fasterPeer(m peerstore.Metrics, a, b peer.ID) bool {
+	la, lb := m.LatencyEWMA(a), m.LatencyEWMA(b)
+	switch {
+	case la == 0:
+		return false
+	case lb == 0:
+		return true
+	}
+	return la < lb
+}
+
+// sortByLatency orders peers fastest first, keeping the order of peers
+// never measured
+func sortByLatency(m peerstore.Metrics, peers []peer.ID) {
+	sort.SliceStable(peers, func(i, j int) bool { return fasterPeer(m, peers[i], peers[j]) })
+}
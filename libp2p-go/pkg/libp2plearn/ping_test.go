@@ -0,0 +1,53 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandardPing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node1, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer node1.Stop(ctx)
+
+	node2, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer node2.Stop(ctx)
+
+	assert.Contains(t, node2.Host().Mux().Protocols(), protocol.ID(StandardPingProtocol))
+	require.NoError(t, connectNodes(ctx, node1.Host(), node2.Host()))
+
+	rtt, err := node1.Ping(ctx, node2.Host().ID())
+	require.NoError(t, err)
+	assert.Positive(t, rtt)
+	assert.Positive(t, node1.Host().Peerstore().LatencyEWMA(node2.Host().ID()), "RTT should be recorded in the peerstore")
+}
+
+func TestSortByLatency(t *testing.T) {
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	slow, fast, unknown1, unknown2 := peer.ID("slow"), peer.ID("fast"), peer.ID("unknown1"), peer.ID("unknown2")
+	ps.RecordLatency(slow, 80*time.Millisecond)
+	ps.RecordLatency(fast, 5*time.Millisecond)
+
+	peers := []peer.ID{unknown1, slow, unknown2, fast}
+	sortByLatency(ps, peers)
+	assert.Equal(t, []peer.ID{fast, slow, unknown1, unknown2}, peers)
+
+	assert.True(t, fasterPeer(ps, fast, slow))
+	assert.True(t, fasterPeer(ps, slow, unknown1))
+	assert.False(t, fasterPeer(ps, unknown1, slow))
+	assert.False(t, fasterPeer(ps, unknown1, unknown2))
+}
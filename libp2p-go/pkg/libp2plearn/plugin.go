@@ -0,0 +1,762 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pluginStartTimeout bounds how long a plugin may take to answer init
+	pluginStartTimeout = 10 * time.Second
+
+	// pluginStopTimeout is how long a plugin has to exit once its stdin is
+	// closed before it is killed
+	pluginStopTimeout = 5 * time.Second
+
+	// pluginDialTimeout bounds a stream or connection a plugin asks for
+	pluginDialTimeout = 30 * time.Second
+
+	// Delay before restarting a plugin that exited, doubling while it keeps
+	// exiting soon after starting
+	pluginMinBackoff = time.Second
+	pluginMaxBackoff = time.Minute
+
+	// pluginChunkSize is the most stream data one message carries
+	pluginChunkSize = 32 * 1024
+
+	// maxPluginMessageSize bounds one line written by a plugin
+	maxPluginMessageSize = 1 << 20
+
+	// pluginStreamQueue is how many writes may wait for a slow stream
+	// before the plugin's messages stop being read
+	pluginStreamQueue = 64
+)
+
+// plugin message types
+const (
+	pluginMessageInit     = "init"      // host: who we are, sent once at start
+	pluginMessageReady    = "ready"     // plugin: the answer to init
+	pluginMessageOpen     = "open"      // host: a peer opened a stream for one of the plugin's protocols
+	pluginMessageData     = "data"      // either: stream data
+	pluginMessageClose    = "close"     // either: no more data on a stream in this direction
+	pluginMessageReset    = "reset"     // either: a stream was aborted
+	pluginMessageDial     = "dial"      // plugin: open a stream to a peer
+	pluginMessageDialed   = "dialed"    // host: the answer to dial
+	pluginMessagePeer     = "peer"      // plugin: a peer was discovered
+	pluginMessageDS       = "ds"        // host: a datastore request
+	pluginMessageDSResult = "ds_result" // plugin: the answer to a datastore request
+)
+
+// PluginManifest describes an external plugin: a program that talks to the
+// node over its stdin and stdout, one JSON message per line
+type PluginManifest struct {
+	Name      string            `json:"name"`
+	Command   string            `json:"command"` // relative paths are relative to the manifest
+	Args      []string          `json:"args"`
+	Env       map[string]string `json:"env"`
+	Protocols []string          `json:"protocols"` // stream protocols the plugin handles
+	Restart   bool              `json:"restart"`   // start the plugin again when it exits
+}
+
+// LoadPluginManifest reads a plugin manifest from a JSON file
+func LoadPluginManifest(path string) (*PluginManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	m := &PluginManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to decode plugin manifest %s: %w", path, err)
+	}
+	if m.Command != "" && !filepath.IsAbs(m.Command) && strings.ContainsRune(m.Command, filepath.Separator) {
+		m.Command = filepath.Join(filepath.Dir(path), m.Command)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Validate checks the manifest is complete
+func (m *PluginManifest) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("plugin name is required")
+	}
+	if m.Command == "" {
+		return fmt.Errorf("plugin command is required")
+	}
+	for _, proto := range m.Protocols {
+		if proto == "" {
+			return fmt.Errorf("plugin protocols must not be empty")
+		}
+	}
+	return nil
+}
+
+// pluginMessage is one line exchanged with a plugin. Which fields are set
+// depends on the type.
+type pluginMessage struct {
+	Type      string        `json:"type"`
+	Peer      string        `json:"peer,omitempty"`
+	Protocols []string      `json:"protocols,omitempty"`
+	Stream    uint64        `json:"stream,omitempty"`
+	Protocol  string        `json:"protocol,omitempty"`
+	Addrs     []string      `json:"addrs,omitempty"`
+	Data      []byte        `json:"data,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	ID        uint64        `json:"id,omitempty"`
+	Op        string        `json:"op,omitempty"`
+	Key       string        `json:"key,omitempty"`
+	Prefix    string        `json:"prefix,omitempty"`
+	KeysOnly  bool          `json:"keys_only,omitempty"`
+	Found     bool          `json:"found,omitempty"`
+	Size      int           `json:"size,omitempty"`
+	Entries   []pluginEntry `json:"entries,omitempty"`
+}
+
+// pluginEntry is one result of a datastore query
+type pluginEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	Size  int    `json:"size"`
+}
+
+// Plugin runs an external program that handles stream protocols, discovers
+// peers or stores data for the node. Streams are relayed to it as messages,
+// so it can be written in any language. It is started again after it exits
+// when its manifest asks for that.
+type Plugin struct {
+	manifest *PluginManifest
+	host     host.Host // nil for a plugin that only serves a datastore
+
+	nextStream  atomic.Uint64
+	nextRequest atomic.Uint64
+
+	mu     sync.Mutex
+	proc   *pluginProcess
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPlugin creates a plugin from its manifest. Nothing runs until Start.
+func NewPlugin(h host.Host, m *PluginManifest) *Plugin {
+	p := &Plugin{manifest: m, host: h}
+	p.nextStream.Store(1)
+	return p
+}
+
+// Name returns the plugin name from its manifest
+func (p *Plugin) Name() string {
+	return p.manifest.Name
+}
+
+// Protocols returns the protocols the plugin handles
+func (p *Plugin) Protocols() []protocol.ID {
+	protos := make([]protocol.ID, 0, len(p.manifest.Protocols))
+	for _, proto := range p.manifest.Protocols {
+		protos = append(protos, protocol.ID(proto))
+	}
+	return protos
+}
+
+// Running reports whether the plugin process is up
+func (p *Plugin) Running() bool {
+	return p.current() != nil
+}
+
+// Start launches the plugin and waits for it to be ready. It keeps running,
+// and is restarted if so configured, until the context is done or Close.
+func (p *Plugin) Start(ctx context.Context) error {
+	proc, err := p.launch()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	p.mu.Lock()
+	p.proc = proc
+	p.cancel = cancel
+	p.done = done
+	p.mu.Unlock()
+
+	go p.supervise(ctx, proc, done)
+	return nil
+}
+
+// Close stops the plugin
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	return nil
+}
+
+// HandleStream relays a stream opened by a peer to the plugin
+func (p *Plugin) HandleStream(s network.Stream) {
+	proc := p.current()
+	if proc == nil {
+		logrus.WithFields(logrus.Fields{
+			"plugin":   p.Name(),
+			"protocol": s.Protocol(),
+		}).Warn("Plugin is not running, dropping stream")
+		s.Reset()
+		return
+	}
+	id := p.nextStream.Add(2) - 2 // odd: chosen by us, even ones are the plugin's
+	ps := proc.addStream(id, s)
+	if ps == nil {
+		s.Reset()
+		return
+	}
+	err := proc.send(&pluginMessage{
+		Type:     pluginMessageOpen,
+		Stream:   id,
+		Protocol: string(s.Protocol()),
+		Peer:     s.Conn().RemotePeer().String(),
+	})
+	if err != nil {
+		ps.reset(false)
+		return
+	}
+	ps.relay()
+}
+
+// current returns the running plugin process, or nil
+func (p *Plugin) current() *pluginProcess {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.proc
+}
+
+// request sends a datastore request and waits for its result
+func (p *Plugin) request(ctx context.Context, msg *pluginMessage) (*pluginMessage, error) {
+	proc := p.current()
+	if proc == nil {
+		return nil, fmt.Errorf("plugin %s is not running", p.Name())
+	}
+	msg.Type = pluginMessageDS
+	msg.ID = p.nextRequest.Add(1)
+	res, err := proc.request(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.Name(), res.Error)
+	}
+	return res, nil
+}
+
+// supervise waits for the plugin to exit and starts it again if configured,
+// backing off while it keeps exiting
+func (p *Plugin) supervise(ctx context.Context, proc *pluginProcess, done chan struct{}) {
+	defer close(done)
+	log := logrus.WithField("plugin", p.Name())
+	backoff := pluginMinBackoff
+	for {
+		started := time.Now()
+		select {
+		case <-ctx.Done():
+			proc.stop()
+			p.setCurrent(nil)
+			return
+		case <-proc.exited:
+		}
+		p.setCurrent(nil)
+		if !p.manifest.Restart {
+			log.Warn("Plugin exited")
+			return
+		}
+		if time.Since(started) > pluginMaxBackoff {
+			backoff = pluginMinBackoff
+		}
+
+		for {
+			log.WithField("backoff", backoff).Warn("Plugin exited, restarting")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, pluginMaxBackoff)
+
+			var err error
+			if proc, err = p.launch(); err == nil {
+				break
+			}
+			log.WithError(err).Error("Failed to restart plugin")
+		}
+		p.setCurrent(proc)
+	}
+}
+
+// setCurrent records the running plugin process
+func (p *Plugin) setCurrent(proc *pluginProcess) {
+	p.mu.Lock()
+	p.proc = proc
+	p.mu.Unlock()
+}
+
+// launch starts the plugin program and waits for it to answer init
+func (p *Plugin) launch() (*pluginProcess, error) {
+	cmd := exec.Command(p.manifest.Command, p.manifest.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range p.manifest.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin stderr: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", p.Name(), err)
+	}
+
+	proc := &pluginProcess{
+		plugin:   p,
+		cmd:      cmd,
+		stdin:    stdin,
+		enc:      json.NewEncoder(stdin),
+		ready:    make(chan struct{}),
+		exited:   make(chan struct{}),
+		streams:  make(map[uint64]*pluginStream),
+		requests: make(map[uint64]chan *pluginMessage),
+	}
+	go proc.run(stdout, stderr)
+
+	hello := &pluginMessage{Type: pluginMessageInit, Protocols: p.manifest.Protocols}
+	if p.host != nil {
+		hello.Peer = p.host.ID().String()
+	}
+	if err := proc.send(hello); err != nil {
+		proc.stop()
+		return nil, fmt.Errorf("failed to initialize plugin %s: %w", p.Name(), err)
+	}
+	select {
+	case <-proc.ready:
+	case <-proc.exited:
+		return nil, fmt.Errorf("plugin %s exited during start", p.Name())
+	case <-time.After(pluginStartTimeout):
+		proc.stop()
+		return nil, fmt.Errorf("plugin %s did not become ready", p.Name())
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"plugin":    p.Name(),
+		"pid":       cmd.Process.Pid,
+		"protocols": p.manifest.Protocols,
+	}).Info("Started plugin")
+	return proc, nil
+}
+
+// pluginProcess is one run of a plugin program
+type pluginProcess struct {
+	plugin *Plugin
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	readyOnce sync.Once
+	ready     chan struct{}
+	exited    chan struct{}
+
+	mu       sync.Mutex
+	streams  map[uint64]*pluginStream
+	requests map[uint64]chan *pluginMessage
+	gone     bool
+}
+
+// send writes a message to the plugin
+func (pp *pluginProcess) send(msg *pluginMessage) error {
+	pp.writeMu.Lock()
+	defer pp.writeMu.Unlock()
+	if err := pp.enc.Encode(msg); err != nil {
+		return fmt.Errorf("failed to write to plugin: %w", err)
+	}
+	return nil
+}
+
+// stop closes the plugin's stdin, which asks it to exit, and kills it if it
+// does not
+func (pp *pluginProcess) stop() {
+	pp.stdin.Close()
+	select {
+	case <-pp.exited:
+	case <-time.After(pluginStopTimeout):
+		pp.cmd.Process.Kill()
+		<-pp.exited
+	}
+}
+
+// run reads the plugin's messages and logs until it exits, then drops its
+// streams and fails its pending requests
+func (pp *pluginProcess) run(stdout, stderr io.Reader) {
+	log := logrus.WithField("plugin", pp.plugin.Name())
+
+	var logged sync.WaitGroup
+	logged.Add(1)
+	go func() {
+		defer logged.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Info(scanner.Text())
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxPluginMessageSize)
+	for scanner.Scan() {
+		var msg pluginMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.WithError(err).Warn("Ignoring malformed plugin message")
+			continue
+		}
+		pp.handle(&msg)
+	}
+	if err := scanner.Err(); err != nil {
+		log.WithError(err).Error("Failed to read from plugin, killing it")
+		pp.cmd.Process.Kill()
+		io.Copy(io.Discard, stdout)
+	}
+	logged.Wait()
+
+	if err := pp.cmd.Wait(); err != nil {
+		log.WithError(err).Warn("Plugin process ended")
+	}
+
+	pp.mu.Lock()
+	pp.gone = true
+	streams := pp.streams
+	requests := pp.requests
+	pp.streams = nil
+	pp.requests = nil
+	pp.mu.Unlock()
+	for _, ps := range streams {
+		ps.reset(false)
+	}
+	for _, ch := range requests {
+		ch <- &pluginMessage{Type: pluginMessageDSResult, Error: "plugin exited"}
+	}
+	close(pp.exited)
+}
+
+// handle acts on one message from the plugin
+func (pp *pluginProcess) handle(msg *pluginMessage) {
+	switch msg.Type {
+	case pluginMessageReady:
+		pp.readyOnce.Do(func() { close(pp.ready) })
+	case pluginMessageData, pluginMessageClose:
+		if ps := pp.stream(msg.Stream); ps != nil {
+			ps.write(msg)
+		}
+	case pluginMessageReset:
+		if ps := pp.stream(msg.Stream); ps != nil {
+			ps.reset(false)
+		}
+	case pluginMessageDial:
+		go pp.dial(msg)
+	case pluginMessagePeer:
+		go pp.discovered(msg)
+	case pluginMessageDSResult:
+		pp.mu.Lock()
+		ch, ok := pp.requests[msg.ID]
+		delete(pp.requests, msg.ID)
+		pp.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	default:
+		logrus.WithFields(logrus.Fields{
+			"plugin": pp.plugin.Name(),
+			"type":   msg.Type,
+		}).Warn("Ignoring unknown plugin message")
+	}
+}
+
+// request sends a request and waits for the result with the same ID
+func (pp *pluginProcess) request(ctx context.Context, msg *pluginMessage) (*pluginMessage, error) {
+	ch := make(chan *pluginMessage, 1)
+	pp.mu.Lock()
+	if pp.gone {
+		pp.mu.Unlock()
+		return nil, fmt.Errorf("plugin %s is not running", pp.plugin.Name())
+	}
+	pp.requests[msg.ID] = ch
+	pp.mu.Unlock()
+
+	if err := pp.send(msg); err != nil {
+		pp.forget(msg.ID)
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-ctx.Done():
+		pp.forget(msg.ID)
+		return nil, ctx.Err()
+	}
+}
+
+// forget drops a pending request
+func (pp *pluginProcess) forget(id uint64) {
+	pp.mu.Lock()
+	delete(pp.requests, id)
+	pp.mu.Unlock()
+}
+
+// dial opens the stream a plugin asked for and tells it the outcome
+func (pp *pluginProcess) dial(msg *pluginMessage) {
+	fail := func(err error) {
+		pp.send(&pluginMessage{Type: pluginMessageDialed, Stream: msg.Stream, Error: err.Error()})
+	}
+	h := pp.plugin.host
+	if h == nil {
+		fail(errors.New("plugin has no host"))
+		return
+	}
+	if msg.Stream == 0 || msg.Stream%2 != 0 {
+		fail(errors.New("streams opened by plugins must have even, non-zero IDs"))
+		return
+	}
+	peerID, err := peer.Decode(msg.Peer)
+	if err != nil {
+		fail(fmt.Errorf("invalid peer %q: %w", msg.Peer, err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDialTimeout)
+	defer cancel()
+	s, err := h.NewStream(ctx, peerID, protocol.ID(msg.Protocol))
+	if err != nil {
+		fail(fmt.Errorf("failed to create stream: %w", err))
+		return
+	}
+	ps := pp.addStream(msg.Stream, s)
+	if ps == nil {
+		s.Reset()
+		fail(fmt.Errorf("stream %d is already open", msg.Stream))
+		return
+	}
+	if err := pp.send(&pluginMessage{Type: pluginMessageDialed, Stream: msg.Stream}); err != nil {
+		ps.reset(false)
+		return
+	}
+	ps.relay()
+}
+
+// discovered adds the addresses of a peer the plugin found and connects to it
+func (pp *pluginProcess) discovered(msg *pluginMessage) {
+	h := pp.plugin.host
+	if h == nil {
+		return
+	}
+	log := logrus.WithFields(logrus.Fields{"plugin": pp.plugin.Name(), "peer": msg.Peer})
+	peerID, err := peer.Decode(msg.Peer)
+	if err != nil {
+		log.WithError(err).Warn("Plugin discovered an invalid peer")
+		return
+	}
+	if peerID == h.ID() {
+		return
+	}
+	info := peer.AddrInfo{ID: peerID}
+	for _, s := range msg.Addrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			log.WithError(err).Warn("Plugin discovered an invalid address")
+			continue
+		}
+		info.Addrs = append(info.Addrs, addr)
+	}
+	h.Peerstore().AddAddrs(peerID, info.Addrs, peerstore.TempAddrTTL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDialTimeout)
+	defer cancel()
+	if err := h.Connect(ctx, info); err != nil {
+		log.WithError(err).Debug("Failed to connect to peer discovered by plugin")
+		return
+	}
+	log.Info("Connected to peer discovered by plugin")
+}
+
+// stream returns an open stream by ID
+func (pp *pluginProcess) stream(id uint64) *pluginStream {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.streams[id]
+}
+
+// addStream records a stream relayed to the plugin, or returns nil if the
+// ID is taken or the plugin is gone
+func (pp *pluginProcess) addStream(id uint64, s network.Stream) *pluginStream {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if pp.gone || pp.streams[id] != nil {
+		return nil
+	}
+	ps := &pluginStream{
+		proc:   pp,
+		id:     id,
+		s:      s,
+		writes: make(chan *pluginMessage, pluginStreamQueue),
+		done:   make(chan struct{}),
+	}
+	pp.streams[id] = ps
+	return ps
+}
+
+// pluginStream relays one stream between a peer and the plugin
+type pluginStream struct {
+	proc   *pluginProcess
+	id     uint64
+	s      network.Stream
+	writes chan *pluginMessage // data and close from the plugin, in order
+	done   chan struct{}
+
+	mu          sync.Mutex
+	closing     bool // the plugin sent close, anything after it is dropped
+	readDone    bool // the peer closed its side
+	writeClosed bool // the plugin's close was written
+	finished    bool
+}
+
+// relay copies the peer's data to the plugin and the plugin's to the peer
+// until both sides are closed or either resets
+func (ps *pluginStream) relay() {
+	go ps.writeLoop()
+
+	buf := make([]byte, pluginChunkSize)
+	for {
+		n, err := ps.s.Read(buf)
+		if n > 0 {
+			msg := &pluginMessage{Type: pluginMessageData, Stream: ps.id, Data: append([]byte(nil), buf[:n]...)}
+			if err := ps.proc.send(msg); err != nil {
+				ps.reset(false)
+				return
+			}
+		}
+		if err == io.EOF {
+			ps.proc.send(&pluginMessage{Type: pluginMessageClose, Stream: ps.id})
+			ps.mu.Lock()
+			ps.readDone = true
+			ps.mu.Unlock()
+			ps.finishIfClosed()
+			return
+		}
+		if err != nil {
+			ps.reset(true)
+			return
+		}
+	}
+}
+
+// writeLoop writes the plugin's data to the peer
+func (ps *pluginStream) writeLoop() {
+	for {
+		select {
+		case <-ps.done:
+			return
+		case msg := <-ps.writes:
+			if msg.Type == pluginMessageClose {
+				ps.s.CloseWrite()
+				ps.mu.Lock()
+				ps.writeClosed = true
+				ps.mu.Unlock()
+				ps.finishIfClosed()
+				return
+			}
+			if _, err := ps.s.Write(msg.Data); err != nil {
+				ps.reset(true)
+				return
+			}
+		}
+	}
+}
+
+// write queues data or a close from the plugin
+func (ps *pluginStream) write(msg *pluginMessage) {
+	ps.mu.Lock()
+	closing := ps.closing
+	ps.closing = closing || msg.Type == pluginMessageClose
+	ps.mu.Unlock()
+	if closing {
+		return
+	}
+	select {
+	case ps.writes <- msg:
+	case <-ps.done:
+	}
+}
+
+// reset aborts the stream, telling the plugin if it did not ask for it
+func (ps *pluginStream) reset(notify bool) {
+	if !ps.finish() {
+		return
+	}
+	ps.s.Reset()
+	if notify {
+		ps.proc.send(&pluginMessage{Type: pluginMessageReset, Stream: ps.id})
+	}
+}
+
+// finishIfClosed closes the stream once both sides are done with it
+func (ps *pluginStream) finishIfClosed() {
+	ps.mu.Lock()
+	closed := ps.readDone && ps.writeClosed
+	ps.mu.Unlock()
+	if closed && ps.finish() {
+		ps.s.Close()
+	}
+}
+
+// finish forgets the stream, reporting whether this call did
+func (ps *pluginStream) finish() bool {
+	ps.mu.Lock()
+	if ps.finished {
+		ps.mu.Unlock()
+		return false
+	}
+	ps.finished = true
+	ps.mu.Unlock()
+	close(ps.done)
+
+	ps.proc.mu.Lock()
+	if ps.proc.streams[ps.id] == ps {
+		delete(ps.proc.streams, ps.id)
+	}
+	ps.proc.mu.Unlock()
+	return true
+}
@@ -0,0 +1,255 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPluginProtocol = "/libp2p-learn/test-plugin/1.0.0"
+
+// TestPluginHelperProcess is the plugin the tests run, by running the test
+// binary again. It echoes streams, exits when a stream says "exit", keeps a
+// datastore in memory and reports the peer in TEST_PLUGIN_PEER.
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("LIBP2P_LEARN_TEST_PLUGIN") != "1" {
+		return
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(os.Stdout)
+	send := func(msg *pluginMessage) {
+		mu.Lock()
+		enc.Encode(msg)
+		mu.Unlock()
+	}
+	store := make(map[string][]byte)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, maxPluginMessageSize)
+	for scanner.Scan() {
+		var msg pluginMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			os.Exit(2)
+		}
+		switch msg.Type {
+		case pluginMessageInit:
+			if found := os.Getenv("TEST_PLUGIN_PEER"); found != "" {
+				id, addr, _ := strings.Cut(found, " ")
+				send(&pluginMessage{Type: pluginMessagePeer, Peer: id, Addrs: []string{addr}})
+			}
+			send(&pluginMessage{Type: pluginMessageReady})
+		case pluginMessageData:
+			if string(msg.Data) == "exit" {
+				os.Exit(1)
+			}
+			send(&pluginMessage{Type: pluginMessageData, Stream: msg.Stream, Data: msg.Data})
+		case pluginMessageClose:
+			send(&pluginMessage{Type: pluginMessageClose, Stream: msg.Stream})
+		case pluginMessageDS:
+			res := &pluginMessage{Type: pluginMessageDSResult, ID: msg.ID}
+			value, found := store[msg.Key]
+			switch msg.Op {
+			case pluginOpGet:
+				res.Found, res.Data = found, value
+			case pluginOpHas:
+				res.Found = found
+			case pluginOpGetSize:
+				res.Found, res.Size = found, len(value)
+			case pluginOpPut:
+				store[msg.Key] = msg.Data
+			case pluginOpDelete:
+				delete(store, msg.Key)
+			case pluginOpSync:
+			case pluginOpQuery:
+				for key, value := range store {
+					if strings.HasPrefix(key, strings.TrimSuffix(msg.Prefix, "/")+"/") {
+						res.Entries = append(res.Entries, pluginEntry{Key: key, Value: value, Size: len(value)})
+					}
+				}
+			default:
+				res.Error = "unknown operation " + msg.Op
+			}
+			send(res)
+		}
+	}
+	os.Exit(0)
+}
+
+// testPluginManifest returns a manifest that runs TestPluginHelperProcess
+func testPluginManifest(env map[string]string) *PluginManifest {
+	m := &PluginManifest{
+		Name:      "test",
+		Command:   os.Args[0],
+		Args:      []string{"-test.run=^TestPluginHelperProcess$"},
+		Env:       map[string]string{"LIBP2P_LEARN_TEST_PLUGIN": "1"},
+		Protocols: []string{testPluginProtocol},
+		Restart:   true,
+	}
+	for k, v := range env {
+		m.Env[k] = v
+	}
+	return m
+}
+
+func TestPluginManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugin.json")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"name":"weather","command":"./bin/weather","protocols":["/weather/1.0.0"]}`), 0o644))
+	m, err := LoadPluginManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "bin", "weather"), m.Command)
+	assert.Equal(t, []protocol.ID{"/weather/1.0.0"}, NewPlugin(nil, m).Protocols())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"name":"weather","command":"python3"}`), 0o644))
+	m, err = LoadPluginManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "python3", m.Command, "bare commands are looked up in PATH")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"name":"weather"}`), 0o644))
+	_, err = LoadPluginManifest(path)
+	assert.Error(t, err)
+}
+
+func TestPluginProtocol(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h2.Close()
+	require.NoError(t, connectNodes(ctx, h1, h2))
+
+	plugin := NewPlugin(h1, testPluginManifest(nil))
+	require.NoError(t, plugin.Start(ctx))
+	defer plugin.Close()
+	h1.SetStreamHandler(testPluginProtocol, plugin.HandleStream)
+
+	t.Run("relays streams", func(t *testing.T) {
+		s, err := h2.NewStream(ctx, h1.ID(), testPluginProtocol)
+		require.NoError(t, err)
+		_, err = s.Write([]byte("hello plugin"))
+		require.NoError(t, err)
+		require.NoError(t, s.CloseWrite())
+
+		reply, err := io.ReadAll(s)
+		require.NoError(t, err)
+		assert.Equal(t, "hello plugin", string(reply))
+		s.Close()
+	})
+
+	t.Run("restarts after exit", func(t *testing.T) {
+		s, err := h2.NewStream(ctx, h1.ID(), testPluginProtocol)
+		require.NoError(t, err)
+		_, err = s.Write([]byte("exit"))
+		require.NoError(t, err)
+		_, err = io.ReadAll(s)
+		assert.Error(t, err, "the stream is reset when the plugin exits")
+
+		require.NoError(t, WaitWithCondition(ctx, func() bool { return !plugin.Running() }, 5*time.Second, 10*time.Millisecond))
+		require.NoError(t, WaitWithCondition(ctx, plugin.Running, 10*time.Second, 50*time.Millisecond))
+
+		s, err = h2.NewStream(ctx, h1.ID(), testPluginProtocol)
+		require.NoError(t, err)
+		_, err = s.Write([]byte("again"))
+		require.NoError(t, err)
+		require.NoError(t, s.CloseWrite())
+		reply, err := io.ReadAll(s)
+		require.NoError(t, err)
+		assert.Equal(t, "again", string(reply))
+	})
+
+	t.Run("stops on close", func(t *testing.T) {
+		require.NoError(t, plugin.Close())
+		assert.False(t, plugin.Running())
+
+		s, err := h2.NewStream(ctx, h1.ID(), testPluginProtocol)
+		if err == nil {
+			_, err = io.ReadAll(s)
+		}
+		assert.Error(t, err)
+	})
+}
+
+func TestPluginDiscovery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h2.Close()
+
+	found := h2.ID().String() + " " + h2.Addrs()[0].String()
+	plugin := NewPlugin(h1, testPluginManifest(map[string]string{"TEST_PLUGIN_PEER": found}))
+	require.NoError(t, plugin.Start(ctx))
+	defer plugin.Close()
+
+	require.NoError(t, WaitForConnection(ctx, h1, h2, 10*time.Second))
+}
+
+func TestPluginDatastore(t *testing.T) {
+	ctx := context.Background()
+	data, err := json.Marshal(testPluginManifest(nil))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "datastore.json")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	cfg := DefaultConfig()
+	cfg.Datastore = DatastorePlugin
+	cfg.DatastorePlugin = path
+	require.NoError(t, cfg.Validate())
+	store, err := OpenDatastore(cfg)
+	require.NoError(t, err)
+	defer store.Close()
+
+	key := datastore.NewKey("/blobs/a")
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, datastore.ErrNotFound)
+
+	require.NoError(t, store.Put(ctx, key, []byte("one")))
+	require.NoError(t, store.Put(ctx, datastore.NewKey("/blobs/b"), []byte("two")))
+	require.NoError(t, store.Put(ctx, datastore.NewKey("/peers/c"), []byte("three")))
+
+	value, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(value))
+	has, err := store.Has(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, has)
+	size, err := store.GetSize(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	results, err := store.Query(ctx, query.Query{Prefix: "/blobs", Orders: []query.Order{query.OrderByKey{}}})
+	require.NoError(t, err)
+	entries, err := results.Rest()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/blobs/a", entries[0].Key)
+	assert.Equal(t, "/blobs/b", entries[1].Key)
+
+	require.NoError(t, store.Delete(ctx, key))
+	has, err = store.Has(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, has)
+}
@@ -0,0 +1,159 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+const (
+	// pinnedTag protects pinned peers from being pruned by the connection manager
+	pinnedTag = "pinned"
+
+	// prewarmRoutingWait bounds how long prewarming waits for the DHT routing table to fill
+	prewarmRoutingWait = 30 * time.Second
+)
+
+// parsePinnedPeers parses pinned peer multiaddrs, which must include /p2p/<peer ID>
+func parsePinnedPeers(addrs []string) ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	for _, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiaddr %s: %w", s, err)
+		}
+
+		info, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get peer info from %s: %w", s, err)
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// prewarmConnections opens connections to pinned peers and the DHT-closest
+// peers so the first user request doesn't pay for a cold dial. diversity,
+// if set, filters the closest peers.
+func prewarmConnections(ctx context.Context, h host.Host, kademliaDHT *discovery.DHT, cfg *Config, diversity *Diversity) {
+	start := time.Now()
+
+	pinned, err := parsePinnedPeers(cfg.PinnedPeers)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to parse pinned peers")
+		return
+	}
+	for _, info := range pinned {
+		h.ConnManager().Protect(info.ID, pinnedTag)
+	}
+
+	targets := pinned
+	if cfg.PrewarmClosest > 0 {
+		closest, err := closestPeers(ctx, h, kademliaDHT, cfg.PrewarmClosest)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to find closest peers to prewarm")
+		}
+		targets = append(targets, diversity.Filter(closest)...)
+	}
+
+	connected := dialPeers(ctx, h, targets, cfg.PrewarmConcurrency, time.Duration(cfg.PrewarmInterval))
+
+	logrus.WithFields(logrus.Fields{
+		"pinned":    len(pinned),
+		"targets":   len(targets),
+		"connected": connected,
+		"took":      time.Since(start),
+	}).Info("Connection prewarming completed")
+}
+
+// closestPeers waits for the routing table to fill and returns up to count of
+// the peers closest to our own ID
+func closestPeers(ctx context.Context, h host.Host, kademliaDHT *discovery.DHT, count int) ([]peer.AddrInfo, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, prewarmRoutingWait)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for kademliaDHT.RoutingTable().Size() == 0 {
+		select {
+		case <-waitCtx.Done():
+			return nil, fmt.Errorf("routing table is still empty")
+		case <-ticker.C:
+		}
+	}
+
+	ids, err := kademliaDHT.GetClosestPeers(waitCtx, string(h.ID()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closest peers: %w", err)
+	}
+	if len(ids) > count {
+		ids = ids[:count]
+	}
+
+	infos := make([]peer.AddrInfo, 0, len(ids))
+	for _, id := range ids {
+		infos = append(infos, h.Peerstore().PeerInfo(id))
+	}
+	return infos, nil
+}
+
+// dialPeers connects to the peers with at most concurrency dials in flight,
+// starting at most one dial per interval, and returns how many connected
+func dialPeers(ctx context.Context, h host.Host, peers []peer.AddrInfo, concurrency int, interval time.Duration) int {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		connected int
+	)
+
+	sem := make(chan struct{}, concurrency)
+	for i, info := range peers {
+		if info.ID == h.ID() {
+			continue
+		}
+
+		if i > 0 && interval > 0 {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return connected
+			case <-time.After(interval):
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return connected
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := h.Connect(ctx, info); err != nil {
+				logrus.WithError(err).WithField("peer", info.ID).Debug("Failed to prewarm connection")
+				return
+			}
+
+			mu.Lock()
+			connected++
+			mu.Unlock()
+			logrus.WithField("peer", info.ID).Debug("Prewarmed connection")
+		}(info)
+	}
+
+	wg.Wait()
+	return connected
+}
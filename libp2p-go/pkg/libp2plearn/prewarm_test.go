@@ -0,0 +1,49 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrewarm(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	node, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer node.Close()
+
+	t.Run("ParsePinnedPeers", func(t *testing.T) {
+		addr := fmt.Sprintf("%s/p2p/%s", node.Addrs()[0], node.ID())
+		infos, err := parsePinnedPeers([]string{addr})
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.Equal(t, node.ID(), infos[0].ID)
+
+		_, err = parsePinnedPeers([]string{node.Addrs()[0].String()})
+		assert.Error(t, err, "Pinned peers must include a peer ID")
+	})
+
+	t.Run("DialPeersConcurrently", func(t *testing.T) {
+		var targets []peer.AddrInfo
+		for i := 0; i < 3; i++ {
+			target, err := createNodeWithOptions(ctx, 0, false, false)
+			require.NoError(t, err)
+			defer target.Close()
+			targets = append(targets, peer.AddrInfo{ID: target.ID(), Addrs: target.Addrs()})
+		}
+
+		connected := dialPeers(ctx, node, targets, 2, 10*time.Millisecond)
+		assert.Equal(t, 3, connected)
+		for _, target := range targets {
+			assert.Equal(t, network.Connected, node.Network().Connectedness(target.ID))
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package libp2plearn
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrProtocolNotSupported is returned when opening a stream to a peer whose
+// identify doesn't list the protocol, without negotiating it
+var ErrProtocolNotSupported = errors.New("protocol not supported by peer")
+
+// ProtocolCache remembers the protocols connected peers listed in identify,
+// so streams they will never answer fail before any negotiation. Entries
+// are updated by identify push and dropped when the peer disconnects, since
+// it may come back running something else.
+type ProtocolCache struct {
+	mu    sync.RWMutex
+	peers map[peer.ID]map[protocol.ID]struct{} // peer -> protocols it listed
+	sub   event.Subscription
+}
+
+// NewProtocolCache creates an empty protocol cache
+func NewProtocolCache() *ProtocolCache {
+	return &ProtocolCache{peers: make(map[peer.ID]map[protocol.ID]struct{})}
+}
+
+// Track fills the cache from the host's identify events
+func (c *ProtocolCache) Track(h host.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerProtocolsUpdated),
+		new(event.EvtPeerConnectednessChanged),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to identify events: %w", err)
+	}
+	c.sub = sub
+
+	go func() {
+		for e := range sub.Out() {
+			switch evt := e.(type) {
+			case event.EvtPeerIdentificationCompleted:
+				c.Set(evt.Peer, evt.Protocols)
+			case event.EvtPeerProtocolsUpdated:
+				c.update(evt.Peer, evt.Added, evt.Removed)
+			case event.EvtPeerConnectednessChanged:
+				if evt.Connectedness != network.Connected && evt.Connectedness != network.Limited {
+					c.Forget(evt.Peer)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops tracking
+func (c *ProtocolCache) Close() {
+	if c.sub != nil {
+		c.sub.Close()
+	}
+}
+
+// Set replaces the protocols a peer supports
+func (c *ProtocolCache) Set(p peer.ID, protos []protocol.ID) {
+	supported := make(map[protocol.ID]struct{}, len(protos))
+	for _, proto := range protos {
+		supported[proto] = struct{}{}
+	}
+	c.mu.Lock()
+	c.peers[p] = supported
+	c.mu.Unlock()
+}
+
+// update applies an identify push. Peers not identified yet are left alone.
+func (c *ProtocolCache) update(p peer.ID, added, removed []protocol.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	supported, ok := c.peers[p]
+	if !ok {
+		return
+	}
+	for _, proto := range removed {
+		delete(supported, proto)
+	}
+	for _, proto := range added {
+		supported[proto] = struct{}{}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peer":    p,
+		"added":   added,
+		"removed": removed,
+	}).Debug("Updated cached protocols")
+}
+
+// Forget drops what we know about a peer
+func (c *ProtocolCache) Forget(p peer.ID) {
+	c.mu.Lock()
+	delete(c.peers, p)
+	c.mu.Unlock()
+}
+
+// Protocols returns the protocols a peer supports, sorted, and whether the
+// peer has been identified
+func (c *ProtocolCache) Protocols(p peer.ID) ([]protocol.ID, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	supported, ok := c.peers[p]
+	if !ok {
+		return nil, false
+	}
+	protos := make([]protocol.ID, 0, len(supported))
+	for proto := range supported {
+		protos = append(protos, proto)
+	}
+	sort.Slice(protos, func(i, j int) bool { return protos[i] < protos[j] })
+	return protos, true
+}
+
+// Supports reports whether a peer answers any of protos, counting a
+// versioned protocol as answered by a later minor version of it. A peer
+// that hasn't been identified may support anything. It is safe to call on
+// a nil ProtocolCache.
+func (c *ProtocolCache) Supports(p peer.ID, protos ...protocol.ID) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	supported, ok := c.peers[p]
+	if !ok {
+		return true
+	}
+	for _, want := range protos {
+		if _, ok := supported[want]; ok {
+			return true
+		}
+		for have := range supported {
+			if SemverMatch(have)(want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check returns ErrProtocolNotSupported if the peer answers none of protos
+func (c *ProtocolCache) check(p peer.ID, protos []protocol.ID) error {
+	if c.Supports(p, protos...) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s doesn't support %v", ErrProtocolNotSupported, p, protos)
+}
+
+// ProtocolCache returns the protocols connected peers listed in identify
+func (n *Node) ProtocolCache() *ProtocolCache {
+	return n.protoCache
+}
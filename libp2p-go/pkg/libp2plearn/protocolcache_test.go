@@ -0,0 +1,80 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtocolCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sender, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer sender.Close()
+
+	remote, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer remote.Close()
+
+	cache := NewProtocolCache()
+	require.NoError(t, cache.Track(sender))
+	defer cache.Close()
+	handler := NewProtocolHandler(sender)
+	handler.SetProtocolCache(cache)
+
+	require.NoError(t, connectNodes(ctx, sender, remote))
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		_, ok := cache.Protocols(remote.ID())
+		return ok
+	}, 5*time.Second, 50*time.Millisecond))
+
+	t.Run("FailsFast", func(t *testing.T) {
+		_, err := handler.SendPing(ctx, remote.ID(), "hello")
+		assert.ErrorIs(t, err, ErrProtocolNotSupported)
+		_, err = handler.SendEcho(ctx, remote.ID(), "hello")
+		assert.ErrorIs(t, err, ErrProtocolNotSupported)
+	})
+
+	t.Run("IdentifyPush", func(t *testing.T) {
+		remoteProtocols := NewProtocolHandler(remote)
+		remoteProtocols.SetupProtocols()
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return cache.Supports(remote.ID(), protocol.ID(PingProtocol))
+		}, 5*time.Second, 50*time.Millisecond))
+
+		reply, err := handler.SendPing(ctx, remote.ID(), "hello")
+		require.NoError(t, err)
+		assert.Equal(t, "pong: hello", reply)
+
+		require.NoError(t, remoteProtocols.Unregister(protocol.ID(PingProtocol)))
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return !cache.Supports(remote.ID(), protocol.ID(PingProtocol))
+		}, 5*time.Second, 50*time.Millisecond))
+		_, err = handler.SendPing(ctx, remote.ID(), "hello")
+		assert.ErrorIs(t, err, ErrProtocolNotSupported)
+	})
+
+	t.Run("MinorVersions", func(t *testing.T) {
+		p := peer.ID("versioned")
+		cache.Set(p, []protocol.ID{"/libp2p-learn/ping/1.2.0"})
+		assert.True(t, cache.Supports(p, "/libp2p-learn/ping/1.0.0"))
+		assert.False(t, cache.Supports(p, "/libp2p-learn/ping/1.3.0"))
+		assert.False(t, cache.Supports(p, "/libp2p-learn/ping/2.0.0"))
+		assert.True(t, cache.Supports("unidentified", "/libp2p-learn/ping/2.0.0"))
+	})
+
+	t.Run("ForgetsOnDisconnect", func(t *testing.T) {
+		require.NoError(t, sender.Network().ClosePeer(remote.ID()))
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			_, ok := cache.Protocols(remote.ID())
+			return !ok
+		}, 5*time.Second, 50*time.Millisecond))
+	})
+}
@@ -0,0 +1,432 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Protocol IDs
+	PingProtocol = "/libp2p-learn/ping/1.0.0"
+	ChatProtocol = "/libp2p-learn/chat/1.0.0"
+	EchoProtocol = "/libp2p-learn/echo/1.0.0"
+)
+
+// StreamOpener opens protocol streams to peers. host.Host implements it.
+type StreamOpener interface {
+	NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error)
+}
+
+// StreamMiddleware wraps the stream handler registered for a protocol
+type StreamMiddleware func(proto protocol.ID, next network.StreamHandler) network.StreamHandler
+
+// ProtocolHandler manages custom protocols for the node
+type ProtocolHandler struct {
+	host        host.Host
+	streams     StreamOpener
+	middlewares []StreamMiddleware
+
+	hooksMu        sync.RWMutex
+	messageHooks   []MessageHook
+	filters        []MessageFilter
+	sessionHandler func(*ChatSession)
+	secureChat     *SecureChat
+	movable        chatSessions // incoming chat sessions that may move
+
+	compressionMu sync.RWMutex
+	compression   map[protocol.ID]CompressionConfig
+
+	versionsMu sync.RWMutex
+	versions   map[string][]protocol.ID // protocol name -> registered versions, newest first
+
+	registryMu sync.Mutex
+	registry   map[protocol.ID]*registration
+
+	echoMaxSize atomic.Int64
+	latency     *LatencyTracker
+	cache       *ProtocolCache
+}
+
+// NewProtocolHandler creates a new protocol handler
+func NewProtocolHandler(h host.Host) *ProtocolHandler {
+	return &ProtocolHandler{
+		host:        h,
+		streams:     h,
+		middlewares: []StreamMiddleware{RecoveryMiddleware},
+	}
+}
+
+// SetStreamOpener changes how outgoing streams are opened, e.g. to schedule them across multiple paths
+func (p *ProtocolHandler) SetStreamOpener(o StreamOpener) {
+	p.streams = o
+}
+
+// SetLatencyTracker records the round trips of pings and small echoes
+func (p *ProtocolHandler) SetLatencyTracker(t *LatencyTracker) {
+	p.latency = t
+}
+
+// SetProtocolCache fails streams to peers whose identify doesn't list the
+// protocol with ErrProtocolNotSupported, before negotiating it
+func (p *ProtocolHandler) SetProtocolCache(c *ProtocolCache) {
+	p.cache = c
+}
+
+// StreamOpener returns how outgoing streams are currently opened
+func (p *ProtocolHandler) StreamOpener() StreamOpener {
+	return p.streams
+}
+
+// Use adds a middleware around every protocol handler registered afterwards.
+// Panic recovery is always the outermost, followed by middlewares in the order added.
+func (p *ProtocolHandler) Use(mw StreamMiddleware) {
+	p.middlewares = append(p.middlewares, mw)
+}
+
+// SetCompression sets how streams opened for each protocol are compressed,
+// keyed by protocol ID. Incoming streams may always be compressed.
+func (p *ProtocolHandler) SetCompression(cfg map[string]CompressionConfig) {
+	compression := make(map[protocol.ID]CompressionConfig, len(cfg))
+	for proto, c := range cfg {
+		compression[protocol.ID(proto)] = c
+	}
+	p.compressionMu.Lock()
+	p.compression = compression
+	p.compressionMu.Unlock()
+}
+
+// SetEchoMaxSize limits the payload accepted by the echo v2 handler (0 for unlimited)
+func (p *ProtocolHandler) SetEchoMaxSize(n int64) {
+	p.echoMaxSize.Store(n)
+}
+
+// OnMessage registers a hook that runs for every ping and chat message received,
+// including messages in chat sessions
+func (p *ProtocolHandler) OnMessage(fn MessageHook) {
+	p.hooksMu.Lock()
+	p.messageHooks = append(p.messageHooks, fn)
+	p.hooksMu.Unlock()
+}
+
+// AddFilter registers a filter that runs for every ping and chat message
+// received. Filters run in the order added, each on the message the
+// previous one returned, until one drops or answers it.
+func (p *ProtocolHandler) AddFilter(fn MessageFilter) {
+	p.hooksMu.Lock()
+	p.filters = append(p.filters, fn)
+	p.hooksMu.Unlock()
+}
+
+// filterMessage runs the filters on a received message
+func (p *ProtocolHandler) filterMessage(proto protocol.ID, from peer.ID, msg string) MessageAction {
+	p.hooksMu.RLock()
+	filters := append([]MessageFilter(nil), p.filters...)
+	p.hooksMu.RUnlock()
+
+	action := MessageAction{Message: strings.TrimSuffix(msg, "\n")}
+	for _, fn := range filters {
+		action = fn(proto, from, action.Message)
+		if action.Drop || action.Reply != "" {
+			break
+		}
+	}
+	return action
+}
+
+// notifyMessage runs the message hooks for a received message
+func (p *ProtocolHandler) notifyMessage(proto protocol.ID, from peer.ID, msg string) {
+	p.hooksMu.RLock()
+	hooks := append([]MessageHook(nil), p.messageHooks...)
+	p.hooksMu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(proto, from, strings.TrimSuffix(msg, "\n"))
+	}
+}
+
+// SetupProtocols registers all custom protocols. They can be unregistered
+// and registered again like protocols added with Register.
+func (p *ProtocolHandler) SetupProtocols() {
+	// Register ping protocol
+	p.register(protocol.ID(PingProtocol), p.handlePing, false)
+	logrus.WithField("protocol", PingProtocol).Info("Registered ping protocol")
+
+	// Register chat protocol
+	p.register(protocol.ID(ChatProtocol), p.handleChat, true)
+	p.register(protocol.ID(ChatSessionProtocol), p.handleChatSession, false)
+	p.register(protocol.ID(ChatResumeProtocol), p.handleChatResume, false)
+	logrus.WithField("protocols", []string{ChatProtocol, ChatSessionProtocol, ChatResumeProtocol}).Info("Registered chat protocol")
+
+	// Register echo protocol
+	p.register(protocol.ID(EchoProtocol), p.handleEcho, true)
+	p.register(protocol.ID(EchoV2Protocol), p.handleEchoV2, true)
+	logrus.WithField("protocols", []string{EchoProtocol, EchoV2Protocol}).Info("Registered echo protocol")
+}
+
+// Handle registers a stream handler for the protocol wrapped in the middlewares
+func (p *ProtocolHandler) Handle(proto protocol.ID, handler network.StreamHandler) {
+	p.host.SetStreamHandler(proto, p.wrap(proto, handler))
+}
+
+// handleCompressed registers the handler under the protocol's compressed IDs.
+// Middlewares see the protocol's plain ID and the stream as sent on the wire.
+func (p *ProtocolHandler) handleCompressed(proto protocol.ID, handler network.StreamHandler) {
+	decompress := func(s network.Stream) {
+		cs, err := compressStream(s)
+		if err != nil {
+			logrus.WithError(err).WithField("protocol", s.Protocol()).Error("Failed to set up stream compression")
+			s.Reset()
+			return
+		}
+		handler(cs)
+	}
+	for _, alg := range compressionAlgorithms {
+		p.host.SetStreamHandler(CompressedProtocol(proto, alg), p.wrap(proto, decompress))
+	}
+}
+
+// wrap wraps a handler in the middlewares
+func (p *ProtocolHandler) wrap(proto protocol.ID, handler network.StreamHandler) network.StreamHandler {
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		handler = p.middlewares[i](proto, handler)
+	}
+	return handler
+}
+
+// newStream opens a stream for the first of protos the peer supports, in
+// order of preference. Each protocol configured for compression is offered
+// compressed first when the first message is at least the minimum size.
+func (p *ProtocolHandler) newStream(ctx context.Context, peerID peer.ID, size int, protos ...protocol.ID) (network.Stream, error) {
+	if err := p.cache.check(peerID, protos); err != nil {
+		return nil, err
+	}
+
+	p.compressionMu.RLock()
+	pids := make([]protocol.ID, 0, 2*len(protos))
+	for _, proto := range protos {
+		if cfg, ok := p.compression[proto]; ok && size >= cfg.MinSize {
+			pids = append(pids, CompressedProtocol(proto, cfg.Algorithm))
+		}
+		pids = append(pids, proto)
+	}
+	p.compressionMu.RUnlock()
+
+	s, err := p.streams.NewStream(ctx, peerID, pids...)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := compressStream(s)
+	if err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return cs, nil
+}
+
+// handlePing handles incoming ping requests
+func (p *ProtocolHandler) handlePing(s network.Stream) {
+	defer s.Close()
+
+	peer := s.Conn().RemotePeer()
+	logrus.WithField("peer", peer).Debug("Received ping request")
+
+	// Read the ping message
+	reader := bufio.NewReader(s)
+	data, err := reader.ReadString('\n')
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read ping data")
+		return
+	}
+	action := p.filterMessage(protocol.ID(PingProtocol), peer, data)
+	if action.Drop {
+		logrus.WithField("peer", peer).Debug("Dropped filtered ping")
+		return
+	}
+	data = action.Message + "\n"
+	p.notifyMessage(protocol.ID(PingProtocol), peer, data)
+
+	// Send pong response
+	response := fmt.Sprintf("pong: %s", data)
+	if action.Reply != "" {
+		response = action.Reply + "\n"
+	}
+	writer := bufio.NewWriter(s)
+	_, err = writer.WriteString(response)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write pong response")
+		return
+	}
+	writer.Flush()
+
+	logrus.WithFields(logrus.Fields{
+		"peer": peer,
+		"data": data[:len(data)-1], // Remove newline
+	}).Info("Handled ping request")
+}
+
+// handleChat handles incoming chat messages
+func (p *ProtocolHandler) handleChat(s network.Stream) {
+	defer s.Close()
+
+	peer := s.Conn().RemotePeer()
+	logrus.WithField("peer", peer).Debug("Received chat connection")
+
+	reader := bufio.NewReader(s)
+	writer := bufio.NewWriter(s)
+
+	for {
+		// Read message
+		message, err := reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				logrus.WithError(err).Error("Failed to read chat message")
+			}
+			break
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"peer":    peer,
+			"message": message[:len(message)-1], // Remove newline
+		}).Info("Received chat message")
+		action := p.filterMessage(protocol.ID(ChatProtocol), peer, message)
+		if action.Drop {
+			logrus.WithField("peer", peer).Debug("Dropped filtered chat message")
+			break
+		}
+		message = action.Message + "\n"
+		p.notifyMessage(protocol.ID(ChatProtocol), peer, message)
+
+		// Echo the message back with timestamp
+		response := fmt.Sprintf("[%s] Echo: %s", time.Now().Format("15:04:05"), message)
+		if action.Reply != "" {
+			response = action.Reply + "\n"
+		}
+		_, err = writer.WriteString(response)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to write chat response")
+			break
+		}
+		writer.Flush()
+	}
+}
+
+// handleEcho handles incoming echo requests
+func (p *ProtocolHandler) handleEcho(s network.Stream) {
+	defer s.Close()
+
+	peer := s.Conn().RemotePeer()
+	logrus.WithField("peer", peer).Debug("Received echo connection")
+
+	// Simple echo - copy input to output
+	_, err := io.Copy(s, s)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to echo data")
+		return
+	}
+
+	logrus.WithField("peer", peer).Info("Handled echo request")
+}
+
+// SendPing sends a ping to a peer
+func (p *ProtocolHandler) SendPing(ctx context.Context, peerID peer.ID, message string) (string, error) {
+	s, err := p.NewVersionedStream(ctx, peerID, protocol.ID(PingProtocol))
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	// Send ping
+	start := time.Now()
+	writer := bufio.NewWriter(s)
+	_, err = writer.WriteString(message + "\n")
+	if err != nil {
+		return "", fmt.Errorf("failed to send ping: %w", err)
+	}
+	writer.Flush()
+
+	// Read pong
+	reader := bufio.NewReader(s)
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read pong: %w", err)
+	}
+	p.latency.Record(peerID, time.Since(start))
+
+	return response[:len(response)-1], nil // Remove newline
+}
+
+// SendChatMessage sends a chat message to a peer
+func (p *ProtocolHandler) SendChatMessage(ctx context.Context, peerID peer.ID, message string) (string, error) {
+	s, err := p.newStream(ctx, peerID, len(message), p.candidateVersions(peerID, protocol.ID(ChatProtocol))...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	writer := bufio.NewWriter(s)
+	reader := bufio.NewReader(s)
+
+	// Send message
+	_, err = writer.WriteString(message + "\n")
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+	writer.Flush()
+
+	// Read response
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return response[:len(response)-1], nil // Remove newline
+}
+
+// SendEcho sends data to a peer's echo service and returns what came back.
+// Peers that support echo v2 verify the data on the way; older peers get the
+// original protocol.
+func (p *ProtocolHandler) SendEcho(ctx context.Context, peerID peer.ID, data string) (string, error) {
+	// A handler that serves no echo version still speaks both
+	candidates := p.candidateVersions(peerID, protocol.ID(EchoV2Protocol))
+	if !slices.Contains(candidates, protocol.ID(EchoProtocol)) {
+		candidates = append(candidates, protocol.ID(EchoProtocol))
+	}
+	s, err := p.newStream(ctx, peerID, len(data), candidates...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	var response string
+	if baseProtocol(s.Protocol()) == protocol.ID(EchoProtocol) {
+		if response, err = sendEchoV1(s, data); err != nil {
+			return "", err
+		}
+	} else {
+		var b strings.Builder
+		b.Grow(len(data))
+		if _, err := echoV2(ctx, s, strings.NewReader(data), &b); err != nil {
+			return "", err
+		}
+		response = b.String()
+	}
+	// Larger echoes measure throughput more than latency
+	if len(data) <= echoLatencyMaxSize {
+		p.latency.Record(peerID, time.Since(start))
+	}
+	return response, nil
+}
@@ -0,0 +1,142 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	libp2pwebrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+)
+
+// errProxyDNS is returned for DNS multiaddrs in strict proxy mode
+var errProxyDNS = errors.New("DNS multiaddrs can't be dialed with proxy_strict: resolving them would bypass the proxy")
+
+// proxyURL returns the SOCKS5 URL for the configured proxy
+func proxyURL(cfg *Config) *url.URL {
+	u := &url.URL{Scheme: "socks5", Host: cfg.ProxyAddr}
+	if cfg.ProxyUsername != "" {
+		u.User = url.UserPassword(cfg.ProxyUsername, cfg.ProxyPassword)
+	}
+	return u
+}
+
+// newProxyDialer creates a SOCKS5 dialer for the configured proxy
+func newProxyDialer(cfg *Config) (proxy.ContextDialer, error) {
+	var auth *proxy.Auth
+	if cfg.ProxyUsername != "" {
+		auth = &proxy.Auth{User: cfg.ProxyUsername, Password: cfg.ProxyPassword}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", cfg.ProxyAddr, auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+
+	ctxDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("SOCKS5 dialer does not support contexts")
+	}
+	return ctxDialer, nil
+}
+
+// proxyOptions returns the transport options that route outbound TCP and
+// WebSocket dials through the configured SOCKS5 proxy
+func proxyOptions(cfg *Config) ([]libp2p.Option, error) {
+	if cfg.ProxyAddr == "" {
+		return nil, nil
+	}
+
+	// Proxied dials keep the proxy's socket options; direct ones get ours
+	var dialer tcp.ContextDialer
+	if cfg.ProxyTCP {
+		proxyDialer, err := newProxyDialer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		dialer = proxyDialer
+	} else if cfg.TCPTuned() {
+		dialer = newTCPDialer(cfg)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"proxy":     cfg.ProxyAddr,
+		"tcp":       cfg.ProxyTCP,
+		"websocket": cfg.ProxyWebSocket,
+		"strict":    cfg.ProxyStrict,
+	}).Info("Outbound proxy enabled")
+
+	opts := transportOptions(cfg, dialer)
+	if cfg.ProxyStrict {
+		opts = append(opts, libp2p.MultiaddrResolver(noDNSResolver{}))
+	}
+	return opts, nil
+}
+
+// transportOptions lists the transports, with TCP dialing through dialer if
+// it isn't nil. Specifying any transport replaces the libp2p defaults, so
+// list them all.
+func transportOptions(cfg *Config, dialer tcp.ContextDialer) []libp2p.Option {
+	var tcpOpts []interface{}
+	if dialer != nil {
+		tcpOpts = append(tcpOpts, tcp.WithDialerForAddr(func(raddr multiaddr.Multiaddr) (tcp.ContextDialer, error) {
+			return dialer, nil
+		}))
+	}
+
+	opts := []libp2p.Option{
+		libp2p.Transport(tcp.NewTCPTransport, tcpOpts...),
+		websocketTransport(cfg),
+	}
+	if !cfg.ProxyStrict {
+		// These transports run over UDP and always dial directly
+		opts = append(opts,
+			libp2p.Transport(quic.NewTransport),
+			libp2p.Transport(webtransport.New),
+			libp2p.Transport(libp2pwebrtc.New),
+		)
+	}
+	return opts
+}
+
+// websocketTransport returns the WebSocket transport, dialing through the
+// proxy if configured
+func websocketTransport(cfg *Config) libp2p.Option {
+	if cfg.ProxyAddr == "" || !cfg.ProxyWebSocket {
+		return libp2p.Transport(ws.New)
+	}
+	return libp2p.Transport(newProxyWebSocketTransport(cfg))
+}
+
+// noDNSResolver refuses to resolve DNS multiaddrs, so no lookup leaves the
+// host outside the proxy
+type noDNSResolver struct{}
+
+func (noDNSResolver) ResolveDNSAddr(context.Context, peer.ID, multiaddr.Multiaddr, int, int) ([]multiaddr.Multiaddr, error) {
+	return nil, errProxyDNS
+}
+
+func (noDNSResolver) ResolveDNSComponent(context.Context, multiaddr.Multiaddr, int) ([]multiaddr.Multiaddr, error) {
+	return nil, errProxyDNS
+}
+
+// filterProxiableAddrs drops addresses for transports that cannot be proxied
+func filterProxiableAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	var filtered []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		if _, err := addr.ValueForProtocol(multiaddr.P_UDP); err == nil {
+			continue
+		}
+		filtered = append(filtered, addr)
+	}
+	return filtered
+}
@@ -0,0 +1,185 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socks5Server is a minimal SOCKS5 proxy without authentication that
+// records the targets of the connections it forwards
+type socks5Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSOCKS5Server(t *testing.T) *socks5Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &socks5Server{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, methods; accept "no authentication"
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// Request: version, CONNECT, reserved, address type, address, port
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestProxy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	serverCfg := testNodeConfig()
+	serverCfg.EnableWebSocket = true
+	server, err := New(WithConfig(serverCfg))
+	require.NoError(t, err)
+	defer server.Stop(ctx)
+
+	var wsAddr multiaddr.Multiaddr
+	for _, addr := range server.Host().Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_WS); err == nil {
+			if _, err := addr.ValueForProtocol(multiaddr.P_IP4); err == nil {
+				wsAddr = addr
+				break
+			}
+		}
+	}
+	require.NotNil(t, wsAddr, "server should listen on WebSocket")
+	wsTarget, err := wsAddr.ValueForProtocol(multiaddr.P_TCP)
+	require.NoError(t, err)
+
+	proxy := newSOCKS5Server(t)
+	proxyCfg := func() *Config {
+		cfg := testNodeConfig()
+		cfg.EnableWebSocket = true
+		cfg.ProxyAddr = proxy.listener.Addr().String()
+		cfg.ProxyTCP = false
+		cfg.ProxyWebSocket = true
+		return cfg
+	}
+
+	t.Run("WebSocketThroughProxy", func(t *testing.T) {
+		client, err := New(WithConfig(proxyCfg()))
+		require.NoError(t, err)
+		defer client.Stop(ctx)
+
+		err = client.Host().Connect(ctx, peer.AddrInfo{ID: server.Host().ID(), Addrs: []multiaddr.Multiaddr{wsAddr}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"127.0.0.1:" + wsTarget}, proxy.Targets())
+		conns := client.Host().Network().ConnsToPeer(server.Host().ID())
+		require.Len(t, conns, 1)
+		assert.True(t, conns[0].RemoteMultiaddr().Equal(wsAddr), "the remote address should be the peer's, not the proxy's")
+		assert.Equal(t, "websocket", conns[0].ConnState().Transport)
+	})
+
+	t.Run("OtherHostsDialDirectly", func(t *testing.T) {
+		before := len(proxy.Targets())
+		other, err := New(WithConfig(testNodeConfig()))
+		require.NoError(t, err)
+		defer other.Stop(ctx)
+
+		err = other.Host().Connect(ctx, peer.AddrInfo{ID: server.Host().ID(), Addrs: []multiaddr.Multiaddr{wsAddr}})
+		require.NoError(t, err)
+		assert.Len(t, proxy.Targets(), before)
+	})
+
+	t.Run("StrictRefusesDNS", func(t *testing.T) {
+		cfg := proxyCfg()
+		cfg.ProxyTCP = true
+		cfg.ProxyStrict = true
+		client, err := New(WithConfig(cfg))
+		require.NoError(t, err)
+		defer client.Stop(ctx)
+
+		before := len(proxy.Targets())
+		dnsAddr := multiaddr.StringCast(fmt.Sprintf("/dns4/localhost/tcp/%s/ws", wsTarget))
+		err = client.Host().Connect(ctx, peer.AddrInfo{ID: server.Host().ID(), Addrs: []multiaddr.Multiaddr{dnsAddr}})
+		assert.Error(t, err)
+		assert.Len(t, proxy.Targets(), before)
+	})
+}
@@ -0,0 +1,252 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcpreuse"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// proxyWebSocketHandshakeTimeout bounds the WebSocket handshake, as libp2p's
+// own transport does
+const proxyWebSocketHandshakeTimeout = 15 * time.Second
+
+// proxyWebSocketTransport is the libp2p WebSocket transport dialing through
+// a SOCKS5 proxy of its own. The libp2p transport only knows the proxy of
+// gorilla's default dialer, which every host in the process shares.
+type proxyWebSocketTransport struct {
+	*ws.WebsocketTransport
+	upgrader transport.Upgrader
+	rcmgr    network.ResourceManager
+	dialer   websocket.Dialer
+}
+
+// newProxyWebSocketTransport returns a constructor for libp2p.Transport
+// creating a WebSocket transport that dials through the configured proxy
+func newProxyWebSocketTransport(cfg *Config) func(transport.Upgrader, network.ResourceManager, *tcpreuse.ConnMgr) (*proxyWebSocketTransport, error) {
+	return func(u transport.Upgrader, rcmgr network.ResourceManager, sharedTCP *tcpreuse.ConnMgr) (*proxyWebSocketTransport, error) {
+		t, err := ws.New(u, rcmgr, sharedTCP)
+		if err != nil {
+			return nil, err
+		}
+		if rcmgr == nil {
+			rcmgr = &network.NullResourceManager{}
+		}
+		return &proxyWebSocketTransport{
+			WebsocketTransport: t,
+			upgrader:           u,
+			rcmgr:              rcmgr,
+			dialer: websocket.Dialer{
+				Proxy:            http.ProxyURL(proxyURL(cfg)),
+				HandshakeTimeout: proxyWebSocketHandshakeTimeout,
+			},
+		}, nil
+	}
+}
+
+// Dial connects to the peer through the proxy
+func (t *proxyWebSocketTransport) Dial(ctx context.Context, raddr multiaddr.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	scope, err := t.rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := t.dial(ctx, raddr, p, scope)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *proxyWebSocketTransport) dial(ctx context.Context, raddr multiaddr.Multiaddr, p peer.ID, scope network.ConnManagementScope) (transport.CapableConn, error) {
+	u, sni, err := websocketURL(raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Name the server for TLS and the Host header but still dial the address
+	dialer := t.dialer
+	var header http.Header
+	if sni != "" {
+		dialer.TLSClientConfig = &tls.Config{ServerName: sni}
+		header = http.Header{"Host": {net.JoinHostPort(sni, u.Port())}}
+	}
+	raw, _, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := newWebSocketConn(raw, raddr, u.Scheme == "wss")
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	capable, err := t.upgrader.Upgrade(ctx, t, conn, network.DirOutbound, p, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &webSocketCapableConn{CapableConn: capable}, nil
+}
+
+// websocketURL returns the URL to dial a WebSocket multiaddr at, and the TLS
+// server name of /tls/sni addresses
+func websocketURL(raddr multiaddr.Multiaddr) (*url.URL, string, error) {
+	var host, port, sni, scheme string
+	for _, c := range raddr {
+		switch c.Protocol().Code {
+		case multiaddr.P_IP4, multiaddr.P_IP6, multiaddr.P_DNS, multiaddr.P_DNS4, multiaddr.P_DNS6:
+			host = c.Value()
+		case multiaddr.P_TCP:
+			port = c.Value()
+		case multiaddr.P_SNI:
+			sni = c.Value()
+		case multiaddr.P_TLS, multiaddr.P_WSS:
+			scheme = "wss"
+		case multiaddr.P_WS:
+			if scheme == "" {
+				scheme = "ws"
+			}
+		}
+	}
+	if host == "" || port == "" || scheme == "" {
+		return nil, "", fmt.Errorf("not a WebSocket address: %s", raddr)
+	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port)}, sni, nil
+}
+
+// webSocketCapableConn reports the transport of an upgraded WebSocket
+// connection, like libp2p's own
+type webSocketCapableConn struct {
+	transport.CapableConn
+}
+
+func (c *webSocketCapableConn) ConnState() network.ConnectionState {
+	cs := c.CapableConn.ConnState()
+	cs.Transport = "websocket"
+	return cs
+}
+
+// webSocketConn is a stream connection over binary WebSocket messages. Its
+// remote address is the one dialed, not the proxy's.
+type webSocketConn struct {
+	*websocket.Conn
+	laddr  multiaddr.Multiaddr
+	raddr  multiaddr.Multiaddr
+	secure bool
+
+	readMu  sync.Mutex
+	reader  io.Reader
+	writeMu sync.Mutex
+	close   func() error
+}
+
+var _ manet.Conn = (*webSocketConn)(nil)
+
+func newWebSocketConn(raw *websocket.Conn, raddr multiaddr.Multiaddr, secure bool) (*webSocketConn, error) {
+	laddr, err := manet.FromNetAddr(ws.NewAddrWithScheme(raw.LocalAddr().String(), secure))
+	if err != nil {
+		return nil, fmt.Errorf("invalid local address: %w", err)
+	}
+	c := &webSocketConn{Conn: raw, laddr: laddr, raddr: raddr, secure: secure}
+	c.close = sync.OnceValue(func() error {
+		err := c.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "closed"),
+			time.Now().Add(ws.GracefulCloseTimeout))
+		return errors.Join(err, c.Conn.Close())
+	})
+	return c, nil
+}
+
+func (c *webSocketConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		if c.reader == nil {
+			typ, r, err := c.Conn.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+				return 0, io.EOF
+			}
+			if err != nil {
+				return 0, err
+			}
+			if typ != websocket.BinaryMessage {
+				return 0, fmt.Errorf("unexpected WebSocket message type %d", typ)
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends a close message and closes the connection; later calls return
+// the same error
+func (c *webSocketConn) Close() error {
+	return c.close()
+}
+
+func (c *webSocketConn) LocalAddr() net.Addr {
+	return ws.NewAddrWithScheme(c.Conn.LocalAddr().String(), c.secure)
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	addr, err := manet.ToNetAddr(c.raddr)
+	if err != nil {
+		return ws.NewAddrWithScheme(c.Conn.RemoteAddr().String(), c.secure)
+	}
+	return addr
+}
+
+func (c *webSocketConn) LocalMultiaddr() multiaddr.Multiaddr {
+	return c.laddr
+}
+
+func (c *webSocketConn) RemoteMultiaddr() multiaddr.Multiaddr {
+	return c.raddr
+}
+
+func (c *webSocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *webSocketConn) SetWriteDeadline(t time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package libp2plearn
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// startWithPTY starts cmd with a new pseudo-terminal as its controlling
+// terminal and stdio, and returns the terminal's master side
+func startWithPTY(cmd *exec.Cmd, size WindowSize) (*os.File, error) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pty master: %w", err)
+	}
+
+	tty, err := openPTYSlave(ptmx)
+	if err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	defer tty.Close()
+
+	if size.Rows > 0 && size.Cols > 0 {
+		if err := setWindowSize(ptmx, size); err != nil {
+			ptmx.Close()
+			return nil, err
+		}
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
+
+// openPTYSlave unlocks the master and opens its slave side
+func openPTYSlave(ptmx *os.File) (*os.File, error) {
+	fd := int(ptmx.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		return nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pty slave: %w", err)
+	}
+	return tty, nil
+}
+
+// setWindowSize changes the size of a pseudo-terminal
+func setWindowSize(ptmx *os.File, size WindowSize) error {
+	return unix.IoctlSetWinsize(int(ptmx.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: size.Rows, Col: size.Cols})
+}
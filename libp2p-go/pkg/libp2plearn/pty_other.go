@@ -0,0 +1,20 @@
+//go:build !linux
+
+package libp2plearn
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errPTYUnsupported is returned for PTY sessions on platforms without PTY support
+var errPTYUnsupported = errors.New("pty sessions are only supported on linux")
+
+func startWithPTY(cmd *exec.Cmd, size WindowSize) (*os.File, error) {
+	return nil, errPTYUnsupported
+}
+
+func setWindowSize(ptmx *os.File, size WindowSize) error {
+	return errPTYUnsupported
+}
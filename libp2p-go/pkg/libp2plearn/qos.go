@@ -0,0 +1,203 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Priority is a QoS class for protocol streams; lower values are more important
+type Priority int
+
+const (
+	// PriorityControl is for small, latency-critical control traffic such as ping
+	PriorityControl Priority = iota
+
+	// PriorityChat is for interactive traffic
+	PriorityChat
+
+	// PriorityBulk is for large transfers that can wait
+	PriorityBulk
+
+	numPriorities
+)
+
+// priorityNames maps config names to priority classes
+var priorityNames = map[string]Priority{
+	"control": PriorityControl,
+	"chat":    PriorityChat,
+	"bulk":    PriorityBulk,
+}
+
+// ParsePriority parses a priority class name
+func ParsePriority(name string) (Priority, error) {
+	p, ok := priorityNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown priority class: %s", name)
+	}
+	return p, nil
+}
+
+func (p Priority) String() string {
+	for name, priority := range priorityNames {
+		if priority == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// defaultQoSClasses is the priority of the built-in protocols
+func defaultQoSClasses() map[string]string {
+	return map[string]string{
+		PingProtocol:        "control",
+		ChatProtocol:        "chat",
+		ChatSessionProtocol: "chat",
+		ChatResumeProtocol:  "chat",
+		EchoProtocol:        "bulk",
+		EchoV2Protocol:      "bulk",
+	}
+}
+
+// QoS slows down writes on low-priority streams while higher-priority traffic is active
+type QoS struct {
+	classes    map[protocol.ID]Priority
+	fallback   Priority
+	window     time.Duration
+	lastActive [numPriorities]atomic.Int64
+	yield      [numPriorities]*rate.Limiter
+}
+
+// NewQoS creates a QoS scheduler. classes maps protocol IDs to priority class names;
+// unlisted protocols are bulk. While a class is active, every lower class is
+// limited to yieldRate bytes per second for window after its last activity.
+func NewQoS(classes map[string]string, window time.Duration, yieldRate int) (*QoS, error) {
+	q := &QoS{
+		classes:  make(map[protocol.ID]Priority, len(classes)),
+		fallback: PriorityBulk,
+		window:   window,
+	}
+	for proto, name := range classes {
+		p, err := ParsePriority(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid class for %s: %w", proto, err)
+		}
+		q.classes[protocol.ID(proto)] = p
+	}
+
+	// Each lower class gets its own bucket so chat and bulk don't compete for one budget
+	for p := PriorityChat; p < numPriorities; p++ {
+		q.yield[p] = newLimiter(yieldRate)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"protocols":  len(q.classes),
+		"window":     window,
+		"yield_rate": yieldRate,
+	}).Info("Stream QoS enabled")
+	return q, nil
+}
+
+// PriorityOf returns the priority class of a protocol
+func (q *QoS) PriorityOf(proto protocol.ID) Priority {
+	if p, ok := q.classes[proto]; ok {
+		return p
+	}
+	return q.fallback
+}
+
+// Middleware applies QoS to streams accepted by a protocol handler
+func (q *QoS) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		next(q.Wrap(s))
+	}
+}
+
+// Opener applies QoS to streams opened through the given opener
+func (q *QoS) Opener(o StreamOpener) StreamOpener {
+	return &qosOpener{qos: q, next: o}
+}
+
+// Wrap returns the stream with writes scheduled by its priority
+func (q *QoS) Wrap(s network.Stream) network.Stream {
+	return &qosStream{Stream: s, qos: q, priority: q.PriorityOf(baseProtocol(s.Protocol()))}
+}
+
+// markActive records traffic on a priority class
+func (q *QoS) markActive(p Priority) {
+	q.lastActive[p].Store(time.Now().UnixNano())
+}
+
+// higherActive reports whether a more important class saw traffic within the window
+func (q *QoS) higherActive(p Priority) bool {
+	cutoff := time.Now().Add(-q.window).UnixNano()
+	for higher := PriorityControl; higher < p; higher++ {
+		if q.lastActive[higher].Load() > cutoff {
+			return true
+		}
+	}
+	return false
+}
+
+// qosOpener wraps outgoing streams in the QoS scheduler
+type qosOpener struct {
+	qos  *QoS
+	next StreamOpener
+}
+
+func (o *qosOpener) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := o.next.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return o.qos.Wrap(s), nil
+}
+
+// qosStream yields to higher-priority traffic on writes
+type qosStream struct {
+	network.Stream
+	qos      *QoS
+	priority Priority
+}
+
+func (s *qosStream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	if n > 0 {
+		s.qos.markActive(s.priority)
+	}
+	return n, err
+}
+
+func (s *qosStream) Write(b []byte) (int, error) {
+	limiter := s.qos.yield[s.priority]
+	if limiter == nil {
+		s.qos.markActive(s.priority)
+		return s.Stream.Write(b)
+	}
+
+	written := 0
+	chunk := limiter.Burst()
+	for written < len(b) {
+		end := min(written+chunk, len(b))
+		if s.qos.higherActive(s.priority) {
+			if err := waitAll([]*rate.Limiter{limiter}, end-written); err != nil {
+				return written, err
+			}
+		}
+
+		s.qos.markActive(s.priority)
+		n, err := s.Stream.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
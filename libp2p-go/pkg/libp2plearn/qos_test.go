@@ -0,0 +1,37 @@
+package libp2plearn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQoS(t *testing.T) {
+	qos, err := NewQoS(defaultQoSClasses(), 100*time.Millisecond, 1024)
+	require.NoError(t, err)
+
+	t.Run("Classes", func(t *testing.T) {
+		assert.Equal(t, PriorityControl, qos.PriorityOf(protocol.ID(PingProtocol)))
+		assert.Equal(t, PriorityChat, qos.PriorityOf(protocol.ID(ChatProtocol)))
+		assert.Equal(t, PriorityBulk, qos.PriorityOf(protocol.ID(EchoProtocol)))
+		assert.Equal(t, PriorityBulk, qos.PriorityOf("/unknown/1.0.0"), "Unlisted protocols should be bulk")
+
+		_, err := NewQoS(map[string]string{EchoProtocol: "urgent"}, time.Second, 1024)
+		assert.Error(t, err)
+	})
+
+	t.Run("YieldToHigherPriority", func(t *testing.T) {
+		assert.False(t, qos.higherActive(PriorityBulk))
+
+		qos.markActive(PriorityChat)
+		assert.True(t, qos.higherActive(PriorityBulk), "Bulk should yield while chat is active")
+		assert.False(t, qos.higherActive(PriorityChat), "A class never yields to itself")
+		assert.False(t, qos.higherActive(PriorityControl))
+
+		time.Sleep(150 * time.Millisecond)
+		assert.False(t, qos.higherActive(PriorityBulk), "Bulk should resume after the window")
+	})
+}
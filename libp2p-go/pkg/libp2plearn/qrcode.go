@@ -0,0 +1,534 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// qrAlphanumeric are the characters of the QR alphanumeric mode, in order
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// qrQuietZone is the light border a scanner needs around the code, in modules
+const qrQuietZone = 4
+
+// qrBlocks is how a version splits its codewords at error correction level
+// M: the EC codewords of every block, then the number of blocks and data
+// codewords of each of the two groups
+type qrBlocks struct {
+	ec             int
+	blocks1, data1 int
+	blocks2, data2 int
+}
+
+// qrVersions are the block layouts of versions 1 to 20 at level M
+var qrVersions = []qrBlocks{
+	{10, 1, 16, 0, 0},
+	{16, 1, 28, 0, 0},
+	{26, 1, 44, 0, 0},
+	{18, 2, 32, 0, 0},
+	{24, 2, 43, 0, 0},
+	{16, 4, 27, 0, 0},
+	{18, 4, 31, 0, 0},
+	{22, 2, 38, 2, 39},
+	{22, 3, 36, 2, 37},
+	{26, 4, 43, 1, 44},
+	{30, 1, 50, 4, 51},
+	{22, 6, 36, 2, 37},
+	{22, 8, 37, 1, 38},
+	{24, 4, 40, 5, 41},
+	{24, 5, 41, 5, 42},
+	{28, 7, 45, 3, 46},
+	{28, 10, 46, 1, 47},
+	{26, 9, 43, 4, 44},
+	{26, 3, 44, 11, 45},
+	{26, 3, 41, 13, 42},
+}
+
+// dataCodewords is the number of data codewords of the layout
+func (b qrBlocks) dataCodewords() int {
+	return b.blocks1*b.data1 + b.blocks2*b.data2
+}
+
+// QRCode is a QR code at error correction level M, enough to scan an
+// invite token off a terminal
+type QRCode struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// EncodeQR encodes text in the smallest QR code that holds it, in
+// alphanumeric mode if every character allows it and in byte mode otherwise
+func EncodeQR(text string) (*QRCode, error) {
+	alphanumeric := true
+	for _, r := range text {
+		if !strings.ContainsRune(qrAlphanumeric, r) {
+			alphanumeric = false
+			break
+		}
+	}
+
+	for version := 1; version <= len(qrVersions); version++ {
+		capacity := qrVersions[version-1].dataCodewords() * 8
+		bits := qrSegment(text, alphanumeric, version)
+		if len(bits) > capacity {
+			continue
+		}
+		q := newQRCode(version)
+		q.drawData(qrCodewords(bits, version))
+		return q, nil
+	}
+	return nil, fmt.Errorf("text of %d bytes is too long for a QR code", len(text))
+}
+
+// Size returns the number of modules on each side
+func (q *QRCode) Size() int {
+	return q.size
+}
+
+// Dark reports whether the module at column x and row y is dark
+func (q *QRCode) Dark(x, y int) bool {
+	return q.modules[y][x]
+}
+
+// Terminal renders the code with half blocks, two rows of modules per line,
+// dark on light whatever the terminal's colors, with the quiet zone around it
+func (q *QRCode) Terminal() string {
+	dark := func(x, y int) bool {
+		x, y = x-qrQuietZone, y-qrQuietZone
+		return x >= 0 && y >= 0 && x < q.size && y < q.size && q.modules[y][x]
+	}
+	var b strings.Builder
+	full := q.size + 2*qrQuietZone
+	for y := 0; y < full; y += 2 {
+		b.WriteString("\x1b[30;107m")
+		for x := 0; x < full; x++ {
+			switch top, bottom := dark(x, y), dark(x, y+1); {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+// qrBits is a bit string being built
+type qrBits []bool
+
+// append adds the low n bits of v, most significant first
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (v>>i)&1 == 1)
+	}
+}
+
+// qrSegment encodes text as one segment: the mode, the character count and
+// the characters
+func qrSegment(text string, alphanumeric bool, version int) qrBits {
+	var bits qrBits
+	if alphanumeric {
+		countBits := 9
+		if version >= 10 {
+			countBits = 11
+		}
+		bits.append(0x2, 4)
+		bits.append(len(text), countBits)
+		for i := 0; i+1 < len(text); i += 2 {
+			bits.append(strings.IndexByte(qrAlphanumeric, text[i])*45+strings.IndexByte(qrAlphanumeric, text[i+1]), 11)
+		}
+		if len(text)%2 == 1 {
+			bits.append(strings.IndexByte(qrAlphanumeric, text[len(text)-1]), 6)
+		}
+		return bits
+	}
+
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	bits.append(0x4, 4)
+	bits.append(len(text), countBits)
+	for i := 0; i < len(text); i++ {
+		bits.append(int(text[i]), 8)
+	}
+	return bits
+}
+
+// qrCodewords terminates and pads the segment to the data capacity of the
+// version, adds the error correction of each block and interleaves them
+func qrCodewords(bits qrBits, version int) []byte {
+	layout := qrVersions[version-1]
+	capacity := layout.dataCodewords() * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	divisor := reedSolomonDivisor(layout.ec)
+	var blocks, ecBlocks [][]byte
+	for i := 0; i < layout.blocks1+layout.blocks2; i++ {
+		n := layout.data1
+		if i >= layout.blocks1 {
+			n = layout.data2
+		}
+		blocks = append(blocks, data[:n])
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var out []byte
+	for i := 0; i < max(layout.data1, layout.data2); i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ec; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// newQRCode draws the function patterns of a version
+func newQRCode(version int) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{version: version, size: size}
+	q.modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// Skip the three corners taken by finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			q.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format areas; drawData fills them in with the mask
+	q.drawFormat(0)
+	q.drawVersion()
+	return q
+}
+
+// set sets a function module
+func (q *QRCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centred on x, y
+func (q *QRCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= q.size || yy >= q.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			q.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centred on x, y
+func (q *QRCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level M and
+// a mask, and the dark module
+func (q *QRCode) drawFormat(mask int) {
+	// Level M is 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawVersion draws both copies of the version information, which versions
+// 7 and up have
+func (q *QRCode) drawVersion() {
+	if q.version < 7 {
+		return
+	}
+	rem := q.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := q.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		a, b := q.size-11+i%3, i/3
+		q.set(a, b, dark)
+		q.set(b, a, dark)
+	}
+}
+
+// drawData places the codewords in the zigzag order, then applies the mask
+// with the lowest penalty
+func (q *QRCode) drawData(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				q.modules[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+}
+
+// applyMask flips the data modules the mask selects; applying it twice
+// undoes it
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan: long runs of one color, 2×2
+// blocks, patterns that look like finders and an uneven balance of dark and
+// light modules
+func (q *QRCode) penalty() int {
+	penalty := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, column := range []bool{false, true} {
+		for a := 0; a < q.size; a++ {
+			line := make([]bool, q.size)
+			for b := range line {
+				if column {
+					line[b] = q.modules[b][a]
+				} else {
+					line[b] = q.modules[a][b]
+				}
+			}
+
+			run := 1
+			for b := 1; b <= q.size; b++ {
+				if b < q.size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// A 1:1:3:1:1 pattern with four light modules on either side,
+			// counting the quiet zone as light
+			light := func(from, to int) bool {
+				for b := from; b < to; b++ {
+					if b >= 0 && b < q.size && line[b] {
+						return false
+					}
+				}
+				return true
+			}
+			for b := 0; b+len(finderLike) <= q.size; b++ {
+				match := true
+				for k, dark := range finderLike {
+					if line[b+k] != dark {
+						match = false
+						break
+					}
+				}
+				if match && (light(b-4, b) || light(b+7, b+11)) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return penalty + k*10
+}
+
+// qrAlignmentPositions returns the centre coordinates of the alignment
+// patterns of a version
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// without its leading 1
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords of data
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,155 @@
+package libp2plearn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRCodewords(t *testing.T) {
+	// The worked example of a 1-M code for HELLO WORLD
+	bits := qrSegment("HELLO WORLD", true, 1)
+	assert.Equal(t, []byte{
+		32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17,
+		196, 35, 39, 119, 235, 215, 231, 226, 93, 23,
+	}, qrCodewords(bits, 1))
+}
+
+func TestQRVersions(t *testing.T) {
+	for version := 1; version <= len(qrVersions); version++ {
+		layout := qrVersions[version-1]
+		q := newQRCode(version)
+		data := 0
+		for y := range q.function {
+			for x := range q.function[y] {
+				if !q.function[y][x] {
+					data++
+				}
+			}
+		}
+		total := layout.dataCodewords() + layout.ec*(layout.blocks1+layout.blocks2)
+		assert.Equal(t, data/8, total, "version %d", version)
+	}
+	assert.Equal(t, []int{6, 26, 46, 66}, qrAlignmentPositions(14))
+}
+
+func TestEncodeQR(t *testing.T) {
+	for _, text := range []string{
+		"HELLO WORLD",
+		InviteTokenPrefix + strings.Repeat("ABCDEFGH234567", 20),
+		"mixed Case bytes: /ip4/192.168.1.20/tcp/4001",
+	} {
+		q, err := EncodeQR(text)
+		require.NoError(t, err)
+		assert.Equal(t, q.version*4+17, q.Size())
+		assert.Equal(t, text, decodeQR(t, q), "version %d", q.version)
+	}
+
+	_, err := EncodeQR(strings.Repeat("x", 2000))
+	assert.Error(t, err)
+}
+
+// decodeQR reads back the text of a code: the format, then the codewords
+// in placement order, then the data codewords of each block in turn
+func decodeQR(t *testing.T, q *QRCode) string {
+	var format int
+	for i := 0; i <= 5; i++ {
+		format |= bit(q.Dark(8, i)) << i
+	}
+	format |= bit(q.Dark(8, 7))<<6 | bit(q.Dark(8, 8))<<7 | bit(q.Dark(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		format |= bit(q.Dark(14-i, 8)) << i
+	}
+	format ^= 0x5412
+	require.Equal(t, 0, format>>13, "level M")
+	mask := format >> 10 & 7
+
+	q.applyMask(mask)
+	defer q.applyMask(mask)
+	var codewords []byte
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] {
+					continue
+				}
+				if i%8 == 0 {
+					codewords = append(codewords, 0)
+				}
+				codewords[i/8] |= byte(bit(q.modules[y][x])) << (7 - i%8)
+				i++
+			}
+		}
+	}
+
+	layout := qrVersions[q.version-1]
+	blocks := make([][]byte, layout.blocks1+layout.blocks2)
+	for i := 0; i < max(layout.data1, layout.data2); i++ {
+		for b := range blocks {
+			if i < layout.data1 || b >= layout.blocks1 {
+				blocks[b] = append(blocks[b], codewords[0])
+				codewords = codewords[1:]
+			}
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+
+	bits := make([]bool, 0, len(data)*8)
+	for _, b := range data {
+		for k := 7; k >= 0; k-- {
+			bits = append(bits, b>>k&1 == 1)
+		}
+	}
+	take := func(n int) int {
+		v := 0
+		for k := 0; k < n; k++ {
+			v = v<<1 | bit(bits[k])
+		}
+		bits = bits[n:]
+		return v
+	}
+
+	large := q.version >= 10
+	var text strings.Builder
+	switch take(4) {
+	case 0x2:
+		count := take(map[bool]int{false: 9, true: 11}[large])
+		for ; count >= 2; count -= 2 {
+			v := take(11)
+			text.WriteByte(qrAlphanumeric[v/45])
+			text.WriteByte(qrAlphanumeric[v%45])
+		}
+		if count == 1 {
+			text.WriteByte(qrAlphanumeric[take(6)])
+		}
+	case 0x4:
+		count := take(map[bool]int{false: 8, true: 16}[large])
+		for ; count > 0; count-- {
+			text.WriteByte(byte(take(8)))
+		}
+	default:
+		t.Fatal("unexpected mode")
+	}
+	return text.String()
+}
+
+// bit is 1 for true
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
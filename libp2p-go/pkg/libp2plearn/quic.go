@@ -0,0 +1,87 @@
+package libp2plearn
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+// quic-go's initial receive windows, which grow up to the maximum as the
+// connection proves it needs them. A smaller maximum caps them as well.
+const (
+	quicInitialStreamWindow     = 512 << 10
+	quicInitialConnectionWindow = 768 << 10
+)
+
+// quicOption tunes the QUIC connections the node dials, or returns nil to
+// keep libp2p's defaults. libp2p only exposes the client side of its QUIC
+// configuration, so connections peers dial to us keep the defaults.
+func quicOption(cfg *Config) libp2p.Option {
+	if !cfg.QUICTuned() {
+		return nil
+	}
+
+	// Same as libp2p's own constructor, which QUICReuse replaces, apart from
+	// the tuning: inbound connections are accounted in the resource manager
+	// and source addresses verified under load
+	return libp2p.QUICReuse(func(key quic.StatelessResetKey, tokenKey quic.TokenGeneratorKey, rcmgr network.ResourceManager, lifecycle fx.Lifecycle) (*quicreuse.ConnManager, error) {
+		cm, err := quicreuse.NewConnManager(key, tokenKey,
+			quicreuse.ConnContext(func(ctx context.Context, info *quic.ClientInfo) (context.Context, error) {
+				addr, err := quicreuse.ToQuicMultiaddr(info.RemoteAddr, quic.Version1)
+				if err != nil {
+					addr = nil
+				}
+				scope, err := rcmgr.OpenConnection(network.DirInbound, false, addr)
+				if err != nil {
+					return ctx, err
+				}
+				ctx = network.WithConnManagementScope(ctx, scope)
+				context.AfterFunc(ctx, scope.Done)
+				return ctx, nil
+			}),
+			quicreuse.VerifySourceAddress(func(addr net.Addr) bool {
+				return rcmgr.VerifySourceAddress(addr)
+			}),
+			quicreuse.EnableMetrics(prometheus.DefaultRegisterer))
+		if err != nil {
+			return nil, err
+		}
+		lifecycle.Append(fx.StopHook(cm.Close))
+
+		tuneQUIC(cm.ClientConfig(), cfg)
+		return cm, nil
+	})
+}
+
+// tuneQUIC applies the configured QUIC parameters, leaving unset ones alone
+func tuneQUIC(conf *quic.Config, cfg *Config) {
+	if cfg.QUICMaxIdleTimeout > 0 {
+		conf.MaxIdleTimeout = time.Duration(cfg.QUICMaxIdleTimeout)
+	}
+	if cfg.QUICKeepAlive > 0 {
+		conf.KeepAlivePeriod = time.Duration(cfg.QUICKeepAlive)
+	}
+	if cfg.QUICStreamWindow > 0 {
+		conf.MaxStreamReceiveWindow = uint64(cfg.QUICStreamWindow)
+		conf.InitialStreamReceiveWindow = min(quicInitialStreamWindow, conf.MaxStreamReceiveWindow)
+	}
+	if cfg.QUICConnectionWindow > 0 {
+		conf.MaxConnectionReceiveWindow = uint64(cfg.QUICConnectionWindow)
+		conf.InitialConnectionReceiveWindow = min(quicInitialConnectionWindow, conf.MaxConnectionReceiveWindow)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"idle_timeout":      conf.MaxIdleTimeout,
+		"keepalive":         conf.KeepAlivePeriod,
+		"stream_window":     conf.MaxStreamReceiveWindow,
+		"connection_window": conf.MaxConnectionReceiveWindow,
+	}).Debug("QUIC tuned")
+}
@@ -0,0 +1,68 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICTuning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.QUICMaxIdleTimeout = Duration(2 * time.Minute)
+	cfg.QUICKeepAlive = Duration(5 * time.Second)
+	cfg.QUICStreamWindow = 32 << 20
+	cfg.QUICConnectionWindow = 64 << 20
+
+	node1, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node1.Stop(ctx)
+	node2, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer node2.Stop(ctx)
+
+	// Dial over QUIC, so the tuned client side carries the connection
+	var quicAddr multiaddr.Multiaddr
+	for _, addr := range node2.Host().Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_QUIC_V1); err == nil {
+			quicAddr = addr
+			break
+		}
+	}
+	require.NotNil(t, quicAddr)
+	require.NoError(t, node1.Connect(ctx, fmt.Sprintf("%s/p2p/%s", quicAddr, node2.Host().ID())))
+
+	response, err := node1.Protocols().SendPing(ctx, node2.Host().ID(), "hello")
+	require.NoError(t, err)
+	assert.Contains(t, response, "hello")
+
+	t.Run("tuneQUIC", func(t *testing.T) {
+		conf := &quic.Config{KeepAlivePeriod: 15 * time.Second}
+		tuneQUIC(conf, &Config{QUICStreamWindow: 256 << 10, QUICConnectionWindow: 4 << 20})
+		assert.Equal(t, 15*time.Second, conf.KeepAlivePeriod)
+		assert.Equal(t, uint64(256<<10), conf.MaxStreamReceiveWindow)
+		assert.Equal(t, uint64(256<<10), conf.InitialStreamReceiveWindow)
+		assert.Equal(t, uint64(4<<20), conf.MaxConnectionReceiveWindow)
+		assert.Equal(t, uint64(quicInitialConnectionWindow), conf.InitialConnectionReceiveWindow)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		cfg := testNodeConfig()
+		cfg.QUICMaxIdleTimeout = Duration(10 * time.Second)
+		cfg.QUICKeepAlive = Duration(10 * time.Second)
+		assert.Error(t, cfg.Validate())
+
+		cfg = testNodeConfig()
+		cfg.QUICStreamWindow = 8 << 20
+		cfg.QUICConnectionWindow = 4 << 20
+		assert.Error(t, cfg.Validate())
+	})
+}
@@ -0,0 +1,384 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	pbv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/pb"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// QuotaPeriodDay resets a quota at local midnight
+	QuotaPeriodDay = "day"
+
+	// QuotaPeriodMonth resets a quota at local midnight on the first of the month
+	QuotaPeriodMonth = "month"
+
+	// QuotaActionRefuse refuses new streams once the traffic quota is used up
+	QuotaActionRefuse = "refuse"
+
+	// QuotaActionThrottle slows new streams down once the traffic quota is used up
+	QuotaActionThrottle = "throttle"
+
+	// Names of the quotas in their status and the quota file
+	quotaTotal = "total"
+	quotaRelay = "relay"
+
+	// quotaSaveInterval is how often usage is saved and periods roll over
+	quotaSaveInterval = time.Minute
+)
+
+// ErrQuotaExceeded is returned for streams refused because the traffic
+// quota of the period is used up
+var ErrQuotaExceeded = errors.New("traffic quota exceeded")
+
+// QuotaLimit caps the traffic of a day or a calendar month
+type QuotaLimit struct {
+	Bytes  int64  `json:"bytes"` // 0 for no cap
+	Period string `json:"period"`
+}
+
+// QuotaStatus is how much of a quota the current period has used
+type QuotaStatus struct {
+	Name     string    `json:"name"`
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit"` // 0 for no cap
+	Period   string    `json:"period"`
+	Resets   time.Time `json:"resets"`
+	Exceeded bool      `json:"exceeded"`
+}
+
+// quota counts the traffic of the current period
+type quota struct {
+	limit QuotaLimit
+	used  atomic.Int64
+	start time.Time // guarded by Quotas.mu
+}
+
+// quotaUsage is what the quota file keeps of a quota
+type quotaUsage struct {
+	Used  int64     `json:"used"`
+	Start time.Time `json:"start"`
+}
+
+// periodStart returns when the period containing t started
+func periodStart(period string, t time.Time) time.Time {
+	y, m, d := t.Date()
+	if period == QuotaPeriodMonth {
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// periodEnd returns when the period starting at start ends
+func periodEnd(period string, start time.Time) time.Time {
+	if period == QuotaPeriodMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func (q *quota) exceeded() bool {
+	return q.limit.Bytes > 0 && q.used.Load() >= q.limit.Bytes
+}
+
+// Quotas counts traffic against daily or monthly caps: the total over all
+// streams, and what our relay service forwards for others. Usage is saved
+// to a file, so a restart doesn't reset it.
+//
+// Once the total quota is used up, new streams of the node's protocols are
+// refused or slowed down, depending on the action; streams already open are
+// not affected. Once either quota is used up, the relay service refuses new
+// reservations and circuits, since relayed traffic can't be slowed down.
+type Quotas struct {
+	*metrics.BandwidthCounter
+
+	path   string
+	action string
+	up     *rate.Limiter // shared by throttled streams
+	down   *rate.Limiter
+	total  *quota
+	relay  *quota
+
+	mu     sync.Mutex // guards period starts and the quota file
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewQuotas loads the quota file, starting from zero if it doesn't exist or
+// holds an earlier period. throttleRate is the bytes per second each
+// direction is slowed to with QuotaActionThrottle.
+func NewQuotas(path string, total, relay QuotaLimit, action string, throttleRate int) (*Quotas, error) {
+	now := time.Now()
+	q := &Quotas{
+		BandwidthCounter: metrics.NewBandwidthCounter(),
+		path:             path,
+		action:           action,
+		up:               newLimiter(throttleRate),
+		down:             newLimiter(throttleRate),
+		total:            &quota{limit: total, start: periodStart(total.Period, now)},
+		relay:            &quota{limit: relay, start: periodStart(relay.Period, now)},
+		done:             make(chan struct{}),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"total":  total.Bytes,
+		"relay":  relay.Bytes,
+		"action": action,
+	}).Info("Traffic quotas enabled")
+	return q, nil
+}
+
+// Options count the host's traffic and the relay service's against the
+// quotas. They replace the host's relay service options, so relayOpts are
+// passed on to it.
+func (q *Quotas) Options(relayOpts ...relayv2.Option) []libp2p.Option {
+	relayOpts = append(relayOpts,
+		relayv2.WithACL(quotaRelayACL{q}),
+		relayv2.WithMetricsTracer(quotaRelayTracer{q}),
+	)
+	return []libp2p.Option{
+		libp2p.BandwidthReporter(q),
+		libp2p.EnableRelayService(relayOpts...),
+	}
+}
+
+// Start rolls periods over and saves usage every minute until Close
+func (q *Quotas) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	go q.run(ctx)
+}
+
+// Close stops saving usage and saves it one last time
+func (q *Quotas) Close() error {
+	if q.cancel != nil {
+		q.cancel()
+		<-q.done
+	}
+	return q.Save()
+}
+
+// LogSentMessage counts data sent on any stream
+func (q *Quotas) LogSentMessage(size int64) {
+	q.BandwidthCounter.LogSentMessage(size)
+	q.total.used.Add(size)
+}
+
+// LogRecvMessage counts data received on any stream
+func (q *Quotas) LogRecvMessage(size int64) {
+	q.BandwidthCounter.LogRecvMessage(size)
+	q.total.used.Add(size)
+}
+
+// Exceeded reports whether the total traffic quota is used up
+func (q *Quotas) Exceeded() bool {
+	return q.total.exceeded()
+}
+
+// Status returns the usage of the total and relay quotas
+func (q *Quotas) Status() []QuotaStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := func(name string, qt *quota) QuotaStatus {
+		return QuotaStatus{
+			Name:     name,
+			Used:     qt.used.Load(),
+			Limit:    qt.limit.Bytes,
+			Period:   qt.limit.Period,
+			Resets:   periodEnd(qt.limit.Period, qt.start),
+			Exceeded: qt.exceeded(),
+		}
+	}
+	return []QuotaStatus{status(quotaTotal, q.total), status(quotaRelay, q.relay)}
+}
+
+// Middleware refuses or slows down streams accepted by a protocol handler
+// while the total quota is used up
+func (q *Quotas) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		s, err := q.Wrap(s)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"peer":     s.Conn().RemotePeer(),
+				"protocol": proto,
+			}).Debug("Refused stream over traffic quota")
+			s.Reset()
+			return
+		}
+		next(s)
+	}
+}
+
+// Opener refuses or slows down streams opened through the given opener
+// while the total quota is used up
+func (q *Quotas) Opener(o StreamOpener) StreamOpener {
+	return &quotaOpener{quotas: q, next: o}
+}
+
+// Wrap returns the stream, slowed down while the total quota is used up, or
+// ErrQuotaExceeded with the stream unchanged if it should be refused
+func (q *Quotas) Wrap(s network.Stream) (network.Stream, error) {
+	if !q.Exceeded() {
+		return s, nil
+	}
+	if q.action != QuotaActionThrottle || q.up == nil {
+		return s, ErrQuotaExceeded
+	}
+	return &throttledStream{Stream: s, up: []*rate.Limiter{q.up}, down: []*rate.Limiter{q.down}}, nil
+}
+
+// roll starts a new period for quotas whose period is over
+func (q *Quotas) roll(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for name, qt := range map[string]*quota{quotaTotal: q.total, quotaRelay: q.relay} {
+		if start := periodStart(qt.limit.Period, now); start.After(qt.start) {
+			logrus.WithFields(logrus.Fields{
+				"quota": name,
+				"used":  qt.used.Load(),
+			}).Info("Traffic quota period ended")
+			qt.start = start
+			qt.used.Store(0)
+		}
+	}
+}
+
+// run rolls periods over and saves usage until ctx is done
+func (q *Quotas) run(ctx context.Context) {
+	defer close(q.done)
+	ticker := time.NewTicker(quotaSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.roll(now)
+			if err := q.Save(); err != nil {
+				logrus.WithError(err).Warn("Failed to save traffic quotas")
+			}
+		}
+	}
+}
+
+// Save writes the usage of the current periods to the quota file
+func (q *Quotas) Save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := map[string]quotaUsage{
+		quotaTotal: {Used: q.total.used.Load(), Start: q.total.start},
+		quotaRelay: {Used: q.relay.used.Load(), Start: q.relay.start},
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode traffic quotas: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return fmt.Errorf("failed to create traffic quota directory: %w", err)
+	}
+
+	if err := writeFileAtomic(q.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write traffic quotas: %w", err)
+	}
+	return nil
+}
+
+// load reads the usage of the current periods from the quota file
+func (q *Quotas) load() error {
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read traffic quotas: %w", err)
+	}
+
+	var usage map[string]quotaUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return fmt.Errorf("failed to parse traffic quotas: %w", err)
+	}
+	for name, qt := range map[string]*quota{quotaTotal: q.total, quotaRelay: q.relay} {
+		// Usage of an earlier period no longer counts
+		if saved, ok := usage[name]; ok && saved.Start.Equal(qt.start) {
+			qt.used.Store(saved.Used)
+		}
+	}
+	return nil
+}
+
+// quotaOpener refuses or slows down outgoing streams over quota
+type quotaOpener struct {
+	quotas *Quotas
+	next   StreamOpener
+}
+
+func (o *quotaOpener) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if _, err := o.quotas.Wrap(nil); err != nil {
+		return nil, err
+	}
+	s, err := o.next.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	s, err = o.quotas.Wrap(s)
+	if err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return s, nil
+}
+
+// quotaRelayACL refuses relay reservations and circuits over quota
+type quotaRelayACL struct {
+	quotas *Quotas
+}
+
+func (a quotaRelayACL) allow() bool {
+	return !a.quotas.total.exceeded() && !a.quotas.relay.exceeded()
+}
+
+func (a quotaRelayACL) AllowReserve(peer.ID, multiaddr.Multiaddr) bool {
+	return a.allow()
+}
+
+func (a quotaRelayACL) AllowConnect(peer.ID, multiaddr.Multiaddr, peer.ID) bool {
+	return a.allow()
+}
+
+// quotaRelayTracer counts what the relay service forwards against the relay quota
+type quotaRelayTracer struct {
+	quotas *Quotas
+}
+
+func (t quotaRelayTracer) BytesTransferred(cnt int) {
+	t.quotas.relay.used.Add(int64(cnt))
+}
+
+func (quotaRelayTracer) RelayStatus(bool)                      {}
+func (quotaRelayTracer) ConnectionOpened()                     {}
+func (quotaRelayTracer) ConnectionClosed(time.Duration)        {}
+func (quotaRelayTracer) ConnectionRequestHandled(pbv2.Status)  {}
+func (quotaRelayTracer) ReservationAllowed(bool)               {}
+func (quotaRelayTracer) ReservationClosed(int)                 {}
+func (quotaRelayTracer) ReservationRequestHandled(pbv2.Status) {}
@@ -0,0 +1,89 @@
+package libp2plearn
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "quota.json")
+	limit := QuotaLimit{Bytes: 64 * 1024, Period: QuotaPeriodDay}
+	quotas, err := NewQuotas(path, limit, QuotaLimit{Bytes: 1024, Period: QuotaPeriodMonth}, QuotaActionRefuse, 0)
+	require.NoError(t, err)
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	NewProtocolHandler(server).SetupProtocols()
+
+	opts := append([]libp2p.Option{libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0")}, quotas.Options()...)
+	client, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	handler := NewProtocolHandler(client)
+	handler.SetStreamOpener(quotas.Opener(handler.StreamOpener()))
+	payload := strings.Repeat("x", 48*1024)
+
+	t.Run("CountsTraffic", func(t *testing.T) {
+		response, err := handler.SendEcho(ctx, server.ID(), payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, response)
+
+		// Sent and echoed back
+		status := quotas.Status()
+		require.Len(t, status, 2)
+		assert.Equal(t, "total", status[0].Name)
+		assert.GreaterOrEqual(t, status[0].Used, int64(2*len(payload)))
+		assert.True(t, status[0].Exceeded)
+		assert.True(t, status[0].Resets.After(time.Now()))
+	})
+
+	t.Run("RefusesOverQuota", func(t *testing.T) {
+		_, err := handler.SendEcho(ctx, server.ID(), "hello")
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+		assert.False(t, quotaRelayACL{quotas}.AllowConnect(server.ID(), nil, client.ID()))
+	})
+
+	t.Run("Throttles", func(t *testing.T) {
+		throttled, err := NewQuotas(filepath.Join(t.TempDir(), "quota.json"), QuotaLimit{Bytes: 1, Period: QuotaPeriodDay}, QuotaLimit{Period: QuotaPeriodDay}, QuotaActionThrottle, 16*1024)
+		require.NoError(t, err)
+		throttled.LogSentMessage(1)
+
+		handler := NewProtocolHandler(client)
+		handler.SetStreamOpener(throttled.Opener(handler.StreamOpener()))
+		start := time.Now()
+		response, err := handler.SendEcho(ctx, server.ID(), strings.Repeat("x", 32*1024))
+		require.NoError(t, err)
+		assert.Len(t, response, 32*1024)
+
+		// The first 16 KiB use the initial burst, the rest has to wait a second
+		assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	})
+
+	t.Run("PersistsAcrossRestarts", func(t *testing.T) {
+		used := quotas.Status()[0].Used
+		require.NoError(t, quotas.Close())
+
+		reopened, err := NewQuotas(path, limit, QuotaLimit{Period: QuotaPeriodMonth}, QuotaActionRefuse, 0)
+		require.NoError(t, err)
+		assert.Equal(t, used, reopened.Status()[0].Used)
+		assert.True(t, reopened.Exceeded())
+
+		// A new period starts from zero
+		reopened.roll(time.Now().AddDate(0, 0, 1))
+		assert.Zero(t, reopened.Status()[0].Used)
+		assert.False(t, reopened.Exceeded())
+	})
+}
@@ -0,0 +1,633 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RaftProtocol carries the votes, log replication and forwarded appends
+	// of the replicated log
+	RaftProtocol = "/libp2p-learn/raft/1.0.0"
+
+	// maxRaftMessageSize bounds the size of one raft message
+	maxRaftMessageSize = 4 << 20
+
+	// MaxRaftEntrySize bounds the data of one log entry
+	MaxRaftEntrySize = 64 * 1024
+
+	// maxRaftBatch is how many entries one append message carries at most
+	maxRaftBatch = 32
+)
+
+// Raft roles
+const (
+	RaftFollower  = "follower"
+	RaftCandidate = "candidate"
+	RaftLeader    = "leader"
+)
+
+// raft message types
+const (
+	raftMessageVote    = "vote"    // a candidate asks for a vote
+	raftMessageAppend  = "append"  // the leader replicates entries, or only asserts its leadership
+	raftMessagePropose = "propose" // a follower forwards an entry to the leader
+)
+
+// ErrNoRaftLeader is returned by Append while no leader is known, e.g. during
+// an election; try again a little later
+var ErrNoRaftLeader = errors.New("no raft leader")
+
+// RaftEntry is one entry of the replicated log. A new leader appends an
+// entry without data to commit what earlier leaders left behind.
+type RaftEntry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// RaftStatus describes what a member knows about the cluster
+type RaftStatus struct {
+	State       string  `json:"state"`
+	Term        uint64  `json:"term"`
+	Leader      peer.ID `json:"leader,omitempty"`
+	CommitIndex uint64  `json:"commit_index"`
+	LastIndex   uint64  `json:"last_index"`
+}
+
+// raftMessage is a request of the raft protocol, sent as one JSON line
+type raftMessage struct {
+	Type string `json:"type"`
+	Term uint64 `json:"term"`
+
+	// vote: the candidate's last entry, so voters can refuse candidates
+	// whose log is behind theirs
+	LastIndex uint64 `json:"last_index,omitempty"`
+	LastTerm  uint64 `json:"last_term,omitempty"`
+
+	// append: the entry before Entries, which the follower must have too
+	PrevIndex    uint64      `json:"prev_index,omitempty"`
+	PrevTerm     uint64      `json:"prev_term,omitempty"`
+	Entries      []RaftEntry `json:"entries,omitempty"`
+	LeaderCommit uint64      `json:"leader_commit,omitempty"`
+
+	// propose
+	Data []byte `json:"data,omitempty"`
+}
+
+// raftReply answers a raft message
+type raftReply struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	Index   uint64 `json:"index,omitempty"` // append: the follower's last entry; propose: the committed entry
+	Error   string `json:"error,omitempty"`
+}
+
+// Raft is a small educational implementation of the Raft consensus algorithm:
+// a fixed set of members elect a leader, which appends entries to its log and
+// replicates them to the followers over RaftProtocol. An entry is committed
+// once a majority stored it, and then never changes. State is kept in memory
+// only, so a member that restarts rejoins with an empty log and catches up
+// from the leader.
+type Raft struct {
+	host      host.Host
+	members   []peer.ID // the other members
+	election  time.Duration
+	heartbeat time.Duration
+	kick      chan struct{}
+
+	mu          sync.Mutex
+	state       string
+	term        uint64
+	votedFor    peer.ID
+	votes       int
+	leader      peer.ID
+	log         []RaftEntry // log[0] is an empty entry at index 0
+	commitIndex uint64
+	deadline    time.Time // an election starts unless a leader is heard from by then
+	nextIndex   map[peer.ID]uint64
+	matchIndex  map[peer.ID]uint64
+	sending     map[peer.ID]bool
+	changed     chan struct{} // closed and replaced when the commit index or term changes
+}
+
+// NewRaft creates a member of the replicated log shared with members and
+// registers the raft protocol handler. Without a heartbeat from a leader for
+// between electionTimeout and twice that, a member starts an election; the
+// leader sends heartbeats every heartbeatInterval.
+func NewRaft(h host.Host, members []peer.ID, electionTimeout, heartbeatInterval time.Duration) *Raft {
+	r := &Raft{
+		host:       h,
+		election:   electionTimeout,
+		heartbeat:  heartbeatInterval,
+		kick:       make(chan struct{}, 1),
+		state:      RaftFollower,
+		log:        []RaftEntry{{}},
+		nextIndex:  make(map[peer.ID]uint64),
+		matchIndex: make(map[peer.ID]uint64),
+		sending:    make(map[peer.ID]bool),
+		changed:    make(chan struct{}),
+	}
+	for _, p := range members {
+		if p != h.ID() && !slices.Contains(r.members, p) {
+			r.members = append(r.members, p)
+		}
+	}
+	r.resetDeadline()
+
+	h.SetStreamHandler(protocol.ID(RaftProtocol), RecoveryMiddleware(protocol.ID(RaftProtocol), r.handleRaft))
+	logrus.WithFields(logrus.Fields{
+		"protocol": RaftProtocol,
+		"members":  len(r.members) + 1,
+	}).Info("Registered raft protocol")
+	return r
+}
+
+// Close unregisters the raft protocol
+func (r *Raft) Close() {
+	r.host.RemoveStreamHandler(protocol.ID(RaftProtocol))
+}
+
+// Run holds elections and, while this member leads, replicates the log until
+// ctx is done
+func (r *Raft) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.kick:
+		}
+
+		r.mu.Lock()
+		state, expired := r.state, time.Now().After(r.deadline)
+		r.mu.Unlock()
+		switch {
+		case state == RaftLeader:
+			r.replicate(ctx)
+		case expired:
+			r.campaign(ctx)
+		}
+	}
+}
+
+// Status returns this member's view of the cluster
+func (r *Raft) Status() RaftStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RaftStatus{
+		State:       r.state,
+		Term:        r.term,
+		Leader:      r.leader,
+		CommitIndex: r.commitIndex,
+		LastIndex:   r.lastIndex(),
+	}
+}
+
+// Committed returns the committed entries from index from on
+func (r *Raft) Committed(from uint64) []RaftEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from = max(from, 1)
+	if from > r.commitIndex {
+		return nil
+	}
+	return slices.Clone(r.log[from : r.commitIndex+1])
+}
+
+// Append adds data to the log and returns its index once it is committed.
+// Followers forward the data to the leader.
+func (r *Raft) Append(ctx context.Context, data []byte) (uint64, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("raft entries must not be empty")
+	}
+	if len(data) > MaxRaftEntrySize {
+		return 0, fmt.Errorf("raft entry of %d bytes exceeds %d bytes", len(data), MaxRaftEntrySize)
+	}
+
+	r.mu.Lock()
+	leader := r.leader
+	r.mu.Unlock()
+	switch leader {
+	case "":
+		return 0, ErrNoRaftLeader
+	case r.host.ID():
+		return r.propose(ctx, data)
+	}
+
+	reply, err := r.send(ctx, leader, raftMessage{Type: raftMessagePropose, Data: data})
+	if err != nil {
+		return 0, fmt.Errorf("failed to forward entry to leader %s: %w", leader, err)
+	}
+	if !reply.Success {
+		return 0, fmt.Errorf("leader %s refused entry: %s", leader, reply.Error)
+	}
+	return reply.Index, nil
+}
+
+// propose appends data to the leader's log and waits until it is committed
+func (r *Raft) propose(ctx context.Context, data []byte) (uint64, error) {
+	r.mu.Lock()
+	if r.state != RaftLeader {
+		r.mu.Unlock()
+		return 0, ErrNoRaftLeader
+	}
+	entry := r.appendLocal(data)
+	r.mu.Unlock()
+	r.wake()
+
+	for {
+		r.mu.Lock()
+		changed := r.changed
+		switch {
+		case r.commitIndex >= entry.Index && r.log[entry.Index].Term == entry.Term:
+			r.mu.Unlock()
+			return entry.Index, nil
+		case r.term != entry.Term:
+			r.mu.Unlock()
+			return 0, fmt.Errorf("lost leadership before entry %d was committed", entry.Index)
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// appendLocal adds an entry of the current term to the log; r.mu must be held
+func (r *Raft) appendLocal(data []byte) RaftEntry {
+	entry := RaftEntry{Index: r.lastIndex() + 1, Term: r.term, Data: data}
+	r.log = append(r.log, entry)
+	r.advanceCommit()
+	return entry
+}
+
+// campaign starts an election for the next term and asks every member for
+// its vote. Replies are counted as they arrive.
+func (r *Raft) campaign(ctx context.Context) {
+	r.mu.Lock()
+	r.term++
+	r.state = RaftCandidate
+	r.votedFor = r.host.ID()
+	r.votes = 1
+	r.leader = ""
+	r.resetDeadline()
+	r.notify()
+	msg := raftMessage{Type: raftMessageVote, Term: r.term, LastIndex: r.lastIndex(), LastTerm: r.lastTerm()}
+	if r.votes >= r.quorum() {
+		r.becomeLeader()
+	}
+	r.mu.Unlock()
+	logrus.WithField("term", msg.Term).Debug("Starting raft election")
+
+	for _, p := range r.members {
+		go func(p peer.ID) {
+			reply, err := r.send(ctx, p, msg)
+			if err != nil {
+				logrus.WithError(err).WithField("peer", p).Debug("Failed to request raft vote")
+				return
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if reply.Term > r.term {
+				r.stepDown(reply.Term)
+				return
+			}
+			if reply.Success && r.state == RaftCandidate && r.term == msg.Term {
+				r.votes++
+				if r.votes >= r.quorum() {
+					r.becomeLeader()
+				}
+			}
+		}(p)
+	}
+}
+
+// becomeLeader takes over after winning an election; r.mu must be held
+func (r *Raft) becomeLeader() {
+	r.state = RaftLeader
+	r.leader = r.host.ID()
+	for _, p := range r.members {
+		r.nextIndex[p] = r.lastIndex() + 1
+		r.matchIndex[p] = 0
+	}
+	// Entries of earlier terms only commit along with one of ours
+	r.appendLocal(nil)
+	logrus.WithField("term", r.term).Info("Elected raft leader")
+	r.wake()
+}
+
+// stepDown becomes a follower, moving to term if it is newer; r.mu must be held
+func (r *Raft) stepDown(term uint64) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.leader = ""
+	}
+	r.state = RaftFollower
+	r.notify()
+}
+
+// replicate sends every member the entries it is missing, or a heartbeat if
+// it has them all. A member that hasn't answered the last message is skipped.
+func (r *Raft) replicate(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.members {
+		if r.sending[p] {
+			continue
+		}
+		next := r.nextIndex[p]
+		end := min(uint64(len(r.log)), next+maxRaftBatch)
+		msg := raftMessage{
+			Type:         raftMessageAppend,
+			Term:         r.term,
+			PrevIndex:    next - 1,
+			PrevTerm:     r.log[next-1].Term,
+			Entries:      slices.Clone(r.log[next:end]),
+			LeaderCommit: r.commitIndex,
+		}
+		r.sending[p] = true
+		go r.sendAppend(ctx, p, msg)
+	}
+}
+
+// sendAppend sends an append message and updates what we know of the
+// member's log from its reply
+func (r *Raft) sendAppend(ctx context.Context, p peer.ID, msg raftMessage) {
+	reply, err := r.send(ctx, p, msg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sending[p] = false
+	if err != nil {
+		logrus.WithError(err).WithField("peer", p).Debug("Failed to replicate raft log")
+		return
+	}
+	if reply.Term > r.term {
+		r.stepDown(reply.Term)
+		return
+	}
+	if r.state != RaftLeader || r.term != msg.Term {
+		return
+	}
+
+	if reply.Success {
+		r.matchIndex[p] = max(r.matchIndex[p], msg.PrevIndex+uint64(len(msg.Entries)))
+		r.nextIndex[p] = r.matchIndex[p] + 1
+		r.advanceCommit()
+		if r.nextIndex[p] <= r.lastIndex() {
+			r.wake()
+		}
+		return
+	}
+	// The member's log diverges before msg.PrevIndex: back up and try again
+	r.nextIndex[p] = max(1, min(reply.Index+1, msg.PrevIndex))
+	r.wake()
+}
+
+// advanceCommit commits the newest entry of the current term that a majority
+// stored, and with it every entry before; r.mu must be held
+func (r *Raft) advanceCommit() {
+	for index := r.lastIndex(); index > r.commitIndex && r.log[index].Term == r.term; index-- {
+		stored := 1
+		for _, p := range r.members {
+			if r.matchIndex[p] >= index {
+				stored++
+			}
+		}
+		if stored >= r.quorum() {
+			r.commitIndex = index
+			r.notify()
+			return
+		}
+	}
+}
+
+// handleRaft answers a message from another member
+func (r *Raft) handleRaft(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	if !slices.Contains(r.members, remote) {
+		s.Reset()
+		return
+	}
+	s.SetDeadline(time.Now().Add(r.election))
+
+	var msg raftMessage
+	if err := readRaftJSON(s, &msg); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read raft message")
+		return
+	}
+
+	var reply raftReply
+	switch msg.Type {
+	case raftMessageVote:
+		reply = r.handleVote(remote, msg)
+	case raftMessageAppend:
+		reply = r.handleAppend(remote, msg)
+	case raftMessagePropose:
+		reply = r.handlePropose(msg)
+	default:
+		s.Reset()
+		return
+	}
+	if err := writeRaftJSON(s, reply); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to send raft reply")
+	}
+}
+
+// handleVote grants the vote of this term to the first candidate whose log
+// is at least as up to date as ours
+func (r *Raft) handleVote(candidate peer.ID, msg raftMessage) raftReply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if msg.Term > r.term {
+		r.stepDown(msg.Term)
+	}
+	upToDate := msg.LastTerm > r.lastTerm() || (msg.LastTerm == r.lastTerm() && msg.LastIndex >= r.lastIndex())
+	granted := msg.Term == r.term && (r.votedFor == "" || r.votedFor == candidate) && upToDate
+	if granted {
+		r.votedFor = candidate
+		r.resetDeadline()
+	}
+	return raftReply{Term: r.term, Success: granted}
+}
+
+// handleAppend stores the leader's entries if our log matches its log up to
+// them, replacing any conflicting entries
+func (r *Raft) handleAppend(leader peer.ID, msg raftMessage) raftReply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if msg.Term < r.term {
+		return raftReply{Term: r.term}
+	}
+	if msg.Term > r.term || r.state != RaftFollower {
+		r.stepDown(msg.Term)
+	}
+	if r.leader != leader {
+		r.leader = leader
+		logrus.WithFields(logrus.Fields{
+			"term":   r.term,
+			"leader": leader,
+		}).Info("Following raft leader")
+	}
+	r.resetDeadline()
+
+	if msg.PrevIndex > r.lastIndex() {
+		return raftReply{Term: r.term, Index: r.lastIndex()}
+	}
+	if r.log[msg.PrevIndex].Term != msg.PrevTerm {
+		return raftReply{Term: r.term, Index: msg.PrevIndex - 1}
+	}
+
+	for i, entry := range msg.Entries {
+		if entry.Index != msg.PrevIndex+uint64(i)+1 {
+			return raftReply{Term: r.term, Index: msg.PrevIndex}
+		}
+		if entry.Index <= r.lastIndex() {
+			if r.log[entry.Index].Term == entry.Term {
+				continue
+			}
+			r.log = r.log[:entry.Index]
+		}
+		r.log = append(r.log, entry)
+	}
+
+	last := msg.PrevIndex + uint64(len(msg.Entries))
+	if commit := min(msg.LeaderCommit, last); commit > r.commitIndex {
+		r.commitIndex = commit
+		r.notify()
+	}
+	return raftReply{Term: r.term, Success: true, Index: last}
+}
+
+// handlePropose appends an entry a follower forwarded and answers once it is
+// committed
+func (r *Raft) handlePropose(msg raftMessage) raftReply {
+	if len(msg.Data) == 0 || len(msg.Data) > MaxRaftEntrySize {
+		return raftReply{Error: "invalid entry size"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.election)
+	defer cancel()
+
+	index, err := r.propose(ctx, msg.Data)
+	if err != nil {
+		return raftReply{Error: err.Error()}
+	}
+	return raftReply{Success: true, Index: index}
+}
+
+// send delivers a message to a member and returns its reply
+func (r *Raft) send(ctx context.Context, p peer.ID, msg raftMessage) (*raftReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.election)
+	defer cancel()
+
+	s, err := r.host.NewStream(ctx, p, protocol.ID(RaftProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	if err := writeRaftJSON(s, msg); err != nil {
+		return nil, err
+	}
+	s.CloseWrite()
+
+	var reply raftReply
+	if err := readRaftJSON(s, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// lastIndex returns the index of the last entry; r.mu must be held
+func (r *Raft) lastIndex() uint64 {
+	return uint64(len(r.log) - 1)
+}
+
+// lastTerm returns the term of the last entry; r.mu must be held
+func (r *Raft) lastTerm() uint64 {
+	return r.log[len(r.log)-1].Term
+}
+
+// quorum is the number of members, including us, that make a majority
+func (r *Raft) quorum() int {
+	return (len(r.members)+1)/2 + 1
+}
+
+// resetDeadline schedules the next election at a random point between one
+// and two election timeouts from now, so members rarely campaign at once;
+// r.mu must be held
+func (r *Raft) resetDeadline() {
+	r.deadline = time.Now().Add(r.election + time.Duration(rand.Int63n(int64(r.election))))
+}
+
+// notify wakes up appends waiting for a commit; r.mu must be held
+func (r *Raft) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// wake makes Run replicate right away instead of at the next heartbeat
+func (r *Raft) wake() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+// readRaftJSON reads one JSON line
+func readRaftJSON(r io.Reader, v interface{}) error {
+	line, err := bufio.NewReaderSize(io.LimitReader(r, maxRaftMessageSize), maxRaftMessageSize).ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(line, v); err != nil {
+		return fmt.Errorf("invalid raft message: %w", err)
+	}
+	return nil
+}
+
+// writeRaftJSON sends v as one JSON line
+func writeRaftJSON(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode raft message: %w", err)
+	}
+	if len(data) >= maxRaftMessageSize {
+		return fmt.Errorf("raft message too large")
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send raft message: %w", err)
+	}
+	return nil
+}
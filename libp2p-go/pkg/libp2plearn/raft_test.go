@@ -0,0 +1,156 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaft(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	hosts := make([]host.Host, 3)
+	ids := make([]peer.ID, 3)
+	for i := range hosts {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		defer h.Close()
+		hosts[i] = h
+		ids[i] = h.ID()
+	}
+	require.NoError(t, connectNodes(ctx, hosts[0], hosts[1]))
+	require.NoError(t, connectNodes(ctx, hosts[1], hosts[2]))
+	require.NoError(t, connectNodes(ctx, hosts[0], hosts[2]))
+
+	rafts := make([]*Raft, 3)
+	stops := make([]context.CancelFunc, 3)
+	for i, h := range hosts {
+		rafts[i] = NewRaft(h, ids, 300*time.Millisecond, 50*time.Millisecond)
+		defer rafts[i].Close()
+
+		runCtx, stop := context.WithCancel(ctx)
+		stops[i] = stop
+		defer stop()
+		go rafts[i].Run(runCtx)
+	}
+
+	// leaderOf waits until the given members agree on a leader and returns its index
+	leaderOf := func(members ...int) int {
+		leader := -1
+		err := WaitWithCondition(ctx, func() bool {
+			leader = -1
+			var agreed peer.ID
+			for _, i := range members {
+				status := rafts[i].Status()
+				if status.Leader == "" || (agreed != "" && status.Leader != agreed) {
+					return false
+				}
+				agreed = status.Leader
+				if status.State == RaftLeader {
+					leader = i
+				}
+			}
+			return leader >= 0
+		}, 10*time.Second, 20*time.Millisecond)
+		require.NoError(t, err)
+		return leader
+	}
+
+	// committed returns the data of the committed entries, skipping the empty ones of new leaders
+	committed := func(r *Raft) []string {
+		var data []string
+		for _, entry := range r.Committed(0) {
+			if len(entry.Data) > 0 {
+				data = append(data, string(entry.Data))
+			}
+		}
+		return data
+	}
+
+	leader := leaderOf(0, 1, 2)
+	follower := (leader + 1) % 3
+
+	t.Run("AppendOnLeader", func(t *testing.T) {
+		index, err := rafts[leader].Append(ctx, []byte("one"))
+		require.NoError(t, err)
+		assert.Positive(t, index)
+	})
+
+	t.Run("AppendForwardedByFollower", func(t *testing.T) {
+		index, err := rafts[follower].Append(ctx, []byte("two"))
+		require.NoError(t, err)
+
+		for _, r := range rafts {
+			err := WaitWithCondition(ctx, func() bool {
+				return r.Status().CommitIndex >= index
+			}, 5*time.Second, 20*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"one", "two"}, committed(r))
+		}
+	})
+
+	t.Run("InvalidEntries", func(t *testing.T) {
+		_, err := rafts[leader].Append(ctx, nil)
+		assert.Error(t, err)
+		_, err = rafts[leader].Append(ctx, make([]byte, MaxRaftEntrySize+1))
+		assert.Error(t, err)
+	})
+
+	t.Run("NewLeaderAfterFailure", func(t *testing.T) {
+		stops[leader]()
+		rafts[leader].Close()
+		hosts[leader].Close()
+
+		var rest []int
+		for i := range rafts {
+			if i != leader {
+				rest = append(rest, i)
+			}
+		}
+		newLeader := leaderOf(rest...)
+		assert.NotEqual(t, leader, newLeader)
+		assert.Greater(t, rafts[newLeader].Status().Term, rafts[leader].Status().Term)
+
+		_, err := rafts[rest[0]].Append(ctx, []byte("three"))
+		require.NoError(t, err)
+		for _, i := range rest {
+			err := WaitWithCondition(ctx, func() bool {
+				return len(committed(rafts[i])) == 3
+			}, 5*time.Second, 20*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"one", "two", "three"}, committed(rafts[i]))
+		}
+	})
+}
+
+func TestRaftSingleMember(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+
+	r := NewRaft(h, nil, 100*time.Millisecond, 20*time.Millisecond)
+	defer r.Close()
+
+	_, err = r.Append(ctx, []byte("early"))
+	assert.ErrorIs(t, err, ErrNoRaftLeader)
+
+	go r.Run(ctx)
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		return r.Status().State == RaftLeader
+	}, 5*time.Second, 10*time.Millisecond))
+
+	index, err := r.Append(ctx, []byte("solo"))
+	require.NoError(t, err)
+	entries := r.Committed(index)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "solo", string(entries[0].Data))
+}
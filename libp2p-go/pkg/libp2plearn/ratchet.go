@@ -0,0 +1,323 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// maxSkippedMessageKeys bounds the keys kept for messages that arrive out of order
+	maxSkippedMessageKeys = 1000
+
+	x3dhInfo           = "libp2p-learn/x3dh"
+	ratchetRootInfo    = "libp2p-learn/ratchet/root"
+	ratchetMessageInfo = "libp2p-learn/ratchet/message"
+)
+
+// x3dhInit is what the responder needs to derive the session secret. The
+// initiator sends it with every message until the responder has replied.
+type x3dhInit struct {
+	Ephemeral []byte `json:"ephemeral"`
+	Prekey    []byte `json:"prekey"`
+}
+
+// ratchetHeader is sent in the clear with every message
+type ratchetHeader struct {
+	DH []byte `json:"dh"`
+	PN uint32 `json:"pn"`
+	N  uint32 `json:"n"`
+}
+
+// ratchetMessage is one encrypted message
+type ratchetMessage struct {
+	Init       *x3dhInit     `json:"init,omitempty"`
+	Header     ratchetHeader `json:"header"`
+	Ciphertext []byte        `json:"ciphertext"`
+}
+
+// ratchetState is one side of a double ratchet session. It is saved after
+// every message, so message keys are gone from disk once used.
+type ratchetState struct {
+	DHs []byte `json:"dhs"` // our ratchet private key
+	DHr []byte `json:"dhr,omitempty"`
+	RK  []byte `json:"rk"`
+	CKs []byte `json:"cks,omitempty"`
+	CKr []byte `json:"ckr,omitempty"`
+	Ns  uint32 `json:"ns"`
+	Nr  uint32 `json:"nr"`
+	PN  uint32 `json:"pn"`
+
+	// Keys of skipped messages, by ratchet key and message number
+	Skipped map[string][]byte `json:"skipped,omitempty"`
+
+	// Both identity keys, initiator first
+	AD []byte `json:"ad"`
+
+	// Init is set on the initiator until the responder replies
+	Init *x3dhInit `json:"init,omitempty"`
+
+	// PeerInit is the ephemeral key of the peer's init this session came from
+	PeerInit []byte `json:"peer_init,omitempty"`
+}
+
+// x3dhInitiate starts a session with a peer from its identity key and signed prekey
+func x3dhInitiate(identity *ecdh.PrivateKey, remoteIdentity, remotePrekey *ecdh.PublicKey) (*ratchetState, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	sk, err := x3dhSecret(
+		func() ([]byte, error) { return identity.ECDH(remotePrekey) },
+		func() ([]byte, error) { return ephemeral.ECDH(remoteIdentity) },
+		func() ([]byte, error) { return ephemeral.ECDH(remotePrekey) },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The responder's prekey is its first ratchet key
+	dhs, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ratchet key: %w", err)
+	}
+	shared, err := dhs.ECDH(remotePrekey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to agree on key: %w", err)
+	}
+	rk, cks := kdfRK(sk, shared)
+
+	return &ratchetState{
+		DHs:  dhs.Bytes(),
+		DHr:  remotePrekey.Bytes(),
+		RK:   rk,
+		CKs:  cks,
+		AD:   append(identity.PublicKey().Bytes(), remoteIdentity.Bytes()...),
+		Init: &x3dhInit{Ephemeral: ephemeral.PublicKey().Bytes(), Prekey: remotePrekey.Bytes()},
+	}, nil
+}
+
+// x3dhRespond derives the session a peer started with our signed prekey
+func x3dhRespond(identity, prekey *ecdh.PrivateKey, remoteIdentity *ecdh.PublicKey, init *x3dhInit) (*ratchetState, error) {
+	if !bytes.Equal(init.Prekey, prekey.PublicKey().Bytes()) {
+		return nil, fmt.Errorf("session started with an unknown prekey")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(init.Ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	sk, err := x3dhSecret(
+		func() ([]byte, error) { return prekey.ECDH(remoteIdentity) },
+		func() ([]byte, error) { return identity.ECDH(ephemeral) },
+		func() ([]byte, error) { return prekey.ECDH(ephemeral) },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ratchetState{
+		DHs:      prekey.Bytes(),
+		RK:       sk,
+		AD:       append(remoteIdentity.Bytes(), identity.PublicKey().Bytes()...),
+		PeerInit: init.Ephemeral,
+	}, nil
+}
+
+// x3dhSecret combines the three agreements into the session secret
+func x3dhSecret(agreements ...func() ([]byte, error)) ([]byte, error) {
+	// 32 0xFF bytes separate the input from other uses of the same keys
+	ikm := bytes.Repeat([]byte{0xff}, 32)
+	for _, agree := range agreements {
+		dh, err := agree()
+		if err != nil {
+			return nil, fmt.Errorf("failed to agree on key: %w", err)
+		}
+		ikm = append(ikm, dh...)
+	}
+	sk := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, ikm, make([]byte, 32), []byte(x3dhInfo)), sk)
+	return sk, nil
+}
+
+// encrypt seals a message with the next sending key
+func (s *ratchetState) encrypt(plaintext []byte) (*ratchetMessage, error) {
+	if s.CKs == nil {
+		return nil, fmt.Errorf("session can't send before the peer's first message")
+	}
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratchet key: %w", err)
+	}
+
+	var mk []byte
+	s.CKs, mk = kdfCK(s.CKs)
+	header := ratchetHeader{DH: dhs.PublicKey().Bytes(), PN: s.PN, N: s.Ns}
+	s.Ns++
+
+	ciphertext, err := sealRatchetMessage(mk, plaintext, s.associatedData(header))
+	if err != nil {
+		return nil, err
+	}
+	return &ratchetMessage{Init: s.Init, Header: header, Ciphertext: ciphertext}, nil
+}
+
+// decrypt opens a message, leaving the state untouched if it can't
+func (s *ratchetState) decrypt(msg *ratchetMessage) ([]byte, error) {
+	next := *s
+	next.Skipped = maps.Clone(s.Skipped)
+	plaintext, err := next.open(msg)
+	if err != nil {
+		return nil, err
+	}
+	// The peer has the session, so it no longer needs our init
+	next.Init = nil
+	*s = next
+	return plaintext, nil
+}
+
+func (s *ratchetState) open(msg *ratchetMessage) ([]byte, error) {
+	header := msg.Header
+	key := skippedKey(header.DH, header.N)
+	if mk, ok := s.Skipped[key]; ok {
+		delete(s.Skipped, key)
+		return openRatchetMessage(mk, msg.Ciphertext, s.associatedData(header))
+	}
+
+	if !bytes.Equal(header.DH, s.DHr) {
+		if err := s.skip(header.PN); err != nil {
+			return nil, err
+		}
+		if err := s.step(header.DH); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.skip(header.N); err != nil {
+		return nil, err
+	}
+
+	var mk []byte
+	s.CKr, mk = kdfCK(s.CKr)
+	s.Nr++
+	return openRatchetMessage(mk, msg.Ciphertext, s.associatedData(header))
+}
+
+// skip stores the keys of messages on the receiving chain up to n
+func (s *ratchetState) skip(n uint32) error {
+	if s.CKr == nil || n <= s.Nr {
+		return nil
+	}
+	if int(n-s.Nr)+len(s.Skipped) > maxSkippedMessageKeys {
+		return fmt.Errorf("too many skipped messages")
+	}
+	if s.Skipped == nil {
+		s.Skipped = make(map[string][]byte)
+	}
+	for s.Nr < n {
+		var mk []byte
+		s.CKr, mk = kdfCK(s.CKr)
+		s.Skipped[skippedKey(s.DHr, s.Nr)] = mk
+		s.Nr++
+	}
+	return nil
+}
+
+// step performs a DH ratchet step with the peer's new ratchet key
+func (s *ratchetState) step(dh []byte) error {
+	remote, err := ecdh.X25519().NewPublicKey(dh)
+	if err != nil {
+		return fmt.Errorf("invalid ratchet key: %w", err)
+	}
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return fmt.Errorf("invalid ratchet key: %w", err)
+	}
+
+	s.PN, s.Ns, s.Nr = s.Ns, 0, 0
+	s.DHr = dh
+	shared, err := dhs.ECDH(remote)
+	if err != nil {
+		return fmt.Errorf("failed to agree on key: %w", err)
+	}
+	s.RK, s.CKr = kdfRK(s.RK, shared)
+
+	next, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate ratchet key: %w", err)
+	}
+	s.DHs = next.Bytes()
+	shared, err = next.ECDH(remote)
+	if err != nil {
+		return fmt.Errorf("failed to agree on key: %w", err)
+	}
+	s.RK, s.CKs = kdfRK(s.RK, shared)
+	return nil
+}
+
+// associatedData binds a message to both identities and its header
+func (s *ratchetState) associatedData(h ratchetHeader) []byte {
+	ad := append([]byte{}, s.AD...)
+	ad = append(ad, h.DH...)
+	ad = binary.BigEndian.AppendUint32(ad, h.PN)
+	return binary.BigEndian.AppendUint32(ad, h.N)
+}
+
+func skippedKey(dh []byte, n uint32) string {
+	return hex.EncodeToString(dh) + ":" + strconv.FormatUint(uint64(n), 10)
+}
+
+// kdfRK derives the next root key and a chain key
+func kdfRK(rk, dh []byte) ([]byte, []byte) {
+	out := make([]byte, 64)
+	io.ReadFull(hkdf.New(sha256.New, dh, rk, []byte(ratchetRootInfo)), out)
+	return out[:32], out[32:]
+}
+
+// kdfCK derives the next chain key and a message key
+func kdfCK(ck []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x01})
+	mk := mac.Sum(nil)
+	mac.Reset()
+	mac.Write([]byte{0x02})
+	return mac.Sum(nil), mk
+}
+
+// sealRatchetMessage encrypts with a message key, which is used only once
+func sealRatchetMessage(mk, plaintext, ad []byte) ([]byte, error) {
+	key, nonce := messageCipherKey(mk)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+func openRatchetMessage(mk, ciphertext, ad []byte) ([]byte, error) {
+	key, nonce := messageCipherKey(mk)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message")
+	}
+	return plaintext, nil
+}
+
+func messageCipherKey(mk []byte) ([]byte, []byte) {
+	out := make([]byte, chacha20poly1305.KeySize+chacha20poly1305.NonceSize)
+	io.ReadFull(hkdf.New(sha256.New, mk, nil, []byte(ratchetMessageInfo)), out)
+	return out[:chacha20poly1305.KeySize], out[chacha20poly1305.KeySize:]
+}
@@ -0,0 +1,149 @@
+package libp2plearn
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRatchetPair starts a session between two fresh identities
+func newRatchetPair(t *testing.T) (*ratchetState, *ratchetState, *ecdh.PrivateKey, *ecdh.PrivateKey) {
+	t.Helper()
+	alice, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	bob, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	bobPrekey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	a, err := x3dhInitiate(alice, bob.PublicKey(), bobPrekey.PublicKey())
+	require.NoError(t, err)
+	msg, err := a.encrypt([]byte("hello"))
+	require.NoError(t, err)
+	require.NotNil(t, msg.Init)
+
+	b, err := x3dhRespond(bob, bobPrekey, alice.PublicKey(), msg.Init)
+	require.NoError(t, err)
+	plaintext, err := b.decrypt(msg)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(plaintext))
+	return a, b, alice, bob
+}
+
+func TestDoubleRatchet(t *testing.T) {
+	t.Run("Conversation", func(t *testing.T) {
+		a, b, _, _ := newRatchetPair(t)
+
+		send := func(from, to *ratchetState, text string) {
+			msg, err := from.encrypt([]byte(text))
+			require.NoError(t, err)
+			plaintext, err := to.decrypt(msg)
+			require.NoError(t, err)
+			assert.Equal(t, text, string(plaintext))
+		}
+		send(b, a, "hi alice")
+		assert.Nil(t, a.Init, "the init is dropped once the responder replied")
+		send(a, b, "how are you")
+		send(a, b, "still there?")
+		send(b, a, "yes")
+	})
+
+	t.Run("NewRatchetKeyEachTurn", func(t *testing.T) {
+		a, b, _, _ := newRatchetPair(t)
+
+		m1, err := b.encrypt([]byte("one"))
+		require.NoError(t, err)
+		_, err = a.decrypt(m1)
+		require.NoError(t, err)
+		m2, err := a.encrypt([]byte("two"))
+		require.NoError(t, err)
+		_, err = b.decrypt(m2)
+		require.NoError(t, err)
+		m3, err := b.encrypt([]byte("three"))
+		require.NoError(t, err)
+		assert.NotEqual(t, m1.Header.DH, m3.Header.DH)
+	})
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		a, b, _, _ := newRatchetPair(t)
+
+		var msgs []*ratchetMessage
+		for _, text := range []string{"1", "2", "3"} {
+			msg, err := b.encrypt([]byte(text))
+			require.NoError(t, err)
+			msgs = append(msgs, msg)
+		}
+		for _, i := range []int{2, 0, 1} {
+			plaintext, err := a.decrypt(msgs[i])
+			require.NoError(t, err)
+			assert.Equal(t, msgs[i].Header.N+1, uint32(plaintext[0]-'0'))
+		}
+		assert.Empty(t, a.Skipped)
+
+		_, err := a.decrypt(msgs[0])
+		assert.Error(t, err, "a message key can only be used once")
+	})
+
+	t.Run("TamperedLeavesStateUnchanged", func(t *testing.T) {
+		a, b, _, _ := newRatchetPair(t)
+
+		msg, err := b.encrypt([]byte("secret"))
+		require.NoError(t, err)
+		before, err := json.Marshal(a)
+		require.NoError(t, err)
+
+		tampered := *msg
+		tampered.Ciphertext = append([]byte{}, msg.Ciphertext...)
+		tampered.Ciphertext[0] ^= 1
+		_, err = a.decrypt(&tampered)
+		require.Error(t, err)
+		after, err := json.Marshal(a)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(before), string(after))
+
+		plaintext, err := a.decrypt(msg)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(plaintext))
+	})
+
+	t.Run("SurvivesSerialization", func(t *testing.T) {
+		a, b, _, _ := newRatchetPair(t)
+
+		data, err := json.Marshal(b)
+		require.NoError(t, err)
+		var restored ratchetState
+		require.NoError(t, json.Unmarshal(data, &restored))
+
+		msg, err := restored.encrypt([]byte("after restart"))
+		require.NoError(t, err)
+		plaintext, err := a.decrypt(msg)
+		require.NoError(t, err)
+		assert.Equal(t, "after restart", string(plaintext))
+	})
+
+	t.Run("WrongIdentity", func(t *testing.T) {
+		alice, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		bob, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		mallory, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		prekey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		a, err := x3dhInitiate(alice, bob.PublicKey(), prekey.PublicKey())
+		require.NoError(t, err)
+		msg, err := a.encrypt([]byte("for bob"))
+		require.NoError(t, err)
+
+		// Bob believing the message came from someone else can't open it
+		b, err := x3dhRespond(bob, prekey, mallory.PublicKey(), msg.Init)
+		require.NoError(t, err)
+		_, err = b.decrypt(msg)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,39 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// streamHandlerPanics counts panics recovered in stream handlers
+var streamHandlerPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "libp2p_learn",
+	Name:      "stream_handler_panics_total",
+	Help:      "Number of panics recovered in stream handlers",
+}, []string{"protocol"})
+
+// RecoveryMiddleware resets the stream instead of crashing the node when its handler panics
+func RecoveryMiddleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		defer func() {
+			if r := recover(); r != nil {
+				streamHandlerPanics.WithLabelValues(string(proto)).Inc()
+				logrus.WithFields(logrus.Fields{
+					"protocol": proto,
+					"peer":     s.Conn().RemotePeer(),
+					"panic":    fmt.Sprint(r),
+					"stack":    string(debug.Stack()),
+				}).Error("Stream handler panicked, resetting stream")
+				s.Reset()
+			}
+		}()
+
+		next(s)
+	}
+}
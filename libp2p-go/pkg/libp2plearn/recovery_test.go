@@ -0,0 +1,62 @@
+package libp2plearn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	const panicProtocol = "/libp2p-learn/test-panic/1.0.0"
+
+	serverHandler := NewProtocolHandler(server)
+	serverHandler.SetupProtocols()
+	serverHandler.Handle(panicProtocol, func(s network.Stream) {
+		panic("malformed message")
+	})
+
+	err = connectNodes(ctx, client, server)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, client, server, 10*time.Second)
+	require.NoError(t, err)
+
+	before := testutil.ToFloat64(streamHandlerPanics.WithLabelValues(panicProtocol))
+
+	t.Run("PanicResetsStream", func(t *testing.T) {
+		s, err := client.NewStream(ctx, server.ID(), protocol.ID(panicProtocol))
+		require.NoError(t, err)
+		defer s.Close()
+
+		_, err = s.Write([]byte("boom\n"))
+		require.NoError(t, err)
+
+		_, err = io.ReadAll(s)
+		assert.Error(t, err, "Stream should be reset")
+		assert.Equal(t, before+1, testutil.ToFloat64(streamHandlerPanics.WithLabelValues(panicProtocol)))
+	})
+
+	t.Run("NodeKeepsServing", func(t *testing.T) {
+		response, err := NewProtocolHandler(client).SendPing(ctx, server.ID(), "still alive")
+		require.NoError(t, err)
+		assert.Equal(t, "pong: still alive", response)
+	})
+}
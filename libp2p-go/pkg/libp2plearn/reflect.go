@@ -0,0 +1,524 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ReflectProtocol describes the protocols a node serves
+	ReflectProtocol = "/libp2p-learn/reflect/1.0.0"
+
+	// reflectTimeout bounds fetching the description of a peer
+	reflectTimeout = 10 * time.Second
+
+	// maxReflectResponseSize bounds the size of a description
+	maxReflectResponseSize = 1 << 20
+)
+
+// How the messages of a protocol are put on the stream
+const (
+	EncodingLines     = "lines"      // newline-terminated text
+	EncodingJSONLines = "json-lines" // one JSON object per line
+	EncodingFrames    = "frames"     // a type byte, a big-endian uint32 length and the payload
+	EncodingRaw       = "raw"        // bytes with no framing
+)
+
+// Message directions, from the point of view of the client that opened the stream
+const (
+	DirectionRequest  = "request"
+	DirectionResponse = "response"
+	DirectionBoth     = "both"
+)
+
+// ProtocolDoc describes a protocol well enough for a client to speak it:
+// how messages are framed, their fields and the limits the server enforces
+type ProtocolDoc struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name,omitempty"`
+	Version     string          `json:"version,omitempty"`
+	Summary     string          `json:"summary,omitempty"`
+	Encoding    string          `json:"encoding,omitempty"`
+	Schema      string          `json:"schema,omitempty"` // protobuf package of its messages, see ProtoSchema
+	Messages    []MessageSchema `json:"messages,omitempty"`
+	Limits      *ProtocolLimits `json:"limits,omitempty"`
+	Compression []string        `json:"compression,omitempty"` // algorithms it is also served compressed with
+}
+
+// ProtocolLimits are the bounds a server enforces on a protocol's streams
+type ProtocolLimits struct {
+	MaxMessageSize int64    `json:"max_message_size,omitempty"` // bytes in one message
+	Timeout        Duration `json:"timeout,omitempty"`          // for the exchange, or between messages for long-lived streams
+}
+
+// MessageSchema describes one message of a protocol. JSON messages list
+// their fields; others are described in Doc.
+type MessageSchema struct {
+	Name      string        `json:"name"`
+	Direction string        `json:"direction"`
+	Doc       string        `json:"doc,omitempty"`
+	Fields    []FieldSchema `json:"fields,omitempty"`
+}
+
+// FieldSchema describes a JSON field. Type is string, integer, number,
+// boolean, bytes (base64), time (RFC 3339), duration (like "1.5s"), peer_id,
+// cid, json, object, array<type> or map<type>. Objects, and arrays and maps
+// of objects, list their fields.
+type FieldSchema struct {
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Optional bool          `json:"optional,omitempty"`
+	Fields   []FieldSchema `json:"fields,omitempty"`
+}
+
+// ProtocolReflection is what a node tells about the protocols it serves
+type ProtocolReflection struct {
+	PeerID    peer.ID       `json:"peer_id"`
+	Protocols []ProtocolDoc `json:"protocols"`
+}
+
+// SchemaOf returns the schema of a JSON message from the Go type of v
+func SchemaOf(name, direction string, v interface{}) MessageSchema {
+	return MessageSchema{Name: name, Direction: direction, Fields: schemaFields(reflect.TypeOf(v), make(map[reflect.Type]bool))}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(Duration(0))
+	peerIDType   = reflect.TypeOf(peer.ID(""))
+	cidType      = reflect.TypeOf(cid.Cid{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// schemaFields lists the JSON fields of a struct type. Types already being
+// listed, seen, aren't listed again, so recursive types end.
+func schemaFields(t reflect.Type, seen map[reflect.Type]bool) []FieldSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || t == cidType || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	var fields []FieldSchema
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		typ, elem := schemaType(f.Type)
+		fields = append(fields, FieldSchema{
+			Name:     name,
+			Type:     typ,
+			Optional: strings.Contains(opts, "omitempty"),
+			Fields:   schemaFields(elem, seen),
+		})
+	}
+	return fields
+}
+
+// schemaType names the JSON type of a Go type, and returns the type whose
+// fields describe it further
+func schemaType(t reflect.Type) (string, reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return "time", t
+	case durationType:
+		return "duration", t
+	case peerIDType:
+		return "peer_id", t
+	case cidType:
+		return "cid", t
+	case rawJSONType:
+		return "json", t
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string", t
+	case reflect.Bool:
+		return "boolean", t
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer", t
+	case reflect.Float32, reflect.Float64:
+		return "number", t
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", t
+		}
+		elem, inner := schemaType(t.Elem())
+		return "array<" + elem + ">", inner
+	case reflect.Map:
+		elem, inner := schemaType(t.Elem())
+		return "map<" + elem + ">", inner
+	case reflect.Struct:
+		return "object", t
+	default:
+		return "json", t
+	}
+}
+
+// Reflect answers peers that ask which protocols this node serves, with
+// documentation for the ones it knows, so clients can adapt to what a server
+// supports instead of hardcoding it
+type Reflect struct {
+	host host.Host
+
+	mu   sync.Mutex
+	docs map[string]ProtocolDoc
+}
+
+// NewReflect creates the reflection service, documenting the built-in
+// protocols, and registers its protocol handler
+func NewReflect(h host.Host) *Reflect {
+	r := &Reflect{host: h, docs: make(map[string]ProtocolDoc)}
+	for _, doc := range builtinProtocolDocs() {
+		r.Document(doc)
+	}
+	h.SetStreamHandler(protocol.ID(ReflectProtocol), RecoveryMiddleware(protocol.ID(ReflectProtocol), r.handleReflect))
+	logrus.WithField("protocol", ReflectProtocol).Info("Registered reflect protocol")
+	return r
+}
+
+// Close unregisters the reflect protocol
+func (r *Reflect) Close() {
+	r.host.RemoveStreamHandler(protocol.ID(ReflectProtocol))
+}
+
+// Document adds or replaces the documentation of a protocol. It is only
+// shown while the protocol is served.
+func (r *Reflect) Document(doc ProtocolDoc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.docs[doc.ID] = doc
+}
+
+// Describe returns the protocols this node serves, documented where known.
+// Compressed variants are folded into the protocol they compress.
+func (r *Reflect) Describe() ProtocolReflection {
+	served := make(map[string][]string)
+	for _, id := range r.host.Mux().Protocols() {
+		if alg := compressionOf(id); alg != "" {
+			base := strings.TrimSuffix(string(id), "-"+alg)
+			served[base] = append(served[base], alg)
+			continue
+		}
+		if _, ok := served[string(id)]; !ok {
+			served[string(id)] = nil
+		}
+	}
+
+	r.mu.Lock()
+	protocols := make([]ProtocolDoc, 0, len(served))
+	for id, algs := range served {
+		doc, ok := r.docs[id]
+		if !ok {
+			doc = ProtocolDoc{ID: id}
+		}
+		if name, version, ok := splitProtocolVersion(protocol.ID(id)); ok {
+			doc.Name = name
+			doc.Version = fmt.Sprintf("%d.%d.%d", version.major, version.minor, version.patch)
+		}
+		if pkg, ok := SchemaPackage(protocol.ID(id)); ok {
+			doc.Schema = pkg
+		}
+		sort.Strings(algs)
+		doc.Compression = algs
+		protocols = append(protocols, doc)
+	}
+	r.mu.Unlock()
+	sort.Slice(protocols, func(i, j int) bool { return protocols[i].ID < protocols[j].ID })
+
+	return ProtocolReflection{PeerID: r.host.ID(), Protocols: protocols}
+}
+
+// handleReflect sends our description as one JSON line
+func (r *Reflect) handleReflect(s network.Stream) {
+	defer s.Close()
+
+	data, err := json.Marshal(r.Describe())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode protocol description")
+		s.Reset()
+		return
+	}
+	s.SetWriteDeadline(time.Now().Add(reflectTimeout))
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).WithField("peer", s.Conn().RemotePeer()).Debug("Failed to send protocol description")
+	}
+}
+
+// Fetch asks a peer to describe the protocols it serves
+func (r *Reflect) Fetch(ctx context.Context, p peer.ID) (*ProtocolReflection, error) {
+	ctx, cancel := context.WithTimeout(ctx, reflectTimeout)
+	defer cancel()
+
+	s, err := r.host.NewStream(ctx, p, protocol.ID(ReflectProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	s.CloseWrite()
+
+	deadline, _ := ctx.Deadline()
+	s.SetReadDeadline(deadline)
+	line, err := bufio.NewReaderSize(io.LimitReader(s, maxReflectResponseSize), maxReflectResponseSize).ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read protocol description: %w", err)
+	}
+	var reflection ProtocolReflection
+	if err := json.Unmarshal(line, &reflection); err != nil {
+		return nil, fmt.Errorf("invalid protocol description: %w", err)
+	}
+	if reflection.PeerID != p {
+		return nil, fmt.Errorf("protocol description is of %s, not %s", reflection.PeerID, p)
+	}
+	return &reflection, nil
+}
+
+// Reflect returns the service describing the protocols this node serves
+func (n *Node) Reflect() *Reflect {
+	return n.reflect
+}
+
+// protocolLimits returns the limits of a protocol
+func protocolLimits(maxMessageSize int64, timeout time.Duration) *ProtocolLimits {
+	return &ProtocolLimits{MaxMessageSize: maxMessageSize, Timeout: Duration(timeout)}
+}
+
+// builtinProtocolDocs documents the protocols this package serves
+func builtinProtocolDocs() []ProtocolDoc {
+	return []ProtocolDoc{
+		{
+			ID:       PingProtocol,
+			Summary:  "Answers a line of text with the same line prefixed by \"pong: \"",
+			Encoding: EncodingLines,
+			Messages: []MessageSchema{
+				{Name: "ping", Direction: DirectionRequest, Doc: "any line of text"},
+				{Name: "pong", Direction: DirectionResponse, Doc: "\"pong: \" and the ping line, or a script's reply"},
+			},
+		},
+		{
+			ID:       ChatProtocol,
+			Summary:  "Exchanges chat messages, one per line, until either side closes",
+			Encoding: EncodingLines,
+			Messages: []MessageSchema{
+				{Name: "message", Direction: DirectionBoth, Doc: "a line of text"},
+			},
+		},
+		{
+			ID:       EchoProtocol,
+			Summary:  "Sends back every byte it receives",
+			Encoding: EncodingRaw,
+		},
+		{
+			ID:       EchoV2Protocol,
+			Summary:  "Echoes a payload in checksummed chunks, then confirms its length and SHA-256",
+			Encoding: EncodingFrames,
+			Messages: []MessageSchema{
+				{Name: "data", Direction: DirectionBoth, Doc: "frame type 1: the CRC-32C of the chunk as uint32, then the chunk"},
+				{Name: "end", Direction: DirectionBoth, Doc: "frame type 2: the total length as uint64, then the SHA-256 of all data"},
+				{Name: "error", Direction: DirectionResponse, Doc: "frame type 3: why the payload was rejected, as text"},
+			},
+			Limits: protocolLimits(maxEchoChunkSize, echoRejectTimeout),
+		},
+		{
+			ID:       ChatSessionProtocol,
+			Summary:  "Chat session with numbered messages, typing indicators and read receipts",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("frame", DirectionBoth, chatFrame{})},
+			Limits:   protocolLimits(maxChatFrameSize, chatWriteTimeout),
+		},
+		{
+			ID:       ChatResumeProtocol,
+			Summary:  "Moves a chat session onto this stream, such as off a relay once a direct connection is up",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("resume", DirectionRequest, chatFrame{}),
+				{Name: "ack", Direction: DirectionResponse, Doc: "an empty resume frame once the session is on this stream; then chat session frames"},
+			},
+			Limits: protocolLimits(maxChatFrameSize, chatWriteTimeout),
+		},
+		{
+			ID:       AdminProtocol,
+			Summary:  "Runs an admin command for admin peers",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("request", DirectionRequest, AdminRequest{}),
+				SchemaOf("response", DirectionResponse, AdminResponse{}),
+			},
+			Limits: protocolLimits(maxAdminMessageSize, adminTimeout),
+		},
+		{
+			ID:       BlobProtocol,
+			Summary:  "Serves a block of stored content by CID",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("request", DirectionRequest, blobRequest{}),
+				SchemaOf("response", DirectionResponse, blobResponse{}),
+				{Name: "block", Direction: DirectionResponse, Doc: "the size bytes of the block, after a response without error"},
+			},
+			Limits: protocolLimits(maxBlobManifestSize, blobRequestTimeout),
+		},
+		{
+			ID:       CapabilitiesProtocol,
+			Summary:  "Sends signed service records, the server's own first",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("records", DirectionResponse, capabilitiesResponse{})},
+			Limits:   protocolLimits(maxCapabilitiesMessageSize, capabilitiesTimeout),
+		},
+		{
+			ID:       ConfigPushProtocol,
+			Summary:  "Applies a configuration update signed by an admin peer",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("update", DirectionRequest, SignedConfigUpdate{}),
+				SchemaOf("ack", DirectionResponse, ConfigAck{}),
+			},
+			Limits: protocolLimits(maxConfigUpdateSize, adminTimeout),
+		},
+		{
+			ID:       GoodbyeProtocol,
+			Summary:  "Announces an intentional disconnect and its reason",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("goodbye", DirectionRequest, goodbyeMessage{})},
+			Limits:   protocolLimits(maxGoodbyeSize, goodbyeTimeout),
+		},
+		{
+			ID:       HealthProtocol,
+			Summary:  "Sends the node's health report",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("report", DirectionResponse, HealthReport{})},
+			Limits:   protocolLimits(maxHealthReportSize, healthTimeout),
+		},
+		{
+			ID:       KVProtocol,
+			Summary:  "Replicates key/value spaces; a sync message is answered with the server's full state",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("message", DirectionBoth, kvMessage{})},
+			Limits:   protocolLimits(maxKVMessageSize, kvTimeout),
+		},
+		{
+			ID:       LogStreamProtocol,
+			Summary:  "Collects the log entries of known sources",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("entry", DirectionRequest, LogEntry{})},
+			Limits:   protocolLimits(maxLogEntrySize, 0),
+		},
+		{
+			ID:       MailboxProtocol,
+			Summary:  "Stores a sealed letter for a peer, or hands the requester the letters stored for it",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("request", DirectionRequest, mailboxRequest{}),
+				SchemaOf("response", DirectionResponse, mailboxResponse{}),
+			},
+			Limits: protocolLimits(maxMailboxMessageSize, mailboxTimeout),
+		},
+		{
+			ID:       RoomProtocol,
+			Summary:  "Swaps a room's signed membership records between members, and admits an invited peer",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("request", DirectionRequest, roomRequest{}),
+				SchemaOf("response", DirectionResponse, roomResponse{}),
+			},
+			Limits: protocolLimits(maxRoomMessageSize, roomTimeout),
+		},
+		{
+			ID:       RaftProtocol,
+			Summary:  "Raft votes, log replication and proposals between cluster members",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("message", DirectionRequest, raftMessage{}),
+				SchemaOf("reply", DirectionResponse, raftReply{}),
+			},
+			Limits: protocolLimits(maxRaftMessageSize, 0),
+		},
+		{
+			ID:       PrekeyProtocol,
+			Summary:  "Sends the signed prekey secure chat sessions start from",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("bundle", DirectionResponse, prekeyBundle{})},
+			Limits:   protocolLimits(maxPrekeyBundleSize, prekeyTimeout),
+		},
+		{
+			ID:       ShellProtocol,
+			Summary:  "Runs a command or interactive shell for authorized peers",
+			Encoding: EncodingFrames,
+			Messages: []MessageSchema{
+				SchemaOf("request", DirectionRequest, ShellRequest{}),
+				{Name: "stdin", Direction: DirectionRequest, Doc: "frame type 0: input"},
+				{Name: "stdout", Direction: DirectionResponse, Doc: "frame type 1: output, all of it with a PTY"},
+				{Name: "stderr", Direction: DirectionResponse, Doc: "frame type 2: error output"},
+				{Name: "resize", Direction: DirectionRequest, Doc: "frame type 3: rows and cols as uint16"},
+				{Name: "stdin_eof", Direction: DirectionRequest, Doc: "frame type 4: input is done"},
+				{Name: "exit", Direction: DirectionResponse, Doc: "frame type 5: exit status as int32, the last frame"},
+			},
+			Limits: protocolLimits(maxShellFrameSize, shellRequestTimeout),
+		},
+		{
+			ID:       TimeSyncProtocol,
+			Summary:  "Measures clock offsets: the server echoes each request with its receive and transmit times",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("sample", DirectionBoth, timeSyncMessage{})},
+			Limits:   protocolLimits(maxTimeSyncMessageSize, timeSyncTimeout),
+		},
+		{
+			ID:       TransferProtocol,
+			Summary:  "Receives a directory from authorized peers as an offer, then a tar stream",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{
+				SchemaOf("offer", DirectionRequest, TransferOffer{}),
+				SchemaOf("reply", DirectionResponse, TransferReply{}),
+				{Name: "tar", Direction: DirectionRequest, Doc: "a tar stream of the directory, after an accepted offer; another reply follows it"},
+			},
+			Limits: protocolLimits(maxTransferLineSize, transferIdleTimeout),
+		},
+		{
+			ID:       PairingProtocol,
+			Summary:  "Pairs with a peer that knows the PIN this node shows, over a SPAKE2 handshake",
+			Encoding: EncodingRaw,
+			Messages: []MessageSchema{
+				{Name: "share", Direction: DirectionRequest, Doc: "the client's 256-byte SPAKE2 share"},
+				{Name: "reply", Direction: DirectionResponse, Doc: "the server's 256-byte share and 32-byte key confirmation"},
+				{Name: "confirm", Direction: DirectionRequest, Doc: "the client's 32-byte key confirmation"},
+				{Name: "ack", Direction: DirectionResponse, Doc: "one byte 1 once paired"},
+			},
+			Limits: protocolLimits(spake2MessageSize+32, pairingTimeout),
+		},
+		{
+			ID:       ReflectProtocol,
+			Summary:  "Describes the protocols this node serves",
+			Encoding: EncodingJSONLines,
+			Messages: []MessageSchema{SchemaOf("description", DirectionResponse, ProtocolReflection{})},
+			Limits:   protocolLimits(maxReflectResponseSize, reflectTimeout),
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaOf(t *testing.T) {
+	t.Run("Fields", func(t *testing.T) {
+		schema := SchemaOf("offer", DirectionRequest, TransferOffer{})
+		assert.Equal(t, "offer", schema.Name)
+		assert.Equal(t, DirectionRequest, schema.Direction)
+		require.Len(t, schema.Fields, 4)
+		assert.Equal(t, FieldSchema{Name: "name", Type: "string"}, schema.Fields[0])
+		assert.Equal(t, FieldSchema{Name: "bytes", Type: "integer"}, schema.Fields[2])
+
+		resumable := schema.Fields[3]
+		assert.Equal(t, "resumable", resumable.Name)
+		assert.Equal(t, "array<object>", resumable.Type)
+		assert.True(t, resumable.Optional)
+		assert.Len(t, resumable.Fields, 3)
+	})
+
+	t.Run("SpecialTypes", func(t *testing.T) {
+		schema := SchemaOf("message", DirectionBoth, kvMessage{})
+		entries := schema.Fields[2]
+		assert.Equal(t, "map<object>", entries.Type)
+		types := make(map[string]string)
+		for _, f := range entries.Fields {
+			types[f.Name] = f.Type
+		}
+		assert.Equal(t, map[string]string{"value": "bytes", "clock": "integer", "writer": "peer_id", "deleted": "boolean"}, types)
+
+		update := SchemaOf("update", DirectionRequest, ConfigUpdate{})
+		assert.Equal(t, "time", update.Fields[1].Type)
+		assert.Equal(t, "json", update.Fields[2].Type)
+	})
+
+	t.Run("Recursive", func(t *testing.T) {
+		schema := SchemaOf("description", DirectionResponse, ProtocolReflection{})
+		require.Len(t, schema.Fields, 2)
+		assert.Equal(t, "array<object>", schema.Fields[1].Type)
+		assert.NotEmpty(t, schema.Fields[1].Fields)
+	})
+}
+
+func TestReflect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer server.Stop(ctx)
+
+	client, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer client.Stop(ctx)
+
+	require.NoError(t, connectNodes(ctx, client.Host(), server.Host()))
+
+	t.Run("Describe", func(t *testing.T) {
+		reflection, err := client.Reflect().Fetch(ctx, server.Host().ID())
+		require.NoError(t, err)
+		assert.Equal(t, server.Host().ID(), reflection.PeerID)
+
+		docs := make(map[string]ProtocolDoc)
+		for _, doc := range reflection.Protocols {
+			docs[doc.ID] = doc
+		}
+
+		health, ok := docs[HealthProtocol]
+		require.True(t, ok)
+		assert.Equal(t, "/libp2p-learn/health", health.Name)
+		assert.Equal(t, "1.0.0", health.Version)
+		assert.Equal(t, EncodingJSONLines, health.Encoding)
+		assert.Equal(t, Duration(healthTimeout), health.Limits.Timeout)
+		require.Len(t, health.Messages, 1)
+		assert.Equal(t, DirectionResponse, health.Messages[0].Direction)
+		assert.NotEmpty(t, health.Messages[0].Fields)
+
+		echo, ok := docs[EchoProtocol]
+		require.True(t, ok)
+		assert.Contains(t, echo.Compression, CompressionZstd)
+		for id := range docs {
+			assert.Empty(t, compressionOf(protocol.ID(id)), "compressed variant %s listed on its own", id)
+		}
+
+		// Protocols from libp2p itself are listed without documentation
+		assert.Contains(t, docs, "/ipfs/id/1.0.0")
+	})
+
+	t.Run("Document", func(t *testing.T) {
+		require.NoError(t, server.Protocols().Register("/custom/thing/1.2.0", func(s network.Stream) { s.Close() }))
+		server.Reflect().Document(ProtocolDoc{ID: "/custom/thing/1.2.0", Summary: "does a thing"})
+
+		var custom ProtocolDoc
+		for _, doc := range server.Reflect().Describe().Protocols {
+			if doc.ID == "/custom/thing/1.2.0" {
+				custom = doc
+			}
+		}
+		assert.Equal(t, "does a thing", custom.Summary)
+		assert.Equal(t, "1.2.0", custom.Version)
+
+		// Documentation of protocols that aren't served isn't shown
+		require.NoError(t, server.Protocols().Unregister("/custom/thing/1.2.0"))
+		for _, doc := range server.Reflect().Describe().Protocols {
+			assert.NotEqual(t, "/custom/thing/1.2.0", doc.ID)
+		}
+	})
+}
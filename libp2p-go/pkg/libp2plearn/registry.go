@@ -0,0 +1,126 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// RegisteredProtocol describes a protocol registered with Register, or by
+// SetupProtocols, in the protocols admin command
+type RegisteredProtocol struct {
+	ID         string `json:"id"`
+	Registered bool   `json:"registered"` // false once unregistered, until registered again
+	Compressed bool   `json:"compressed,omitempty"`
+}
+
+// registration is a protocol's handler as it was registered
+type registration struct {
+	handler    network.StreamHandler
+	compressed bool // also registered under its compressed IDs
+	active     bool
+}
+
+// Register adds a protocol while the node runs, wrapped in the middlewares
+// and versioned like HandleVersion. Peers learn about it through identify
+// push. It fails if the protocol is already registered.
+func (p *ProtocolHandler) Register(proto protocol.ID, handler network.StreamHandler) error {
+	if proto == "" || handler == nil {
+		return fmt.Errorf("protocol ID and handler are required")
+	}
+	return p.register(proto, handler, false)
+}
+
+// Unregister removes a protocol registered with Register or by
+// SetupProtocols. Streams already open keep running. The handler is kept,
+// so Reregister can bring the protocol back.
+func (p *ProtocolHandler) Unregister(proto protocol.ID) error {
+	p.registryMu.Lock()
+	defer p.registryMu.Unlock()
+
+	reg, ok := p.registry[proto]
+	if !ok || !reg.active {
+		return fmt.Errorf("protocol %s is not registered", proto)
+	}
+	p.host.RemoveStreamHandler(proto)
+	if reg.compressed {
+		for _, alg := range compressionAlgorithms {
+			p.host.RemoveStreamHandler(CompressedProtocol(proto, alg))
+		}
+	}
+	p.removeVersion(proto)
+	reg.active = false
+
+	logrus.WithField("protocol", proto).Info("Unregistered protocol")
+	return nil
+}
+
+// Reregister registers an unregistered protocol again with its last handler
+func (p *ProtocolHandler) Reregister(proto protocol.ID) error {
+	p.registryMu.Lock()
+	reg, ok := p.registry[proto]
+	p.registryMu.Unlock()
+	if !ok {
+		return fmt.Errorf("protocol %s was never registered", proto)
+	}
+	return p.register(proto, reg.handler, reg.compressed)
+}
+
+// Registered lists the protocols registered with Register or by
+// SetupProtocols, including unregistered ones, sorted by ID
+func (p *ProtocolHandler) Registered() []RegisteredProtocol {
+	p.registryMu.Lock()
+	defer p.registryMu.Unlock()
+
+	protos := make([]RegisteredProtocol, 0, len(p.registry))
+	for proto, reg := range p.registry {
+		protos = append(protos, RegisteredProtocol{ID: string(proto), Registered: reg.active, Compressed: reg.compressed})
+	}
+	sort.Slice(protos, func(i, j int) bool { return protos[i].ID < protos[j].ID })
+	return protos
+}
+
+// register records a protocol and sets its handlers on the host
+func (p *ProtocolHandler) register(proto protocol.ID, handler network.StreamHandler, compressed bool) error {
+	p.registryMu.Lock()
+	defer p.registryMu.Unlock()
+
+	if reg, ok := p.registry[proto]; ok && reg.active {
+		return fmt.Errorf("protocol %s is already registered", proto)
+	}
+	if p.registry == nil {
+		p.registry = make(map[protocol.ID]*registration)
+	}
+	p.registry[proto] = &registration{handler: handler, compressed: compressed, active: true}
+
+	p.HandleVersion(proto, handler)
+	if compressed {
+		p.handleCompressed(proto, handler)
+	}
+	logrus.WithField("protocol", proto).Debug("Registered protocol")
+	return nil
+}
+
+// removeVersion forgets a registered version of a protocol
+func (p *ProtocolHandler) removeVersion(proto protocol.ID) {
+	name, _, ok := splitProtocolVersion(proto)
+	if !ok {
+		return
+	}
+	p.versionsMu.Lock()
+	defer p.versionsMu.Unlock()
+	versions := p.versions[name][:0]
+	for _, v := range p.versions[name] {
+		if v != proto {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		delete(p.versions, name)
+	} else {
+		p.versions[name] = versions
+	}
+}
@@ -0,0 +1,133 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtocolRegistry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	handler := NewProtocolHandler(server)
+	handler.SetupProtocols()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	const proto = protocol.ID("/test/plugin/1.0.0")
+	hello := func(h host.Host) (string, error) {
+		s, err := h.NewStream(ctx, server.ID(), proto)
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+		reply, err := io.ReadAll(s)
+		return string(reply), err
+	}
+	plugin := func(s network.Stream) {
+		defer s.Close()
+		s.Write([]byte("hello"))
+	}
+
+	t.Run("Register", func(t *testing.T) {
+		require.NoError(t, handler.Register(proto, plugin))
+		reply, err := hello(client)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", reply)
+
+		assert.Error(t, handler.Register(proto, plugin), "registering twice should fail")
+		assert.Error(t, handler.Register("", plugin))
+		assert.Contains(t, handler.Registered(), RegisteredProtocol{ID: string(proto), Registered: true})
+	})
+
+	t.Run("Unregister", func(t *testing.T) {
+		require.NoError(t, handler.Unregister(proto))
+		_, err := hello(client)
+		assert.Error(t, err)
+		assert.NotContains(t, server.Mux().Protocols(), proto)
+		assert.Contains(t, handler.Registered(), RegisteredProtocol{ID: string(proto), Registered: false})
+
+		assert.Error(t, handler.Unregister(proto), "unregistering twice should fail")
+	})
+
+	t.Run("Reregister", func(t *testing.T) {
+		require.NoError(t, handler.Reregister(proto))
+		reply, err := hello(client)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", reply)
+
+		assert.Error(t, handler.Reregister("/test/unknown/1.0.0"))
+	})
+
+	t.Run("BuiltinProtocols", func(t *testing.T) {
+		require.NoError(t, handler.Unregister(protocol.ID(EchoProtocol)))
+		for _, alg := range compressionAlgorithms {
+			assert.NotContains(t, server.Mux().Protocols(), CompressedProtocol(protocol.ID(EchoProtocol), alg))
+		}
+		assert.Equal(t, []protocol.ID{protocol.ID(EchoV2Protocol)}, handler.Versions(protocol.ID(EchoProtocol)))
+
+		require.NoError(t, handler.Reregister(protocol.ID(EchoProtocol)))
+		assert.Contains(t, server.Mux().Protocols(), CompressedProtocol(protocol.ID(EchoProtocol), compressionAlgorithms[0]))
+		assert.Len(t, handler.Versions(protocol.ID(EchoProtocol)), 2)
+	})
+}
+
+func TestAdminProtocolCommands(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	operator, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer operator.Close()
+
+	managed, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer managed.Close()
+
+	goodbye, err := NewGoodbye(managed)
+	require.NoError(t, err)
+	defer goodbye.Close()
+
+	admin, err := NewAdmin(managed, goodbye, []string{operator.ID().String()})
+	require.NoError(t, err)
+	defer admin.Close()
+
+	handler := NewProtocolHandler(managed)
+	handler.SetupProtocols()
+	admin.SetProtocols(handler)
+	require.NoError(t, connectNodes(ctx, operator, managed))
+
+	_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdProtocolUnregister, ChatProtocol)
+	require.NoError(t, err)
+	assert.NotContains(t, managed.Mux().Protocols(), protocol.ID(ChatProtocol))
+
+	result, err := SendAdminCommand(ctx, operator, managed.ID(), AdminCmdProtocols)
+	require.NoError(t, err)
+	var protos []RegisteredProtocol
+	require.NoError(t, json.Unmarshal(result, &protos))
+	assert.Contains(t, protos, RegisteredProtocol{ID: ChatProtocol, Registered: false, Compressed: true})
+	assert.Contains(t, protos, RegisteredProtocol{ID: PingProtocol, Registered: true})
+
+	_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdProtocolRegister, ChatProtocol)
+	require.NoError(t, err)
+	assert.Contains(t, managed.Mux().Protocols(), protocol.ID(ChatProtocol))
+
+	_, err = SendAdminCommand(ctx, operator, managed.ID(), AdminCmdProtocolRegister, "/test/unknown/1.0.0")
+	assert.ErrorContains(t, err, "never registered")
+}
@@ -0,0 +1,270 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// relayTag protects relays we hold a reservation with from being pruned
+	relayTag = "relay-reservation"
+
+	// relayRefreshMargin is how long before it expires a reservation is renewed
+	relayRefreshMargin = 2 * time.Minute
+
+	// relayRetryInterval is the delay after a failed reservation, and the
+	// shortest delay between renewals
+	relayRetryInterval = 30 * time.Second
+)
+
+// ConnPath describes how one connection reaches a peer: directly, or
+// through another peer's circuit relay
+type ConnPath struct {
+	Addr      string    `json:"addr"`
+	Transport string    `json:"transport"`
+	Direction string    `json:"direction"`
+	Opened    time.Time `json:"opened"`
+	Relayed   bool      `json:"relayed"`
+	Relay     string    `json:"relay,omitempty"`   // peer ID of the relay, if the address names it
+	Limited   bool      `json:"limited,omitempty"` // the relay caps the connection's duration and data
+	// ReservationExpiry is when our reservation with the relay runs out, for
+	// connections peers made to us through it
+	ReservationExpiry *time.Time `json:"reservation_expiry,omitempty"`
+}
+
+// ConnPaths describes each connection to a peer. reservations, which may be
+// nil, supplies the expiry of our reservations with relays.
+func ConnPaths(h host.Host, p peer.ID, reservations *RelayReservations) []ConnPath {
+	var paths []ConnPath
+	for _, c := range h.Network().ConnsToPeer(p) {
+		stat := c.Stat()
+		path := ConnPath{
+			Addr:      c.RemoteMultiaddr().String(),
+			Transport: transportName(c.RemoteMultiaddr()),
+			Direction: strings.ToLower(stat.Direction.String()),
+			Opened:    stat.Opened,
+			Limited:   stat.Limited,
+		}
+		if relay, ok := relayOf(c.RemoteMultiaddr()); ok {
+			path.Relayed = true
+			if relay != "" {
+				path.Relay = relay.String()
+				if expiry, ok := reservations.Expiry(relay); ok && stat.Direction == network.DirInbound {
+					path.ReservationExpiry = &expiry
+				}
+			}
+		}
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Opened.Before(paths[j].Opened) })
+	return paths
+}
+
+// relayOf reports whether an address goes through a relay, and the relay's
+// peer ID if the address names it
+func relayOf(addr multiaddr.Multiaddr) (peer.ID, bool) {
+	var relay peer.ID
+	for _, c := range addr {
+		switch c.Code() {
+		case multiaddr.P_P2P:
+			relay, _ = peer.Decode(c.Value())
+		case multiaddr.P_CIRCUIT:
+			return relay, true
+		}
+	}
+	return "", false
+}
+
+// isRelayed reports whether a connection goes through a relay
+func isRelayed(c network.Conn) bool {
+	_, ok := relayOf(c.RemoteMultiaddr())
+	return ok
+}
+
+// waitDirect returns a channel closed once the host has a direct
+// connection to p, such as after a hole punch. It stops watching when ctx
+// is done.
+func waitDirect(ctx context.Context, h host.Host, p peer.ID) <-chan struct{} {
+	direct := make(chan struct{})
+	var once sync.Once
+	found := func(c network.Conn) {
+		if c.RemotePeer() == p && !isRelayed(c) {
+			once.Do(func() { close(direct) })
+		}
+	}
+
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) { found(c) },
+	}
+	h.Network().Notify(notifee)
+	for _, c := range h.Network().ConnsToPeer(p) {
+		found(c)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-direct:
+		}
+		h.Network().StopNotify(notifee)
+	}()
+	return direct
+}
+
+// RelayReservation is a slot we hold on a relay, through which peers that
+// can't reach us directly can connect to us
+type RelayReservation struct {
+	Relay   string    `json:"relay"`
+	Addrs   []string  `json:"addrs"` // our addresses through the relay
+	Expires time.Time `json:"expires"`
+	Error   string    `json:"error,omitempty"` // why the last renewal failed
+}
+
+// RelayReservations keeps reservations with the configured relays,
+// renewing them before they expire
+type RelayReservations struct {
+	host   host.Host
+	relays []peer.AddrInfo
+
+	mu           sync.Mutex
+	reservations map[peer.ID]*RelayReservation
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRelayReservations creates the reservation keeper for the relays
+func NewRelayReservations(h host.Host, relays []peer.AddrInfo) *RelayReservations {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RelayReservations{
+		host:         h,
+		relays:       relays,
+		reservations: make(map[peer.ID]*RelayReservation),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Start reserves a slot on every relay and keeps renewing them
+func (r *RelayReservations) Start() {
+	for _, info := range r.relays {
+		r.host.ConnManager().Protect(info.ID, relayTag)
+		r.wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer r.wg.Done()
+			r.keep(info)
+		}(info)
+	}
+	logrus.WithField("relays", len(r.relays)).Info("Relay reservations started")
+}
+
+// Close stops renewing reservations. The relays drop them once they expire.
+func (r *RelayReservations) Close() {
+	r.cancel()
+	r.wg.Wait()
+	for _, info := range r.relays {
+		r.host.ConnManager().Unprotect(info.ID, relayTag)
+	}
+}
+
+// keep renews the reservation on a relay until closed
+func (r *RelayReservations) keep(info peer.AddrInfo) {
+	for {
+		wait := relayRetryInterval
+		if res, err := r.Reserve(r.ctx, info); err != nil {
+			logrus.WithError(err).WithField("relay", info.ID).Warn("Failed to reserve a relay slot")
+		} else if until := time.Until(res.Expires) - relayRefreshMargin; until > wait {
+			wait = until
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Reserve reserves a slot on a relay, or renews ours
+func (r *RelayReservations) Reserve(ctx context.Context, info peer.AddrInfo) (*RelayReservation, error) {
+	rsvp, err := client.Reserve(ctx, r.host, info)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.reservations[info.ID]
+	if !ok {
+		res = &RelayReservation{Relay: info.ID.String()}
+		r.reservations[info.ID] = res
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return nil, fmt.Errorf("failed to reserve a slot on %s: %w", info.ID, err)
+	}
+	res.Error = ""
+	res.Expires = rsvp.Expiration
+	res.Addrs = nil
+	for _, addr := range rsvp.Addrs {
+		res.Addrs = append(res.Addrs, addr.String())
+	}
+	logrus.WithFields(logrus.Fields{
+		"relay":   info.ID,
+		"expires": rsvp.Expiration,
+	}).Debug("Reserved relay slot")
+	return res.copy(), nil
+}
+
+// Expiry returns when our reservation with a relay runs out, if we hold
+// one. It is safe to call on a nil RelayReservations.
+func (r *RelayReservations) Expiry(relay peer.ID) (time.Time, bool) {
+	if r == nil {
+		return time.Time{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.reservations[relay]
+	if !ok || !res.Expires.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return res.Expires, true
+}
+
+// Reservations returns our reservations, including failed ones, by relay
+func (r *RelayReservations) Reservations() []RelayReservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reservations := make([]RelayReservation, 0, len(r.reservations))
+	for _, res := range r.reservations {
+		reservations = append(reservations, *res.copy())
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Relay < reservations[j].Relay })
+	return reservations
+}
+
+// copy returns a copy that doesn't share the address slice
+func (res *RelayReservation) copy() *RelayReservation {
+	c := *res
+	c.Addrs = append([]string(nil), res.Addrs...)
+	return &c
+}
+
+// RelayReservations returns the relay reservation keeper, or nil if no
+// relay_reservations are configured
+func (n *Node) RelayReservations() *RelayReservations {
+	return n.relays
+}
+
+// ConnPaths describes each connection to a peer
+func (n *Node) ConnPaths(p peer.ID) []ConnPath {
+	return ConnPaths(n.host, p, n.relays)
+}
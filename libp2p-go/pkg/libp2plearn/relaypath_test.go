@@ -0,0 +1,102 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayOf(t *testing.T) {
+	relay := "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"
+
+	_, ok := relayOf(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001"))
+	assert.False(t, ok)
+
+	id, ok := relayOf(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001/p2p/" + relay + "/p2p-circuit"))
+	assert.True(t, ok)
+	assert.Equal(t, relay, id.String())
+
+	id, ok = relayOf(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001/p2p-circuit"))
+	assert.True(t, ok)
+	assert.Empty(t, id)
+}
+
+func TestRelayReservations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relayHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer relayHost.Close()
+	relay, err := relayv2.New(relayHost)
+	require.NoError(t, err)
+	defer relay.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	target, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer target.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	reservations := NewRelayReservations(target, []peer.AddrInfo{relayInfo})
+	reservations.Start()
+	defer reservations.Close()
+
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		_, ok := reservations.Expiry(relayHost.ID())
+		return ok
+	}, 10*time.Second, 100*time.Millisecond))
+
+	t.Run("Reservations", func(t *testing.T) {
+		list := reservations.Reservations()
+		require.Len(t, list, 1)
+		assert.Equal(t, relayHost.ID().String(), list[0].Relay)
+		assert.Empty(t, list[0].Error)
+		assert.True(t, list[0].Expires.After(time.Now()))
+		assert.True(t, target.ConnManager().IsProtected(relayHost.ID(), relayTag))
+	})
+
+	t.Run("RelayedPath", func(t *testing.T) {
+		circuit := multiaddr.StringCast(fmt.Sprintf("%s/p2p/%s/p2p-circuit", relayInfo.Addrs[0], relayHost.ID()))
+		dialCtx := network.WithAllowLimitedConn(ctx, "test")
+		require.NoError(t, client.Connect(dialCtx, peer.AddrInfo{ID: target.ID(), Addrs: []multiaddr.Multiaddr{circuit}}))
+
+		paths := ConnPaths(target, client.ID(), reservations)
+		require.Len(t, paths, 1)
+		assert.True(t, paths[0].Relayed)
+		assert.Equal(t, relayHost.ID().String(), paths[0].Relay)
+		assert.Equal(t, "inbound", paths[0].Direction)
+		assert.Equal(t, "relay", paths[0].Transport)
+		assert.True(t, paths[0].Limited)
+		require.NotNil(t, paths[0].ReservationExpiry)
+		assert.True(t, paths[0].ReservationExpiry.After(time.Now()))
+
+		// The dialing side goes through the relay too, but holds no reservation
+		paths = ConnPaths(client, target.ID(), nil)
+		require.Len(t, paths, 1)
+		assert.True(t, paths[0].Relayed)
+		assert.Equal(t, "outbound", paths[0].Direction)
+		assert.Nil(t, paths[0].ReservationExpiry)
+	})
+
+	t.Run("DirectPath", func(t *testing.T) {
+		paths := ConnPaths(target, relayHost.ID(), reservations)
+		require.Len(t, paths, 1)
+		assert.False(t, paths[0].Relayed)
+		assert.Empty(t, paths[0].Relay)
+		assert.Equal(t, "outbound", paths[0].Direction)
+		assert.Equal(t, "tcp", paths[0].Transport)
+	})
+}
@@ -0,0 +1,184 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+
+	"libp2p-learn/pkg/libp2plearn/discovery"
+)
+
+// reloadableFields are the config fields (by JSON name) Reload applies to a running node
+var reloadableFields = map[string]bool{
+	"bootstrap_peers":        true,
+	"blocked_peers":          true,
+	"peer_bandwidth":         true,
+	"protocol_bandwidth":     true,
+	"max_streams_per_peer":   true,
+	"protocol_stream_limits": true,
+	"compression":            true,
+	"log_level":              true,
+	"log_subsystems":         true,
+	"admin_peers":            true,
+}
+
+// Reload applies a new configuration to the running node. Bootstrap peers,
+// blocked peers, bandwidth and stream limits, compression, log levels and
+// admin peers change in place; the JSON names of other changed fields are
+// returned, since they only take effect after a restart.
+func (n *Node) Reload(cfg *Config) ([]string, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	old := n.Config()
+	restart := changedFields(old, cfg, reloadableFields)
+
+	// Bandwidth caps can only change if the throttle was installed at startup
+	if n.throttle != nil {
+		n.throttle.SetLimits(cfg.PeerBandwidth, cfg.ProtocolBandwidth)
+	} else if cfg.BandwidthLimited() {
+		restart = append(restart, "peer_bandwidth")
+	}
+
+	// Stream caps can only change if the limit was installed at startup
+	if n.streamLimit != nil {
+		n.streamLimit.SetLimits(cfg.MaxStreamsPerPeer, cfg.ProtocolStreamLimits)
+	} else if cfg.StreamLimited() {
+		restart = append(restart, "max_streams_per_peer")
+	}
+
+	n.protocols.SetCompression(cfg.Compression)
+
+	// Admin peers can only change if the admin protocol was registered at startup
+	if n.admin != nil {
+		if err := n.admin.SetAdmins(cfg.AdminPeers); err != nil {
+			return nil, err
+		}
+	} else if len(cfg.AdminPeers) > 0 {
+		restart = append(restart, "admin_peers")
+	}
+
+	if cfg.LogLevel != old.LogLevel {
+		level, _ := logrus.ParseLevel(cfg.LogLevel) // validated above
+		logrus.SetLevel(level)
+	}
+	if !reflect.DeepEqual(cfg.LogSubsystems, old.LogSubsystems) {
+		for subsystem, level := range cfg.LogSubsystems {
+			SetLogLevel(subsystem, level) // validated above
+		}
+	}
+
+	n.reloadBlocklist(cfg.BlockedPeers)
+	n.reloadBootstrap(old.BootstrapPeers, cfg.BootstrapPeers)
+
+	n.cfg.Store(cfg)
+	logrus.WithField("restart_required", restart).Info("Configuration reloaded")
+	return restart, nil
+}
+
+// applyConfigPatch reloads the current configuration with a pushed patch applied
+func (n *Node) applyConfigPatch(patch json.RawMessage) ([]string, error) {
+	cfg, err := mergeConfig(n.Config(), patch)
+	if err != nil {
+		return nil, err
+	}
+	return n.Reload(cfg)
+}
+
+// reloadBlocklist makes the blocklist match ids, disconnecting newly blocked peers
+func (n *Node) reloadBlocklist(ids []string) {
+	want := make(map[peer.ID]bool, len(ids))
+	for _, id := range ids {
+		p, _ := peer.Decode(id) // validated with the config
+		want[p] = true
+	}
+
+	for _, p := range n.blocklist.Blocked() {
+		// Peers gated or banned for misbehavior stay blocked
+		if !want[p] && !n.reputation.Penalized(p) {
+			n.blocklist.Unblock(p)
+		}
+	}
+	for p := range want {
+		if n.blocklist.IsBlocked(p) {
+			continue
+		}
+		n.blocklist.Block(p)
+		if n.host.Network().Connectedness(p) == network.Connected {
+			go n.goodbye.Disconnect(context.Background(), p, GoodbyeBanned, "blocked by configuration")
+		}
+	}
+}
+
+// reloadBootstrap connects to bootstrap peers that weren't in the previous list
+func (n *Node) reloadBootstrap(old, updated []string) {
+	if n.group == nil || n.stopped {
+		return
+	}
+
+	known := make(map[string]bool, len(old))
+	for _, addr := range old {
+		known[addr] = true
+	}
+	var added []string
+	for _, addr := range updated {
+		if !known[addr] {
+			added = append(added, addr)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	ctx := n.ctx
+	n.group.Go(func() error {
+		if err := discovery.BootstrapPeers(ctx, n.host, added); err != nil {
+			logrus.WithError(err).Warn("Failed to connect to new bootstrap peers")
+		}
+		return nil
+	})
+}
+
+// changedFields returns the JSON names of fields that differ between two
+// configurations, leaving out the ignored ones
+func changedFields(a, b *Config, ignore map[string]bool) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		name := strings.Split(va.Type().Field(i).Tag.Get("json"), ",")[0]
+		if ignore[name] {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// mergeConfig returns a copy of cfg with the JSON patch applied on top. Fields
+// missing from the patch keep their value, lists are replaced and maps are merged.
+func mergeConfig(cfg *Config, patch json.RawMessage) (*Config, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	merged := &Config{}
+	if err := json.Unmarshal(data, merged); err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+	if err := json.Unmarshal(patch, merged); err != nil {
+		return nil, fmt.Errorf("failed to apply config patch: %w", err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,189 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// republishTimeout bounds publishing every record again
+const republishTimeout = 5 * time.Minute
+
+// What made the advertised addresses change
+const (
+	RepublishAddresses    = "addresses"
+	RepublishReachability = "reachability"
+	RepublishRelay        = "relay"
+)
+
+// EvtRecordsRepublished is emitted on the host event bus after the node's
+// DHT records were published again because its advertised addresses
+// changed. PeerRecord is whether the peers closest to our ID learned the
+// new addresses; Failed counts the records that couldn't be published.
+type EvtRecordsRepublished struct {
+	Reasons    []string
+	Added      []multiaddr.Multiaddr
+	Removed    []multiaddr.Multiaddr
+	PeerRecord bool
+	Services   []string
+	Blobs      []cid.Cid
+	Failed     int
+}
+
+// republishOnAddrChange publishes our DHT records again whenever the
+// advertised addresses change and then stay put for the republish delay,
+// until ctx is done. Records already carry our addresses, so a change in
+// reachability or relays that leaves them as they were publishes nothing.
+func (n *Node) republishOnAddrChange(ctx context.Context, sub event.Subscription, emitter event.Emitter) {
+	defer sub.Close()
+	defer emitter.Close()
+
+	published := n.host.Addrs()
+	reasons := make(map[string]bool)
+	var settled <-chan time.Time
+	var timer *time.Timer
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			switch evt := e.(type) {
+			case event.EvtLocalAddressesUpdated:
+				reasons[RepublishAddresses] = true
+			case event.EvtLocalReachabilityChanged:
+				reasons[RepublishReachability] = true
+			case event.EvtAutoRelayAddrsUpdated:
+				if len(evt.RelayAddrs) == 0 {
+					continue
+				}
+				reasons[RepublishRelay] = true
+			}
+			// Wait for the addresses to settle, as changes come in bursts
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(time.Duration(n.Config().RepublishDelay))
+			settled = timer.C
+		case <-settled:
+			settled = nil
+			current := n.host.Addrs()
+			added, removed := diffAddrs(published, current)
+			if len(added) > 0 || len(removed) > 0 {
+				evt := n.republish(ctx, sortedKeys(reasons), added, removed)
+				if err := emitter.Emit(evt); err != nil {
+					logrus.WithError(err).Debug("Failed to emit records republished event")
+				}
+				published = current
+			}
+			clear(reasons)
+		}
+	}
+}
+
+// republish announces our services and pinned blobs in the DHT again and
+// has the peers closest to our ID update our peer record
+func (n *Node) republish(ctx context.Context, reasons []string, added, removed []multiaddr.Multiaddr) EvtRecordsRepublished {
+	ctx, cancel := context.WithTimeout(ctx, republishTimeout)
+	defer cancel()
+
+	evt := EvtRecordsRepublished{Reasons: reasons, Added: added, Removed: removed}
+	logger := logrus.WithFields(logrus.Fields{
+		"reasons": reasons,
+		"added":   added,
+		"removed": removed,
+	})
+	logger.Info("Advertised addresses changed, republishing DHT records")
+
+	// FindPeer asks the peers closest to our ID for our addresses
+	if err := n.refreshPeerRecord(ctx); err != nil {
+		logger.WithError(err).Debug("Failed to refresh peer record")
+		evt.Failed++
+	} else {
+		evt.PeerRecord = true
+	}
+
+	for _, name := range n.capabilities.Services() {
+		if err := n.dht.Provide(ctx, serviceKey(name), true); err != nil {
+			logger.WithError(err).WithField("service", name).Debug("Failed to announce service in the DHT")
+			evt.Failed++
+			continue
+		}
+		evt.Services = append(evt.Services, name)
+	}
+
+	if n.blobs != nil {
+		for _, pin := range n.blobs.Pins() {
+			root, err := cid.Decode(pin.CID)
+			if err != nil {
+				continue
+			}
+			if err := n.dht.Provide(ctx, root, true); err != nil {
+				logger.WithError(err).WithField("cid", root).Debug("Failed to announce blob in the DHT")
+				evt.Failed++
+				continue
+			}
+			evt.Blobs = append(evt.Blobs, root)
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"peer_record": evt.PeerRecord,
+		"services":    len(evt.Services),
+		"blobs":       len(evt.Blobs),
+		"failed":      evt.Failed,
+	}).Info("Republished DHT records")
+	return evt
+}
+
+// refreshPeerRecord looks up the peers closest to our ID. The lookup
+// connects to them, and identify tells them our addresses; identify push
+// already told those we were connected to.
+func (n *Node) refreshPeerRecord(ctx context.Context) error {
+	closest, err := n.dht.GetClosestPeers(ctx, string(n.host.ID()))
+	if err != nil {
+		return fmt.Errorf("failed to find the peers closest to us: %w", err)
+	}
+	if len(closest) == 0 {
+		return fmt.Errorf("no peers close to us")
+	}
+	return nil
+}
+
+// diffAddrs returns the addresses in current but not in previous, and those
+// in previous but not in current
+func diffAddrs(previous, current []multiaddr.Multiaddr) (added, removed []multiaddr.Multiaddr) {
+	for _, addr := range current {
+		if !slices.ContainsFunc(previous, addr.Equal) {
+			added = append(added, addr)
+		}
+	}
+	for _, addr := range previous {
+		if !slices.ContainsFunc(current, addr.Equal) {
+			removed = append(removed, addr)
+		}
+	}
+	return added, removed
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
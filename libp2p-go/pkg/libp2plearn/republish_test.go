@@ -0,0 +1,63 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepublishOnAddrChange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.Services = []string{"relay"}
+	cfg.RepublishDelay = Duration(100 * time.Millisecond)
+	provider, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	hub, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+
+	republished, cancelEvents := provider.SubscribeEvents(EventRecordsRepublished)
+	defer cancelEvents()
+
+	require.NoError(t, provider.Start(ctx))
+	defer provider.Stop(ctx)
+	require.NoError(t, hub.Start(ctx))
+	defer hub.Stop(ctx)
+
+	require.NoError(t, connectNodes(ctx, provider.Host(), hub.Host()))
+	require.NoError(t, WaitWithCondition(ctx, func() bool {
+		return provider.DHT().RoutingTable().Size() > 0
+	}, 10*time.Second, 50*time.Millisecond))
+
+	// Listening on another port adds an address
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	require.NoError(t, provider.Host().Network().Listen(addr))
+
+	e := nextEvent(t, ctx, republished)
+	evt := e.Raw.(EvtRecordsRepublished)
+	assert.Contains(t, evt.Reasons, RepublishAddresses)
+	assert.Contains(t, e.Message, RepublishAddresses)
+	assert.NotEmpty(t, evt.Added)
+	assert.Empty(t, evt.Removed)
+	assert.Equal(t, []string{"relay"}, evt.Services)
+}
+
+func TestDiffAddrs(t *testing.T) {
+	a := multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001")
+	b := multiaddr.StringCast("/ip4/10.0.0.1/udp/4001/quic-v1")
+	c := multiaddr.StringCast("/ip4/203.0.113.7/tcp/4001")
+
+	added, removed := diffAddrs([]multiaddr.Multiaddr{a, b}, []multiaddr.Multiaddr{b, c})
+	assert.Equal(t, []multiaddr.Multiaddr{c}, added)
+	assert.Equal(t, []multiaddr.Multiaddr{a}, removed)
+
+	added, removed = diffAddrs([]multiaddr.Multiaddr{a}, []multiaddr.Multiaddr{a})
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
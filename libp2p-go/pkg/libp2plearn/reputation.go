@@ -0,0 +1,333 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// reputationTag is the connection manager tag carrying a peer's penalty
+const reputationTag = "reputation"
+
+// Misbehavior is a kind of peer misbehavior
+type Misbehavior string
+
+const (
+	MisbehaviorProtocolError    Misbehavior = "protocol_error"    // malformed or invalid protocol messages
+	MisbehaviorRateLimit        Misbehavior = "rate_limit"        // exceeding a rate or size limit
+	MisbehaviorInvalidSignature Misbehavior = "invalid_signature" // records, tokens or updates with bad signatures
+)
+
+// defaultReputationPenalties are the penalties of the built-in misbehavior kinds
+func defaultReputationPenalties() map[string]float64 {
+	return map[string]float64{
+		string(MisbehaviorProtocolError):    10,
+		string(MisbehaviorRateLimit):        5,
+		string(MisbehaviorInvalidSignature): 25,
+	}
+}
+
+// EvtPeerMisbehaved is emitted on the host event bus when a peer misbehaves
+type EvtPeerMisbehaved struct {
+	Peer   peer.ID
+	Kind   Misbehavior
+	Detail string
+}
+
+// ReportMisbehavior emits an EvtPeerMisbehaved for a peer on the host's event bus
+func ReportMisbehavior(h host.Host, p peer.ID, kind Misbehavior, detail string) {
+	emitter, err := h.EventBus().Emitter(new(EvtPeerMisbehaved))
+	if err != nil {
+		logrus.WithError(err).Debug("Failed to create misbehavior emitter")
+		return
+	}
+	defer emitter.Close()
+	emitter.Emit(EvtPeerMisbehaved{Peer: p, Kind: kind, Detail: detail})
+}
+
+// ReputationPolicy decides how misbehavior escalates. Every misbehavior adds
+// its penalty, which halves every Decay. A peer whose penalty reaches
+// GateScore is disconnected and refused for GateBackoff, doubling with every
+// gating up to GateMax; after BanAfter gatings it is banned for good.
+type ReputationPolicy struct {
+	Penalties   map[Misbehavior]float64
+	Decay       time.Duration
+	GateScore   float64
+	GateBackoff time.Duration
+	GateMax     time.Duration
+	BanAfter    int // 0 to never ban
+}
+
+// PeerReputation is what is known about a peer's misbehavior
+type PeerReputation struct {
+	Penalty    float64             `json:"penalty"`
+	Updated    time.Time           `json:"updated"`
+	Counts     map[Misbehavior]int `json:"counts"`
+	Gates      int                 `json:"gates,omitempty"`
+	GatedUntil time.Time           `json:"gated_until,omitempty"`
+	Banned     bool                `json:"banned,omitempty"`
+}
+
+// Reputation tracks peer misbehavior across restarts and escalates from a
+// connection manager penalty, to temporary gating, to a permanent ban
+type Reputation struct {
+	path      string
+	policy    ReputationPolicy
+	host      host.Host
+	blocklist *Blocklist
+	goodbye   *Goodbye
+	sub       event.Subscription
+
+	mu    sync.Mutex
+	peers map[peer.ID]*PeerReputation
+
+	saveMu sync.Mutex // serializes writes of the file
+}
+
+// NewReputation loads the reputation file, starting empty if it doesn't exist,
+// re-applies bans and gates that are still in force, and starts tracking
+// misbehavior reported on the host's event bus
+func NewReputation(h host.Host, blocklist *Blocklist, goodbye *Goodbye, path string, policy ReputationPolicy) (*Reputation, error) {
+	r := &Reputation{
+		path:      path,
+		policy:    policy,
+		host:      h,
+		blocklist: blocklist,
+		goodbye:   goodbye,
+		peers:     make(map[peer.ID]*PeerReputation),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	sub, err := h.EventBus().Subscribe(new(EvtPeerMisbehaved))
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to misbehavior: %w", err)
+	}
+	r.sub = sub
+	go func() {
+		for e := range sub.Out() {
+			evt := e.(EvtPeerMisbehaved)
+			r.Report(evt.Peer, evt.Kind, evt.Detail)
+		}
+	}()
+
+	logrus.WithFields(logrus.Fields{
+		"file":  path,
+		"peers": len(r.peers),
+	}).Info("Peer reputation enabled")
+	return r, nil
+}
+
+// Close stops tracking misbehavior and saves the reputations
+func (r *Reputation) Close() error {
+	r.sub.Close()
+	return r.Save()
+}
+
+// Report records a misbehavior and escalates if the peer's penalty calls for it
+func (r *Reputation) Report(p peer.ID, kind Misbehavior, detail string) {
+	penalty, ok := r.policy.Penalties[kind]
+	if !ok || p == r.host.ID() {
+		return
+	}
+
+	r.mu.Lock()
+	rep := r.peers[p]
+	if rep == nil {
+		rep = &PeerReputation{Counts: make(map[Misbehavior]int)}
+		r.peers[p] = rep
+	}
+	now := time.Now()
+	rep.Penalty = r.decayed(rep, now) + penalty
+	rep.Updated = now
+	rep.Counts[kind]++
+	if rep.Banned || now.Before(rep.GatedUntil) {
+		// Still in flight from before the gate took effect
+		r.mu.Unlock()
+		return
+	}
+
+	fields := logrus.Fields{
+		"peer":    p,
+		"kind":    kind,
+		"detail":  detail,
+		"penalty": fmt.Sprintf("%.1f", rep.Penalty),
+	}
+	var reason GoodbyeReason
+	switch {
+	case rep.Penalty < r.policy.GateScore:
+		logrus.WithFields(fields).Debug("Peer misbehaved")
+	case r.policy.BanAfter > 0 && rep.Gates >= r.policy.BanAfter:
+		rep.Banned = true
+		reason = GoodbyeBanned
+		logrus.WithFields(fields).Warn("Banned misbehaving peer")
+	default:
+		backoff := r.policy.GateBackoff << min(rep.Gates, 30)
+		if backoff <= 0 || backoff > r.policy.GateMax {
+			backoff = r.policy.GateMax
+		}
+		rep.Gates++
+		rep.GatedUntil = now.Add(backoff)
+		rep.Penalty = 0
+		reason = GoodbyePruned
+		logrus.WithFields(fields).WithField("backoff", backoff).Warn("Gated misbehaving peer")
+	}
+	snapshot := *rep
+	r.mu.Unlock()
+
+	r.enforce(p, &snapshot)
+	if reason != GoodbyeUnknown {
+		go r.disconnect(p, reason)
+	}
+	if err := r.Save(); err != nil {
+		logrus.WithError(err).Warn("Failed to save peer reputation")
+	}
+}
+
+// Get returns a peer's reputation with the penalty decayed to now
+func (r *Reputation) Get(p peer.ID) (PeerReputation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep, ok := r.peers[p]
+	if !ok {
+		return PeerReputation{}, false
+	}
+	snapshot := *rep
+	snapshot.Penalty = r.decayed(rep, time.Now())
+	return snapshot, true
+}
+
+// Penalized reports whether the peer is banned or gated. A nil Reputation
+// penalizes no one.
+func (r *Reputation) Penalized(p peer.ID) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep, ok := r.peers[p]
+	return ok && (rep.Banned || time.Now().Before(rep.GatedUntil))
+}
+
+// Pardon forgets a peer's misbehavior and lifts its gate or ban
+func (r *Reputation) Pardon(p peer.ID) error {
+	r.mu.Lock()
+	rep, ok := r.peers[p]
+	delete(r.peers, p)
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if rep.Banned || time.Now().Before(rep.GatedUntil) {
+		r.blocklist.Unblock(p)
+	}
+	r.host.ConnManager().UntagPeer(p, reputationTag)
+	logrus.WithField("peer", p).Info("Pardoned peer")
+	return r.Save()
+}
+
+// Save writes the reputations to disk
+func (r *Reputation) Save() error {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.mu.Lock()
+	records := make(map[string]*PeerReputation, len(r.peers))
+	for p, rep := range r.peers {
+		snapshot := *rep
+		records[p.String()] = &snapshot
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode peer reputation: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create peer reputation directory: %w", err)
+	}
+
+	if err := writeFileAtomic(r.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write peer reputation: %w", err)
+	}
+	return nil
+}
+
+// load reads the reputation file and enforces the bans and gates in it
+func (r *Reputation) load() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read peer reputation: %w", err)
+	}
+
+	var records map[string]*PeerReputation
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to parse peer reputation: %w", err)
+	}
+	for id, rep := range records {
+		p, err := peer.Decode(id)
+		if err != nil {
+			logrus.WithField("peer", id).Warn("Skipping invalid peer in reputation")
+			continue
+		}
+		if rep.Counts == nil {
+			rep.Counts = make(map[Misbehavior]int)
+		}
+		r.peers[p] = rep
+		r.enforce(p, rep)
+	}
+	return nil
+}
+
+// enforce applies a reputation to the blocklist and connection manager
+func (r *Reputation) enforce(p peer.ID, rep *PeerReputation) {
+	switch {
+	case rep.Banned:
+		r.blocklist.Block(p)
+	case time.Now().Before(rep.GatedUntil):
+		r.blocklist.BlockUntil(p, rep.GatedUntil)
+	}
+	if rep.Penalty > 0 {
+		// Misbehaving peers are the first to go when the connection manager trims
+		r.host.ConnManager().TagPeer(p, reputationTag, -int(math.Ceil(rep.Penalty)))
+	} else {
+		r.host.ConnManager().UntagPeer(p, reputationTag)
+	}
+}
+
+// disconnect tells a gated or banned peer why and closes its connections
+func (r *Reputation) disconnect(p peer.ID, reason GoodbyeReason) {
+	ctx, cancel := context.WithTimeout(context.Background(), goodbyeTimeout)
+	defer cancel()
+	if r.goodbye != nil {
+		if err := r.goodbye.Disconnect(ctx, p, reason, "misbehavior"); err != nil {
+			logrus.WithError(err).WithField("peer", p).Debug("Failed to disconnect misbehaving peer")
+		}
+		return
+	}
+	r.host.Network().ClosePeer(p)
+}
+
+// decayed returns the penalty halved for every Decay since it was last updated
+func (r *Reputation) decayed(rep *PeerReputation, now time.Time) float64 {
+	if r.policy.Decay <= 0 || rep.Updated.IsZero() {
+		return rep.Penalty
+	}
+	halvings := float64(now.Sub(rep.Updated)) / float64(r.policy.Decay)
+	return rep.Penalty * math.Pow(0.5, halvings)
+}
@@ -0,0 +1,131 @@
+package libp2plearn
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReputation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+	other, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer other.Close()
+	p := other.ID()
+
+	policy := ReputationPolicy{
+		Penalties: map[Misbehavior]float64{
+			MisbehaviorProtocolError:    10,
+			MisbehaviorInvalidSignature: 50,
+		},
+		Decay:       time.Hour,
+		GateScore:   90,
+		GateBackoff: time.Minute,
+		GateMax:     3 * time.Minute,
+		BanAfter:    3,
+	}
+
+	// setup creates a reputation backed by the file at path
+	setup := func(t *testing.T, path string) (*Reputation, *Blocklist) {
+		blocklist, err := NewBlocklist(nil)
+		require.NoError(t, err)
+		rep, err := NewReputation(h, blocklist, nil, path, policy)
+		require.NoError(t, err)
+		t.Cleanup(func() { rep.Close() })
+		return rep, blocklist
+	}
+
+	t.Run("PenaltyBelowGate", func(t *testing.T) {
+		rep, blocklist := setup(t, filepath.Join(t.TempDir(), "reputation.json"))
+		for range 3 {
+			rep.Report(p, MisbehaviorProtocolError, "bad frame")
+		}
+
+		got, ok := rep.Get(p)
+		require.True(t, ok)
+		assert.InDelta(t, 30, got.Penalty, 0.1)
+		assert.Equal(t, 3, got.Counts[MisbehaviorProtocolError])
+		assert.False(t, blocklist.IsBlocked(p))
+		info := h.ConnManager().GetTagInfo(p)
+		require.NotNil(t, info)
+		assert.Less(t, info.Value, 0)
+
+		// Unknown kinds carry no penalty
+		rep.Report(p, "unknown", "")
+		got, _ = rep.Get(p)
+		assert.InDelta(t, 30, got.Penalty, 0.1)
+	})
+
+	t.Run("GatesWithBackoffThenBans", func(t *testing.T) {
+		rep, blocklist := setup(t, filepath.Join(t.TempDir(), "reputation.json"))
+
+		var backoffs []time.Duration
+		for range 3 {
+			rep.Report(p, MisbehaviorInvalidSignature, "test")
+			rep.Report(p, MisbehaviorInvalidSignature, "test")
+			got, _ := rep.Get(p)
+			assert.True(t, blocklist.IsBlocked(p))
+			assert.True(t, rep.Penalized(p))
+			assert.Zero(t, got.Penalty, "gating starts the penalty over")
+			backoffs = append(backoffs, time.Until(got.GatedUntil).Round(time.Minute))
+
+			// Reports while gated don't extend the gate
+			rep.Report(p, MisbehaviorInvalidSignature, "test")
+			again, _ := rep.Get(p)
+			assert.Equal(t, got.GatedUntil, again.GatedUntil)
+
+			// Let the gate run out
+			rep.mu.Lock()
+			rep.peers[p].GatedUntil = time.Now().Add(-time.Second)
+			rep.peers[p].Penalty = 0
+			rep.mu.Unlock()
+		}
+		assert.Equal(t, []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}, backoffs)
+
+		rep.Report(p, MisbehaviorInvalidSignature, "test")
+		rep.Report(p, MisbehaviorInvalidSignature, "test")
+		got, _ := rep.Get(p)
+		assert.True(t, got.Banned)
+		assert.True(t, blocklist.IsBlocked(p))
+		assert.Equal(t, 3, got.Gates)
+	})
+
+	t.Run("SurvivesRestart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "reputation.json")
+		rep, _ := setup(t, path)
+		rep.Report(p, MisbehaviorInvalidSignature, "test")
+		rep.Report(p, MisbehaviorInvalidSignature, "test")
+		require.NoError(t, rep.Close())
+
+		restored, blocklist := setup(t, path)
+		got, ok := restored.Get(p)
+		require.True(t, ok)
+		assert.Equal(t, 1, got.Gates)
+		assert.Equal(t, 2, got.Counts[MisbehaviorInvalidSignature])
+		assert.True(t, blocklist.IsBlocked(p), "the gate is enforced again after a restart")
+
+		require.NoError(t, restored.Pardon(p))
+		assert.False(t, blocklist.IsBlocked(p))
+		_, ok = restored.Get(p)
+		assert.False(t, ok)
+	})
+
+	t.Run("ReportedOnEventBus", func(t *testing.T) {
+		rep, _ := setup(t, filepath.Join(t.TempDir(), "reputation.json"))
+		ReportMisbehavior(h, p, MisbehaviorProtocolError, "bad frame")
+
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			got, ok := rep.Get(p)
+			return ok && got.Counts[MisbehaviorProtocolError] == 1
+		}, 5*time.Second, 20*time.Millisecond))
+	})
+}
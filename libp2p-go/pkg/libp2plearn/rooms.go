@@ -0,0 +1,763 @@
+package libp2plearn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+)
+
+const (
+	// DefaultRoomsFile is where the rooms are kept unless told otherwise
+	DefaultRoomsFile = "data/rooms.json"
+
+	// RoomMembershipDomain is the signature domain of room membership records
+	RoomMembershipDomain = "libp2p-learn-room-membership"
+
+	// RoomInvitationDomain is the signature domain of room invitations
+	RoomInvitationDomain = "libp2p-learn-room-invitation"
+
+	// RoomInviteTokenPrefix starts every room invite token and names its format
+	RoomInviteTokenPrefix = "ROOM1:"
+
+	// maxRoomMembers bounds the members of one room
+	maxRoomMembers = 1000
+)
+
+// RoomRole is what a member may do in a room
+type RoomRole string
+
+const (
+	RoleOwner  RoomRole = "owner"  // created the room, and alone names admins
+	RoleAdmin  RoomRole = "admin"  // invites and removes members
+	RoleMember RoomRole = "member" // may chat in the room
+)
+
+var (
+	// roomMembershipCodec is the payload type of membership envelopes
+	roomMembershipCodec = []byte("/libp2p-learn/room-membership")
+
+	// roomInvitationCodec is the payload type of invitation envelopes
+	roomInvitationCodec = []byte("/libp2p-learn/room-invitation")
+
+	// roomName is what room names look like
+	roomName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+	// roomTokenEncoding encodes room invite tokens
+	roomTokenEncoding = base64.RawURLEncoding
+)
+
+// RoomMember is a peer in a room and its role
+type RoomMember struct {
+	ID   peer.ID  `json:"id"`
+	Role RoomRole `json:"role"`
+}
+
+// RoomMembership is one version of a room's member list. Each version is
+// numbered one higher than the last and signed by the owner or an admin of
+// the last, and members check every version they receive against the one
+// before, so nobody the owner and admins didn't admit gets in.
+type RoomMembership struct {
+	Room    string       `json:"room"`
+	Owner   peer.ID      `json:"owner"`
+	Seq     uint64       `json:"seq"`
+	Members []RoomMember `json:"members"` // sorted by peer ID, the owner among them
+}
+
+// Domain is the signature domain of membership records
+func (m *RoomMembership) Domain() string {
+	return RoomMembershipDomain
+}
+
+// Codec is the payload type of membership records
+func (m *RoomMembership) Codec() []byte {
+	return roomMembershipCodec
+}
+
+// MarshalRecord encodes the membership as JSON
+func (m *RoomMembership) MarshalRecord() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// UnmarshalRecord decodes a JSON membership
+func (m *RoomMembership) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, m)
+}
+
+// RoleOf returns the role of a peer in the room, or "" if it isn't a member
+func (m *RoomMembership) RoleOf(p peer.ID) RoomRole {
+	for _, member := range m.Members {
+		if member.ID == p {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// IsMember reports whether a peer is in the room
+func (m *RoomMembership) IsMember(p peer.ID) bool {
+	return m.RoleOf(p) != ""
+}
+
+// check validates a membership on its own
+func (m *RoomMembership) check() error {
+	if !roomName.MatchString(m.Room) {
+		return fmt.Errorf("invalid room name %q", m.Room)
+	}
+	if len(m.Members) > maxRoomMembers {
+		return fmt.Errorf("room %s has more than %d members", m.Room, maxRoomMembers)
+	}
+	seen := make(map[peer.ID]bool, len(m.Members))
+	for _, member := range m.Members {
+		if seen[member.ID] {
+			return fmt.Errorf("%s is listed twice in room %s", member.ID, m.Room)
+		}
+		seen[member.ID] = true
+		switch member.Role {
+		case RoleOwner:
+			if member.ID != m.Owner {
+				return fmt.Errorf("%s isn't the owner of room %s", member.ID, m.Room)
+			}
+		case RoleAdmin, RoleMember:
+		default:
+			return fmt.Errorf("unknown role %q", member.Role)
+		}
+	}
+	if m.RoleOf(m.Owner) != RoleOwner {
+		return fmt.Errorf("owner of room %s isn't a member", m.Room)
+	}
+	return nil
+}
+
+// privileged returns the owner and admins, sorted by peer ID
+func (m *RoomMembership) privileged() []RoomMember {
+	var members []RoomMember
+	for _, member := range m.Members {
+		if member.Role != RoleMember {
+			members = append(members, member)
+		}
+	}
+	sortRoomMembers(members)
+	return members
+}
+
+// clone returns a copy that doesn't share the member list
+func (m *RoomMembership) clone() RoomMembership {
+	c := *m
+	c.Members = slices.Clone(m.Members)
+	return c
+}
+
+// sortRoomMembers sorts members by peer ID
+func sortRoomMembers(members []RoomMember) {
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+}
+
+// checkRoomUpdate checks that signer may replace the membership cur with
+// next. cur is nil for the first version, which only the owner can sign.
+func checkRoomUpdate(cur, next *RoomMembership, signer peer.ID) error {
+	if err := next.check(); err != nil {
+		return err
+	}
+	if cur == nil {
+		if next.Seq != 1 {
+			return fmt.Errorf("first membership record of room %s has seq %d", next.Room, next.Seq)
+		}
+		if signer != next.Owner {
+			return fmt.Errorf("first membership record of room %s signed by %s, not its owner", next.Room, signer)
+		}
+		return nil
+	}
+	if next.Room != cur.Room || next.Owner != cur.Owner {
+		return fmt.Errorf("membership record is for another room")
+	}
+	if next.Seq != cur.Seq+1 {
+		return fmt.Errorf("membership record %d of room %s doesn't follow %d", next.Seq, next.Room, cur.Seq)
+	}
+	switch cur.RoleOf(signer) {
+	case RoleOwner:
+		return nil
+	case RoleAdmin:
+		// Admins manage members, but only the owner names or removes admins
+		if !slices.Equal(cur.privileged(), next.privileged()) {
+			return fmt.Errorf("admin %s can't change the admins of room %s", signer, next.Room)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s can't change the members of room %s", signer, next.Room)
+	}
+}
+
+// RoomInvitation lets a peer into a room. It is signed by the owner or an
+// admin, and the invitee hands it to one of them, who adds the invitee to
+// the member list. It is no good once it expires or its signer is no longer
+// an owner or admin.
+type RoomInvitation struct {
+	Room    string    `json:"room"`
+	Owner   peer.ID   `json:"owner"`
+	Invitee peer.ID   `json:"invitee"`
+	Role    RoomRole  `json:"role"`
+	Expires time.Time `json:"expires"`
+}
+
+// Domain is the signature domain of room invitations
+func (i *RoomInvitation) Domain() string {
+	return RoomInvitationDomain
+}
+
+// Codec is the payload type of room invitations
+func (i *RoomInvitation) Codec() []byte {
+	return roomInvitationCodec
+}
+
+// MarshalRecord encodes the invitation as JSON
+func (i *RoomInvitation) MarshalRecord() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+// UnmarshalRecord decodes a JSON invitation
+func (i *RoomInvitation) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, i)
+}
+
+// checkInvitation verifies an invitation against the latest membership of
+// its room
+func checkInvitation(m *RoomMembership, data []byte) (*RoomInvitation, error) {
+	var inv RoomInvitation
+	signer, err := openRoomRecord(data, &inv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid invitation: %w", err)
+	}
+	if inv.Room != m.Room || inv.Owner != m.Owner {
+		return nil, fmt.Errorf("invitation is for another room")
+	}
+	if inv.Role != RoleAdmin && inv.Role != RoleMember {
+		return nil, fmt.Errorf("invitation has invalid role %q", inv.Role)
+	}
+	switch m.RoleOf(signer) {
+	case RoleOwner:
+	case RoleAdmin:
+		if inv.Role != RoleMember {
+			return nil, fmt.Errorf("admin %s can't invite admins", signer)
+		}
+	default:
+		return nil, fmt.Errorf("invitation signed by %s, who isn't an owner or admin of room %s", signer, m.Room)
+	}
+	if !time.Now().Before(inv.Expires) {
+		return nil, fmt.Errorf("invitation to room %s expired", inv.Room)
+	}
+	return &inv, nil
+}
+
+// openRoomRecord verifies a signed room record, decodes it into rec and
+// returns its signer
+func openRoomRecord(data []byte, rec record.Record) (peer.ID, error) {
+	env, err := record.ConsumeTypedEnvelope(data, rec)
+	if err != nil {
+		return "", err
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid signing key: %w", err)
+	}
+	return signer, nil
+}
+
+// roomInviteToken is what a room invite token carries: the invitation, and
+// the room's membership records so the invitee can check who signed it
+type roomInviteToken struct {
+	Invitation []byte   `json:"invitation"`
+	Records    [][]byte `json:"records"`
+}
+
+// roomState is a room as kept in the rooms file
+type roomState struct {
+	Records    [][]byte `json:"records"`              // signed membership records, oldest first
+	Invitation []byte   `json:"invitation,omitempty"` // ours, until an owner or admin admits us
+
+	membership *RoomMembership // the latest record
+}
+
+// apply checks the records newer than the latest one, each against the one
+// before, and adds them. Two admins changing the members at once both sign
+// the same seq; whichever version a member sees first wins. It returns
+// whether there were new records.
+func (s *roomState) apply(records [][]byte) (bool, error) {
+	changed := false
+	for _, data := range records {
+		var m RoomMembership
+		signer, err := openRoomRecord(data, &m)
+		if err != nil {
+			return changed, fmt.Errorf("invalid membership record: %w", err)
+		}
+		if s.membership != nil && m.Seq <= s.membership.Seq {
+			if m.Room != s.membership.Room || m.Owner != s.membership.Owner {
+				return changed, fmt.Errorf("membership record is for another room")
+			}
+			continue
+		}
+		if err := checkRoomUpdate(s.membership, &m, signer); err != nil {
+			return changed, err
+		}
+		s.Records = append(s.Records, data)
+		s.membership = &m
+		changed = true
+	}
+	return changed, nil
+}
+
+// RoomBook keeps the rooms this node is in, so chat rooms can be private:
+// only peers the owner or an admin invited get in. Every change to a room
+// is a new membership record signed with this node's key, and records from
+// other members are only taken after checking them.
+type RoomBook struct {
+	path string
+	key  crypto.PrivKey
+	self peer.ID
+
+	mu    sync.Mutex
+	rooms map[string]*roomState
+}
+
+// LoadRoomBook reads the rooms file, checking every room's records, and
+// starts empty if it doesn't exist. key signs the changes made here.
+func LoadRoomBook(path string, key crypto.PrivKey) (*RoomBook, error) {
+	self, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	book := &RoomBook{
+		path:  path,
+		key:   key,
+		self:  self,
+		rooms: make(map[string]*roomState),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return book, nil
+		}
+		return nil, fmt.Errorf("failed to read rooms: %w", err)
+	}
+
+	var rooms []*roomState
+	if err := json.Unmarshal(data, &rooms); err != nil {
+		return nil, fmt.Errorf("failed to parse rooms: %w", err)
+	}
+	for _, saved := range rooms {
+		st := &roomState{Invitation: saved.Invitation}
+		if _, err := st.apply(saved.Records); err != nil {
+			return nil, fmt.Errorf("invalid room in %s: %w", path, err)
+		}
+		if st.membership != nil {
+			book.rooms[st.membership.Room] = st
+		}
+	}
+	return book, nil
+}
+
+// Create starts a room owned by this node, with no other members
+func (b *RoomBook) Create(name string) (RoomMembership, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.rooms[name]; ok {
+		return RoomMembership{}, fmt.Errorf("room %s already exists", name)
+	}
+	st := &roomState{}
+	first := &RoomMembership{
+		Room:    name,
+		Owner:   b.self,
+		Seq:     1,
+		Members: []RoomMember{{ID: b.self, Role: RoleOwner}},
+	}
+	if err := b.sign(st, first); err != nil {
+		return RoomMembership{}, err
+	}
+	b.rooms[name] = st
+	return st.membership.clone(), nil
+}
+
+// Invite returns a token that lets a peer into a room as a member or, if
+// the owner invites, an admin. The invitee joins with it, then is admitted
+// the next time it reaches the owner or an admin.
+func (b *RoomBook) Invite(name string, invitee peer.ID, role RoomRole, ttl time.Duration) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	if !ok {
+		return "", fmt.Errorf("no room named %s", name)
+	}
+	m := st.membership
+	if role != RoleAdmin && role != RoleMember {
+		return "", fmt.Errorf("invalid role %q", role)
+	}
+	switch m.RoleOf(b.self) {
+	case RoleOwner:
+	case RoleAdmin:
+		if role != RoleMember {
+			return "", fmt.Errorf("only the owner of room %s can invite admins", name)
+		}
+	default:
+		return "", fmt.Errorf("only the owner and admins of room %s can invite", name)
+	}
+	if m.IsMember(invitee) {
+		return "", fmt.Errorf("%s is already a member of room %s", invitee, name)
+	}
+
+	inv := &RoomInvitation{
+		Room:    name,
+		Owner:   m.Owner,
+		Invitee: invitee,
+		Role:    role,
+		Expires: time.Now().Add(ttl).UTC(),
+	}
+	env, err := record.Seal(inv, b.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign invitation: %w", err)
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode invitation: %w", err)
+	}
+	payload, err := json.Marshal(roomInviteToken{Invitation: data, Records: st.Records})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode room invite: %w", err)
+	}
+	return RoomInviteTokenPrefix + roomTokenEncoding.EncodeToString(payload), nil
+}
+
+// Join takes a room invite token for this node and keeps the room with the
+// invitation, which is handed over to the owner or an admin on the next
+// sync with them
+func (b *RoomBook) Join(token string) (RoomMembership, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(token), RoomInviteTokenPrefix)
+	if !ok {
+		return RoomMembership{}, fmt.Errorf("invalid room invite: it doesn't start with %s", RoomInviteTokenPrefix)
+	}
+	payload, err := roomTokenEncoding.DecodeString(encoded)
+	if err != nil {
+		return RoomMembership{}, fmt.Errorf("invalid room invite: %w", err)
+	}
+	var t roomInviteToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return RoomMembership{}, fmt.Errorf("invalid room invite: %w", err)
+	}
+	invited := &roomState{}
+	if _, err := invited.apply(t.Records); err != nil {
+		return RoomMembership{}, fmt.Errorf("invalid room invite: %w", err)
+	}
+	if invited.membership == nil {
+		return RoomMembership{}, fmt.Errorf("invalid room invite: no membership records")
+	}
+	inv, err := checkInvitation(invited.membership, t.Invitation)
+	if err != nil {
+		return RoomMembership{}, err
+	}
+	if inv.Invitee != b.self {
+		return RoomMembership{}, fmt.Errorf("room invite is for %s", inv.Invitee)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[inv.Room]
+	if !ok {
+		st = invited
+		b.rooms[inv.Room] = st
+	} else {
+		if st.membership.Owner != inv.Owner {
+			return RoomMembership{}, fmt.Errorf("already in another room named %s", inv.Room)
+		}
+		if _, err := st.apply(t.Records); err != nil {
+			return RoomMembership{}, fmt.Errorf("invalid room invite: %w", err)
+		}
+	}
+	if !st.membership.IsMember(b.self) {
+		st.Invitation = t.Invitation
+	}
+	return st.membership.clone(), nil
+}
+
+// Remove takes a peer out of a room. Admins can remove members, and the
+// owner anyone but itself.
+func (b *RoomBook) Remove(name string, p peer.ID) (RoomMembership, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, next, err := b.next(name)
+	if err != nil {
+		return RoomMembership{}, err
+	}
+	if p == next.Owner {
+		return RoomMembership{}, fmt.Errorf("the owner can't be removed from room %s", name)
+	}
+	i := slices.IndexFunc(next.Members, func(m RoomMember) bool { return m.ID == p })
+	if i < 0 {
+		return RoomMembership{}, fmt.Errorf("%s isn't a member of room %s", p, name)
+	}
+	next.Members = slices.Delete(next.Members, i, i+1)
+	if err := b.sign(st, next); err != nil {
+		return RoomMembership{}, err
+	}
+	return st.membership.clone(), nil
+}
+
+// SetRole makes a member an admin or a plain member, which only the owner
+// can do
+func (b *RoomBook) SetRole(name string, p peer.ID, role RoomRole) (RoomMembership, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, next, err := b.next(name)
+	if err != nil {
+		return RoomMembership{}, err
+	}
+	if role != RoleAdmin && role != RoleMember {
+		return RoomMembership{}, fmt.Errorf("invalid role %q", role)
+	}
+	if p == next.Owner {
+		return RoomMembership{}, fmt.Errorf("the owner of room %s can't change role", name)
+	}
+	i := slices.IndexFunc(next.Members, func(m RoomMember) bool { return m.ID == p })
+	if i < 0 {
+		return RoomMembership{}, fmt.Errorf("%s isn't a member of room %s", p, name)
+	}
+	next.Members[i].Role = role
+	if err := b.sign(st, next); err != nil {
+		return RoomMembership{}, err
+	}
+	return st.membership.clone(), nil
+}
+
+// Leave forgets a room. The other members still list this node until an
+// owner or admin removes it.
+func (b *RoomBook) Leave(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.rooms[name]; !ok {
+		return false
+	}
+	delete(b.rooms, name)
+	return true
+}
+
+// Membership returns the latest member list of a room
+func (b *RoomBook) Membership(name string) (RoomMembership, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	if !ok {
+		return RoomMembership{}, false
+	}
+	return st.membership.clone(), true
+}
+
+// Rooms returns the latest member list of every room, sorted by name
+func (b *RoomBook) Rooms() []RoomMembership {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rooms := make([]RoomMembership, 0, len(b.rooms))
+	for _, st := range b.rooms {
+		rooms = append(rooms, st.membership.clone())
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Room < rooms[j].Room
+	})
+	return rooms
+}
+
+// Pending reports whether this node was invited to a room but not yet
+// admitted
+func (b *RoomBook) Pending(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	return ok && len(st.Invitation) > 0
+}
+
+// IsMember reports whether a peer is in a room, as far as this node knows.
+// A nil book has no rooms.
+func (b *RoomBook) IsMember(name string, p peer.ID) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	return ok && st.membership.IsMember(p)
+}
+
+// Save writes the rooms file
+func (b *RoomBook) Save() error {
+	b.mu.Lock()
+	rooms := make([]*roomState, 0, len(b.rooms))
+	for _, st := range b.rooms {
+		rooms = append(rooms, st)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].membership.Room < rooms[j].membership.Room
+	})
+	data, err := json.MarshalIndent(rooms, "", "  ")
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode rooms: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("failed to create rooms directory: %w", err)
+	}
+
+	if err := writeFileAtomic(b.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write rooms: %w", err)
+	}
+	return nil
+}
+
+// next copies the latest membership of a room as its next version; b.mu
+// must be held
+func (b *RoomBook) next(name string) (*roomState, *RoomMembership, error) {
+	st, ok := b.rooms[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("no room named %s", name)
+	}
+	next := st.membership.clone()
+	next.Seq++
+	return st, &next, nil
+}
+
+// sign seals a membership with this node's key and applies it to the room,
+// which checks that this node may make the change; b.mu must be held
+func (b *RoomBook) sign(st *roomState, next *RoomMembership) error {
+	sortRoomMembers(next.Members)
+	env, err := record.Seal(next, b.key)
+	if err != nil {
+		return fmt.Errorf("failed to sign membership record: %w", err)
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode membership record: %w", err)
+	}
+	_, err = st.apply([][]byte{data})
+	return err
+}
+
+// roomsWith returns the names of the rooms a peer is in
+func (b *RoomBook) roomsWith(p peer.ID) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []string
+	for name, st := range b.rooms {
+		if st.membership.IsMember(p) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// request builds a sync request for a room
+func (b *RoomBook) request(name string) (roomRequest, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	if !ok {
+		return roomRequest{}, false
+	}
+	return roomRequest{
+		Room:       name,
+		Owner:      st.membership.Owner,
+		Records:    slices.Clone(st.Records),
+		Invitation: st.Invitation,
+	}, true
+}
+
+// records returns the membership records of a room, oldest first
+func (b *RoomBook) records(name string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if st, ok := b.rooms[name]; ok {
+		return slices.Clone(st.Records)
+	}
+	return nil
+}
+
+// merge applies membership records received for a room and drops our
+// invitation once they list us. It returns whether there were new records.
+func (b *RoomBook) merge(name string, owner peer.ID, records [][]byte) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	if !ok || st.membership.Owner != owner {
+		return false, fmt.Errorf("no room %s owned by %s", name, owner)
+	}
+	changed, err := st.apply(records)
+	if st.membership.IsMember(b.self) {
+		st.Invitation = nil
+	}
+	return changed, err
+}
+
+// admit adds the peer an invitation is for to a room, if this node can
+// sign that change. It returns whether the peer was added.
+func (b *RoomBook) admit(name string, owner peer.ID, invitation []byte, from peer.ID) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.rooms[name]
+	if !ok || st.membership.Owner != owner {
+		return false, fmt.Errorf("no room %s owned by %s", name, owner)
+	}
+	inv, err := checkInvitation(st.membership, invitation)
+	if err != nil {
+		return false, err
+	}
+	if inv.Invitee != from {
+		return false, fmt.Errorf("invitation is for %s", inv.Invitee)
+	}
+	if st.membership.IsMember(from) {
+		return false, nil
+	}
+	// Leave it to another owner or admin if we can't sign this change
+	switch st.membership.RoleOf(b.self) {
+	case RoleOwner:
+	case RoleAdmin:
+		if inv.Role != RoleMember {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	next := st.membership.clone()
+	next.Seq++
+	next.Members = append(next.Members, RoomMember{ID: from, Role: inv.Role})
+	if err := b.sign(st, &next); err != nil {
+		return false, err
+	}
+	return true, nil
+}
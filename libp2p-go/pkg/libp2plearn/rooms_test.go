@@ -0,0 +1,203 @@
+package libp2plearn
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomBook(t *testing.T) {
+	dir := t.TempDir()
+	newBook := func(name string) (*RoomBook, peer.ID) {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		book, err := LoadRoomBook(filepath.Join(dir, name+".json"), key)
+		require.NoError(t, err)
+		return book, book.self
+	}
+	owner, ownerID := newBook("owner")
+	admin, adminID := newBook("admin")
+	member, memberID := newBook("member")
+	outsider, outsiderID := newBook("outsider")
+
+	// join hands an invitation straight to whoever signs it in, as a sync
+	// would
+	join := func(book *RoomBook, inviter *RoomBook, token string) {
+		_, err := book.Join(token)
+		require.NoError(t, err)
+		req, ok := book.request("team")
+		require.True(t, ok)
+		_, err = inviter.merge(req.Room, req.Owner, req.Records)
+		require.NoError(t, err)
+		admitted, err := inviter.admit(req.Room, req.Owner, req.Invitation, book.self)
+		require.NoError(t, err)
+		require.True(t, admitted)
+		_, err = book.merge("team", ownerID, inviter.records("team"))
+		require.NoError(t, err)
+	}
+
+	m, err := owner.Create("team")
+	require.NoError(t, err)
+	assert.Equal(t, RoleOwner, m.RoleOf(ownerID))
+	_, err = owner.Create("team")
+	assert.Error(t, err)
+
+	t.Run("Invite", func(t *testing.T) {
+		token, err := owner.Invite("team", adminID, RoleAdmin, time.Hour)
+		require.NoError(t, err)
+		_, err = member.Join(token)
+		assert.ErrorContains(t, err, "room invite is for")
+
+		join(admin, owner, token)
+		assert.False(t, admin.Pending("team"))
+		assert.True(t, admin.IsMember("team", adminID))
+		m, _ := admin.Membership("team")
+		assert.Equal(t, RoleAdmin, m.RoleOf(adminID))
+
+		// Admins invite members, but not other admins
+		_, err = admin.Invite("team", memberID, RoleAdmin, time.Hour)
+		assert.Error(t, err)
+		token, err = admin.Invite("team", memberID, RoleMember, time.Hour)
+		require.NoError(t, err)
+		_, err = member.Join(token)
+		require.NoError(t, err)
+		assert.True(t, member.Pending("team"))
+		assert.False(t, member.IsMember("team", memberID))
+
+		// Only the invitee can hand its invitation over
+		req, _ := member.request("team")
+		_, err = admin.admit(req.Room, req.Owner, req.Invitation, outsiderID)
+		assert.ErrorContains(t, err, "invitation is for")
+
+		join(member, admin, token)
+		_, err = owner.merge("team", ownerID, admin.records("team"))
+		require.NoError(t, err)
+		assert.True(t, owner.IsMember("team", memberID))
+	})
+
+	t.Run("MembersCantChangeMembers", func(t *testing.T) {
+		_, err := member.Invite("team", outsiderID, RoleMember, time.Hour)
+		assert.Error(t, err)
+		_, err = member.Remove("team", adminID)
+		assert.Error(t, err)
+
+		// Nor can they forge a version that others accept
+		m, _ := member.Membership("team")
+		m.Seq++
+		m.Members = append(m.Members, RoomMember{ID: outsiderID, Role: RoleMember})
+		env, err := record.Seal(&m, member.key)
+		require.NoError(t, err)
+		forged, err := env.Marshal()
+		require.NoError(t, err)
+		_, err = owner.merge("team", ownerID, append(owner.records("team"), forged))
+		assert.ErrorContains(t, err, "can't change the members")
+		assert.False(t, owner.IsMember("team", outsiderID))
+	})
+
+	t.Run("OnlyOwnerNamesAdmins", func(t *testing.T) {
+		_, err := admin.SetRole("team", memberID, RoleAdmin)
+		assert.ErrorContains(t, err, "can't change the admins")
+		_, err = admin.Remove("team", ownerID)
+		assert.Error(t, err)
+
+		m, err := owner.SetRole("team", memberID, RoleAdmin)
+		require.NoError(t, err)
+		assert.Equal(t, RoleAdmin, m.RoleOf(memberID))
+		m, err = owner.SetRole("team", memberID, RoleMember)
+		require.NoError(t, err)
+		assert.Equal(t, RoleMember, m.RoleOf(memberID))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		_, err := admin.merge("team", ownerID, owner.records("team"))
+		require.NoError(t, err)
+		m, err := admin.Remove("team", memberID)
+		require.NoError(t, err)
+		assert.False(t, m.IsMember(memberID))
+
+		changed, err := owner.merge("team", ownerID, admin.records("team"))
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.False(t, owner.IsMember("team", memberID))
+	})
+
+	t.Run("ExpiredInvitation", func(t *testing.T) {
+		token, err := owner.Invite("team", outsiderID, RoleMember, -time.Second)
+		require.NoError(t, err)
+		_, err = outsider.Join(token)
+		assert.ErrorContains(t, err, "expired")
+	})
+
+	t.Run("Persisted", func(t *testing.T) {
+		require.NoError(t, owner.Save())
+		reloaded, err := LoadRoomBook(owner.path, owner.key)
+		require.NoError(t, err)
+		before, _ := owner.Membership("team")
+		after, ok := reloaded.Membership("team")
+		require.True(t, ok)
+		assert.Equal(t, before, after)
+	})
+}
+
+func TestRoomSync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	owner, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer owner.Close()
+	guest, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer guest.Close()
+
+	dir := t.TempDir()
+	ownerBook, err := LoadRoomBook(filepath.Join(dir, "owner.json"), owner.Peerstore().PrivKey(owner.ID()))
+	require.NoError(t, err)
+	guestBook, err := LoadRoomBook(filepath.Join(dir, "guest.json"), guest.Peerstore().PrivKey(guest.ID()))
+	require.NoError(t, err)
+	ownerSync, err := NewRoomSync(owner, ownerBook)
+	require.NoError(t, err)
+	defer ownerSync.Close()
+	guestSync, err := NewRoomSync(guest, guestBook)
+	require.NoError(t, err)
+	defer guestSync.Close()
+
+	_, err = ownerBook.Create("team")
+	require.NoError(t, err)
+	token, err := ownerBook.Invite("team", guest.ID(), RoleMember, time.Hour)
+	require.NoError(t, err)
+	_, err = guestBook.Join(token)
+	require.NoError(t, err)
+
+	sub, err := guest.EventBus().Subscribe(new(EvtRoomMembership))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	// Connecting syncs the room, which hands over the invitation
+	require.NoError(t, connectNodes(ctx, guest, owner))
+	select {
+	case e := <-sub.Out():
+		evt := e.(EvtRoomMembership)
+		assert.Equal(t, "team", evt.Membership.Room)
+		assert.True(t, evt.Membership.IsMember(guest.ID()))
+	case <-ctx.Done():
+		t.Fatal("guest not admitted")
+	}
+	assert.False(t, guestBook.Pending("team"))
+	assert.True(t, ownerBook.IsMember("team", guest.ID()))
+
+	t.Run("RemovedMemberGetsNoRecords", func(t *testing.T) {
+		_, err := ownerBook.Remove("team", guest.ID())
+		require.NoError(t, err)
+		err = guestSync.Sync(ctx, "team", owner.ID())
+		assert.ErrorContains(t, err, "not a member")
+	})
+}
@@ -0,0 +1,258 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RoomProtocol swaps a room's membership records between members, and
+	// admits invited peers
+	RoomProtocol = "/libp2p-learn/room/1.0.0"
+
+	// roomTimeout bounds one room sync
+	roomTimeout = 10 * time.Second
+
+	// maxRoomMessageSize bounds the size of a room request or response
+	maxRoomMessageSize = 1 << 20
+)
+
+// roomRequest carries the requester's membership records of a room, and its
+// invitation if it hasn't been admitted yet, as one JSON line
+type roomRequest struct {
+	Room       string   `json:"room"`
+	Owner      peer.ID  `json:"owner"`
+	Records    [][]byte `json:"records"`
+	Invitation []byte   `json:"invitation,omitempty"`
+}
+
+// roomResponse answers with the responder's membership records, which it
+// only sends to members
+type roomResponse struct {
+	Error   string   `json:"error,omitempty"`
+	Records [][]byte `json:"records,omitempty"`
+}
+
+// EvtRoomMembership is emitted on the host event bus when a room's member
+// list changes through a sync
+type EvtRoomMembership struct {
+	Membership RoomMembership
+}
+
+// RoomSync keeps a room book up to date with the other members. Rooms are
+// synced with each member as it connects, and changes are passed on to the
+// connected members, so every member ends up with the latest records.
+type RoomSync struct {
+	host    host.Host
+	book    *RoomBook
+	emitter event.Emitter
+	notifee *network.NotifyBundle
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewRoomSync registers the room protocol handler and starts syncing the
+// rooms in book with members as they connect
+func NewRoomSync(h host.Host, book *RoomBook) (*RoomSync, error) {
+	emitter, err := h.EventBus().Emitter(new(EvtRoomMembership))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create room emitter: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RoomSync{
+		host:    h,
+		book:    book,
+		emitter: emitter,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	h.SetStreamHandler(protocol.ID(RoomProtocol), RecoveryMiddleware(protocol.ID(RoomProtocol), r.handleRoom))
+	logrus.WithField("protocol", RoomProtocol).Info("Registered room protocol")
+
+	r.notifee = &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			go r.syncRooms(c.RemotePeer())
+		},
+	}
+	h.Network().Notify(r.notifee)
+	return r, nil
+}
+
+// Close stops syncing and unregisters the room protocol
+func (r *RoomSync) Close() {
+	r.host.Network().StopNotify(r.notifee)
+	r.host.RemoveStreamHandler(protocol.ID(RoomProtocol))
+	r.cancel()
+	r.emitter.Close()
+}
+
+// Sync swaps a room's membership records with a peer, handing over our
+// invitation if we haven't been admitted yet
+func (r *RoomSync) Sync(ctx context.Context, name string, p peer.ID) error {
+	req, ok := r.book.request(name)
+	if !ok {
+		return fmt.Errorf("no room named %s", name)
+	}
+	resp, err := roomExchange(ctx, r.host, p, req)
+	if err != nil {
+		return err
+	}
+	changed, err := r.book.merge(name, req.Owner, resp.Records)
+	if changed {
+		r.changed(name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid membership records from %s: %w", p, err)
+	}
+	return nil
+}
+
+// Push syncs a room with each connected member but one, after its records
+// changed
+func (r *RoomSync) Push(ctx context.Context, name string, except peer.ID) {
+	for _, p := range r.host.Network().Peers() {
+		if p == except || !r.book.IsMember(name, p) {
+			continue
+		}
+		if err := r.Sync(ctx, name, p); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"room": name, "peer": p}).Debug("Failed to sync room")
+		}
+	}
+}
+
+// syncRooms syncs every room a peer is in with it
+func (r *RoomSync) syncRooms(p peer.ID) {
+	for _, name := range r.book.roomsWith(p) {
+		if err := r.Sync(r.ctx, name, p); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"room": name, "peer": p}).Debug("Failed to sync room")
+		}
+	}
+}
+
+// changed saves the book and announces a room's new member list
+func (r *RoomSync) changed(name string) {
+	if err := r.book.Save(); err != nil {
+		logrus.WithError(err).Warn("Failed to save rooms")
+	}
+	if m, ok := r.book.Membership(name); ok {
+		logrus.WithFields(logrus.Fields{"room": name, "seq": m.Seq, "members": len(m.Members)}).Info("Room membership changed")
+		r.emitter.Emit(EvtRoomMembership{Membership: m})
+	}
+}
+
+// handleRoom takes a member's records and admits it if it brings an
+// invitation, then answers with our records
+func (r *RoomSync) handleRoom(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(roomTimeout))
+
+	reader := bufio.NewReaderSize(io.LimitReader(s, maxRoomMessageSize), maxRoomMessageSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read room request")
+		return
+	}
+	var req roomRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Received invalid room request")
+		return
+	}
+
+	var resp roomResponse
+	changed, err := r.book.merge(req.Room, req.Owner, req.Records)
+	if err == nil && len(req.Invitation) > 0 {
+		var admitted bool
+		admitted, err = r.book.admit(req.Room, req.Owner, req.Invitation, remote)
+		if admitted {
+			logrus.WithFields(logrus.Fields{"room": req.Room, "peer": remote}).Info("Admitted peer to room")
+			changed = true
+		}
+	}
+	switch {
+	case err != nil:
+		resp.Error = err.Error()
+	case !r.book.IsMember(req.Room, remote):
+		resp.Error = fmt.Sprintf("%s is not a member of room %s", remote, req.Room)
+	default:
+		resp.Records = r.book.records(req.Room)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode room response")
+		s.Reset()
+		return
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to send room response")
+	}
+
+	if changed {
+		r.changed(req.Room)
+		go r.Push(r.ctx, req.Room, remote)
+	}
+}
+
+// roomExchange sends one request to a room member and reads its response
+func roomExchange(ctx context.Context, h host.Host, p peer.ID, req roomRequest) (*roomResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, roomTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, p, protocol.ID(RoomProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode room request: %w", err)
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send room request: %w", err)
+	}
+	s.CloseWrite()
+
+	reader := bufio.NewReaderSize(io.LimitReader(s, maxRoomMessageSize), maxRoomMessageSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read room response: %w", err)
+	}
+	var resp roomResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid room response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("peer refused room sync: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// Rooms returns the rooms this node is in, which is nil unless
+// enable_rooms is set
+func (n *Node) Rooms() *RoomBook {
+	return n.rooms
+}
+
+// RoomSync returns the room sync service, which is nil unless enable_rooms
+// is set
+func (n *Node) RoomSync() *RoomSync {
+	return n.roomSync
+}
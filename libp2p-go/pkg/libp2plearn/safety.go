@@ -0,0 +1,156 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// safetyNumberVersion is hashed into every fingerprint, so a later
+	// scheme never produces matching numbers by accident
+	safetyNumberVersion uint16 = 0
+
+	// safetyNumberIterations makes finding a key with a colliding
+	// fingerprint expensive
+	safetyNumberIterations = 5200
+
+	// safetyFingerprintSize is how many bytes of a peer's fingerprint
+	// become its 30 digits
+	safetyFingerprintSize = 30
+
+	// safetyEmojiCount is how many emoji the short form has, 6 bits each
+	safetyEmojiCount = 8
+)
+
+// safetyEmoji are the symbols of the short form and their names, the same
+// 64 that Matrix uses for SAS verification
+var safetyEmoji = [64][2]string{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// SafetyNumber is a short authentication string for a pair of peers,
+// derived from both public keys. Both peers compute the same one, so reading
+// it out in person or over a call shows that nobody in between swapped a
+// key when the peers were introduced.
+type SafetyNumber struct {
+	Digits     string   `json:"digits"`      // 60 digits, 30 for each peer
+	Emoji      []string `json:"emoji"`       // the short form, to compare at a glance
+	EmojiNames []string `json:"emoji_names"` // names of the emoji, to read them out
+}
+
+// NewSafetyNumber derives the safety number of two peers' public keys. The
+// order of the keys doesn't matter.
+func NewSafetyNumber(a, b crypto.PubKey) (SafetyNumber, error) {
+	fa, err := safetyFingerprint(a)
+	if err != nil {
+		return SafetyNumber{}, err
+	}
+	fb, err := safetyFingerprint(b)
+	if err != nil {
+		return SafetyNumber{}, err
+	}
+	if bytes.Compare(fa, fb) > 0 {
+		fa, fb = fb, fa
+	}
+
+	n := SafetyNumber{Digits: safetyDigits(fa) + safetyDigits(fb)}
+	sum := sha256.Sum256(append(append([]byte(nil), fa...), fb...))
+	bits := binary.BigEndian.Uint64(sum[:8])
+	for i := 0; i < safetyEmojiCount; i++ {
+		e := safetyEmoji[bits>>(64-6*(i+1))&63]
+		n.Emoji = append(n.Emoji, e[0])
+		n.EmojiNames = append(n.EmojiNames, e[1])
+	}
+	return n, nil
+}
+
+// SafetyNumberOf derives the safety number of two peers from the keys in
+// their peer IDs, which Ed25519 peer IDs, the default, embed
+func SafetyNumberOf(a, b peer.ID) (SafetyNumber, error) {
+	ka, err := a.ExtractPublicKey()
+	if err != nil {
+		return SafetyNumber{}, fmt.Errorf("failed to get public key of %s: %w", a, err)
+	}
+	kb, err := b.ExtractPublicKey()
+	if err != nil {
+		return SafetyNumber{}, fmt.Errorf("failed to get public key of %s: %w", b, err)
+	}
+	return NewSafetyNumber(ka, kb)
+}
+
+// String returns the digits in twelve groups of five
+func (n SafetyNumber) String() string {
+	groups := make([]string, 0, len(n.Digits)/5)
+	for i := 0; i+5 <= len(n.Digits); i += 5 {
+		groups = append(groups, n.Digits[i:i+5])
+	}
+	return strings.Join(groups, " ")
+}
+
+// EmojiString returns the emoji separated by spaces
+func (n SafetyNumber) EmojiString() string {
+	return strings.Join(n.Emoji, " ")
+}
+
+// safetyFingerprint hashes a public key many times over
+func safetyFingerprint(key crypto.PubKey) ([]byte, error) {
+	raw, err := crypto.MarshalPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	h := sha512.New()
+	binary.Write(h, binary.BigEndian, safetyNumberVersion)
+	h.Write(raw)
+	sum := h.Sum(nil)
+	for i := 0; i < safetyNumberIterations; i++ {
+		h.Reset()
+		h.Write(sum)
+		h.Write(raw)
+		sum = h.Sum(sum[:0])
+	}
+	return sum[:safetyFingerprintSize], nil
+}
+
+// safetyDigits turns each 5 bytes of a fingerprint into 5 digits
+func safetyDigits(fingerprint []byte) string {
+	var b strings.Builder
+	for i := 0; i+5 <= len(fingerprint); i += 5 {
+		var chunk [8]byte
+		copy(chunk[3:], fingerprint[i:i+5])
+		fmt.Fprintf(&b, "%05d", binary.BigEndian.Uint64(chunk[:])%100000)
+	}
+	return b.String()
+}
+
+// SafetyNumber derives the safety number of this node and a peer, whose
+// public key is taken from the peerstore
+func (n *Node) SafetyNumber(p peer.ID) (SafetyNumber, error) {
+	ps := n.host.Peerstore()
+	remote := ps.PubKey(p)
+	if remote == nil {
+		return SafetyNumber{}, fmt.Errorf("public key of %s is unknown", p)
+	}
+	return NewSafetyNumber(ps.PubKey(n.host.ID()), remote)
+}
@@ -0,0 +1,65 @@
+package libp2plearn
+
+import (
+	"crypto/rand"
+	"regexp"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafetyNumber(t *testing.T) {
+	newPeer := func() peer.ID {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+		return id
+	}
+	alice, bob, mallory := newPeer(), newPeer(), newPeer()
+
+	n, err := SafetyNumberOf(alice, bob)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9]{60}$`), n.Digits)
+	assert.Regexp(t, regexp.MustCompile(`^([0-9]{5} ){11}[0-9]{5}$`), n.String())
+	assert.Len(t, n.Emoji, 8)
+	assert.Len(t, n.EmojiNames, 8)
+
+	t.Run("SameForBothPeers", func(t *testing.T) {
+		other, err := SafetyNumberOf(bob, alice)
+		require.NoError(t, err)
+		assert.Equal(t, n, other)
+	})
+
+	t.Run("ChangesWithEitherKey", func(t *testing.T) {
+		// A man in the middle gives each side his own key
+		forAlice, err := SafetyNumberOf(alice, mallory)
+		require.NoError(t, err)
+		forBob, err := SafetyNumberOf(mallory, bob)
+		require.NoError(t, err)
+		assert.NotEqual(t, n.Digits, forAlice.Digits)
+		assert.NotEqual(t, n.Digits, forBob.Digits)
+		assert.NotEqual(t, forAlice.Digits, forBob.Digits)
+		// Each half is one peer's fingerprint
+		assert.Contains(t, []string{forAlice.Digits[:30], forAlice.Digits[30:]}, safetyDigitsOf(t, alice))
+	})
+
+	t.Run("NeedsEmbeddedKey", func(t *testing.T) {
+		rsa, err := peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+		require.NoError(t, err)
+		_, err = SafetyNumberOf(alice, rsa)
+		assert.Error(t, err)
+	})
+}
+
+// safetyDigitsOf returns the 30 digits of one peer
+func safetyDigitsOf(t *testing.T, id peer.ID) string {
+	key, err := id.ExtractPublicKey()
+	require.NoError(t, err)
+	fingerprint, err := safetyFingerprint(key)
+	require.NoError(t, err)
+	return safetyDigits(fingerprint)
+}
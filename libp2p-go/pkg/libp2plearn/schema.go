@@ -0,0 +1,306 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ProtoSchema is the protobuf schema of the JSON messages of one protocol
+// version. The wire format stays JSON, in the proto3 JSON mapping of these
+// messages, so clients in other languages can generate types for them.
+//
+// A schema's version is the major version of its protocol ID, so the
+// version both sides use is negotiated with the protocol by multistream
+// select: a node that serves /libp2p-learn/health/1.0.0 speaks
+// libp2plearn.health.v1.
+type ProtoSchema struct {
+	Protocol string
+	Messages []ProtoMessage
+}
+
+// ProtoMessage is a top-level message of a schema and the Go value it is
+// generated from
+type ProtoMessage struct {
+	Name      string
+	Direction string
+	Value     interface{}
+}
+
+// protoSchemas are the schemas of the JSON protocols this package serves
+var protoSchemas = []ProtoSchema{
+	{AdminProtocol, []ProtoMessage{
+		{"AdminRequest", DirectionRequest, AdminRequest{}},
+		{"AdminResponse", DirectionResponse, AdminResponse{}},
+	}},
+	{BlobProtocol, []ProtoMessage{
+		{"BlobRequest", DirectionRequest, blobRequest{}},
+		{"BlobResponse", DirectionResponse, blobResponse{}},
+	}},
+	{CapabilitiesProtocol, []ProtoMessage{
+		{"CapabilitiesResponse", DirectionResponse, capabilitiesResponse{}},
+		{"ServiceRecord", "", ServiceRecord{}},
+	}},
+	{ChatResumeProtocol, []ProtoMessage{
+		{"ChatFrame", DirectionBoth, chatFrame{}},
+	}},
+	{ChatSessionProtocol, []ProtoMessage{
+		{"ChatFrame", DirectionBoth, chatFrame{}},
+	}},
+	{ConfigPushProtocol, []ProtoMessage{
+		{"SignedConfigUpdate", DirectionRequest, SignedConfigUpdate{}},
+		{"ConfigAck", DirectionResponse, ConfigAck{}},
+		{"ConfigUpdate", "", ConfigUpdate{}},
+	}},
+	{GoodbyeProtocol, []ProtoMessage{
+		{"GoodbyeMessage", DirectionRequest, goodbyeMessage{}},
+	}},
+	{HealthProtocol, []ProtoMessage{
+		{"HealthReport", DirectionResponse, HealthReport{}},
+	}},
+	{KVProtocol, []ProtoMessage{
+		{"KVMessage", DirectionBoth, kvMessage{}},
+	}},
+	{LogStreamProtocol, []ProtoMessage{
+		{"LogEntry", DirectionRequest, LogEntry{}},
+	}},
+	{MailboxProtocol, []ProtoMessage{
+		{"MailboxRequest", DirectionRequest, mailboxRequest{}},
+		{"MailboxResponse", DirectionResponse, mailboxResponse{}},
+		{"MailboxLetter", "", MailboxLetter{}},
+	}},
+	{RoomProtocol, []ProtoMessage{
+		{"RoomRequest", DirectionRequest, roomRequest{}},
+		{"RoomResponse", DirectionResponse, roomResponse{}},
+		{"RoomMembership", "", RoomMembership{}},
+		{"RoomInvitation", "", RoomInvitation{}},
+	}},
+	{RaftProtocol, []ProtoMessage{
+		{"RaftMessage", DirectionRequest, raftMessage{}},
+		{"RaftReply", DirectionResponse, raftReply{}},
+	}},
+	{PrekeyProtocol, []ProtoMessage{
+		{"PrekeyBundle", DirectionResponse, prekeyBundle{}},
+	}},
+	{ShellProtocol, []ProtoMessage{
+		{"ShellRequest", DirectionRequest, ShellRequest{}},
+	}},
+	{TimeSyncProtocol, []ProtoMessage{
+		{"TimeSyncMessage", DirectionBoth, timeSyncMessage{}},
+	}},
+	{TransferProtocol, []ProtoMessage{
+		{"TransferOffer", DirectionRequest, TransferOffer{}},
+		{"TransferReply", DirectionResponse, TransferReply{}},
+	}},
+	{ReflectProtocol, []ProtoMessage{
+		{"ProtocolReflection", DirectionResponse, ProtocolReflection{}},
+	}},
+}
+
+// ProtoSchemas returns the schemas of the JSON protocols this package serves
+func ProtoSchemas() []ProtoSchema {
+	return protoSchemas
+}
+
+// SchemaPackage returns the protobuf package of the messages of a protocol
+// ID, such as libp2plearn.health.v1, or false if it has no schema. Use it
+// with the protocol multistream select negotiated to know which schema the
+// peer speaks.
+func SchemaPackage(id protocol.ID) (string, bool) {
+	name, version, ok := splitProtocolVersion(id)
+	if !ok {
+		return "", false
+	}
+	for _, schema := range protoSchemas {
+		if schemaName, schemaVersion, _ := splitProtocolVersion(protocol.ID(schema.Protocol)); schemaName == name && schemaVersion.major == version.major {
+			return schema.Package(), true
+		}
+	}
+	return "", false
+}
+
+// Package returns the protobuf package of the schema
+func (s ProtoSchema) Package() string {
+	name, version, _ := splitProtocolVersion(protocol.ID(s.Protocol))
+	short := strings.ReplaceAll(name[strings.LastIndex(name, "/")+1:], "-", "_")
+	return fmt.Sprintf("libp2plearn.%s.v%d", short, version.major)
+}
+
+// Path returns where the proto file of the schema goes, relative to the
+// proto root
+func (s ProtoSchema) Path() string {
+	pkg := strings.Split(s.Package(), ".")
+	return filepath.Join(append(pkg, pkg[1]+".proto")...)
+}
+
+// WriteProtoFiles writes the proto file of every schema under dir
+func WriteProtoFiles(dir string) ([]string, error) {
+	var paths []string
+	for _, schema := range protoSchemas {
+		path := filepath.Join(dir, schema.Path())
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create proto directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(schema.Proto()), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write proto file: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Proto renders the schema as a proto3 file. Nested types become messages
+// of their own, named after their Go types.
+func (s ProtoSchema) Proto() string {
+	g := &protoGen{names: make(map[reflect.Type]string), imports: make(map[string]bool)}
+	for _, msg := range s.Messages {
+		g.names[reflect.TypeOf(msg.Value)] = msg.Name
+	}
+	for _, msg := range s.Messages {
+		comment := ""
+		switch msg.Direction {
+		case DirectionRequest:
+			comment = "Sent by the client that opened the stream"
+		case DirectionResponse:
+			comment = "Sent by the server"
+		case DirectionBoth:
+			comment = "Sent by both sides"
+		}
+		g.message(msg.Name, reflect.TypeOf(msg.Value), comment)
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by \"libp2p-node schemas\"; DO NOT EDIT.\n//\n")
+	fmt.Fprintf(&b, "// Messages of %s, sent as one JSON object per line in the\n", s.Protocol)
+	b.WriteString("// proto3 JSON mapping, with these differences: 64-bit integers are JSON\n")
+	b.WriteString("// numbers rather than strings, and durations are Go duration strings such\n")
+	b.WriteString("// as \"1m30s\".\n")
+	b.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&b, "package %s;\n", s.Package())
+	if len(g.imports) > 0 {
+		b.WriteString("\n")
+		for _, imp := range []string{"google/protobuf/struct.proto", "google/protobuf/timestamp.proto"} {
+			if g.imports[imp] {
+				fmt.Fprintf(&b, "import \"%s\";\n", imp)
+			}
+		}
+	}
+	for _, msg := range g.out {
+		b.WriteString("\n")
+		b.WriteString(msg)
+	}
+	return b.String()
+}
+
+// protoGen collects the messages of a proto file
+type protoGen struct {
+	names   map[reflect.Type]string // message names of the types seen
+	out     []string
+	imports map[string]bool
+}
+
+// message renders a struct type as a message, and the types it uses after it
+func (g *protoGen) message(name string, t reflect.Type, comment string) {
+	var b strings.Builder
+	if comment != "" {
+		fmt.Fprintf(&b, "// %s\n", comment)
+	}
+	fmt.Fprintf(&b, "message %s {\n", name)
+	g.out = append(g.out, "")
+	slot := len(g.out) - 1
+
+	if t == cidType {
+		b.WriteString("  string root = 1 [json_name = \"/\"];\n")
+	}
+	number := 1
+	for i := 0; t != cidType && i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(tag, ",")
+		if jsonName == "" {
+			jsonName = f.Name
+		}
+		typ, note := g.fieldType(f.Type)
+		if note != "" {
+			note = " // " + note
+		}
+		fmt.Fprintf(&b, "  %s %s = %d [json_name = %q];%s\n", typ, strings.ToLower(jsonName), number, jsonName, note)
+		number++
+	}
+	b.WriteString("}\n")
+	g.out[slot] = b.String()
+}
+
+// fieldType returns the proto type of a field, and a comment for types the
+// proto3 JSON mapping doesn't cover
+func (g *protoGen) fieldType(t reflect.Type) (string, string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		g.imports["google/protobuf/timestamp.proto"] = true
+		return "google.protobuf.Timestamp", ""
+	case durationType:
+		return "string", "Go duration"
+	case peerIDType:
+		return "string", "peer ID"
+	case rawJSONType:
+		g.imports["google/protobuf/struct.proto"] = true
+		return "google.protobuf.Value", ""
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string", ""
+	case reflect.Bool:
+		return "bool", ""
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return "int32", ""
+	case reflect.Int, reflect.Int64:
+		return "int64", ""
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "uint32", ""
+	case reflect.Uint, reflect.Uint64:
+		return "uint64", ""
+	case reflect.Float32:
+		return "float", ""
+	case reflect.Float64:
+		return "double", ""
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", ""
+		}
+		elem, note := g.fieldType(t.Elem())
+		if !strings.HasPrefix(elem, "repeated ") && !strings.HasPrefix(elem, "map<") {
+			return "repeated " + elem, note
+		}
+	case reflect.Map:
+		elem, note := g.fieldType(t.Elem())
+		if t.Key().Kind() == reflect.String && !strings.HasPrefix(elem, "repeated ") && !strings.HasPrefix(elem, "map<") {
+			return "map<string, " + elem + ">", note
+		}
+	case reflect.Struct:
+		name, ok := g.names[t]
+		if !ok {
+			name = t.Name()
+			if t == cidType {
+				name = "Cid"
+			}
+			name = strings.ToUpper(name[:1]) + name[1:]
+			g.names[t] = name
+			g.message(name, t, "")
+		}
+		return name, ""
+	}
+	// Anything else is arbitrary JSON
+	g.imports["google/protobuf/struct.proto"] = true
+	return "google.protobuf.Value", ""
+}
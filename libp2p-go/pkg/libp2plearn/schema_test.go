@@ -0,0 +1,54 @@
+package libp2plearn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoSchemas(t *testing.T) {
+	t.Run("Package", func(t *testing.T) {
+		pkg, ok := SchemaPackage(HealthProtocol)
+		require.True(t, ok)
+		assert.Equal(t, "libp2plearn.health.v1", pkg)
+
+		// Minor versions speak the same schema, major versions don't
+		pkg, ok = SchemaPackage("/libp2p-learn/health/1.4.0")
+		require.True(t, ok)
+		assert.Equal(t, "libp2plearn.health.v1", pkg)
+		_, ok = SchemaPackage("/libp2p-learn/health/2.0.0")
+		assert.False(t, ok)
+
+		pkg, ok = SchemaPackage(ChatSessionProtocol)
+		require.True(t, ok)
+		assert.Equal(t, "libp2plearn.chat_session.v1", pkg)
+
+		_, ok = SchemaPackage(EchoProtocol)
+		assert.False(t, ok)
+	})
+
+	t.Run("Coverage", func(t *testing.T) {
+		// Every JSON lines protocol has a schema
+		for _, doc := range builtinProtocolDocs() {
+			if doc.Encoding != EncodingJSONLines {
+				continue
+			}
+			_, ok := SchemaPackage(protocol.ID(doc.ID))
+			assert.True(t, ok, "no schema for %s", doc.ID)
+		}
+	})
+
+	t.Run("UpToDate", func(t *testing.T) {
+		// The checked in proto files are what the schemas generate; run
+		// make proto after changing a message
+		for _, schema := range ProtoSchemas() {
+			want, err := os.ReadFile(filepath.Join("..", "..", "proto", schema.Path()))
+			require.NoError(t, err)
+			assert.Equal(t, string(want), schema.Proto(), "%s is out of date", schema.Path())
+		}
+	})
+}
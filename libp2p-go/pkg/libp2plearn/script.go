@@ -0,0 +1,104 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxScriptSteps bounds the work of one script call, so a runaway loop
+	// cannot stall the protocol it filters
+	maxScriptSteps = 1_000_000
+
+	// scriptGreetingTimeout bounds sending the message a script answers a
+	// new peer with
+	scriptGreetingTimeout = 10 * time.Second
+)
+
+// ScriptConfig runs a Starlark script on events. Its on_message applies to
+// the listed protocols, or to ping and chat if none are listed.
+type ScriptConfig struct {
+	File      string   `json:"file"`
+	Protocols []string `json:"protocols"`
+}
+
+// scriptEngine runs the event functions a script defines. Functions the
+// script does not define leave messages alone and do nothing.
+type scriptEngine interface {
+	// onMessage calls on_message(msg) for a received message
+	onMessage(proto protocol.ID, from peer.ID, text string) (MessageAction, error)
+	// onPeerConnected calls on_peer_connected(peer), which may return a
+	// chat message to send to the peer
+	onPeerConnected(p peer.ID) (string, error)
+}
+
+// Script is a user script that filters, rewrites and answers messages and
+// greets peers, so simple automation needs no recompile. A script that
+// fails leaves the message alone and logs the error.
+type Script struct {
+	name      string
+	engine    scriptEngine
+	protocols []protocol.ID
+}
+
+// LoadScript reads and compiles a script
+func LoadScript(cfg ScriptConfig) (*Script, error) {
+	src, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	name := filepath.Base(cfg.File)
+	engine, err := compileScript(name, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script %s: %w", cfg.File, err)
+	}
+	s := &Script{name: name, engine: engine}
+	for _, proto := range cfg.Protocols {
+		s.protocols = append(s.protocols, protocol.ID(proto))
+	}
+	logrus.WithFields(logrus.Fields{
+		"script":    name,
+		"protocols": cfg.Protocols,
+	}).Info("Loaded script")
+	return s, nil
+}
+
+// Filter is a MessageFilter that runs the script's on_message
+func (s *Script) Filter(proto protocol.ID, from peer.ID, msg string) MessageAction {
+	if len(s.protocols) > 0 && !slices.Contains(s.protocols, proto) {
+		return MessageAction{Message: msg}
+	}
+	action, err := s.engine.onMessage(proto, from, msg)
+	if err != nil {
+		logrus.WithError(err).WithField("script", s.name).Warn("Script failed on message")
+		return MessageAction{Message: msg}
+	}
+	return action
+}
+
+// greet runs the script's on_peer_connected and sends the peer the chat
+// message it returns, if any
+func (s *Script) greet(protocols *ProtocolHandler, p peer.ID) {
+	log := logrus.WithFields(logrus.Fields{"script": s.name, "peer": p})
+	msg, err := s.engine.onPeerConnected(p)
+	if err != nil {
+		log.WithError(err).Warn("Script failed on peer connected")
+		return
+	}
+	if msg == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scriptGreetingTimeout)
+	defer cancel()
+	if _, err := protocols.SendChatMessage(ctx, p, msg); err != nil {
+		log.WithError(err).Debug("Failed to send script greeting")
+	}
+}
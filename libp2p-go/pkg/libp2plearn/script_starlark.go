@@ -0,0 +1,112 @@
+package libp2plearn
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// starlarkScript runs the functions of a Starlark script. Its globals are
+// frozen once loaded, so calls may run concurrently, each on its own thread.
+type starlarkScript struct {
+	name    string
+	globals starlark.StringDict
+}
+
+// compileScript runs a script's top level, which defines its functions
+func compileScript(name string, src []byte) (scriptEngine, error) {
+	globals, err := starlark.ExecFile(newScriptThread(name), name, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &starlarkScript{name: name, globals: globals}, nil
+}
+
+// newScriptThread creates a thread for one call, which prints to the log
+func newScriptThread(name string) *starlark.Thread {
+	log := logrus.WithField("script", name)
+	thread := &starlark.Thread{
+		Name:  name,
+		Print: func(_ *starlark.Thread, msg string) { log.Info(msg) },
+	}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	return thread
+}
+
+// call calls a function of the script, returning None if it is not defined
+func (s *starlarkScript) call(fn string, args ...starlark.Value) (starlark.Value, error) {
+	callable, ok := s.globals[fn].(starlark.Callable)
+	if !ok {
+		return starlark.None, nil
+	}
+	return starlark.Call(newScriptThread(s.name), callable, args, nil)
+}
+
+// onMessage calls on_message with a struct of protocol, peer and text. It
+// may return None to leave the message alone, a string to rewrite it, or a
+// dict with any of text, reply and drop.
+func (s *starlarkScript) onMessage(proto protocol.ID, from peer.ID, text string) (MessageAction, error) {
+	msg := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"protocol": starlark.String(proto),
+		"peer":     starlark.String(from.String()),
+		"text":     starlark.String(text),
+	})
+	result, err := s.call("on_message", msg)
+	if err != nil {
+		return MessageAction{}, err
+	}
+
+	action := MessageAction{Message: text}
+	switch v := result.(type) {
+	case starlark.NoneType:
+	case starlark.String:
+		action.Message = string(v)
+	case *starlark.Dict:
+		if action.Message, err = dictString(v, "text", text); err != nil {
+			return MessageAction{}, err
+		}
+		if action.Reply, err = dictString(v, "reply", ""); err != nil {
+			return MessageAction{}, err
+		}
+		if drop, ok, _ := v.Get(starlark.String("drop")); ok {
+			action.Drop = bool(drop.Truth())
+		}
+	default:
+		return MessageAction{}, fmt.Errorf("on_message returned %s, want None, a string or a dict", result.Type())
+	}
+	return action, nil
+}
+
+// onPeerConnected calls on_peer_connected with the peer ID. It may return
+// a message to send to the peer.
+func (s *starlarkScript) onPeerConnected(p peer.ID) (string, error) {
+	result, err := s.call("on_peer_connected", starlark.String(p.String()))
+	if err != nil {
+		return "", err
+	}
+	switch v := result.(type) {
+	case starlark.NoneType:
+		return "", nil
+	case starlark.String:
+		return string(v), nil
+	default:
+		return "", fmt.Errorf("on_peer_connected returned %s, want None or a string", result.Type())
+	}
+}
+
+// dictString returns a string entry of a dict, or def if it is missing
+func dictString(d *starlark.Dict, key, def string) (string, error) {
+	v, ok, _ := d.Get(starlark.String(key))
+	if !ok {
+		return def, nil
+	}
+	s, ok := starlark.AsString(v)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %s", key, v.Type())
+	}
+	return s, nil
+}
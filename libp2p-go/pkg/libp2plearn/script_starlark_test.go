@@ -0,0 +1,51 @@
+package libp2plearn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testScript = `
+def on_message(msg):
+    if "spam" in msg.text:
+        return {"drop": True}
+    if msg.text == "ping?":
+        return {"reply": "pong from " + msg.protocol}
+    return msg.text.upper()
+
+def on_peer_connected(peer):
+    return "hello " + peer[:4]
+
+def forever():
+    for i in range(100000000):
+        pass
+`
+
+func TestStarlarkScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auto.star")
+	require.NoError(t, os.WriteFile(path, []byte(testScript), 0o644))
+	script, err := LoadScript(ScriptConfig{File: path})
+	require.NoError(t, err)
+
+	assert.Equal(t, MessageAction{Message: "HELLO"}, script.Filter(ChatProtocol, "", "hello"))
+	assert.True(t, script.Filter(ChatProtocol, "", "buy spam").Drop)
+	assert.Equal(t, "pong from "+ChatProtocol, script.Filter(ChatProtocol, "", "ping?").Reply)
+
+	id := peer.ID("peer")
+	greeting, err := script.engine.onPeerConnected(id)
+	require.NoError(t, err)
+	assert.Equal(t, "hello "+id.String()[:4], greeting)
+
+	_, err = script.engine.(*starlarkScript).call("forever")
+	assert.Error(t, err, "runaway scripts are stopped")
+
+	require.NoError(t, os.WriteFile(path, []byte("def on_message(msg):\n    return 42\n"), 0o644))
+	script, err = LoadScript(ScriptConfig{File: path})
+	require.NoError(t, err)
+	assert.Equal(t, MessageAction{Message: "hello"}, script.Filter(ChatProtocol, "", "hello"))
+}
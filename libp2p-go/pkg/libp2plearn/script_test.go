@@ -0,0 +1,73 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScript stands in for a compiled script
+type fakeScript struct {
+	action   MessageAction
+	err      error
+	greeting string
+}
+
+func (f *fakeScript) onMessage(proto protocol.ID, from peer.ID, text string) (MessageAction, error) {
+	return f.action, f.err
+}
+
+func (f *fakeScript) onPeerConnected(p peer.ID) (string, error) {
+	return f.greeting, f.err
+}
+
+func TestScript(t *testing.T) {
+	engine := &fakeScript{action: MessageAction{Message: "rewritten", Reply: "auto"}}
+	script := &Script{name: "test.star", engine: engine, protocols: []protocol.ID{ChatProtocol}}
+
+	assert.Equal(t, engine.action, script.Filter(ChatProtocol, "", "hello"))
+	assert.Equal(t, MessageAction{Message: "hello"}, script.Filter(PingProtocol, "", "hello"), "other protocols pass untouched")
+
+	engine.err = errors.New("boom")
+	assert.Equal(t, MessageAction{Message: "hello"}, script.Filter(ChatProtocol, "", "hello"), "failing scripts leave messages alone")
+
+	cfg := DefaultConfig()
+	cfg.Scripts = []ScriptConfig{{File: "greeter.star", Protocols: []string{ChatProtocol}}}
+	assert.NoError(t, cfg.Validate())
+	cfg.Scripts[0].Protocols = []string{EchoProtocol}
+	assert.Error(t, cfg.Validate(), "scripts only run on ping and chat")
+}
+
+func TestScriptGreeting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	h1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h2.Close()
+	require.NoError(t, connectNodes(ctx, h1, h2))
+
+	received := make(chan string, 1)
+	p2 := NewProtocolHandler(h2)
+	p2.SetupProtocols()
+	p2.OnMessage(func(proto protocol.ID, from peer.ID, msg string) { received <- msg })
+
+	script := &Script{name: "greeter.star", engine: &fakeScript{greeting: "welcome"}}
+	script.greet(NewProtocolHandler(h1), h2.ID())
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "welcome", msg)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for greeting")
+	}
+}
@@ -0,0 +1,173 @@
+package libp2plearn
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// sealedVersion is the first byte of every sealed message
+	sealedVersion byte = 1
+
+	// sealedInfo binds derived keys to this scheme
+	sealedInfo = "libp2p-learn/sealed/1"
+
+	// SealedOverhead is how many bytes sealing adds to a message
+	SealedOverhead = 1 + 32 + chacha20poly1305.Overhead
+)
+
+// curve25519P is the field prime 2^255 - 19
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// EncryptFor seals a message so that only the holder of the peer's private key
+// can read it. Each message uses a fresh X25519 key, agreed with the peer's
+// Ed25519 identity key, and ChaCha20-Poly1305, so stores that relay it (such as
+// mailbox nodes) can neither read nor alter it. The sender stays anonymous;
+// sign the plaintext if the recipient needs to know who sent it.
+func EncryptFor(peerID peer.ID, plaintext []byte) ([]byte, error) {
+	pub, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipient public key: %w", err)
+	}
+	recipient, err := x25519PublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to agree on key: %w", err)
+	}
+	aead, err := sealedCipher(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, SealedOverhead+len(plaintext))
+	out = append(out, sealedVersion)
+	out = append(out, ephemeral.PublicKey().Bytes()...)
+	// The key is never reused, so a zero nonce is safe
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(out, nonce, plaintext, out[:1]), nil
+}
+
+// Decrypt opens a message sealed with EncryptFor for the owner of key
+func Decrypt(key crypto.PrivKey, sealed []byte) ([]byte, error) {
+	if len(sealed) < SealedOverhead {
+		return nil, fmt.Errorf("sealed message too short")
+	}
+	if sealed[0] != sealedVersion {
+		return nil, fmt.Errorf("unsupported sealed message version %d", sealed[0])
+	}
+
+	priv, err := x25519PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[1:33])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to agree on key: %w", err)
+	}
+	aead, err := sealedCipher(shared, ephemeral, priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	plaintext, err := aead.Open(nil, nonce, sealed[33:], sealed[:1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: not sealed for this key or altered")
+	}
+	return plaintext, nil
+}
+
+// Decrypt opens a message sealed for this node
+func (n *Node) Decrypt(sealed []byte) ([]byte, error) {
+	return Decrypt(n.host.Peerstore().PrivKey(n.host.ID()), sealed)
+}
+
+// sealedCipher derives the message cipher from the shared secret, bound to
+// both public keys
+func sealedCipher(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(sealedInfo)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return chacha20poly1305.New(key)
+}
+
+// x25519PublicKey converts an Ed25519 public key to its X25519 form,
+// u = (1 + y) / (1 - y)
+func x25519PublicKey(pub crypto.PubKey) (*ecdh.PublicKey, error) {
+	if pub.Type() != crypto.Ed25519 {
+		return nil, fmt.Errorf("encryption needs an Ed25519 key, peer has %s", pub.Type())
+	}
+	raw, err := pub.Raw()
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+
+	// y is little-endian, with the sign of x in the top bit
+	be := make([]byte, 32)
+	for i, b := range raw {
+		be[31-i] = b
+	}
+	be[0] &= 0x7f
+	y := new(big.Int).SetBytes(be)
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	for i, j := 0, 31; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return ecdh.X25519().NewPublicKey(out)
+}
+
+// x25519PrivateKey converts an Ed25519 private key to its X25519 form, the
+// clamped first half of the SHA-512 of the seed
+func x25519PrivateKey(key crypto.PrivKey) (*ecdh.PrivateKey, error) {
+	if key == nil {
+		return nil, fmt.Errorf("no private key")
+	}
+	if key.Type() != crypto.Ed25519 {
+		return nil, fmt.Errorf("decryption needs an Ed25519 key, have %s", key.Type())
+	}
+	raw, err := key.Raw()
+	if err != nil || len(raw) < 32 {
+		return nil, fmt.Errorf("invalid Ed25519 private key")
+	}
+	h := sha512.Sum512(raw[:32])
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
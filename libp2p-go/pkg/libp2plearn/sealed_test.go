@@ -0,0 +1,69 @@
+package libp2plearn
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealedMessages(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	msg := []byte("meet me at the usual place")
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		sealed, err := EncryptFor(id, msg)
+		require.NoError(t, err)
+		assert.Len(t, sealed, len(msg)+SealedOverhead)
+		assert.NotContains(t, string(sealed), string(msg))
+
+		opened, err := Decrypt(key, sealed)
+		require.NoError(t, err)
+		assert.Equal(t, msg, opened)
+	})
+
+	t.Run("FreshKeyPerMessage", func(t *testing.T) {
+		a, err := EncryptFor(id, msg)
+		require.NoError(t, err)
+		b, err := EncryptFor(id, msg)
+		require.NoError(t, err)
+		assert.NotEqual(t, a, b)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		sealed, err := EncryptFor(id, msg)
+		require.NoError(t, err)
+		_, err = Decrypt(other, sealed)
+		assert.Error(t, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		sealed, err := EncryptFor(id, msg)
+		require.NoError(t, err)
+		sealed[len(sealed)-1] ^= 1
+		_, err = Decrypt(key, sealed)
+		assert.Error(t, err)
+
+		_, err = Decrypt(key, sealed[:SealedOverhead-1])
+		assert.Error(t, err)
+	})
+
+	t.Run("NeedsEd25519", func(t *testing.T) {
+		secp, _, err := crypto.GenerateSecp256k1Key(rand.Reader)
+		require.NoError(t, err)
+		secpID, err := peer.IDFromPrivateKey(secp)
+		require.NoError(t, err)
+
+		_, err = EncryptFor(secpID, msg)
+		assert.ErrorContains(t, err, "Ed25519")
+	})
+}
@@ -0,0 +1,330 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PrekeyProtocol serves the node's signed prekey for starting secure chat sessions
+	PrekeyProtocol = "/libp2p-learn/prekey/1.0.0"
+
+	// prekeySignatureDomain separates prekey signatures from other uses of the identity key
+	prekeySignatureDomain = "libp2p-learn-prekey:"
+
+	// prekeyTimeout bounds fetching a peer's prekey
+	prekeyTimeout = 10 * time.Second
+
+	// maxPrekeyBundleSize bounds the size of a prekey response
+	maxPrekeyBundleSize = 4 * 1024
+)
+
+// prekeyBundle is a prekey signed with the node's identity key, sent as one JSON line
+type prekeyBundle struct {
+	Prekey    []byte `json:"prekey"`
+	Signature []byte `json:"signature"`
+}
+
+// secureChatState is what SecureChat keeps on disk
+type secureChatState struct {
+	Prekey    []byte                   `json:"prekey"`
+	Signature []byte                   `json:"signature"`
+	Sessions  map[string]*ratchetState `json:"sessions"`
+}
+
+// SecureChat encrypts chat messages end to end with a double ratchet per peer,
+// started with X3DH from the peers' identity keys and signed prekeys. Message
+// keys are derived once and dropped after use, so a stolen key or session file
+// does not reveal earlier messages, whatever the transport security.
+type SecureChat struct {
+	host     host.Host
+	path     string
+	identity *ecdh.PrivateKey
+	bundle   prekeyBundle
+	prekey   *ecdh.PrivateKey
+
+	mu       sync.Mutex
+	sessions map[peer.ID]*ratchetState
+}
+
+// NewSecureChat loads the prekey and sessions saved at path, creating them if
+// the file doesn't exist, and registers the prekey protocol. With an empty
+// path, sessions only last until the node stops.
+func NewSecureChat(h host.Host, path string) (*SecureChat, error) {
+	identity, err := x25519PrivateKey(h.Peerstore().PrivKey(h.ID()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity key: %w", err)
+	}
+	sc := &SecureChat{
+		host:     h,
+		path:     path,
+		identity: identity,
+		sessions: make(map[peer.ID]*ratchetState),
+	}
+	if err := sc.load(); err != nil {
+		return nil, err
+	}
+
+	h.SetStreamHandler(protocol.ID(PrekeyProtocol), RecoveryMiddleware(protocol.ID(PrekeyProtocol), sc.handlePrekey))
+	logrus.WithField("protocol", PrekeyProtocol).Info("Registered prekey protocol")
+	return sc, nil
+}
+
+// Close unregisters the prekey protocol
+func (sc *SecureChat) Close() {
+	sc.host.RemoveStreamHandler(protocol.ID(PrekeyProtocol))
+}
+
+// Encrypt seals a message for a peer, starting a session with its signed
+// prekey if there is none yet
+func (sc *SecureChat) Encrypt(ctx context.Context, p peer.ID, plaintext []byte) ([]byte, error) {
+	sc.mu.Lock()
+	_, ok := sc.sessions[p]
+	sc.mu.Unlock()
+
+	var started *ratchetState
+	if !ok {
+		remoteIdentity, remotePrekey, err := sc.fetchPrekey(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		started, err = x3dhInitiate(sc.identity, remoteIdentity, remotePrekey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start session: %w", err)
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	state, ok := sc.sessions[p]
+	if !ok {
+		state = started
+		sc.sessions[p] = state
+		logrus.WithField("peer", p).Info("Started secure chat session")
+	}
+	msg, err := state.encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if err := sc.save(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+// Decrypt opens a message from a peer
+func (sc *SecureChat) Decrypt(p peer.ID, data []byte) ([]byte, error) {
+	var msg ratchetMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted message: %w", err)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	state := sc.sessions[p]
+	if msg.Init != nil && (state == nil || !bytes.Equal(state.PeerInit, msg.Init.Ephemeral)) {
+		return sc.accept(p, state, &msg)
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no secure chat session with %s", p)
+	}
+
+	plaintext, err := state.decrypt(&msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := sc.save(); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// accept decrypts the first message of a session the peer started; sc.mu
+// must be held
+func (sc *SecureChat) accept(p peer.ID, current *ratchetState, msg *ratchetMessage) ([]byte, error) {
+	pub, err := p.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer public key: %w", err)
+	}
+	remoteIdentity, err := x25519PublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	state, err := x3dhRespond(sc.identity, sc.prekey, remoteIdentity, msg.Init)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := state.decrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// When both sides start a session at once, both keep the one started by
+	// the peer with the lower ID
+	if current != nil && current.Init != nil && sc.host.ID() < p {
+		return plaintext, nil
+	}
+
+	sc.sessions[p] = state
+	if err := sc.save(); err != nil {
+		return nil, err
+	}
+	logrus.WithField("peer", p).Info("Accepted secure chat session")
+	return plaintext, nil
+}
+
+// handlePrekey sends our signed prekey
+func (sc *SecureChat) handlePrekey(s network.Stream) {
+	defer s.Close()
+
+	data, err := json.Marshal(sc.bundle)
+	if err != nil {
+		s.Reset()
+		return
+	}
+	s.SetWriteDeadline(time.Now().Add(prekeyTimeout))
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		logrus.WithError(err).WithField("peer", s.Conn().RemotePeer()).Debug("Failed to send prekey")
+	}
+}
+
+// fetchPrekey gets a peer's identity key and checks its signed prekey
+func (sc *SecureChat) fetchPrekey(ctx context.Context, p peer.ID) (*ecdh.PublicKey, *ecdh.PublicKey, error) {
+	pub, err := p.ExtractPublicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get peer public key: %w", err)
+	}
+	identity, err := x25519PublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, prekeyTimeout)
+	defer cancel()
+	s, err := sc.host.NewStream(ctx, p, protocol.ID(PrekeyProtocol))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+	s.CloseWrite()
+
+	deadline, _ := ctx.Deadline()
+	s.SetReadDeadline(deadline)
+	line, err := bufio.NewReader(io.LimitReader(s, maxPrekeyBundleSize)).ReadBytes('\n')
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read prekey: %w", err)
+	}
+	var bundle prekeyBundle
+	if err := json.Unmarshal(line, &bundle); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode prekey: %w", err)
+	}
+
+	ok, err := pub.Verify(append([]byte(prekeySignatureDomain), bundle.Prekey...), bundle.Signature)
+	if err != nil || !ok {
+		ReportMisbehavior(sc.host, p, MisbehaviorInvalidSignature, "prekey")
+		return nil, nil, fmt.Errorf("invalid prekey signature from %s", p)
+	}
+	prekey, err := ecdh.X25519().NewPublicKey(bundle.Prekey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid prekey: %w", err)
+	}
+	return identity, prekey, nil
+}
+
+// load reads the saved state, or creates and signs a new prekey
+func (sc *SecureChat) load() error {
+	var state secureChatState
+	if sc.path != "" {
+		data, err := os.ReadFile(sc.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read secure chat state: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &state); err != nil {
+				return fmt.Errorf("failed to parse secure chat state: %w", err)
+			}
+		}
+	}
+
+	if state.Prekey != nil {
+		prekey, err := ecdh.X25519().NewPrivateKey(state.Prekey)
+		if err != nil {
+			return fmt.Errorf("invalid saved prekey: %w", err)
+		}
+		sc.prekey = prekey
+		sc.bundle = prekeyBundle{Prekey: prekey.PublicKey().Bytes(), Signature: state.Signature}
+		for id, session := range state.Sessions {
+			p, err := peer.Decode(id)
+			if err != nil {
+				logrus.WithField("peer", id).Warn("Skipping invalid peer in secure chat state")
+				continue
+			}
+			sc.sessions[p] = session
+		}
+		logrus.WithFields(logrus.Fields{
+			"file":     sc.path,
+			"sessions": len(sc.sessions),
+		}).Info("Loaded secure chat sessions")
+		return nil
+	}
+
+	prekey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate prekey: %w", err)
+	}
+	pub := prekey.PublicKey().Bytes()
+	sig, err := sc.host.Peerstore().PrivKey(sc.host.ID()).Sign(append([]byte(prekeySignatureDomain), pub...))
+	if err != nil {
+		return fmt.Errorf("failed to sign prekey: %w", err)
+	}
+	sc.prekey = prekey
+	sc.bundle = prekeyBundle{Prekey: pub, Signature: sig}
+	return sc.save()
+}
+
+// save writes the prekey and sessions to disk; sc.mu must be held
+func (sc *SecureChat) save() error {
+	if sc.path == "" {
+		return nil
+	}
+
+	state := secureChatState{
+		Prekey:    sc.prekey.Bytes(),
+		Signature: sc.bundle.Signature,
+		Sessions:  make(map[string]*ratchetState, len(sc.sessions)),
+	}
+	for p, session := range sc.sessions {
+		state.Sessions[p.String()] = session
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode secure chat state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sc.path), 0700); err != nil {
+		return fmt.Errorf("failed to create secure chat directory: %w", err)
+	}
+
+	if err := writeFileAtomic(sc.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write secure chat state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package libp2plearn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecureChat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	serverSecure, err := NewSecureChat(server, filepath.Join(dir, "server.json"))
+	require.NoError(t, err)
+	serverHandler := NewProtocolHandler(server)
+	serverHandler.SetSecureChat(serverSecure)
+	serverHandler.SetupProtocols()
+
+	sessions := make(chan *ChatSession, 4)
+	serverHandler.SetChatSessionHandler(func(sess *ChatSession) {
+		sessions <- sess
+	})
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+	clientFile := filepath.Join(dir, "client.json")
+	clientSecure, err := NewSecureChat(client, clientFile)
+	require.NoError(t, err)
+	handler := NewProtocolHandler(client)
+	handler.SetSecureChat(clientSecure)
+
+	// converse opens a session, sends text and has the server answer
+	converse := func(t *testing.T, text, reply string) {
+		local, err := handler.OpenChatSession(ctx, server.ID())
+		require.NoError(t, err)
+		defer local.Close()
+		_, err = local.Send(text)
+		require.NoError(t, err)
+
+		var remote *ChatSession
+		select {
+		case remote = <-sessions:
+		case <-ctx.Done():
+			t.Fatal("server never saw the session")
+		}
+		defer remote.Close()
+
+		evt := nextChatEvent(t, remote)
+		assert.Equal(t, text, evt.Text)
+		_, err = remote.Send(reply)
+		require.NoError(t, err)
+		assert.Equal(t, reply, nextChatEvent(t, local).Text)
+	}
+
+	t.Run("EncryptsMessages", func(t *testing.T) {
+		converse(t, "the password is swordfish", "noted")
+
+		// The saved sessions hold keys, never the text
+		data, err := os.ReadFile(clientFile)
+		require.NoError(t, err)
+		assert.False(t, strings.Contains(string(data), "swordfish"))
+	})
+
+	t.Run("SessionSurvivesRestart", func(t *testing.T) {
+		clientSecure.Close()
+		restored, err := NewSecureChat(client, clientFile)
+		require.NoError(t, err)
+		handler.SetSecureChat(restored)
+
+		converse(t, "back again", "welcome back")
+
+		restored.mu.Lock()
+		defer restored.mu.Unlock()
+		assert.Nil(t, restored.sessions[server.ID()].Init, "the restored session is the one already answered")
+	})
+
+	t.Run("RefusesPlaintext", func(t *testing.T) {
+		plain := NewProtocolHandler(client)
+		sess, err := plain.OpenChatSession(ctx, server.ID())
+		require.NoError(t, err)
+		defer sess.Close()
+		_, err = sess.Send("in the clear")
+		require.NoError(t, err)
+
+		var remote *ChatSession
+		select {
+		case remote = <-sessions:
+		case <-ctx.Done():
+			t.Fatal("server never saw the session")
+		}
+		select {
+		case _, ok := <-remote.Events():
+			assert.False(t, ok, "unencrypted messages must end the session")
+		case <-time.After(5 * time.Second):
+			t.Fatal("session with plaintext message never ended")
+		}
+	})
+}
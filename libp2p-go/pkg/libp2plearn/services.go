@@ -0,0 +1,119 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/gostream"
+	"github.com/sirupsen/logrus"
+)
+
+// services tracks the listeners and tunnels opened on the node so Stop can close them
+type services struct {
+	mu        sync.Mutex
+	listeners map[protocol.ID]net.Listener
+	tunnels   map[*Tunnel]struct{}
+}
+
+// serviceListener removes itself from the node's services when closed
+type serviceListener struct {
+	net.Listener
+	once    sync.Once
+	onClose func()
+}
+
+func (l *serviceListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		err = l.Listener.Close()
+		l.onClose()
+	})
+	return err
+}
+
+// ListenService returns a listener whose connections are libp2p streams of
+// the given protocol, so any net.Listener based server (gRPC, net/http, ...)
+// can be served to peers. Connections' addresses are peer IDs.
+func (n *Node) ListenService(proto protocol.ID) (net.Listener, error) {
+	n.services.mu.Lock()
+	defer n.services.mu.Unlock()
+
+	if _, ok := n.services.listeners[proto]; ok {
+		return nil, fmt.Errorf("already listening on %s", proto)
+	}
+	l, err := gostream.Listen(n.host, proto)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", proto, err)
+	}
+
+	sl := &serviceListener{Listener: l}
+	sl.onClose = func() {
+		n.services.mu.Lock()
+		defer n.services.mu.Unlock()
+		if n.services.listeners[proto] == net.Listener(sl) {
+			delete(n.services.listeners, proto)
+		}
+	}
+	if n.services.listeners == nil {
+		n.services.listeners = make(map[protocol.ID]net.Listener)
+	}
+	n.services.listeners[proto] = sl
+
+	logrus.WithField("protocol", proto).Info("Listening for service connections")
+	return sl, nil
+}
+
+// DialService opens a stream of the given protocol to a peer as a net.Conn,
+// the client side of ListenService
+func (n *Node) DialService(ctx context.Context, p peer.ID, proto protocol.ID) (net.Conn, error) {
+	conn, err := gostream.Dial(ctx, n.host, p, proto)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s on %s: %w", proto, p, err)
+	}
+	return conn, nil
+}
+
+// ServiceDialer returns a dial function for clients that take one, such as
+// grpc.WithContextDialer. The address is the peer ID; for host:port style
+// addresses the host part is used.
+func (n *Node) ServiceDialer(proto protocol.ID) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		p, err := peer.Decode(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service address %q: %w", addr, err)
+		}
+		return n.DialService(ctx, p, proto)
+	}
+}
+
+// closeServices closes every tunnel and listener still open
+func (n *Node) closeServices() {
+	n.services.mu.Lock()
+	tunnels := make([]*Tunnel, 0, len(n.services.tunnels))
+	for t := range n.services.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	n.services.mu.Unlock()
+
+	for _, t := range tunnels {
+		t.Close()
+	}
+
+	n.services.mu.Lock()
+	listeners := make([]net.Listener, 0, len(n.services.listeners))
+	for _, l := range n.services.listeners {
+		listeners = append(listeners, l)
+	}
+	n.services.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer server.Stop(context.Background())
+
+	client, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer client.Stop(context.Background())
+
+	require.NoError(t, connectNodes(ctx, client.Host(), server.Host()))
+
+	t.Run("RawConn", func(t *testing.T) {
+		proto := protocol.ID("/libp2p-learn/test-echo/1.0.0")
+		l, err := server.ListenService(proto)
+		require.NoError(t, err)
+		defer l.Close()
+
+		_, err = server.ListenService(proto)
+		assert.Error(t, err, "listening twice on a protocol should fail")
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			assert.Equal(t, client.Host().ID().String(), conn.RemoteAddr().String())
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte("echo: " + line))
+		}()
+
+		conn, err := client.DialService(ctx, server.Host().ID(), proto)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello\n"))
+		require.NoError(t, err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "echo: hello\n", line)
+	})
+
+	t.Run("HTTPServer", func(t *testing.T) {
+		proto := protocol.ID("/libp2p-learn/test-http/1.0.0")
+		l, err := server.ListenService(proto)
+		require.NoError(t, err)
+
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "hello %s", r.RemoteAddr)
+		})}
+		go srv.Serve(l)
+		defer srv.Close()
+
+		dial := client.ServiceDialer(proto)
+		httpClient := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dial(ctx, addr)
+			},
+		}}
+
+		resp, err := httpClient.Get(fmt.Sprintf("http://%s/", server.Host().ID()))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello "+client.Host().ID().String(), string(body))
+	})
+
+	t.Run("CloseReleasesProtocol", func(t *testing.T) {
+		proto := protocol.ID("/libp2p-learn/test-reuse/1.0.0")
+		l, err := server.ListenService(proto)
+		require.NoError(t, err)
+		require.NoError(t, l.Close())
+
+		l, err = server.ListenService(proto)
+		require.NoError(t, err, "a closed listener's protocol should be free again")
+		l.Close()
+	})
+
+	t.Run("InvalidAddress", func(t *testing.T) {
+		_, err := client.ServiceDialer("/libp2p-learn/test-http/1.0.0")(ctx, "not-a-peer:80")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,398 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ShellProtocol runs commands and interactive shells for authorized peers
+	ShellProtocol = "/libp2p-learn/shell/1.0.0"
+
+	// shellRequestTimeout bounds reading the session request
+	shellRequestTimeout = 30 * time.Second
+
+	// maxShellRequestSize bounds the size of the session request line
+	maxShellRequestSize = 64 * 1024
+
+	// maxShellFrameSize bounds the payload of one frame
+	maxShellFrameSize = 32 * 1024
+
+	// shellExitFailed is the exit status reported when the command couldn't run
+	shellExitFailed = 255
+)
+
+// Shell frame types. After the request line, both sides exchange frames of a
+// type byte, a big-endian uint32 length and the payload.
+const (
+	shellFrameStdin    byte = iota // client input
+	shellFrameStdout               // command output (all output with a PTY)
+	shellFrameStderr               // command error output
+	shellFrameResize               // new terminal size: rows, cols as uint16
+	shellFrameStdinEOF             // client input is done
+	shellFrameExit                 // exit status as int32, the last frame
+)
+
+// ShellRequest starts a session, sent as one JSON line
+type ShellRequest struct {
+	Command string `json:"command,omitempty"` // run with the shell's -c; empty for an interactive shell
+	PTY     bool   `json:"pty,omitempty"`
+	Term    string `json:"term,omitempty"` // TERM for PTY sessions
+	Rows    uint16 `json:"rows,omitempty"`
+	Cols    uint16 `json:"cols,omitempty"`
+}
+
+// WindowSize is a terminal size
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
+}
+
+// ShellIO connects a remote session to local streams. Resize, if set,
+// delivers terminal size changes of PTY sessions.
+type ShellIO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Resize <-chan WindowSize
+}
+
+// Shell serves remote command and shell sessions to authorized peers. Peers
+// are authenticated by the secure channel, so only the remote peer ID needs
+// checking; sessions run with the node's user and environment.
+type Shell struct {
+	host    host.Host
+	command string
+	allowed map[peer.ID]bool
+	audit   *AuditLog
+}
+
+// NewShell creates the shell service and registers its protocol handler.
+// Only the given peer IDs may open sessions, which run the command (e.g. /bin/sh).
+func NewShell(h host.Host, allowedIDs []string, command string) (*Shell, error) {
+	sh := &Shell{
+		host:    h,
+		command: command,
+		allowed: make(map[peer.ID]bool, len(allowedIDs)),
+	}
+	for _, id := range allowedIDs {
+		p, err := peer.Decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shell peer %q: %w", id, err)
+		}
+		sh.allowed[p] = true
+	}
+
+	h.SetStreamHandler(protocol.ID(ShellProtocol), RecoveryMiddleware(protocol.ID(ShellProtocol), sh.handleShell))
+	logrus.WithFields(logrus.Fields{
+		"protocol": ShellProtocol,
+		"peers":    len(sh.allowed),
+	}).Warn("Remote shell enabled")
+	return sh, nil
+}
+
+// SetAuditLog records every session, and refused attempts, to the audit log
+func (sh *Shell) SetAuditLog(audit *AuditLog) {
+	sh.audit = audit
+}
+
+// Close unregisters the shell protocol
+func (sh *Shell) Close() {
+	sh.host.RemoveStreamHandler(protocol.ID(ShellProtocol))
+}
+
+// handleShell runs one session for an authorized peer
+func (sh *Shell) handleShell(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !sh.allowed[remote] {
+		logrus.WithField("peer", remote).Warn("Rejected shell stream from unauthorized peer")
+		sh.audit.Action(remote, ShellProtocol, "shell", nil, fmt.Errorf("unauthorized peer"))
+		s.Reset()
+		return
+	}
+	defer s.Close()
+
+	s.SetReadDeadline(time.Now().Add(shellRequestTimeout))
+	reader := bufio.NewReaderSize(s, maxShellRequestSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read shell request")
+		return
+	}
+	var req ShellRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Received invalid shell request")
+		return
+	}
+	s.SetReadDeadline(time.Time{})
+
+	logrus.WithFields(logrus.Fields{
+		"peer":    remote,
+		"command": req.Command,
+		"pty":     req.PTY,
+	}).Info("Starting remote shell session")
+
+	out := &shellFrameWriter{w: s}
+	code, err := sh.run(req, reader, out)
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Remote shell session failed")
+		out.write(shellFrameStderr, []byte(err.Error()+"\n"))
+	} else if code != 0 {
+		err = fmt.Errorf("exit status %d", code)
+	}
+	sh.audit.Action(remote, ShellProtocol, "shell", []string{req.Command}, err)
+
+	var status [4]byte
+	binary.BigEndian.PutUint32(status[:], uint32(int32(code)))
+	out.write(shellFrameExit, status[:])
+
+	logrus.WithFields(logrus.Fields{
+		"peer":      remote,
+		"exit_code": code,
+	}).Info("Remote shell session ended")
+}
+
+// run executes the session's command and returns its exit status
+func (sh *Shell) run(req ShellRequest, in *bufio.Reader, out *shellFrameWriter) (int, error) {
+	cmd := exec.Command(sh.command)
+	if req.Command != "" {
+		cmd = exec.Command(sh.command, "-c", req.Command)
+	}
+	cmd.Env = os.Environ()
+
+	var (
+		stdin   io.WriteCloser
+		resize  func(WindowSize)
+		outputs sync.WaitGroup
+	)
+	if req.PTY {
+		if req.Term != "" {
+			cmd.Env = append(cmd.Env, "TERM="+req.Term)
+		}
+		ptmx, err := startWithPTY(cmd, WindowSize{Rows: req.Rows, Cols: req.Cols})
+		if err != nil {
+			return shellExitFailed, fmt.Errorf("failed to start command with pty: %w", err)
+		}
+		defer ptmx.Close()
+
+		stdin = ptmx
+		resize = func(size WindowSize) {
+			if err := setWindowSize(ptmx, size); err != nil {
+				logrus.WithError(err).Debug("Failed to resize pty")
+			}
+		}
+		outputs.Add(1)
+		go func() {
+			defer outputs.Done()
+			out.copyFrom(shellFrameStdout, ptmx)
+		}()
+	} else {
+		var err error
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return shellExitFailed, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return shellExitFailed, err
+		}
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return shellExitFailed, err
+		}
+		if err := cmd.Start(); err != nil {
+			return shellExitFailed, fmt.Errorf("failed to start command: %w", err)
+		}
+		outputs.Add(2)
+		go func() {
+			defer outputs.Done()
+			out.copyFrom(shellFrameStdout, stdout)
+		}()
+		go func() {
+			defer outputs.Done()
+			out.copyFrom(shellFrameStderr, stderr)
+		}()
+	}
+
+	// Feed client input to the command; a client that goes away ends the session
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			typ, payload, err := readShellFrame(in)
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					cmd.Process.Kill()
+				}
+				return
+			}
+			switch typ {
+			case shellFrameStdin:
+				stdin.Write(payload)
+			case shellFrameStdinEOF:
+				if req.PTY {
+					stdin.Write([]byte{4}) // ^D at the start of a line
+				} else {
+					stdin.Close()
+				}
+			case shellFrameResize:
+				if resize != nil && len(payload) == 4 {
+					resize(WindowSize{
+						Rows: binary.BigEndian.Uint16(payload[0:2]),
+						Cols: binary.BigEndian.Uint16(payload[2:4]),
+					})
+				}
+			}
+		}
+	}()
+
+	outputs.Wait()
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return shellExitFailed, err
+	}
+	return 0, nil
+}
+
+// shellFrameWriter serializes frames written by several goroutines
+type shellFrameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *shellFrameWriter) write(typ byte, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var header [5]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := f.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := f.w.Write(payload)
+	return err
+}
+
+// copyFrom sends everything read from r as frames of one type until r ends
+func (f *shellFrameWriter) copyFrom(typ byte, r io.Reader) {
+	buf := make([]byte, maxShellFrameSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := f.write(typ, buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readShellFrame reads one frame
+func readShellFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxShellFrameSize {
+		return 0, nil, fmt.Errorf("shell frame too large: %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// RunRemoteShell runs a session on a peer that lists this host as a shell
+// peer, connecting it to sio, and returns the remote exit status
+func RunRemoteShell(ctx context.Context, h host.Host, p peer.ID, req ShellRequest, sio ShellIO) (int, error) {
+	s, err := h.NewStream(ctx, p, protocol.ID(ShellProtocol))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	// Interrupt blocked reads and writes when ctx is done
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode shell request: %w", err)
+	}
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return 0, fmt.Errorf("failed to send shell request: %w", err)
+	}
+
+	out := &shellFrameWriter{w: s}
+	if sio.Stdin != nil {
+		go func() {
+			out.copyFrom(shellFrameStdin, sio.Stdin)
+			out.write(shellFrameStdinEOF, nil)
+		}()
+	} else {
+		out.write(shellFrameStdinEOF, nil)
+	}
+	if sio.Resize != nil {
+		go func() {
+			for size := range sio.Resize {
+				var payload [4]byte
+				binary.BigEndian.PutUint16(payload[0:2], size.Rows)
+				binary.BigEndian.PutUint16(payload[2:4], size.Cols)
+				if out.write(shellFrameResize, payload[:]) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	reader := bufio.NewReader(s)
+	for {
+		typ, payload, err := readShellFrame(reader)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("shell session ended without exit status: %w", err)
+		}
+		switch typ {
+		case shellFrameStdout:
+			if sio.Stdout != nil {
+				sio.Stdout.Write(payload)
+			}
+		case shellFrameStderr:
+			if sio.Stderr != nil {
+				sio.Stderr.Write(payload)
+			}
+		case shellFrameExit:
+			if len(payload) != 4 {
+				return 0, fmt.Errorf("invalid exit status")
+			}
+			return int(int32(binary.BigEndian.Uint32(payload))), nil
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package libp2plearn
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShell(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	operator, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer operator.Close()
+
+	stranger, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer stranger.Close()
+
+	managed, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer managed.Close()
+
+	shell, err := NewShell(managed, []string{operator.ID().String()}, "/bin/sh")
+	require.NoError(t, err)
+	defer shell.Close()
+
+	require.NoError(t, connectNodes(ctx, operator, managed))
+	require.NoError(t, connectNodes(ctx, stranger, managed))
+
+	t.Run("Exec", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code, err := RunRemoteShell(ctx, operator, managed.ID(), ShellRequest{
+			Command: "echo hello; echo oops >&2; exit 3",
+		}, ShellIO{Stdout: &stdout, Stderr: &stderr})
+		require.NoError(t, err)
+		assert.Equal(t, 3, code)
+		assert.Equal(t, "hello\n", stdout.String())
+		assert.Equal(t, "oops\n", stderr.String())
+	})
+
+	t.Run("Stdin", func(t *testing.T) {
+		var stdout bytes.Buffer
+		code, err := RunRemoteShell(ctx, operator, managed.ID(), ShellRequest{Command: "tr a-z A-Z"},
+			ShellIO{Stdin: strings.NewReader("shout\n"), Stdout: &stdout})
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "SHOUT\n", stdout.String())
+	})
+
+	t.Run("PTY", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("pty sessions are only supported on linux")
+		}
+
+		var stdout bytes.Buffer
+		code, err := RunRemoteShell(ctx, operator, managed.ID(), ShellRequest{
+			Command: "tty; stty size",
+			PTY:     true,
+			Rows:    40,
+			Cols:    100,
+		}, ShellIO{Stdout: &stdout})
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stdout.String(), "/dev/pts/")
+		assert.Contains(t, stdout.String(), "40 100")
+	})
+
+	t.Run("CommandNotFound", func(t *testing.T) {
+		var stderr bytes.Buffer
+		code, err := RunRemoteShell(ctx, operator, managed.ID(), ShellRequest{Command: "definitely-not-a-command"},
+			ShellIO{Stderr: &stderr})
+		require.NoError(t, err)
+		assert.Equal(t, 127, code)
+		assert.NotEmpty(t, stderr.String())
+	})
+
+	t.Run("RejectsUnauthorizedPeers", func(t *testing.T) {
+		_, err := RunRemoteShell(ctx, stranger, managed.ID(), ShellRequest{Command: "id"}, ShellIO{})
+		assert.Error(t, err)
+	})
+
+	t.Run("CancelKillsSession", func(t *testing.T) {
+		sessionCtx, stop := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer stop()
+
+		start := time.Now()
+		_, err := RunRemoteShell(sessionCtx, operator, managed.ID(), ShellRequest{Command: "sleep 30"}, ShellIO{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
+
+func TestShellConfig(t *testing.T) {
+	cfg := testNodeConfig()
+	assert.False(t, cfg.EnableShell, "the shell must be off by default")
+
+	cfg.EnableShell = true
+	assert.ErrorContains(t, cfg.Validate(), "shell_peers is required")
+}
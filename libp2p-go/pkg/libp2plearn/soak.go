@@ -0,0 +1,320 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SoakConfig describes a soak run: an in-process network of Nodes kept
+// alive for Duration, replacing one node every ChurnInterval while pairs
+// of nodes ping each other every RequestInterval
+type SoakConfig struct {
+	Nodes           int
+	Duration        time.Duration
+	ChurnInterval   time.Duration
+	RequestInterval time.Duration
+	SampleInterval  time.Duration
+	// RequestTimeout bounds a ping; one still running after twice as long
+	// is stuck
+	RequestTimeout time.Duration
+	// MaxGoroutineGrowth and MaxHeapGrowth are how far the goroutine count
+	// and live heap may end above where they started, as a fraction
+	MaxGoroutineGrowth float64
+	MaxHeapGrowth      float64
+	// NodeConfig returns the configuration of a new node, bootstrapping
+	// from the given peers. DefaultSoakNodeConfig is used if nil.
+	NodeConfig func(bootstrap []string) *Config
+}
+
+// DefaultSoakConfig returns a one hour soak of 20 nodes
+func DefaultSoakConfig() SoakConfig {
+	return SoakConfig{
+		Nodes:              20,
+		Duration:           time.Hour,
+		ChurnInterval:      10 * time.Second,
+		RequestInterval:    time.Second,
+		SampleInterval:     30 * time.Second,
+		RequestTimeout:     10 * time.Second,
+		MaxGoroutineGrowth: 0.25,
+		MaxHeapGrowth:      0.5,
+	}
+}
+
+// DefaultSoakNodeConfig is the configuration of a soak node: random ports,
+// TCP and QUIC only, bootstrapping from the given peers
+func DefaultSoakNodeConfig(bootstrap []string) *Config {
+	cfg := DefaultConfig()
+	cfg.ListenPort = 0
+	cfg.EnableWebSocket = false
+	cfg.BootstrapPeers = bootstrap
+	return cfg
+}
+
+// SoakSample is the state of the process at one point of a soak run
+type SoakSample struct {
+	Elapsed    Duration `json:"elapsed"`
+	Nodes      int      `json:"nodes"`
+	Goroutines int      `json:"goroutines"`
+	HeapBytes  uint64   `json:"heap_bytes"`
+	Streams    int      `json:"streams"`
+	Stuck      int      `json:"stuck"`
+}
+
+// SoakReport is the outcome of a soak run. Baseline is sampled once the
+// first nodes are up, Final after the run with as many nodes still up.
+type SoakReport struct {
+	Baseline SoakSample   `json:"baseline"`
+	Final    SoakSample   `json:"final"`
+	Samples  []SoakSample `json:"samples"`
+	Joins    int          `json:"joins"`
+	Leaves   int          `json:"leaves"`
+	Requests int          `json:"requests"`
+	Failures int          `json:"failures"`
+}
+
+// soak is a running soak test
+type soak struct {
+	cfg   SoakConfig
+	start time.Time
+
+	mu       sync.Mutex
+	nodes    []*Node
+	inflight map[int]time.Time
+	nextID   int
+	report   SoakReport
+}
+
+// RunSoak runs a soak test and reports what it saw. The error says why the
+// run failed: stuck requests, or goroutines or heap that grew too much.
+// onSample, if not nil, is called with every sample.
+func RunSoak(ctx context.Context, cfg SoakConfig, onSample func(SoakSample)) (*SoakReport, error) {
+	if cfg.Nodes < 2 {
+		return nil, fmt.Errorf("a soak needs at least 2 nodes")
+	}
+	if cfg.NodeConfig == nil {
+		cfg.NodeConfig = DefaultSoakNodeConfig
+	}
+	s := &soak{cfg: cfg, inflight: make(map[int]time.Time)}
+	defer s.stopAll()
+
+	for i := 0; i < cfg.Nodes; i++ {
+		if err := s.join(ctx); err != nil {
+			return nil, err
+		}
+	}
+	s.report.Joins = 0
+	s.start = time.Now()
+	s.report.Baseline = s.sample()
+	logrus.WithField("nodes", cfg.Nodes).Info("Soak network up")
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	churn := time.NewTicker(cfg.ChurnInterval)
+	defer churn.Stop()
+	requests := time.NewTicker(cfg.RequestInterval)
+	defer requests.Stop()
+	samples := time.NewTicker(cfg.SampleInterval)
+	defer samples.Stop()
+
+loop:
+	for {
+		select {
+		case <-runCtx.Done():
+			break loop
+		case <-churn.C:
+			s.leave(ctx)
+			if err := s.join(ctx); err != nil {
+				logrus.WithError(err).Warn("Soak node failed to join")
+			}
+		case <-requests.C:
+			for i := 0; i < cfg.Nodes/2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.request(runCtx)
+				}()
+			}
+		case <-samples.C:
+			sample := s.sample()
+			s.mu.Lock()
+			s.report.Samples = append(s.report.Samples, sample)
+			s.mu.Unlock()
+			if onSample != nil {
+				onSample(sample)
+			}
+		}
+	}
+	wg.Wait()
+
+	// Replace any node that failed to join, so the final sample compares
+	// with the baseline
+	for s.count() < cfg.Nodes {
+		if err := s.join(ctx); err != nil {
+			return &s.report, err
+		}
+	}
+	s.report.Final = s.sample()
+	return &s.report, s.check()
+}
+
+// check compares the final sample with the baseline
+func (s *soak) check() error {
+	baseline, final := s.report.Baseline, s.report.Final
+	for _, sample := range s.report.Samples {
+		if sample.Stuck > 0 {
+			return fmt.Errorf("%d requests stuck after %s", sample.Stuck, time.Duration(sample.Elapsed))
+		}
+	}
+	if limit := float64(baseline.Goroutines) * (1 + s.cfg.MaxGoroutineGrowth); float64(final.Goroutines) > limit {
+		return fmt.Errorf("goroutines grew from %d to %d", baseline.Goroutines, final.Goroutines)
+	}
+	if limit := float64(baseline.HeapBytes) * (1 + s.cfg.MaxHeapGrowth); float64(final.HeapBytes) > limit {
+		return fmt.Errorf("heap grew from %d to %d bytes", baseline.HeapBytes, final.HeapBytes)
+	}
+	return nil
+}
+
+// join starts a node that bootstraps from up to three running nodes
+func (s *soak) join(ctx context.Context) error {
+	s.mu.Lock()
+	var bootstrap []string
+	for _, i := range rand.Perm(len(s.nodes)) {
+		if len(bootstrap) == 3 {
+			break
+		}
+		h := s.nodes[i].Host()
+		if len(h.Addrs()) == 0 {
+			continue
+		}
+		bootstrap = append(bootstrap, fmt.Sprintf("%s/p2p/%s", h.Addrs()[0], h.ID()))
+	}
+	s.mu.Unlock()
+
+	node, err := New(WithConfig(s.cfg.NodeConfig(bootstrap)))
+	if err != nil {
+		return fmt.Errorf("failed to create soak node: %w", err)
+	}
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		return fmt.Errorf("failed to start soak node: %w", err)
+	}
+
+	s.mu.Lock()
+	s.nodes = append(s.nodes, node)
+	s.report.Joins++
+	s.mu.Unlock()
+	return nil
+}
+
+// leave stops a random node
+func (s *soak) leave(ctx context.Context) {
+	s.mu.Lock()
+	if len(s.nodes) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	i := rand.Intn(len(s.nodes))
+	node := s.nodes[i]
+	s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+	s.report.Leaves++
+	s.mu.Unlock()
+
+	stopCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := node.Stop(stopCtx); err != nil {
+		logrus.WithError(err).Warn("Soak node failed to stop cleanly")
+	}
+}
+
+// request pings one random node from another, tracking it until it returns
+func (s *soak) request(ctx context.Context) {
+	s.mu.Lock()
+	if len(s.nodes) < 2 {
+		s.mu.Unlock()
+		return
+	}
+	pair := rand.Perm(len(s.nodes))[:2]
+	from, to := s.nodes[pair[0]], s.nodes[pair[1]]
+	id := s.nextID
+	s.nextID++
+	s.inflight[id] = time.Now()
+	s.report.Requests++
+	s.mu.Unlock()
+
+	reqCtx, cancel := context.WithTimeout(ctx, s.cfg.RequestTimeout)
+	defer cancel()
+	info := to.Host().Peerstore().PeerInfo(to.Host().ID())
+	info.Addrs = to.Host().Addrs()
+	err := from.Host().Connect(reqCtx, info)
+	if err == nil {
+		_, err = from.Protocols().SendPing(reqCtx, to.Host().ID(), "soak")
+	}
+
+	s.mu.Lock()
+	delete(s.inflight, id)
+	if err != nil && ctx.Err() == nil {
+		s.report.Failures++
+	}
+	s.mu.Unlock()
+}
+
+// sample measures the process after a garbage collection
+func (s *soak) sample() SoakSample {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sample := SoakSample{
+		Elapsed:    Duration(time.Since(s.start).Round(time.Second)),
+		Nodes:      len(s.nodes),
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  mem.HeapAlloc,
+	}
+	for _, node := range s.nodes {
+		for _, conn := range node.Host().Network().Conns() {
+			sample.Streams += len(conn.GetStreams())
+		}
+	}
+	for _, started := range s.inflight {
+		if time.Since(started) > 2*s.cfg.RequestTimeout {
+			sample.Stuck++
+		}
+	}
+	return sample
+}
+
+// count returns the number of running nodes
+func (s *soak) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.nodes)
+}
+
+// stopAll stops every node still running
+func (s *soak) stopAll() {
+	s.mu.Lock()
+	nodes := s.nodes
+	s.nodes = nil
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			node.Stop(ctx)
+		}(node)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,68 @@
+package libp2plearn
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSoakChurn runs a short soak of a 20 node network. Set
+// LIBP2P_LEARN_SOAK to a duration such as 2h for a real soak.
+func TestSoakChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping soak in short mode")
+	}
+
+	cfg := DefaultSoakConfig()
+	cfg.Duration = 15 * time.Second
+	cfg.ChurnInterval = time.Second
+	cfg.SampleInterval = 5 * time.Second
+	// Short runs haven't settled, so allow more growth
+	cfg.MaxGoroutineGrowth = 0.5
+	cfg.MaxHeapGrowth = 1
+	if soak := os.Getenv("LIBP2P_LEARN_SOAK"); soak != "" {
+		duration, err := time.ParseDuration(soak)
+		require.NoError(t, err)
+		defaults := DefaultSoakConfig()
+		cfg.Duration = duration
+		cfg.ChurnInterval = defaults.ChurnInterval
+		cfg.SampleInterval = defaults.SampleInterval
+		cfg.MaxGoroutineGrowth = defaults.MaxGoroutineGrowth
+		cfg.MaxHeapGrowth = defaults.MaxHeapGrowth
+	}
+
+	report, err := RunSoak(context.Background(), cfg, func(sample SoakSample) {
+		t.Logf("%s: %d nodes, %d goroutines, %d heap bytes, %d streams, %d stuck",
+			time.Duration(sample.Elapsed), sample.Nodes, sample.Goroutines, sample.HeapBytes, sample.Streams, sample.Stuck)
+	})
+	require.NoError(t, err)
+
+	assert.Greater(t, report.Leaves, 0)
+	assert.Equal(t, report.Leaves, report.Joins)
+	assert.Equal(t, cfg.Nodes, report.Final.Nodes)
+	assert.Greater(t, report.Requests, 0)
+	// Requests to a node that is leaving fail, but most must succeed
+	assert.Less(t, report.Failures, report.Requests/2)
+}
+
+func TestSoakCheck(t *testing.T) {
+	s := &soak{cfg: DefaultSoakConfig()}
+	s.report.Baseline = SoakSample{Goroutines: 100, HeapBytes: 1000}
+
+	s.report.Final = SoakSample{Goroutines: 120, HeapBytes: 1400}
+	assert.NoError(t, s.check())
+
+	s.report.Final = SoakSample{Goroutines: 200, HeapBytes: 1000}
+	assert.ErrorContains(t, s.check(), "goroutines grew")
+
+	s.report.Final = SoakSample{Goroutines: 100, HeapBytes: 2000}
+	assert.ErrorContains(t, s.check(), "heap grew")
+
+	s.report.Final = s.report.Baseline
+	s.report.Samples = []SoakSample{{Stuck: 2}}
+	assert.ErrorContains(t, s.check(), "2 requests stuck")
+}
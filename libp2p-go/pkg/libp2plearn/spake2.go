@@ -0,0 +1,162 @@
+package libp2plearn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// spake2Prime is the 2048-bit MODP group of RFC 3526. It is a safe prime, so
+// the squares modulo it form a subgroup of prime order (p-1)/2, which its
+// generator 2 is in.
+const spake2Prime = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1" +
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DD" +
+	"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245" +
+	"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D" +
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F" +
+	"83655D23DCA3AD961C62F356208552BB9ED529077096966D" +
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9" +
+	"DE2BCBF6955817183995497CEA956AE515D2261898FA0510" +
+	"15728E5A8AACAA68FFFFFFFFFFFFFFFF"
+
+// spake2MessageSize is the size of a SPAKE2 message, an element of the group
+const spake2MessageSize = 256
+
+var (
+	spake2P, _ = new(big.Int).SetString(spake2Prime, 16)
+	spake2Q    = new(big.Int).Rsh(spake2P, 1)
+	spake2G    = big.NewInt(2)
+	spake2M    = spake2Element("libp2p-learn SPAKE2 M")
+	spake2N    = spake2Element("libp2p-learn SPAKE2 N")
+)
+
+// errSPAKE2Confirm is returned when the other side's key confirmation doesn't
+// match, which means it used another PIN
+var errSPAKE2Confirm = errors.New("key confirmation failed")
+
+// spake2Element hashes a label to an element of the subgroup nobody knows
+// the discrete logarithm of, by squaring a hash that is longer than the prime
+func spake2Element(label string) *big.Int {
+	var digest []byte
+	for i := byte(0); len(digest) < spake2MessageSize+32; i++ {
+		sum := sha256.Sum256(append([]byte(label), i))
+		digest = append(digest, sum[:]...)
+	}
+	e := new(big.Int).SetBytes(digest)
+	e.Mod(e, spake2P)
+	return e.Exp(e, big.NewInt(2), spake2P)
+}
+
+// spake2 is one side of a SPAKE2 exchange (RFC 9382) keyed by a PIN. Side A
+// blinds its share with M and side B with N; A and B are the peer IDs of the
+// connection it runs over, so a share can't be relayed to another peer.
+type spake2 struct {
+	a, b   peer.ID
+	isA    bool
+	w      *big.Int
+	x      *big.Int
+	share  []byte
+	kcA    []byte
+	kcB    []byte
+	digest []byte
+}
+
+// newSPAKE2 starts side A, or B, of an exchange between peers a and b
+func newSPAKE2(pin string, a, b peer.ID, isA bool) (*spake2, error) {
+	x, err := rand.Int(rand.Reader, spake2Q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SPAKE2 secret: %w", err)
+	}
+	w := sha256.Sum256([]byte("libp2p-learn pairing PIN\x00" + pin))
+	s := &spake2{a: a, b: b, isA: isA, x: x, w: new(big.Int).Mod(new(big.Int).SetBytes(w[:]), spake2Q)}
+
+	blind := spake2N
+	if isA {
+		blind = spake2M
+	}
+	share := new(big.Int).Exp(spake2G, x, spake2P)
+	share.Mul(share, new(big.Int).Exp(blind, s.w, spake2P)).Mod(share, spake2P)
+	s.share = share.FillBytes(make([]byte, spake2MessageSize))
+	return s, nil
+}
+
+// Share returns the message to send to the other side
+func (s *spake2) Share() []byte {
+	return s.share
+}
+
+// Finish takes the other side's share and derives the keys. It fails for
+// shares that aren't in the group.
+func (s *spake2) Finish(other []byte) error {
+	if len(other) != spake2MessageSize {
+		return fmt.Errorf("invalid SPAKE2 share size %d", len(other))
+	}
+	y := new(big.Int).SetBytes(other)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(spake2P) >= 0 ||
+		new(big.Int).Exp(y, spake2Q, spake2P).Cmp(big.NewInt(1)) != 0 {
+		return fmt.Errorf("invalid SPAKE2 share")
+	}
+
+	// Unblind the share with the other side's constant and raise it to our
+	// secret: both sides get g^(xy)
+	blind := spake2M
+	shareA, shareB := other, s.share
+	if s.isA {
+		blind = spake2N
+		shareA, shareB = s.share, other
+	}
+	unblind := new(big.Int).Exp(blind, s.w, spake2P)
+	unblind.ModInverse(unblind, spake2P)
+	k := y.Mul(y, unblind).Mod(y, spake2P)
+	k.Exp(k, s.x, spake2P)
+
+	var transcript []byte
+	for _, field := range [][]byte{
+		[]byte(s.a), []byte(s.b), shareA, shareB,
+		k.FillBytes(make([]byte, spake2MessageSize)),
+		s.w.FillBytes(make([]byte, spake2MessageSize)),
+	} {
+		transcript = binary.LittleEndian.AppendUint64(transcript, uint64(len(field)))
+		transcript = append(transcript, field...)
+	}
+	digest := sha256.Sum256(transcript)
+	s.digest = digest[:]
+	s.kcA = spake2MAC(digest[16:], "ConfirmationKey A")
+	s.kcB = spake2MAC(digest[16:], "ConfirmationKey B")
+	return nil
+}
+
+// Confirm returns our key confirmation, which proves we know the PIN
+func (s *spake2) Confirm() []byte {
+	if s.isA {
+		return spake2MAC(s.kcA, string(s.digest))
+	}
+	return spake2MAC(s.kcB, string(s.digest))
+}
+
+// Verify checks the other side's key confirmation
+func (s *spake2) Verify(confirm []byte) error {
+	key := s.kcA
+	if s.isA {
+		key = s.kcB
+	}
+	if !hmac.Equal(confirm, spake2MAC(key, string(s.digest))) {
+		return errSPAKE2Confirm
+	}
+	return nil
+}
+
+// spake2MAC returns the HMAC-SHA256 of msg under key
+func spake2MAC(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,108 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// streamKey identifies the inbound streams of one peer on one protocol
+type streamKey struct {
+	peer  peer.ID
+	proto protocol.ID
+}
+
+// StreamLimit caps how many inbound streams a single peer may have open at
+// once on each protocol, so no peer can tie up an unbounded number of handler
+// goroutines. A stream counts until its handler returns. Streams over the
+// cap are reset and reported as rate-limit misbehavior.
+type StreamLimit struct {
+	host host.Host
+
+	mu        sync.Mutex
+	limit     int
+	protocols map[protocol.ID]int
+	active    map[streamKey]int
+}
+
+// NewStreamLimit creates a stream cap of limit streams per peer and protocol
+// (0 for no cap). protocolLimits maps protocol IDs to their own caps.
+func NewStreamLimit(h host.Host, limit int, protocolLimits map[string]int) *StreamLimit {
+	l := &StreamLimit{host: h, active: make(map[streamKey]int)}
+	l.SetLimits(limit, protocolLimits)
+	return l
+}
+
+// SetLimits changes the caps. Streams already open stay open even if they
+// exceed the new caps.
+func (l *StreamLimit) SetLimits(limit int, protocolLimits map[string]int) {
+	protocols := make(map[protocol.ID]int, len(protocolLimits))
+	for proto, n := range protocolLimits {
+		protocols[protocol.ID(proto)] = n
+	}
+
+	l.mu.Lock()
+	l.limit = limit
+	l.protocols = protocols
+	l.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"streams_per_peer": limit,
+		"protocols":        len(protocols),
+	}).Info("Stream limits set")
+}
+
+// Middleware resets inbound streams beyond the cap of their peer and protocol
+func (l *StreamLimit) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		key := streamKey{peer: s.Conn().RemotePeer(), proto: proto}
+		if err := l.acquire(key); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"peer":     key.peer,
+				"protocol": proto,
+			}).Warn("Rejected stream over the limit")
+			s.ResetWithError(network.StreamResourceLimitExceeded)
+			ReportMisbehavior(l.host, key.peer, MisbehaviorRateLimit, "too many streams")
+			return
+		}
+		defer l.release(key)
+		next(s)
+	}
+}
+
+// Active returns how many streams a peer has open on a protocol
+func (l *StreamLimit) Active(p peer.ID, proto protocol.ID) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active[streamKey{peer: p, proto: proto}]
+}
+
+// acquire counts a new stream unless it would exceed the cap
+func (l *StreamLimit) acquire(key streamKey) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.protocols[key.proto]
+	if !ok {
+		limit = l.limit
+	}
+	if limit > 0 && l.active[key] >= limit {
+		return fmt.Errorf("peer already has %d streams open", l.active[key])
+	}
+	l.active[key]++
+	return nil
+}
+
+// release uncounts a stream whose handler returned
+func (l *StreamLimit) release(key streamKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[key]--; l.active[key] <= 0 {
+		delete(l.active, key)
+	}
+}
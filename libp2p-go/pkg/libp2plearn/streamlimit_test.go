@@ -0,0 +1,115 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const (
+		holdProtocol  = protocol.ID("/libp2p-learn/test-hold/1.0.0")
+		smallProtocol = protocol.ID("/libp2p-learn/test-small/1.0.0")
+	)
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	limit := NewStreamLimit(server, 3, map[string]int{string(smallProtocol): 1})
+	handler := NewProtocolHandler(server)
+	handler.Use(limit.Middleware)
+
+	// Handlers hold their stream until it is closed by the client
+	hold := func(s network.Stream) {
+		defer s.Close()
+		io.Copy(io.Discard, s)
+	}
+	handler.Handle(holdProtocol, hold)
+	handler.Handle(smallProtocol, hold)
+
+	// open starts a stream and makes sure the server sees it
+	open := func(proto protocol.ID) network.Stream {
+		s, err := client.NewStream(ctx, server.ID(), proto)
+		require.NoError(t, err)
+		_, err = s.Write([]byte("x"))
+		require.NoError(t, err)
+		return s
+	}
+
+	// rejected reports whether the server reset the stream
+	rejected := func(s network.Stream) bool {
+		s.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := s.Read(make([]byte, 1))
+		var resetErr *network.StreamError
+		return errors.As(err, &resetErr) && resetErr.ErrorCode == network.StreamResourceLimitExceeded
+	}
+
+	waitActive := func(proto protocol.ID, n int) {
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			return limit.Active(client.ID(), proto) == n
+		}, 5*time.Second, 20*time.Millisecond))
+	}
+
+	t.Run("ResetsStreamsOverTheLimit", func(t *testing.T) {
+		var streams []network.Stream
+		for range 3 {
+			streams = append(streams, open(holdProtocol))
+		}
+		waitActive(holdProtocol, 3)
+
+		assert.True(t, rejected(open(holdProtocol)))
+
+		// Closing a stream makes room for another
+		streams[0].Close()
+		waitActive(holdProtocol, 2)
+		streams[0] = open(holdProtocol)
+		waitActive(holdProtocol, 3)
+
+		for _, s := range streams {
+			s.Close()
+		}
+		waitActive(holdProtocol, 0)
+	})
+
+	t.Run("ProtocolOverride", func(t *testing.T) {
+		s := open(smallProtocol)
+		waitActive(smallProtocol, 1)
+		assert.True(t, rejected(open(smallProtocol)))
+
+		// Other protocols keep their own count
+		other := open(holdProtocol)
+		waitActive(holdProtocol, 1)
+
+		s.Close()
+		other.Close()
+		waitActive(smallProtocol, 0)
+		waitActive(holdProtocol, 0)
+	})
+
+	t.Run("SetLimits", func(t *testing.T) {
+		limit.SetLimits(0, nil)
+		var streams []network.Stream
+		for range 5 {
+			streams = append(streams, open(smallProtocol))
+		}
+		waitActive(smallProtocol, 5)
+		for _, s := range streams {
+			s.Close()
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// StreamInfo describes an open stream
+type StreamInfo struct {
+	ID           string    `json:"id"`
+	Protocol     string    `json:"protocol"`
+	Direction    string    `json:"direction"`
+	Opened       time.Time `json:"opened"`
+	Age          Duration  `json:"age"`
+	BytesRead    int64     `json:"bytes_read"`
+	BytesWritten int64     `json:"bytes_written"`
+	// Counted is whether bytes are counted, which they are only for streams
+	// of the node's own protocols
+	Counted bool `json:"counted"`
+}
+
+// ConnStreams lists the open streams of one connection
+type ConnStreams struct {
+	ID        string       `json:"id"`
+	Peer      string       `json:"peer"`
+	Addr      string       `json:"addr"`
+	Transport string       `json:"transport"`
+	Streams   []StreamInfo `json:"streams"`
+}
+
+// streamCounter counts the bytes of one stream
+type streamCounter struct {
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+// StreamTracker counts the bytes of the streams the node's protocols accept
+// and open, and lists every open stream so stuck or leaked ones can be found
+type StreamTracker struct {
+	host host.Host
+
+	mu       sync.Mutex
+	counters map[string]*streamCounter // by stream ID
+}
+
+// NewStreamTracker creates a stream tracker for the host
+func NewStreamTracker(h host.Host) *StreamTracker {
+	return &StreamTracker{host: h, counters: make(map[string]*streamCounter)}
+}
+
+// Middleware counts the bytes of streams accepted by a protocol handler
+func (t *StreamTracker) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		next(t.Wrap(s))
+	}
+}
+
+// Opener counts the bytes of streams opened through the given opener
+func (t *StreamTracker) Opener(o StreamOpener) StreamOpener {
+	return &trackedOpener{tracker: t, next: o}
+}
+
+// Wrap returns the stream with its reads and writes counted
+func (t *StreamTracker) Wrap(s network.Stream) network.Stream {
+	c := &streamCounter{}
+	t.mu.Lock()
+	t.counters[s.ID()] = c
+	t.mu.Unlock()
+	return &trackedStream{Stream: s, tracker: t, counter: c}
+}
+
+// forget stops counting a stream
+func (t *StreamTracker) forget(id string) {
+	t.mu.Lock()
+	delete(t.counters, id)
+	t.mu.Unlock()
+}
+
+// Streams lists the open streams of each connection, to one peer or to all
+// peers if p is empty, oldest connection first
+func (t *StreamTracker) Streams(p peer.ID) []ConnStreams {
+	conns := t.host.Network().Conns()
+	if p != "" {
+		conns = t.host.Network().ConnsToPeer(p)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	open := make(map[string]bool)
+	var result []ConnStreams
+	for _, c := range conns {
+		info := ConnStreams{
+			ID:        c.ID(),
+			Peer:      c.RemotePeer().String(),
+			Addr:      c.RemoteMultiaddr().String(),
+			Transport: transportName(c.RemoteMultiaddr()),
+			Streams:   []StreamInfo{},
+		}
+		for _, s := range c.GetStreams() {
+			stat := s.Stat()
+			si := StreamInfo{
+				ID:        s.ID(),
+				Protocol:  string(s.Protocol()),
+				Direction: strings.ToLower(stat.Direction.String()),
+				Opened:    stat.Opened,
+				Age:       Duration(time.Since(stat.Opened).Round(time.Millisecond)),
+			}
+			if counter, ok := t.counters[s.ID()]; ok {
+				si.Counted = true
+				si.BytesRead = counter.read.Load()
+				si.BytesWritten = counter.written.Load()
+			}
+			open[s.ID()] = true
+			info.Streams = append(info.Streams, si)
+		}
+		sort.Slice(info.Streams, func(i, j int) bool { return info.Streams[i].Opened.Before(info.Streams[j].Opened) })
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	// Drop the counters of streams that went away without being closed
+	// through their wrapper, such as when their connection died
+	if p == "" {
+		for id := range t.counters {
+			if !open[id] {
+				delete(t.counters, id)
+			}
+		}
+	}
+	return result
+}
+
+// Reset resets an open stream by its ID
+func (t *StreamTracker) Reset(id string) error {
+	for _, c := range t.host.Network().Conns() {
+		for _, s := range c.GetStreams() {
+			if s.ID() != id {
+				continue
+			}
+			if err := s.Reset(); err != nil {
+				return fmt.Errorf("failed to reset stream %s: %w", id, err)
+			}
+			t.forget(id)
+			logrus.WithFields(logrus.Fields{
+				"stream":   id,
+				"peer":     c.RemotePeer(),
+				"protocol": s.Protocol(),
+			}).Info("Reset stream")
+			return nil
+		}
+	}
+	return fmt.Errorf("no open stream %s", id)
+}
+
+// trackedStream counts the bytes read from and written to a stream
+type trackedStream struct {
+	network.Stream
+	tracker *StreamTracker
+	counter *streamCounter
+}
+
+func (s *trackedStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.counter.read.Add(int64(n))
+	return n, err
+}
+
+func (s *trackedStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.counter.written.Add(int64(n))
+	return n, err
+}
+
+func (s *trackedStream) Close() error {
+	s.tracker.forget(s.ID())
+	return s.Stream.Close()
+}
+
+func (s *trackedStream) Reset() error {
+	s.tracker.forget(s.ID())
+	return s.Stream.Reset()
+}
+
+// trackedOpener counts the bytes of outgoing streams
+type trackedOpener struct {
+	tracker *StreamTracker
+	next    StreamOpener
+}
+
+func (o *trackedOpener) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := o.next.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return o.tracker.Wrap(s), nil
+}
+
+// Streams lists the open streams of each connection, to one peer or to all
+// peers if p is empty
+func (n *Node) Streams(p peer.ID) []ConnStreams {
+	return n.streams.Streams(p)
+}
+
+// ResetStream resets an open stream by the ID Streams reports
+func (n *Node) ResetStream(id string) error {
+	return n.streams.Reset(id)
+}
@@ -0,0 +1,101 @@
+package libp2plearn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamTracker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// A handler that reads and never answers, like a chat session left open
+	const stuckProtocol = protocol.ID("/libp2p-learn/test/stuck/1.0.0")
+	serverStreams := NewStreamTracker(server)
+	handler := NewProtocolHandler(server)
+	handler.Use(serverStreams.Middleware)
+	done := make(chan error, 1)
+	require.NoError(t, handler.Register(stuckProtocol, func(s network.Stream) {
+		_, err := io.ReadAll(s)
+		done <- err
+	}))
+
+	require.NoError(t, connectNodes(ctx, client, server))
+
+	clientStreams := NewStreamTracker(client)
+	s, err := clientStreams.Opener(client).NewStream(ctx, server.ID(), stuckProtocol)
+	require.NoError(t, err)
+	defer s.Reset()
+	_, err = s.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	// stuck finds the stream among the connections to a peer
+	stuck := func(conns []ConnStreams) (StreamInfo, bool) {
+		for _, c := range conns {
+			for _, si := range c.Streams {
+				if si.Protocol == string(stuckProtocol) {
+					return si, true
+				}
+			}
+		}
+		return StreamInfo{}, false
+	}
+
+	t.Run("Inbound", func(t *testing.T) {
+		require.NoError(t, WaitWithCondition(ctx, func() bool {
+			si, ok := stuck(serverStreams.Streams(client.ID()))
+			return ok && si.BytesRead == 5
+		}, 10*time.Second, 50*time.Millisecond))
+
+		conns := serverStreams.Streams(client.ID())
+		require.Len(t, conns, 1)
+		assert.Equal(t, client.ID().String(), conns[0].Peer)
+		assert.Equal(t, "tcp", conns[0].Transport)
+
+		si, _ := stuck(conns)
+		assert.Equal(t, "inbound", si.Direction)
+		assert.True(t, si.Counted)
+		assert.Zero(t, si.BytesWritten)
+		assert.False(t, si.Opened.IsZero())
+	})
+
+	t.Run("Outbound", func(t *testing.T) {
+		si, ok := stuck(clientStreams.Streams(""))
+		require.True(t, ok)
+		assert.Equal(t, "outbound", si.Direction)
+		assert.True(t, si.Counted)
+		assert.Equal(t, int64(5), si.BytesWritten)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		si, ok := stuck(serverStreams.Streams(""))
+		require.True(t, ok)
+		require.NoError(t, serverStreams.Reset(si.ID))
+
+		select {
+		case err := <-done:
+			assert.Error(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the handler to return")
+		}
+		_, ok = stuck(serverStreams.Streams(""))
+		assert.False(t, ok)
+
+		assert.ErrorContains(t, serverStreams.Reset(si.ID), "no open stream")
+	})
+}
@@ -0,0 +1,357 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ClusterConfig describes the nodes a supervisor runs in one process, and
+// the operator endpoints and metrics they share
+type ClusterConfig struct {
+	// Combined operator endpoints: /healthz, /readyz and /nodes
+	AdminHTTPAddr string `json:"admin_http_addr"`
+
+	// Metrics of every node, exported once
+	MetricsExporters []string          `json:"metrics_exporters"`
+	MetricsAddr      string            `json:"metrics_addr"`
+	MetricsInterval  Duration          `json:"metrics_interval"`
+	StatsDAddr       string            `json:"statsd_addr"`
+	OTLPEndpoint     string            `json:"otlp_endpoint"`
+	OTLPHeaders      map[string]string `json:"otlp_headers"`
+
+	Nodes []ClusterNode `json:"nodes"`
+}
+
+// ClusterNode is one node of a cluster. Its configuration is read from
+// config_file, relative to the cluster file, with the inline config applied
+// on top; either may be left out.
+type ClusterNode struct {
+	Name       string          `json:"name"`
+	ConfigFile string          `json:"config_file,omitempty"`
+	Inline     json.RawMessage `json:"config,omitempty"`
+
+	Config *Config `json:"-"`
+}
+
+// LoadClusterConfig loads a cluster file and the configuration of its nodes
+func LoadClusterConfig(path string) (*ClusterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster config: %w", err)
+	}
+	defaults := DefaultConfig()
+	cluster := &ClusterConfig{
+		MetricsAddr:     defaults.MetricsAddr,
+		MetricsInterval: defaults.MetricsInterval,
+		StatsDAddr:      defaults.StatsDAddr,
+		OTLPEndpoint:    defaults.OTLPEndpoint,
+	}
+	if err := json.Unmarshal(data, cluster); err != nil {
+		return nil, fmt.Errorf("failed to decode cluster config: %w", err)
+	}
+
+	for i := range cluster.Nodes {
+		node := &cluster.Nodes[i]
+		node.Config = DefaultConfig()
+		if node.ConfigFile != "" {
+			file := node.ConfigFile
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(path), file)
+			}
+			if node.Config, err = LoadConfig(file); err != nil {
+				return nil, fmt.Errorf("node %s: %w", node.Name, err)
+			}
+		}
+		if len(node.Inline) > 0 {
+			if err := json.Unmarshal(node.Inline, node.Config); err != nil {
+				return nil, fmt.Errorf("node %s: failed to decode config: %w", node.Name, err)
+			}
+		}
+	}
+	return cluster, nil
+}
+
+// metricsConfig returns the shared metrics settings as a node configuration
+func (c *ClusterConfig) metricsConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.MetricsExporters = c.MetricsExporters
+	cfg.MetricsAddr = c.MetricsAddr
+	cfg.MetricsInterval = c.MetricsInterval
+	cfg.StatsDAddr = c.StatsDAddr
+	cfg.OTLPEndpoint = c.OTLPEndpoint
+	cfg.OTLPHeaders = c.OTLPHeaders
+	return cfg
+}
+
+// Validate checks the cluster and every node configuration. Nodes must have
+// distinct names, identities and fixed ports, and leave metrics and operator
+// endpoints to the cluster.
+func (c *ClusterConfig) Validate() error {
+	if len(c.Nodes) == 0 {
+		return fmt.Errorf("nodes must not be empty")
+	}
+	if err := c.metricsConfig().Validate(); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	identities := make(map[string]string)
+	ports := make(map[string]string)
+	for _, node := range c.Nodes {
+		if node.Name == "" || strings.Contains(node.Name, "/") {
+			return fmt.Errorf("node names must be non-empty and contain no /")
+		}
+		if names[node.Name] {
+			return fmt.Errorf("node name %q is used twice", node.Name)
+		}
+		names[node.Name] = true
+
+		cfg := node.Config
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("node %s: %w", node.Name, err)
+		}
+		if len(cfg.MetricsExporters) > 0 || cfg.AdminHTTPAddr != "" {
+			return fmt.Errorf("node %s: metrics_exporters and admin_http_addr must be set on the cluster", node.Name)
+		}
+		if setting := differingProcessSetting(c.Nodes[0].Config, cfg); setting != "" {
+			return fmt.Errorf("nodes %s and %s must have the same %s", c.Nodes[0].Name, node.Name, setting)
+		}
+
+		if cfg.IdentityFile != "" {
+			id, err := filepath.Abs(cfg.IdentityFile)
+			if err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+			if other, ok := identities[id]; ok {
+				return fmt.Errorf("nodes %s and %s share identity_file %s", other, node.Name, cfg.IdentityFile)
+			}
+			identities[id] = node.Name
+		}
+
+		tp := cfg.TransportPorts()
+		for _, port := range []string{
+			fmt.Sprintf("tcp/%d", tp.TCP),
+			fmt.Sprintf("udp/%d", tp.QUIC),
+			fmt.Sprintf("tcp/%d", tp.WS),
+			fmt.Sprintf("tcp/%d", tp.WSS),
+		} {
+			if strings.HasSuffix(port, "/0") {
+				continue
+			}
+			if other, ok := ports[port]; ok && other != node.Name {
+				return fmt.Errorf("nodes %s and %s both listen on %s", other, node.Name, port)
+			}
+			ports[port] = node.Name
+		}
+	}
+	return nil
+}
+
+// differingProcessSetting names a setting the nodes of a cluster must share
+// but a and b don't, or returns "". Connect timeouts and proxies must agree
+// so no node of a proxied cluster dials around the proxy.
+func differingProcessSetting(a, b *Config) string {
+	switch {
+	case a.ConnectTimeout != b.ConnectTimeout:
+		return "connect_timeout"
+	case a.ProxyAddr != b.ProxyAddr, a.ProxyUsername != b.ProxyUsername, a.ProxyPassword != b.ProxyPassword,
+		a.ProxyTCP != b.ProxyTCP, a.ProxyWebSocket != b.ProxyWebSocket, a.ProxyStrict != b.ProxyStrict:
+		return "proxy settings"
+	}
+	return ""
+}
+
+// ClusterNodeStatus describes a node in the /nodes listing
+type ClusterNodeStatus struct {
+	Name   string   `json:"name"`
+	PeerID string   `json:"peer_id"`
+	Addrs  []string `json:"addrs"`
+	Peers  int      `json:"peers"`
+	Status string   `json:"status"`
+}
+
+// Supervisor runs several nodes in one process, for example a relay, a DHT
+// server and an application node on one machine. The nodes share the
+// process' metrics registry, exported once, and one set of operator
+// endpoints covering all of them.
+type Supervisor struct {
+	cfg     *ClusterConfig
+	nodes   []*Node
+	byName  map[string]*Node
+	metrics *Metrics
+	admin   *AdminHTTP
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewSupervisor creates the nodes of a cluster without starting them
+func NewSupervisor(cfg *ClusterConfig) (*Supervisor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cluster configuration: %w", err)
+	}
+	s := &Supervisor{cfg: cfg, byName: make(map[string]*Node)}
+	for _, cn := range cfg.Nodes {
+		node, err := New(WithConfig(cn.Config))
+		if err != nil {
+			s.closeNodes(context.Background())
+			return nil, fmt.Errorf("failed to create node %s: %w", cn.Name, err)
+		}
+		s.nodes = append(s.nodes, node)
+		s.byName[cn.Name] = node
+	}
+	return s, nil
+}
+
+// Node returns a node by name, or nil
+func (s *Supervisor) Node(name string) *Node {
+	return s.byName[name]
+}
+
+// Nodes returns the nodes in cluster order
+func (s *Supervisor) Nodes() []*Node {
+	return s.nodes
+}
+
+// Start starts the nodes in cluster order, then the shared metrics and
+// operator endpoints. If a node fails to start, every node is stopped.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, node := range s.nodes {
+		if err := node.Start(ctx); err != nil {
+			s.closeNodes(ctx)
+			return fmt.Errorf("failed to start node %s: %w", s.cfg.Nodes[i].Name, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"node":    s.cfg.Nodes[i].Name,
+			"peer_id": node.Host().ID(),
+		}).Info("Cluster node started")
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if len(s.cfg.MetricsExporters) > 0 {
+		metrics, err := NewMetrics(s.cfg.metricsConfig(), prometheus.DefaultGatherer, "cluster")
+		if err != nil {
+			cancel()
+			s.closeNodes(ctx)
+			return err
+		}
+		s.metrics = metrics
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			metrics.Run(runCtx)
+		}()
+	}
+	if s.cfg.AdminHTTPAddr != "" {
+		s.admin = NewAdminHTTP(s.cfg.AdminHTTPAddr, s.readiness)
+		s.admin.Handle("/nodes", http.HandlerFunc(s.handleNodes))
+		s.admin.Handle("/nodes/", http.HandlerFunc(s.handleNodeHealth))
+		s.admin.Start()
+	}
+	return nil
+}
+
+// Stop stops the operator endpoints and metrics, then the nodes in reverse
+// order, so an application node leaves before the relay it depends on
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	if s.admin != nil {
+		if err := s.admin.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop admin HTTP endpoints: %w", err))
+		}
+		s.admin = nil
+	}
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+		s.cancel = nil
+	}
+	if s.metrics != nil {
+		if err := s.metrics.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop metrics: %w", err))
+		}
+		s.metrics = nil
+	}
+	errs = append(errs, s.closeNodes(ctx))
+	return errors.Join(errs...)
+}
+
+// closeNodes stops every node in reverse order. Stopping a node that never
+// started releases its host and services.
+func (s *Supervisor) closeNodes(ctx context.Context) error {
+	var errs []error
+	for i := len(s.nodes) - 1; i >= 0; i-- {
+		if err := s.nodes[i].Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop node %s: %w", s.cfg.Nodes[i].Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readiness is ready when every node is, naming checks after their node
+func (s *Supervisor) readiness() []ProbeResult {
+	var results []ProbeResult
+	for i, node := range s.nodes {
+		for _, result := range node.readiness() {
+			result.Name = s.cfg.Nodes[i].Name + "/" + result.Name
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// handleNodes lists the nodes with their health status
+func (s *Supervisor) handleNodes(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]ClusterNodeStatus, 0, len(s.nodes))
+	for i, node := range s.nodes {
+		status := ClusterNodeStatus{
+			Name:   s.cfg.Nodes[i].Name,
+			PeerID: node.Host().ID().String(),
+			Peers:  len(node.Host().Network().Peers()),
+			Status: node.Health().Report(r.Context()).Status,
+		}
+		for _, addr := range node.Host().Addrs() {
+			status.Addrs = append(status.Addrs, addr.String())
+		}
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, statuses)
+}
+
+// handleNodeHealth serves the health report of one node at /nodes/<name>
+func (s *Supervisor) handleNodeHealth(w http.ResponseWriter, r *http.Request) {
+	node := s.Node(strings.TrimPrefix(r.URL.Path, "/nodes/"))
+	if node == nil {
+		http.Error(w, "no such node", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, node.Health().Report(r.Context()))
+}
+
+// writeJSON writes v as an indented JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logrus.WithError(err).Debug("Failed to write JSON response")
+	}
+}
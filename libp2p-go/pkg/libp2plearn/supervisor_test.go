@@ -0,0 +1,129 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterConfig(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "relay.json"), []byte(`{"listen_port": 4001}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cluster.json"), []byte(`{
+		"admin_http_addr": "127.0.0.1:0",
+		"nodes": [
+			{"name": "relay", "config_file": "relay.json", "config": {"enable_prewarm": true}},
+			{"name": "app", "config": {"listen_port": 4002}}
+		]
+	}`), 0644))
+
+	cluster, err := LoadClusterConfig(filepath.Join(dir, "cluster.json"))
+	require.NoError(t, err)
+	require.Len(t, cluster.Nodes, 2)
+	assert.Equal(t, 4001, cluster.Nodes[0].Config.ListenPort)
+	assert.True(t, cluster.Nodes[0].Config.EnablePrewarm)
+	assert.Equal(t, 4002, cluster.Nodes[1].Config.ListenPort)
+	assert.Equal(t, DefaultConfig().MetricsAddr, cluster.MetricsAddr)
+	require.NoError(t, cluster.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(c *ClusterConfig)
+	}{
+		{"NoNodes", func(c *ClusterConfig) { c.Nodes = nil }},
+		{"DuplicateName", func(c *ClusterConfig) { c.Nodes[1].Name = "relay" }},
+		{"SlashInName", func(c *ClusterConfig) { c.Nodes[1].Name = "a/b" }},
+		{"SharedPort", func(c *ClusterConfig) { c.Nodes[1].Config.ListenPort = 4001 }},
+		{"SharedIdentity", func(c *ClusterConfig) {
+			c.Nodes[0].Config.IdentityFile = "node.key"
+			c.Nodes[1].Config.IdentityFile = "./node.key"
+		}},
+		{"NodeMetrics", func(c *ClusterConfig) { c.Nodes[1].Config.MetricsExporters = []string{MetricsPrometheus} }},
+		{"NodeAdminHTTP", func(c *ClusterConfig) { c.Nodes[1].Config.AdminHTTPAddr = "127.0.0.1:0" }},
+		{"DifferentConnectTimeout", func(c *ClusterConfig) { c.Nodes[1].Config.ConnectTimeout = Duration(time.Minute) }},
+		{"DifferentProxy", func(c *ClusterConfig) { c.Nodes[1].Config.ProxyAddr = "127.0.0.1:9050" }},
+		{"BadExporter", func(c *ClusterConfig) { c.MetricsExporters = []string{"bogus"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadClusterConfig(filepath.Join(dir, "cluster.json"))
+			require.NoError(t, err)
+			tt.modify(c)
+			assert.Error(t, c.Validate())
+		})
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cluster := &ClusterConfig{AdminHTTPAddr: "127.0.0.1:0"}
+	for _, name := range []string{"relay", "app"} {
+		cfg := testNodeConfig()
+		cfg.ReadyMinPeers = 0
+		cfg.ReadyMinRoutingPeers = 0
+		cluster.Nodes = append(cluster.Nodes, ClusterNode{Name: name, Config: cfg})
+	}
+
+	s, err := NewSupervisor(cluster)
+	require.NoError(t, err)
+	require.NoError(t, s.Start(ctx))
+	defer s.Stop(ctx)
+
+	require.NotNil(t, s.Node("relay"))
+	assert.Nil(t, s.Node("bogus"))
+	assert.Len(t, s.Nodes(), 2)
+
+	var names []string
+	for _, result := range s.readiness() {
+		assert.NoError(t, result.Err, result.Name)
+		names = append(names, result.Name)
+	}
+	assert.Contains(t, names, "relay/dht")
+	assert.Contains(t, names, "app/peers")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.admin.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		rec := get("/readyz")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "[+]app/host ok")
+	})
+
+	t.Run("Nodes", func(t *testing.T) {
+		rec := get("/nodes")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var statuses []ClusterNodeStatus
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+		require.Len(t, statuses, 2)
+		assert.Equal(t, "relay", statuses[0].Name)
+		assert.Equal(t, s.Node("relay").Host().ID().String(), statuses[0].PeerID)
+		assert.NotEmpty(t, statuses[0].Addrs)
+	})
+
+	t.Run("NodeHealth", func(t *testing.T) {
+		rec := get("/nodes/app")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report HealthReport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Equal(t, s.Node("app").Host().ID().String(), report.PeerID)
+
+		assert.Equal(t, http.StatusNotFound, get("/nodes/bogus").Code)
+	})
+
+	require.NoError(t, s.Stop(ctx))
+	assert.Nil(t, s.admin)
+}
@@ -0,0 +1,101 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// systemd notification states
+const (
+	SdReady    = "READY=1"
+	SdStopping = "STOPPING=1"
+	SdWatchdog = "WATCHDOG=1"
+)
+
+// SdNotify sends a state such as SdReady to systemd. It reports false
+// without an error when systemd isn't listening, i.e. the service isn't of
+// Type=notify or the process wasn't started by systemd.
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// An abstract socket is written with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// SdWatchdogInterval returns how often systemd expects watchdog pings, or 0
+// if the watchdog isn't enabled for this process
+func SdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// systemd sets WATCHDOG_PID when the variable may reach other processes
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdWatchdog pings the systemd watchdog twice per interval while alive
+// reports no error, until ctx is done. A node that stops answering misses
+// its pings and systemd restarts it.
+func sdWatchdog(ctx context.Context, interval time.Duration, alive func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval/2)
+			err := alive(checkCtx)
+			cancel()
+			if err != nil {
+				logrus.WithError(err).Warn("Skipping systemd watchdog ping")
+				continue
+			}
+			if _, err := SdNotify(SdWatchdog); err != nil {
+				logrus.WithError(err).Warn("Failed to ping systemd watchdog")
+			}
+		}
+	}
+}
+
+// alive checks the node can still produce a health report within ctx and
+// no subsystem is down
+func (n *Node) alive(ctx context.Context) error {
+	done := make(chan HealthReport, 1)
+	go func() { done <- n.health.Report(ctx) }()
+	select {
+	case report := <-done:
+		for _, sub := range report.Subsystems {
+			if sub.Status == HealthDown {
+				return fmt.Errorf("%s is down: %s", sub.Name, sub.Message)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("health report timed out")
+	}
+}
@@ -0,0 +1,96 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listenNotify listens for systemd notifications the way systemd does
+func listenNotify(t *testing.T) *net.UnixConn {
+	dir, err := os.MkdirTemp("", "sd")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 256)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := SdNotify(SdReady)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	conn := listenNotify(t)
+	ok, err = SdNotify(SdReady)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, SdReady, readNotify(t, conn))
+
+	t.Run("watchdog interval", func(t *testing.T) {
+		t.Setenv("WATCHDOG_USEC", "")
+		assert.Zero(t, SdWatchdogInterval())
+
+		t.Setenv("WATCHDOG_USEC", "30000000")
+		t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+		assert.Equal(t, 30*time.Second, SdWatchdogInterval())
+
+		t.Setenv("WATCHDOG_PID", "1")
+		assert.Zero(t, SdWatchdogInterval())
+	})
+
+	t.Run("watchdog pings while alive", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		alive := make(chan error, 1)
+		alive <- errors.New("stuck")
+		go sdWatchdog(ctx, 100*time.Millisecond, func(ctx context.Context) error {
+			select {
+			case err := <-alive:
+				return err
+			default:
+				return nil
+			}
+		})
+		// The first check fails, so the first ping comes a tick later
+		start := time.Now()
+		assert.Equal(t, SdWatchdog, readNotify(t, conn))
+		assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	})
+}
+
+func TestNodeNotifiesSystemd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn := listenNotify(t)
+	node, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	require.NoError(t, node.Start(ctx))
+	assert.Equal(t, SdReady, readNotify(t, conn))
+
+	require.NoError(t, node.Stop(ctx))
+	assert.Equal(t, SdStopping, readNotify(t, conn))
+}
@@ -0,0 +1,90 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/sirupsen/logrus"
+)
+
+// tcpDialer dials TCP connections with the configured socket options. libp2p
+// enables keepalives after the dial with a 30 second idle time, which only
+// replaces the idle time, so the probe interval set here stays in effect.
+type tcpDialer struct {
+	dialer      net.Dialer
+	noDelay     bool
+	readBuffer  int
+	writeBuffer int
+}
+
+// newTCPDialer creates a dialer applying the TCP options in cfg
+func newTCPDialer(cfg *Config) *tcpDialer {
+	d := &tcpDialer{
+		noDelay:     cfg.TCPNoDelay,
+		readBuffer:  cfg.TCPReadBuffer,
+		writeBuffer: cfg.TCPWriteBuffer,
+	}
+	if cfg.TCPKeepAliveInterval > 0 {
+		d.dialer.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Interval: time.Duration(cfg.TCPKeepAliveInterval),
+		}
+	}
+	return d
+}
+
+// DialContext dials address and sets the socket options on the connection
+func (d *tcpDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if err := d.apply(tcpConn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set TCP socket options: %w", err)
+	}
+	return conn, nil
+}
+
+// apply sets Nagle's algorithm and the buffer sizes, leaving unset ones alone
+func (d *tcpDialer) apply(conn *net.TCPConn) error {
+	if err := conn.SetNoDelay(d.noDelay); err != nil {
+		return err
+	}
+	if d.readBuffer > 0 {
+		if err := conn.SetReadBuffer(d.readBuffer); err != nil {
+			return err
+		}
+	}
+	if d.writeBuffer > 0 {
+		if err := conn.SetWriteBuffer(d.writeBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tcpOptions returns the transports with the TCP dialer replaced, or nil to
+// keep libp2p's defaults. With a proxy configured the proxy options list
+// the transports instead, see proxyOptions.
+func tcpOptions(cfg *Config) []libp2p.Option {
+	if !cfg.TCPTuned() || cfg.ProxyAddr != "" {
+		return nil
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"keepalive_interval": time.Duration(cfg.TCPKeepAliveInterval),
+		"nodelay":            cfg.TCPNoDelay,
+		"read_buffer":        cfg.TCPReadBuffer,
+		"write_buffer":       cfg.TCPWriteBuffer,
+	}).Debug("TCP socket options set")
+
+	return transportOptions(cfg, newTCPDialer(cfg))
+}
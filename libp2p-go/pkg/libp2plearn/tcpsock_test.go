@@ -0,0 +1,72 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPSocketOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := testNodeConfig()
+	cfg.TCPKeepAliveInterval = Duration(10 * time.Second)
+	cfg.TCPNoDelay = false
+	cfg.TCPReadBuffer = 1 << 20
+	cfg.TCPWriteBuffer = 1 << 20
+	require.True(t, cfg.TCPTuned())
+
+	node1, err := New(WithConfig(cfg))
+	require.NoError(t, err)
+	defer node1.Stop(ctx)
+	node2, err := New(WithConfig(testNodeConfig()))
+	require.NoError(t, err)
+	defer node2.Stop(ctx)
+
+	// Dial over TCP, so the tuned dialer carries the connection
+	var tcpAddr multiaddr.Multiaddr
+	for _, addr := range node2.Host().Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_TCP); err == nil {
+			tcpAddr = addr
+			break
+		}
+	}
+	require.NotNil(t, tcpAddr)
+	require.NoError(t, node1.Connect(ctx, fmt.Sprintf("%s/p2p/%s", tcpAddr, node2.Host().ID())))
+
+	response, err := node1.Protocols().SendPing(ctx, node2.Host().ID(), "hello")
+	require.NoError(t, err)
+	assert.Contains(t, response, "hello")
+
+	t.Run("tcpDialer", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		dialer := newTCPDialer(cfg)
+		assert.Equal(t, 10*time.Second, dialer.dialer.KeepAliveConfig.Interval)
+		conn, err := dialer.DialContext(ctx, "tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.IsType(t, &net.TCPConn{}, conn)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		cfg := testNodeConfig()
+		assert.False(t, cfg.TCPTuned())
+
+		cfg.TCPKeepAliveInterval = Duration(-time.Second)
+		assert.Error(t, cfg.Validate())
+
+		cfg = testNodeConfig()
+		cfg.TCPReadBuffer = -1
+		assert.Error(t, cfg.Validate())
+	})
+}
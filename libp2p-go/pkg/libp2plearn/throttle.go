@@ -0,0 +1,258 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleBurst caps the token bucket size, which is also the largest
+// chunk read or written at once on a throttled stream
+const maxThrottleBurst = 64 * 1024
+
+// BandwidthLimit caps upload and download in bytes per second (0 for unlimited)
+type BandwidthLimit struct {
+	Upload   int `json:"upload"`
+	Download int `json:"download"`
+}
+
+// buckets holds the upload and download token buckets for one peer or peer/protocol pair
+type buckets struct {
+	up   *rate.Limiter
+	down *rate.Limiter
+}
+
+func newBuckets(limit BandwidthLimit) *buckets {
+	return &buckets{up: newLimiter(limit.Upload), down: newLimiter(limit.Download)}
+}
+
+// newLimiter creates a token bucket for the rate, or nil for unlimited
+func newLimiter(bytesPerSec int) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), min(bytesPerSec, maxThrottleBurst))
+}
+
+// streamLimiters are the buckets a stream draws from in each direction
+type streamLimiters struct {
+	up   []*rate.Limiter
+	down []*rate.Limiter
+}
+
+// Throttle caps stream bandwidth per peer and per protocol of each peer
+type Throttle struct {
+	host      host.Host
+	peerLimit BandwidthLimit
+	protocols map[protocol.ID]BandwidthLimit
+
+	mu      sync.Mutex
+	peers   map[peer.ID]*buckets
+	streams map[peer.ID]map[protocol.ID]*buckets
+}
+
+// NewThrottle creates a bandwidth throttle. protocolLimits maps protocol IDs to their per-peer caps.
+func NewThrottle(h host.Host, peerLimit BandwidthLimit, protocolLimits map[string]BandwidthLimit) *Throttle {
+	protocols := make(map[protocol.ID]BandwidthLimit, len(protocolLimits))
+	for proto, limit := range protocolLimits {
+		protocols[protocol.ID(proto)] = limit
+	}
+
+	t := &Throttle{
+		host:      h,
+		peerLimit: peerLimit,
+		protocols: protocols,
+		peers:     make(map[peer.ID]*buckets),
+		streams:   make(map[peer.ID]map[protocol.ID]*buckets),
+	}
+	h.Network().Notify(t)
+
+	logrus.WithFields(logrus.Fields{
+		"peer_upload":   peerLimit.Upload,
+		"peer_download": peerLimit.Download,
+		"protocols":     len(protocols),
+	}).Info("Bandwidth throttling enabled")
+	return t
+}
+
+// SetLimits changes the bandwidth caps. Streams opened from now on use the new
+// caps; open streams keep the buckets they started with.
+func (t *Throttle) SetLimits(peerLimit BandwidthLimit, protocolLimits map[string]BandwidthLimit) {
+	protocols := make(map[protocol.ID]BandwidthLimit, len(protocolLimits))
+	for proto, limit := range protocolLimits {
+		protocols[protocol.ID(proto)] = limit
+	}
+
+	t.mu.Lock()
+	t.peerLimit = peerLimit
+	t.protocols = protocols
+	t.peers = make(map[peer.ID]*buckets)
+	t.streams = make(map[peer.ID]map[protocol.ID]*buckets)
+	t.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"peer_upload":   peerLimit.Upload,
+		"peer_download": peerLimit.Download,
+		"protocols":     len(protocols),
+	}).Info("Bandwidth limits updated")
+}
+
+// Close stops tracking peers
+func (t *Throttle) Close() {
+	t.host.Network().StopNotify(t)
+}
+
+// Middleware throttles streams accepted by a protocol handler
+func (t *Throttle) Middleware(proto protocol.ID, next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		next(t.Wrap(s))
+	}
+}
+
+// Opener throttles streams opened through the given opener
+func (t *Throttle) Opener(o StreamOpener) StreamOpener {
+	return &throttledOpener{throttle: t, next: o}
+}
+
+// Wrap returns the stream with reads and writes throttled
+func (t *Throttle) Wrap(s network.Stream) network.Stream {
+	limiters := t.limiters(s.Conn().RemotePeer(), baseProtocol(s.Protocol()))
+	if len(limiters.up) == 0 && len(limiters.down) == 0 {
+		return s
+	}
+	return &throttledStream{Stream: s, up: limiters.up, down: limiters.down}
+}
+
+// limiters returns the peer-wide and protocol-specific buckets that apply to a stream
+func (t *Throttle) limiters(p peer.ID, proto protocol.ID) streamLimiters {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var result streamLimiters
+	add := func(b *buckets) {
+		if b.up != nil {
+			result.up = append(result.up, b.up)
+		}
+		if b.down != nil {
+			result.down = append(result.down, b.down)
+		}
+	}
+
+	peerBuckets, ok := t.peers[p]
+	if !ok {
+		peerBuckets = newBuckets(t.peerLimit)
+		t.peers[p] = peerBuckets
+	}
+	add(peerBuckets)
+
+	if limit, ok := t.protocols[proto]; ok {
+		if t.streams[p] == nil {
+			t.streams[p] = make(map[protocol.ID]*buckets)
+		}
+		protoBuckets, ok := t.streams[p][proto]
+		if !ok {
+			protoBuckets = newBuckets(limit)
+			t.streams[p][proto] = protoBuckets
+		}
+		add(protoBuckets)
+	}
+
+	return result
+}
+
+// Disconnected drops the buckets of peers we are no longer connected to
+func (t *Throttle) Disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	if n.Connectedness(p) == network.Connected {
+		return
+	}
+
+	t.mu.Lock()
+	delete(t.peers, p)
+	delete(t.streams, p)
+	t.mu.Unlock()
+}
+
+func (t *Throttle) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (t *Throttle) ListenClose(network.Network, multiaddr.Multiaddr) {}
+func (t *Throttle) Connected(network.Network, network.Conn)          {}
+
+// throttledOpener wraps outgoing streams in the throttle
+type throttledOpener struct {
+	throttle *Throttle
+	next     StreamOpener
+}
+
+func (o *throttledOpener) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := o.next.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return o.throttle.Wrap(s), nil
+}
+
+// throttledStream waits for tokens from every applicable bucket before data moves
+type throttledStream struct {
+	network.Stream
+	up   []*rate.Limiter
+	down []*rate.Limiter
+}
+
+func (s *throttledStream) Read(b []byte) (int, error) {
+	if len(b) > maxBurst(s.down) {
+		b = b[:maxBurst(s.down)]
+	}
+
+	n, err := s.Stream.Read(b)
+	if n > 0 {
+		if werr := waitAll(s.down, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (s *throttledStream) Write(b []byte) (int, error) {
+	written := 0
+	chunk := maxBurst(s.up)
+	for written < len(b) {
+		end := min(written+chunk, len(b))
+		if err := waitAll(s.up, end-written); err != nil {
+			return written, err
+		}
+
+		n, err := s.Stream.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// maxBurst returns the largest number of bytes every limiter allows at once
+func maxBurst(limiters []*rate.Limiter) int {
+	burst := maxThrottleBurst
+	for _, l := range limiters {
+		burst = min(burst, l.Burst())
+	}
+	return burst
+}
+
+// waitAll blocks until every limiter has n tokens
+func waitAll(limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(context.Background(), n); err != nil {
+			return fmt.Errorf("throttle wait failed: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package libp2plearn
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+
+	NewProtocolHandler(server).SetupProtocols()
+
+	err = connectNodes(ctx, client, server)
+	require.NoError(t, err)
+
+	err = WaitForConnection(ctx, client, server, 10*time.Second)
+	require.NoError(t, err)
+
+	payload := strings.Repeat("x", 32*1024)
+
+	t.Run("UploadLimited", func(t *testing.T) {
+		throttle := NewThrottle(client, BandwidthLimit{Upload: 16 * 1024}, nil)
+		defer throttle.Close()
+
+		handler := NewProtocolHandler(client)
+		handler.SetStreamOpener(throttle.Opener(handler.StreamOpener()))
+
+		start := time.Now()
+		response, err := handler.SendEcho(ctx, server.ID(), payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, response)
+
+		// The first 16 KiB use the initial burst, the rest has to wait a second
+		assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	})
+
+	t.Run("UnlimitedProtocolPassesThrough", func(t *testing.T) {
+		throttle := NewThrottle(client, BandwidthLimit{}, map[string]BandwidthLimit{
+			ChatProtocol: {Upload: 1024},
+		})
+		defer throttle.Close()
+
+		handler := NewProtocolHandler(client)
+		handler.SetStreamOpener(throttle.Opener(handler.StreamOpener()))
+
+		start := time.Now()
+		response, err := handler.SendEcho(ctx, server.ID(), payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, response)
+		assert.Less(t, time.Since(start), 900*time.Millisecond, "Echo has no limit configured")
+	})
+}
@@ -0,0 +1,266 @@
+package libp2plearn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TimeSyncProtocol exchanges timestamps to estimate clock offsets between peers
+	TimeSyncProtocol = "/libp2p-learn/time/1.0.0"
+
+	// timeSyncRounds is how many exchanges one measurement makes; the lowest-RTT one is kept
+	timeSyncRounds = 4
+
+	// timeSyncTimeout bounds one measurement
+	timeSyncTimeout = 10 * time.Second
+
+	// maxTimeSyncMessageSize bounds the size of a time sync message
+	maxTimeSyncMessageSize = 256
+
+	// maxClockSampleAge is how long a measurement counts towards network time
+	maxClockSampleAge = 10 * time.Minute
+)
+
+// timeSyncMessage is one exchange, sent as one JSON line. The client fills in
+// Origin; the server echoes it with its receive and transmit times, all in
+// Unix nanoseconds.
+type timeSyncMessage struct {
+	Origin   int64 `json:"origin"`
+	Receive  int64 `json:"receive,omitempty"`
+	Transmit int64 `json:"transmit,omitempty"`
+}
+
+// ClockSample is the measured clock offset of a peer. A positive Offset means
+// the peer's clock is ahead of ours.
+type ClockSample struct {
+	Offset time.Duration
+	RTT    time.Duration
+	Time   time.Time // when the sample was taken
+}
+
+// TimeSync answers time queries and estimates the clock offset and round-trip
+// time to selected peers, NTP style
+type TimeSync struct {
+	host host.Host
+	now  func() time.Time
+
+	mu      sync.Mutex
+	peers   map[peer.ID]bool
+	samples map[peer.ID]ClockSample
+}
+
+// NewTimeSync creates the time sync service and registers its protocol handler
+func NewTimeSync(h host.Host) *TimeSync {
+	t := &TimeSync{
+		host:    h,
+		now:     time.Now,
+		peers:   make(map[peer.ID]bool),
+		samples: make(map[peer.ID]ClockSample),
+	}
+	h.SetStreamHandler(protocol.ID(TimeSyncProtocol), RecoveryMiddleware(protocol.ID(TimeSyncProtocol), t.handleTime))
+	logrus.WithField("protocol", TimeSyncProtocol).Info("Registered time sync protocol")
+	return t
+}
+
+// Close unregisters the time sync protocol
+func (t *TimeSync) Close() {
+	t.host.RemoveStreamHandler(protocol.ID(TimeSyncProtocol))
+}
+
+// Watch adds a peer to measure periodically
+func (t *TimeSync) Watch(p peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers[p] = true
+}
+
+// Unwatch stops measuring a peer and forgets its sample
+func (t *TimeSync) Unwatch(p peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, p)
+	delete(t.samples, p)
+}
+
+// Run measures every watched, connected peer each interval until ctx is done
+func (t *TimeSync) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		t.measureWatched(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// measureWatched measures the watched peers we're connected to
+func (t *TimeSync) measureWatched(ctx context.Context) {
+	t.mu.Lock()
+	peers := make([]peer.ID, 0, len(t.peers))
+	for p := range t.peers {
+		peers = append(peers, p)
+	}
+	t.mu.Unlock()
+
+	for _, p := range peers {
+		if t.host.Network().Connectedness(p) != network.Connected {
+			continue
+		}
+		if _, err := t.Measure(ctx, p); err != nil {
+			logrus.WithError(err).WithField("peer", p).Debug("Failed to measure clock offset")
+		}
+	}
+}
+
+// Measure estimates a peer's clock offset and RTT from several exchanges,
+// keeping the one with the lowest RTT, and records the sample
+func (t *TimeSync) Measure(ctx context.Context, p peer.ID) (ClockSample, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeSyncTimeout)
+	defer cancel()
+
+	s, err := t.host.NewStream(ctx, p, protocol.ID(TimeSyncProtocol))
+	if err != nil {
+		return ClockSample{}, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReaderSize(s, maxTimeSyncMessageSize)
+	var best ClockSample
+	for i := 0; i < timeSyncRounds; i++ {
+		sample, err := t.exchange(s, reader)
+		if err != nil {
+			return ClockSample{}, err
+		}
+		if i == 0 || sample.RTT < best.RTT {
+			best = sample
+		}
+	}
+
+	t.mu.Lock()
+	t.samples[p] = best
+	t.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"peer":   p,
+		"offset": best.Offset,
+		"rtt":    best.RTT,
+	}).Debug("Measured clock offset")
+	return best, nil
+}
+
+// exchange makes one request/response round and computes the offset as
+// ((receive - origin) + (transmit - arrival)) / 2 and the RTT as the time on
+// the wire, excluding the peer's processing time
+func (t *TimeSync) exchange(s network.Stream, reader *bufio.Reader) (ClockSample, error) {
+	origin := t.now()
+	data, _ := json.Marshal(timeSyncMessage{Origin: origin.UnixNano()})
+	if _, err := s.Write(append(data, '\n')); err != nil {
+		return ClockSample{}, fmt.Errorf("failed to send time request: %w", err)
+	}
+
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return ClockSample{}, fmt.Errorf("failed to read time response: %w", err)
+	}
+	arrival := t.now()
+
+	var resp timeSyncMessage
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return ClockSample{}, fmt.Errorf("failed to parse time response: %w", err)
+	}
+	if resp.Origin != origin.UnixNano() {
+		return ClockSample{}, fmt.Errorf("time response does not match request")
+	}
+
+	t1, t2, t3, t4 := origin.UnixNano(), resp.Receive, resp.Transmit, arrival.UnixNano()
+	return ClockSample{
+		Offset: time.Duration(((t2 - t1) + (t3 - t4)) / 2),
+		RTT:    max(arrival.Sub(origin)-time.Duration(t3-t2), 0),
+		Time:   arrival,
+	}, nil
+}
+
+// handleTime answers time requests until the peer closes the stream
+func (t *TimeSync) handleTime(s network.Stream) {
+	defer s.Close()
+
+	s.SetDeadline(time.Now().Add(timeSyncTimeout))
+	reader := bufio.NewReaderSize(s, maxTimeSyncMessageSize)
+	for i := 0; i < timeSyncRounds; i++ {
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			return
+		}
+		receive := t.now()
+
+		var req timeSyncMessage
+		if err := json.Unmarshal(line, &req); err != nil {
+			logrus.WithError(err).WithField("peer", s.Conn().RemotePeer()).Debug("Received invalid time request")
+			return
+		}
+
+		req.Receive = receive.UnixNano()
+		req.Transmit = t.now().UnixNano()
+		data, _ := json.Marshal(req)
+		if _, err := s.Write(append(data, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+// Samples returns the latest sample of every measured peer
+func (t *TimeSync) Samples() map[peer.ID]ClockSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples := make(map[peer.ID]ClockSample, len(t.samples))
+	for p, s := range t.samples {
+		samples[p] = s
+	}
+	return samples
+}
+
+// Offset returns the median of our own clock and the recent peer samples,
+// relative to our clock. A minority of peers with wrong clocks can't move it.
+func (t *TimeSync) Offset() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets := []time.Duration{0}
+	for _, s := range t.samples {
+		if time.Since(s.Time) <= maxClockSampleAge {
+			offsets = append(offsets, s.Offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	mid := len(offsets) / 2
+	if len(offsets)%2 == 0 {
+		return (offsets[mid-1] + offsets[mid]) / 2
+	}
+	return offsets[mid]
+}
+
+// Now returns the median network time
+func (t *TimeSync) Now() time.Time {
+	return time.Now().Add(t.Offset())
+}
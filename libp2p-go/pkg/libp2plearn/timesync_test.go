@@ -0,0 +1,75 @@
+package libp2plearn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeSync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	local, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer local.Close()
+
+	remote, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer remote.Close()
+
+	localSync := NewTimeSync(local)
+	defer localSync.Close()
+	remoteSync := NewTimeSync(remote)
+	defer remoteSync.Close()
+
+	require.NoError(t, connectNodes(ctx, local, remote))
+
+	t.Run("SameClock", func(t *testing.T) {
+		sample, err := localSync.Measure(ctx, remote.ID())
+		require.NoError(t, err)
+		assert.Less(t, sample.Offset.Abs(), 50*time.Millisecond)
+		assert.Less(t, sample.RTT, time.Second)
+	})
+
+	t.Run("SkewedClock", func(t *testing.T) {
+		remoteSync.now = func() time.Time { return time.Now().Add(5 * time.Second) }
+		defer func() { remoteSync.now = time.Now }()
+
+		sample, err := localSync.Measure(ctx, remote.ID())
+		require.NoError(t, err)
+		assert.InDelta(t, float64(5*time.Second), float64(sample.Offset), float64(50*time.Millisecond))
+		assert.Equal(t, sample, localSync.Samples()[remote.ID()])
+	})
+}
+
+func TestTimeSyncMedian(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h.Close()
+
+	ts := NewTimeSync(h)
+	defer ts.Close()
+
+	assert.Equal(t, time.Duration(0), ts.Offset(), "without samples network time should be our own")
+
+	now := time.Now()
+	ts.samples[peer.ID("a")] = ClockSample{Offset: 2 * time.Second, Time: now}
+	ts.samples[peer.ID("b")] = ClockSample{Offset: 3 * time.Second, Time: now}
+	ts.samples[peer.ID("c")] = ClockSample{Offset: time.Hour, Time: now}
+	assert.Equal(t, 2500*time.Millisecond, ts.Offset(), "one wrong clock should not move the median far")
+
+	ts.samples[peer.ID("d")] = ClockSample{Offset: -time.Hour, Time: now.Add(-2 * maxClockSampleAge)}
+	assert.Equal(t, 2500*time.Millisecond, ts.Offset(), "stale samples should be ignored")
+
+	ts.Unwatch(peer.ID("c"))
+	assert.Equal(t, 2*time.Second, ts.Offset())
+	assert.WithinDuration(t, time.Now().Add(2*time.Second), ts.Now(), 100*time.Millisecond)
+}
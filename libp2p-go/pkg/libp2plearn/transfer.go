@@ -0,0 +1,708 @@
+package libp2plearn
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TransferProtocol sends directories to authorized peers as a tar stream
+	TransferProtocol = "/libp2p-learn/transfer/1.0.0"
+
+	// transferIdleTimeout bounds how long a transfer may go without progress
+	transferIdleTimeout = 30 * time.Second
+
+	// maxTransferLineSize bounds the size of the offer and reply lines, which
+	// list the resumable files and the chunks already received
+	maxTransferLineSize = 1 << 20
+
+	// transferBufferSize is the size of the copy buffer, and so how often
+	// progress is reported
+	transferBufferSize = 32 * 1024
+
+	// PAX records of resumable entries: the content hash and full size. The
+	// entry's data holds only the chunks the receiver said it was missing.
+	transferHashRecord = "LIBP2PLEARN.sha256"
+	transferSizeRecord = "LIBP2PLEARN.size"
+)
+
+// TransferOffer announces a directory, sent as one JSON line before the tar
+// stream so the receiver can refuse it up front
+type TransferOffer struct {
+	Name  string `json:"name"`  // base name of the directory, created under the receiver's directory
+	Files int    `json:"files"` // regular files in the tree
+	Bytes int64  `json:"bytes"` // total size of the regular files
+
+	// Files larger than one chunk, by content hash, so the receiver can
+	// resume them from an earlier transfer
+	Resumable []TransferFile `json:"resumable,omitempty"`
+}
+
+// TransferFile identifies the content of a file in an offer
+type TransferFile struct {
+	Path string `json:"path"` // slash-separated and relative to the directory
+	Hash string `json:"hash"` // hex SHA-256
+	Size int64  `json:"size"`
+}
+
+// TransferReply accepts or refuses an offer, and after the tar stream reports
+// what was written. Both are sent as one JSON line.
+type TransferReply struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Files int    `json:"files,omitempty"`
+	Bytes int64  `json:"bytes,omitempty"` // bytes actually sent, without resumed chunks
+
+	// Bitmaps of the chunks already received, by content hash, which the
+	// sender leaves out
+	Have map[string][]byte `json:"have,omitempty"`
+}
+
+// TransferProgress is the state of a transfer after some bytes were sent or received
+type TransferProgress struct {
+	Peer       peer.ID
+	Name       string // directory being transferred
+	Path       string // current entry, slash-separated and relative to the directory
+	Files      int    // regular files started so far
+	Bytes      int64  // including chunks resumed from an earlier transfer
+	TotalFiles int
+	TotalBytes int64
+}
+
+// TransferProgressFunc receives progress updates. It is called from the
+// transfer goroutine, so it should return quickly.
+type TransferProgressFunc func(TransferProgress)
+
+// Transfer receives directories from authorized peers into a local
+// directory. Each tar entry keeps its mode and modification time; only
+// regular files and directories are accepted, and no entry may point outside
+// the received directory.
+type Transfer struct {
+	host     host.Host
+	dir      string
+	allowed  map[peer.ID]bool
+	partial  *PartialStore
+	progress TransferProgressFunc
+}
+
+// NewTransfer creates the transfer service and registers its protocol
+// handler. Only the given peer IDs may send directories, which are written
+// under dir.
+func NewTransfer(h host.Host, dir string, allowedIDs []string) (*Transfer, error) {
+	t := &Transfer{
+		host:    h,
+		dir:     dir,
+		allowed: make(map[peer.ID]bool, len(allowedIDs)),
+	}
+	for _, id := range allowedIDs {
+		p, err := peer.Decode(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transfer peer %q: %w", id, err)
+		}
+		t.allowed[p] = true
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create transfer directory: %w", err)
+	}
+
+	h.SetStreamHandler(protocol.ID(TransferProtocol), RecoveryMiddleware(protocol.ID(TransferProtocol), t.handleTransfer))
+	logrus.WithFields(logrus.Fields{
+		"protocol": TransferProtocol,
+		"dir":      dir,
+		"peers":    len(t.allowed),
+	}).Info("Registered transfer protocol handler")
+	return t, nil
+}
+
+// SetPartialStore keeps partially received large files in the store, so
+// interrupted transfers resume instead of starting over
+func (t *Transfer) SetPartialStore(ps *PartialStore) {
+	t.partial = ps
+}
+
+// SetProgressHandler sets the function receiving progress of incoming transfers
+func (t *Transfer) SetProgressHandler(fn TransferProgressFunc) {
+	t.progress = fn
+}
+
+// Close unregisters the transfer protocol
+func (t *Transfer) Close() {
+	t.host.RemoveStreamHandler(protocol.ID(TransferProtocol))
+}
+
+// handleTransfer receives one directory from an authorized peer
+func (t *Transfer) handleTransfer(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !t.allowed[remote] {
+		logrus.WithField("peer", remote).Warn("Rejected transfer stream from unauthorized peer")
+		s.Reset()
+		return
+	}
+	defer s.Close()
+
+	idle := &idleStream{s: s}
+	reader := bufio.NewReaderSize(idle, maxTransferLineSize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		logrus.WithError(err).WithField("peer", remote).Debug("Failed to read transfer offer")
+		return
+	}
+	var offer TransferOffer
+	if err := json.Unmarshal(line, &offer); err != nil {
+		logrus.WithError(err).WithField("peer", remote).Warn("Received invalid transfer offer")
+		ReportMisbehavior(t.host, remote, MisbehaviorProtocolError, "invalid transfer offer")
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"peer":  remote,
+		"name":  offer.Name,
+		"files": offer.Files,
+		"bytes": offer.Bytes,
+	})
+	if err := validTransferName(offer.Name); err != nil {
+		log.WithError(err).Warn("Refused transfer")
+		writeTransferReply(idle, TransferReply{Error: err.Error()})
+		return
+	}
+	accept := TransferReply{OK: true}
+	if t.partial != nil {
+		for _, file := range offer.Resumable {
+			if have := t.partial.Have(file.Hash, file.Size); have != nil {
+				if accept.Have == nil {
+					accept.Have = make(map[string][]byte)
+				}
+				accept.Have[file.Hash] = have
+			}
+		}
+	}
+	if err := writeTransferReply(idle, accept); err != nil {
+		log.WithError(err).Debug("Failed to accept transfer")
+		return
+	}
+	log.WithField("resumed", len(accept.Have)).Info("Receiving directory")
+
+	progress := func(p TransferProgress) {
+		if t.progress != nil {
+			p.Peer = remote
+			t.progress(p)
+		}
+	}
+	root := filepath.Join(t.dir, offer.Name)
+	files, received, err := extractTar(reader, root, offer, t.partial, accept.Have, progress)
+	if err != nil {
+		log.WithError(err).Warn("Failed to receive directory")
+		writeTransferReply(idle, TransferReply{Error: err.Error(), Files: files, Bytes: received})
+		return
+	}
+	writeTransferReply(idle, TransferReply{OK: true, Files: files, Bytes: received})
+	log.WithField("dir", root).Info("Received directory")
+}
+
+// SendDir sends a local directory to a peer, which stores it under its
+// transfer directory by the directory's base name. progress may be nil.
+func SendDir(ctx context.Context, h host.Host, p peer.ID, dir string, progress TransferProgressFunc) (*TransferReply, error) {
+	dir = filepath.Clean(dir)
+	offer, err := scanDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = func(TransferProgress) {}
+	}
+
+	for {
+		reply, err := sendDir(ctx, h, p, dir, offer, progress)
+		if !errors.Is(err, errTransferMoved) {
+			return reply, err
+		}
+		logrus.WithField("peer", p).Info("Resuming transfer over a direct connection")
+	}
+}
+
+// errTransferMoved is why a relayed transfer stopped once a direct
+// connection came up
+var errTransferMoved = errors.New("transfer moved to a direct connection")
+
+// sendDir sends a directory over one stream. A transfer that goes through
+// a relay is stopped with errTransferMoved once there is a direct
+// connection to the peer, such as after a hole punch; the next attempt
+// takes the direct connection, and the peer only asks again for what it
+// didn't keep.
+func sendDir(ctx context.Context, h host.Host, p peer.ID, dir string, offer TransferOffer, progress TransferProgressFunc) (_ *TransferReply, err error) {
+	s, err := h.NewStream(ctx, p, protocol.ID(TransferProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer s.Close()
+
+	// Interrupt blocked reads and writes when ctx is done
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
+
+	if isRelayed(s.Conn()) {
+		var moved atomic.Bool
+		watch, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-waitDirect(watch, h, p):
+				moved.Store(true)
+				s.Reset()
+			case <-watch.Done():
+			}
+		}()
+		defer func() {
+			if err != nil && moved.Load() {
+				err = errTransferMoved
+			}
+		}()
+	}
+
+	idle := &idleStream{s: s}
+	data, err := json.Marshal(offer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transfer offer: %w", err)
+	}
+	if _, err := idle.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send transfer offer: %w", err)
+	}
+
+	reader := bufio.NewReaderSize(idle, maxTransferLineSize)
+	reply, err := readTransferReply(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !reply.OK {
+		return reply, fmt.Errorf("transfer refused: %s", reply.Error)
+	}
+
+	if err := writeTar(idle, dir, offer, reply.Have, func(pr TransferProgress) {
+		pr.Peer = p
+		progress(pr)
+	}); err != nil {
+		return nil, err
+	}
+	s.CloseWrite()
+
+	reply, err = readTransferReply(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !reply.OK {
+		return reply, fmt.Errorf("transfer failed: %s", reply.Error)
+	}
+	return reply, nil
+}
+
+// scanDir builds the offer of a directory
+func scanDir(dir string) (TransferOffer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return TransferOffer{}, fmt.Errorf("failed to read directory: %w", err)
+	}
+	if !info.IsDir() {
+		return TransferOffer{}, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	offer := TransferOffer{Name: filepath.Base(dir)}
+	if err := validTransferName(offer.Name); err != nil {
+		return TransferOffer{}, err
+	}
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		offer.Files++
+		offer.Bytes += info.Size()
+		if info.Size() <= transferChunkSize {
+			return nil
+		}
+
+		hash, err := hashFile(file)
+		if err != nil {
+			return err
+		}
+		// Identical copies are sent whole, since the receiver finishes the
+		// first one before it gets to the next
+		if !seen[hash] {
+			seen[hash] = true
+			rel, _ := filepath.Rel(dir, file)
+			offer.Resumable = append(offer.Resumable, TransferFile{Path: filepath.ToSlash(rel), Hash: hash, Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return TransferOffer{}, fmt.Errorf("failed to scan directory: %w", err)
+	}
+	return offer, nil
+}
+
+// writeTar writes the directories and regular files under dir as a tar
+// stream. Other entries, such as symlinks, are skipped. Of resumable files,
+// only the chunks missing from the receiver's bitmaps in have are sent.
+func writeTar(w io.Writer, dir string, offer TransferOffer, have map[string][]byte, progress TransferProgressFunc) error {
+	tw := tar.NewWriter(w)
+	buf := make([]byte, transferBufferSize)
+	state := TransferProgress{Name: offer.Name, TotalFiles: offer.Files, TotalBytes: offer.Bytes}
+	resumable := make(map[string]TransferFile, len(offer.Resumable))
+	for _, file := range offer.Resumable {
+		resumable[file.Path] = file
+	}
+
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			logrus.WithField("path", file).Warn("Skipping file that is neither regular nor a directory")
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		// Owners mean nothing on the other machine
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if d.IsDir() {
+			return tw.WriteHeader(hdr)
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// The header fixes the size, so a file that grew is cut short
+		sections := []*io.SectionReader{io.NewSectionReader(f, 0, hdr.Size)}
+		var skipped int64
+		if rf, ok := resumable[hdr.Name]; ok {
+			if rf.Size != hdr.Size {
+				return fmt.Errorf("%s changed while sending", hdr.Name)
+			}
+			// Only the chunks the receiver is missing
+			hdr.PAXRecords = map[string]string{
+				transferHashRecord: rf.Hash,
+				transferSizeRecord: strconv.FormatInt(rf.Size, 10),
+			}
+			sections, hdr.Size = nil, 0
+			for _, i := range missingChunks(rf.Size, have[rf.Hash]) {
+				n := chunkLen(rf.Size, i)
+				sections = append(sections, io.NewSectionReader(f, int64(i)*transferChunkSize, n))
+				hdr.Size += n
+			}
+			skipped = rf.Size - hdr.Size
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		state.Path = hdr.Name
+		state.Files++
+		state.Bytes += skipped
+		progress(state)
+		for _, section := range sections {
+			if err := copyWithProgress(tw, section, buf, func(n int) {
+				state.Bytes += int64(n)
+				progress(state)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send directory: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to send directory: %w", err)
+	}
+	return nil
+}
+
+// extractTar writes a tar stream under root, returning the files written and
+// the bytes received. Entries beyond what the offer announced are refused.
+// Resumable entries are written through partial, if set, and hold only the
+// chunks missing from the bitmaps in have.
+func extractTar(r io.Reader, root string, offer TransferOffer, partial *PartialStore, have map[string][]byte, progress TransferProgressFunc) (int, int64, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return 0, 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	type dirTimes struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirTimes
+	var received int64
+	buf := make([]byte, transferBufferSize)
+	state := TransferProgress{Name: offer.Name, TotalFiles: offer.Files, TotalBytes: offer.Bytes}
+	written := func(n int) {
+		received += int64(n)
+		state.Bytes += int64(n)
+		progress(state)
+	}
+	resumable := make(map[string]TransferFile, len(offer.Resumable))
+	for _, file := range offer.Resumable {
+		resumable[file.Path] = file
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return state.Files, received, fmt.Errorf("failed to read archive: %w", err)
+		}
+		target, err := transferTarget(root, hdr.Name)
+		if err != nil {
+			return state.Files, received, err
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return state.Files, received, fmt.Errorf("failed to create directory: %w", err)
+			}
+			// Applied once the files inside are written, so a read-only
+			// directory can still be filled and keeps its mtime
+			dirs = append(dirs, dirTimes{path: target, mode: mode, mtime: hdr.ModTime})
+
+		case tar.TypeReg:
+			size := hdr.Size
+			hash, isResumable := hdr.PAXRecords[transferHashRecord]
+			if isResumable {
+				rf := resumable[hdr.Name]
+				if rf.Hash != hash || hdr.PAXRecords[transferSizeRecord] != strconv.FormatInt(rf.Size, 10) {
+					return state.Files, received, fmt.Errorf("entry %q doesn't match the offer", hdr.Name)
+				}
+				size = rf.Size
+			}
+			if state.Files+1 > offer.Files || state.Bytes+size > offer.Bytes {
+				return state.Files, received, fmt.Errorf("archive is larger than offered")
+			}
+			state.Path = hdr.Name
+			state.Files++
+
+			if !isResumable || partial == nil {
+				if hdr.Size != size {
+					return state.Files, received, fmt.Errorf("entry %q is incomplete", hdr.Name)
+				}
+				progress(state)
+				if err := writeTransferFile(tr, target, hdr, mode, buf, written); err != nil {
+					return state.Files, received, err
+				}
+				continue
+			}
+			chunks := missingChunks(size, have[hash])
+			state.Bytes += size - hdr.Size
+			progress(state)
+			if err := receiveChunks(tr, partial, hdr, target, size, chunks, mode, buf, written); err != nil {
+				return state.Files, received, err
+			}
+
+		default:
+			return state.Files, received, fmt.Errorf("unsupported entry %q", hdr.Name)
+		}
+	}
+
+	// Innermost directories first, since setting a child's mtime touches its parent
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirs[i].path, dirs[i].mode|0700)
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return state.Files, received, nil
+}
+
+// receiveChunks writes the chunks of a resumable entry into the partial store,
+// and moves the file to target once it is complete. Chunks written before an
+// error are kept for the next transfer.
+func receiveChunks(r io.Reader, partial *PartialStore, hdr *tar.Header, target string, size int64, chunks []int, mode os.FileMode, buf []byte, written func(int)) error {
+	var expected int64
+	for _, i := range chunks {
+		expected += chunkLen(size, i)
+	}
+	if hdr.Size != expected {
+		return fmt.Errorf("entry %q doesn't hold the missing chunks", hdr.Name)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	pf, err := partial.Open(hdr.PAXRecords[transferHashRecord], size)
+	if err != nil {
+		return err
+	}
+	var chunk bytes.Buffer
+	for _, i := range chunks {
+		chunk.Reset()
+		if err := copyWithProgress(&chunk, io.LimitReader(r, chunkLen(size, i)), buf, written); err != nil {
+			pf.Close()
+			return fmt.Errorf("failed to receive %s: %w", hdr.Name, err)
+		}
+		if err := pf.WriteChunk(i, chunk.Bytes()); err != nil {
+			pf.Close()
+			return fmt.Errorf("failed to receive %s: %w", hdr.Name, err)
+		}
+	}
+	if err := pf.Finish(target, mode, hdr.ModTime); err != nil {
+		return fmt.Errorf("failed to receive %s: %w", hdr.Name, err)
+	}
+	return nil
+}
+
+// writeTransferFile writes one regular file entry and applies its mode and mtime
+func writeTransferFile(r io.Reader, target string, hdr *tar.Header, mode os.FileMode, buf []byte, written func(int)) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temporary file first so an interrupted transfer never leaves
+	// a truncated file in place of a complete one
+	tmp := target + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", hdr.Name, err)
+	}
+	err = copyWithProgress(f, r, buf, written)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, hdr.ModTime, hdr.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", hdr.Name, err)
+	}
+	return nil
+}
+
+// transferTarget resolves a tar entry name under root, refusing absolute
+// names and names that climb out of it
+func transferTarget(root, name string) (string, error) {
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+	if name == "" || path.IsAbs(clean) || clean == "." || clean == ".." ||
+		strings.HasPrefix(clean, "../") || strings.ContainsRune(name, 0) || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("unsafe entry %q", name)
+	}
+	return filepath.Join(root, filepath.FromSlash(clean)), nil
+}
+
+// validTransferName checks that a directory name is a single path element
+func validTransferName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0) {
+		return fmt.Errorf("invalid directory name %q", name)
+	}
+	return nil
+}
+
+// copyWithProgress copies r to w through buf, calling written after every write
+func copyWithProgress(w io.Writer, r io.Reader, buf []byte, written func(int)) error {
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			written(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeTransferReply sends a reply line
+func writeTransferReply(w io.Writer, reply TransferReply) error {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// readTransferReply reads a reply line
+func readTransferReply(r *bufio.Reader) (*TransferReply, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("peer closed the transfer without a reply")
+		}
+		return nil, fmt.Errorf("failed to read transfer reply: %w", err)
+	}
+	var reply TransferReply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		return nil, fmt.Errorf("failed to parse transfer reply: %w", err)
+	}
+	return &reply, nil
+}
+
+// idleStream extends the stream deadline on every read and write, so a
+// transfer of any size only fails once it stalls
+type idleStream struct {
+	s network.Stream
+}
+
+func (i *idleStream) Read(p []byte) (int, error) {
+	i.s.SetReadDeadline(time.Now().Add(transferIdleTimeout))
+	return i.s.Read(p)
+}
+
+func (i *idleStream) Write(p []byte) (int, error) {
+	i.s.SetWriteDeadline(time.Now().Add(transferIdleTimeout))
+	return i.s.Write(p)
+}
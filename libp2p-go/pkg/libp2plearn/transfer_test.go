@@ -0,0 +1,190 @@
+package libp2plearn
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	server, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer client.Close()
+	other, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer other.Close()
+	stranger, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer stranger.Close()
+	require.NoError(t, connectNodes(ctx, client, server))
+	require.NoError(t, connectNodes(ctx, other, server))
+	require.NoError(t, connectNodes(ctx, stranger, server))
+
+	received := t.TempDir()
+	transfer, err := NewTransfer(server, received, []string{client.ID().String(), other.ID().String()})
+	require.NoError(t, err)
+	defer transfer.Close()
+
+	// A project folder with nested and empty directories
+	src := filepath.Join(t.TempDir(), "project")
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "cmd", "tool"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "empty"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "README.md"), []byte("# project\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "cmd", "tool", "run.sh"), []byte("#!/bin/sh\necho hi\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "big.bin"), bytes.Repeat([]byte("x"), 3*transferBufferSize+7), 0600))
+	video := make([]byte, 3*transferChunkSize+123)
+	rand.Read(video)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "video.bin"), video, 0644))
+	require.NoError(t, os.Symlink("README.md", filepath.Join(src, "link")))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "README.md"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "cmd"), mtime, mtime))
+
+	total := int64(len("# project\n") + len("#!/bin/sh\necho hi\n") + 3*transferBufferSize + 7 + len(video))
+
+	t.Run("SendsDirectoryWithMetadata", func(t *testing.T) {
+		var sent, got []TransferProgress
+		transfer.SetProgressHandler(func(p TransferProgress) { got = append(got, p) })
+		defer transfer.SetProgressHandler(nil)
+
+		reply, err := SendDir(ctx, client, server.ID(), src, func(p TransferProgress) { sent = append(sent, p) })
+		require.NoError(t, err)
+		assert.Equal(t, 4, reply.Files)
+		assert.Equal(t, total, reply.Bytes)
+
+		require.NotEmpty(t, sent)
+		last := sent[len(sent)-1]
+		assert.Equal(t, TransferProgress{Peer: server.ID(), Name: "project", Path: last.Path, Files: 4, Bytes: total, TotalFiles: 4, TotalBytes: total}, last)
+		require.NotEmpty(t, got)
+		assert.Equal(t, client.ID(), got[len(got)-1].Peer)
+		assert.Equal(t, total, got[len(got)-1].Bytes)
+
+		dst := filepath.Join(received, "project")
+		data, err := os.ReadFile(filepath.Join(dst, "cmd", "tool", "run.sh"))
+		require.NoError(t, err)
+		assert.Equal(t, "#!/bin/sh\necho hi\n", string(data))
+
+		info, err := os.Stat(filepath.Join(dst, "cmd", "tool", "run.sh"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+		info, err = os.Stat(filepath.Join(dst, "big.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assert.Equal(t, int64(3*transferBufferSize+7), info.Size())
+
+		info, err = os.Stat(filepath.Join(dst, "README.md"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(mtime))
+		info, err = os.Stat(filepath.Join(dst, "cmd"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(mtime), "directory mtimes survive the files written into them")
+
+		info, err = os.Stat(filepath.Join(dst, "empty"))
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+		_, err = os.Lstat(filepath.Join(dst, "link"))
+		assert.True(t, os.IsNotExist(err), "symlinks are not sent")
+
+		data, err = os.ReadFile(filepath.Join(dst, "video.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, video, data)
+	})
+
+	t.Run("ResumesFromAnotherPeer", func(t *testing.T) {
+		partial, err := NewPartialStore(t.TempDir(), 0)
+		require.NoError(t, err)
+		transfer.SetPartialStore(partial)
+		defer transfer.SetPartialStore(nil)
+		require.NoError(t, os.RemoveAll(filepath.Join(received, "project")))
+
+		// An earlier transfer got the first and third chunks before it broke off
+		sum := sha256.Sum256(video)
+		hash := hex.EncodeToString(sum[:])
+		pf, err := partial.Open(hash, int64(len(video)))
+		require.NoError(t, err)
+		require.NoError(t, pf.WriteChunk(0, video[:transferChunkSize]))
+		require.NoError(t, pf.WriteChunk(2, video[2*transferChunkSize:3*transferChunkSize]))
+		require.NoError(t, pf.Close())
+
+		var sent []TransferProgress
+		reply, err := SendDir(ctx, other, server.ID(), src, func(p TransferProgress) { sent = append(sent, p) })
+		require.NoError(t, err)
+		assert.Equal(t, total-2*transferChunkSize, reply.Bytes, "resumed chunks are not sent again")
+		assert.Equal(t, total, sent[len(sent)-1].Bytes)
+
+		data, err := os.ReadFile(filepath.Join(received, "project", "video.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, video, data)
+		assert.Nil(t, partial.Have(hash, int64(len(video))), "completed files leave the store")
+	})
+
+	t.Run("RejectsUnauthorizedPeer", func(t *testing.T) {
+		_, err := SendDir(ctx, stranger, server.ID(), src, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsFile", func(t *testing.T) {
+		_, err := SendDir(ctx, client, server.ID(), filepath.Join(src, "README.md"), nil)
+		assert.ErrorContains(t, err, "not a directory")
+	})
+}
+
+func TestExtractTar(t *testing.T) {
+	// archive builds a tar stream of regular files with the given names
+	archive := func(names ...string) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 2}))
+			_, err := tw.Write([]byte("hi"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+	offer := TransferOffer{Name: "dir", Files: 10, Bytes: 100}
+	noProgress := func(TransferProgress) {}
+
+	t.Run("RejectsEscapingEntries", func(t *testing.T) {
+		for _, name := range []string{"../evil", "a/../../evil", "/etc/evil", `..\evil`} {
+			parent := t.TempDir()
+			_, _, err := extractTar(archive(name), filepath.Join(parent, "dir"), offer, nil, nil, noProgress)
+			assert.ErrorContains(t, err, "unsafe entry", name)
+			_, err = os.Stat(filepath.Join(parent, "evil"))
+			assert.True(t, os.IsNotExist(err), name)
+		}
+	})
+
+	t.Run("RejectsSymlinks", func(t *testing.T) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+		require.NoError(t, tw.Close())
+		_, _, err := extractTar(&buf, t.TempDir(), offer, nil, nil, noProgress)
+		assert.ErrorContains(t, err, "unsupported entry")
+	})
+
+	t.Run("RejectsMoreThanOffered", func(t *testing.T) {
+		files, _, err := extractTar(archive("a", "b", "c"), t.TempDir(), TransferOffer{Name: "dir", Files: 2, Bytes: 100}, nil, nil, noProgress)
+		assert.ErrorContains(t, err, "larger than offered")
+		assert.Equal(t, 2, files)
+	})
+}
@@ -0,0 +1,360 @@
+package libp2plearn
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// transportPerfAlpha weighs a new measurement against the average
+	transportPerfAlpha = 0.2
+
+	// transportPerfMinSamples is how many measurements of a transport it
+	// takes before they stand in for its addresses that weren't measured
+	transportPerfMinSamples = 3
+
+	// transportPerfMaxAge is how long an address is remembered after its
+	// last measurement
+	transportPerfMaxAge = time.Hour
+)
+
+var (
+	// transportConnectSeconds is the average time to connect over each transport
+	transportConnectSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "libp2p_learn",
+		Subsystem: "transport",
+		Name:      "connect_seconds",
+		Help:      "Moving average of the time to connect over each transport",
+	}, []string{"transport"})
+
+	// transportRTTSeconds is the average round trip over each transport
+	transportRTTSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "libp2p_learn",
+		Subsystem: "transport",
+		Name:      "rtt_seconds",
+		Help:      "Moving average of the round trip over each transport",
+	}, []string{"transport"})
+
+	// transportPreferred counts dials by the transport ranked first
+	transportPreferred = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "libp2p_learn",
+		Subsystem: "transport",
+		Name:      "preferred_total",
+		Help:      "Number of dials by the transport of the address ranked first",
+	}, []string{"transport"})
+)
+
+// TransportPolicy orders the addresses of a dial, best first, given what
+// was measured of them. perf may be nil when nothing is measured.
+type TransportPolicy func(addrs []multiaddr.Multiaddr, perf *TransportPerf) []multiaddr.Multiaddr
+
+// TransportEstimate is what was measured of a transport, or of one address
+// when Addr is set. Connect is the time from dialing to a usable
+// connection, RTT the round trip of pings over it; both are moving averages.
+type TransportEstimate struct {
+	Transport string   `json:"transport"`
+	Addr      string   `json:"addr,omitempty"`
+	Connects  int      `json:"connects"`
+	Connect   Duration `json:"connect"`
+	RTTs      int      `json:"rtts"`
+	RTT       Duration `json:"rtt"`
+}
+
+// Cost is what the measured policy ranks addresses by: the connect time
+// plus the round trip
+func (e TransportEstimate) Cost() time.Duration {
+	return time.Duration(e.Connect + e.RTT)
+}
+
+// perfAverage is the moving averages of a transport or an address
+type perfAverage struct {
+	connects int
+	connect  time.Duration
+	rtts     int
+	rtt      time.Duration
+	updated  time.Time
+}
+
+// ewma moves avg towards sample, starting from the first sample
+func ewma(avg time.Duration, n int, sample time.Duration) time.Duration {
+	if n == 0 {
+		return sample
+	}
+	return avg + time.Duration(transportPerfAlpha*float64(sample-avg))
+}
+
+// TransportPerf measures how well each transport, and each address of a
+// peer, performs: how long outbound connections take to establish, from
+// the connection timings, and the round trips of pings over them. With the
+// measured dial strategy its policy ranks dial addresses by it, so a peer's
+// WebSocket address is dialed after its QUIC one if QUIC has done better,
+// and the other way around.
+type TransportPerf struct {
+	host host.Host
+	sub  event.Subscription
+	done chan struct{}
+
+	mu         sync.Mutex
+	policy     TransportPolicy
+	transports map[string]*perfAverage
+	addrs      map[string]*perfAverage
+	pruned     time.Time
+}
+
+// NewTransportPerf creates the measurements, ranking with
+// MeasuredTransportPolicy
+func NewTransportPerf() *TransportPerf {
+	return &TransportPerf{
+		policy:     MeasuredTransportPolicy,
+		transports: make(map[string]*perfAverage),
+		addrs:      make(map[string]*perfAverage),
+	}
+}
+
+// SetPolicy replaces the policy that ranks dial addresses
+func (t *TransportPerf) SetPolicy(policy TransportPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.policy = policy
+}
+
+// Attach starts measuring the connections of h
+func (t *TransportPerf) Attach(h host.Host) error {
+	sub, err := h.EventBus().Subscribe(new(EvtConnectionTimed))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to connection timings: %w", err)
+	}
+	t.host = h
+	t.sub = sub
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		for e := range sub.Out() {
+			timing := e.(EvtConnectionTimed).Timing
+			// Only our own dials say how well we reach the address
+			if timing.Direction != "outbound" {
+				continue
+			}
+			t.recordConnect(timing.Addr, timing.Transport, time.Duration(timing.Total-timing.Identify))
+		}
+	}()
+	return nil
+}
+
+// Close stops measuring
+func (t *TransportPerf) Close() {
+	if t.sub != nil {
+		t.sub.Close()
+		<-t.done
+	}
+}
+
+// RecordRTT records a round trip to a peer against the address it is
+// connected on. Peers with several connections are skipped, since which
+// one carried the ping isn't known. A nil TransportPerf records nothing.
+func (t *TransportPerf) RecordRTT(p peer.ID, rtt time.Duration) {
+	if t == nil || t.host == nil || rtt <= 0 {
+		return
+	}
+	conns := t.host.Network().ConnsToPeer(p)
+	if len(conns) != 1 {
+		return
+	}
+	addr := conns[0].RemoteMultiaddr()
+	t.update(addr.String(), transportName(addr), func(avg *perfAverage) {
+		avg.rtt = ewma(avg.rtt, avg.rtts, rtt)
+		avg.rtts++
+	}, func(avg *perfAverage) {
+		transportRTTSeconds.WithLabelValues(transportName(addr)).Set(avg.rtt.Seconds())
+	})
+}
+
+// recordConnect records the time to connect to an address
+func (t *TransportPerf) recordConnect(addr, transport string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t.update(addr, transport, func(avg *perfAverage) {
+		avg.connect = ewma(avg.connect, avg.connects, d)
+		avg.connects++
+	}, func(avg *perfAverage) {
+		transportConnectSeconds.WithLabelValues(transport).Set(avg.connect.Seconds())
+	})
+}
+
+// update applies a measurement to the averages of an address and its
+// transport, then calls observe with the transport's
+func (t *TransportPerf) update(addr, transport string, apply, observe func(*perfAverage)) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, entry := range []struct {
+		m   map[string]*perfAverage
+		key string
+	}{{t.addrs, addr}, {t.transports, transport}} {
+		avg, ok := entry.m[entry.key]
+		if !ok {
+			avg = &perfAverage{}
+			entry.m[entry.key] = avg
+		}
+		apply(avg)
+		avg.updated = now
+	}
+	observe(t.transports[transport])
+
+	if now.Sub(t.pruned) > time.Minute {
+		t.pruned = now
+		for key, avg := range t.addrs {
+			if now.Sub(avg.updated) > transportPerfMaxAge {
+				delete(t.addrs, key)
+			}
+		}
+	}
+}
+
+// Estimate returns what is known of an address: its own measurements, with
+// those of its transport standing in for what it lacks once the transport
+// was measured often enough. It reports false if nothing is known.
+func (t *TransportPerf) Estimate(addr multiaddr.Multiaddr) (TransportEstimate, bool) {
+	transport := transportName(addr)
+	est := TransportEstimate{Transport: transport, Addr: addr.String()}
+	if t == nil {
+		return est, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if avg, ok := t.addrs[est.Addr]; ok {
+		est.Connects, est.Connect = avg.connects, Duration(avg.connect)
+		est.RTTs, est.RTT = avg.rtts, Duration(avg.rtt)
+	}
+	if avg, ok := t.transports[transport]; ok {
+		if est.Connects == 0 && avg.connects >= transportPerfMinSamples {
+			est.Connect = Duration(avg.connect)
+		}
+		if est.RTTs == 0 && avg.rtts >= transportPerfMinSamples {
+			est.RTT = Duration(avg.rtt)
+		}
+	}
+	return est, est.Connect > 0 || est.RTT > 0
+}
+
+// Snapshot returns the measurements of every transport, then of every
+// address, each by name
+func (t *TransportPerf) Snapshot() []TransportEstimate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	estimates := make([]TransportEstimate, 0, len(t.transports)+len(t.addrs))
+	for _, entry := range []struct {
+		m       map[string]*perfAverage
+		isAddrs bool
+	}{{t.transports, false}, {t.addrs, true}} {
+		start := len(estimates)
+		for key, avg := range entry.m {
+			est := TransportEstimate{
+				Transport: key,
+				Connects:  avg.connects,
+				Connect:   Duration(avg.connect),
+				RTTs:      avg.rtts,
+				RTT:       Duration(avg.rtt),
+			}
+			if entry.isAddrs {
+				est.Addr = key
+				if addr, err := multiaddr.NewMultiaddr(key); err == nil {
+					est.Transport = transportName(addr)
+				}
+			}
+			estimates = append(estimates, est)
+		}
+		added := estimates[start:]
+		sort.Slice(added, func(i, j int) bool {
+			if added[i].Transport != added[j].Transport {
+				return added[i].Transport < added[j].Transport
+			}
+			return added[i].Addr < added[j].Addr
+		})
+	}
+	return estimates
+}
+
+// Ranker returns a dial ranker that orders addresses with the policy and
+// starts one dial per interval. A nil TransportPerf ranks like the
+// staggered strategy.
+func (t *TransportPerf) Ranker(interval time.Duration) network.DialRanker {
+	if t == nil {
+		return staggeredDialRanker(interval)
+	}
+	return func(addrs []multiaddr.Multiaddr) []network.AddrDelay {
+		t.mu.Lock()
+		policy := t.policy
+		t.mu.Unlock()
+
+		ranked := policy(addrs, t)
+		delays := make([]network.AddrDelay, 0, len(ranked))
+		for i, addr := range ranked {
+			delays = append(delays, network.AddrDelay{
+				Addr:  addr,
+				Delay: time.Duration(i) * interval,
+			})
+		}
+		if len(ranked) > 0 {
+			transportPreferred.WithLabelValues(transportName(ranked[0])).Inc()
+			logrus.WithFields(logrus.Fields{
+				"first":     ranked[0],
+				"addresses": len(ranked),
+			}).Debug("Ranked dial addresses by measured performance")
+		}
+		return delays
+	}
+}
+
+// MeasuredTransportPolicy dials direct addresses before relayed ones, and
+// of each, the addresses with the lowest measured cost first. Addresses
+// nothing is known about follow in the staggered strategy's order.
+func MeasuredTransportPolicy(addrs []multiaddr.Multiaddr, perf *TransportPerf) []multiaddr.Multiaddr {
+	type candidate struct {
+		addr    multiaddr.Multiaddr
+		relayed bool
+		known   bool
+		cost    time.Duration
+	}
+	candidates := make([]candidate, 0, len(addrs))
+	for _, addr := range addrs {
+		est, known := perf.Estimate(addr)
+		candidates = append(candidates, candidate{
+			addr:    addr,
+			relayed: hasProtocol(addr, multiaddr.P_CIRCUIT),
+			known:   known,
+			cost:    est.Cost(),
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.relayed != b.relayed:
+			return !a.relayed
+		case a.known != b.known:
+			return a.known
+		case a.known:
+			return a.cost < b.cost
+		default:
+			return transportRank(a.addr) < transportRank(b.addr)
+		}
+	})
+
+	ranked := make([]multiaddr.Multiaddr, 0, len(candidates))
+	for _, c := range candidates {
+		ranked = append(ranked, c.addr)
+	}
+	return ranked
+}
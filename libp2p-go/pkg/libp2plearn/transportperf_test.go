@@ -0,0 +1,87 @@
+package libp2plearn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasuredTransportPolicy(t *testing.T) {
+	quic := multiaddr.StringCast("/ip4/10.0.0.1/udp/4001/quic-v1")
+	tcp := multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001")
+	ws := multiaddr.StringCast("/ip4/10.0.0.1/tcp/4002/ws")
+	relay := multiaddr.StringCast("/ip4/10.0.0.2/tcp/4001/p2p/12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK/p2p-circuit")
+	addrs := []multiaddr.Multiaddr{relay, ws, tcp, quic}
+
+	t.Run("UnmeasuredKeepsStaggeredOrder", func(t *testing.T) {
+		perf := NewTransportPerf()
+		assert.Equal(t, []multiaddr.Multiaddr{quic, tcp, ws, relay}, MeasuredTransportPolicy(addrs, perf))
+		assert.Equal(t, []multiaddr.Multiaddr{quic, tcp, ws, relay}, MeasuredTransportPolicy(addrs, nil))
+	})
+
+	t.Run("PrefersWhatPerformedBetter", func(t *testing.T) {
+		perf := NewTransportPerf()
+		perf.recordConnect(quic.String(), "quic", 400*time.Millisecond)
+		perf.recordConnect(ws.String(), "websocket", 50*time.Millisecond)
+		perf.recordConnect(relay.String(), "relay", time.Millisecond)
+
+		// Measured addresses first, relays last whatever they measured
+		assert.Equal(t, []multiaddr.Multiaddr{ws, quic, tcp, relay}, MeasuredTransportPolicy(addrs, perf))
+	})
+
+	t.Run("TransportStandsInForAddress", func(t *testing.T) {
+		perf := NewTransportPerf()
+		for i := 0; i < transportPerfMinSamples; i++ {
+			perf.recordConnect("/ip4/10.9.9.9/tcp/4001", "tcp", 20*time.Millisecond)
+		}
+		perf.recordConnect(quic.String(), "quic", 100*time.Millisecond)
+
+		est, ok := perf.Estimate(tcp)
+		require.True(t, ok)
+		assert.Equal(t, 0, est.Connects)
+		assert.Equal(t, Duration(20*time.Millisecond), est.Connect)
+		assert.Equal(t, []multiaddr.Multiaddr{tcp, quic, ws, relay}, MeasuredTransportPolicy(addrs, perf))
+	})
+}
+
+func TestTransportPerf(t *testing.T) {
+	perf := NewTransportPerf()
+	tcp := multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001")
+
+	_, ok := perf.Estimate(tcp)
+	assert.False(t, ok)
+
+	perf.recordConnect(tcp.String(), "tcp", 100*time.Millisecond)
+	perf.recordConnect(tcp.String(), "tcp", 200*time.Millisecond)
+	est, ok := perf.Estimate(tcp)
+	require.True(t, ok)
+	assert.Equal(t, 2, est.Connects)
+	assert.Equal(t, Duration(120*time.Millisecond), est.Connect)
+
+	snapshot := perf.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, TransportEstimate{Transport: "tcp", Connects: 2, Connect: Duration(120 * time.Millisecond)}, snapshot[0])
+	assert.Equal(t, tcp.String(), snapshot[1].Addr)
+	assert.Equal(t, "tcp", snapshot[1].Transport)
+
+	t.Run("SetPolicy", func(t *testing.T) {
+		perf.SetPolicy(func(addrs []multiaddr.Multiaddr, _ *TransportPerf) []multiaddr.Multiaddr {
+			return addrs[len(addrs)-1:]
+		})
+		quic := multiaddr.StringCast("/ip4/10.0.0.1/udp/4001/quic-v1")
+		delays := perf.Ranker(time.Second)([]multiaddr.Multiaddr{tcp, quic})
+		require.Len(t, delays, 1)
+		assert.Equal(t, quic, delays[0].Addr)
+	})
+
+	t.Run("NilRanksStaggered", func(t *testing.T) {
+		var none *TransportPerf
+		none.RecordRTT("peer", time.Millisecond)
+		delays := none.Ranker(time.Second)([]multiaddr.Multiaddr{tcp})
+		require.Len(t, delays, 1)
+		assert.Equal(t, tcp, delays[0].Addr)
+	})
+}
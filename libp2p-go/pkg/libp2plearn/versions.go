@@ -0,0 +1,173 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// protocolVersion is the major.minor.patch version ending a protocol ID
+type protocolVersion struct {
+	major, minor, patch int
+}
+
+// serves reports whether a handler of version v answers requests for req:
+// same major version, and at least the requested minor version
+func (v protocolVersion) serves(req protocolVersion) bool {
+	return v.major == req.major && v.minor >= req.minor
+}
+
+// newerThan orders versions
+func (v protocolVersion) newerThan(o protocolVersion) bool {
+	if v.major != o.major {
+		return v.major > o.major
+	}
+	if v.minor != o.minor {
+		return v.minor > o.minor
+	}
+	return v.patch > o.patch
+}
+
+// splitProtocolVersion splits an ID like /libp2p-learn/ping/1.0.0 into its
+// name and version
+func splitProtocolVersion(id protocol.ID) (string, protocolVersion, bool) {
+	i := strings.LastIndex(string(id), "/")
+	if i < 0 {
+		return "", protocolVersion{}, false
+	}
+	parts := strings.Split(string(id[i+1:]), ".")
+	if len(parts) != 3 {
+		return "", protocolVersion{}, false
+	}
+	var nums [3]int
+	for j, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return "", protocolVersion{}, false
+		}
+		nums[j] = n
+	}
+	return string(id[:i]), protocolVersion{nums[0], nums[1], nums[2]}, true
+}
+
+// SemverMatch returns a multistream match function under which a handler
+// for proto also answers requests for earlier minor versions of its major
+// version, e.g. a 1.2.0 handler answers 1.0.0 and 1.1.0 but not 2.0.0. The
+// handler finds the requested version in Stream.Protocol.
+func SemverMatch(proto protocol.ID) func(protocol.ID) bool {
+	name, version, ok := splitProtocolVersion(proto)
+	return func(req protocol.ID) bool {
+		if !ok {
+			return req == proto
+		}
+		reqName, reqVersion, reqOK := splitProtocolVersion(req)
+		return reqOK && reqName == name && version.serves(reqVersion)
+	}
+}
+
+// HandleVersion registers a handler for one version of a protocol, wrapped
+// in the middlewares. Several versions may be registered side by side, and
+// each also answers earlier minor versions of its major version. Versioned
+// streams opened to peers use the newest version both sides support.
+func (p *ProtocolHandler) HandleVersion(proto protocol.ID, handler network.StreamHandler) {
+	if name, _, ok := splitProtocolVersion(proto); ok {
+		p.versionsMu.Lock()
+		if p.versions == nil {
+			p.versions = make(map[string][]protocol.ID)
+		}
+		if !slices.Contains(p.versions[name], proto) {
+			p.versions[name] = append(p.versions[name], proto)
+			sortVersions(p.versions[name])
+		}
+		p.versionsMu.Unlock()
+	}
+	p.host.SetStreamHandlerMatch(proto, SemverMatch(proto), p.wrap(proto, handler))
+}
+
+// Versions returns the registered versions of the protocol proto is a
+// version of, newest first
+func (p *ProtocolHandler) Versions(proto protocol.ID) []protocol.ID {
+	name, _, ok := splitProtocolVersion(proto)
+	if !ok {
+		return []protocol.ID{proto}
+	}
+	p.versionsMu.RLock()
+	defer p.versionsMu.RUnlock()
+	if versions := p.versions[name]; len(versions) > 0 {
+		return append([]protocol.ID(nil), versions...)
+	}
+	return []protocol.ID{proto}
+}
+
+// NewVersionedStream opens a stream for the newest version of proto's
+// protocol both this node and the peer support. Stream.Protocol tells which
+// version was picked.
+func (p *ProtocolHandler) NewVersionedStream(ctx context.Context, peerID peer.ID, proto protocol.ID) (network.Stream, error) {
+	candidates := p.candidateVersions(peerID, proto)
+	if err := p.cache.check(peerID, candidates); err != nil {
+		return nil, err
+	}
+	s, err := p.streams.NewStream(ctx, peerID, candidates...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	return s, nil
+}
+
+// candidateVersions orders our versions of proto's protocol for a peer:
+// first, newest first, the ones a version the peer advertised in identify
+// answers, then the rest in case the peerstore is out of date. A peer we
+// know nothing about gets all of them, newest first, and multistream-select
+// settles on the first it supports.
+func (p *ProtocolHandler) candidateVersions(peerID peer.ID, proto protocol.ID) []protocol.ID {
+	ours := p.Versions(proto)
+	name, _, ok := splitProtocolVersion(proto)
+	if !ok {
+		return ours
+	}
+
+	var theirs []protocolVersion
+	advertised, _ := p.host.Peerstore().GetProtocols(peerID)
+	for _, id := range advertised {
+		if n, v, ok := splitProtocolVersion(id); ok && n == name {
+			theirs = append(theirs, v)
+		}
+	}
+	if len(theirs) == 0 {
+		return ours
+	}
+
+	var supported, rest []protocol.ID
+	for _, id := range ours {
+		_, v, _ := splitProtocolVersion(id)
+		answered := false
+		for _, t := range theirs {
+			if t.serves(v) {
+				answered = true
+				break
+			}
+		}
+		if answered {
+			supported = append(supported, id)
+		} else {
+			rest = append(rest, id)
+		}
+	}
+	return append(supported, rest...)
+}
+
+// sortVersions sorts versions of one protocol newest first
+func sortVersions(ids []protocol.ID) {
+	sort.Slice(ids, func(i, j int) bool {
+		_, vi, _ := splitProtocolVersion(ids[i])
+		_, vj, _ := splitProtocolVersion(ids[j])
+		return vi.newerThan(vj)
+	})
+}
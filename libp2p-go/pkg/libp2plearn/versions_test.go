@@ -0,0 +1,96 @@
+package libp2plearn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemverMatch(t *testing.T) {
+	match := SemverMatch("/test/greet/1.2.0")
+
+	assert.True(t, match("/test/greet/1.2.0"))
+	assert.True(t, match("/test/greet/1.0.0"), "older minor versions should match")
+	assert.True(t, match("/test/greet/1.2.7"), "patch versions should not matter")
+	assert.False(t, match("/test/greet/1.3.0"), "newer minor versions should not match")
+	assert.False(t, match("/test/greet/2.0.0"), "other major versions should not match")
+	assert.False(t, match("/test/other/1.0.0"))
+	assert.False(t, match("/test/greet/latest"))
+
+	unversioned := SemverMatch("/test/greet")
+	assert.True(t, unversioned("/test/greet"))
+	assert.False(t, unversioned("/test/greet/1.0.0"))
+}
+
+func TestProtocolVersions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// greeter registers versions of /test/greet and answers with the version it served
+	greeter := func(versions ...protocol.ID) (host.Host, *ProtocolHandler) {
+		h, err := createNodeWithOptions(ctx, 0, false, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+
+		handler := NewProtocolHandler(h)
+		for _, v := range versions {
+			handler.HandleVersion(v, func(s network.Stream) {
+				defer s.Close()
+				s.Write([]byte(s.Protocol()))
+			})
+		}
+		return h, handler
+	}
+	greet := func(from *ProtocolHandler, to host.Host) (string, error) {
+		s, err := from.NewVersionedStream(ctx, to.ID(), "/test/greet/1.0.0")
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+		s.CloseWrite()
+		reply, err := io.ReadAll(s)
+		return string(reply), err
+	}
+
+	newHost, newHandler := greeter("/test/greet/1.0.0", "/test/greet/2.0.0")
+	oldHost, oldHandler := greeter("/test/greet/1.1.0")
+	nextHost, _ := greeter("/test/greet/2.0.0")
+	require.NoError(t, connectNodes(ctx, newHost, oldHost))
+	require.NoError(t, connectNodes(ctx, newHost, nextHost))
+
+	assert.Equal(t, []protocol.ID{"/test/greet/2.0.0", "/test/greet/1.0.0"}, newHandler.Versions("/test/greet/1.0.0"))
+
+	t.Run("NewestCommonVersion", func(t *testing.T) {
+		reply, err := greet(newHandler, nextHost)
+		require.NoError(t, err)
+		assert.Equal(t, "/test/greet/2.0.0", reply)
+	})
+
+	t.Run("OlderPeer", func(t *testing.T) {
+		reply, err := greet(newHandler, oldHost)
+		require.NoError(t, err)
+		assert.Equal(t, "/test/greet/1.0.0", reply, "a 1.1.0 handler should answer 1.0.0")
+	})
+
+	t.Run("PeerstoreOrdering", func(t *testing.T) {
+		err := WaitWithCondition(ctx, func() bool {
+			protos, _ := newHost.Peerstore().GetProtocols(oldHost.ID())
+			return len(protos) > 0
+		}, 5*time.Second, 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, []protocol.ID{"/test/greet/1.0.0", "/test/greet/2.0.0"}, newHandler.candidateVersions(oldHost.ID(), "/test/greet/1.0.0"))
+	})
+
+	t.Run("NoCommonVersion", func(t *testing.T) {
+		require.NoError(t, connectNodes(ctx, oldHost, nextHost))
+		_, err := greet(oldHandler, nextHost)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,192 @@
+package libp2plearn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WASMHostModule is the import module of the host API offered to WASM
+	// handlers
+	WASMHostModule = "libp2p_learn"
+
+	// defaultWASMTimeout bounds one run of a handler unless configured
+	defaultWASMTimeout = time.Second
+
+	// defaultWASMMaxRequestSize bounds a request unless configured
+	defaultWASMMaxRequestSize = 64 * 1024
+
+	// maxWASMResponseSize bounds what a handler may write
+	maxWASMResponseSize = 1 << 20
+
+	// wasmMemoryLimitPages caps a handler's memory at 16 MiB in 64 KiB pages
+	wasmMemoryLimitPages = 256
+)
+
+// Log levels a WASM handler passes to log
+const (
+	WASMLogDebug = 0
+	WASMLogInfo  = 1
+	WASMLogWarn  = 2
+	WASMLogError = 3
+)
+
+// errWASMResponseTooLarge is returned when a handler writes more than
+// maxWASMResponseSize
+var errWASMResponseTooLarge = errors.New("wasm response too large")
+
+// WASMHandlerConfig serves a protocol with a WASM module
+type WASMHandlerConfig struct {
+	Protocol       string   `json:"protocol"`
+	Module         string   `json:"module"`           // path to the .wasm file
+	Timeout        Duration `json:"timeout"`          // per request, 1s if zero
+	MaxRequestSize int64    `json:"max_request_size"` // bytes, 64 KiB if zero
+}
+
+// wasmModule is a compiled WASM module that can be run once per request
+type wasmModule interface {
+	// run instantiates the module and calls its handle export
+	run(ctx context.Context, call *wasmCall) error
+	Close() error
+}
+
+// wasmCall is the state of one request that the host API works on
+type wasmCall struct {
+	request  []byte
+	response []byte
+	log      *logrus.Entry
+}
+
+// readRequest copies up to size bytes of the request into a buffer
+func (c *wasmCall) readRequest(size uint32) []byte {
+	return c.request[:min(int(size), len(c.request))]
+}
+
+// writeResponse appends to the response
+func (c *wasmCall) writeResponse(data []byte) error {
+	if len(c.response)+len(data) > maxWASMResponseSize {
+		return errWASMResponseTooLarge
+	}
+	c.response = append(c.response, data...)
+	return nil
+}
+
+// logMessage logs a message from the handler at its level
+func (c *wasmCall) logMessage(level uint32, msg string) {
+	switch level {
+	case WASMLogDebug:
+		c.log.Debug(msg)
+	case WASMLogWarn:
+		c.log.Warn(msg)
+	case WASMLogError:
+		c.log.Error(msg)
+	default:
+		c.log.Info(msg)
+	}
+}
+
+// WASMHandler serves a protocol with a WASM module run in a sandbox. Each
+// request gets a fresh instance with bounded memory and time, which sees
+// nothing of the node but the host API: request_size, read_request,
+// write_response and log. A module that traps, runs out of memory or time
+// only resets its stream.
+type WASMHandler struct {
+	protocol       string
+	module         wasmModule
+	timeout        time.Duration
+	maxRequestSize int64
+}
+
+// NewWASMHandler compiles the module of a handler
+func NewWASMHandler(cfg WASMHandlerConfig) (*WASMHandler, error) {
+	code, err := os.ReadFile(cfg.Module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm module: %w", err)
+	}
+	module, err := compileWASM(code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile wasm module %s: %w", cfg.Module, err)
+	}
+
+	h := &WASMHandler{
+		protocol:       cfg.Protocol,
+		module:         module,
+		timeout:        time.Duration(cfg.Timeout),
+		maxRequestSize: cfg.MaxRequestSize,
+	}
+	if h.timeout <= 0 {
+		h.timeout = defaultWASMTimeout
+	}
+	if h.maxRequestSize <= 0 {
+		h.maxRequestSize = defaultWASMMaxRequestSize
+	}
+	logrus.WithFields(logrus.Fields{
+		"protocol": cfg.Protocol,
+		"module":   cfg.Module,
+	}).Info("Loaded WASM handler")
+	return h, nil
+}
+
+// Handle reads the request until the peer closes its side of the stream,
+// runs the module on it and writes back the response
+func (h *WASMHandler) Handle(s network.Stream) {
+	log := logrus.WithFields(logrus.Fields{
+		"protocol": h.protocol,
+		"peer":     s.Conn().RemotePeer(),
+	})
+
+	request, err := io.ReadAll(io.LimitReader(s, h.maxRequestSize+1))
+	if err != nil {
+		log.WithError(err).Debug("Failed to read WASM request")
+		s.Reset()
+		return
+	}
+	if int64(len(request)) > h.maxRequestSize {
+		log.Warn("WASM request too large")
+		s.Reset()
+		return
+	}
+
+	response, err := h.Run(context.Background(), request)
+	if err != nil {
+		log.WithError(err).Warn("WASM handler failed")
+		s.Reset()
+		return
+	}
+	if _, err := s.Write(response); err != nil {
+		log.WithError(err).Debug("Failed to write WASM response")
+		s.Reset()
+		return
+	}
+	s.Close()
+}
+
+// Run runs the module on a request and returns its response
+func (h *WASMHandler) Run(ctx context.Context, request []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	call := &wasmCall{
+		request: request,
+		log:     logrus.WithField("wasm", h.protocol),
+	}
+	if err := h.module.run(ctx, call); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("wasm handler timed out after %s", h.timeout)
+		}
+		return nil, err
+	}
+	return call.response, nil
+}
+
+// Close releases the compiled module
+func (h *WASMHandler) Close() error {
+	return h.module.Close()
+}
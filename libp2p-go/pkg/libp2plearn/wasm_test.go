@@ -0,0 +1,38 @@
+package libp2plearn
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWASMHandlerConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WASMHandlers = []WASMHandlerConfig{{Protocol: "/wasm/echo/1.0.0", Module: "echo.wasm"}}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.WASMHandlers[0].Protocol = "wasm/echo"
+	assert.Error(t, cfg.Validate())
+
+	cfg.WASMHandlers[0] = WASMHandlerConfig{Protocol: "/wasm/echo/1.0.0"}
+	assert.Error(t, cfg.Validate(), "module is required")
+
+	cfg.WASMHandlers[0] = WASMHandlerConfig{Protocol: "/wasm/echo/1.0.0", Module: "echo.wasm", Timeout: -1}
+	assert.Error(t, cfg.Validate())
+
+	_, err := NewWASMHandler(WASMHandlerConfig{Protocol: "/wasm/echo/1.0.0", Module: filepath.Join(t.TempDir(), "missing.wasm")})
+	assert.Error(t, err)
+}
+
+func TestWASMCall(t *testing.T) {
+	call := &wasmCall{request: []byte("hello")}
+	assert.Equal(t, "hel", string(call.readRequest(3)))
+	assert.Equal(t, "hello", string(call.readRequest(100)))
+
+	require.NoError(t, call.writeResponse([]byte("ok")))
+	assert.ErrorIs(t, call.writeResponse([]byte(strings.Repeat("x", maxWASMResponseSize))), errWASMResponseTooLarge)
+	assert.Equal(t, "ok", string(call.response))
+}
@@ -0,0 +1,111 @@
+package libp2plearn
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmCallKey finds the wasmCall of a request in the context of host calls
+type wasmCallKey struct{}
+
+// wazeroModule runs a module in its own wazero runtime, which only offers
+// the host API: no WASI, so no files, clock, randomness or network
+type wazeroModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// compileWASM compiles a module and checks it exports handle
+func compileWASM(code []byte) (wasmModule, error) {
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(wasmMemoryLimitPages).
+		WithCloseOnContextDone(true))
+
+	_, err := runtime.NewHostModuleBuilder(WASMHostModule).
+		NewFunctionBuilder().WithFunc(wasmRequestSize).Export("request_size").
+		NewFunctionBuilder().WithFunc(wasmReadRequest).Export("read_request").
+		NewFunctionBuilder().WithFunc(wasmWriteResponse).Export("write_response").
+		NewFunctionBuilder().WithFunc(wasmLog).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to set up host API: %w", err)
+	}
+
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	if _, ok := compiled.ExportedFunctions()["handle"]; !ok {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("module does not export handle")
+	}
+	return &wazeroModule{runtime: runtime, compiled: compiled}, nil
+}
+
+// run instantiates the module afresh, so no state survives between
+// requests, and calls handle
+func (m *wazeroModule) run(ctx context.Context, call *wasmCall) error {
+	ctx = context.WithValue(ctx, wasmCallKey{}, call)
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return fmt.Errorf("failed to instantiate wasm module: %w", err)
+	}
+	defer mod.Close(ctx)
+
+	results, err := mod.ExportedFunction("handle").Call(ctx)
+	if err != nil {
+		return fmt.Errorf("wasm handler trapped: %w", err)
+	}
+	if len(results) > 0 && api.DecodeI32(results[0]) != 0 {
+		return fmt.Errorf("wasm handler returned %d", api.DecodeI32(results[0]))
+	}
+	return nil
+}
+
+// Close releases the runtime
+func (m *wazeroModule) Close() error {
+	return m.runtime.Close(context.Background())
+}
+
+// wasmRequestSize returns the size of the request in bytes
+func wasmRequestSize(ctx context.Context) uint32 {
+	return uint32(len(ctx.Value(wasmCallKey{}).(*wasmCall).request))
+}
+
+// wasmReadRequest copies up to size bytes of the request to ptr and
+// returns how many it copied, or -1 if the memory is out of range
+func wasmReadRequest(ctx context.Context, mod api.Module, ptr, size uint32) int32 {
+	data := ctx.Value(wasmCallKey{}).(*wasmCall).readRequest(size)
+	if !mod.Memory().Write(ptr, data) {
+		return -1
+	}
+	return int32(len(data))
+}
+
+// wasmWriteResponse appends size bytes at ptr to the response and returns
+// 0, or -1 if the memory is out of range or the response too large
+func wasmWriteResponse(ctx context.Context, mod api.Module, ptr, size uint32) int32 {
+	data, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		return -1
+	}
+	if err := ctx.Value(wasmCallKey{}).(*wasmCall).writeResponse(data); err != nil {
+		return -1
+	}
+	return 0
+}
+
+// wasmLog logs size bytes at ptr at a WASMLog level
+func wasmLog(ctx context.Context, mod api.Module, level, ptr, size uint32) {
+	data, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		return
+	}
+	ctx.Value(wasmCallKey{}).(*wasmCall).logMessage(level, string(data))
+}
@@ -0,0 +1,78 @@
+package libp2plearn
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Hand-assembled modules importing request_size, read_request and
+// write_response. wasmEcho's handle writes the request back; wasmLoop's
+// loops forever.
+const (
+	wasmEcho = "0061736d01000000010b026000017f60027f7f017f0257030c6c69627032705f6c6561726e0c726571756573745f73697a6500000c6c69627032705f6c6561726e0c726561645f7265717565737400010c6c69627032705f6c6561726e0e77726974655f726573706f6e73650001030201000503010001071302066d656d6f727902000668616e646c6500030a11010f00410041001000100110021a41000b"
+	wasmLoop = "0061736d01000000010b026000017f60027f7f017f0257030c6c69627032705f6c6561726e0c726571756573745f73697a6500000c6c69627032705f6c6561726e0c726561645f7265717565737400010c6c69627032705f6c6561726e0e77726974655f726573706f6e73650001030201000503010001071302066d656d6f727902000668616e646c6500030a0b01090003400c000b41000b"
+)
+
+// writeWASM writes a hex-encoded module to a file
+func writeWASM(t *testing.T, module string) string {
+	code, err := hex.DecodeString(module)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "handler.wasm")
+	require.NoError(t, os.WriteFile(path, code, 0o644))
+	return path
+}
+
+func TestWASMHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const proto = "/libp2p-learn/wasm-echo/1.0.0"
+	handler, err := NewWASMHandler(WASMHandlerConfig{Protocol: proto, Module: writeWASM(t, wasmEcho)})
+	require.NoError(t, err)
+	defer handler.Close()
+
+	response, err := handler.Run(ctx, []byte("hello wasm"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello wasm", string(response))
+
+	h1, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h1.Close()
+	h2, err := createNodeWithOptions(ctx, 0, false, false)
+	require.NoError(t, err)
+	defer h2.Close()
+	require.NoError(t, connectNodes(ctx, h1, h2))
+	h1.SetStreamHandler(proto, handler.Handle)
+
+	s, err := h2.NewStream(ctx, h1.ID(), proto)
+	require.NoError(t, err)
+	_, err = s.Write([]byte("over a stream"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	reply, err := io.ReadAll(s)
+	require.NoError(t, err)
+	assert.Equal(t, "over a stream", string(reply))
+}
+
+func TestWASMHandlerTimeout(t *testing.T) {
+	handler, err := NewWASMHandler(WASMHandlerConfig{
+		Protocol: "/libp2p-learn/wasm-loop/1.0.0",
+		Module:   writeWASM(t, wasmLoop),
+		Timeout:  Duration(100 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	defer handler.Close()
+
+	start := time.Now()
+	_, err = handler.Run(context.Background(), []byte("spin"))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newRoomCommand manages private chat rooms
+func newRoomCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "room",
+		Short: "Private chat rooms that only invited peers can join",
+		Long: `Private chat rooms that only invited peers can join.
+
+A room's member list is a chain of records, each signed by the owner or an
+admin, that every member checks. The owner names admins, and the owner and
+admins invite and remove members. An invited peer joins with its invite
+token and is admitted the next time it connects to the owner or an admin.
+Chat in a room with "chat --room".`,
+	}
+	cmd.PersistentFlags().String("rooms", libp2plearn.DefaultRoomsFile, "Rooms file")
+	cmd.PersistentFlags().StringP("identity", "k", "", "Private key file of this node, which signs its changes to rooms")
+	cmd.MarkPersistentFlagRequired("identity")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "create <room>",
+		Short: "Create a room owned by this node",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRoomCreate,
+	})
+	invite := &cobra.Command{
+		Use:   "invite <room> <peer>",
+		Short: "Print a token that lets a peer into a room",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runRoomInvite,
+	}
+	invite.Flags().Bool("admin", false, "Invite the peer as an admin, which only the owner can do")
+	invite.Flags().Duration("ttl", 7*24*time.Hour, "How long the invitation can be used")
+	cmd.AddCommand(invite)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "join <token>",
+		Short: "Join a room with an invite token",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRoomJoin,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls",
+		Short: "List the rooms this node is in",
+		Args:  cobra.NoArgs,
+		RunE:  runRoomLs,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "members <room>",
+		Short: "List the members of a room and their roles",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRoomMembers,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <room> <peer>",
+		Short: "Take a peer out of a room",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runRoomRemove,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "role <room> <peer> <admin|member>",
+		Short: "Make a member an admin or a plain member",
+		Args:  cobra.ExactArgs(3),
+		RunE:  runRoomRole,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "leave <room>",
+		Short: "Forget a room",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRoomLeave,
+	})
+	return cmd
+}
+
+func runRoomCreate(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	m, err := rooms.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := rooms.Save(); err != nil {
+		return err
+	}
+	return printOutput(cmd, m, func() {
+		fmt.Printf("Created room %s\n", m.Room)
+	})
+}
+
+// roomInvite is the result of room invite
+type roomInvite struct {
+	Room    string               `json:"room"`
+	Invitee string               `json:"invitee"`
+	Role    libp2plearn.RoomRole `json:"role"`
+	Token   string               `json:"token"`
+}
+
+func runRoomInvite(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	invitee, err := contacts.ResolveID(args[1])
+	if err != nil {
+		return err
+	}
+	role := libp2plearn.RoleMember
+	if admin, _ := cmd.Flags().GetBool("admin"); admin {
+		role = libp2plearn.RoleAdmin
+	}
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	token, err := rooms.Invite(args[0], invitee, role, ttl)
+	if err != nil {
+		return err
+	}
+	result := roomInvite{Room: args[0], Invitee: invitee.String(), Role: role, Token: token}
+	return printOutput(cmd, result, func() {
+		fmt.Printf("Room invite for %s:\n\n  %s\n\n", contacts.Label(invitee), token)
+		fmt.Printf("On the invited machine, run:\n\n  libp2p-node room join -k <identity> %s\n", token)
+	})
+}
+
+func runRoomJoin(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	m, err := rooms.Join(args[0])
+	if err != nil {
+		return err
+	}
+	if err := rooms.Save(); err != nil {
+		return err
+	}
+	return printOutput(cmd, m, func() {
+		fmt.Printf("Joined room %s; the owner or an admin admits this node when it next connects to them\n", m.Room)
+	})
+}
+
+// roomSummary is one room in room ls
+type roomSummary struct {
+	Room    string               `json:"room"`
+	Owner   string               `json:"owner"`
+	Role    libp2plearn.RoomRole `json:"role,omitempty"`
+	Pending bool                 `json:"pending,omitempty"`
+	Members int                  `json:"members"`
+}
+
+func runRoomLs(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	self, err := roomsIdentity(cmd)
+	if err != nil {
+		return err
+	}
+	var list []roomSummary
+	for _, m := range rooms.Rooms() {
+		list = append(list, roomSummary{
+			Room:    m.Room,
+			Owner:   m.Owner.String(),
+			Role:    m.RoleOf(self),
+			Pending: rooms.Pending(m.Room),
+			Members: len(m.Members),
+		})
+	}
+	return printOutput(cmd, list, func() {
+		for _, r := range list {
+			role := string(r.Role)
+			if r.Pending {
+				role = "invited"
+			}
+			fmt.Printf("%s\t%s\t%d members\towner %s\n", r.Room, role, r.Members, contactLabel(contacts, r.Owner))
+		}
+		fmt.Printf("%d rooms\n", len(list))
+	})
+}
+
+func runRoomMembers(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	m, ok := rooms.Membership(args[0])
+	if !ok {
+		return fmt.Errorf("no room named %s", args[0])
+	}
+	return printRoom(cmd, m)
+}
+
+func runRoomRemove(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	p, err := contacts.ResolveID(args[1])
+	if err != nil {
+		return err
+	}
+	m, err := rooms.Remove(args[0], p)
+	if err != nil {
+		return err
+	}
+	if err := rooms.Save(); err != nil {
+		return err
+	}
+	return printRoom(cmd, m)
+}
+
+func runRoomRole(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	p, err := contacts.ResolveID(args[1])
+	if err != nil {
+		return err
+	}
+	m, err := rooms.SetRole(args[0], p, libp2plearn.RoomRole(args[2]))
+	if err != nil {
+		return err
+	}
+	if err := rooms.Save(); err != nil {
+		return err
+	}
+	return printRoom(cmd, m)
+}
+
+func runRoomLeave(cmd *cobra.Command, args []string) error {
+	rooms, err := loadRooms(cmd)
+	if err != nil {
+		return err
+	}
+	if !rooms.Leave(args[0]) {
+		return fmt.Errorf("no room named %s", args[0])
+	}
+	return rooms.Save()
+}
+
+// printRoom prints the members of a room
+func printRoom(cmd *cobra.Command, m libp2plearn.RoomMembership) error {
+	contacts, err := loadContacts(cmd)
+	if err != nil {
+		return err
+	}
+	return printOutput(cmd, m, func() {
+		for _, member := range m.Members {
+			fmt.Printf("%s\t%s\n", member.Role, contacts.Label(member.ID))
+		}
+		fmt.Printf("%d members in room %s, version %d\n", len(m.Members), m.Room, m.Seq)
+	})
+}
+
+// loadRooms loads the rooms file named by --rooms, signing changes with the
+// key named by --identity
+func loadRooms(cmd *cobra.Command) (*libp2plearn.RoomBook, error) {
+	path, _ := cmd.Flags().GetString("rooms")
+	identityFile, _ := cmd.Flags().GetString("identity")
+	key, err := libp2plearn.LoadIdentity(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	return libp2plearn.LoadRoomBook(path, key)
+}
+
+// roomsIdentity returns the peer ID of the key named by --identity
+func roomsIdentity(cmd *cobra.Command) (peer.ID, error) {
+	identityFile, _ := cmd.Flags().GetString("identity")
+	key, err := libp2plearn.LoadIdentity(identityFile)
+	if err != nil {
+		return "", err
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	return id, nil
+}
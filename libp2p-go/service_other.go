@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errNoService = errors.New("windows services are only supported on windows; use systemd elsewhere")
+
+func initService() {}
+
+func installService(args []string) error {
+	return errNoService
+}
+
+func uninstallService() error {
+	return errNoService
+}
+
+func startService() error {
+	return errNoService
+}
+
+func stopService() error {
+	return errNoService
+}
+
+// runUntilStopped waits for a signal and then calls stop
+func runUntilStopped(stop func()) {
+	waitForSignal()
+	stop()
+}
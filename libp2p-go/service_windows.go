@@ -0,0 +1,157 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	// serviceName is the name the node is installed under
+	serviceName = "libp2p-node"
+
+	// serviceStopTimeout bounds how long service stop waits for the node to stop
+	serviceStopTimeout = 30 * time.Second
+)
+
+// initService makes paths in the configuration relative to the executable
+// when running as a service, since services start in the system directory
+func initService() {
+	if isService, _ := svc.IsWindowsService(); !isService {
+		return
+	}
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+}
+
+// installService registers the executable as a service that starts with
+// the system, is restarted if it fails, and runs the node with args
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "libp2p node",
+		Description: "libp2p node with TCP/UDP/WebSocket support and hole punching",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to install service: %w", err)
+	}
+	defer s.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: 5 * time.Second}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		return fmt.Errorf("failed to set service recovery: %w", err)
+	}
+	return nil
+}
+
+// uninstallService removes the service
+func uninstallService() error {
+	return withService(func(s *mgr.Service) error {
+		if err := s.Delete(); err != nil {
+			return fmt.Errorf("failed to uninstall service: %w", err)
+		}
+		return nil
+	})
+}
+
+// startService starts the installed service
+func startService() error {
+	return withService(func(s *mgr.Service) error {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("failed to start service: %w", err)
+		}
+		return nil
+	})
+}
+
+// stopService stops the service and waits until it has stopped
+func stopService() error {
+	return withService(func(s *mgr.Service) error {
+		status, err := s.Control(svc.Stop)
+		if err != nil {
+			return fmt.Errorf("failed to stop service: %w", err)
+		}
+		deadline := time.Now().Add(serviceStopTimeout)
+		for status.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("service did not stop within %s", serviceStopTimeout)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				return fmt.Errorf("failed to query service: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// withService runs fn on the installed service
+func withService(fn func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// runUntilStopped waits for the service manager to stop the service, or
+// for a signal when not running as one, and then calls stop
+func runUntilStopped(stop func()) {
+	if isService, _ := svc.IsWindowsService(); !isService {
+		waitForSignal()
+		stop()
+		return
+	}
+	if err := svc.Run(serviceName, &nodeService{stop: stop}); err != nil {
+		log.Printf("Service failed: %v", err)
+		stop()
+	}
+}
+
+// nodeService answers the service manager while the node runs
+type nodeService struct {
+	stop func()
+}
+
+func (s *nodeService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			s.stop()
+			return false, 0
+		}
+	}
+	return false, 0
+}
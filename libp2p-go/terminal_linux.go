@@ -0,0 +1,68 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// isTerminal reports whether fd is a terminal
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+// makeRaw puts the terminal into raw mode so keystrokes reach the remote
+// shell unprocessed, and returns a function that restores it
+func makeRaw(fd int) (func(), error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	old := *termios
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, &old) }, nil
+}
+
+// terminalSize returns the size of the terminal
+func terminalSize(fd int) (libp2plearn.WindowSize, error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return libp2plearn.WindowSize{}, err
+	}
+	return libp2plearn.WindowSize{Rows: ws.Row, Cols: ws.Col}, nil
+}
+
+// watchResize delivers the terminal size every time it changes
+func watchResize(fd int) <-chan libp2plearn.WindowSize {
+	sizes := make(chan libp2plearn.WindowSize, 1)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	go func() {
+		for range sigs {
+			if size, err := terminalSize(fd); err == nil {
+				select {
+				case sizes <- size:
+				default:
+				}
+			}
+		}
+	}()
+	return sizes
+}
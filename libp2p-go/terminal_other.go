@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+var errNoTerminal = errors.New("interactive terminals are only supported on linux")
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(fd int) (libp2plearn.WindowSize, error) {
+	return libp2plearn.WindowSize{}, errNoTerminal
+}
+
+func watchResize(fd int) <-chan libp2plearn.WindowSize {
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"libp2p-learn/pkg/libp2plearn"
+)
+
+// newSendDirCommand sends a directory to a node that accepts transfers from us
+func newSendDirCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "send-dir <peer> <dir>",
+		Short: "Send a directory to a node running recv-dir or with transfer_peers set",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runSendDir,
+	}
+	cmd.Flags().StringP("identity", "k", "", "Private key file of an identity the receiver accepts")
+	cmd.Flags().Duration("timeout", 30*time.Second, "Timeout for connecting to the node")
+	return cmd
+}
+
+func runSendDir(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, target, err := connectAsOperator(ctx, cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer node.Stop(context.Background())
+
+	reply, err := libp2plearn.SendDir(ctx, node.Host(), target, args[1], transferProgressPrinter())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sent %d files (%d bytes)\n", reply.Files, reply.Bytes)
+	return nil
+}
+
+// newRecvDirCommand runs a node that receives directories into a local directory
+func newRecvDirCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recv-dir <dir>",
+		Short: "Receive directories sent with send-dir into a local directory",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRecvDir,
+	}
+	cmd.Flags().StringArrayP("from", "f", nil, "Peer ID allowed to send directories")
+	cmd.Flags().StringP("identity", "k", "", "Private key file, so senders can reach the same peer ID")
+	cmd.Flags().StringP("config", "c", "", "Configuration file path")
+	cmd.MarkFlagRequired("from")
+	return cmd
+}
+
+func runRecvDir(cmd *cobra.Command, args []string) error {
+	configFile, _ := cmd.Flags().GetString("config")
+	config, err := libp2plearn.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	config.TransferDir = args[0]
+	config.TransferPeers, _ = cmd.Flags().GetStringArray("from")
+	if identityFile, _ := cmd.Flags().GetString("identity"); identityFile != "" {
+		config.IdentityFile = identityFile
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.SetupLogging(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := libp2plearn.New(libp2plearn.WithConfig(config))
+	if err != nil {
+		return err
+	}
+	node.Transfer().SetProgressHandler(transferProgressPrinter())
+	if err := node.Start(ctx); err != nil {
+		node.Stop(context.Background())
+		return err
+	}
+
+	fmt.Printf("Peer ID: %s\n", node.Host().ID())
+	for _, addr := range node.Host().Addrs() {
+		fmt.Printf("  %s/p2p/%s\n", addr, node.Host().ID())
+	}
+	fmt.Printf("Receiving directories into %s\n", config.TransferDir)
+	fmt.Println("Press Ctrl+C to stop...")
+	waitForSignal()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return node.Stop(shutdownCtx)
+}
+
+// transferProgressPrinter returns a progress handler that rewrites one line
+// with the progress at most every 100ms, and always at the last byte
+func transferProgressPrinter() libp2plearn.TransferProgressFunc {
+	var (
+		mu   sync.Mutex
+		last time.Time
+	)
+	return func(p libp2plearn.TransferProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		done := p.Files == p.TotalFiles && p.Bytes == p.TotalBytes
+		if !done && time.Since(last) < 100*time.Millisecond {
+			return
+		}
+		last = time.Now()
+		fmt.Printf("\r%s: %d/%d files, %d/%d bytes", p.Name, p.Files, p.TotalFiles, p.Bytes, p.TotalBytes)
+		if done {
+			fmt.Println()
+		}
+	}
+}